@@ -2,15 +2,39 @@ package ast
 
 import (
 	"bytes"
-	"xon/token"
 	"strings"
+	"xon/token"
 )
 
 type Node interface {
 	TokenLiteral() string
 	String() string
+	Range() Span
+	SetRange(Span)
 }
 
+// Pos is a source position: a 1-based line and a 1-based column counted
+// in characters.
+type Pos struct {
+	Line int
+	Col  int
+}
+
+// Span is the source range a node was parsed from; End is just past its
+// last character. Every node embeds one. Nodes built by hand have a zero
+// Span.
+type Span struct {
+	Start Pos
+	End   Pos
+}
+
+// Range returns the node's span.
+func (s *Span) Range() Span { return *s }
+
+// SetRange replaces the node's span. The parser sets it as it finishes
+// each node.
+func (s *Span) SetRange(r Span) { *s = r }
+
 type Statement interface {
 	Node
 	statementNode()
@@ -22,7 +46,14 @@ type Expression interface {
 }
 
 type Program struct {
+	Span
 	Statements []Statement
+	// Comments holds the source comments in order when the lexer was made
+	// with lexer.NewWithComments; it is empty otherwise.
+	Comments []token.Token
+	// Sources maps the program's lines to the files they came from when
+	// the lexer was made with lexer.NewChunks; it is nil otherwise.
+	Sources token.SourceMap
 }
 
 func (p *Program) TokenLiteral() string {
@@ -44,8 +75,11 @@ func (p *Program) String() string {
 // Statements
 
 type SetStatement struct {
+	Span
 	Token   token.Token
+	Doc     string // from /// comments above the statement
 	IsConst bool
+	IsDeep  bool // `set const deep`: the value's arrays and hashes are frozen too
 	Name    *Identifier
 	Value   Expression
 }
@@ -56,7 +90,38 @@ func (ss *SetStatement) String() string {
 	return "set " + ss.Name.String() + " = " + ss.Value.String() + ";"
 }
 
+// DestructureStatement is `set [a, b] = pair` or `set {name, age: years}
+// = person`: each name is bound to the element at its position, or to the
+// value under Keys[i] for a hash pattern.
+type DestructureStatement struct {
+	Span
+	Token   token.Token
+	IsConst bool
+	IsDeep  bool
+	IsHash  bool
+	Names   []*Identifier
+	Keys    []string // hash patterns only; Keys[i] is the key Names[i] takes
+	Value   Expression
+}
+
+func (ds *DestructureStatement) statementNode()       {}
+func (ds *DestructureStatement) TokenLiteral() string { return ds.Token.Literal }
+func (ds *DestructureStatement) String() string {
+	parts := make([]string, len(ds.Names))
+	for i, name := range ds.Names {
+		parts[i] = name.String()
+		if ds.IsHash && ds.Keys[i] != name.Value {
+			parts[i] = ds.Keys[i] + ": " + name.String()
+		}
+	}
+	if ds.IsHash {
+		return "set {" + strings.Join(parts, ", ") + "} = " + ds.Value.String() + ";"
+	}
+	return "set [" + strings.Join(parts, ", ") + "] = " + ds.Value.String() + ";"
+}
+
 type AssignStatement struct {
+	Span
 	Token token.Token
 	Name  *Identifier
 	Value Expression
@@ -66,7 +131,23 @@ func (as *AssignStatement) statementNode()       {}
 func (as *AssignStatement) TokenLiteral() string { return as.Token.Literal }
 func (as *AssignStatement) String() string       { return as.Name.String() + " = " + as.Value.String() + ";" }
 
+// IndexAssignStatement stores into a container in place: Target is the
+// *IndexExpression of `arr[0] = v` or the *MemberExpression of `obj.f = v`.
+type IndexAssignStatement struct {
+	Span
+	Token  token.Token
+	Target Expression
+	Value  Expression
+}
+
+func (is *IndexAssignStatement) statementNode()       {}
+func (is *IndexAssignStatement) TokenLiteral() string { return is.Token.Literal }
+func (is *IndexAssignStatement) String() string {
+	return is.Target.String() + " = " + is.Value.String() + ";"
+}
+
 type OutStatement struct {
+	Span
 	Token token.Token
 	Value Expression
 }
@@ -76,6 +157,7 @@ func (os *OutStatement) TokenLiteral() string { return os.Token.Literal }
 func (os *OutStatement) String() string       { return "out " + os.Value.String() + ";" }
 
 type ReturnStatement struct {
+	Span
 	Token token.Token
 	Value Expression
 }
@@ -85,6 +167,7 @@ func (rs *ReturnStatement) TokenLiteral() string { return rs.Token.Literal }
 func (rs *ReturnStatement) String() string       { return "return " + rs.Value.String() + ";" }
 
 type ExpressionStatement struct {
+	Span
 	Token      token.Token
 	Expression Expression
 }
@@ -94,6 +177,7 @@ func (es *ExpressionStatement) TokenLiteral() string { return es.Token.Literal }
 func (es *ExpressionStatement) String() string       { return es.Expression.String() }
 
 type BlockStatement struct {
+	Span
 	Token      token.Token
 	Statements []Statement
 }
@@ -109,6 +193,7 @@ func (bs *BlockStatement) String() string {
 }
 
 type ImportStatement struct {
+	Span
 	Token token.Token
 	Path  Expression
 	Alias *Identifier
@@ -118,7 +203,20 @@ func (is *ImportStatement) statementNode()       {}
 func (is *ImportStatement) TokenLiteral() string { return is.Token.Literal }
 func (is *ImportStatement) String() string       { return "import " + is.Path.String() }
 
+// RequiresStatement is a `requires "1.2";` directive: the script needs at
+// least that runtime version.
+type RequiresStatement struct {
+	Span
+	Token   token.Token
+	Version string
+}
+
+func (rs *RequiresStatement) statementNode()       {}
+func (rs *RequiresStatement) TokenLiteral() string { return rs.Token.Literal }
+func (rs *RequiresStatement) String() string       { return "requires \"" + rs.Version + "\"" }
+
 type SpawnStatement struct {
+	Span
 	Token token.Token
 	Call  *CallExpression
 }
@@ -127,7 +225,55 @@ func (ss *SpawnStatement) statementNode()       {}
 func (ss *SpawnStatement) TokenLiteral() string { return ss.Token.Literal }
 func (ss *SpawnStatement) String() string       { return "spawn " + ss.Call.String() }
 
+// GroupStatement is `group { ... }`: the block runs, then waits for every
+// task spawned inside it.
+type GroupStatement struct {
+	Span
+	Token token.Token
+	Body  *BlockStatement
+}
+
+func (gs *GroupStatement) statementNode()       {}
+func (gs *GroupStatement) TokenLiteral() string { return gs.Token.Literal }
+func (gs *GroupStatement) String() string       { return "group " + gs.Body.String() }
+
+// ClassStatement is `class Name { field = default; fn method(params) {
+// ... } }`. Calling the class makes an instance, a hash holding the fields,
+// whose methods see it as self; a method named init takes the call's
+// arguments and runs after the field defaults.
+type ClassStatement struct {
+	Span
+	Token   token.Token
+	Doc     string // from /// comments above the class
+	Name    *Identifier
+	Fields  []*ClassField
+	Methods []*FunctionLiteral // Name is the method name
+}
+
+func (cs *ClassStatement) statementNode()       {}
+func (cs *ClassStatement) TokenLiteral() string { return cs.Token.Literal }
+func (cs *ClassStatement) String() string       { return "class " + cs.Name.String() }
+
+// Init returns the class's init method, or nil.
+func (cs *ClassStatement) Init() *FunctionLiteral {
+	for _, m := range cs.Methods {
+		if m.Name == "init" {
+			return m
+		}
+	}
+	return nil
+}
+
+// ClassField is a field of a class and its default, which is nil for
+// `name;` and then null.
+type ClassField struct {
+	Span
+	Name  *Identifier
+	Value Expression
+}
+
 type ForStatement struct {
+	Span
 	Token     token.Token
 	Init      Statement
 	Condition Expression
@@ -140,6 +286,7 @@ func (fs *ForStatement) TokenLiteral() string { return fs.Token.Literal }
 func (fs *ForStatement) String() string       { return "for" }
 
 type ForInStatement struct {
+	Span
 	Token    token.Token
 	Variable *Identifier
 	Iterable Expression
@@ -151,22 +298,25 @@ func (fs *ForInStatement) TokenLiteral() string { return fs.Token.Literal }
 func (fs *ForInStatement) String() string       { return "for ... in" }
 
 type BreakStatement struct {
+	Span
 	Token token.Token
 }
 
 func (bs *BreakStatement) statementNode()       {}
 func (bs *BreakStatement) TokenLiteral() string { return bs.Token.Literal }
-func (bs *BreakStatement) String() string      { return "break" }
+func (bs *BreakStatement) String() string       { return "break" }
 
 type ContinueStatement struct {
+	Span
 	Token token.Token
 }
 
 func (cs *ContinueStatement) statementNode()       {}
 func (cs *ContinueStatement) TokenLiteral() string { return cs.Token.Literal }
-func (cs *ContinueStatement) String() string      { return "continue" }
+func (cs *ContinueStatement) String() string       { return "continue" }
 
 type WhileStatement struct {
+	Span
 	Token     token.Token
 	Condition Expression
 	Body      *BlockStatement
@@ -176,7 +326,21 @@ func (ws *WhileStatement) statementNode()       {}
 func (ws *WhileStatement) TokenLiteral() string { return ws.Token.Literal }
 func (ws *WhileStatement) String() string       { return "while" }
 
+// DoWhileStatement is `do { ... } while cond;`, whose body runs once
+// before the condition is first checked.
+type DoWhileStatement struct {
+	Span
+	Token     token.Token
+	Body      *BlockStatement
+	Condition Expression
+}
+
+func (dw *DoWhileStatement) statementNode()       {}
+func (dw *DoWhileStatement) TokenLiteral() string { return dw.Token.Literal }
+func (dw *DoWhileStatement) String() string       { return "do while" }
+
 type IfStatement struct {
+	Span
 	Token       token.Token
 	Condition   Expression
 	Consequence *BlockStatement
@@ -188,6 +352,7 @@ func (is *IfStatement) TokenLiteral() string { return is.Token.Literal }
 func (is *IfStatement) String() string       { return "if" }
 
 type ThrowStatement struct {
+	Span
 	Token token.Token
 	Value Expression
 }
@@ -199,6 +364,7 @@ func (ts *ThrowStatement) String() string       { return "throw" }
 // Expressions
 
 type Identifier struct {
+	Span
 	Token token.Token
 	Value string
 }
@@ -208,6 +374,7 @@ func (i *Identifier) TokenLiteral() string { return i.Token.Literal }
 func (i *Identifier) String() string       { return i.Value }
 
 type IntegerLiteral struct {
+	Span
 	Token token.Token
 	Value int64
 }
@@ -217,6 +384,7 @@ func (il *IntegerLiteral) TokenLiteral() string { return il.Token.Literal }
 func (il *IntegerLiteral) String() string       { return il.Token.Literal }
 
 type FloatLiteral struct {
+	Span
 	Token token.Token
 	Value float64
 }
@@ -226,6 +394,7 @@ func (fl *FloatLiteral) TokenLiteral() string { return fl.Token.Literal }
 func (fl *FloatLiteral) String() string       { return fl.Token.Literal }
 
 type StringLiteral struct {
+	Span
 	Token token.Token
 	Value string
 }
@@ -235,6 +404,7 @@ func (sl *StringLiteral) TokenLiteral() string { return sl.Token.Literal }
 func (sl *StringLiteral) String() string       { return "\"" + sl.Value + "\"" }
 
 type InterpolatedString struct {
+	Span
 	Token token.Token
 	Parts []Expression
 }
@@ -244,6 +414,7 @@ func (is *InterpolatedString) TokenLiteral() string { return is.Token.Literal }
 func (is *InterpolatedString) String() string       { return "interpolated" }
 
 type Boolean struct {
+	Span
 	Token token.Token
 	Value bool
 }
@@ -252,7 +423,29 @@ func (b *Boolean) expressionNode()      {}
 func (b *Boolean) TokenLiteral() string { return b.Token.Literal }
 func (b *Boolean) String() string       { return b.Token.Literal }
 
+type NullLiteral struct {
+	Span
+	Token token.Token
+}
+
+func (n *NullLiteral) expressionNode()      {}
+func (n *NullLiteral) TokenLiteral() string { return n.Token.Literal }
+func (n *NullLiteral) String() string       { return "null" }
+
+// ErrorExpression takes the place of an expression that failed to parse.
+// The parser reports an error whenever it makes one, so it never reaches a
+// successful compile; tools walking a partial AST can skip it.
+type ErrorExpression struct {
+	Span
+	Token token.Token // where parsing failed
+}
+
+func (e *ErrorExpression) expressionNode()      {}
+func (e *ErrorExpression) TokenLiteral() string { return e.Token.Literal }
+func (e *ErrorExpression) String() string       { return "<error>" }
+
 type PrefixExpression struct {
+	Span
 	Token    token.Token
 	Operator string
 	Right    Expression
@@ -263,6 +456,7 @@ func (pe *PrefixExpression) TokenLiteral() string { return pe.Token.Literal }
 func (pe *PrefixExpression) String() string       { return "(" + pe.Operator + pe.Right.String() + ")" }
 
 type InfixExpression struct {
+	Span
 	Token    token.Token
 	Left     Expression
 	Operator string
@@ -276,6 +470,7 @@ func (ie *InfixExpression) String() string {
 }
 
 type PostfixExpression struct {
+	Span
 	Token    token.Token
 	Operator string
 	Left     Expression
@@ -286,7 +481,10 @@ func (pe *PostfixExpression) TokenLiteral() string { return pe.Token.Literal }
 func (pe *PostfixExpression) String() string       { return pe.Left.String() + pe.Operator }
 
 type FunctionLiteral struct {
+	Span
 	Token      token.Token
+	Name       string // set when the literal is bound with set/assign; used for self-recursion
+	Doc        string // doc comment of the set statement binding it
 	Parameters []*Identifier
 	Body       *BlockStatement
 }
@@ -308,6 +506,7 @@ func (fl *FunctionLiteral) String() string {
 }
 
 type CallExpression struct {
+	Span
 	Token     token.Token
 	Function  Expression
 	Arguments []Expression
@@ -329,6 +528,7 @@ func (ce *CallExpression) String() string {
 }
 
 type ArrayLiteral struct {
+	Span
 	Token    token.Token
 	Elements []Expression
 }
@@ -348,6 +548,7 @@ func (al *ArrayLiteral) String() string {
 }
 
 type IndexExpression struct {
+	Span
 	Token token.Token
 	Left  Expression
 	Index Expression
@@ -359,7 +560,34 @@ func (ie *IndexExpression) String() string {
 	return "(" + ie.Left.String() + "[" + ie.Index.String() + "])"
 }
 
+// SliceExpression is left[low:high]. Either bound may be nil, meaning the
+// start or the end.
+type SliceExpression struct {
+	Span
+	Token token.Token // the [ token
+	Left  Expression
+	Low   Expression
+	High  Expression
+}
+
+func (se *SliceExpression) expressionNode()      {}
+func (se *SliceExpression) TokenLiteral() string { return se.Token.Literal }
+func (se *SliceExpression) String() string {
+	var out bytes.Buffer
+	out.WriteString("(" + se.Left.String() + "[")
+	if se.Low != nil {
+		out.WriteString(se.Low.String())
+	}
+	out.WriteString(":")
+	if se.High != nil {
+		out.WriteString(se.High.String())
+	}
+	out.WriteString("])")
+	return out.String()
+}
+
 type HashLiteral struct {
+	Span
 	Token token.Token
 	Pairs map[Expression]Expression
 }
@@ -369,16 +597,24 @@ func (hl *HashLiteral) TokenLiteral() string { return hl.Token.Literal }
 func (hl *HashLiteral) String() string       { return "{...}" }
 
 type MemberExpression struct {
-	Token  token.Token
-	Object Expression
-	Member *Identifier
+	Span
+	Token    token.Token
+	Object   Expression
+	Member   *Identifier
+	Optional bool // obj?.member: null when Object is null
 }
 
 func (me *MemberExpression) expressionNode()      {}
 func (me *MemberExpression) TokenLiteral() string { return me.Token.Literal }
-func (me *MemberExpression) String() string       { return me.Object.String() + "." + me.Member.String() }
+func (me *MemberExpression) String() string {
+	if me.Optional {
+		return me.Object.String() + "?." + me.Member.String()
+	}
+	return me.Object.String() + "." + me.Member.String()
+}
 
 type PipeExpression struct {
+	Span
 	Token token.Token
 	Left  Expression
 	Right Expression
@@ -388,12 +624,17 @@ func (pe *PipeExpression) expressionNode()      {}
 func (pe *PipeExpression) TokenLiteral() string { return pe.Token.Literal }
 func (pe *PipeExpression) String() string       { return pe.Left.String() + " |> " + pe.Right.String() }
 
+// MatchCase is `pattern => body`, or `pattern if guard => body`, which is
+// taken only when the pattern matches and the guard is then truthy.
 type MatchCase struct {
+	Span
 	Pattern Expression
+	Guard   Expression // nil without an if
 	Body    *BlockStatement
 }
 
 type MatchExpression struct {
+	Span
 	Token token.Token
 	Value Expression
 	Cases []*MatchCase
@@ -404,6 +645,7 @@ func (me *MatchExpression) TokenLiteral() string { return me.Token.Literal }
 func (me *MatchExpression) String() string       { return "match" }
 
 type TryExpression struct {
+	Span
 	Token          token.Token
 	Block          *BlockStatement
 	CatchParameter *Identifier
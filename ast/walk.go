@@ -0,0 +1,365 @@
+package ast
+
+import "sort"
+
+// A Visitor's Visit method is called for each node Walk reaches. If it
+// returns a non-nil Visitor w, Walk visits the node's children with w and
+// then calls w.Visit(nil).
+type Visitor interface {
+	Visit(node Node) (w Visitor)
+}
+
+// Walk traverses the tree rooted at node depth-first, visiting children in
+// source order. Missing optional parts, such as an if without else, are
+// skipped.
+func Walk(v Visitor, node Node) {
+	if v = v.Visit(node); v == nil {
+		return
+	}
+
+	switch n := node.(type) {
+	case *Program:
+		walkStatements(v, n.Statements)
+	case *BlockStatement:
+		walkStatements(v, n.Statements)
+	case *SetStatement:
+		Walk(v, n.Name)
+		walkExpression(v, n.Value)
+	case *DestructureStatement:
+		for _, name := range n.Names {
+			Walk(v, name)
+		}
+		walkExpression(v, n.Value)
+	case *AssignStatement:
+		Walk(v, n.Name)
+		walkExpression(v, n.Value)
+	case *IndexAssignStatement:
+		walkExpression(v, n.Target)
+		walkExpression(v, n.Value)
+	case *OutStatement:
+		walkExpression(v, n.Value)
+	case *ReturnStatement:
+		walkExpression(v, n.Value)
+	case *ThrowStatement:
+		walkExpression(v, n.Value)
+	case *ExpressionStatement:
+		walkExpression(v, n.Expression)
+	case *ImportStatement:
+		walkExpression(v, n.Path)
+		if n.Alias != nil {
+			Walk(v, n.Alias)
+		}
+	case *SpawnStatement:
+		Walk(v, n.Call)
+	case *ClassStatement:
+		Walk(v, n.Name)
+		for _, f := range n.Fields {
+			Walk(v, f.Name)
+			walkExpression(v, f.Value)
+		}
+		for _, m := range n.Methods {
+			Walk(v, m)
+		}
+	case *GroupStatement:
+		Walk(v, n.Body)
+	case *ForStatement:
+		if n.Init != nil {
+			Walk(v, n.Init)
+		}
+		walkExpression(v, n.Condition)
+		if n.Update != nil {
+			Walk(v, n.Update)
+		}
+		Walk(v, n.Body)
+	case *ForInStatement:
+		Walk(v, n.Variable)
+		walkExpression(v, n.Iterable)
+		Walk(v, n.Body)
+	case *WhileStatement:
+		walkExpression(v, n.Condition)
+		Walk(v, n.Body)
+	case *DoWhileStatement:
+		Walk(v, n.Body)
+		walkExpression(v, n.Condition)
+	case *IfStatement:
+		walkExpression(v, n.Condition)
+		Walk(v, n.Consequence)
+		if n.Alternative != nil {
+			Walk(v, n.Alternative)
+		}
+	case *InterpolatedString:
+		walkExpressions(v, n.Parts)
+	case *PrefixExpression:
+		walkExpression(v, n.Right)
+	case *InfixExpression:
+		walkExpression(v, n.Left)
+		walkExpression(v, n.Right)
+	case *PostfixExpression:
+		walkExpression(v, n.Left)
+	case *FunctionLiteral:
+		for _, param := range n.Parameters {
+			Walk(v, param)
+		}
+		Walk(v, n.Body)
+	case *CallExpression:
+		walkExpression(v, n.Function)
+		walkExpressions(v, n.Arguments)
+	case *ArrayLiteral:
+		walkExpressions(v, n.Elements)
+	case *IndexExpression:
+		walkExpression(v, n.Left)
+		walkExpression(v, n.Index)
+	case *SliceExpression:
+		walkExpression(v, n.Left)
+		walkExpression(v, n.Low)
+		walkExpression(v, n.High)
+	case *HashLiteral:
+		for _, key := range n.Keys() {
+			walkExpression(v, key)
+			walkExpression(v, n.Pairs[key])
+		}
+	case *MemberExpression:
+		walkExpression(v, n.Object)
+		Walk(v, n.Member)
+	case *PipeExpression:
+		walkExpression(v, n.Left)
+		walkExpression(v, n.Right)
+	case *MatchExpression:
+		walkExpression(v, n.Value)
+		for _, c := range n.Cases {
+			walkExpression(v, c.Pattern)
+			walkExpression(v, c.Guard)
+			Walk(v, c.Body)
+		}
+	case *TryExpression:
+		Walk(v, n.Block)
+		if n.CatchParameter != nil {
+			Walk(v, n.CatchParameter)
+		}
+		Walk(v, n.CatchBlock)
+	}
+
+	v.Visit(nil)
+}
+
+func walkStatements(v Visitor, list []Statement) {
+	for _, stmt := range list {
+		Walk(v, stmt)
+	}
+}
+
+func walkExpressions(v Visitor, list []Expression) {
+	for _, exp := range list {
+		walkExpression(v, exp)
+	}
+}
+
+func walkExpression(v Visitor, exp Expression) {
+	if exp != nil {
+		Walk(v, exp)
+	}
+}
+
+// Keys returns the hash's keys in source order. Keys without a span, from
+// hand-built trees, sort by their String form after those with one.
+func (hl *HashLiteral) Keys() []Expression {
+	keys := make([]Expression, 0, len(hl.Pairs))
+	for k := range hl.Pairs {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i].Range().Start, keys[j].Range().Start
+		if (a.Line == 0) != (b.Line == 0) {
+			return b.Line == 0
+		}
+		if a != b {
+			return a.Line < b.Line || a.Line == b.Line && a.Col < b.Col
+		}
+		return keys[i].String() < keys[j].String()
+	})
+	return keys
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(node Node) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+// Inspect traverses the tree rooted at node like Walk, calling f for each
+// node and f(nil) once its children are done. The children of a node are
+// skipped when f returns false for it.
+func Inspect(node Node, f func(Node) bool) {
+	Walk(inspector(f), node)
+}
+
+// Rewrite transforms the tree rooted at node bottom up: it rewrites the
+// children of node in place, then returns fn(node). fn returns the node to
+// put in its place, often node itself. Returning nil for a statement in a
+// program or block, or for a for loop's init or update, removes it. Any
+// other replacement must fit the field it goes into, an Expression for an
+// expression or a *BlockStatement for a body, or Rewrite panics.
+func Rewrite(node Node, fn func(Node) Node) Node {
+	switch n := node.(type) {
+	case *Program:
+		n.Statements = rewriteStatements(n.Statements, fn)
+	case *BlockStatement:
+		n.Statements = rewriteStatements(n.Statements, fn)
+	case *SetStatement:
+		n.Name = Rewrite(n.Name, fn).(*Identifier)
+		n.Value = rewriteExpression(n.Value, fn)
+	case *DestructureStatement:
+		for i, name := range n.Names {
+			n.Names[i] = Rewrite(name, fn).(*Identifier)
+		}
+		n.Value = rewriteExpression(n.Value, fn)
+	case *AssignStatement:
+		n.Name = Rewrite(n.Name, fn).(*Identifier)
+		n.Value = rewriteExpression(n.Value, fn)
+	case *IndexAssignStatement:
+		n.Target = rewriteExpression(n.Target, fn)
+		n.Value = rewriteExpression(n.Value, fn)
+	case *OutStatement:
+		n.Value = rewriteExpression(n.Value, fn)
+	case *ReturnStatement:
+		n.Value = rewriteExpression(n.Value, fn)
+	case *ThrowStatement:
+		n.Value = rewriteExpression(n.Value, fn)
+	case *ExpressionStatement:
+		n.Expression = rewriteExpression(n.Expression, fn)
+	case *ImportStatement:
+		n.Path = rewriteExpression(n.Path, fn)
+		if n.Alias != nil {
+			n.Alias = Rewrite(n.Alias, fn).(*Identifier)
+		}
+	case *SpawnStatement:
+		n.Call = Rewrite(n.Call, fn).(*CallExpression)
+	case *ClassStatement:
+		n.Name = Rewrite(n.Name, fn).(*Identifier)
+		for _, f := range n.Fields {
+			f.Name = Rewrite(f.Name, fn).(*Identifier)
+			f.Value = rewriteExpression(f.Value, fn)
+		}
+		for i, m := range n.Methods {
+			n.Methods[i] = Rewrite(m, fn).(*FunctionLiteral)
+		}
+	case *GroupStatement:
+		n.Body = rewriteBlock(n.Body, fn)
+	case *ForStatement:
+		n.Init = rewriteStatement(n.Init, fn)
+		n.Condition = rewriteExpression(n.Condition, fn)
+		n.Update = rewriteStatement(n.Update, fn)
+		n.Body = rewriteBlock(n.Body, fn)
+	case *ForInStatement:
+		n.Variable = Rewrite(n.Variable, fn).(*Identifier)
+		n.Iterable = rewriteExpression(n.Iterable, fn)
+		n.Body = rewriteBlock(n.Body, fn)
+	case *WhileStatement:
+		n.Condition = rewriteExpression(n.Condition, fn)
+		n.Body = rewriteBlock(n.Body, fn)
+	case *DoWhileStatement:
+		n.Body = rewriteBlock(n.Body, fn)
+		n.Condition = rewriteExpression(n.Condition, fn)
+	case *IfStatement:
+		n.Condition = rewriteExpression(n.Condition, fn)
+		n.Consequence = rewriteBlock(n.Consequence, fn)
+		n.Alternative = rewriteBlock(n.Alternative, fn)
+	case *InterpolatedString:
+		n.Parts = rewriteExpressions(n.Parts, fn)
+	case *PrefixExpression:
+		n.Right = rewriteExpression(n.Right, fn)
+	case *InfixExpression:
+		n.Left = rewriteExpression(n.Left, fn)
+		n.Right = rewriteExpression(n.Right, fn)
+	case *PostfixExpression:
+		n.Left = rewriteExpression(n.Left, fn)
+	case *FunctionLiteral:
+		for i, param := range n.Parameters {
+			n.Parameters[i] = Rewrite(param, fn).(*Identifier)
+		}
+		n.Body = rewriteBlock(n.Body, fn)
+	case *CallExpression:
+		n.Function = rewriteExpression(n.Function, fn)
+		n.Arguments = rewriteExpressions(n.Arguments, fn)
+	case *ArrayLiteral:
+		n.Elements = rewriteExpressions(n.Elements, fn)
+	case *IndexExpression:
+		n.Left = rewriteExpression(n.Left, fn)
+		n.Index = rewriteExpression(n.Index, fn)
+	case *SliceExpression:
+		n.Left = rewriteExpression(n.Left, fn)
+		n.Low = rewriteExpression(n.Low, fn)
+		n.High = rewriteExpression(n.High, fn)
+	case *HashLiteral:
+		pairs := make(map[Expression]Expression, len(n.Pairs))
+		for _, key := range n.Keys() {
+			value := n.Pairs[key]
+			pairs[rewriteExpression(key, fn)] = rewriteExpression(value, fn)
+		}
+		n.Pairs = pairs
+	case *MemberExpression:
+		n.Object = rewriteExpression(n.Object, fn)
+		n.Member = Rewrite(n.Member, fn).(*Identifier)
+	case *PipeExpression:
+		n.Left = rewriteExpression(n.Left, fn)
+		n.Right = rewriteExpression(n.Right, fn)
+	case *MatchExpression:
+		n.Value = rewriteExpression(n.Value, fn)
+		for _, c := range n.Cases {
+			c.Pattern = rewriteExpression(c.Pattern, fn)
+			c.Guard = rewriteExpression(c.Guard, fn)
+			c.Body = rewriteBlock(c.Body, fn)
+		}
+	case *TryExpression:
+		n.Block = rewriteBlock(n.Block, fn)
+		if n.CatchParameter != nil {
+			n.CatchParameter = Rewrite(n.CatchParameter, fn).(*Identifier)
+		}
+		n.CatchBlock = rewriteBlock(n.CatchBlock, fn)
+	}
+	return fn(node)
+}
+
+func rewriteStatements(list []Statement, fn func(Node) Node) []Statement {
+	out := list[:0]
+	for _, stmt := range list {
+		if stmt = rewriteStatement(stmt, fn); stmt != nil {
+			out = append(out, stmt)
+		}
+	}
+	return out
+}
+
+func rewriteStatement(stmt Statement, fn func(Node) Node) Statement {
+	if stmt == nil {
+		return nil
+	}
+	if r := Rewrite(stmt, fn); r != nil {
+		return r.(Statement)
+	}
+	return nil
+}
+
+func rewriteExpressions(list []Expression, fn func(Node) Node) []Expression {
+	for i, exp := range list {
+		list[i] = rewriteExpression(exp, fn)
+	}
+	return list
+}
+
+func rewriteExpression(exp Expression, fn func(Node) Node) Expression {
+	if exp == nil {
+		return nil
+	}
+	return Rewrite(exp, fn).(Expression)
+}
+
+func rewriteBlock(block *BlockStatement, fn func(Node) Node) *BlockStatement {
+	if block == nil {
+		return nil
+	}
+	return Rewrite(block, fn).(*BlockStatement)
+}
@@ -0,0 +1,283 @@
+// Argparse - command-line flag parsing for scripts, with generated --help output
+
+package builtins
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"xon/object"
+)
+
+// ScriptArgs holds the command-line arguments following the script path.
+var ScriptArgs []string
+
+func init() {
+	builtinsMap["argparse"] = &object.Builtin{Fn: argparse}
+}
+
+type flagSpec struct {
+	name     string
+	short    string
+	kind     string // "bool", "string", "int" or "float"
+	help     string
+	def      object.Object
+	required bool
+}
+
+// argparse implements argparse(flags, options?). flags maps each long flag
+// name to a hash with "type" (bool, string, int, float; default string),
+// "short" (one letter), "default", "help" and "required". options may set
+// "name" and "description" for the usage text, "positionals" (an array of
+// required positional names) and "args" (an array parsed instead of the
+// script's arguments).
+//
+// The result is {"options": {...}, "positionals": [...]}. --help prints the
+// usage and exits with status 0; an unknown flag, a missing or malformed
+// value, or a missing required argument prints the error and usage to stderr
+// and exits with status 2.
+func argparse(args ...object.Object) object.Object {
+	if len(args) != 1 && len(args) != 2 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1 or 2", len(args))}
+	}
+	flagsHash, ok := args[0].(*object.Hash)
+	if !ok {
+		return &object.Error{Message: fmt.Sprintf("first argument to `argparse` must be HASH, got %s", args[0].Type())}
+	}
+	specs, errObj := parseFlagSpecs(flagsHash)
+	if errObj != nil {
+		return errObj
+	}
+
+	name, description := "script", ""
+	var positionalNames []string
+	argv := ScriptArgs
+	if len(args) == 2 {
+		opts, ok := args[1].(*object.Hash)
+		if !ok {
+			return &object.Error{Message: "argparse options must be a hash"}
+		}
+		if n := getHashStr(opts, "name"); n != "" {
+			name = n
+		}
+		description = getHashStr(opts, "description")
+		for _, p := range getHashArray(opts, "positionals") {
+			positionalNames = append(positionalNames, p.Inspect())
+		}
+		if v := getHashValue(opts, "args"); v != nil {
+			arr, ok := v.(*object.Array)
+			if !ok {
+				return &object.Error{Message: "argparse option \"args\" must be an array of strings"}
+			}
+			argv = make([]string, len(arr.Elements))
+			for i, el := range arr.Elements {
+				argv[i] = el.Inspect()
+			}
+		}
+	}
+
+	usage := argparseUsage(name, description, positionalNames, specs)
+	values, positionals, err := parseArgv(argv, specs)
+	if err == errHelpRequested {
+		fmt.Print(usage)
+		os.Exit(0)
+	}
+	if err == nil && len(positionals) < len(positionalNames) {
+		err = fmt.Errorf("missing argument %s", positionalNames[len(positionals)])
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n%s", name, err, usage)
+		os.Exit(2)
+	}
+
+	options := &object.Hash{Pairs: make(map[object.HashKey]object.HashPair)}
+	for _, spec := range specs {
+		val, ok := values[spec.name]
+		if !ok {
+			val = spec.def
+		}
+		setHashPair(options, spec.name, val)
+	}
+	posElements := make([]object.Object, len(positionals))
+	for i, p := range positionals {
+		posElements[i] = &object.String{Value: p}
+	}
+	result := &object.Hash{Pairs: make(map[object.HashKey]object.HashPair)}
+	setHashPair(result, "options", options)
+	setHashPair(result, "positionals", &object.Array{Elements: posElements})
+	return result
+}
+
+// parseFlagSpecs validates the flags hash, returning the specs sorted by name.
+func parseFlagSpecs(h *object.Hash) ([]*flagSpec, *object.Error) {
+	var specs []*flagSpec
+	for _, pair := range h.Pairs {
+		name, ok := pair.Key.(*object.String)
+		if !ok {
+			return nil, &object.Error{Message: fmt.Sprintf("argparse flag names must be STRING, got %s", pair.Key.Type())}
+		}
+		opts, ok := pair.Value.(*object.Hash)
+		if !ok {
+			return nil, &object.Error{Message: fmt.Sprintf("argparse flag %q must map to a hash", name.Value)}
+		}
+		spec := &flagSpec{name: name.Value, kind: "string", def: NULL}
+		if k := getHashStr(opts, "type"); k != "" {
+			spec.kind = k
+		}
+		spec.short = getHashStr(opts, "short")
+		spec.help = getHashStr(opts, "help")
+		spec.required = getHashBool(opts, "required")
+		if d := getHashValue(opts, "default"); d != nil {
+			spec.def = d
+		}
+		switch spec.kind {
+		case "bool":
+			if spec.def == NULL {
+				spec.def = FALSE
+			}
+		case "string", "int", "float":
+		default:
+			return nil, &object.Error{Message: fmt.Sprintf("argparse flag %q has unknown type %q", spec.name, spec.kind)}
+		}
+		if len([]rune(spec.short)) > 1 {
+			return nil, &object.Error{Message: fmt.Sprintf("argparse flag %q: short name must be one letter", spec.name)}
+		}
+		specs = append(specs, spec)
+	}
+	sort.Slice(specs, func(i, j int) bool { return specs[i].name < specs[j].name })
+	return specs, nil
+}
+
+var errHelpRequested = fmt.Errorf("help requested")
+
+// parseArgv splits argv into flag values and positionals. "--" ends flag
+// parsing; values may follow as the next argument or after "=".
+func parseArgv(argv []string, specs []*flagSpec) (map[string]object.Object, []string, error) {
+	byName := make(map[string]*flagSpec)
+	for _, spec := range specs {
+		byName["--"+spec.name] = spec
+		if spec.short != "" {
+			byName["-"+spec.short] = spec
+		}
+	}
+
+	values := make(map[string]object.Object)
+	var positionals []string
+	for i := 0; i < len(argv); i++ {
+		arg := argv[i]
+		if arg == "--" {
+			positionals = append(positionals, argv[i+1:]...)
+			break
+		}
+		if arg == "-" || !strings.HasPrefix(arg, "-") {
+			positionals = append(positionals, arg)
+			continue
+		}
+		key, value, hasValue := strings.Cut(arg, "=")
+		spec, ok := byName[key]
+		if !ok {
+			if key == "--help" || key == "-h" {
+				return nil, nil, errHelpRequested
+			}
+			return nil, nil, fmt.Errorf("unknown flag %s", key)
+		}
+		if spec.kind == "bool" {
+			if hasValue {
+				b, err := strconv.ParseBool(value)
+				if err != nil {
+					return nil, nil, fmt.Errorf("flag %s expects true or false, got %q", key, value)
+				}
+				values[spec.name] = boolToObj(b)
+			} else {
+				values[spec.name] = TRUE
+			}
+			continue
+		}
+		if !hasValue {
+			if i+1 >= len(argv) {
+				return nil, nil, fmt.Errorf("flag %s needs a value", key)
+			}
+			i++
+			value = argv[i]
+		}
+		val, err := convertFlagValue(spec, value)
+		if err != nil {
+			return nil, nil, fmt.Errorf("flag %s: %s", key, err)
+		}
+		values[spec.name] = val
+	}
+	for _, spec := range specs {
+		if _, ok := values[spec.name]; !ok && spec.required {
+			return nil, nil, fmt.Errorf("missing required flag --%s", spec.name)
+		}
+	}
+	return values, positionals, nil
+}
+
+func convertFlagValue(spec *flagSpec, value string) (object.Object, error) {
+	switch spec.kind {
+	case "int":
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("expected an integer, got %q", value)
+		}
+		return &object.Integer{Value: n}, nil
+	case "float":
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("expected a number, got %q", value)
+		}
+		return &object.Float{Value: f}, nil
+	}
+	return &object.String{Value: value}, nil
+}
+
+// argparseUsage renders the --help text: a usage line, the description and
+// one aligned line per flag.
+func argparseUsage(name, description string, positionals []string, specs []*flagSpec) string {
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "usage: %s [options]", name)
+	for _, p := range positionals {
+		out.WriteString(" " + p)
+	}
+	out.WriteString("\n")
+	if description != "" {
+		fmt.Fprintf(&out, "\n%s\n", description)
+	}
+	out.WriteString("\noptions:\n")
+
+	type row struct{ left, right string }
+	rows := make([]row, 0, len(specs)+1)
+	for _, spec := range specs {
+		left := "    "
+		if spec.short != "" {
+			left = "-" + spec.short + ", "
+		}
+		left += "--" + spec.name
+		if spec.kind != "bool" {
+			left += " " + strings.ToUpper(spec.kind)
+		}
+		right := spec.help
+		if spec.required {
+			right = strings.TrimSpace(right + " (required)")
+		} else if spec.kind != "bool" && spec.def != NULL {
+			right = strings.TrimSpace(fmt.Sprintf("%s (default: %s)", right, spec.def.Inspect()))
+		}
+		rows = append(rows, row{left, right})
+	}
+	rows = append(rows, row{"-h, --help", "Show this help and exit"})
+
+	width := 0
+	for _, r := range rows {
+		if len(r.left) > width {
+			width = len(r.left)
+		}
+	}
+	for _, r := range rows {
+		fmt.Fprintf(&out, "  %-*s  %s\n", width, r.left, r.right)
+	}
+	return out.String()
+}
@@ -0,0 +1,204 @@
+// Audio - sound devices, recording, playback and the system volume
+
+package builtins
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"xon/object"
+)
+
+func init() {
+	builtinsMap["audio_devices"] = &object.Builtin{Fn: audioDevicesBuiltin}
+	builtinsMap["audio_record"] = &object.Builtin{Fn: audioRecordBuiltin}
+	builtinsMap["audio_play"] = &object.Builtin{Fn: audioPlayBuiltin}
+	builtinsMap["volume_get"] = &object.Builtin{Fn: volumeGetBuiltin}
+	builtinsMap["volume_set"] = &object.Builtin{Fn: volumeSetBuiltin}
+	builtinsMap["volume_mute"] = &object.Builtin{Fn: volumeMuteBuiltin}
+}
+
+// audioDevice is one sound card endpoint. ID is what the device option of
+// audio_record and audio_play takes; Kind is "input" or "output".
+type audioDevice struct {
+	ID, Name, Kind string
+	Default        bool
+}
+
+func audioDevicesBuiltin(args ...object.Object) object.Object {
+	if len(args) != 0 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=0", len(args))}
+	}
+	devices, err := audioDevices()
+	if err != nil {
+		return &object.Error{Message: "audio_devices: " + err.Error()}
+	}
+	elements := make([]object.Object, len(devices))
+	for i, d := range devices {
+		h := &object.Hash{Pairs: make(map[object.HashKey]object.HashPair)}
+		setHashPair(h, "id", &object.String{Value: d.ID})
+		setHashPair(h, "name", &object.String{Value: d.Name})
+		setHashPair(h, "kind", &object.String{Value: d.Kind})
+		setHashPair(h, "default", boolToObj(d.Default))
+		elements[i] = h
+	}
+	return &object.Array{Elements: elements}
+}
+
+// audioOptions reads the optional options hash of audio_record and
+// audio_play.
+func audioOptions(name string, args []object.Object, n int) (*object.Hash, *object.Error) {
+	if len(args) <= n {
+		return &object.Hash{Pairs: make(map[object.HashKey]object.HashPair)}, nil
+	}
+	h, ok := args[n].(*object.Hash)
+	if !ok {
+		return nil, &object.Error{Message: name + " options must be a hash"}
+	}
+	return h, nil
+}
+
+// audioRecordBuiltin implements audio_record(seconds, path, options?). It
+// records mono 16-bit sound from the default input, or from options.device,
+// into a WAV file and returns once the time is up.
+func audioRecordBuiltin(args ...object.Object) object.Object {
+	if len(args) != 2 && len(args) != 3 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=2 or 3", len(args))}
+	}
+	seconds, ok := toFloat(args[0])
+	if !ok {
+		return &object.Error{Message: fmt.Sprintf("audio_record: seconds must be a number, got %s", args[0].Type())}
+	}
+	if seconds <= 0 || seconds > 86400 {
+		return &object.Error{Message: fmt.Sprintf("audio_record: seconds must be between 0 and 86400, got %g", seconds)}
+	}
+	path, ok := args[1].(*object.String)
+	if !ok {
+		return &object.Error{Message: fmt.Sprintf("audio_record: path must be STRING, got %s", args[1].Type())}
+	}
+	if !strings.HasSuffix(strings.ToLower(path.Value), ".wav") {
+		return &object.Error{Message: fmt.Sprintf("audio_record: recordings are WAV files, so the path must end in .wav, got %q", path.Value)}
+	}
+	opts, errObj := audioOptions("audio_record", args, 2)
+	if errObj != nil {
+		return errObj
+	}
+	if err := audioRecord(seconds, path.Value, getHashStr(opts, "device")); err != nil {
+		return &object.Error{Message: "audio_record: " + err.Error()}
+	}
+	return TRUE
+}
+
+// audioPlayBuiltin implements audio_play(path, options?). Options: device
+// (an output id from audio_devices) and wait (false returns while the
+// sound is still playing). Returns true.
+func audioPlayBuiltin(args ...object.Object) object.Object {
+	if len(args) != 1 && len(args) != 2 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1 or 2", len(args))}
+	}
+	path, ok := args[0].(*object.String)
+	if !ok {
+		return &object.Error{Message: fmt.Sprintf("argument to `audio_play` must be STRING, got %s", args[0].Type())}
+	}
+	opts, errObj := audioOptions("audio_play", args, 1)
+	if errObj != nil {
+		return errObj
+	}
+	if _, err := os.Stat(path.Value); err != nil {
+		return &object.Error{Message: "audio_play: " + err.Error()}
+	}
+	wait := getHashValue(opts, "wait") == nil || getHashBool(opts, "wait")
+	if err := audioPlay(path.Value, getHashStr(opts, "device"), wait); err != nil {
+		return &object.Error{Message: "audio_play: " + err.Error()}
+	}
+	return TRUE
+}
+
+// volumeGetBuiltin returns the default output's volume in percent.
+func volumeGetBuiltin(args ...object.Object) object.Object {
+	if len(args) != 0 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=0", len(args))}
+	}
+	percent, err := volumeGet()
+	if err != nil {
+		return &object.Error{Message: "volume_get: " + err.Error()}
+	}
+	return &object.Integer{Value: percent}
+}
+
+func volumeSetBuiltin(args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1", len(args))}
+	}
+	percent, ok := args[0].(*object.Integer)
+	if !ok {
+		return &object.Error{Message: fmt.Sprintf("volume_set: percent must be INTEGER, got %s", args[0].Type())}
+	}
+	if percent.Value < 0 || percent.Value > 100 {
+		return &object.Error{Message: fmt.Sprintf("volume_set: percent must be between 0 and 100, got %d", percent.Value)}
+	}
+	if err := volumeSet(percent.Value); err != nil {
+		return &object.Error{Message: "volume_set: " + err.Error()}
+	}
+	return TRUE
+}
+
+// volumeMuteBuiltin mutes the default output, or unmutes it with false,
+// leaving its volume as it was.
+func volumeMuteBuiltin(args ...object.Object) object.Object {
+	if len(args) > 1 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=0 or 1", len(args))}
+	}
+	mute := true
+	if len(args) == 1 {
+		b, ok := args[0].(*object.Boolean)
+		if !ok {
+			return &object.Error{Message: fmt.Sprintf("argument to `volume_mute` must be BOOLEAN, got %s", args[0].Type())}
+		}
+		mute = b.Value
+	}
+	if err := volumeMute(mute); err != nil {
+		return &object.Error{Message: "volume_mute: " + err.Error()}
+	}
+	return TRUE
+}
+
+var pactlPercent = regexp.MustCompile(`(\d+)%`)
+
+// parsePactlVolume reads `pactl get-sink-volume` output, which gives each
+// channel's level; the first one stands for the device.
+func parsePactlVolume(out string) (int64, error) {
+	m := pactlPercent.FindStringSubmatch(out)
+	if m == nil {
+		return 0, fmt.Errorf("unexpected pactl output %q", strings.TrimSpace(out))
+	}
+	return strconv.ParseInt(m[1], 10, 64)
+}
+
+// parsePactlDevices reads `pactl --format=json list sinks` (or
+// sources) output. Monitor sources, which loop an output back as an
+// input, are left out.
+func parsePactlDevices(out, kind, defaultName string) ([]audioDevice, error) {
+	var entries []struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+	}
+	if err := json.Unmarshal([]byte(out), &entries); err != nil {
+		return nil, fmt.Errorf("unexpected pactl output: %v", err)
+	}
+	var devices []audioDevice
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name, ".monitor") {
+			continue
+		}
+		name := e.Description
+		if name == "" {
+			name = e.Name
+		}
+		devices = append(devices, audioDevice{ID: e.Name, Name: name, Kind: kind, Default: e.Name == defaultName})
+	}
+	return devices, nil
+}
@@ -0,0 +1,428 @@
+// Browser - headless Chrome automation over the DevTools protocol, for pages that need JavaScript
+
+package builtins
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"xon/object"
+)
+
+func init() {
+	builtinsMap["browser_open"] = &object.Builtin{Fn: browserOpen}
+	builtinsMap["browser_goto"] = &object.Builtin{Fn: browserGoto}
+	builtinsMap["browser_click"] = &object.Builtin{Fn: browserClick}
+	builtinsMap["browser_type"] = &object.Builtin{Fn: browserType}
+	builtinsMap["browser_text"] = &object.Builtin{Fn: browserText}
+	builtinsMap["browser_screenshot"] = &object.Builtin{Fn: browserScreenshot}
+	builtinsMap["browser_close"] = &object.Builtin{Fn: browserClose}
+}
+
+// browser is a running Chrome, driven through the DevTools connection to
+// its one page.
+type browser struct {
+	cmd     *exec.Cmd
+	dataDir string
+	page    *cdpConn
+	timeout time.Duration // how long to wait for pages and selectors
+
+	mu     sync.Mutex
+	closed bool
+}
+
+func (b *browser) Type() object.ObjectType { return "BROWSER" }
+func (b *browser) Inspect() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return "browser(closed)"
+	}
+	return "browser(open)"
+}
+
+// chromeCandidates are tried in order when browser_open gets no path.
+func chromeCandidates() []string {
+	names := []string{"google-chrome", "google-chrome-stable", "chromium", "chromium-browser", "chrome", "msedge"}
+	for _, dir := range []string{os.Getenv("ProgramFiles"), os.Getenv("ProgramFiles(x86)"), os.Getenv("LocalAppData")} {
+		if dir != "" {
+			names = append(names,
+				filepath.Join(dir, "Google", "Chrome", "Application", "chrome.exe"),
+				filepath.Join(dir, "Microsoft", "Edge", "Application", "msedge.exe"))
+		}
+	}
+	return append(names, "/Applications/Google Chrome.app/Contents/MacOS/Google Chrome")
+}
+
+func findChrome() (string, error) {
+	if path := os.Getenv("XON_CHROME"); path != "" {
+		return path, nil
+	}
+	for _, name := range chromeCandidates() {
+		if path, err := exec.LookPath(name); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("no Chrome or Chromium found; install one, set XON_CHROME or pass {\"path\": ...}")
+}
+
+// browserOpen implements browser_open(options?). Options: path (the Chrome
+// executable), headless (default true) and timeout (ms to wait for pages
+// and selectors, default 10000).
+func browserOpen(args ...object.Object) object.Object {
+	if len(args) > 1 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=0 or 1", len(args))}
+	}
+	headless := true
+	timeout := 10 * time.Second
+	var path string
+	if len(args) == 1 {
+		opts, ok := args[0].(*object.Hash)
+		if !ok {
+			return &object.Error{Message: fmt.Sprintf("argument to `browser_open` must be HASH, got %s", args[0].Type())}
+		}
+		path = getHashStr(opts, "path")
+		if getHashValue(opts, "headless") != nil {
+			headless = getHashBool(opts, "headless")
+		}
+		if ms := getHashInt(opts, "timeout"); ms > 0 {
+			timeout = time.Duration(ms) * time.Millisecond
+		}
+	}
+	if path == "" {
+		found, err := findChrome()
+		if err != nil {
+			return &object.Error{Message: fmt.Sprintf("browser_open: %s", err)}
+		}
+		path = found
+	}
+
+	b, err := launchChrome(path, headless, timeout)
+	if err != nil {
+		return &object.Error{Message: fmt.Sprintf("browser_open: %s", err)}
+	}
+	return b
+}
+
+func launchChrome(path string, headless bool, timeout time.Duration) (*browser, error) {
+	dataDir, err := os.MkdirTemp("", "xon-browser-")
+	if err != nil {
+		return nil, err
+	}
+	flags := []string{
+		"--remote-debugging-port=0",
+		"--user-data-dir=" + dataDir,
+		"--no-first-run",
+		"--no-default-browser-check",
+		"--disable-extensions",
+	}
+	if headless {
+		flags = append(flags, "--headless=new", "--hide-scrollbars", "--mute-audio")
+	}
+	cmd := exec.Command(path, append(flags, "about:blank")...)
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		os.RemoveAll(dataDir)
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		os.RemoveAll(dataDir)
+		return nil, err
+	}
+	b := &browser{cmd: cmd, dataDir: dataDir, timeout: timeout}
+
+	// Chrome announces its DevTools endpoint on stderr.
+	endpoint := make(chan string, 1)
+	go func() {
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			if rest, ok := strings.CutPrefix(scanner.Text(), "DevTools listening on "); ok {
+				endpoint <- rest
+				break
+			}
+		}
+		close(endpoint)
+		for scanner.Scan() {
+		}
+	}()
+	var browserURL string
+	select {
+	case browserURL = <-endpoint:
+	case <-time.After(timeout):
+	}
+	if browserURL == "" {
+		b.shutdown()
+		return nil, fmt.Errorf("%s did not start a DevTools endpoint", filepath.Base(path))
+	}
+
+	pageURL, err := pageTarget(browserURL)
+	if err != nil {
+		b.shutdown()
+		return nil, err
+	}
+	if b.page, err = dialCDP(pageURL); err != nil {
+		b.shutdown()
+		return nil, err
+	}
+	if err := b.page.call("Page.enable", nil, nil, timeout); err != nil {
+		b.shutdown()
+		return nil, err
+	}
+	return b, nil
+}
+
+// pageTarget returns the DevTools WebSocket URL of the browser's first
+// page, listed over HTTP on the same host as browserURL.
+func pageTarget(browserURL string) (string, error) {
+	host := strings.TrimPrefix(browserURL, "ws://")
+	host, _, _ = strings.Cut(host, "/")
+	resp, err := http.Get("http://" + host + "/json/list")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var targets []struct {
+		Type                 string `json:"type"`
+		WebSocketDebuggerURL string `json:"webSocketDebuggerUrl"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&targets); err != nil {
+		return "", fmt.Errorf("reading DevTools targets: %w", err)
+	}
+	for _, t := range targets {
+		if t.Type == "page" {
+			return t.WebSocketDebuggerURL, nil
+		}
+	}
+	return "", fmt.Errorf("the browser has no page open")
+}
+
+func (b *browser) shutdown() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	b.closed = true
+	if b.page != nil {
+		b.page.call("Browser.close", nil, nil, time.Second)
+		b.page.Close()
+	}
+	done := make(chan struct{})
+	go func() {
+		b.cmd.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		b.cmd.Process.Kill()
+		<-done
+	}
+	os.RemoveAll(b.dataDir)
+}
+
+// browserArgs checks that args are a browser followed by want strings.
+func browserArgs(name string, args []object.Object, want int) (*browser, []string, *object.Error) {
+	if len(args) != want+1 {
+		return nil, nil, &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=%d", len(args), want+1)}
+	}
+	b, ok := args[0].(*browser)
+	if !ok {
+		return nil, nil, &object.Error{Message: fmt.Sprintf("first argument to `%s` must be a browser from browser_open, got %s", name, args[0].Type())}
+	}
+	b.mu.Lock()
+	closed := b.closed
+	b.mu.Unlock()
+	if closed {
+		return nil, nil, &object.Error{Message: fmt.Sprintf("%s: the browser is closed", name)}
+	}
+	strs := make([]string, want)
+	for i, arg := range args[1:] {
+		s, ok := arg.(*object.String)
+		if !ok {
+			return nil, nil, &object.Error{Message: fmt.Sprintf("argument %d to `%s` must be STRING, got %s", i+2, name, arg.Type())}
+		}
+		strs[i] = s.Value
+	}
+	return b, strs, nil
+}
+
+// eval runs the JavaScript expression js in the page and decodes its
+// value into result.
+func (b *browser) eval(js string, result any) error {
+	var reply struct {
+		Result struct {
+			Value json.RawMessage `json:"value"`
+		} `json:"result"`
+		ExceptionDetails *struct {
+			Exception struct {
+				Description string `json:"description"`
+			} `json:"exception"`
+			Text string `json:"text"`
+		} `json:"exceptionDetails"`
+	}
+	params := map[string]any{"expression": js, "returnByValue": true, "awaitPromise": true}
+	if err := b.page.call("Runtime.evaluate", params, &reply, b.timeout); err != nil {
+		return err
+	}
+	if ex := reply.ExceptionDetails; ex != nil {
+		if ex.Exception.Description != "" {
+			return fmt.Errorf("%s", ex.Exception.Description)
+		}
+		return fmt.Errorf("%s", ex.Text)
+	}
+	if result == nil || len(reply.Result.Value) == 0 {
+		return nil
+	}
+	return json.Unmarshal(reply.Result.Value, result)
+}
+
+// waitFor polls until the element matching selector exists and then
+// returns what js, a function body given the element as el, evaluates to.
+func (b *browser) waitFor(selector, js string, result any) error {
+	sel, _ := json.Marshal(selector)
+	expr := fmt.Sprintf("(() => { const el = document.querySelector(%s); if (!el) return {found: false}; return {found: true, value: (() => { %s })()}; })()", sel, js)
+	deadline := time.Now().Add(b.timeout)
+	for {
+		var reply struct {
+			Found bool            `json:"found"`
+			Value json.RawMessage `json:"value"`
+		}
+		if err := b.eval(expr, &reply); err != nil {
+			return err
+		}
+		if reply.Found {
+			if result == nil || len(reply.Value) == 0 {
+				return nil
+			}
+			return json.Unmarshal(reply.Value, result)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("no element matches %q after %s", selector, b.timeout)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// browserGoto implements browser_goto(b, url): it loads url and waits for
+// the page's load event.
+func browserGoto(args ...object.Object) object.Object {
+	b, strs, errObj := browserArgs("browser_goto", args, 1)
+	if errObj != nil {
+		return errObj
+	}
+	loaded := b.page.await("Page.loadEventFired")
+	var reply struct {
+		ErrorText string `json:"errorText"`
+	}
+	if err := b.page.call("Page.navigate", map[string]any{"url": strs[0]}, &reply, b.timeout); err != nil {
+		return &object.Error{Message: fmt.Sprintf("browser_goto: %s", err)}
+	}
+	if reply.ErrorText != "" {
+		return &object.Error{Message: fmt.Sprintf("browser_goto: %s: %s", strs[0], reply.ErrorText)}
+	}
+	select {
+	case <-loaded:
+	case <-time.After(b.timeout):
+		return &object.Error{Message: fmt.Sprintf("browser_goto: %s did not finish loading after %s", strs[0], b.timeout)}
+	}
+	return NULL
+}
+
+// browserClick implements browser_click(b, selector): it waits for the
+// element, scrolls it into view and clicks its centre with the mouse.
+func browserClick(args ...object.Object) object.Object {
+	b, strs, errObj := browserArgs("browser_click", args, 1)
+	if errObj != nil {
+		return errObj
+	}
+	var at struct{ X, Y float64 }
+	err := b.waitFor(strs[0], `el.scrollIntoView({block: "center", inline: "center"}); const r = el.getBoundingClientRect(); return {x: r.left + r.width / 2, y: r.top + r.height / 2};`, &at)
+	if err != nil {
+		return &object.Error{Message: fmt.Sprintf("browser_click: %s", err)}
+	}
+	for _, kind := range []string{"mouseMoved", "mousePressed", "mouseReleased"} {
+		params := map[string]any{"type": kind, "x": at.X, "y": at.Y, "button": "left", "clickCount": 1}
+		if kind == "mouseMoved" {
+			params["button"] = "none"
+		}
+		if err := b.page.call("Input.dispatchMouseEvent", params, nil, b.timeout); err != nil {
+			return &object.Error{Message: fmt.Sprintf("browser_click: %s", err)}
+		}
+	}
+	return NULL
+}
+
+// browserType implements browser_type(b, selector, text): it focuses the
+// element and types text into it as keyboard input.
+func browserType(args ...object.Object) object.Object {
+	b, strs, errObj := browserArgs("browser_type", args, 2)
+	if errObj != nil {
+		return errObj
+	}
+	if err := b.waitFor(strs[0], "el.focus();", nil); err != nil {
+		return &object.Error{Message: fmt.Sprintf("browser_type: %s", err)}
+	}
+	if err := b.page.call("Input.insertText", map[string]any{"text": strs[1]}, nil, b.timeout); err != nil {
+		return &object.Error{Message: fmt.Sprintf("browser_type: %s", err)}
+	}
+	return NULL
+}
+
+// browserText implements browser_text(b, selector): the rendered text of
+// the first element matching selector.
+func browserText(args ...object.Object) object.Object {
+	b, strs, errObj := browserArgs("browser_text", args, 1)
+	if errObj != nil {
+		return errObj
+	}
+	var text string
+	if err := b.waitFor(strs[0], "return el.innerText ?? el.textContent;", &text); err != nil {
+		return &object.Error{Message: fmt.Sprintf("browser_text: %s", err)}
+	}
+	return &object.String{Value: text}
+}
+
+// browserScreenshot implements browser_screenshot(b, path): it saves the
+// visible page as a PNG.
+func browserScreenshot(args ...object.Object) object.Object {
+	b, strs, errObj := browserArgs("browser_screenshot", args, 1)
+	if errObj != nil {
+		return errObj
+	}
+	var reply struct {
+		Data string `json:"data"`
+	}
+	if err := b.page.call("Page.captureScreenshot", map[string]any{"format": "png"}, &reply, b.timeout); err != nil {
+		return &object.Error{Message: fmt.Sprintf("browser_screenshot: %s", err)}
+	}
+	png, err := base64.StdEncoding.DecodeString(reply.Data)
+	if err != nil {
+		return &object.Error{Message: fmt.Sprintf("browser_screenshot: %s", err)}
+	}
+	if err := os.WriteFile(strs[0], png, 0644); err != nil {
+		return &object.Error{Message: fmt.Sprintf("browser_screenshot: %s", err)}
+	}
+	return NULL
+}
+
+// browserClose implements browser_close(b). Closing twice is harmless.
+func browserClose(args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1", len(args))}
+	}
+	b, ok := args[0].(*browser)
+	if !ok {
+		return &object.Error{Message: fmt.Sprintf("argument to `browser_close` must be a browser from browser_open, got %s", args[0].Type())}
+	}
+	b.shutdown()
+	return NULL
+}
@@ -0,0 +1,148 @@
+// Build - standalone executables for os_compile and `xon build`, optionally cross-compiled
+
+package builtins
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// scriptTrailer ends an executable that carries its script. The script is
+// appended to the runner, followed by its length as 8 little-endian bytes
+// and then the trailer; operating systems ignore data past the end of the
+// binary's image.
+const scriptTrailer = "\x00XON-SCRIPT-V1\x00"
+
+// BuildExecutable writes output, an executable that runs the script at
+// scriptPath. target is "" for the host platform or "GOOS/GOARCH", such as
+// "linux/amd64". For the host, the running interpreter is copied with the
+// script appended, so no Go toolchain is needed. For other targets a plain
+// runner is compiled with `go build` in the current directory, which must be
+// the Xon source tree, and the script is appended to it the same way; the
+// platform files in this package decide which builtins work there.
+func BuildExecutable(scriptPath, output, target string) error {
+	scriptContent, err := ioutil.ReadFile(scriptPath)
+	if err != nil {
+		return fmt.Errorf("failed to read script: %s", err)
+	}
+	if target == "" || target == runtime.GOOS+"/"+runtime.GOARCH {
+		exe, err := os.Executable()
+		if err != nil {
+			return fmt.Errorf("cannot find the running interpreter: %s", err)
+		}
+		return BundleScript(exe, output, scriptContent)
+	}
+
+	goos, goarch, ok := strings.Cut(target, "/")
+	if !ok || goos == "" || goarch == "" {
+		return fmt.Errorf("invalid target %q, want GOOS/GOARCH such as linux/amd64", target)
+	}
+	runner, err := ioutil.TempFile("", "xon-runner-*")
+	if err != nil {
+		return err
+	}
+	runner.Close()
+	defer os.Remove(runner.Name())
+
+	cmd := exec.Command("go", "build", "-o", runner.Name(), ".")
+	cmd.Env = append(os.Environ(), "GOOS="+goos, "GOARCH="+goarch, "CGO_ENABLED=0")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		if _, lookErr := exec.LookPath("go"); lookErr != nil {
+			return fmt.Errorf("building for %s needs the Go toolchain and the Xon source tree; run xon build on a %s machine instead", target, target)
+		}
+		return fmt.Errorf("build failed: %s %s", out, err)
+	}
+	return BundleScript(runner.Name(), output, scriptContent)
+}
+
+// BundleScript copies the executable runner to output with script
+// appended. A script the runner already carries is replaced, so a bundled
+// program can build others.
+func BundleScript(runner, output string, script []byte) error {
+	in, err := os.Open(runner)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	size, _, err := embeddedScript(in)
+	if err != nil {
+		return err
+	}
+	if a, err := in.Stat(); err == nil {
+		if b, err := os.Stat(output); err == nil && os.SameFile(a, b) {
+			return fmt.Errorf("%s is the interpreter itself; choose another output", output)
+		}
+	}
+
+	out, err := os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0755)
+	if err != nil {
+		return err
+	}
+	var length [8]byte
+	binary.LittleEndian.PutUint64(length[:], uint64(len(script)))
+	_, err = io.Copy(out, io.NewSectionReader(in, 0, size))
+	if err == nil {
+		_, err = out.Write(append(append(script, length[:]...), scriptTrailer...))
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(output)
+	}
+	return err
+}
+
+// EmbeddedScript returns the script appended to the executable at path by
+// BundleScript, if there is one.
+func EmbeddedScript(path string) (string, bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", false
+	}
+	defer f.Close()
+	_, script, err := embeddedScript(f)
+	if err != nil || script == nil {
+		return "", false
+	}
+	buf := make([]byte, script.Size())
+	if _, err := script.ReadAt(buf, 0); err != nil {
+		return "", false
+	}
+	return string(buf), true
+}
+
+// embeddedScript splits an executable into the runner, which is its first
+// size bytes, and the script after it, which is nil if there is none.
+func embeddedScript(f *os.File) (size int64, script *io.SectionReader, err error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, nil, err
+	}
+	size = info.Size()
+	tail := int64(8 + len(scriptTrailer))
+	if size < tail {
+		return size, nil, nil
+	}
+	buf := make([]byte, tail)
+	if _, err := f.ReadAt(buf, size-tail); err != nil {
+		return 0, nil, err
+	}
+	if !bytes.Equal(buf[8:], []byte(scriptTrailer)) {
+		return size, nil, nil
+	}
+	n := binary.LittleEndian.Uint64(buf[:8])
+	if n > uint64(size-tail) {
+		return size, nil, nil
+	}
+	start := size - tail - int64(n)
+	return start, io.NewSectionReader(f, start, int64(n)), nil
+}
@@ -9,17 +9,12 @@ import (
 	"io"
 	"io/ioutil"
 	"math"
-	"math/rand"
 	"net/http"
 	"os"
-	"os/exec"
 	"strconv"
 	"strings"
 	"sync"
-	"syscall"
 	"time"
-	"unicode/utf16"
-	"unsafe"
 )
 
 var (
@@ -30,6 +25,33 @@ var (
 
 var RunClosureCallback func(cl *object.Closure, args []object.Object) object.Object
 
+// callFunction invokes a script closure (through RunClosureCallback) or a builtin.
+func callFunction(fn object.Object, args ...object.Object) object.Object {
+	switch f := fn.(type) {
+	case *object.Closure:
+		if RunClosureCallback == nil {
+			return &object.Error{Message: "closure callbacks are not available in this engine"}
+		}
+		res := RunClosureCallback(f, args)
+		if res == nil {
+			return NULL
+		}
+		return res
+	case *object.Builtin:
+		res := f.Fn(args...)
+		if res == nil {
+			return NULL
+		}
+		return res
+	case *object.BoundMethod:
+		return callFunction(f.Method, append([]object.Object{f.Receiver}, args...)...)
+	case *object.Class:
+		return callFunction(f.Init, append([]object.Object{f.NewInstance()}, args...)...)
+	default:
+		return &object.Error{Message: fmt.Sprintf("not a function: %s", fn.Type())}
+	}
+}
+
 func SetVMContext(constants []object.Object, globals []object.Object, mu *sync.RWMutex) {
 	VMConstants = constants
 	VMGlobals = globals
@@ -40,12 +62,13 @@ func SetVMContext(constants []object.Object, globals []object.Object, mu *sync.R
 var embeddedStd embed.FS
 
 const StdBltinsFallback = `
-set help = fn() {
-    out "Xon Language - Core Primitives Only";
-    out "Standard library (std/core.xn) not found.";
-};
+// Xon Language - Core Primitives Only
+// Standard library (std/core.xn) not found.
 `
 
+// StdLibFile names the standard library in positions and stack traces.
+const StdLibFile = "std/core.xn"
+
 // LoadStdLib loads the standard library source code.
 func LoadStdLib() (string, error) {
 	stdPath := "builtins/std/core.xn"
@@ -53,14 +76,30 @@ func LoadStdLib() (string, error) {
 	if err == nil {
 		return string(content), nil
 	}
-	// Fallback to embedded
-	embeddedContent, err := embeddedStd.ReadFile(stdPath)
+	// Fallback to embedded; embed paths are relative to this package.
+	embeddedContent, err := embeddedStd.ReadFile("std/core.xn")
 	if err == nil {
 		return string(embeddedContent), nil
 	}
 	return StdBltinsFallback, nil
 }
 
+// LoadModule reads the source of an imported module. Paths under std/ fall
+// back to the standard library embedded in the binary, so `import "std/..."`
+// works the same with or without the source tree on disk.
+func LoadModule(path string) (string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err == nil {
+		return string(content), nil
+	}
+	if strings.HasPrefix(path, "std/") {
+		if embedded, embErr := embeddedStd.ReadFile(path); embErr == nil {
+			return string(embedded), nil
+		}
+	}
+	return "", err
+}
+
 var (
 	NULL         = &object.Null{}
 	TRUE         = &object.Boolean{Value: true}
@@ -167,51 +206,36 @@ var builtinsMap = map[string]*object.Builtin{
 	},
 	"http_serve": &object.Builtin{
 		Fn: func(args ...object.Object) object.Object {
-			if len(args) != 2 {
-				return &object.Error{Message: "wrong number of arguments. got=" + fmt.Sprint(len(args)) + ", want=2"}
+			if len(args) != 2 && len(args) != 3 {
+				return &object.Error{Message: "wrong number of arguments. got=" + fmt.Sprint(len(args)) + ", want=2 or 3"}
 			}
 			port, ok1 := args[0].(*object.Integer)
 			handler, ok2 := args[1].(*object.Closure)
 			if !ok1 || !ok2 {
 				return &object.Error{Message: "arguments to http_serve must be (INTEGER, FUNCTION)"}
 			}
+			var opts serveOptions
+			if len(args) == 3 {
+				h, ok := args[2].(*object.Hash)
+				if !ok {
+					return &object.Error{Message: "http_serve options must be a hash"}
+				}
+				opts = parseServeOptions(h)
+			}
 
 			addr := ":" + fmt.Sprint(port.Value)
 			fmt.Printf("Xon Server starting on %s...\n", addr)
 
-			server := &http.Server{Addr: addr}
-			http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-				// Create sub-VM for request
-				// We need a dummy VM or just a way to run the closure.
-				// Since we don't have a direct "RunClosure" helper, we'll implement a tiny one or use VM logic.
-
-				// Prepare request object
-				reqHash := make(map[object.HashKey]object.HashPair)
-				reqHash[(&object.String{Value: "method"}).HashKey()] = object.HashPair{Key: &object.String{Value: "method"}, Value: &object.String{Value: r.Method}}
-				reqHash[(&object.String{Value: "path"}).HashKey()] = object.HashPair{Key: &object.String{Value: "path"}, Value: &object.String{Value: r.URL.Path}}
-
-				// For simplicity, we just pass method and path for now.
-				// In a full implementation, we'd add headers, body, etc.
-
-				// Need a way to run this. We actually need a circular dependency or a helper.
-				// Let's assume we have a way to run a closure.
-
-				// Since we can't easily import 'vm' here without circular deps,
-				// we'll use a hack or a callback.
+			// Each server gets its own mux, so a script can serve on several ports.
+			mux := http.NewServeMux()
+			mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 				if RunClosureCallback == nil {
 					http.Error(w, "Server engine not initialized", 500)
 					return
 				}
-
-				res := RunClosureCallback(handler, []object.Object{&object.Hash{Pairs: reqHash}})
-				if res.Type() == object.ERROR_OBJ {
-					http.Error(w, res.Inspect(), 500)
-					return
-				}
-
-				fmt.Fprintf(w, "%s", res.Inspect())
+				writeResponse(w, RunClosureCallback(handler, []object.Object{requestHash(r)}))
 			})
-
+			server := &http.Server{Addr: addr, Handler: withMiddleware(mux, opts)}
 			go server.ListenAndServe()
 			return &object.String{Value: "Server running on " + addr}
 		},
@@ -226,11 +250,45 @@ var builtinsMap = map[string]*object.Builtin{
 				return &object.Integer{Value: int64(len(arg.Elements))}
 			case *object.String:
 				return &object.Integer{Value: int64(len(arg.Value))}
+			case *object.NumArray:
+				return &object.Integer{Value: int64(arg.Shape[0])}
+			case *object.Range:
+				return &object.Integer{Value: arg.Len()}
 			default:
 				return &object.Error{Message: fmt.Sprintf("argument to `len` not supported, got %s", args[0].Type())}
 			}
 		},
 	},
+	"range": &object.Builtin{
+		// range(end), range(start, end) or range(start, end, step); the
+		// numbers are made as they are read, so the length costs nothing.
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) < 1 || len(args) > 3 {
+				return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1 to 3", len(args))}
+			}
+			bounds := make([]int64, len(args))
+			for i, arg := range args {
+				n, ok := arg.(*object.Integer)
+				if !ok {
+					return &object.Error{Message: fmt.Sprintf("arguments to `range` must be INTEGER, got %s", arg.Type())}
+				}
+				bounds[i] = n.Value
+			}
+			r := &object.Range{Step: 1}
+			switch len(bounds) {
+			case 1:
+				r.End = bounds[0]
+			case 2:
+				r.Start, r.End = bounds[0], bounds[1]
+			case 3:
+				r.Start, r.End, r.Step = bounds[0], bounds[1], bounds[2]
+			}
+			if r.Step == 0 {
+				return &object.Error{Message: "range: step cannot be 0"}
+			}
+			return r
+		},
+	},
 	"push": &object.Builtin{
 		Fn: func(args ...object.Object) object.Object {
 			if len(args) != 2 {
@@ -335,6 +393,96 @@ var builtinsMap = map[string]*object.Builtin{
 			return &object.Array{Elements: []object.Object{}}
 		},
 	},
+	// push_mut and pop_mut are the in-place counterparts of push and pop,
+	// matching the arr.push(x) / arr.pop() methods.
+	"push_mut": &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) < 2 {
+				return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=2+", len(args))}
+			}
+			arr, ok := args[0].(*object.Array)
+			if !ok {
+				return &object.Error{Message: fmt.Sprintf("argument to `push_mut` must be ARRAY, got %s", args[0].Type())}
+			}
+			if arr.Frozen {
+				return &object.Error{Message: "cannot push to a frozen array", Raise: true}
+			}
+			arr.Elements = append(arr.Elements, args[1:]...)
+			return arr
+		},
+	},
+	"pop_mut": &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1", len(args))}
+			}
+			arr, ok := args[0].(*object.Array)
+			if !ok {
+				return &object.Error{Message: fmt.Sprintf("argument to `pop_mut` must be ARRAY, got %s", args[0].Type())}
+			}
+			if arr.Frozen {
+				return &object.Error{Message: "cannot pop from a frozen array", Raise: true}
+			}
+			length := len(arr.Elements)
+			if length == 0 {
+				return NULL
+			}
+			last := arr.Elements[length-1]
+			arr.Elements[length-1] = nil
+			arr.Elements = arr.Elements[:length-1]
+			return last
+		},
+	},
+	"is_null": &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1", len(args))}
+			}
+			if args[0] == nil || args[0].Type() == object.NULL_OBJ {
+				return TRUE
+			}
+			return FALSE
+		},
+	},
+	// ifnull(value, fallback) returns fallback when value is null.
+	"ifnull": &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 2 {
+				return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=2", len(args))}
+			}
+			if args[0] == nil || args[0].Type() == object.NULL_OBJ {
+				return args[1]
+			}
+			return args[0]
+		},
+	},
+	"freeze": &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1", len(args))}
+			}
+			object.Freeze(args[0])
+			return args[0]
+		},
+	},
+	"is_frozen": &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1", len(args))}
+			}
+			switch v := args[0].(type) {
+			case *object.Array:
+				if v.Frozen {
+					return TRUE
+				}
+			case *object.Hash:
+				if v.Frozen {
+					return TRUE
+				}
+			}
+			return FALSE
+		},
+	},
 	"toUpperCase": &object.Builtin{
 		Fn: func(args ...object.Object) object.Object {
 			if len(args) != 1 {
@@ -364,15 +512,25 @@ var builtinsMap = map[string]*object.Builtin{
 	},
 	"sleep": &object.Builtin{
 		Fn: func(args ...object.Object) object.Object {
-			if len(args) != 1 {
-				return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1", len(args))}
+			if len(args) != 1 && len(args) != 2 {
+				return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1 or 2", len(args))}
 			}
 			if args[0].Type() != object.INTEGER_OBJ {
 				return &object.Error{Message: fmt.Sprintf("argument to `sleep` must be INTEGER (ms), got %s", args[0].Type())}
 			}
+			ctx, errObj := optionalContext("sleep", args, 1)
+			if errObj != nil {
+				return errObj
+			}
 			ms := args[0].(*object.Integer).Value
-			time.Sleep(time.Duration(ms) * time.Millisecond)
-			return NULL
+			timer := time.NewTimer(time.Duration(ms) * time.Millisecond)
+			defer timer.Stop()
+			select {
+			case <-timer.C:
+				return NULL
+			case <-ctx.Done():
+				return &object.Error{Message: "sleep canceled: " + ctx.Err().Error()}
+			}
 		},
 	},
 	"json_encode": &object.Builtin{
@@ -447,15 +605,18 @@ var builtinsMap = map[string]*object.Builtin{
 			if !ok1 || !ok2 {
 				return &object.Error{Message: "arguments to mouse_move must be INTEGER"}
 			}
-			setCursorPos.Call(uintptr(x.Value), uintptr(y.Value))
+			if err := mouseMove(x.Value, y.Value); err != nil {
+				return &object.Error{Message: err.Error()}
+			}
 			return NULL
 		},
 	},
 	"os_mouse_click": &object.Builtin{
 		Fn: func(args ...object.Object) object.Object {
 			// Basic left click
-			mouseEvent.Call(uintptr(0x0002), 0, 0, 0, 0) // MOUSEEVENTF_LEFTDOWN
-			mouseEvent.Call(uintptr(0x0004), 0, 0, 0, 0) // MOUSEEVENTF_LEFTUP
+			if err := mouseClick(); err != nil {
+				return &object.Error{Message: err.Error()}
+			}
 			return NULL
 		},
 	},
@@ -468,8 +629,9 @@ var builtinsMap = map[string]*object.Builtin{
 			if !ok {
 				return &object.Error{Message: "argument to key_tap must be INTEGER (VK code)"}
 			}
-			keybdEvent.Call(uintptr(key.Value), 0, 0, 0)               // Key down
-			keybdEvent.Call(uintptr(key.Value), 0, uintptr(0x0002), 0) // Key up (KEYEVENTF_KEYUP = 0x0002)
+			if err := keyTap(key.Value); err != nil {
+				return &object.Error{Message: err.Error()}
+			}
 			return NULL
 		},
 	},
@@ -482,7 +644,7 @@ var builtinsMap = map[string]*object.Builtin{
 			if !ok {
 				return &object.Error{Message: "argument to os_exec must be STRING"}
 			}
-			out, err := exec.Command("cmd", "/C", input.Value).CombinedOutput()
+			out, err := shellCommand(input.Value).CombinedOutput()
 			if err != nil {
 				return &object.Error{Message: string(out) + " " + err.Error()}
 			}
@@ -491,11 +653,13 @@ var builtinsMap = map[string]*object.Builtin{
 	},
 	"os_mouse_get_pos": &object.Builtin{
 		Fn: func(args ...object.Object) object.Object {
-			var pt POINT
-			getCursorPos.Call(uintptr(unsafe.Pointer(&pt)))
+			x, y, err := mousePos()
+			if err != nil {
+				return &object.Error{Message: err.Error()}
+			}
 			return &object.Hash{Pairs: map[object.HashKey]object.HashPair{
-				(&object.String{Value: "x"}).HashKey(): {Key: &object.String{Value: "x"}, Value: &object.Integer{Value: int64(pt.X)}},
-				(&object.String{Value: "y"}).HashKey(): {Key: &object.String{Value: "y"}, Value: &object.Integer{Value: int64(pt.Y)}},
+				(&object.String{Value: "x"}).HashKey(): {Key: &object.String{Value: "x"}, Value: &object.Integer{Value: x}},
+				(&object.String{Value: "y"}).HashKey(): {Key: &object.String{Value: "y"}, Value: &object.Integer{Value: y}},
 			}}
 		},
 	},
@@ -511,19 +675,27 @@ var builtinsMap = map[string]*object.Builtin{
 			if max.Value <= 0 {
 				return &object.Integer{Value: 0}
 			}
-			return &object.Integer{Value: int64(rand.Intn(int(max.Value)))}
+			return &object.Integer{Value: int64(randIntn(int(max.Value)))}
 		},
 	},
 	"http_get": &object.Builtin{
 		Fn: func(args ...object.Object) object.Object {
-			if len(args) != 1 {
-				return &object.Error{Message: "wrong number of arguments. got=" + fmt.Sprint(len(args)) + ", want=1"}
+			if len(args) != 1 && len(args) != 2 {
+				return &object.Error{Message: "wrong number of arguments. got=" + fmt.Sprint(len(args)) + ", want=1 or 2"}
 			}
 			url, ok := args[0].(*object.String)
 			if !ok {
 				return &object.Error{Message: "argument to http_get must be STRING"}
 			}
-			resp, err := http.Get(url.Value)
+			ctx, errObj := optionalContext("http_get", args, 1)
+			if errObj != nil {
+				return errObj
+			}
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, url.Value, nil)
+			if err != nil {
+				return &object.Error{Message: err.Error()}
+			}
+			resp, err := http.DefaultClient.Do(req)
 			if err != nil {
 				return &object.Error{Message: err.Error()}
 			}
@@ -545,53 +717,40 @@ var builtinsMap = map[string]*object.Builtin{
 			if !ok1 || !ok2 {
 				return &object.Error{Message: "arguments to alert must be STRING"}
 			}
-			tPtr, _ := syscall.UTF16PtrFromString(title.Value)
-			mPtr, _ := syscall.UTF16PtrFromString(msg.Value)
-			messageBox.Call(0, uintptr(unsafe.Pointer(mPtr)), uintptr(unsafe.Pointer(tPtr)), 0)
+			if err := alertBox(title.Value, msg.Value); err != nil {
+				return &object.Error{Message: err.Error()}
+			}
 			return NULL
 		},
 	},
 	"os_compile": &object.Builtin{
 		Fn: func(args ...object.Object) object.Object {
-			if len(args) != 2 {
-				return &object.Error{Message: "wrong number of arguments. got=" + fmt.Sprint(len(args)) + ", want=2"}
+			if len(args) != 2 && len(args) != 3 {
+				return &object.Error{Message: "wrong number of arguments. got=" + fmt.Sprint(len(args)) + ", want=2 or 3"}
 			}
 			scriptPath, ok1 := args[0].(*object.String)
 			outputExe, ok2 := args[1].(*object.String)
 			if !ok1 || !ok2 {
 				return &object.Error{Message: "arguments to compile must be STRING"}
 			}
-
-			scriptContent, err := ioutil.ReadFile(scriptPath.Value)
-			if err != nil {
-				return &object.Error{Message: "failed to read script: " + err.Error()}
+			target := ""
+			if len(args) == 3 {
+				t, ok := args[2].(*object.String)
+				if !ok {
+					return &object.Error{Message: "compile target must be STRING, such as \"linux/amd64\""}
+				}
+				target = t.Value
 			}
 
-			escaped := strings.ReplaceAll(string(scriptContent), "'", "''")
-			ldflags := fmt.Sprintf("-X 'main.EmbeddedScript=%s'", escaped)
-
-			cmd := exec.Command("go", "build", "-ldflags", ldflags, "-o", outputExe.Value, "main.go")
-			out, err := cmd.CombinedOutput()
-			if err != nil {
-				return &object.Error{Message: "build failed: " + string(out) + " " + err.Error()}
+			if err := BuildExecutable(scriptPath.Value, outputExe.Value, target); err != nil {
+				return &object.Error{Message: err.Error()}
 			}
 
 			return &object.String{Value: "Successfully built " + outputExe.Value}
 		},
 	},
 	"input": &object.Builtin{
-		Fn: func(args ...object.Object) object.Object {
-			if len(args) == 1 {
-				prompt, ok := args[0].(*object.String)
-				if ok {
-					fmt.Print(prompt.Value)
-				}
-			}
-			if stdinScanner.Scan() {
-				return &object.String{Value: stdinScanner.Text()}
-			}
-			return &object.String{Value: ""}
-		},
+		Fn: consoleInput,
 	},
 	"int": &object.Builtin{
 		Fn: func(args ...object.Object) object.Object {
@@ -670,13 +829,19 @@ var builtinsMap = map[string]*object.Builtin{
 			if !ok {
 				return &object.Error{Message: "argument to copy must be STRING"}
 			}
-			setClipboard(text.Value)
+			if err := setClipboard(text.Value); err != nil {
+				return &object.Error{Message: err.Error()}
+			}
 			return NULL
 		},
 	},
 	"paste": &object.Builtin{
 		Fn: func(args ...object.Object) object.Object {
-			return &object.String{Value: getClipboard()}
+			text, err := getClipboard()
+			if err != nil {
+				return &object.Error{Message: err.Error()}
+			}
+			return &object.String{Value: text}
 		},
 	},
 	"os_keyboard_type": &object.Builtin{
@@ -688,101 +853,14 @@ var builtinsMap = map[string]*object.Builtin{
 			if !ok {
 				return &object.Error{Message: "argument to type must be STRING"}
 			}
-			for _, char := range text.Value {
-				vk := charToVK(char)
-				if vk != 0 {
-					keybdEvent.Call(uintptr(vk), 0, 0, 0)
-					keybdEvent.Call(uintptr(vk), 0, uintptr(0x0002), 0)
-				}
+			if err := typeText(text.Value); err != nil {
+				return &object.Error{Message: err.Error()}
 			}
 			return NULL
 		},
 	},
 }
 
-type POINT struct {
-	X, Y int32
-}
-
-var (
-	user32           = syscall.NewLazyDLL("user32.dll")
-	setCursorPos     = user32.NewProc("SetCursorPos")
-	getCursorPos     = user32.NewProc("GetCursorPos")
-	mouseEvent       = user32.NewProc("mouse_event")
-	keybdEvent       = user32.NewProc("keybd_event")
-	messageBox       = user32.NewProc("MessageBoxW")
-	openClipboard    = user32.NewProc("OpenClipboard")
-	emptyClipboard   = user32.NewProc("EmptyClipboard")
-	setClipboardData = user32.NewProc("SetClipboardData")
-	getClipboardData = user32.NewProc("GetClipboardData")
-	closeClipboard   = user32.NewProc("CloseClipboard")
-	kernel32         = syscall.NewLazyDLL("kernel32.dll")
-	globalAlloc      = kernel32.NewProc("GlobalAlloc")
-	globalLock       = kernel32.NewProc("GlobalLock")
-	globalUnlock     = kernel32.NewProc("GlobalUnlock")
-	lstrcpy          = kernel32.NewProc("lstrcpyW")
-)
-
-func setClipboard(text string) {
-	opened, _, _ := openClipboard.Call(0)
-	if opened == 0 {
-		return
-	}
-	defer closeClipboard.Call()
-	emptyClipboard.Call()
-
-	utf16 := utf16.Encode([]rune(text + "\x00"))
-	size := uintptr(len(utf16) * 2)
-	hMem, _, _ := globalAlloc.Call(uintptr(0x0042), size) // GHND = 0x0042
-	ptr, _, _ := globalLock.Call(hMem)
-	lstrcpy.Call(ptr, uintptr(unsafe.Pointer(&utf16[0])))
-	globalUnlock.Call(hMem)
-
-	setClipboardData.Call(uintptr(13), hMem) // CF_UNICODETEXT = 13
-}
-
-func getClipboard() string {
-	opened, _, _ := openClipboard.Call(0)
-	if opened == 0 {
-		return ""
-	}
-	defer closeClipboard.Call()
-
-	hMem, _, _ := getClipboardData.Call(uintptr(13))
-	if hMem == 0 {
-		return ""
-	}
-
-	ptr, _, _ := globalLock.Call(hMem)
-	defer globalUnlock.Call(hMem)
-
-	var res []uint16
-	for i := 0; ; i++ {
-		char := *(*uint16)(unsafe.Pointer(ptr + uintptr(i*2)))
-		if char == 0 {
-			break
-		}
-		res = append(res, char)
-	}
-	return string(utf16.Decode(res))
-}
-
-func charToVK(r rune) byte {
-	if r >= 'a' && r <= 'z' {
-		return byte(r - 'a' + 0x41)
-	}
-	if r >= 'A' && r <= 'Z' {
-		return byte(r - 'A' + 0x41)
-	}
-	if r >= '0' && r <= '9' {
-		return byte(r - '0' + 0x30)
-	}
-	if r == ' ' {
-		return 0x20
-	}
-	return 0
-}
-
 func objToRaw(obj object.Object) interface{} {
 	switch obj := obj.(type) {
 	case *object.Integer:
@@ -801,7 +879,7 @@ func objToRaw(obj object.Object) interface{} {
 		return res
 	case *object.Hash:
 		res := make(map[string]interface{})
-		for _, pair := range obj.Pairs {
+		for _, pair := range obj.Entries() {
 			res[pair.Key.Inspect()] = objToRaw(pair.Value)
 		}
 		return res
@@ -0,0 +1,188 @@
+// Cache - memoize() for script functions and TTL caches exposed as hashes of methods
+
+package builtins
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"xon/object"
+)
+
+func init() {
+	builtinsMap["memoize"] = &object.Builtin{Fn: memoize}
+	builtinsMap["cache_new"] = &object.Builtin{Fn: cacheNew}
+}
+
+// memoize wraps a function so repeated calls with equal arguments reuse the
+// first result. Error results are not cached.
+func memoize(args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1", len(args))}
+	}
+	fn := args[0]
+	if fn.Type() != object.CLOSURE_OBJ && fn.Type() != object.BUILTIN_OBJ {
+		return &object.Error{Message: fmt.Sprintf("argument to `memoize` must be a function, got %s", fn.Type())}
+	}
+
+	var mu sync.Mutex
+	results := make(map[string]object.Object)
+	return &object.Builtin{Fn: func(callArgs ...object.Object) object.Object {
+		key := argsKey(callArgs)
+		mu.Lock()
+		res, ok := results[key]
+		mu.Unlock()
+		if ok {
+			return res
+		}
+		res = callFunction(fn, callArgs...)
+		if res.Type() != object.ERROR_OBJ {
+			mu.Lock()
+			results[key] = res
+			mu.Unlock()
+		}
+		return res
+	}}
+}
+
+// argsKey identifies an argument list by type and printed value.
+func argsKey(args []object.Object) string {
+	parts := make([]string, len(args))
+	for i, a := range args {
+		parts[i] = string(a.Type()) + ":" + a.Inspect()
+	}
+	return strings.Join(parts, "\x00")
+}
+
+type cacheEntry struct {
+	value   object.Object
+	expires time.Time // zero means the entry never expires
+}
+
+type ttlCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[object.HashKey]cacheEntry
+}
+
+// cacheNew implements cache_new(ttl_ms). A ttl of 0 keeps entries until
+// deleted. The result is a hash of get/set/has/delete/clear/size functions.
+func cacheNew(args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1", len(args))}
+	}
+	ttlMs, ok := args[0].(*object.Integer)
+	if !ok || ttlMs.Value < 0 {
+		return &object.Error{Message: "argument to `cache_new` must be a non-negative INTEGER (ms)"}
+	}
+	c := &ttlCache{ttl: time.Duration(ttlMs.Value) * time.Millisecond, entries: make(map[object.HashKey]cacheEntry)}
+
+	h := &object.Hash{Pairs: make(map[object.HashKey]object.HashPair)}
+	setHashPair(h, "get", &object.Builtin{Fn: c.get})
+	setHashPair(h, "set", &object.Builtin{Fn: c.set})
+	setHashPair(h, "has", &object.Builtin{Fn: c.has})
+	setHashPair(h, "delete", &object.Builtin{Fn: c.delete})
+	setHashPair(h, "clear", &object.Builtin{Fn: c.clear})
+	setHashPair(h, "size", &object.Builtin{Fn: c.size})
+	return h
+}
+
+func cacheKey(name string, args []object.Object, want int) (object.HashKey, *object.Error) {
+	if len(args) < want {
+		return object.HashKey{}, &object.Error{Message: fmt.Sprintf("cache.%s: wrong number of arguments. got=%d, want=%d", name, len(args), want)}
+	}
+	hashable, ok := args[0].(object.Hashable)
+	if !ok {
+		return object.HashKey{}, &object.Error{Message: fmt.Sprintf("cache.%s: unusable as key: %s", name, args[0].Type())}
+	}
+	return hashable.HashKey(), nil
+}
+
+// lookup returns the live entry for key, evicting it if it has expired. c.mu must be held.
+func (c *ttlCache) lookup(key object.HashKey) (cacheEntry, bool) {
+	e, ok := c.entries[key]
+	if ok && !e.expires.IsZero() && time.Now().After(e.expires) {
+		delete(c.entries, key)
+		return cacheEntry{}, false
+	}
+	return e, ok
+}
+
+func (c *ttlCache) get(args ...object.Object) object.Object {
+	key, errObj := cacheKey("get", args, 1)
+	if errObj != nil {
+		return errObj
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.lookup(key); ok {
+		return e.value
+	}
+	return NULL
+}
+
+// set stores a value; an optional third argument overrides the cache's ttl in ms.
+func (c *ttlCache) set(args ...object.Object) object.Object {
+	key, errObj := cacheKey("set", args, 2)
+	if errObj != nil {
+		return errObj
+	}
+	ttl := c.ttl
+	if len(args) == 3 {
+		ms, ok := args[2].(*object.Integer)
+		if !ok || ms.Value < 0 {
+			return &object.Error{Message: "cache.set: ttl must be a non-negative INTEGER (ms)"}
+		}
+		ttl = time.Duration(ms.Value) * time.Millisecond
+	}
+	e := cacheEntry{value: args[1]}
+	if ttl > 0 {
+		e.expires = time.Now().Add(ttl)
+	}
+	c.mu.Lock()
+	c.entries[key] = e
+	c.mu.Unlock()
+	return args[1]
+}
+
+func (c *ttlCache) has(args ...object.Object) object.Object {
+	key, errObj := cacheKey("has", args, 1)
+	if errObj != nil {
+		return errObj
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.lookup(key)
+	return boolToObj(ok)
+}
+
+func (c *ttlCache) delete(args ...object.Object) object.Object {
+	key, errObj := cacheKey("delete", args, 1)
+	if errObj != nil {
+		return errObj
+	}
+	c.mu.Lock()
+	delete(c.entries, key)
+	c.mu.Unlock()
+	return NULL
+}
+
+func (c *ttlCache) clear(args ...object.Object) object.Object {
+	c.mu.Lock()
+	c.entries = make(map[object.HashKey]cacheEntry)
+	c.mu.Unlock()
+	return NULL
+}
+
+func (c *ttlCache) size(args ...object.Object) object.Object {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for key := range c.entries {
+		if _, ok := c.lookup(key); ok {
+			n++
+		}
+	}
+	return &object.Integer{Value: int64(n)}
+}
@@ -0,0 +1,296 @@
+// CDP - a minimal Chrome DevTools Protocol client over a WebSocket, for the browser_* builtins
+
+package builtins
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// cdpConn sends commands to one DevTools target and routes the replies
+// back by id. Events are dropped unless someone waits for them.
+type cdpConn struct {
+	ws *wsConn
+
+	mu      sync.Mutex
+	nextID  int64
+	pending map[int64]chan cdpMessage
+	waiters map[string][]chan struct{}
+	err     error // set once the connection is gone
+}
+
+type cdpMessage struct {
+	ID     int64           `json:"id,omitempty"`
+	Method string          `json:"method,omitempty"`
+	Params any             `json:"params,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *struct {
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+func dialCDP(wsURL string) (*cdpConn, error) {
+	ws, err := dialWebSocket(wsURL)
+	if err != nil {
+		return nil, err
+	}
+	c := &cdpConn{
+		ws:      ws,
+		pending: make(map[int64]chan cdpMessage),
+		waiters: make(map[string][]chan struct{}),
+	}
+	go c.readLoop()
+	return c, nil
+}
+
+func (c *cdpConn) readLoop() {
+	for {
+		data, err := c.ws.ReadMessage()
+		if err != nil {
+			c.mu.Lock()
+			c.err = err
+			for id, ch := range c.pending {
+				close(ch)
+				delete(c.pending, id)
+			}
+			c.mu.Unlock()
+			return
+		}
+		var msg cdpMessage
+		if json.Unmarshal(data, &msg) != nil {
+			continue
+		}
+		c.mu.Lock()
+		if msg.ID != 0 {
+			if ch, ok := c.pending[msg.ID]; ok {
+				ch <- msg
+				delete(c.pending, msg.ID)
+			}
+		} else if msg.Method != "" {
+			for _, ch := range c.waiters[msg.Method] {
+				close(ch)
+			}
+			delete(c.waiters, msg.Method)
+		}
+		c.mu.Unlock()
+	}
+}
+
+// call sends method with params and waits up to timeout for the result,
+// which is decoded into result unless that is nil.
+func (c *cdpConn) call(method string, params any, result any, timeout time.Duration) error {
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return fmt.Errorf("browser connection lost: %w", c.err)
+	}
+	c.nextID++
+	id := c.nextID
+	ch := make(chan cdpMessage, 1)
+	c.pending[id] = ch
+	c.mu.Unlock()
+
+	data, _ := json.Marshal(cdpMessage{ID: id, Method: method, Params: params})
+	if err := c.ws.WriteMessage(data); err != nil {
+		return err
+	}
+	select {
+	case msg, ok := <-ch:
+		if !ok {
+			return errors.New("browser connection lost")
+		}
+		if msg.Error != nil {
+			return fmt.Errorf("%s: %s", method, msg.Error.Message)
+		}
+		if result != nil {
+			return json.Unmarshal(msg.Result, result)
+		}
+		return nil
+	case <-time.After(timeout):
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+		return fmt.Errorf("%s: timed out after %s", method, timeout)
+	}
+}
+
+// await returns a channel closed when the event method next arrives.
+// Call it before the command that causes the event.
+func (c *cdpConn) await(method string) <-chan struct{} {
+	ch := make(chan struct{})
+	c.mu.Lock()
+	c.waiters[method] = append(c.waiters[method], ch)
+	c.mu.Unlock()
+	return ch
+}
+
+func (c *cdpConn) Close() error {
+	return c.ws.Close()
+}
+
+// wsConn is the client side of a WebSocket (RFC 6455), just enough for
+// DevTools: text messages, fragmentation, ping and close.
+type wsConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	wmu  sync.Mutex
+}
+
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+func dialWebSocket(rawURL string) (*wsConn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "ws" {
+		return nil, fmt.Errorf("unsupported WebSocket URL %q", rawURL)
+	}
+	conn, err := net.DialTimeout("tcp", u.Host, 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	keyBytes := make([]byte, 16)
+	rand.Read(keyBytes)
+	key := base64.StdEncoding.EncodeToString(keyBytes)
+	fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: %s\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Key: %s\r\nSec-WebSocket-Version: 13\r\n\r\n", u.RequestURI(), u.Host, key)
+
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	sum := sha1.Sum([]byte(key + wsGUID))
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != base64.StdEncoding.EncodeToString(sum[:]) {
+		conn.Close()
+		return nil, fmt.Errorf("WebSocket handshake with %s failed: %s", u.Host, resp.Status)
+	}
+	return &wsConn{conn: conn, r: r}, nil
+}
+
+const (
+	wsText  = 0x1
+	wsClose = 0x8
+	wsPing  = 0x9
+	wsPong  = 0xA
+)
+
+// WriteMessage sends data as one masked text frame.
+func (ws *wsConn) WriteMessage(data []byte) error {
+	return ws.writeFrame(wsText, data)
+}
+
+func (ws *wsConn) writeFrame(opcode byte, data []byte) error {
+	header := []byte{0x80 | opcode, 0}
+	switch n := len(data); {
+	case n < 126:
+		header[1] = byte(n)
+	case n <= 0xFFFF:
+		header[1] = 126
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header[1] = 127
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+	header[1] |= 0x80 // clients must mask
+	mask := make([]byte, 4)
+	rand.Read(mask)
+	header = append(header, mask...)
+	masked := make([]byte, len(data))
+	for i, b := range data {
+		masked[i] = b ^ mask[i%4]
+	}
+
+	ws.wmu.Lock()
+	defer ws.wmu.Unlock()
+	if _, err := ws.conn.Write(header); err != nil {
+		return err
+	}
+	_, err := ws.conn.Write(masked)
+	return err
+}
+
+// ReadMessage returns the next complete data message, answering pings on
+// the way.
+func (ws *wsConn) ReadMessage() ([]byte, error) {
+	var message []byte
+	for {
+		fin, opcode, payload, err := ws.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		switch opcode {
+		case wsPing:
+			ws.writeFrame(wsPong, payload)
+			continue
+		case wsPong:
+			continue
+		case wsClose:
+			return nil, io.EOF
+		}
+		message = append(message, payload...)
+		if fin {
+			return message, nil
+		}
+	}
+}
+
+func (ws *wsConn) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	var head [2]byte
+	if _, err = io.ReadFull(ws.r, head[:]); err != nil {
+		return
+	}
+	fin = head[0]&0x80 != 0
+	opcode = head[0] & 0x0F
+	n := uint64(head[1] & 0x7F)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(ws.r, ext[:]); err != nil {
+			return
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(ws.r, ext[:]); err != nil {
+			return
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	var mask [4]byte
+	masked := head[1]&0x80 != 0
+	if masked {
+		if _, err = io.ReadFull(ws.r, mask[:]); err != nil {
+			return
+		}
+	}
+	payload = make([]byte, n)
+	if _, err = io.ReadFull(ws.r, payload); err != nil {
+		return
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return
+}
+
+func (ws *wsConn) Close() error {
+	ws.writeFrame(wsClose, nil)
+	return ws.conn.Close()
+}
@@ -0,0 +1,84 @@
+// Characters - code point and byte conversions for low-level text processing
+
+package builtins
+
+import (
+	"fmt"
+	"unicode/utf8"
+	"xon/object"
+)
+
+func init() {
+	builtinsMap["ord"] = &object.Builtin{Fn: charOrd}
+	builtinsMap["chr"] = &object.Builtin{Fn: charChr}
+	builtinsMap["bytes"] = &object.Builtin{Fn: strBytes}
+	builtinsMap["str_from_bytes"] = &object.Builtin{Fn: strFromBytes}
+}
+
+// charOrd implements ord(ch): the Unicode code point of a one-character string.
+func charOrd(args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1", len(args))}
+	}
+	s, ok := args[0].(*object.String)
+	if !ok {
+		return &object.Error{Message: fmt.Sprintf("argument to `ord` must be STRING, got %s", args[0].Type())}
+	}
+	if utf8.RuneCountInString(s.Value) != 1 {
+		return &object.Error{Message: fmt.Sprintf("ord expects a single character, got %d", utf8.RuneCountInString(s.Value))}
+	}
+	r, _ := utf8.DecodeRuneInString(s.Value)
+	return &object.Integer{Value: int64(r)}
+}
+
+// charChr implements chr(n): the one-character string for code point n.
+func charChr(args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1", len(args))}
+	}
+	n, ok := args[0].(*object.Integer)
+	if !ok {
+		return &object.Error{Message: fmt.Sprintf("argument to `chr` must be INTEGER, got %s", args[0].Type())}
+	}
+	if n.Value < 0 || n.Value > utf8.MaxRune || !utf8.ValidRune(rune(n.Value)) {
+		return &object.Error{Message: fmt.Sprintf("chr: %d is not a valid code point", n.Value)}
+	}
+	return &object.String{Value: string(rune(n.Value))}
+}
+
+// strBytes implements bytes(str): the UTF-8 encoding as an array of integers 0-255.
+func strBytes(args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1", len(args))}
+	}
+	s, ok := args[0].(*object.String)
+	if !ok {
+		return &object.Error{Message: fmt.Sprintf("argument to `bytes` must be STRING, got %s", args[0].Type())}
+	}
+	elements := make([]object.Object, len(s.Value))
+	for i := 0; i < len(s.Value); i++ {
+		elements[i] = &object.Integer{Value: int64(s.Value[i])}
+	}
+	return &object.Array{Elements: elements}
+}
+
+// strFromBytes implements str_from_bytes(arr), the inverse of bytes. The
+// result is not validated as UTF-8 so binary protocol data round-trips.
+func strFromBytes(args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1", len(args))}
+	}
+	arr, ok := args[0].(*object.Array)
+	if !ok {
+		return &object.Error{Message: fmt.Sprintf("argument to `str_from_bytes` must be ARRAY, got %s", args[0].Type())}
+	}
+	buf := make([]byte, len(arr.Elements))
+	for i, el := range arr.Elements {
+		b, ok := el.(*object.Integer)
+		if !ok || b.Value < 0 || b.Value > 255 {
+			return &object.Error{Message: fmt.Sprintf("str_from_bytes: element %d is not a byte (0-255): %s", i, el.Inspect())}
+		}
+		buf[i] = byte(b.Value)
+	}
+	return &object.String{Value: string(buf)}
+}
@@ -0,0 +1,117 @@
+// Checksum - streaming file hashing and byte-for-byte comparison
+
+package builtins
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"xon/object"
+)
+
+const compareChunkSize = 64 * 1024
+
+func init() {
+	builtinsMap["fs_sha256"] = &object.Builtin{Fn: fsSHA256}
+	builtinsMap["fs_compare"] = &object.Builtin{Fn: fsCompare}
+}
+
+// fsSHA256 implements fs_sha256(path), returning the lowercase hex digest.
+func fsSHA256(args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1", len(args))}
+	}
+	path, ok := args[0].(*object.String)
+	if !ok {
+		return &object.Error{Message: fmt.Sprintf("argument to `fs_sha256` must be STRING, got %s", args[0].Type())}
+	}
+	sum, err := fileSHA256(path.Value)
+	if err != nil {
+		return &object.Error{Message: fmt.Sprintf("could not hash file %s: %s", path.Value, err.Error())}
+	}
+	return &object.String{Value: sum}
+}
+
+// fsCompare implements fs_compare(a, b): true when both files have identical
+// contents. Sizes are checked first so differing files are usually rejected
+// without reading them.
+func fsCompare(args ...object.Object) object.Object {
+	if len(args) != 2 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=2", len(args))}
+	}
+	a, ok1 := args[0].(*object.String)
+	b, ok2 := args[1].(*object.String)
+	if !ok1 || !ok2 {
+		return &object.Error{Message: "arguments to `fs_compare` must be STRING, STRING"}
+	}
+	same, err := filesEqual(a.Value, b.Value)
+	if err != nil {
+		return &object.Error{Message: fmt.Sprintf("could not compare files: %s", err.Error())}
+	}
+	if same {
+		return TRUE
+	}
+	return FALSE
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func filesEqual(a, b string) (bool, error) {
+	fa, err := os.Open(a)
+	if err != nil {
+		return false, err
+	}
+	defer fa.Close()
+	fb, err := os.Open(b)
+	if err != nil {
+		return false, err
+	}
+	defer fb.Close()
+
+	sa, err := fa.Stat()
+	if err != nil {
+		return false, err
+	}
+	sb, err := fb.Stat()
+	if err != nil {
+		return false, err
+	}
+	if sa.Size() != sb.Size() {
+		return false, nil
+	}
+
+	bufA := make([]byte, compareChunkSize)
+	bufB := make([]byte, compareChunkSize)
+	for {
+		na, errA := io.ReadFull(fa, bufA)
+		nb, errB := io.ReadFull(fb, bufB)
+		if !bytes.Equal(bufA[:na], bufB[:nb]) {
+			return false, nil
+		}
+		doneA := errA == io.EOF || errA == io.ErrUnexpectedEOF
+		doneB := errB == io.EOF || errB == io.ErrUnexpectedEOF
+		if doneA || doneB {
+			return doneA == doneB, nil
+		}
+		if errA != nil {
+			return false, errA
+		}
+		if errB != nil {
+			return false, errB
+		}
+	}
+}
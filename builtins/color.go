@@ -0,0 +1,382 @@
+// Colors - parsing, hex/HSL conversion, WCAG contrast and nearest palette match
+
+package builtins
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"xon/object"
+)
+
+func init() {
+	builtinsMap["color_parse"] = &object.Builtin{Fn: colorParse}
+	builtinsMap["color_hex"] = &object.Builtin{Fn: colorHex}
+	builtinsMap["rgb_to_hsl"] = &object.Builtin{Fn: rgbToHSL}
+	builtinsMap["hsl_to_rgb"] = &object.Builtin{Fn: hslToRGB}
+	builtinsMap["color_contrast"] = &object.Builtin{Fn: colorContrast}
+	builtinsMap["color_nearest"] = &object.Builtin{Fn: colorNearest}
+}
+
+// rgba is a color with 0-255 channels and alpha in 0-1.
+type rgba struct {
+	r, g, b int64
+	a       float64
+}
+
+// colorNames are the CSS basic colors, enough for themes written by hand.
+var colorNames = map[string]string{
+	"black": "#000000", "white": "#ffffff", "red": "#ff0000", "lime": "#00ff00",
+	"green": "#008000", "blue": "#0000ff", "yellow": "#ffff00", "cyan": "#00ffff",
+	"magenta": "#ff00ff", "silver": "#c0c0c0", "gray": "#808080", "grey": "#808080",
+	"maroon": "#800000", "olive": "#808000", "purple": "#800080", "teal": "#008080",
+	"navy": "#000080", "orange": "#ffa500",
+}
+
+// toColor accepts "#rgb", "#rrggbb", "#rrggbbaa", "rgb(r, g, b)",
+// "rgba(r, g, b, a)", a color name, a {r, g, b, a?} hash or an [r, g, b, a?]
+// array, so pixels read as arrays compare with theme colors written as text.
+func toColor(name string, obj object.Object) (rgba, *object.Error) {
+	fail := func(format string, a ...any) (rgba, *object.Error) {
+		return rgba{}, &object.Error{Message: fmt.Sprintf("%s: ", name) + fmt.Sprintf(format, a...)}
+	}
+	var parts []object.Object
+	switch v := obj.(type) {
+	case *object.String:
+		c, err := parseColorText(v.Value)
+		if err != nil {
+			return fail("%s", err)
+		}
+		return c, nil
+	case *object.Hash:
+		for _, key := range []string{"r", "g", "b"} {
+			val := getHashValue(v, key)
+			if val == nil {
+				return fail("color hash needs r, g and b, missing %s", key)
+			}
+			parts = append(parts, val)
+		}
+		if val := getHashValue(v, "a"); val != nil {
+			parts = append(parts, val)
+		}
+	case *object.Array:
+		if len(v.Elements) != 3 && len(v.Elements) != 4 {
+			return fail("color array needs 3 or 4 numbers, got %d", len(v.Elements))
+		}
+		parts = v.Elements
+	default:
+		return fail("expected a color STRING, HASH or ARRAY, got %s", obj.Type())
+	}
+	c := rgba{a: 1}
+	for i, p := range parts {
+		f, ok := toFloat(p)
+		if !ok {
+			return fail("color channels must be numbers, got %s", p.Type())
+		}
+		if i == 3 {
+			if f < 0 || f > 1 {
+				return fail("alpha %g is outside 0-1", f)
+			}
+			c.a = f
+			continue
+		}
+		if f < 0 || f > 255 {
+			return fail("channel %g is outside 0-255", f)
+		}
+		ch := int64(math.Round(f))
+		switch i {
+		case 0:
+			c.r = ch
+		case 1:
+			c.g = ch
+		case 2:
+			c.b = ch
+		}
+	}
+	return c, nil
+}
+
+func parseColorText(s string) (rgba, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if hex, ok := colorNames[s]; ok {
+		s = hex
+	}
+	if strings.HasPrefix(s, "#") {
+		hex := s[1:]
+		if len(hex) == 3 || len(hex) == 4 {
+			var long strings.Builder
+			for _, ch := range hex {
+				long.WriteRune(ch)
+				long.WriteRune(ch)
+			}
+			hex = long.String()
+		}
+		if len(hex) != 6 && len(hex) != 8 {
+			return rgba{}, fmt.Errorf("%q is not #rgb, #rrggbb or #rrggbbaa", s)
+		}
+		n, err := strconv.ParseUint(hex, 16, 32)
+		if err != nil {
+			return rgba{}, fmt.Errorf("%q is not a hex color", s)
+		}
+		c := rgba{a: 1}
+		if len(hex) == 8 {
+			c.a = float64(n&0xff) / 255
+			n >>= 8
+		}
+		c.r, c.g, c.b = int64(n>>16&0xff), int64(n>>8&0xff), int64(n&0xff)
+		return c, nil
+	}
+	for _, fn := range []string{"rgba(", "rgb("} {
+		if !strings.HasPrefix(s, fn) || !strings.HasSuffix(s, ")") {
+			continue
+		}
+		fields := strings.Split(s[len(fn):len(s)-1], ",")
+		if len(fields) != 3 && len(fields) != 4 {
+			return rgba{}, fmt.Errorf("%q needs 3 or 4 values", s)
+		}
+		c := rgba{a: 1}
+		for i, field := range fields {
+			f, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+			if err != nil {
+				return rgba{}, fmt.Errorf("%q has a value that is not a number", s)
+			}
+			switch {
+			case i == 3 && (f < 0 || f > 1):
+				return rgba{}, fmt.Errorf("alpha %g is outside 0-1", f)
+			case i == 3:
+				c.a = f
+			case f < 0 || f > 255:
+				return rgba{}, fmt.Errorf("channel %g is outside 0-255", f)
+			case i == 0:
+				c.r = int64(math.Round(f))
+			case i == 1:
+				c.g = int64(math.Round(f))
+			default:
+				c.b = int64(math.Round(f))
+			}
+		}
+		return c, nil
+	}
+	return rgba{}, fmt.Errorf("unknown color %q", s)
+}
+
+func (c rgba) hash() *object.Hash {
+	h := &object.Hash{Pairs: make(map[object.HashKey]object.HashPair)}
+	setHashPair(h, "r", &object.Integer{Value: c.r})
+	setHashPair(h, "g", &object.Integer{Value: c.g})
+	setHashPair(h, "b", &object.Integer{Value: c.b})
+	setHashPair(h, "a", &object.Float{Value: c.a})
+	return h
+}
+
+// luminance is the WCAG relative luminance of the color.
+func (c rgba) luminance() float64 {
+	lin := func(ch int64) float64 {
+		v := float64(ch) / 255
+		if v <= 0.03928 {
+			return v / 12.92
+		}
+		return math.Pow((v+0.055)/1.055, 2.4)
+	}
+	return 0.2126*lin(c.r) + 0.7152*lin(c.g) + 0.0722*lin(c.b)
+}
+
+// colorParse implements color_parse(c): {r, g, b, a}.
+func colorParse(args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1", len(args))}
+	}
+	c, errObj := toColor("color_parse", args[0])
+	if errObj != nil {
+		return errObj
+	}
+	return c.hash()
+}
+
+// colorHex implements color_hex(c): "#rrggbb", or "#rrggbbaa" when the
+// color is not opaque.
+func colorHex(args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1", len(args))}
+	}
+	c, errObj := toColor("color_hex", args[0])
+	if errObj != nil {
+		return errObj
+	}
+	if c.a < 1 {
+		return &object.String{Value: fmt.Sprintf("#%02x%02x%02x%02x", c.r, c.g, c.b, int64(math.Round(c.a*255)))}
+	}
+	return &object.String{Value: fmt.Sprintf("#%02x%02x%02x", c.r, c.g, c.b)}
+}
+
+// rgbToHSL implements rgb_to_hsl(c): {h, s, l, a} with h in degrees and
+// s and l in 0-1.
+func rgbToHSL(args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1", len(args))}
+	}
+	c, errObj := toColor("rgb_to_hsl", args[0])
+	if errObj != nil {
+		return errObj
+	}
+	r, g, b := float64(c.r)/255, float64(c.g)/255, float64(c.b)/255
+	hi, lo := math.Max(r, math.Max(g, b)), math.Min(r, math.Min(g, b))
+	l := (hi + lo) / 2
+	var h, s float64
+	if d := hi - lo; d > 0 {
+		s = d / (1 - math.Abs(2*l-1))
+		switch hi {
+		case r:
+			h = math.Mod((g-b)/d+6, 6)
+		case g:
+			h = (b-r)/d + 2
+		default:
+			h = (r-g)/d + 4
+		}
+		h *= 60
+	}
+	round := func(f float64) float64 { return math.Round(f*1000) / 1000 }
+	out := &object.Hash{Pairs: make(map[object.HashKey]object.HashPair)}
+	setHashPair(out, "h", &object.Float{Value: round(h)})
+	setHashPair(out, "s", &object.Float{Value: round(s)})
+	setHashPair(out, "l", &object.Float{Value: round(l)})
+	setHashPair(out, "a", &object.Float{Value: c.a})
+	return out
+}
+
+// hslToRGB implements hsl_to_rgb(hsl) and hsl_to_rgb(h, s, l): h in
+// degrees, s and l in 0-1. It returns {r, g, b, a} like color_parse.
+func hslToRGB(args ...object.Object) object.Object {
+	var parts []object.Object
+	alpha := 1.0
+	switch len(args) {
+	case 1:
+		h, ok := args[0].(*object.Hash)
+		if !ok {
+			return &object.Error{Message: fmt.Sprintf("argument to `hsl_to_rgb` must be HASH, got %s", args[0].Type())}
+		}
+		for _, key := range []string{"h", "s", "l"} {
+			val := getHashValue(h, key)
+			if val == nil {
+				return &object.Error{Message: fmt.Sprintf("hsl_to_rgb: hash needs h, s and l, missing %s", key)}
+			}
+			parts = append(parts, val)
+		}
+		if val := getHashValue(h, "a"); val != nil {
+			a, ok := toFloat(val)
+			if !ok || a < 0 || a > 1 {
+				return &object.Error{Message: "hsl_to_rgb: a must be a number in 0-1"}
+			}
+			alpha = a
+		}
+	case 3:
+		parts = args
+	default:
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1 or 3", len(args))}
+	}
+	var hsl [3]float64
+	for i, p := range parts {
+		f, ok := toFloat(p)
+		if !ok {
+			return &object.Error{Message: fmt.Sprintf("hsl_to_rgb: h, s and l must be numbers, got %s", p.Type())}
+		}
+		if i > 0 && (f < 0 || f > 1) {
+			return &object.Error{Message: fmt.Sprintf("hsl_to_rgb: s and l must be in 0-1, got %g", f)}
+		}
+		hsl[i] = f
+	}
+	h, s, l := math.Mod(math.Mod(hsl[0], 360)+360, 360), hsl[1], hsl[2]
+	chroma := (1 - math.Abs(2*l-1)) * s
+	x := chroma * (1 - math.Abs(math.Mod(h/60, 2)-1))
+	var r, g, b float64
+	switch {
+	case h < 60:
+		r, g = chroma, x
+	case h < 120:
+		r, g = x, chroma
+	case h < 180:
+		g, b = chroma, x
+	case h < 240:
+		g, b = x, chroma
+	case h < 300:
+		r, b = x, chroma
+	default:
+		r, b = chroma, x
+	}
+	m := l - chroma/2
+	ch := func(v float64) int64 { return int64(math.Round((v + m) * 255)) }
+	return rgba{r: ch(r), g: ch(g), b: ch(b), a: alpha}.hash()
+}
+
+// colorContrast implements color_contrast(a, b): the WCAG contrast ratio,
+// from 1 for equal colors to 21 for black on white. Text needs 4.5 for AA.
+func colorContrast(args ...object.Object) object.Object {
+	if len(args) != 2 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=2", len(args))}
+	}
+	a, errObj := toColor("color_contrast", args[0])
+	if errObj != nil {
+		return errObj
+	}
+	b, errObj := toColor("color_contrast", args[1])
+	if errObj != nil {
+		return errObj
+	}
+	la, lb := a.luminance(), b.luminance()
+	if la < lb {
+		la, lb = lb, la
+	}
+	return &object.Float{Value: math.Round((la+0.05)/(lb+0.05)*100) / 100}
+}
+
+// colorNearest implements color_nearest(c, palette): the palette entry
+// closest to c, judged by a red-mean weighted RGB distance that tracks
+// perceived difference better than plain Euclidean distance. For an array
+// palette the entry itself is returned; for a hash palette, its name.
+func colorNearest(args ...object.Object) object.Object {
+	if len(args) != 2 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=2", len(args))}
+	}
+	target, errObj := toColor("color_nearest", args[0])
+	if errObj != nil {
+		return errObj
+	}
+	var keys, entries []object.Object
+	switch p := args[1].(type) {
+	case *object.Array:
+		entries = p.Elements
+		keys = p.Elements
+	case *object.Hash:
+		pairs := make([]object.HashPair, 0, len(p.Pairs))
+		for _, pair := range p.Pairs {
+			pairs = append(pairs, pair)
+		}
+		// Sorted so that ties go to the same name every run.
+		sort.Slice(pairs, func(i, j int) bool { return pairs[i].Key.Inspect() < pairs[j].Key.Inspect() })
+		for _, pair := range pairs {
+			keys = append(keys, pair.Key)
+			entries = append(entries, pair.Value)
+		}
+	default:
+		return &object.Error{Message: fmt.Sprintf("second argument to `color_nearest` must be ARRAY or HASH, got %s", args[1].Type())}
+	}
+	if len(entries) == 0 {
+		return &object.Error{Message: "color_nearest: the palette is empty"}
+	}
+	best, bestDist := -1, math.Inf(1)
+	for i, entry := range entries {
+		c, errObj := toColor("color_nearest", entry)
+		if errObj != nil {
+			return errObj
+		}
+		rmean := float64(target.r+c.r) / 2
+		dr, dg, db := float64(target.r-c.r), float64(target.g-c.g), float64(target.b-c.b)
+		dist := (2+rmean/256)*dr*dr + 4*dg*dg + (2+(255-rmean)/256)*db*db
+		if dist < bestDist {
+			best, bestDist = i, dist
+		}
+	}
+	return keys[best]
+}
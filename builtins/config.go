@@ -0,0 +1,452 @@
+// Config - config_load: defaults, a JSON or TOML file, .env, environment variables and flags merged into one hash
+
+package builtins
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"xon/object"
+)
+
+func init() {
+	builtinsMap["config_load"] = &object.Builtin{Fn: configLoad}
+}
+
+// configLoad implements config_load(defaults, options?). Each later source
+// overrides the earlier ones:
+//
+//  1. defaults, a hash of every setting and its default value
+//  2. the config file, options.file (.json or .toml); skipped when missing
+//  3. the dotenv file, options.dotenv (default ".env"); skipped when missing
+//  4. environment variables, named options.env_prefix plus the key in
+//     upper case: prefix "APP_" reads db_host from APP_DB_HOST
+//  5. flags among the script's arguments (or options.args): --db-host=x,
+//     --db_host x, and --debug or --no-debug for a boolean
+//
+// A table (a hash value) in the defaults is merged key by key with the one
+// in the config file, and each key inside it can be set on its own: the
+// environment variable joins the keys with _, so APP_DB_PORT sets db.port,
+// and the flag joins them with ., - or _, as in --db.port, --db-port or
+// --db_port. A top-level key of the same name, such as db_port, wins.
+// Only keys named in the defaults or the config file can be set by the
+// .env file, the environment or flags; other flags are left for argparse.
+// Text from those sources is converted to the type of the key's current
+// value, so "8080" becomes 8080 when the default is an integer, and a
+// comma-separated list becomes an array.
+func configLoad(args ...object.Object) object.Object {
+	if len(args) != 1 && len(args) != 2 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1 or 2", len(args))}
+	}
+	defaults, ok := args[0].(*object.Hash)
+	if !ok {
+		return &object.Error{Message: fmt.Sprintf("config_load: defaults must be HASH, got %s", args[0].Type())}
+	}
+	file, dotenv, prefix, argv := "", ".env", "", ScriptArgs
+	if len(args) == 2 {
+		opts, ok := args[1].(*object.Hash)
+		if !ok {
+			return &object.Error{Message: fmt.Sprintf("config_load: options must be HASH, got %s", args[1].Type())}
+		}
+		file = getHashStr(opts, "file")
+		if v := getHashValue(opts, "dotenv"); v != nil {
+			dotenv = getHashStr(opts, "dotenv") // null or "" turns it off
+		}
+		prefix = getHashStr(opts, "env_prefix")
+		if v := getHashValue(opts, "args"); v != nil {
+			arr, ok := v.(*object.Array)
+			if !ok {
+				return &object.Error{Message: "config_load: option \"args\" must be an array of strings"}
+			}
+			argv = make([]string, len(arr.Elements))
+			for i, el := range arr.Elements {
+				argv[i] = el.Inspect()
+			}
+		}
+	}
+
+	config := make(map[string]object.Object)
+	var keys []string
+	set := func(key string, value object.Object) {
+		if _, ok := config[key]; !ok {
+			keys = append(keys, key)
+		}
+		config[key] = value
+	}
+	for _, pair := range defaults.Pairs {
+		key, ok := pair.Key.(*object.String)
+		if !ok {
+			return &object.Error{Message: fmt.Sprintf("config_load: keys must be STRING, got %s", pair.Key.Type())}
+		}
+		set(key.Value, pair.Value)
+	}
+
+	if file != "" {
+		values, err := readConfigFile(file)
+		if err != nil {
+			return &object.Error{Message: "config_load: " + err.Error()}
+		}
+		for _, pair := range values.Pairs {
+			key := pair.Key.(*object.String).Value
+			value := pair.Value
+			if base, ok := config[key]; ok {
+				if value, err = mergeConfigValue(base, value); err != nil {
+					return &object.Error{Message: fmt.Sprintf("config_load: %s: %s: %s", file, key, err)}
+				}
+			}
+			set(key, value)
+		}
+	}
+
+	// Tables are copied, so setting a key inside one below leaves the
+	// hashes passed in alone.
+	for key, value := range config {
+		if table, ok := value.(*object.Hash); ok {
+			config[key] = copyConfigTable(table)
+		}
+	}
+	paths := configPaths(config, keys)
+
+	var dotenvVars map[string]string
+	if dotenv != "" {
+		src, err := os.ReadFile(dotenv)
+		if err != nil && !os.IsNotExist(err) {
+			return &object.Error{Message: "config_load: " + err.Error()}
+		}
+		if dotenvVars, err = parseDotenv(string(src)); err != nil {
+			return &object.Error{Message: fmt.Sprintf("config_load: %s: %s", dotenv, err)}
+		}
+	}
+	for _, path := range paths {
+		base := getConfig(config, path)
+		if base == nil {
+			continue // its table was replaced by one without it
+		}
+		name := prefix + strings.ToUpper(strings.Join(path, "_"))
+		source := "environment variable " + name
+		text, ok := os.LookupEnv(name)
+		if !ok {
+			text, ok = dotenvVars[name]
+			source = dotenv + ": " + name
+		}
+		if !ok {
+			continue
+		}
+		value, err := coerceConfig(base, text)
+		if err != nil {
+			return &object.Error{Message: fmt.Sprintf("config_load: %s: %s", source, err)}
+		}
+		setConfig(config, path, value)
+	}
+
+	flags := make(map[string][]string)
+	for _, path := range paths {
+		if name := strings.Join(path, "_"); flags[name] == nil {
+			flags[name] = path
+		}
+	}
+
+	for i := 0; i < len(argv); i++ {
+		arg := argv[i]
+		if arg == "--" {
+			break
+		}
+		if !strings.HasPrefix(arg, "--") {
+			continue
+		}
+		name, text, hasValue := strings.Cut(arg[2:], "=")
+		key := strings.NewReplacer("-", "_", ".", "_").Replace(name)
+		path := flags[key]
+		base := getConfig(config, path)
+		if base == nil && strings.HasPrefix(key, "no_") && !hasValue {
+			if negated := flags[key[3:]]; negated != nil {
+				if _, isBool := getConfig(config, negated).(*object.Boolean); isBool {
+					setConfig(config, negated, FALSE)
+				}
+			}
+			continue
+		}
+		if base == nil {
+			continue
+		}
+		if _, isBool := base.(*object.Boolean); isBool && !hasValue {
+			setConfig(config, path, TRUE)
+			continue
+		}
+		if !hasValue {
+			if i+1 >= len(argv) {
+				return &object.Error{Message: fmt.Sprintf("config_load: flag --%s needs a value", name)}
+			}
+			i++
+			text = argv[i]
+		}
+		value, err := coerceConfig(base, text)
+		if err != nil {
+			return &object.Error{Message: fmt.Sprintf("config_load: flag --%s: %s", name, err)}
+		}
+		setConfig(config, path, value)
+	}
+
+	result := &object.Hash{Pairs: make(map[object.HashKey]object.HashPair)}
+	for key, value := range config {
+		setHashPair(result, key, value)
+	}
+	return result
+}
+
+// readConfigFile parses a .json or .toml file into a hash; a missing file
+// is an empty one.
+func readConfigFile(path string) (*object.Hash, error) {
+	lower := strings.ToLower(path)
+	if !strings.HasSuffix(lower, ".toml") && !strings.HasSuffix(lower, ".json") {
+		return nil, fmt.Errorf("%s: config files must end in .json or .toml", path)
+	}
+	src, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &object.Hash{Pairs: make(map[object.HashKey]object.HashPair)}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if strings.HasSuffix(lower, ".toml") {
+		h, err := parseTOML(string(src))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+		return h, nil
+	}
+	var raw interface{}
+	if err := json.Unmarshal(src, &raw); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	h, ok := rawToObj(raw).(*object.Hash)
+	if !ok {
+		return nil, fmt.Errorf("%s: expected a JSON object", path)
+	}
+	return h, nil
+}
+
+// mergeConfigValue checks a value from the config file against the key's
+// default: text is converted like an environment variable, an integer
+// where a float is expected becomes a float, and a table is merged into
+// the default table key by key.
+func mergeConfigValue(base, value object.Object) (object.Object, error) {
+	switch v := value.(type) {
+	case *object.String:
+		return coerceConfig(base, v.Value)
+	case *object.Integer:
+		if _, ok := base.(*object.Float); ok {
+			return &object.Float{Value: float64(v.Value)}, nil
+		}
+	case *object.Hash:
+		table, ok := base.(*object.Hash)
+		if !ok {
+			break
+		}
+		merged := copyConfigTable(table)
+		for hk, pair := range v.Pairs {
+			value := pair.Value
+			if old, ok := merged.Pairs[hk]; ok {
+				var err error
+				if value, err = mergeConfigValue(old.Value, value); err != nil {
+					return nil, fmt.Errorf("%s: %s", pair.Key.Inspect(), err)
+				}
+			}
+			merged.Pairs[hk] = object.HashPair{Key: pair.Key, Value: value}
+		}
+		return merged, nil
+	}
+	return value, nil
+}
+
+// copyConfigTable copies a table and the tables inside it.
+func copyConfigTable(table *object.Hash) *object.Hash {
+	out := &object.Hash{Pairs: make(map[object.HashKey]object.HashPair, len(table.Pairs))}
+	for hk, pair := range table.Pairs {
+		if inner, ok := pair.Value.(*object.Hash); ok {
+			pair.Value = copyConfigTable(inner)
+		}
+		out.Pairs[hk] = pair
+	}
+	return out
+}
+
+// configPaths lists every key that can be overridden, as the keys leading
+// to it: the top-level keys first, in order, then the keys in each table
+// after the table itself, sorted.
+func configPaths(config map[string]object.Object, keys []string) [][]string {
+	var paths [][]string
+	for _, key := range keys {
+		paths = append(paths, []string{key})
+	}
+	for i := 0; i < len(paths); i++ {
+		table, ok := getConfig(config, paths[i]).(*object.Hash)
+		if !ok {
+			continue
+		}
+		var names []string
+		for _, pair := range table.Pairs {
+			if name, ok := pair.Key.(*object.String); ok {
+				names = append(names, name.Value)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			paths = append(paths, append(slices.Clip(paths[i]), name))
+		}
+	}
+	return paths
+}
+
+// getConfig returns the value at path, or nil when there is none.
+func getConfig(config map[string]object.Object, path []string) object.Object {
+	if len(path) == 0 {
+		return nil
+	}
+	value := config[path[0]]
+	for _, key := range path[1:] {
+		table, ok := value.(*object.Hash)
+		if !ok {
+			return nil
+		}
+		value = getHashValue(table, key)
+	}
+	return value
+}
+
+// setConfig stores value at path, which getConfig has found.
+func setConfig(config map[string]object.Object, path []string, value object.Object) {
+	if len(path) == 1 {
+		config[path[0]] = value
+		return
+	}
+	table := getConfig(config, path[:len(path)-1]).(*object.Hash)
+	setHashPair(table, path[len(path)-1], value)
+}
+
+// coerceConfig converts text to the type of base. A null or string base
+// keeps the text.
+func coerceConfig(base object.Object, text string) (object.Object, error) {
+	switch b := base.(type) {
+	case *object.Integer:
+		n, err := strconv.ParseInt(strings.TrimSpace(text), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("expected an integer, got %q", text)
+		}
+		return &object.Integer{Value: n}, nil
+	case *object.Float:
+		f, err := strconv.ParseFloat(strings.TrimSpace(text), 64)
+		if err != nil {
+			return nil, fmt.Errorf("expected a number, got %q", text)
+		}
+		return &object.Float{Value: f}, nil
+	case *object.Boolean:
+		switch strings.ToLower(strings.TrimSpace(text)) {
+		case "true", "1", "yes", "on":
+			return TRUE, nil
+		case "false", "0", "no", "off", "":
+			return FALSE, nil
+		}
+		return nil, fmt.Errorf("expected true or false, got %q", text)
+	case *object.Array:
+		var elements []object.Object
+		var elementBase object.Object = NULL
+		if len(b.Elements) > 0 {
+			elementBase = b.Elements[0]
+		}
+		for _, part := range strings.Split(text, ",") {
+			if part = strings.TrimSpace(part); part == "" {
+				continue
+			}
+			el, err := coerceConfig(elementBase, part)
+			if err != nil {
+				return nil, err
+			}
+			elements = append(elements, el)
+		}
+		return &object.Array{Elements: elements}, nil
+	case *object.Hash:
+		var raw interface{}
+		if err := json.Unmarshal([]byte(text), &raw); err == nil {
+			if h, ok := rawToObj(raw).(*object.Hash); ok {
+				return h, nil
+			}
+		}
+		return nil, fmt.Errorf("expected a JSON object, got %q", text)
+	}
+	return &object.String{Value: text}, nil
+}
+
+// parseDotenv reads KEY=value lines. Blank lines and # comments are
+// skipped and "export " is allowed in front. A value may be "double
+// quoted" with \n, \t, \" and \\ escapes, 'single quoted' as is, or bare,
+// where a " #" starts a comment.
+func parseDotenv(src string) (map[string]string, error) {
+	vars := make(map[string]string)
+	for n, line := range strings.Split(src, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" || strings.ContainsAny(key, " \t") {
+			return nil, fmt.Errorf("line %d: expected KEY=value", n+1)
+		}
+		value = strings.TrimSpace(value)
+		switch {
+		case strings.HasPrefix(value, `"`):
+			unquoted, rest, err := dotenvQuoted(value[1:])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", n+1, err)
+			}
+			if rest = strings.TrimSpace(rest); rest != "" && rest[0] != '#' {
+				return nil, fmt.Errorf("line %d: unexpected %q after the value", n+1, rest)
+			}
+			value = unquoted
+		case strings.HasPrefix(value, "'"):
+			end := strings.IndexByte(value[1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated string", n+1)
+			}
+			value = value[1 : end+1]
+		default:
+			if i := strings.Index(value, " #"); i >= 0 {
+				value = strings.TrimSpace(value[:i])
+			}
+		}
+		vars[key] = value
+	}
+	return vars, nil
+}
+
+// dotenvQuoted reads a double-quoted value after its opening quote and
+// returns it unescaped with the text after the closing quote.
+func dotenvQuoted(s string) (value, rest string, err error) {
+	var out strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"':
+			return out.String(), s[i+1:], nil
+		case c == '\\' && i+1 < len(s):
+			i++
+			switch s[i] {
+			case 'n':
+				out.WriteByte('\n')
+			case 't':
+				out.WriteByte('\t')
+			case 'r':
+				out.WriteByte('\r')
+			default:
+				out.WriteByte(s[i])
+			}
+		default:
+			out.WriteByte(c)
+		}
+	}
+	return "", "", fmt.Errorf("unterminated string")
+}
@@ -0,0 +1,150 @@
+// Console - input() with defaults, hidden entry and validation, plus non-blocking key polling
+
+package builtins
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"xon/object"
+)
+
+func init() {
+	builtinsMap["key_pressed"] = &object.Builtin{Fn: keyPressed}
+}
+
+// consoleInput implements input(prompt?, options?). Options is a hash with
+// "default" (returned on an empty line or EOF), "hidden" (no echo),
+// "number" (re-prompt until the line parses as INTEGER or FLOAT) and
+// "choices" (re-prompt until the line matches one of the array's values).
+func consoleInput(args ...object.Object) object.Object {
+	if len(args) > 2 {
+		return &object.Error{Message: fmt.Sprintf("input expects at most 2 arguments (prompt, options), got %d", len(args))}
+	}
+	prompt := ""
+	if len(args) >= 1 {
+		if p, ok := args[0].(*object.String); ok {
+			prompt = p.Value
+		}
+	}
+	if len(args) < 2 {
+		fmt.Print(prompt)
+		line, _ := readConsoleLine(false)
+		return &object.String{Value: line}
+	}
+
+	opts, ok := args[1].(*object.Hash)
+	if !ok {
+		return &object.Error{Message: "input options must be a hash"}
+	}
+	def := getHashValue(opts, "default")
+	hidden := getHashBool(opts, "hidden")
+	number := getHashBool(opts, "number")
+	choices := getHashArray(opts, "choices")
+
+	names := make([]string, len(choices))
+	for i, c := range choices {
+		names[i] = c.Inspect()
+	}
+	if len(names) > 0 {
+		prompt += "(" + strings.Join(names, "/") + ") "
+	}
+	if def != nil {
+		prompt += "[" + def.Inspect() + "] "
+	}
+
+	for {
+		fmt.Print(prompt)
+		line, ok := readConsoleLine(hidden)
+		if !ok {
+			if def != nil {
+				return def
+			}
+			return NULL
+		}
+		if def != nil && strings.TrimSpace(line) == "" {
+			return def
+		}
+
+		if number {
+			clean := strings.TrimSpace(line)
+			if i, err := strconv.ParseInt(clean, 10, 64); err == nil {
+				return &object.Integer{Value: i}
+			}
+			if f, err := strconv.ParseFloat(clean, 64); err == nil {
+				return &object.Float{Value: f}
+			}
+			fmt.Println("Please enter a number.")
+			continue
+		}
+
+		if len(choices) > 0 {
+			clean := strings.TrimSpace(line)
+			for i, name := range names {
+				if name == clean {
+					return choices[i]
+				}
+			}
+			fmt.Printf("Please choose one of: %s\n", strings.Join(names, ", "))
+			continue
+		}
+
+		return &object.String{Value: line}
+	}
+}
+
+// readConsoleLine reads one line from stdin, optionally with echo turned off.
+// It reports false at EOF.
+func readConsoleLine(hidden bool) (string, bool) {
+	if hidden {
+		restore := disableConsoleEcho()
+		defer restore()
+		// Echo is off, so the user's Enter did not move to a new line.
+		defer fmt.Println()
+	}
+	if stdinScanner.Scan() {
+		return stdinScanner.Text(), true
+	}
+	return "", false
+}
+
+// keyPressed returns the pending key as a string without blocking, or null
+// when no key is waiting. Arrows and common control keys are returned by name.
+func keyPressed(args ...object.Object) object.Object {
+	if len(args) != 0 {
+		return &object.Error{Message: fmt.Sprintf("key_pressed expects no arguments, got %d", len(args))}
+	}
+	ch, ok, err := readKey()
+	if err != nil {
+		return &object.Error{Message: err.Error()}
+	}
+	if !ok {
+		return NULL
+	}
+	if ch == 0 || ch == 0xE0 {
+		// Extended key: a second read returns its scan code.
+		scan, _, _ := readKey()
+		switch scan {
+		case 72:
+			return &object.String{Value: "up"}
+		case 80:
+			return &object.String{Value: "down"}
+		case 75:
+			return &object.String{Value: "left"}
+		case 77:
+			return &object.String{Value: "right"}
+		}
+		return &object.String{Value: fmt.Sprintf("scan:%d", scan)}
+	}
+	switch ch {
+	case '\r':
+		return &object.String{Value: "enter"}
+	case 27:
+		return &object.String{Value: "escape"}
+	case 8:
+		return &object.String{Value: "backspace"}
+	case '\t':
+		return &object.String{Value: "tab"}
+	}
+	return &object.String{Value: string(rune(ch))}
+}
@@ -0,0 +1,112 @@
+// Context - cancellation scopes shared by spawned tasks, sleeps and HTTP requests
+
+package builtins
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+	"xon/object"
+)
+
+func init() {
+	builtinsMap["ctx_new"] = &object.Builtin{Fn: ctxNew}
+	builtinsMap["ctx_cancel"] = &object.Builtin{Fn: ctxCancel}
+	builtinsMap["ctx_done"] = &object.Builtin{Fn: ctxDone}
+	// with_timeout runs its function in a VM of its own; see vm.withTimeout.
+	builtinsMap["with_timeout"] = &object.Builtin{Fn: vmOnly("with_timeout")}
+}
+
+// ctxNew implements ctx_new(options?). Options: "parent", a context whose
+// cancellation also cancels this one; "timeout", in milliseconds; and
+// "interrupt", which cancels the context when the script gets Ctrl+C
+// instead of letting it end the process.
+func ctxNew(args ...object.Object) object.Object {
+	if len(args) > 1 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=0 or 1", len(args))}
+	}
+	parent := context.Background()
+	var timeout int64
+	interrupt := false
+	if len(args) == 1 {
+		opts, ok := args[0].(*object.Hash)
+		if !ok {
+			return &object.Error{Message: fmt.Sprintf("argument to `ctx_new` must be HASH, got %s", args[0].Type())}
+		}
+		if v := getHashValue(opts, "parent"); v != nil {
+			p, ok := v.(*object.Context)
+			if !ok {
+				return &object.Error{Message: fmt.Sprintf("ctx_new option \"parent\" must be a context, got %s", v.Type())}
+			}
+			parent = p.Ctx
+		}
+		timeout = getHashInt(opts, "timeout")
+		interrupt = getHashBool(opts, "interrupt")
+	}
+
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(parent, time.Duration(timeout)*time.Millisecond)
+	} else {
+		ctx, cancel = context.WithCancel(parent)
+	}
+	if interrupt {
+		// NotifyContext's stop cancels ctx as well as the signal handler.
+		var stop context.CancelFunc
+		ctx, stop = signal.NotifyContext(ctx, os.Interrupt)
+		outer := cancel
+		cancel = func() {
+			stop()
+			outer()
+		}
+	}
+	return &object.Context{Ctx: ctx, Cancel: cancel}
+}
+
+// ctxCancel implements ctx_cancel(ctx). Canceling a context cancels every
+// context made with it as "parent"; canceling twice does nothing.
+func ctxCancel(args ...object.Object) object.Object {
+	c, errObj := contextArg("ctx_cancel", args)
+	if errObj != nil {
+		return errObj
+	}
+	c.Cancel()
+	return NULL
+}
+
+// ctxDone implements ctx_done(ctx): true once the context was canceled or
+// its timeout passed.
+func ctxDone(args ...object.Object) object.Object {
+	c, errObj := contextArg("ctx_done", args)
+	if errObj != nil {
+		return errObj
+	}
+	return boolToObj(c.Ctx.Err() != nil)
+}
+
+func contextArg(name string, args []object.Object) (*object.Context, *object.Error) {
+	if len(args) != 1 {
+		return nil, &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1", len(args))}
+	}
+	c, ok := args[0].(*object.Context)
+	if !ok {
+		return nil, &object.Error{Message: fmt.Sprintf("argument to `%s` must be CONTEXT, got %s", name, args[0].Type())}
+	}
+	return c, nil
+}
+
+// optionalContext returns the context passed as the optional argument at
+// index i, or context.Background() when it is absent.
+func optionalContext(name string, args []object.Object, i int) (context.Context, *object.Error) {
+	if len(args) <= i {
+		return context.Background(), nil
+	}
+	c, ok := args[i].(*object.Context)
+	if !ok {
+		return nil, &object.Error{Message: fmt.Sprintf("argument %d to `%s` must be CONTEXT, got %s", i+1, name, args[i].Type())}
+	}
+	return c.Ctx, nil
+}
@@ -0,0 +1,157 @@
+// Conversions - explicit casts (to_int, to_float, to_str, to_bool) and try_int/try_float for parsing user input
+//
+// Implicit conversions are limited to two rules, applied by the VM:
+// INTEGER and FLOAT mix as FLOAT, and `+` with a STRING on either side turns
+// the other operand into text the way to_str does. == and != between
+// different types are false and true; any other operator on mismatched types
+// is an error, and these builtins are the way to convert.
+
+package builtins
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"xon/object"
+)
+
+func init() {
+	builtinsMap["to_int"] = &object.Builtin{Fn: toInt}
+	builtinsMap["to_float"] = &object.Builtin{Fn: toFloat64}
+	builtinsMap["to_str"] = &object.Builtin{Fn: toStr}
+	builtinsMap["to_bool"] = &object.Builtin{Fn: toBool}
+	builtinsMap["try_int"] = &object.Builtin{Fn: tryConvert("try_int", convertInt)}
+	builtinsMap["try_float"] = &object.Builtin{Fn: tryConvert("try_float", convertFloat)}
+}
+
+// toInt implements to_int(x): floats truncate toward zero, strings are
+// parsed as base-10 integers after trimming spaces, booleans become 1 or 0.
+func toInt(args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1", len(args))}
+	}
+	result, err := convertInt(args[0])
+	if err != nil {
+		return &object.Error{Message: "to_int: " + err.Error()}
+	}
+	return result
+}
+
+// toFloat64 implements to_float(x) for integers, numeric strings and booleans.
+func toFloat64(args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1", len(args))}
+	}
+	result, err := convertFloat(args[0])
+	if err != nil {
+		return &object.Error{Message: "to_float: " + err.Error()}
+	}
+	return result
+}
+
+// toStr implements to_str(x): strings are returned as they are, everything
+// else as it prints, the same text `"" + x` produces.
+func toStr(args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1", len(args))}
+	}
+	if s, ok := args[0].(*object.String); ok {
+		return s
+	}
+	return &object.String{Value: args[0].Inspect()}
+}
+
+// toBool implements to_bool(x). Unlike bool(x), which reports truthiness,
+// it parses strings ("true", "false", "1", "0", "yes", "no", any case),
+// treats numbers as true when non-zero and null as false, and rejects
+// everything else.
+func toBool(args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1", len(args))}
+	}
+	switch arg := args[0].(type) {
+	case *object.Boolean:
+		return arg
+	case *object.Integer:
+		return boolToObj(arg.Value != 0)
+	case *object.Float:
+		return boolToObj(arg.Value != 0)
+	case *object.Null:
+		return FALSE
+	case *object.String:
+		switch strings.ToLower(strings.TrimSpace(arg.Value)) {
+		case "true", "1", "yes":
+			return TRUE
+		case "false", "0", "no":
+			return FALSE
+		}
+		return &object.Error{Message: fmt.Sprintf("to_bool: cannot parse %q as a boolean", arg.Value)}
+	}
+	return &object.Error{Message: fmt.Sprintf("to_bool: cannot convert %s to BOOLEAN", args[0].Type())}
+}
+
+// tryConvert builds try_int and try_float: convert(x), or the optional
+// default (null when omitted) where the conversion fails.
+func tryConvert(name string, convert func(object.Object) (object.Object, error)) object.BuiltinFunction {
+	return func(args ...object.Object) object.Object {
+		if len(args) != 1 && len(args) != 2 {
+			return &object.Error{Message: fmt.Sprintf("wrong number of arguments to `%s`. got=%d, want=1 or 2", name, len(args))}
+		}
+		result, err := convert(args[0])
+		if err == nil {
+			return result
+		}
+		if len(args) == 2 {
+			return args[1]
+		}
+		return NULL
+	}
+}
+
+func convertInt(obj object.Object) (object.Object, error) {
+	switch arg := obj.(type) {
+	case *object.Integer:
+		return arg, nil
+	case *object.Float:
+		if math.IsNaN(arg.Value) || math.IsInf(arg.Value, 0) || math.Abs(arg.Value) >= math.MaxInt64 {
+			return nil, fmt.Errorf("%s does not fit in an INTEGER", arg.Inspect())
+		}
+		return &object.Integer{Value: int64(arg.Value)}, nil
+	case *object.Boolean:
+		if arg.Value {
+			return &object.Integer{Value: 1}, nil
+		}
+		return &object.Integer{Value: 0}, nil
+	case *object.String:
+		n, err := strconv.ParseInt(strings.TrimSpace(arg.Value), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse %q as an integer", arg.Value)
+		}
+		return &object.Integer{Value: n}, nil
+	}
+	return nil, fmt.Errorf("cannot convert %s to INTEGER", obj.Type())
+}
+
+func convertFloat(obj object.Object) (object.Object, error) {
+	switch arg := obj.(type) {
+	case *object.Float:
+		return arg, nil
+	case *object.Integer:
+		return &object.Float{Value: float64(arg.Value)}, nil
+	case *object.Boolean:
+		if arg.Value {
+			return &object.Float{Value: 1}, nil
+		}
+		return &object.Float{Value: 0}, nil
+	case *object.String:
+		// ParseFloat also reads "nan", "inf" and "infinity", which are not
+		// numbers a user types in on purpose.
+		f, err := strconv.ParseFloat(strings.TrimSpace(arg.Value), 64)
+		if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, fmt.Errorf("cannot parse %q as a number", arg.Value)
+		}
+		return &object.Float{Value: f}, nil
+	}
+	return nil, fmt.Errorf("cannot convert %s to FLOAT", obj.Type())
+}
@@ -0,0 +1,90 @@
+// Crash reports - `--crash-report`: what a script was doing when it stopped on an uncaught error
+
+package builtins
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// CrashReport describes a run that stopped on an uncaught error, so a
+// failure in a scheduled task or service can be looked into afterwards.
+type CrashReport struct {
+	Script       string            `json:"script"`
+	Version      string            `json:"version"`
+	Time         string            `json:"time"`
+	DurationMs   int64             `json:"duration_ms"`
+	Instructions uint64            `json:"instructions"`
+	Error        string            `json:"error"`
+	Stack        []string          `json:"stack"`   // innermost call first
+	Recent       []string          `json:"recent"`  // the last instructions run, oldest first
+	Globals      map[string]string `json:"globals"` // inspected values of the script's globals
+}
+
+// crashValueLimit caps each value in Globals so one big array does not
+// bury the rest of the report.
+const crashValueLimit = 200
+
+// SnapshotGlobals inspects every assigned global not named in skip,
+// leaving out module members the way globals() does.
+func SnapshotGlobals(skip []string) map[string]string {
+	snapshot := make(map[string]string)
+	if GlobalNames == nil || VMGlobals == nil {
+		return snapshot
+	}
+	skipped := make(map[string]bool, len(skip))
+	for _, name := range skip {
+		skipped[name] = true
+	}
+	names := GlobalNames()
+	VMGlobalsMu.RLock()
+	defer VMGlobalsMu.RUnlock()
+	for i, name := range names {
+		if i >= len(VMGlobals) || VMGlobals[i] == nil || skipped[name] || strings.Contains(name, ".") {
+			continue
+		}
+		value := []rune(VMGlobals[i].Inspect())
+		if len(value) > crashValueLimit {
+			value = append(value[:crashValueLimit], []rune("...")...)
+		}
+		snapshot[name] = string(value)
+	}
+	return snapshot
+}
+
+// WriteCrashReport sends the report as JSON to target and returns where it
+// went. An http:// or https:// target is a webhook the report is POSTed
+// to; a directory gets a new crash-TIMESTAMP.json file, so repeated
+// failures do not overwrite each other; anything else is a file path.
+func WriteCrashReport(target string, r *CrashReport) (string, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false) // keep <main> readable
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(r); err != nil {
+		return "", err
+	}
+	body := buf.Bytes()
+	if strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://") {
+		client := &http.Client{Timeout: 10 * time.Second}
+		resp, err := client.Post(target, "application/json", bytes.NewReader(body))
+		if err != nil {
+			return "", err
+		}
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			return "", fmt.Errorf("crash report webhook returned %s", resp.Status)
+		}
+		return target, nil
+	}
+	if info, err := os.Stat(target); err == nil && info.IsDir() {
+		target = filepath.Join(target, "crash-"+time.Now().Format("20060102-150405.000")+".json")
+	}
+	return target, os.WriteFile(target, body, 0644)
+}
@@ -0,0 +1,370 @@
+// Docker - list, run, stop and read the logs of containers through the Docker Engine API
+
+package builtins
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"xon/object"
+)
+
+func init() {
+	builtinsMap["docker_ps"] = &object.Builtin{Fn: dockerPs}
+	builtinsMap["docker_run"] = &object.Builtin{Fn: dockerRun}
+	builtinsMap["docker_stop"] = &object.Builtin{Fn: dockerStop}
+	builtinsMap["docker_logs"] = &object.Builtin{Fn: dockerLogs}
+}
+
+// dockerDo sends req to the daemon named by DOCKER_HOST (unix://, tcp://
+// or npipe://), or the platform's default socket. Over a socket or pipe
+// the request is written and the response read in turn on one
+// connection, as Windows pipes opened for synchronous I/O cannot be read
+// and written at the same time.
+func dockerDo(req *http.Request) (*http.Response, error) {
+	host := os.Getenv("DOCKER_HOST")
+	if host == "" {
+		host = defaultDockerHost
+	}
+	scheme, addr, ok := strings.Cut(host, "://")
+	var conn io.ReadWriteCloser
+	var err error
+	switch {
+	case ok && (scheme == "tcp" || scheme == "http"):
+		req.URL.Scheme, req.URL.Host = "http", addr
+		return http.DefaultClient.Do(req)
+	case ok && scheme == "unix":
+		conn, err = net.DialTimeout("unix", addr, 5*time.Second)
+	case ok && scheme == "npipe":
+		conn, err = dialPipe(strings.ReplaceAll(addr, "/", `\`))
+	default:
+		return nil, fmt.Errorf("DOCKER_HOST %q is not a unix://, tcp:// or npipe:// address", host)
+	}
+	if err != nil {
+		return nil, err
+	}
+	req.Close = true
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{resp.Body, conn}
+	return resp, nil
+}
+
+// dockerAPI sends a request to the Engine API and decodes a JSON reply
+// into result unless it is nil. A failing status becomes an error with the
+// daemon's message.
+func dockerAPI(method, path string, body any, result any) (int, []byte, error) {
+	var reader io.Reader
+	if body != nil {
+		data, _ := json.Marshal(body)
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, "http://docker"+path, reader)
+	if err != nil {
+		return 0, nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := dockerDo(req)
+	if err != nil {
+		return 0, nil, fmt.Errorf("cannot reach the Docker daemon: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, nil, err
+	}
+	if resp.StatusCode >= 400 {
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Message != "" {
+			return resp.StatusCode, data, fmt.Errorf("%s", apiErr.Message)
+		}
+		return resp.StatusCode, data, fmt.Errorf("%s", resp.Status)
+	}
+	if result != nil && len(data) > 0 {
+		if err := json.Unmarshal(data, result); err != nil {
+			return resp.StatusCode, data, err
+		}
+	}
+	return resp.StatusCode, data, nil
+}
+
+func dockerError(name string, err error) *object.Error {
+	return &object.Error{Message: fmt.Sprintf("%s: %s", name, err)}
+}
+
+// dockerOptions returns the optional options hash at args[at].
+func dockerOptions(name string, args []object.Object, at int) (*object.Hash, *object.Error) {
+	if len(args) <= at {
+		return &object.Hash{Pairs: make(map[object.HashKey]object.HashPair)}, nil
+	}
+	opts, ok := args[at].(*object.Hash)
+	if !ok {
+		return nil, &object.Error{Message: fmt.Sprintf("%s options must be a hash", name)}
+	}
+	return opts, nil
+}
+
+// dockerPs implements docker_ps(options?): the running containers, or all
+// of them with {"all": true}, as {id, name, image, state, status}.
+func dockerPs(args ...object.Object) object.Object {
+	if len(args) > 1 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=0 or 1", len(args))}
+	}
+	opts, errObj := dockerOptions("docker_ps", args, 0)
+	if errObj != nil {
+		return errObj
+	}
+	path := "/containers/json"
+	if getHashBool(opts, "all") {
+		path += "?all=1"
+	}
+	var containers []struct {
+		ID     string   `json:"Id"`
+		Names  []string `json:"Names"`
+		Image  string   `json:"Image"`
+		State  string   `json:"State"`
+		Status string   `json:"Status"`
+	}
+	if _, _, err := dockerAPI("GET", path, nil, &containers); err != nil {
+		return dockerError("docker_ps", err)
+	}
+	elements := make([]object.Object, len(containers))
+	for i, c := range containers {
+		name := ""
+		if len(c.Names) > 0 {
+			name = strings.TrimPrefix(c.Names[0], "/")
+		}
+		h := &object.Hash{Pairs: make(map[object.HashKey]object.HashPair)}
+		setHashPair(h, "id", &object.String{Value: shortID(c.ID)})
+		setHashPair(h, "name", &object.String{Value: name})
+		setHashPair(h, "image", &object.String{Value: c.Image})
+		setHashPair(h, "state", &object.String{Value: c.State})
+		setHashPair(h, "status", &object.String{Value: c.Status})
+		elements[i] = h
+	}
+	return &object.Array{Elements: elements}
+}
+
+func shortID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
+
+// dockerRun implements docker_run(image, options?): it creates and starts
+// a container in the background, pulling the image first if it is
+// missing, and returns {id, name}. Options: name, cmd (an array), env (a
+// hash), ports ({"8080": 80} maps host to container ports), volumes
+// ({"/host/dir": "/container/dir"}) and remove (delete it when it stops).
+func dockerRun(args ...object.Object) object.Object {
+	if len(args) != 1 && len(args) != 2 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1 or 2", len(args))}
+	}
+	image, ok := args[0].(*object.String)
+	if !ok {
+		return &object.Error{Message: fmt.Sprintf("first argument to `docker_run` must be STRING, got %s", args[0].Type())}
+	}
+	opts, errObj := dockerOptions("docker_run", args, 1)
+	if errObj != nil {
+		return errObj
+	}
+
+	config := map[string]any{"Image": image.Value}
+	hostConfig := map[string]any{"AutoRemove": getHashBool(opts, "remove")}
+	if cmd := getHashArray(opts, "cmd"); cmd != nil {
+		words := make([]string, len(cmd))
+		for i, w := range cmd {
+			words[i] = objectText(w)
+		}
+		config["Cmd"] = words
+	}
+	if env, ok := getHashValue(opts, "env").(*object.Hash); ok {
+		var vars []string
+		for _, pair := range env.Pairs {
+			vars = append(vars, objectText(pair.Key)+"="+objectText(pair.Value))
+		}
+		sort.Strings(vars)
+		config["Env"] = vars
+	}
+	if ports, ok := getHashValue(opts, "ports").(*object.Hash); ok {
+		exposed := map[string]any{}
+		bindings := map[string]any{}
+		for _, pair := range ports.Pairs {
+			port := objectText(pair.Value)
+			if !strings.Contains(port, "/") {
+				port += "/tcp"
+			}
+			exposed[port] = struct{}{}
+			bindings[port] = []map[string]string{{"HostPort": objectText(pair.Key)}}
+		}
+		config["ExposedPorts"] = exposed
+		hostConfig["PortBindings"] = bindings
+	}
+	if volumes, ok := getHashValue(opts, "volumes").(*object.Hash); ok {
+		var binds []string
+		for _, pair := range volumes.Pairs {
+			binds = append(binds, objectText(pair.Key)+":"+objectText(pair.Value))
+		}
+		sort.Strings(binds)
+		hostConfig["Binds"] = binds
+	}
+	config["HostConfig"] = hostConfig
+
+	createPath := "/containers/create"
+	if name := getHashStr(opts, "name"); name != "" {
+		createPath += "?name=" + url.QueryEscape(name)
+	}
+	var created struct {
+		ID string `json:"Id"`
+	}
+	status, _, err := dockerAPI("POST", createPath, config, &created)
+	if status == http.StatusNotFound {
+		if err := dockerPull(image.Value); err != nil {
+			return dockerError("docker_run", err)
+		}
+		_, _, err = dockerAPI("POST", createPath, config, &created)
+	}
+	if err != nil {
+		return dockerError("docker_run", err)
+	}
+	if _, _, err := dockerAPI("POST", "/containers/"+created.ID+"/start", nil, nil); err != nil {
+		return dockerError("docker_run", err)
+	}
+
+	var inspect struct {
+		Name string `json:"Name"`
+	}
+	dockerAPI("GET", "/containers/"+created.ID+"/json", nil, &inspect)
+	result := &object.Hash{Pairs: make(map[object.HashKey]object.HashPair)}
+	setHashPair(result, "id", &object.String{Value: shortID(created.ID)})
+	setHashPair(result, "name", &object.String{Value: strings.TrimPrefix(inspect.Name, "/")})
+	return result
+}
+
+// dockerPull pulls image, waiting for the download to finish. The daemon
+// streams progress as JSON lines; an error can arrive in any of them.
+func dockerPull(image string) error {
+	name, tag := image, "latest"
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		name, tag = image[:i], image[i+1:]
+	}
+	_, data, err := dockerAPI("POST", "/images/create?fromImage="+url.QueryEscape(name)+"&tag="+url.QueryEscape(tag), nil, nil)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for {
+		var progress struct {
+			Error string `json:"error"`
+		}
+		if dec.Decode(&progress) != nil {
+			return nil
+		}
+		if progress.Error != "" {
+			return fmt.Errorf("%s", progress.Error)
+		}
+	}
+}
+
+// objectText is an object as text, without quotes around strings.
+func objectText(obj object.Object) string {
+	if s, ok := obj.(*object.String); ok {
+		return s.Value
+	}
+	return obj.Inspect()
+}
+
+// dockerStop implements docker_stop(id, options?). Options: timeout, the
+// seconds to wait before killing it (default 10). Stopping a stopped
+// container is not an error.
+func dockerStop(args ...object.Object) object.Object {
+	if len(args) != 1 && len(args) != 2 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1 or 2", len(args))}
+	}
+	id, ok := args[0].(*object.String)
+	if !ok {
+		return &object.Error{Message: fmt.Sprintf("first argument to `docker_stop` must be STRING, got %s", args[0].Type())}
+	}
+	opts, errObj := dockerOptions("docker_stop", args, 1)
+	if errObj != nil {
+		return errObj
+	}
+	path := "/containers/" + url.PathEscape(id.Value) + "/stop"
+	if getHashValue(opts, "timeout") != nil {
+		path += "?t=" + strconv.FormatInt(getHashInt(opts, "timeout"), 10)
+	}
+	if _, _, err := dockerAPI("POST", path, nil, nil); err != nil {
+		return dockerError("docker_stop", err)
+	}
+	return TRUE
+}
+
+// dockerLogs implements docker_logs(id, options?): the container's stdout
+// and stderr so far. Options: tail, the number of lines from the end.
+func dockerLogs(args ...object.Object) object.Object {
+	if len(args) != 1 && len(args) != 2 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1 or 2", len(args))}
+	}
+	id, ok := args[0].(*object.String)
+	if !ok {
+		return &object.Error{Message: fmt.Sprintf("first argument to `docker_logs` must be STRING, got %s", args[0].Type())}
+	}
+	opts, errObj := dockerOptions("docker_logs", args, 1)
+	if errObj != nil {
+		return errObj
+	}
+	path := "/containers/" + url.PathEscape(id.Value) + "/logs?stdout=1&stderr=1"
+	if tail := getHashInt(opts, "tail"); tail > 0 {
+		path += "&tail=" + strconv.FormatInt(tail, 10)
+	}
+	_, data, err := dockerAPI("GET", path, nil, nil)
+	if err != nil {
+		return dockerError("docker_logs", err)
+	}
+	return &object.String{Value: demuxDockerStream(data)}
+}
+
+// demuxDockerStream strips the 8-byte frame headers the daemon puts before
+// each chunk of output of a container without a TTY. Output of a TTY
+// container has no headers and is returned as it is.
+func demuxDockerStream(data []byte) string {
+	var out bytes.Buffer
+	for rest := data; len(rest) > 0; {
+		if len(rest) < 8 || rest[0] > 2 || rest[1] != 0 || rest[2] != 0 || rest[3] != 0 {
+			return string(data)
+		}
+		size := int(binary.BigEndian.Uint32(rest[4:8]))
+		if 8+size > len(rest) {
+			return string(data)
+		}
+		out.Write(rest[8 : 8+size])
+		rest = rest[8+size:]
+	}
+	return out.String()
+}
@@ -0,0 +1,42 @@
+// Errors - error(msg, code, data) values for throw, read back with e.message, e.code, e.data and e.stack
+
+package builtins
+
+import (
+	"fmt"
+	"xon/object"
+)
+
+func init() {
+	builtinsMap["error"] = &object.Builtin{Fn: errorBuiltin}
+}
+
+// errorBuiltin makes an error value. code, usually an integer or a short
+// string such as "E_AUTH", lets a catch block tell errors apart without
+// parsing the message; data carries anything else the handler needs.
+func errorBuiltin(args ...object.Object) object.Object {
+	if len(args) < 1 || len(args) > 3 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1 to 3", len(args))}
+	}
+	msg, ok := args[0].(*object.String)
+	if !ok {
+		return &object.Error{Message: fmt.Sprintf("error: message must be STRING, got %s", args[0].Type())}
+	}
+	e := &object.Error{Message: msg.Value}
+	if len(args) > 1 {
+		switch args[1].(type) {
+		case *object.Integer, *object.String, *object.Null:
+			e.Code = args[1]
+		default:
+			return &object.Error{Message: fmt.Sprintf("error: code must be INTEGER or STRING, got %s", args[1].Type())}
+		}
+	}
+	if len(args) > 2 {
+		data, ok := args[2].(*object.Hash)
+		if !ok {
+			return &object.Error{Message: fmt.Sprintf("error: data must be HASH, got %s", args[2].Type())}
+		}
+		e.Data = data
+	}
+	return e
+}
@@ -0,0 +1,139 @@
+// Events - pub/sub emitters safe to share between spawned tasks
+
+package builtins
+
+import (
+	"fmt"
+	"sync"
+	"xon/object"
+)
+
+func init() {
+	builtinsMap["emitter_new"] = &object.Builtin{Fn: emitterNew}
+}
+
+type listener struct {
+	id   int64
+	fn   object.Object
+	once bool
+}
+
+type emitter struct {
+	mu        sync.Mutex
+	nextID    int64
+	listeners map[string][]listener
+}
+
+// emitterNew returns a hash of on/once/off/emit/count functions backed by one
+// listener table. Listeners run synchronously on the emitting task.
+func emitterNew(args ...object.Object) object.Object {
+	if len(args) != 0 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=0", len(args))}
+	}
+	e := &emitter{listeners: make(map[string][]listener)}
+
+	h := &object.Hash{Pairs: make(map[object.HashKey]object.HashPair)}
+	setHashPair(h, "on", &object.Builtin{Fn: e.subscribe("on", false)})
+	setHashPair(h, "once", &object.Builtin{Fn: e.subscribe("once", true)})
+	setHashPair(h, "off", &object.Builtin{Fn: e.off})
+	setHashPair(h, "emit", &object.Builtin{Fn: e.emit})
+	setHashPair(h, "count", &object.Builtin{Fn: e.count})
+	return h
+}
+
+// subscribe registers fn for an event and returns its listener id for off().
+func (e *emitter) subscribe(name string, once bool) object.BuiltinFunction {
+	return func(args ...object.Object) object.Object {
+		if len(args) != 2 {
+			return &object.Error{Message: fmt.Sprintf("emitter.%s: wrong number of arguments. got=%d, want=2", name, len(args))}
+		}
+		event, ok := args[0].(*object.String)
+		if !ok {
+			return &object.Error{Message: fmt.Sprintf("emitter.%s: event name must be STRING, got %s", name, args[0].Type())}
+		}
+		if args[1].Type() != object.CLOSURE_OBJ && args[1].Type() != object.BUILTIN_OBJ {
+			return &object.Error{Message: fmt.Sprintf("emitter.%s: listener must be a function, got %s", name, args[1].Type())}
+		}
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		e.nextID++
+		e.listeners[event.Value] = append(e.listeners[event.Value], listener{id: e.nextID, fn: args[1], once: once})
+		return &object.Integer{Value: e.nextID}
+	}
+}
+
+// off(event) removes every listener for event; off(event, idOrFn) removes
+// the listener with that id or function. It returns how many were removed.
+func (e *emitter) off(args ...object.Object) object.Object {
+	if len(args) != 1 && len(args) != 2 {
+		return &object.Error{Message: fmt.Sprintf("emitter.off: wrong number of arguments. got=%d, want=1 or 2", len(args))}
+	}
+	event, ok := args[0].(*object.String)
+	if !ok {
+		return &object.Error{Message: fmt.Sprintf("emitter.off: event name must be STRING, got %s", args[0].Type())}
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	current := e.listeners[event.Value]
+	if len(args) == 1 {
+		delete(e.listeners, event.Value)
+		return &object.Integer{Value: int64(len(current))}
+	}
+	kept := current[:0:0]
+	for _, l := range current {
+		if id, ok := args[1].(*object.Integer); ok && l.id == id.Value {
+			continue
+		}
+		if l.fn == args[1] {
+			continue
+		}
+		kept = append(kept, l)
+	}
+	e.listeners[event.Value] = kept
+	return &object.Integer{Value: int64(len(current) - len(kept))}
+}
+
+// emit(event, args...) calls each listener with args and returns the number
+// called. A listener returning an error stops delivery and the error is returned.
+func (e *emitter) emit(args ...object.Object) object.Object {
+	if len(args) < 1 {
+		return &object.Error{Message: "emitter.emit: missing event name"}
+	}
+	event, ok := args[0].(*object.String)
+	if !ok {
+		return &object.Error{Message: fmt.Sprintf("emitter.emit: event name must be STRING, got %s", args[0].Type())}
+	}
+
+	// Snapshot under the lock so listeners may call on/off/emit themselves.
+	e.mu.Lock()
+	current := append([]listener(nil), e.listeners[event.Value]...)
+	kept := e.listeners[event.Value][:0:0]
+	for _, l := range e.listeners[event.Value] {
+		if !l.once {
+			kept = append(kept, l)
+		}
+	}
+	e.listeners[event.Value] = kept
+	e.mu.Unlock()
+
+	for _, l := range current {
+		res := callFunction(l.fn, args[1:]...)
+		if res.Type() == object.ERROR_OBJ {
+			return res
+		}
+	}
+	return &object.Integer{Value: int64(len(current))}
+}
+
+func (e *emitter) count(args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return &object.Error{Message: fmt.Sprintf("emitter.count: wrong number of arguments. got=%d, want=1", len(args))}
+	}
+	event, ok := args[0].(*object.String)
+	if !ok {
+		return &object.Error{Message: fmt.Sprintf("emitter.count: event name must be STRING, got %s", args[0].Type())}
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return &object.Integer{Value: int64(len(e.listeners[event.Value]))}
+}
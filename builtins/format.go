@@ -0,0 +1,161 @@
+// Format - number and currency formatting for reports
+
+package builtins
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"xon/object"
+)
+
+type numberStyle struct {
+	decimals  int
+	thousands string
+	decimal   string
+	symbol    string
+	suffix    bool // symbol after the number ("1.234,50 €") instead of before
+}
+
+var currencyStyles = map[string]numberStyle{
+	"USD": {decimals: 2, thousands: ",", decimal: ".", symbol: "$"},
+	"EUR": {decimals: 2, thousands: ".", decimal: ",", symbol: " €", suffix: true},
+	"GBP": {decimals: 2, thousands: ",", decimal: ".", symbol: "£"},
+	"JPY": {decimals: 0, thousands: ",", decimal: ".", symbol: "¥"},
+	"CHF": {decimals: 2, thousands: "'", decimal: ".", symbol: "CHF "},
+}
+
+func init() {
+	builtinsMap["num_format"] = &object.Builtin{Fn: numFormat}
+	builtinsMap["currency_format"] = &object.Builtin{Fn: currencyFormat}
+}
+
+// numFormat implements num_format(n, options?). Options: "decimals" (default
+// 0 for INTEGER, 2 for FLOAT), "thousands" (default ",") and "decimal" (default ".").
+func numFormat(args ...object.Object) object.Object {
+	if len(args) != 1 && len(args) != 2 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1 or 2", len(args))}
+	}
+	if _, ok := toFloat(args[0]); !ok {
+		return &object.Error{Message: fmt.Sprintf("first argument to `num_format` must be a number, got %s", args[0].Type())}
+	}
+	style := numberStyle{decimals: 2, thousands: ",", decimal: "."}
+	if args[0].Type() == object.INTEGER_OBJ {
+		style.decimals = 0
+	}
+	if len(args) == 2 {
+		opts, ok := args[1].(*object.Hash)
+		if !ok {
+			return &object.Error{Message: "num_format options must be a hash"}
+		}
+		style = applyStyleOptions(style, opts)
+	}
+	return &object.String{Value: formatNumber(args[0], style)}
+}
+
+// currencyFormat implements currency_format(n, code) where code is one of the
+// known currency codes, or a hash with "code" plus any num_format option and "symbol".
+func currencyFormat(args ...object.Object) object.Object {
+	if len(args) != 2 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=2", len(args))}
+	}
+	if _, ok := toFloat(args[0]); !ok {
+		return &object.Error{Message: fmt.Sprintf("first argument to `currency_format` must be a number, got %s", args[0].Type())}
+	}
+
+	var code string
+	var opts *object.Hash
+	switch arg := args[1].(type) {
+	case *object.String:
+		code = arg.Value
+	case *object.Hash:
+		code = getHashStr(arg, "code")
+		opts = arg
+	default:
+		return &object.Error{Message: "second argument to `currency_format` must be a currency code or options hash"}
+	}
+
+	style, ok := currencyStyles[strings.ToUpper(code)]
+	if !ok {
+		if opts == nil {
+			return &object.Error{Message: fmt.Sprintf("unknown currency code %q", code)}
+		}
+		style = numberStyle{decimals: 2, thousands: ",", decimal: "."}
+	}
+	if opts != nil {
+		style = applyStyleOptions(style, opts)
+		if sym := getHashValue(opts, "symbol"); sym != nil {
+			style.symbol = sym.Inspect()
+		}
+		if getHashValue(opts, "suffix") != nil {
+			style.suffix = getHashBool(opts, "suffix")
+		}
+	}
+
+	formatted := formatNumber(args[0], style)
+	sign := ""
+	if strings.HasPrefix(formatted, "-") {
+		sign, formatted = "-", formatted[1:]
+	}
+	if style.suffix {
+		return &object.String{Value: sign + formatted + style.symbol}
+	}
+	return &object.String{Value: sign + style.symbol + formatted}
+}
+
+func applyStyleOptions(style numberStyle, opts *object.Hash) numberStyle {
+	if d := getHashValue(opts, "decimals"); d != nil {
+		if i, ok := d.(*object.Integer); ok && i.Value >= 0 {
+			style.decimals = int(i.Value)
+		}
+	}
+	if t := getHashValue(opts, "thousands"); t != nil {
+		style.thousands = t.Inspect()
+	}
+	if d := getHashValue(opts, "decimal"); d != nil {
+		style.decimal = d.Inspect()
+	}
+	return style
+}
+
+// formatNumber rounds n, an INTEGER or FLOAT, to style.decimals places and
+// inserts the thousands separator. Integers are printed exactly rather than
+// through float64, which would round them past 2^53.
+func formatNumber(n object.Object, style numberStyle) string {
+	var digits string
+	switch v := n.(type) {
+	case *object.Integer:
+		digits = strconv.FormatInt(v.Value, 10)
+		if style.decimals > 0 {
+			digits += "." + strings.Repeat("0", style.decimals)
+		}
+	case *object.Float:
+		digits = strconv.FormatFloat(v.Value, 'f', style.decimals, 64)
+	}
+	sign := ""
+	if strings.HasPrefix(digits, "-") {
+		digits = digits[1:]
+		// Values that round to zero are printed without a sign.
+		if strings.Trim(digits, "0.") != "" {
+			sign = "-"
+		}
+	}
+	intPart, fracPart := digits, ""
+	if dot := strings.IndexByte(digits, '.'); dot >= 0 {
+		intPart, fracPart = digits[:dot], digits[dot+1:]
+	}
+
+	var out strings.Builder
+	out.WriteString(sign)
+	for i, ch := range intPart {
+		if i > 0 && (len(intPart)-i)%3 == 0 {
+			out.WriteString(style.thousands)
+		}
+		out.WriteRune(ch)
+	}
+	if fracPart != "" {
+		out.WriteString(style.decimal)
+		out.WriteString(fracPart)
+	}
+	return out.String()
+}
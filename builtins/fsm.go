@@ -0,0 +1,190 @@
+// FSM - finite state machines with validated transitions and enter/exit callbacks
+
+package builtins
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"xon/object"
+)
+
+func init() {
+	builtinsMap["fsm_new"] = &object.Builtin{Fn: fsmNew}
+}
+
+type fsm struct {
+	mu          sync.Mutex
+	state       string
+	states      map[string]bool
+	transitions map[string][]string
+	onEnter     map[string]object.Object
+	onExit      map[string]object.Object
+	history     []string
+}
+
+// fsmNew implements fsm_new(spec). spec keys:
+//
+//	"states":      array of state names (required)
+//	"initial":     starting state (defaults to the first state)
+//	"transitions": hash of state => array of states it may move to
+//	"on_enter":    hash of state => fn(from, to), run after entering
+//	"on_exit":     hash of state => fn(from, to), run before leaving
+//
+// The result is a hash of state/can/allowed/go/history functions.
+func fsmNew(args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1", len(args))}
+	}
+	spec, ok := args[0].(*object.Hash)
+	if !ok {
+		return &object.Error{Message: "argument to `fsm_new` must be a hash"}
+	}
+
+	m := &fsm{
+		states:      make(map[string]bool),
+		transitions: make(map[string][]string),
+		onEnter:     make(map[string]object.Object),
+		onExit:      make(map[string]object.Object),
+	}
+	states := getHashArray(spec, "states")
+	if len(states) == 0 {
+		return &object.Error{Message: "fsm_new: \"states\" must be a non-empty array"}
+	}
+	for _, s := range states {
+		m.states[s.Inspect()] = true
+	}
+
+	m.state = states[0].Inspect()
+	if initial := getHashValue(spec, "initial"); initial != nil {
+		m.state = initial.Inspect()
+	}
+	if !m.states[m.state] {
+		return &object.Error{Message: fmt.Sprintf("fsm_new: unknown initial state %q", m.state)}
+	}
+	m.history = []string{m.state}
+
+	if t, ok := getHashValue(spec, "transitions").(*object.Hash); ok {
+		for _, pair := range t.Pairs {
+			from := pair.Key.Inspect()
+			if !m.states[from] {
+				return &object.Error{Message: fmt.Sprintf("fsm_new: transition from unknown state %q", from)}
+			}
+			targets, ok := pair.Value.(*object.Array)
+			if !ok {
+				return &object.Error{Message: fmt.Sprintf("fsm_new: transitions for %q must be an array", from)}
+			}
+			for _, to := range targets.Elements {
+				if !m.states[to.Inspect()] {
+					return &object.Error{Message: fmt.Sprintf("fsm_new: transition from %q to unknown state %q", from, to.Inspect())}
+				}
+				m.transitions[from] = append(m.transitions[from], to.Inspect())
+			}
+		}
+	}
+	for _, cb := range []struct {
+		key string
+		dst map[string]object.Object
+	}{{"on_enter", m.onEnter}, {"on_exit", m.onExit}} {
+		h, ok := getHashValue(spec, cb.key).(*object.Hash)
+		if !ok {
+			continue
+		}
+		for _, pair := range h.Pairs {
+			if !m.states[pair.Key.Inspect()] {
+				return &object.Error{Message: fmt.Sprintf("fsm_new: %s for unknown state %q", cb.key, pair.Key.Inspect())}
+			}
+			cb.dst[pair.Key.Inspect()] = pair.Value
+		}
+	}
+
+	h := &object.Hash{Pairs: make(map[object.HashKey]object.HashPair)}
+	setHashPair(h, "state", &object.Builtin{Fn: m.current})
+	setHashPair(h, "can", &object.Builtin{Fn: m.can})
+	setHashPair(h, "allowed", &object.Builtin{Fn: m.allowed})
+	setHashPair(h, "go", &object.Builtin{Fn: m.goTo})
+	setHashPair(h, "history", &object.Builtin{Fn: m.historyList})
+	return h
+}
+
+func (m *fsm) canMove(to string) bool {
+	for _, t := range m.transitions[m.state] {
+		if t == to {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *fsm) current(args ...object.Object) object.Object {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return &object.String{Value: m.state}
+}
+
+func (m *fsm) can(args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return &object.Error{Message: fmt.Sprintf("fsm.can: wrong number of arguments. got=%d, want=1", len(args))}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return boolToObj(m.canMove(args[0].Inspect()))
+}
+
+func (m *fsm) allowed(args ...object.Object) object.Object {
+	m.mu.Lock()
+	targets := append([]string(nil), m.transitions[m.state]...)
+	m.mu.Unlock()
+	sort.Strings(targets)
+	elements := make([]object.Object, len(targets))
+	for i, t := range targets {
+		elements[i] = &object.String{Value: t}
+	}
+	return &object.Array{Elements: elements}
+}
+
+// goTo moves to a new state, returning it, or an error for an illegal transition.
+// The exit callback of the old state runs first, then the enter callback of the new one.
+func (m *fsm) goTo(args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return &object.Error{Message: fmt.Sprintf("fsm.go: wrong number of arguments. got=%d, want=1", len(args))}
+	}
+	to := args[0].Inspect()
+	m.mu.Lock()
+	from := m.state
+	if !m.states[to] {
+		m.mu.Unlock()
+		return &object.Error{Message: fmt.Sprintf("fsm: unknown state %q", to)}
+	}
+	if !m.canMove(to) {
+		m.mu.Unlock()
+		return &object.Error{Message: fmt.Sprintf("fsm: illegal transition from %q to %q", from, to)}
+	}
+	m.state = to
+	m.history = append(m.history, to)
+	exit, enter := m.onExit[from], m.onEnter[to]
+	m.mu.Unlock()
+
+	fromObj, toObj := &object.String{Value: from}, &object.String{Value: to}
+	if exit != nil {
+		if res := callFunction(exit, fromObj, toObj); res.Type() == object.ERROR_OBJ {
+			return res
+		}
+	}
+	if enter != nil {
+		if res := callFunction(enter, fromObj, toObj); res.Type() == object.ERROR_OBJ {
+			return res
+		}
+	}
+	return toObj
+}
+
+func (m *fsm) historyList(args ...object.Object) object.Object {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	elements := make([]object.Object, len(m.history))
+	for i, s := range m.history {
+		elements[i] = &object.String{Value: s}
+	}
+	return &object.Array{Elements: elements}
+}
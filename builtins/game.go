@@ -0,0 +1,620 @@
+// Game loop - on_frame, drawing, sprites and keyboard state for the GUI canvas
+
+package builtins
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"xon/object"
+)
+
+func init() {
+	builtinsMap["on_frame"] = &object.Builtin{Fn: onFrame}
+	builtinsMap["frame_step"] = &object.Builtin{Fn: frameStep}
+	builtinsMap["draw_clear"] = &object.Builtin{Fn: drawClear}
+	builtinsMap["draw_rect"] = &object.Builtin{Fn: drawRect}
+	builtinsMap["draw_circle"] = &object.Builtin{Fn: drawCircle}
+	builtinsMap["draw_line"] = &object.Builtin{Fn: drawLine}
+	builtinsMap["draw_text"] = &object.Builtin{Fn: drawText}
+	builtinsMap["draw_sprite"] = &object.Builtin{Fn: drawSprite}
+	builtinsMap["sprite_load"] = &object.Builtin{Fn: spriteLoad}
+	builtinsMap["sprite_from"] = &object.Builtin{Fn: spriteFrom}
+	builtinsMap["sprite_size"] = &object.Builtin{Fn: spriteSize}
+	builtinsMap["key_down"] = &object.Builtin{Fn: keyDown}
+	builtinsMap["key_hit"] = &object.Builtin{Fn: keyHit}
+}
+
+// game is the one frame loop: the function on_frame registered, the
+// commands drawn by the frame running now, and the keys the canvas has
+// seen. The canvas runs frames on the GUI thread; frame_step runs them on
+// the caller's.
+var game = struct {
+	sync.Mutex
+	frame   object.Object // nil when no loop is registered
+	fps     int
+	inFrame bool
+	drawing []drawCmd
+	held    map[string]bool
+	hit     map[string]bool // went down since the previous frame
+}{fps: 60, held: map[string]bool{}, hit: map[string]bool{}}
+
+// drawCmd is one draw_* call. Lines run from x, y to x2, y2; circles are
+// centred on x, y.
+type drawCmd struct {
+	op         string // clear, rect, circle, line, text or sprite
+	x, y, w, h float64
+	x2, y2, r  float64
+	scale      int
+	color      rgba
+	text       string
+	sprite     *sprite
+}
+
+// keyNames are the names key_down and key_hit accept besides letters and
+// digits.
+var keyNames = []string{"left", "right", "up", "down", "space", "enter", "escape", "shift", "ctrl", "tab", "backspace"}
+
+func checkKeyName(builtin string, obj object.Object) (string, *object.Error) {
+	s, ok := obj.(*object.String)
+	if !ok {
+		return "", &object.Error{Message: fmt.Sprintf("argument to `%s` must be STRING, got %s", builtin, obj.Type())}
+	}
+	name := strings.ToLower(s.Value)
+	if len(name) == 1 && (name[0] >= 'a' && name[0] <= 'z' || name[0] >= '0' && name[0] <= '9') {
+		return name, nil
+	}
+	for _, k := range keyNames {
+		if name == k {
+			return name, nil
+		}
+	}
+	return "", &object.Error{Message: fmt.Sprintf("%s: unknown key %q; use a letter, a digit or one of %s", builtin, s.Value, strings.Join(keyNames, ", "))}
+}
+
+// setKey records a key going down or up on the canvas.
+func setKey(name string, down bool) {
+	game.Lock()
+	defer game.Unlock()
+	if down && !game.held[name] {
+		game.hit[name] = true
+	}
+	if down {
+		game.held[name] = true
+	} else {
+		delete(game.held, name)
+	}
+}
+
+// releaseKeys forgets the held keys, whose key-up events go elsewhere once
+// the canvas loses the focus.
+func releaseKeys() {
+	game.Lock()
+	game.held = map[string]bool{}
+	game.Unlock()
+}
+
+// runFrame calls the on_frame function with dt and returns what it drew.
+// It returns no commands when no function is registered.
+func runFrame(dt float64) ([]drawCmd, *object.Error) {
+	game.Lock()
+	fn := game.frame
+	if fn == nil {
+		game.Unlock()
+		return nil, nil
+	}
+	game.drawing, game.inFrame = nil, true
+	game.Unlock()
+
+	result := callFunction(fn, &object.Float{Value: dt})
+
+	game.Lock()
+	cmds := game.drawing
+	game.drawing, game.inFrame = nil, false
+	game.hit = map[string]bool{}
+	game.Unlock()
+	if errObj, ok := result.(*object.Error); ok {
+		return cmds, errObj
+	}
+	return cmds, nil
+}
+
+// onFrame implements on_frame(fn, fps?): the canvas calls fn(dt) fps times
+// a second, dt being the seconds since the previous frame. null stops it.
+func onFrame(args ...object.Object) object.Object {
+	if len(args) != 1 && len(args) != 2 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1 or 2", len(args))}
+	}
+	fps := int64(60)
+	if len(args) == 2 {
+		n, ok := args[1].(*object.Integer)
+		if !ok {
+			return &object.Error{Message: fmt.Sprintf("on_frame: fps must be INTEGER, got %s", args[1].Type())}
+		}
+		if n.Value < 1 || n.Value > 240 {
+			return &object.Error{Message: fmt.Sprintf("on_frame: fps must be between 1 and 240, got %d", n.Value)}
+		}
+		fps = n.Value
+	}
+	var fn object.Object
+	switch f := args[0].(type) {
+	case *object.Null:
+	case *object.Closure, *object.Builtin, *object.BoundMethod:
+		fn = f
+	default:
+		return &object.Error{Message: fmt.Sprintf("on_frame: first argument must be a function or null, got %s", args[0].Type())}
+	}
+	game.Lock()
+	game.frame, game.fps = fn, int(fps)
+	game.Unlock()
+	return NULL
+}
+
+// frameStep implements frame_step(dt, options?): it runs one frame without
+// a window, for tests and simulations, and returns the draw commands.
+// Options: keys (the keys held during the frame), png (a file to render the
+// frame to, without its text), width and height (of that image).
+func frameStep(args ...object.Object) object.Object {
+	if len(args) != 1 && len(args) != 2 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1 or 2", len(args))}
+	}
+	dt, ok := toFloat(args[0])
+	if !ok || dt < 0 {
+		return &object.Error{Message: fmt.Sprintf("frame_step: dt must be a number of seconds, got %s", args[0].Inspect())}
+	}
+	var pngPath string
+	width, height := 320, 240
+	if len(args) == 2 {
+		opts, ok := args[1].(*object.Hash)
+		if !ok {
+			return &object.Error{Message: fmt.Sprintf("frame_step: options must be HASH, got %s", args[1].Type())}
+		}
+		if keys := getHashValue(opts, "keys"); keys != nil {
+			arr, ok := keys.(*object.Array)
+			if !ok {
+				return &object.Error{Message: fmt.Sprintf("frame_step: keys must be ARRAY, got %s", keys.Type())}
+			}
+			held := map[string]bool{}
+			for _, k := range arr.Elements {
+				name, errObj := checkKeyName("frame_step", k)
+				if errObj != nil {
+					return errObj
+				}
+				held[name] = true
+			}
+			game.Lock()
+			for name := range held {
+				if !game.held[name] {
+					game.hit[name] = true
+				}
+			}
+			game.held = held
+			game.Unlock()
+		}
+		pngPath = getHashStr(opts, "png")
+		if w := getHashInt(opts, "width"); w > 0 {
+			width = int(w)
+		}
+		if h := getHashInt(opts, "height"); h > 0 {
+			height = int(h)
+		}
+	}
+	game.Lock()
+	registered := game.frame != nil
+	game.Unlock()
+	if !registered {
+		return &object.Error{Message: "frame_step: no function is registered with on_frame"}
+	}
+
+	cmds, errObj := runFrame(dt)
+	if errObj != nil {
+		return errObj
+	}
+	if pngPath != "" {
+		if err := savePNG(pngPath, renderFrame(cmds, width, height)); err != nil {
+			return &object.Error{Message: fmt.Sprintf("frame_step: %s", err)}
+		}
+	}
+	out := make([]object.Object, len(cmds))
+	for i, c := range cmds {
+		out[i] = c.hash()
+	}
+	return &object.Array{Elements: out}
+}
+
+func savePNG(path string, img image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// hash describes c to scripts the way it was drawn.
+func (c drawCmd) hash() *object.Hash {
+	h := &object.Hash{Pairs: make(map[object.HashKey]object.HashPair)}
+	num := func(key string, v float64) {
+		if v == math.Trunc(v) {
+			setHashPair(h, key, &object.Integer{Value: int64(v)})
+		} else {
+			setHashPair(h, key, &object.Float{Value: v})
+		}
+	}
+	setHashPair(h, "op", &object.String{Value: c.op})
+	switch c.op {
+	case "rect":
+		num("x", c.x)
+		num("y", c.y)
+		num("w", c.w)
+		num("h", c.h)
+	case "circle":
+		num("x", c.x)
+		num("y", c.y)
+		num("r", c.r)
+	case "line":
+		num("x", c.x)
+		num("y", c.y)
+		num("x2", c.x2)
+		num("y2", c.y2)
+	case "text":
+		num("x", c.x)
+		num("y", c.y)
+		setHashPair(h, "text", &object.String{Value: c.text})
+	case "sprite":
+		num("x", c.x)
+		num("y", c.y)
+		setHashPair(h, "scale", &object.Integer{Value: int64(c.scale)})
+		setHashPair(h, "sprite", c.sprite)
+		return h
+	}
+	setHashPair(h, "color", colorHex(c.color.hash()))
+	return h
+}
+
+// addDraw appends cmd to the frame running now.
+func addDraw(name string, cmd drawCmd) object.Object {
+	game.Lock()
+	defer game.Unlock()
+	if !game.inFrame {
+		return &object.Error{Message: fmt.Sprintf("%s: draw inside the function given to on_frame", name)}
+	}
+	game.drawing = append(game.drawing, cmd)
+	return NULL
+}
+
+// numbers reads args as the numbers named in names, for draw_* coordinates.
+func numbers(builtin string, args []object.Object, names ...string) ([]float64, *object.Error) {
+	out := make([]float64, len(names))
+	for i, name := range names {
+		f, ok := toFloat(args[i])
+		if !ok {
+			return nil, &object.Error{Message: fmt.Sprintf("%s: %s must be a number, got %s", builtin, name, args[i].Type())}
+		}
+		out[i] = f
+	}
+	return out, nil
+}
+
+func drawClear(args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1", len(args))}
+	}
+	c, errObj := toColor("draw_clear", args[0])
+	if errObj != nil {
+		return errObj
+	}
+	return addDraw("draw_clear", drawCmd{op: "clear", color: c})
+}
+
+func drawRect(args ...object.Object) object.Object {
+	if len(args) != 5 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=5", len(args))}
+	}
+	n, errObj := numbers("draw_rect", args, "x", "y", "w", "h")
+	if errObj != nil {
+		return errObj
+	}
+	c, errObj := toColor("draw_rect", args[4])
+	if errObj != nil {
+		return errObj
+	}
+	return addDraw("draw_rect", drawCmd{op: "rect", x: n[0], y: n[1], w: n[2], h: n[3], color: c})
+}
+
+func drawCircle(args ...object.Object) object.Object {
+	if len(args) != 4 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=4", len(args))}
+	}
+	n, errObj := numbers("draw_circle", args, "x", "y", "r")
+	if errObj != nil {
+		return errObj
+	}
+	c, errObj := toColor("draw_circle", args[3])
+	if errObj != nil {
+		return errObj
+	}
+	return addDraw("draw_circle", drawCmd{op: "circle", x: n[0], y: n[1], r: n[2], color: c})
+}
+
+func drawLine(args ...object.Object) object.Object {
+	if len(args) != 5 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=5", len(args))}
+	}
+	n, errObj := numbers("draw_line", args, "x", "y", "x2", "y2")
+	if errObj != nil {
+		return errObj
+	}
+	c, errObj := toColor("draw_line", args[4])
+	if errObj != nil {
+		return errObj
+	}
+	return addDraw("draw_line", drawCmd{op: "line", x: n[0], y: n[1], x2: n[2], y2: n[3], color: c})
+}
+
+// drawText implements draw_text(x, y, text, color?), white by default.
+// The canvas draws text above the shapes and sprites of the frame.
+func drawText(args ...object.Object) object.Object {
+	if len(args) != 3 && len(args) != 4 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=3 or 4", len(args))}
+	}
+	n, errObj := numbers("draw_text", args, "x", "y")
+	if errObj != nil {
+		return errObj
+	}
+	c := rgba{r: 255, g: 255, b: 255, a: 1}
+	if len(args) == 4 {
+		if c, errObj = toColor("draw_text", args[3]); errObj != nil {
+			return errObj
+		}
+	}
+	text := args[2].Inspect()
+	return addDraw("draw_text", drawCmd{op: "text", x: n[0], y: n[1], text: text, color: c})
+}
+
+// drawSprite implements draw_sprite(s, x, y, scale?), scale being a whole
+// number that blows each pixel up into a square, for pixel art.
+func drawSprite(args ...object.Object) object.Object {
+	if len(args) != 3 && len(args) != 4 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=3 or 4", len(args))}
+	}
+	s, ok := args[0].(*sprite)
+	if !ok {
+		return &object.Error{Message: fmt.Sprintf("draw_sprite: first argument must be SPRITE, got %s", args[0].Type())}
+	}
+	n, errObj := numbers("draw_sprite", args[1:], "x", "y")
+	if errObj != nil {
+		return errObj
+	}
+	scale := int64(1)
+	if len(args) == 4 {
+		i, ok := args[3].(*object.Integer)
+		if !ok || i.Value < 1 {
+			return &object.Error{Message: fmt.Sprintf("draw_sprite: scale must be a positive INTEGER, got %s", args[3].Inspect())}
+		}
+		scale = i.Value
+	}
+	return addDraw("draw_sprite", drawCmd{op: "sprite", x: n[0], y: n[1], scale: int(scale), sprite: s})
+}
+
+// sprite is an image for draw_sprite, decoded once so that frames only
+// copy its pixels.
+type sprite struct {
+	img *image.NRGBA
+}
+
+func (s *sprite) Type() object.ObjectType { return "SPRITE" }
+func (s *sprite) Inspect() string {
+	b := s.img.Bounds()
+	return fmt.Sprintf("sprite(%dx%d)", b.Dx(), b.Dy())
+}
+
+// spriteLoad implements sprite_load(path) for PNG, GIF and JPEG files.
+func spriteLoad(args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1", len(args))}
+	}
+	path, ok := args[0].(*object.String)
+	if !ok {
+		return &object.Error{Message: fmt.Sprintf("argument to `sprite_load` must be STRING, got %s", args[0].Type())}
+	}
+	f, err := os.Open(path.Value)
+	if err != nil {
+		return &object.Error{Message: fmt.Sprintf("sprite_load: %s", err)}
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return &object.Error{Message: fmt.Sprintf("sprite_load: %s: %s", path.Value, err)}
+	}
+	nrgba := image.NewNRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+	draw.Draw(nrgba, nrgba.Bounds(), img, img.Bounds().Min, draw.Src)
+	return &sprite{img: nrgba}
+}
+
+// spriteFrom implements sprite_from(rows, palette): pixel art written as
+// equal-length strings, each character a color from palette. "." and " "
+// are transparent.
+func spriteFrom(args ...object.Object) object.Object {
+	if len(args) != 2 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=2", len(args))}
+	}
+	rows, ok := args[0].(*object.Array)
+	if !ok || len(rows.Elements) == 0 {
+		return &object.Error{Message: "sprite_from: rows must be a non-empty ARRAY of strings"}
+	}
+	palette, ok := args[1].(*object.Hash)
+	if !ok {
+		return &object.Error{Message: fmt.Sprintf("sprite_from: palette must be HASH, got %s", args[1].Type())}
+	}
+	colors := map[rune]color.NRGBA{'.': {}, ' ': {}}
+	for _, pair := range palette.Pairs {
+		key, ok := pair.Key.(*object.String)
+		if !ok || len([]rune(key.Value)) != 1 {
+			return &object.Error{Message: fmt.Sprintf("sprite_from: palette keys must be single characters, got %s", pair.Key.Inspect())}
+		}
+		c, errObj := toColor("sprite_from", pair.Value)
+		if errObj != nil {
+			return errObj
+		}
+		colors[[]rune(key.Value)[0]] = c.nrgba()
+	}
+	var lines [][]rune
+	for i, el := range rows.Elements {
+		s, ok := el.(*object.String)
+		if !ok {
+			return &object.Error{Message: fmt.Sprintf("sprite_from: row %d must be STRING, got %s", i, el.Type())}
+		}
+		line := []rune(s.Value)
+		if len(line) == 0 || len(lines) > 0 && len(line) != len(lines[0]) {
+			return &object.Error{Message: fmt.Sprintf("sprite_from: row %d has %d pixels; rows must be equal and not empty", i, len(line))}
+		}
+		lines = append(lines, line)
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, len(lines[0]), len(lines)))
+	for y, line := range lines {
+		for x, ch := range line {
+			c, ok := colors[ch]
+			if !ok {
+				return &object.Error{Message: fmt.Sprintf("sprite_from: row %d uses %q, which is not in the palette", y, ch)}
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return &sprite{img: img}
+}
+
+func spriteSize(args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1", len(args))}
+	}
+	s, ok := args[0].(*sprite)
+	if !ok {
+		return &object.Error{Message: fmt.Sprintf("argument to `sprite_size` must be SPRITE, got %s", args[0].Type())}
+	}
+	b := s.img.Bounds()
+	return &object.Array{Elements: []object.Object{&object.Integer{Value: int64(b.Dx())}, &object.Integer{Value: int64(b.Dy())}}}
+}
+
+// keyDown implements key_down(name): whether the key is held now.
+func keyDown(args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1", len(args))}
+	}
+	name, errObj := checkKeyName("key_down", args[0])
+	if errObj != nil {
+		return errObj
+	}
+	game.Lock()
+	defer game.Unlock()
+	return boolToObj(game.held[name])
+}
+
+// keyHit implements key_hit(name): whether the key went down since the
+// previous frame, which holding it does not repeat.
+func keyHit(args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1", len(args))}
+	}
+	name, errObj := checkKeyName("key_hit", args[0])
+	if errObj != nil {
+		return errObj
+	}
+	game.Lock()
+	defer game.Unlock()
+	return boolToObj(game.hit[name])
+}
+
+func (c rgba) nrgba() color.NRGBA {
+	return color.NRGBA{R: uint8(c.r), G: uint8(c.g), B: uint8(c.b), A: uint8(math.Round(c.a * 255))}
+}
+
+// renderFrame paints cmds in order on a black width x height image. Text
+// is left to the caller, which has the fonts.
+func renderFrame(cmds []drawCmd, width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.Black, image.Point{}, draw.Src)
+	fill := func(r image.Rectangle, c color.NRGBA) {
+		draw.Draw(img, r.Intersect(img.Bounds()), &image.Uniform{C: c}, image.Point{}, draw.Over)
+	}
+	round := func(f float64) int { return int(math.Round(f)) }
+	for _, cmd := range cmds {
+		c := cmd.color.nrgba()
+		switch cmd.op {
+		case "clear":
+			fill(img.Bounds(), c)
+		case "rect":
+			fill(image.Rect(round(cmd.x), round(cmd.y), round(cmd.x+cmd.w), round(cmd.y+cmd.h)), c)
+		case "circle":
+			// One span per row, as wide as the circle at the row's middle.
+			for y := round(cmd.y - cmd.r); y < round(cmd.y+cmd.r); y++ {
+				dy := float64(y) + 0.5 - cmd.y
+				if half := cmd.r*cmd.r - dy*dy; half > 0 {
+					dx := math.Sqrt(half)
+					fill(image.Rect(round(cmd.x-dx), y, round(cmd.x+dx), y+1), c)
+				}
+			}
+		case "line":
+			x0, y0, x1, y1 := round(cmd.x), round(cmd.y), round(cmd.x2), round(cmd.y2)
+			dx, dy := abs(x1-x0), -abs(y1-y0)
+			sx, sy := sign(x1-x0), sign(y1-y0)
+			for e := dx + dy; ; {
+				fill(image.Rect(x0, y0, x0+1, y0+1), c)
+				if x0 == x1 && y0 == y1 {
+					break
+				}
+				if e2 := 2 * e; e2 >= dy {
+					e += dy
+					x0 += sx
+				} else {
+					e += dx
+					y0 += sy
+				}
+			}
+		case "sprite":
+			src := cmd.sprite.img
+			at := image.Pt(round(cmd.x), round(cmd.y))
+			if cmd.scale == 1 {
+				draw.Draw(img, src.Bounds().Add(at).Intersect(img.Bounds()), src, image.Point{}, draw.Over)
+				continue
+			}
+			for y := 0; y < src.Rect.Dy(); y++ {
+				for x := 0; x < src.Rect.Dx(); x++ {
+					if px := src.NRGBAAt(x, y); px.A > 0 {
+						corner := at.Add(image.Pt(x*cmd.scale, y*cmd.scale))
+						fill(image.Rectangle{Min: corner, Max: corner.Add(image.Pt(cmd.scale, cmd.scale))}, px)
+					}
+				}
+			}
+		}
+	}
+	return img
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,168 @@
+// Game canvas - the gui_run widget that runs on_frame on a timer and paints its frames
+
+package builtins
+
+import (
+	"time"
+	"unsafe"
+
+	"github.com/rodrigocfd/windigo/co"
+	"github.com/rodrigocfd/windigo/ui"
+	"github.com/rodrigocfd/windigo/win"
+)
+
+var (
+	setTimer  = user32.NewProc("SetTimer")
+	killTimer = user32.NewProc("KillTimer")
+)
+
+const frameTimer = 1
+
+// vkNames maps the virtual keys key_down knows to their names; letters and
+// digits are mapped by vkName.
+var vkNames = map[co.VK]string{
+	co.VK_LEFT: "left", co.VK_RIGHT: "right", co.VK_UP: "up", co.VK_DOWN: "down",
+	co.VK_SPACE: "space", co.VK_RETURN: "enter", co.VK_ESCAPE: "escape",
+	co.VK_SHIFT: "shift", co.VK_CONTROL: "ctrl", co.VK_TAB: "tab", co.VK_BACK: "backspace",
+}
+
+func vkName(vk co.VK) string {
+	switch {
+	case vk >= 'A' && vk <= 'Z':
+		return string(rune('a' + vk - 'A'))
+	case vk >= '0' && vk <= '9':
+		return string(rune(vk))
+	}
+	return vkNames[vk]
+}
+
+// newGameCanvas adds a width x height canvas at x, y. A timer runs the
+// on_frame function and repaints with what it drew; keys pressed while
+// the canvas has the focus feed key_down and key_hit.
+func newGameCanvas(parent ui.Parent, x, y, width, height int) {
+	ctl := ui.NewControl(parent, ui.OptsControl().
+		Position(x, y).
+		Size(width, height))
+
+	var (
+		cmds    []drawCmd
+		fps     int
+		last    time.Time
+		focused bool
+	)
+	start := func() {
+		game.Lock()
+		fps = game.fps
+		game.Unlock()
+		setTimer.Call(uintptr(ctl.Hwnd()), frameTimer, uintptr(1000/fps), 0)
+		last = time.Now()
+	}
+
+	ctl.On().WmCreate(func(_ ui.WmCreate) int {
+		start()
+		return 0
+	})
+	ctl.On().WmTimer(frameTimer, func() {
+		if !focused {
+			ctl.Hwnd().SetFocus()
+			focused = true
+		}
+		now := time.Now()
+		drawn, errObj := runFrame(now.Sub(last).Seconds())
+		last = now
+		if errObj != nil {
+			killTimer.Call(uintptr(ctl.Hwnd()), frameTimer)
+			ctl.Hwnd().MessageBox(errObj.Message, "on_frame", co.MB_ICONERROR)
+			return
+		}
+		cmds = drawn
+		ctl.Hwnd().InvalidateRect(nil, false)
+
+		// on_frame may have changed the rate.
+		game.Lock()
+		changed := game.fps != fps
+		game.Unlock()
+		if changed {
+			start()
+		}
+	})
+	ctl.On().WmPaint(func() {
+		paintCanvas(ctl.Hwnd(), cmds, width, height)
+	})
+	ctl.On().WmEraseBkgnd(func(_ ui.WmEraseBkgnd) int {
+		return 1 // WM_PAINT covers every pixel
+	})
+	ctl.On().WmGetDlgCode(func(_ ui.WmGetDlgCode) co.DLGC {
+		return co.DLGC_WANTARROWS | co.DLGC_WANTALLKEYS
+	})
+	ctl.On().WmKeyDown(func(p ui.WmKey) {
+		if name := vkName(p.VirtualKeyCode()); name != "" {
+			setKey(name, true)
+		}
+	})
+	ctl.On().WmKeyUp(func(p ui.WmKey) {
+		if name := vkName(p.VirtualKeyCode()); name != "" {
+			setKey(name, false)
+		}
+	})
+	ctl.On().WmKillFocus(func(_ ui.WmKillFocus) {
+		releaseKeys()
+	})
+	ctl.On().WmLButtonDown(func(_ ui.WmMouse) {
+		ctl.Hwnd().SetFocus()
+	})
+	ctl.On().WmDestroy(func() {
+		killTimer.Call(uintptr(ctl.Hwnd()), frameTimer)
+	})
+}
+
+// paintCanvas renders cmds off screen, writes their text on top in the
+// system font and copies the result to the canvas in one go, so frames do
+// not flicker.
+func paintCanvas(hwnd win.HWND, cmds []drawCmd, width, height int) {
+	var ps win.PAINTSTRUCT
+	hdc, err := hwnd.BeginPaint(&ps)
+	if err != nil {
+		return
+	}
+	defer hwnd.EndPaint(&ps)
+
+	mem, err := hdc.CreateCompatibleDC()
+	if err != nil {
+		return
+	}
+	defer mem.DeleteDC()
+	bmp, err := hdc.CreateCompatibleBitmap(width, height)
+	if err != nil {
+		return
+	}
+	defer bmp.DeleteObject()
+	old, _ := mem.SelectObjectBmp(bmp)
+	defer mem.SelectObjectBmp(old)
+
+	// GDI takes blue, green, red rows; a negative height puts the first
+	// row at the top.
+	img := renderFrame(cmds, width, height)
+	px := make([]byte, len(img.Pix))
+	for i := 0; i < len(px); i += 4 {
+		px[i], px[i+1], px[i+2] = img.Pix[i+2], img.Pix[i+1], img.Pix[i]
+	}
+	var bi win.BITMAPINFO
+	bi.BmiHeader.SetSize()
+	bi.BmiHeader.Width = int32(width)
+	bi.BmiHeader.Height = -int32(height)
+	bi.BmiHeader.Planes = 1
+	bi.BmiHeader.BitCount = co.BITCOUNT_32
+	bi.BmiHeader.Compression = co.BI_RGB
+	size := win.SIZE{Cx: int32(width), Cy: int32(height)}
+	mem.SetDIBitsToDevice(win.POINT{}, size, win.POINT{}, 0, height, unsafe.Pointer(&px[0]), &bi, co.DIB_COLORS_RGB)
+
+	mem.SetBkMode(co.BKMODE_TRANSPARENT)
+	for _, c := range cmds {
+		if c.op == "text" {
+			mem.SetTextColor(win.RGB(uint8(c.color.r), uint8(c.color.g), uint8(c.color.b)))
+			mem.TextOut(int(c.x), int(c.y), c.text)
+		}
+	}
+	hdc.BitBlt(win.POINT{}, size, mem, win.POINT{}, co.ROP_SRCCOPY)
+}
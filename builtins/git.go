@@ -0,0 +1,282 @@
+// Git - clone, pull, commit, push and status by running the git command line
+
+package builtins
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"xon/object"
+)
+
+func init() {
+	builtinsMap["git_clone"] = &object.Builtin{Fn: gitClone}
+	builtinsMap["git_pull"] = &object.Builtin{Fn: gitPull}
+	builtinsMap["git_commit"] = &object.Builtin{Fn: gitCommit}
+	builtinsMap["git_push"] = &object.Builtin{Fn: gitPush}
+	builtinsMap["git_status"] = &object.Builtin{Fn: gitStatus}
+}
+
+// runGit runs git with args in dir and returns its trimmed stdout. The
+// arguments go to git as they are, never through a shell, and git is told
+// not to prompt for credentials, which would hang a script. A failure
+// carries what git printed on stderr.
+func runGit(dir string, args ...string) (string, error) {
+	path, err := exec.LookPath("git")
+	if err != nil {
+		return "", fmt.Errorf("git is not installed or not on the PATH")
+	}
+	cmd := exec.Command(path, args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0", "LC_ALL=C")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = strings.TrimSpace(stdout.String())
+		}
+		if msg == "" {
+			msg = err.Error()
+		}
+		return "", fmt.Errorf("%s", msg)
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// gitArgs checks for want leading string arguments and an optional
+// options hash after them.
+func gitArgs(name string, args []object.Object, want int) ([]string, *object.Hash, *object.Error) {
+	if len(args) != want && len(args) != want+1 {
+		return nil, nil, &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=%d or %d", len(args), want, want+1)}
+	}
+	strs := make([]string, want)
+	for i := 0; i < want; i++ {
+		s, ok := args[i].(*object.String)
+		if !ok {
+			return nil, nil, &object.Error{Message: fmt.Sprintf("argument %d to `%s` must be STRING, got %s", i+1, name, args[i].Type())}
+		}
+		strs[i] = s.Value
+	}
+	opts := &object.Hash{Pairs: make(map[object.HashKey]object.HashPair)}
+	if len(args) == want+1 {
+		h, ok := args[want].(*object.Hash)
+		if !ok {
+			return nil, nil, &object.Error{Message: fmt.Sprintf("%s options must be a hash", name)}
+		}
+		opts = h
+	}
+	return strs, opts, nil
+}
+
+func gitError(name string, err error) *object.Error {
+	return &object.Error{Message: fmt.Sprintf("%s: %s", name, err)}
+}
+
+// gitHead returns the commit hash HEAD points to, or "" in an empty
+// repository.
+func gitHead(dir string) string {
+	head, err := runGit(dir, "rev-parse", "--verify", "--quiet", "HEAD")
+	if err != nil {
+		return ""
+	}
+	return head
+}
+
+// gitClone implements git_clone(url, dir, options?). Options: branch and
+// depth. Returns {dir, head}.
+func gitClone(args ...object.Object) object.Object {
+	strs, opts, errObj := gitArgs("git_clone", args, 2)
+	if errObj != nil {
+		return errObj
+	}
+	cloneArgs := []string{"clone", "--quiet"}
+	if branch := getHashStr(opts, "branch"); branch != "" {
+		cloneArgs = append(cloneArgs, "--branch", branch)
+	}
+	if depth := getHashInt(opts, "depth"); depth > 0 {
+		cloneArgs = append(cloneArgs, "--depth", strconv.FormatInt(depth, 10))
+	}
+	// "--" keeps a url or dir starting with "-" from being read as a flag.
+	cloneArgs = append(cloneArgs, "--", strs[0], strs[1])
+	if _, err := runGit("", cloneArgs...); err != nil {
+		return gitError("git_clone", err)
+	}
+	result := &object.Hash{Pairs: make(map[object.HashKey]object.HashPair)}
+	setHashPair(result, "dir", &object.String{Value: strs[1]})
+	setHashPair(result, "head", &object.String{Value: gitHead(strs[1])})
+	return result
+}
+
+// gitPull implements git_pull(dir, options?). Options: remote and branch.
+// Returns {updated, head}, where updated says whether HEAD moved.
+func gitPull(args ...object.Object) object.Object {
+	strs, opts, errObj := gitArgs("git_pull", args, 1)
+	if errObj != nil {
+		return errObj
+	}
+	dir := strs[0]
+	pullArgs := []string{"pull", "--quiet", "--ff-only"}
+	if remote := getHashStr(opts, "remote"); remote != "" {
+		pullArgs = append(pullArgs, "--", remote)
+		if branch := getHashStr(opts, "branch"); branch != "" {
+			pullArgs = append(pullArgs, branch)
+		}
+	}
+	before := gitHead(dir)
+	if _, err := runGit(dir, pullArgs...); err != nil {
+		return gitError("git_pull", err)
+	}
+	head := gitHead(dir)
+	result := &object.Hash{Pairs: make(map[object.HashKey]object.HashPair)}
+	setHashPair(result, "updated", boolToObj(head != before))
+	setHashPair(result, "head", &object.String{Value: head})
+	return result
+}
+
+// gitCommit implements git_commit(dir, message, options?). By default it
+// stages every change first; {"all": false} commits only what is already
+// staged. Other options: author ("Name <email>") and allow_empty. Returns
+// the new commit's hash, or an error when there is nothing to commit.
+func gitCommit(args ...object.Object) object.Object {
+	strs, opts, errObj := gitArgs("git_commit", args, 2)
+	if errObj != nil {
+		return errObj
+	}
+	dir, message := strs[0], strs[1]
+	if getHashValue(opts, "all") == nil || getHashBool(opts, "all") {
+		if _, err := runGit(dir, "add", "--all"); err != nil {
+			return gitError("git_commit", err)
+		}
+	}
+	commitArgs := []string{"commit", "--quiet", "--message", message}
+	if author := getHashStr(opts, "author"); author != "" {
+		commitArgs = append(commitArgs, "--author", author)
+	}
+	if getHashBool(opts, "allow_empty") {
+		commitArgs = append(commitArgs, "--allow-empty")
+	}
+	if _, err := runGit(dir, commitArgs...); err != nil {
+		if strings.Contains(err.Error(), "nothing to commit") || strings.Contains(err.Error(), "no changes added") {
+			return &object.Error{Message: "git_commit: nothing to commit"}
+		}
+		return gitError("git_commit", err)
+	}
+	return &object.String{Value: gitHead(dir)}
+}
+
+// gitPush implements git_push(dir, options?). Options: remote (default
+// origin), branch (default the current one), set_upstream, tags and force
+// (which uses --force-with-lease). Returns true.
+func gitPush(args ...object.Object) object.Object {
+	strs, opts, errObj := gitArgs("git_push", args, 1)
+	if errObj != nil {
+		return errObj
+	}
+	pushArgs := []string{"push", "--quiet", "--porcelain"}
+	if getHashBool(opts, "set_upstream") {
+		pushArgs = append(pushArgs, "--set-upstream")
+	}
+	if getHashBool(opts, "tags") {
+		pushArgs = append(pushArgs, "--follow-tags")
+	}
+	if getHashBool(opts, "force") {
+		pushArgs = append(pushArgs, "--force-with-lease")
+	}
+	remote := getHashStr(opts, "remote")
+	branch := getHashStr(opts, "branch")
+	if remote != "" || branch != "" {
+		if remote == "" {
+			remote = "origin"
+		}
+		pushArgs = append(pushArgs, "--", remote)
+		if branch != "" {
+			pushArgs = append(pushArgs, branch)
+		}
+	}
+	if _, err := runGit(strs[0], pushArgs...); err != nil {
+		return gitError("git_push", err)
+	}
+	return TRUE
+}
+
+// gitStatus implements git_status(dir): {branch, upstream, ahead, behind,
+// clean, files}, where each file is {path, index, worktree} with the
+// one-letter git status codes ("M", "A", "D", "R", "?" ...), and renamed
+// files also have from.
+func gitStatus(args ...object.Object) object.Object {
+	strs, _, errObj := gitArgs("git_status", args, 1)
+	if errObj != nil {
+		return errObj
+	}
+	out, err := runGit(strs[0], "status", "--porcelain=v2", "--branch", "-z")
+	if err != nil {
+		return gitError("git_status", err)
+	}
+	status := &object.Hash{Pairs: make(map[object.HashKey]object.HashPair)}
+	var branch, upstream string
+	var ahead, behind int64
+	files := []object.Object{}
+
+	fields := strings.Split(out, "\x00")
+	for i := 0; i < len(fields); i++ {
+		line := fields[i]
+		switch {
+		case strings.HasPrefix(line, "# branch.head "):
+			branch = strings.TrimPrefix(line, "# branch.head ")
+		case strings.HasPrefix(line, "# branch.upstream "):
+			upstream = strings.TrimPrefix(line, "# branch.upstream ")
+		case strings.HasPrefix(line, "# branch.ab "):
+			fmt.Sscanf(strings.TrimPrefix(line, "# branch.ab "), "+%d -%d", &ahead, &behind)
+		case strings.HasPrefix(line, "1 "), strings.HasPrefix(line, "u "):
+			// 1 XY sub mH mI mW hH hI path; u has three more fields.
+			parts := strings.SplitN(line, " ", 9)
+			if line[0] == 'u' {
+				parts = strings.SplitN(line, " ", 11)
+			}
+			files = append(files, gitFile(parts[len(parts)-1], parts[1], ""))
+		case strings.HasPrefix(line, "2 "):
+			// 2 XY sub mH mI mW hH hI Xscore path, then the original path.
+			parts := strings.SplitN(line, " ", 10)
+			from := ""
+			if i+1 < len(fields) {
+				i++
+				from = fields[i]
+			}
+			files = append(files, gitFile(parts[9], parts[1], from))
+		case strings.HasPrefix(line, "? "):
+			files = append(files, gitFile(line[2:], "??", ""))
+		}
+	}
+	if branch == "(detached)" {
+		branch = ""
+	}
+	setHashPair(status, "branch", &object.String{Value: branch})
+	setHashPair(status, "upstream", &object.String{Value: upstream})
+	setHashPair(status, "ahead", &object.Integer{Value: ahead})
+	setHashPair(status, "behind", &object.Integer{Value: behind})
+	setHashPair(status, "clean", boolToObj(len(files) == 0))
+	setHashPair(status, "files", &object.Array{Elements: files})
+	return status
+}
+
+func gitFile(path, xy, from string) object.Object {
+	code := func(c byte) object.Object {
+		if c == '.' {
+			return &object.String{Value: ""}
+		}
+		return &object.String{Value: string(c)}
+	}
+	file := &object.Hash{Pairs: make(map[object.HashKey]object.HashPair)}
+	setHashPair(file, "path", &object.String{Value: path})
+	setHashPair(file, "index", code(xy[0]))
+	setHashPair(file, "worktree", code(xy[1]))
+	if from != "" {
+		setHashPair(file, "from", &object.String{Value: from})
+	}
+	return file
+}
@@ -0,0 +1,11 @@
+//go:build unix
+
+// GUI window - gui_run is Windows only; on Unix it reports that
+
+package builtins
+
+import "xon/object"
+
+func guiRun(args ...object.Object) object.Object {
+	return unsupported("gui_run")
+}
@@ -0,0 +1,151 @@
+// GUI window - gui_run on top of Windigo
+
+package builtins
+
+import (
+	"fmt"
+	"runtime"
+	"xon/object"
+
+	"github.com/rodrigocfd/windigo/co"
+	"github.com/rodrigocfd/windigo/ui"
+)
+
+func guiRun(args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return &object.Error{Message: fmt.Sprintf("gui_run expects 1 argument (config hash), got %d", len(args))}
+	}
+	cfg, ok := args[0].(*object.Hash)
+	if !ok {
+		return &object.Error{Message: "gui_run argument must be a hash"}
+	}
+
+	title := getHashStr(cfg, "title")
+	if title == "" {
+		title = "Xon GUI"
+	}
+	width := getHashInt(cfg, "width")
+	height := getHashInt(cfg, "height")
+	if width < 1 {
+		width = 400
+	}
+	if height < 1 {
+		height = 300
+	}
+
+	childrenRaw := getHashArray(cfg, "children")
+	if childrenRaw == nil {
+		childrenRaw = []object.Object{}
+	}
+
+	var callbacks []*object.Closure
+	type editEntry struct {
+		id   string
+		edit *ui.Edit
+	}
+	var entries []editEntry
+
+	// Windigo requires main thread for GUI on Windows
+	runtime.LockOSThread()
+
+	wnd := ui.NewMain(
+		ui.OptsMain().
+			Title(title).
+			Size(int(width), int(height)),
+	)
+
+	y := 20
+	const margin = 20
+	const rowHeight = 28
+	const btnHeight = 32
+	clientW := int(width) - margin*2
+	if clientW < 200 {
+		clientW = 200
+	}
+
+	for _, childObj := range childrenRaw {
+		childHash, ok := childObj.(*object.Hash)
+		if !ok {
+			continue
+		}
+		t := widgetType(childHash)
+		text := getHashStr(childHash, "text")
+		id := getHashStr(childHash, "id")
+
+		switch t {
+		case 1:
+			lbl := ui.NewStatic(wnd, ui.OptsStatic().
+				Text(text).
+				Position(margin, y))
+			_ = lbl
+			y += rowHeight
+		case 2:
+			ed := ui.NewEdit(wnd, ui.OptsEdit().
+				Position(margin, y).
+				Width(clientW).
+				Text(text))
+			if id != "" {
+				entries = append(entries, editEntry{id: id, edit: ed})
+			}
+			y += rowHeight + 4
+		case 3:
+			ed := ui.NewEdit(wnd, ui.OptsEdit().
+				Position(margin, y).
+				Width(clientW).
+				Height(60).
+				CtrlStyle(co.ES_AUTOHSCROLL | co.ES_NOHIDESEL | co.ES_MULTILINE).
+				Text(text))
+			if id != "" {
+				entries = append(entries, editEntry{id: id, edit: ed})
+			}
+			y += 64
+		case 4:
+			idx := len(callbacks)
+			callbacks = append(callbacks, getHashClosure(childHash, "onClick"))
+			btn := ui.NewButton(wnd, ui.OptsButton().
+				Text(text).
+				Position(margin, y).
+				Width(clientW))
+			btn.On().BnClicked(func() {
+				guiInputsMu.Lock()
+				for _, e := range entries {
+					guiInputs[e.id] = e.edit.Text()
+				}
+				guiInputsMu.Unlock()
+				if idx < len(callbacks) && callbacks[idx] != nil && RunClosureCallback != nil {
+					res := RunClosureCallback(callbacks[idx], nil)
+					guiInputsMu.Lock()
+					for k := range guiInputs {
+						delete(guiInputs, k)
+					}
+					guiInputsMu.Unlock()
+					if res != nil && res.Type() != object.ERROR_OBJ && res.Inspect() != "" {
+						wnd.Hwnd().MessageBox(res.Inspect(), "", co.MB_ICONINFORMATION)
+					}
+				}
+			})
+			y += btnHeight
+		case 5:
+			w, h := int(getHashInt(childHash, "width")), int(getHashInt(childHash, "height"))
+			if w < 1 {
+				w = clientW
+			}
+			if h < 1 {
+				h = 200
+			}
+			newGameCanvas(wnd, margin, y, w, h)
+			y += h + 8
+		}
+	}
+
+	ui.NewButton(wnd, ui.OptsButton().
+		Text("Quit").
+		Position(margin, y).
+		Width(clientW)).
+		On().BnClicked(func() {
+			wnd.Hwnd().PostMessage(co.WM_CLOSE, 0, 0)
+		})
+
+	wnd.RunAsMain()
+	return NULL
+}
@@ -0,0 +1,166 @@
+// Help - language overview, /// doc lookup and the builtin registry exposed to scripts
+
+package builtins
+
+import (
+	"fmt"
+	"xon/object"
+)
+
+// ScriptDocs maps documented global names (std library and user script) to
+// their /// comments; the engine points it at the compiler's Docs map.
+var ScriptDocs map[string]string
+
+const helpOverview = `Xon Standard Library
+================================
+std: map, filter, reduce
+ranges: start..end (end excluded), range(start?, end, step?); lazy, for x in 0..1_000_000 builds no array
+help: help() this overview; help("name") or help(fn) shows docs; builtins_list, builtin_help
+arrays: push, pop (return copies); push_mut, pop_mut, arr.push(x), arr.pop() (modify in place)
+slices: arr[1:4], arr[:3], arr[2:], str[0:5] (copies; bounds past the end are clamped)
+pretty: pretty(value, depth?) -> indented string
+null: null literal, is_null(x), ifnull(x, fallback)
+const: set const x (no rebinding), set const deep x (contents frozen too), freeze, is_frozen
+stats: sum, avg, median, stddev, percentile, min_by, max_by, group_by, count_by
+numeric: numarray, num_zeros, num_shape, num_to_array, num_sum, num_dot, num_matmul, num_transpose
+math: PI, E, abs, max, min, pow, random, sqrt
+random: seed, random_choice, random_shuffle, random_range, weighted_choice
+string_utils: split, contains
+chars: ord, chr, bytes, str_from_bytes
+format: num_format, currency_format (USD, EUR, GBP, JPY, CHF)
+time: now, sleep
+queue: queue_push(name, payload, {max_attempts, delay}) -> id, queue_pop(name, {visibility}) -> {id, receipt, payload, attempts}, queue_ack(name, receipt), queue_fail(name, receipt, error), queue_stats, queue_dead, queue_redrive
+cache: memoize(fn), cache_new(ttl_ms) -> get, set, has, delete, clear, size
+git: git_clone(url, dir), git_pull, git_commit(dir, message), git_push, git_status -> {branch, ahead, behind, clean, files}
+docker: docker_ps, docker_run(image, {name, cmd, env, ports, volumes}), docker_stop(id), docker_logs(id)
+xlsx: xlsx_read(path) -> {sheet: [rows]}, xlsx_write(path, rows or {sheet: rows}, {columns})
+speech: tts_speak(text, {voice, rate, wait})
+ui: os_ui_find({name, contains, role, id, window}) -> [{name, role, x, y, ...}], os_ui_invoke(criteria), os_ui_get_text(criteria)
+recording: os_record_start({mouse_moves}), os_record_stop() -> [events], os_replay(events, speed)
+hooks: stop = os_on_key(fn(ev)), os_on_mouse(fn(ev)); ev is {type, key, char} or {type, x, y, button, delta}
+picking: os_pick_point({timeout}) -> {x, y}, os_pick_region({timeout}) -> {x, y, width, height}
+power: battery_status() -> {present, percent, charging, plugged, seconds_left}
+displays: display_list() -> [{name, x, y, width, height, primary}], display_set_brightness(percent, name?)
+network: net_interfaces() -> [{name, mac, up, addresses, ...}], wifi_list() -> [{ssid, signal, security, connected}], wifi_connect(ssid, pass)
+schedule: os_schedule_install(name, "30 2 * * *", scriptPath), os_schedule_remove(name) (crontab or Task Scheduler)
+secrets: secret_get(name) -> string or null, secret_set(name, value) (OS keychain; null deletes)
+config: config_load(defaults, {file, dotenv, env_prefix, args}) -> hash (defaults < file < .env < env < --flags)
+audio: audio_devices() -> [{id, name, kind, default}], audio_record(seconds, "x.wav", {device}), audio_play(path, {device, wait}), volume_get(), volume_set(percent), volume_mute(on)
+colors: color_parse(c) -> {r, g, b, a}, color_hex(c), rgb_to_hsl(c), hsl_to_rgb(h, s, l), color_contrast(a, b), color_nearest(c, palette)
+fs: read, write, remove, exists, lock, unlock, sha256, compare, sync
+os: move_mouse, click, key_tap, exec, pos, alert
+gui: label, button, input, textarea, canvas(width, height), window, run, get, runWindow (native Go/Fyne)
+game: on_frame(fn(dt), fps), draw_clear, draw_rect, draw_circle, draw_line, draw_text, draw_sprite(s, x, y, scale), sprite_load(path), sprite_from(rows, palette), sprite_size, key_down(name), key_hit(name), frame_step(dt, {keys, png})
+http: get, test_http_server(routes) -> url
+browser: browser_open({path, headless, timeout}), browser_goto, browser_click, browser_type, browser_text, browser_screenshot, browser_close
+testing: expect_snapshot(name, value) (--update-snapshots rewrites)
+server: serve, http_serve(port, handler, {access_log, metrics})
+metrics: metric_counter, metric_gauge, metric_histogram, metrics_text, metrics_statsd(addr, {prefix})
+json: json_encode, json_decode
+html: html_parse, html_select(node, "div.item > a[href]"), html_text, html_attr
+log: debug, info, warn, error, with, format (text|json)
+concurrency: spawn, group { spawn ... } (waits, cancels on error), emitter_new() -> on, once, off, emit, count
+operators: |> (pipeline), ?? (null default), ?. (optional member), >> (right shift), ++, --
+error handling: try, catch, throw; error(msg, code?, data?) -> e.message, e.code, e.data, e.stack
+fsm: fsm_new({states, initial, transitions, on_enter, on_exit}) -> state, can, allowed, go, history
+keywords: set, =, match, for, while, do { } while, if, out, spawn, group, try, class
+classes: class Name { field = default; fn init(a) { self.field = a; } fn method() { return self.field; } } -> Name(a), class_of(x)
+hash methods: {"name": "ann", "greet": fn() { return self.name; }}.greet() (self is the hash the function is read from)
+args: argparse(flags, {name, description, positionals, args}) -> options, positionals
+introspection: globals, locals, vm_stats, mem_usage
+reflection: fn_arity, fn_params, has_key, call(f, args), class_of
+context: ctx_new({parent, timeout, interrupt}), ctx_cancel, ctx_done, with_timeout(ms, f); sleep and http_get take ctx last
+conversion: to_int, to_float, to_str, to_bool (error on bad input); try_int, try_float (default instead)
+global: input, key_pressed, int, str, copy, paste, type`
+
+func init() {
+	builtinsMap["help"] = &object.Builtin{Fn: help}
+	builtinsMap["builtins_list"] = &object.Builtin{Fn: builtinsList}
+	builtinsMap["builtin_help"] = &object.Builtin{Fn: builtinHelp}
+}
+
+// help implements help() and help(name|fn): with no argument it prints the
+// overview, otherwise the /// doc comment of a documented function or global.
+func help(args ...object.Object) object.Object {
+	if len(args) > 1 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=0 or 1", len(args))}
+	}
+	if len(args) == 0 {
+		fmt.Println(helpOverview)
+		return NULL
+	}
+	switch arg := args[0].(type) {
+	case *object.String:
+		if doc, ok := ScriptDocs[arg.Value]; ok {
+			fmt.Println(doc)
+		} else if info, ok := LookupInfo(arg.Value); ok {
+			fmt.Printf("%s\n    %s\n", info.Signature, info.Doc)
+		} else {
+			fmt.Printf("%s: no documentation\n", arg.Value)
+		}
+	case *object.Closure:
+		name := arg.Fn.Name
+		if name == "" {
+			name = "fn"
+		}
+		if arg.Fn.Doc != "" {
+			fmt.Println(arg.Fn.Doc)
+		} else {
+			fmt.Printf("%s: no documentation\n", name)
+		}
+	case *object.Builtin:
+		if info, ok := builtinInfoFor(arg); ok {
+			fmt.Printf("%s\n    %s\n", info.Signature, info.Doc)
+		} else {
+			fmt.Println("builtin function")
+		}
+	default:
+		return &object.Error{Message: fmt.Sprintf("argument to `help` must be a name or function, got %s", args[0].Type())}
+	}
+	return NULL
+}
+
+// builtinInfoFor finds the registry entry of a builtin value, such as
+// help(len). Builtins returned by other builtins have no entry.
+func builtinInfoFor(b *object.Builtin) (BuiltinInfo, bool) {
+	for _, info := range Registry {
+		if builtinsMap[info.Name] == b {
+			return info, true
+		}
+	}
+	return BuiltinInfo{}, false
+}
+
+// builtinsList implements builtins_list(): the names of all builtins in
+// registry order.
+func builtinsList(args ...object.Object) object.Object {
+	if len(args) != 0 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=0", len(args))}
+	}
+	elements := make([]object.Object, len(BuiltinNames))
+	for i, name := range BuiltinNames {
+		elements[i] = &object.String{Value: name}
+	}
+	return &object.Array{Elements: elements}
+}
+
+// builtinHelp implements builtin_help(name), returning the registry entry as
+// {"name", "signature", "doc"}, or null for an unknown name.
+func builtinHelp(args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1", len(args))}
+	}
+	name, ok := args[0].(*object.String)
+	if !ok {
+		return &object.Error{Message: fmt.Sprintf("argument to `builtin_help` must be STRING, got %s", args[0].Type())}
+	}
+	info, ok := LookupInfo(name.Value)
+	if !ok {
+		return NULL
+	}
+	h := &object.Hash{Pairs: make(map[object.HashKey]object.HashPair)}
+	setHashPair(h, "name", &object.String{Value: info.Name})
+	setHashPair(h, "signature", &object.String{Value: info.Signature})
+	setHashPair(h, "doc", &object.String{Value: info.Doc})
+	return h
+}
@@ -0,0 +1,74 @@
+// Input hooks - calling script functions for every key and mouse event, system-wide
+
+package builtins
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"xon/object"
+)
+
+func init() {
+	builtinsMap["os_on_key"] = &object.Builtin{Fn: func(args ...object.Object) object.Object {
+		return onInput("os_on_key", args, func(ev inputEvent) bool { return strings.HasPrefix(ev.Kind, "key_") })
+	}}
+	builtinsMap["os_on_mouse"] = &object.Builtin{Fn: func(args ...object.Object) object.Object {
+		return onInput("os_on_mouse", args, func(ev inputEvent) bool { return !strings.HasPrefix(ev.Kind, "key_") })
+	}}
+}
+
+// hookQueue is how many events a slow handler may fall behind before new
+// ones are dropped. The system hook must never wait on a script.
+const hookQueue = 256
+
+// onInput calls fn with each event hash that wants accepts, in order, on a
+// task of its own. It returns a function that removes the hook.
+func onInput(builtin string, args []object.Object, wants func(inputEvent) bool) object.Object {
+	if len(args) != 1 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1", len(args))}
+	}
+	fn := args[0]
+	if fn.Type() != object.CLOSURE_OBJ && fn.Type() != object.BUILTIN_OBJ {
+		return &object.Error{Message: fmt.Sprintf("%s: handler must be a function, got %s", builtin, fn.Type())}
+	}
+
+	// events is never closed: dispatchInput may still be sending to it
+	// just after the hook is removed.
+	events := make(chan inputEvent, hookQueue)
+	done := make(chan struct{})
+	remove, err := listenInput(func(ev inputEvent) bool {
+		if wants(ev) {
+			select {
+			case events <- ev:
+			default:
+			}
+		}
+		return false
+	})
+	if err != nil {
+		return &object.Error{Message: builtin + ": " + err.Error()}
+	}
+	go func() {
+		for {
+			select {
+			case ev := <-events:
+				if res := callFunction(fn, eventHash(ev)); res.Type() == object.ERROR_OBJ {
+					fmt.Fprintf(os.Stderr, "%s handler error: %s\n", builtin, res.(*object.Error).Message)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return &object.Builtin{Fn: func(args ...object.Object) object.Object {
+		once.Do(func() {
+			remove()
+			close(done)
+		})
+		return NULL
+	}}
+}
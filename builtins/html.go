@@ -0,0 +1,462 @@
+// HTML - a forgiving HTML parser with CSS selectors, for scraping pages
+
+package builtins
+
+import (
+	"fmt"
+	"html"
+	"strings"
+	"xon/object"
+)
+
+func init() {
+	builtinsMap["html_parse"] = &object.Builtin{Fn: htmlParse}
+	builtinsMap["html_select"] = &object.Builtin{Fn: htmlSelect}
+	builtinsMap["html_text"] = &object.Builtin{Fn: htmlText}
+	builtinsMap["html_attr"] = &object.Builtin{Fn: htmlAttr}
+}
+
+type htmlNodeKind int
+
+const (
+	htmlDocument htmlNodeKind = iota
+	htmlElement
+	htmlTextNode
+	htmlComment
+)
+
+// htmlNode is a document, element, text or comment. Text and attribute
+// values are kept with their entities decoded.
+type htmlNode struct {
+	kind     htmlNodeKind
+	tag      string // lower-case element name
+	attrs    []htmlAttribute
+	text     string // text and comment content
+	parent   *htmlNode
+	children []*htmlNode
+}
+
+type htmlAttribute struct {
+	name, value string
+}
+
+func (n *htmlNode) Type() object.ObjectType { return "HTML_NODE" }
+func (n *htmlNode) Inspect() string {
+	var out strings.Builder
+	n.render(&out)
+	return out.String()
+}
+
+func (n *htmlNode) attr(name string) (string, bool) {
+	for _, a := range n.attrs {
+		if a.name == name {
+			return a.value, true
+		}
+	}
+	return "", false
+}
+
+// elements returns the element children of n.
+func (n *htmlNode) elements() []*htmlNode {
+	var els []*htmlNode
+	for _, c := range n.children {
+		if c.kind == htmlElement {
+			els = append(els, c)
+		}
+	}
+	return els
+}
+
+func (n *htmlNode) render(out *strings.Builder) {
+	switch n.kind {
+	case htmlTextNode:
+		if n.parent != nil && (n.parent.tag == "script" || n.parent.tag == "style") {
+			out.WriteString(n.text)
+		} else {
+			out.WriteString(html.EscapeString(n.text))
+		}
+		return
+	case htmlComment:
+		out.WriteString("<!--" + n.text + "-->")
+		return
+	case htmlElement:
+		out.WriteString("<" + n.tag)
+		for _, a := range n.attrs {
+			fmt.Fprintf(out, " %s=\"%s\"", a.name, html.EscapeString(a.value))
+		}
+		out.WriteString(">")
+		if htmlVoid[n.tag] {
+			return
+		}
+	}
+	for _, c := range n.children {
+		c.render(out)
+	}
+	if n.kind == htmlElement {
+		out.WriteString("</" + n.tag + ">")
+	}
+}
+
+// textContent collects the text under n, leaving out scripts and styles,
+// with runs of whitespace collapsed to one space.
+func (n *htmlNode) textContent() string {
+	var out strings.Builder
+	var walk func(*htmlNode)
+	walk = func(n *htmlNode) {
+		if n.kind == htmlTextNode {
+			out.WriteString(n.text)
+			out.WriteByte(' ')
+		}
+		if n.tag == "script" || n.tag == "style" {
+			return
+		}
+		for _, c := range n.children {
+			walk(c)
+		}
+	}
+	walk(n)
+	return strings.Join(strings.Fields(out.String()), " ")
+}
+
+var htmlVoid = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true, "img": true,
+	"input": true, "link": true, "meta": true, "param": true, "source": true, "track": true, "wbr": true,
+}
+
+// htmlRawText elements hold text up to their end tag, without markup.
+var htmlRawText = map[string]bool{"script": true, "style": true, "textarea": true, "title": true}
+
+// htmlClosesP are the start tags that end an open paragraph.
+var htmlClosesP = map[string]bool{
+	"address": true, "article": true, "aside": true, "blockquote": true, "div": true, "dl": true,
+	"fieldset": true, "footer": true, "form": true, "h1": true, "h2": true, "h3": true, "h4": true,
+	"h5": true, "h6": true, "header": true, "hr": true, "main": true, "nav": true, "ol": true,
+	"p": true, "pre": true, "section": true, "table": true, "ul": true,
+}
+
+// htmlImpliedEnd says which open elements a start tag closes, up to (not
+// past) the given boundaries: a new <li> ends the previous one in its list.
+var htmlImpliedEnd = map[string]struct{ closes, boundary []string }{
+	"li":     {[]string{"li"}, []string{"ul", "ol"}},
+	"dt":     {[]string{"dt", "dd"}, []string{"dl"}},
+	"dd":     {[]string{"dt", "dd"}, []string{"dl"}},
+	"tr":     {[]string{"tr", "td", "th"}, []string{"table", "thead", "tbody", "tfoot"}},
+	"td":     {[]string{"td", "th"}, []string{"tr", "table"}},
+	"th":     {[]string{"td", "th"}, []string{"tr", "table"}},
+	"option": {[]string{"option"}, []string{"select", "datalist"}},
+	"thead":  {[]string{"thead", "tbody", "tfoot", "tr", "td", "th"}, []string{"table"}},
+	"tbody":  {[]string{"thead", "tbody", "tfoot", "tr", "td", "th"}, []string{"table"}},
+	"tfoot":  {[]string{"thead", "tbody", "tfoot", "tr", "td", "th"}, []string{"table"}},
+}
+
+// parseHTML builds a tree from src the way browsers do for everyday
+// markup: tags are case-insensitive, void elements need no end tag,
+// common end tags may be left out, and stray end tags are ignored. It
+// never fails.
+func parseHTML(src string) *htmlNode {
+	doc := &htmlNode{kind: htmlDocument}
+	stack := []*htmlNode{doc}
+	current := func() *htmlNode { return stack[len(stack)-1] }
+	appendChild := func(n *htmlNode) {
+		p := current()
+		n.parent = p
+		p.children = append(p.children, n)
+	}
+	addText := func(text string) {
+		if text == "" {
+			return
+		}
+		p := current()
+		if last := len(p.children) - 1; last >= 0 && p.children[last].kind == htmlTextNode {
+			p.children[last].text += text
+			return
+		}
+		appendChild(&htmlNode{kind: htmlTextNode, text: text})
+	}
+	// closeTag pops up to and including the innermost open tag.
+	closeTag := func(tag string) {
+		for i := len(stack) - 1; i > 0; i-- {
+			if stack[i].tag == tag {
+				stack = stack[:i]
+				return
+			}
+		}
+	}
+	// closeImplied pops up to and including the outermost of tags that
+	// is open inside the innermost boundary, so a new <tr> ends both the
+	// open cell and its row.
+	closeImplied := func(tags []string, boundaries []string) {
+		to := 0
+		for i := len(stack) - 1; i > 0 && !contains(boundaries, stack[i].tag); i-- {
+			if contains(tags, stack[i].tag) {
+				to = i
+			}
+		}
+		if to > 0 {
+			stack = stack[:to]
+		}
+	}
+
+	for i := 0; i < len(src); {
+		lt := strings.IndexByte(src[i:], '<')
+		if lt < 0 {
+			addText(html.UnescapeString(src[i:]))
+			break
+		}
+		addText(html.UnescapeString(src[i : i+lt]))
+		i += lt
+		rest := src[i:]
+
+		switch {
+		case strings.HasPrefix(rest, "<!--"):
+			end := strings.Index(rest[4:], "-->")
+			if end < 0 {
+				appendChild(&htmlNode{kind: htmlComment, text: rest[4:]})
+				i = len(src)
+			} else {
+				appendChild(&htmlNode{kind: htmlComment, text: rest[4 : 4+end]})
+				i += 4 + end + 3
+			}
+		case strings.HasPrefix(rest, "<!") || strings.HasPrefix(rest, "<?"):
+			// Doctype or processing instruction.
+			end := strings.IndexByte(rest, '>')
+			if end < 0 {
+				i = len(src)
+			} else {
+				i += end + 1
+			}
+		case strings.HasPrefix(rest, "</"):
+			name, _ := htmlTagName(rest[2:])
+			end := strings.IndexByte(rest, '>')
+			if name == "" || end < 0 {
+				addText("<")
+				i++
+				continue
+			}
+			i += end + 1
+			closeTag(name)
+		default:
+			name, n := htmlTagName(rest[1:])
+			if name == "" {
+				addText("<")
+				i++
+				continue
+			}
+			attrs, selfClosing, size := htmlAttributes(rest[1+n:])
+			i += 1 + n + size
+
+			if htmlClosesP[name] {
+				closeImplied([]string{"p"}, []string{"button", "td", "th", "li"})
+			}
+			if implied, ok := htmlImpliedEnd[name]; ok {
+				closeImplied(implied.closes, implied.boundary)
+			}
+			el := &htmlNode{kind: htmlElement, tag: name, attrs: attrs}
+			appendChild(el)
+			if htmlVoid[name] || selfClosing {
+				continue
+			}
+			if htmlRawText[name] {
+				body := src[i:]
+				end := strings.Index(strings.ToLower(body), "</"+name)
+				if end < 0 {
+					end = len(body)
+				}
+				text := body[:end]
+				if name == "textarea" || name == "title" {
+					text = html.UnescapeString(text)
+				}
+				if text != "" {
+					el.children = append(el.children, &htmlNode{kind: htmlTextNode, text: text, parent: el})
+				}
+				i += end
+				if close := strings.IndexByte(src[i:], '>'); close >= 0 {
+					i += close + 1
+				} else {
+					i = len(src)
+				}
+				continue
+			}
+			stack = append(stack, el)
+		}
+	}
+	return doc
+}
+
+// htmlTagName reads a tag name at the start of s, returning it in lower
+// case with the number of bytes it took.
+func htmlTagName(s string) (string, int) {
+	n := 0
+	for n < len(s) && (isASCIILetter(s[n]) || (n > 0 && (s[n] >= '0' && s[n] <= '9' || s[n] == '-' || s[n] == ':'))) {
+		n++
+	}
+	return strings.ToLower(s[:n]), n
+}
+
+func isASCIILetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// htmlAttributes reads the attributes of a start tag from s, which begins
+// after the tag name, up to and including the closing '>'.
+func htmlAttributes(s string) (attrs []htmlAttribute, selfClosing bool, size int) {
+	i := 0
+	for i < len(s) {
+		switch c := s[i]; {
+		case c == '>':
+			return attrs, selfClosing, i + 1
+		case c == '/':
+			selfClosing = true
+			i++
+			continue
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f':
+			i++
+			continue
+		}
+		selfClosing = false
+		start := i
+		for i < len(s) && !strings.ContainsRune(" \t\n\r\f/>=", rune(s[i])) {
+			i++
+		}
+		name := strings.ToLower(s[start:i])
+		for i < len(s) && strings.ContainsRune(" \t\n\r\f", rune(s[i])) {
+			i++
+		}
+		var value string
+		if i < len(s) && s[i] == '=' {
+			i++
+			for i < len(s) && strings.ContainsRune(" \t\n\r\f", rune(s[i])) {
+				i++
+			}
+			if i < len(s) && (s[i] == '"' || s[i] == '\'') {
+				quote := s[i]
+				end := strings.IndexByte(s[i+1:], quote)
+				if end < 0 {
+					end = len(s) - i - 1
+				}
+				value = s[i+1 : i+1+end]
+				i += end + 2
+			} else {
+				start := i
+				for i < len(s) && !strings.ContainsRune(" \t\n\r\f>", rune(s[i])) {
+					i++
+				}
+				value = s[start:i]
+			}
+		}
+		// The first of repeated attributes wins, as in browsers.
+		if name != "" && !hasAttribute(attrs, name) {
+			attrs = append(attrs, htmlAttribute{name, html.UnescapeString(value)})
+		}
+	}
+	return attrs, selfClosing, len(s)
+}
+
+func hasAttribute(attrs []htmlAttribute, name string) bool {
+	for _, a := range attrs {
+		if a.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// htmlParse implements html_parse(s): the document tree of the HTML in s.
+func htmlParse(args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1", len(args))}
+	}
+	s, ok := args[0].(*object.String)
+	if !ok {
+		return &object.Error{Message: fmt.Sprintf("argument to `html_parse` must be STRING, got %s", args[0].Type())}
+	}
+	return parseHTML(s.Value)
+}
+
+// htmlNodeArg accepts a node, or a string of HTML to parse.
+func htmlNodeArg(name string, arg object.Object) (*htmlNode, *object.Error) {
+	switch a := arg.(type) {
+	case *htmlNode:
+		return a, nil
+	case *object.String:
+		return parseHTML(a.Value), nil
+	}
+	return nil, &object.Error{Message: fmt.Sprintf("first argument to `%s` must be an HTML node or STRING, got %s", name, arg.Type())}
+}
+
+// htmlSelect implements html_select(node, selector): the elements under
+// node that match the CSS selector, in document order.
+func htmlSelect(args ...object.Object) object.Object {
+	if len(args) != 2 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=2", len(args))}
+	}
+	root, errObj := htmlNodeArg("html_select", args[0])
+	if errObj != nil {
+		return errObj
+	}
+	s, ok := args[1].(*object.String)
+	if !ok {
+		return &object.Error{Message: fmt.Sprintf("second argument to `html_select` must be STRING, got %s", args[1].Type())}
+	}
+	sel, err := parseSelector(s.Value)
+	if err != nil {
+		return &object.Error{Message: fmt.Sprintf("html_select: %s", err)}
+	}
+	var matches []object.Object
+	var walk func(*htmlNode)
+	walk = func(n *htmlNode) {
+		for _, c := range n.children {
+			if c.kind != htmlElement {
+				continue
+			}
+			if sel.matches(c) {
+				matches = append(matches, c)
+			}
+			walk(c)
+		}
+	}
+	walk(root)
+	return &object.Array{Elements: matches}
+}
+
+// htmlText implements html_text(node): its text, without tags, scripts or
+// styles, and with whitespace collapsed.
+func htmlText(args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1", len(args))}
+	}
+	n, errObj := htmlNodeArg("html_text", args[0])
+	if errObj != nil {
+		return errObj
+	}
+	return &object.String{Value: n.textContent()}
+}
+
+// htmlAttr implements html_attr(node, name): the attribute's value, or
+// null when the element does not have it.
+func htmlAttr(args ...object.Object) object.Object {
+	if len(args) != 2 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=2", len(args))}
+	}
+	n, ok := args[0].(*htmlNode)
+	if !ok {
+		return &object.Error{Message: fmt.Sprintf("first argument to `html_attr` must be an HTML node, got %s", args[0].Type())}
+	}
+	name, ok := args[1].(*object.String)
+	if !ok {
+		return &object.Error{Message: fmt.Sprintf("second argument to `html_attr` must be STRING, got %s", args[1].Type())}
+	}
+	if v, ok := n.attr(strings.ToLower(name.Value)); ok {
+		return &object.String{Value: v}
+	}
+	return NULL
+}
@@ -0,0 +1,426 @@
+// HTML selectors - the CSS selector subset html_select understands
+
+package builtins
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A selector is a comma-separated group of complex selectors such as
+// "div.item > a[href]". Supported: type, *, #id, .class, [attr], [attr=v]
+// and the ^= $= *= ~= |= variants, :first-child, :last-child,
+// :nth-child(n|odd|even), :not(...), and the descendant, >, + and ~
+// combinators.
+type selector []complexSelector
+
+// complexSelector is compound selectors joined by combinators, kept
+// right to left: parts[0] is the subject and combinators[i] joins parts[i]
+// to parts[i+1].
+type complexSelector struct {
+	parts       []compoundSelector
+	combinators []byte // ' ', '>', '+' or '~'
+}
+
+type compoundSelector struct {
+	tag     string // "" or "*" for any
+	id      string
+	classes []string
+	attrs   []attrSelector
+	pseudos []pseudoSelector
+}
+
+type attrSelector struct {
+	name, op, value string // op is "" for presence
+}
+
+type pseudoSelector struct {
+	name string
+	nth  int      // :nth-child; 0 with odd or even set in name
+	not  selector // :not
+}
+
+func (s selector) matches(n *htmlNode) bool {
+	for _, c := range s {
+		if c.matches(n) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c complexSelector) matches(n *htmlNode) bool {
+	return c.matchFrom(0, n)
+}
+
+// matchFrom reports whether n matches parts[i] and what is left of the
+// selector matches n's relatives.
+func (c complexSelector) matchFrom(i int, n *htmlNode) bool {
+	if !c.parts[i].matches(n) {
+		return false
+	}
+	if i == len(c.parts)-1 {
+		return true
+	}
+	switch c.combinators[i] {
+	case '>':
+		return n.parent != nil && n.parent.kind == htmlElement && c.matchFrom(i+1, n.parent)
+	case ' ':
+		for p := n.parent; p != nil && p.kind == htmlElement; p = p.parent {
+			if c.matchFrom(i+1, p) {
+				return true
+			}
+		}
+	case '+':
+		if prev := previousSibling(n); prev != nil {
+			return c.matchFrom(i+1, prev)
+		}
+	case '~':
+		for prev := previousSibling(n); prev != nil; prev = previousSibling(prev) {
+			if c.matchFrom(i+1, prev) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func previousSibling(n *htmlNode) *htmlNode {
+	if n.parent == nil {
+		return nil
+	}
+	var prev *htmlNode
+	for _, el := range n.parent.elements() {
+		if el == n {
+			return prev
+		}
+		prev = el
+	}
+	return nil
+}
+
+// childIndex is n's 1-based position among its parent's elements, and
+// their count.
+func childIndex(n *htmlNode) (int, int) {
+	if n.parent == nil {
+		return 1, 1
+	}
+	els := n.parent.elements()
+	for i, el := range els {
+		if el == n {
+			return i + 1, len(els)
+		}
+	}
+	return 0, len(els)
+}
+
+func (c compoundSelector) matches(n *htmlNode) bool {
+	if n.kind != htmlElement {
+		return false
+	}
+	if c.tag != "" && c.tag != "*" && c.tag != n.tag {
+		return false
+	}
+	if c.id != "" {
+		if id, _ := n.attr("id"); id != c.id {
+			return false
+		}
+	}
+	if len(c.classes) > 0 {
+		class, _ := n.attr("class")
+		have := strings.Fields(class)
+		for _, want := range c.classes {
+			if !contains(have, want) {
+				return false
+			}
+		}
+	}
+	for _, a := range c.attrs {
+		if !a.matches(n) {
+			return false
+		}
+	}
+	for _, p := range c.pseudos {
+		if !p.matches(n) {
+			return false
+		}
+	}
+	return true
+}
+
+func (a attrSelector) matches(n *htmlNode) bool {
+	v, ok := n.attr(a.name)
+	if !ok {
+		return false
+	}
+	switch a.op {
+	case "":
+		return true
+	case "=":
+		return v == a.value
+	case "^=":
+		return a.value != "" && strings.HasPrefix(v, a.value)
+	case "$=":
+		return a.value != "" && strings.HasSuffix(v, a.value)
+	case "*=":
+		return a.value != "" && strings.Contains(v, a.value)
+	case "~=":
+		return contains(strings.Fields(v), a.value)
+	case "|=":
+		return v == a.value || strings.HasPrefix(v, a.value+"-")
+	}
+	return false
+}
+
+func (p pseudoSelector) matches(n *htmlNode) bool {
+	index, count := childIndex(n)
+	switch p.name {
+	case "first-child":
+		return index == 1
+	case "last-child":
+		return index == count
+	case "nth-child":
+		return index == p.nth
+	case "nth-child(odd)":
+		return index%2 == 1
+	case "nth-child(even)":
+		return index%2 == 0
+	case "not":
+		return !p.not.matches(n)
+	}
+	return false
+}
+
+// parseSelector parses a selector group such as "ul li a, h1".
+func parseSelector(src string) (selector, error) {
+	p := &selectorParser{src: src}
+	sel, err := p.group()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.src) {
+		return nil, p.errorf("unexpected %q", p.src[p.pos])
+	}
+	return sel, nil
+}
+
+type selectorParser struct {
+	src string
+	pos int
+}
+
+func (p *selectorParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("bad selector %q at %d: %s", p.src, p.pos+1, fmt.Sprintf(format, args...))
+}
+
+func (p *selectorParser) skipSpace() bool {
+	start := p.pos
+	for p.pos < len(p.src) && strings.IndexByte(" \t\n\r\f", p.src[p.pos]) >= 0 {
+		p.pos++
+	}
+	return p.pos > start
+}
+
+func (p *selectorParser) peek() byte {
+	if p.pos < len(p.src) {
+		return p.src[p.pos]
+	}
+	return 0
+}
+
+// group parses complex selectors separated by commas, stopping at the end
+// or at a ')' closing :not(...).
+func (p *selectorParser) group() (selector, error) {
+	var sel selector
+	for {
+		c, err := p.complex()
+		if err != nil {
+			return nil, err
+		}
+		sel = append(sel, c)
+		p.skipSpace()
+		if p.peek() != ',' {
+			return sel, nil
+		}
+		p.pos++
+	}
+}
+
+func (p *selectorParser) complex() (complexSelector, error) {
+	var parts []compoundSelector
+	var combinators []byte
+	p.skipSpace()
+	for {
+		part, err := p.compound()
+		if err != nil {
+			return complexSelector{}, err
+		}
+		parts = append(parts, part)
+
+		spaced := p.skipSpace()
+		c := p.peek()
+		switch {
+		case c == '>' || c == '+' || c == '~':
+			p.pos++
+			p.skipSpace()
+		case c == 0 || c == ',' || c == ')':
+			// Reverse so matching starts from the subject.
+			for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+				parts[i], parts[j] = parts[j], parts[i]
+			}
+			for i, j := 0, len(combinators)-1; i < j; i, j = i+1, j-1 {
+				combinators[i], combinators[j] = combinators[j], combinators[i]
+			}
+			return complexSelector{parts: parts, combinators: combinators}, nil
+		case spaced:
+			c = ' '
+		default:
+			return complexSelector{}, p.errorf("unexpected %q", c)
+		}
+		combinators = append(combinators, c)
+	}
+}
+
+func (p *selectorParser) compound() (compoundSelector, error) {
+	var c compoundSelector
+	start := p.pos
+	if p.peek() == '*' {
+		p.pos++
+		c.tag = "*"
+	} else if name := p.name(); name != "" {
+		c.tag = strings.ToLower(name)
+	}
+	for {
+		switch p.peek() {
+		case '#':
+			p.pos++
+			if c.id = p.name(); c.id == "" {
+				return c, p.errorf("expected an id after #")
+			}
+		case '.':
+			p.pos++
+			class := p.name()
+			if class == "" {
+				return c, p.errorf("expected a class name after .")
+			}
+			c.classes = append(c.classes, class)
+		case '[':
+			a, err := p.attribute()
+			if err != nil {
+				return c, err
+			}
+			c.attrs = append(c.attrs, a)
+		case ':':
+			ps, err := p.pseudo()
+			if err != nil {
+				return c, err
+			}
+			c.pseudos = append(c.pseudos, ps)
+		default:
+			if p.pos == start {
+				if p.pos == len(p.src) {
+					return c, p.errorf("expected a selector")
+				}
+				return c, p.errorf("unexpected %q", p.src[p.pos])
+			}
+			return c, nil
+		}
+	}
+}
+
+// name reads an identifier: letters, digits, '-' and '_'.
+func (p *selectorParser) name() string {
+	start := p.pos
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if !(isASCIILetter(c) || c >= '0' && c <= '9' || c == '-' || c == '_' || c >= 0x80) {
+			break
+		}
+		p.pos++
+	}
+	return p.src[start:p.pos]
+}
+
+func (p *selectorParser) attribute() (attrSelector, error) {
+	p.pos++ // [
+	p.skipSpace()
+	var a attrSelector
+	if a.name = strings.ToLower(p.name()); a.name == "" {
+		return a, p.errorf("expected an attribute name")
+	}
+	p.skipSpace()
+	if p.peek() == ']' {
+		p.pos++
+		return a, nil
+	}
+	for _, op := range []string{"=", "^=", "$=", "*=", "~=", "|="} {
+		if strings.HasPrefix(p.src[p.pos:], op) {
+			a.op = op
+			p.pos += len(op)
+			break
+		}
+	}
+	if a.op == "" {
+		return a, p.errorf("expected ], =, ^=, $=, *=, ~= or |=")
+	}
+	p.skipSpace()
+	if q := p.peek(); q == '"' || q == '\'' {
+		end := strings.IndexByte(p.src[p.pos+1:], q)
+		if end < 0 {
+			return a, p.errorf("unterminated string")
+		}
+		a.value = p.src[p.pos+1 : p.pos+1+end]
+		p.pos += end + 2
+	} else if a.value = p.name(); a.value == "" {
+		return a, p.errorf("expected an attribute value")
+	}
+	p.skipSpace()
+	if p.peek() != ']' {
+		return a, p.errorf("expected ]")
+	}
+	p.pos++
+	return a, nil
+}
+
+func (p *selectorParser) pseudo() (pseudoSelector, error) {
+	p.pos++ // :
+	ps := pseudoSelector{name: strings.ToLower(p.name())}
+	switch ps.name {
+	case "first-child", "last-child":
+		return ps, nil
+	case "nth-child", "not":
+	default:
+		return ps, p.errorf("unsupported pseudo-class :%s", ps.name)
+	}
+	if p.peek() != '(' {
+		return ps, p.errorf("expected ( after :%s", ps.name)
+	}
+	p.pos++
+	p.skipSpace()
+	if ps.name == "not" {
+		not, err := p.group()
+		if err != nil {
+			return ps, err
+		}
+		ps.not = not
+	} else {
+		arg := strings.ToLower(p.name())
+		switch arg {
+		case "odd", "even":
+			ps.name = "nth-child(" + arg + ")"
+		default:
+			n, err := strconv.Atoi(arg)
+			if err != nil || n < 1 {
+				return ps, p.errorf("expected a position, odd or even in :nth-child()")
+			}
+			ps.nth = n
+		}
+		p.skipSpace()
+	}
+	if p.peek() != ')' {
+		return ps, p.errorf("expected )")
+	}
+	p.pos++
+	return ps, nil
+}
@@ -0,0 +1,151 @@
+// HTTP middleware - access logging and a metrics endpoint for http_serve
+
+package builtins
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"xon/object"
+)
+
+// serveOptions are the options hash of http_serve.
+type serveOptions struct {
+	accessLog   bool   // log each request at info level
+	metricsPath string // serve metrics here; "" for none
+}
+
+func parseServeOptions(opts *object.Hash) serveOptions {
+	var o serveOptions
+	o.accessLog = getHashBool(opts, "access_log")
+	switch v := getHashValue(opts, "metrics").(type) {
+	case *object.String:
+		o.metricsPath = v.Value
+	case *object.Boolean:
+		if v.Value {
+			o.metricsPath = "/metrics"
+		}
+	}
+	return o
+}
+
+// withMiddleware wraps next with what opts ask for. The metrics endpoint
+// itself is neither logged nor counted.
+func withMiddleware(next http.Handler, opts serveOptions) http.Handler {
+	if !opts.accessLog && opts.metricsPath == "" {
+		return next
+	}
+	metrics := newHTTPMetrics()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if opts.metricsPath != "" && r.URL.Path == opts.metricsPath {
+			metrics.ServeHTTP(w, r)
+			return
+		}
+		rec := &statusRecorder{ResponseWriter: w}
+		start := time.Now()
+		next.ServeHTTP(rec, r)
+		latency := time.Since(start)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		if opts.metricsPath != "" {
+			metrics.observe(r.Method, rec.status, latency)
+		}
+		if opts.accessLog {
+			writeLogLine("info", "http request", map[string]object.Object{
+				"method":     &object.String{Value: r.Method},
+				"path":       &object.String{Value: r.URL.Path},
+				"status":     &object.Integer{Value: int64(rec.status)},
+				"latency_ms": &object.Float{Value: float64(latency.Microseconds()) / 1000},
+			})
+		}
+	})
+}
+
+// statusRecorder remembers the status a handler wrote.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// latencyBuckets are the upper bounds, in seconds, of the request duration
+// histogram.
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// httpMetrics counts a server's requests by method and status and keeps a
+// histogram of their durations, served in the Prometheus text format along
+// with the script's own metrics.
+type httpMetrics struct {
+	mu       sync.Mutex
+	requests map[string]int64 // by `method="GET",status="200"` labels
+	buckets  []int64          // requests per latencyBuckets bound, not cumulative
+	count    int64
+	sum      float64
+}
+
+func newHTTPMetrics() *httpMetrics {
+	return &httpMetrics{
+		requests: make(map[string]int64),
+		buckets:  make([]int64, len(latencyBuckets)),
+	}
+}
+
+func (m *httpMetrics) observe(method string, status int, latency time.Duration) {
+	seconds := latency.Seconds()
+	labels := fmt.Sprintf("method=%q,status=\"%d\"", method, status)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[labels]++
+	m.count++
+	m.sum += seconds
+	if i := sort.SearchFloat64s(latencyBuckets, seconds); i < len(latencyBuckets) {
+		m.buckets[i]++
+	}
+}
+
+func (m *httpMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out strings.Builder
+	out.WriteString("# HELP http_requests_total Requests answered, by method and status.\n")
+	out.WriteString("# TYPE http_requests_total counter\n")
+	labels := make([]string, 0, len(m.requests))
+	for l := range m.requests {
+		labels = append(labels, l)
+	}
+	sort.Strings(labels)
+	for _, l := range labels {
+		fmt.Fprintf(&out, "http_requests_total{%s} %d\n", l, m.requests[l])
+	}
+	out.WriteString("# HELP http_request_duration_seconds Time taken to answer requests.\n")
+	out.WriteString("# TYPE http_request_duration_seconds histogram\n")
+	var cumulative int64
+	for i, bound := range latencyBuckets {
+		cumulative += m.buckets[i]
+		fmt.Fprintf(&out, "http_request_duration_seconds_bucket{le=%q} %d\n", strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+	}
+	fmt.Fprintf(&out, "http_request_duration_seconds_bucket{le=\"+Inf\"} %d\n", m.count)
+	fmt.Fprintf(&out, "http_request_duration_seconds_sum %g\n", m.sum)
+	fmt.Fprintf(&out, "http_request_duration_seconds_count %d\n", m.count)
+	out.WriteString(writeMetrics())
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprint(w, out.String())
+}
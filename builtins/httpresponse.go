@@ -0,0 +1,81 @@
+// HTTP responses - turning what a script's request handler returns into a response
+
+package builtins
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"xon/object"
+)
+
+// HTTPDebug sends the message of a failed handler to the client in the
+// 500 response; otherwise the client only sees "Internal Server Error" and
+// the message goes to stderr. main sets it for --debug.
+var HTTPDebug bool
+
+// requestHash describes r to a script handler: method, path, query,
+// headers (with lower-case names) and body.
+func requestHash(r *http.Request) *object.Hash {
+	body, _ := io.ReadAll(r.Body)
+	headers := &object.Hash{Pairs: make(map[object.HashKey]object.HashPair)}
+	for name := range r.Header {
+		setHashPair(headers, strings.ToLower(name), &object.String{Value: r.Header.Get(name)})
+	}
+	req := &object.Hash{Pairs: make(map[object.HashKey]object.HashPair)}
+	setHashPair(req, "method", &object.String{Value: r.Method})
+	setHashPair(req, "path", &object.String{Value: r.URL.Path})
+	setHashPair(req, "query", &object.String{Value: r.URL.RawQuery})
+	setHashPair(req, "headers", headers)
+	setHashPair(req, "body", &object.String{Value: string(body)})
+	return req
+}
+
+// writeResponse writes what a handler returned. A hash with any of
+// "status", "body" and "headers" describes the response; an error, from a
+// throw or a runtime failure, becomes a 500; anything else is the body of
+// a 200.
+func writeResponse(w http.ResponseWriter, response object.Object) {
+	switch res := response.(type) {
+	case *object.Error:
+		fmt.Fprintf(os.Stderr, "http handler error: %s\n", res.Message)
+		message := http.StatusText(http.StatusInternalServerError)
+		if HTTPDebug {
+			message = res.Message
+		}
+		http.Error(w, message, http.StatusInternalServerError)
+		return
+	case *object.Hash:
+		if isResponseHash(res) {
+			writeResponseHash(w, res)
+			return
+		}
+	}
+	io.WriteString(w, response.Inspect())
+}
+
+func isResponseHash(h *object.Hash) bool {
+	return getHashValue(h, "status") != nil || getHashValue(h, "body") != nil || getHashValue(h, "headers") != nil
+}
+
+func writeResponseHash(w http.ResponseWriter, res *object.Hash) {
+	status := int(getHashInt(res, "status"))
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if status < 100 || status > 999 {
+		writeResponse(w, &object.Error{Message: fmt.Sprintf("invalid HTTP status %d", status)})
+		return
+	}
+	if headers, ok := getHashValue(res, "headers").(*object.Hash); ok {
+		for _, pair := range headers.Pairs {
+			w.Header().Set(pair.Key.Inspect(), pair.Value.Inspect())
+		}
+	}
+	w.WriteHeader(status)
+	if body := getHashValue(res, "body"); body != nil {
+		io.WriteString(w, body.Inspect())
+	}
+}
@@ -0,0 +1,69 @@
+// Introspection - globals, locals, VM counters and memory usage for debugging long-running scripts
+
+package builtins
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"xon/object"
+)
+
+// GlobalNames reports the name of each global slot; the engine points it at
+// the compiler's GlobalNames so globals() can label VMGlobals.
+var GlobalNames func() []string
+
+func init() {
+	builtinsMap["globals"] = &object.Builtin{Fn: globalsHash}
+	builtinsMap["mem_usage"] = &object.Builtin{Fn: memUsage}
+	// locals and vm_stats describe the calling VM, which runs them itself;
+	// these bodies only answer calls made from outside a VM.
+	builtinsMap["locals"] = &object.Builtin{Fn: vmOnly("locals")}
+	builtinsMap["vm_stats"] = &object.Builtin{Fn: vmOnly("vm_stats")}
+}
+
+func vmOnly(name string) object.BuiltinFunction {
+	return func(args ...object.Object) object.Object {
+		return &object.Error{Message: fmt.Sprintf("%s() must be called directly from script code", name)}
+	}
+}
+
+// globalsHash implements globals(): a hash of every assigned global,
+// including the standard library's. The compiler's hidden slots for module
+// members (labelled module.name) are left out; the module itself is listed.
+func globalsHash(args ...object.Object) object.Object {
+	if len(args) != 0 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=0", len(args))}
+	}
+	h := &object.Hash{Pairs: make(map[object.HashKey]object.HashPair)}
+	if GlobalNames == nil || VMGlobals == nil {
+		return h
+	}
+	names := GlobalNames()
+	VMGlobalsMu.RLock()
+	defer VMGlobalsMu.RUnlock()
+	for i, name := range names {
+		if i < len(VMGlobals) && VMGlobals[i] != nil && !strings.Contains(name, ".") {
+			setHashPair(h, name, VMGlobals[i])
+		}
+	}
+	return h
+}
+
+// memUsage implements mem_usage(): Go heap figures for the whole process,
+// in bytes, plus the GC count and number of goroutines (spawned tasks).
+func memUsage(args ...object.Object) object.Object {
+	if len(args) != 0 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=0", len(args))}
+	}
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	h := &object.Hash{Pairs: make(map[object.HashKey]object.HashPair)}
+	setHashPair(h, "heap_alloc", &object.Integer{Value: int64(m.HeapAlloc)})
+	setHashPair(h, "heap_objects", &object.Integer{Value: int64(m.HeapObjects)})
+	setHashPair(h, "sys", &object.Integer{Value: int64(m.Sys)})
+	setHashPair(h, "total_alloc", &object.Integer{Value: int64(m.TotalAlloc)})
+	setHashPair(h, "num_gc", &object.Integer{Value: int64(m.NumGC)})
+	setHashPair(h, "goroutines", &object.Integer{Value: int64(runtime.NumGoroutine())})
+	return h
+}
@@ -0,0 +1,127 @@
+// Locks - OS-level exclusive file locks so separate script instances can coordinate
+
+package builtins
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+	"xon/object"
+)
+
+const lockPollInterval = 50 * time.Millisecond
+
+// heldLock is a lock fs_lock holds: the open lock file and the task that
+// took it.
+type heldLock struct {
+	f    *os.File
+	task int64
+}
+
+var (
+	heldLocksMu sync.Mutex
+	heldLocks   = make(map[string]heldLock)
+)
+
+func init() {
+	builtinsMap["fs_lock"] = &object.Builtin{Fn: func(args ...object.Object) object.Object {
+		return FsLock(0, context.Background(), args...)
+	}}
+	builtinsMap["fs_unlock"] = &object.Builtin{Fn: fsUnlock}
+}
+
+// FsLock implements fs_lock(path, options?) for task, the VM task calling
+// it. The lock file is created if missing. By default it waits until the
+// lock is free; options "timeout" (ms) bounds the wait and {"wait": false}
+// tries once. Returns true when acquired and false when the lock is held
+// elsewhere. Each call opens the file anew and the OS lock belongs to that
+// open file, so another task of this script waits for the lock just as
+// another process does; locking a path task already holds is an error, as
+// that wait would never end. The wait polls, so it also ends, returning
+// false, once ctx is done.
+func FsLock(task int64, ctx context.Context, args ...object.Object) object.Object {
+	if len(args) != 1 && len(args) != 2 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1 or 2", len(args))}
+	}
+	path, ok := args[0].(*object.String)
+	if !ok {
+		return &object.Error{Message: "first argument to fs_lock must be STRING"}
+	}
+	wait := true
+	timeout := time.Duration(-1)
+	if len(args) == 2 {
+		opts, ok := args[1].(*object.Hash)
+		if !ok {
+			return &object.Error{Message: "fs_lock options must be a hash"}
+		}
+		if getHashValue(opts, "wait") != nil {
+			wait = getHashBool(opts, "wait")
+		}
+		if getHashValue(opts, "timeout") != nil {
+			timeout = time.Duration(getHashInt(opts, "timeout")) * time.Millisecond
+		}
+	}
+
+	heldLocksMu.Lock()
+	held, ok := heldLocks[path.Value]
+	heldLocksMu.Unlock()
+	if ok && held.task == task {
+		return &object.Error{Message: fmt.Sprintf("fs_lock: %s is already locked by this task", path.Value)}
+	}
+
+	f, err := os.OpenFile(path.Value, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return &object.Error{Message: fmt.Sprintf("fs_lock: %s", err)}
+	}
+
+	// heldLocksMu is not held while waiting, so a long wait here does not
+	// stall fs_unlock or other locks in the same process.
+	deadline := time.Now().Add(timeout)
+	for {
+		acquired, err := lockFile(f, false)
+		if acquired {
+			heldLocksMu.Lock()
+			heldLocks[path.Value] = heldLock{f: f, task: task}
+			heldLocksMu.Unlock()
+			return TRUE
+		}
+		if err != nil {
+			f.Close()
+			return &object.Error{Message: fmt.Sprintf("fs_lock: %s", err)}
+		}
+		if !wait || (timeout >= 0 && time.Now().After(deadline)) {
+			f.Close()
+			return FALSE
+		}
+		select {
+		case <-ctx.Done():
+			f.Close()
+			return FALSE
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
+
+// fsUnlock releases a lock taken with fs_lock. Unlocking a path that is not
+// held by this script returns false.
+func fsUnlock(args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1", len(args))}
+	}
+	path, ok := args[0].(*object.String)
+	if !ok {
+		return &object.Error{Message: "argument to fs_unlock must be STRING"}
+	}
+	heldLocksMu.Lock()
+	defer heldLocksMu.Unlock()
+	held, ok := heldLocks[path.Value]
+	if !ok {
+		return FALSE
+	}
+	delete(heldLocks, path.Value)
+	unlockFile(held.f)
+	held.f.Close()
+	return TRUE
+}
@@ -0,0 +1,141 @@
+// Logging - leveled log lines in text or JSON-lines format, with child loggers carrying fields
+
+package builtins
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"xon/object"
+)
+
+var (
+	logMu     sync.Mutex
+	logJSON   bool
+	logLevels = []string{"debug", "info", "warn", "error"}
+)
+
+func init() {
+	builtinsMap["log_debug"] = &object.Builtin{Fn: logAt("debug", nil)}
+	builtinsMap["log_info"] = &object.Builtin{Fn: logAt("info", nil)}
+	builtinsMap["log_warn"] = &object.Builtin{Fn: logAt("warn", nil)}
+	builtinsMap["log_error"] = &object.Builtin{Fn: logAt("error", nil)}
+	builtinsMap["log_with"] = &object.Builtin{Fn: logWith(nil)}
+	builtinsMap["log_format"] = &object.Builtin{Fn: logFormat}
+}
+
+// logAt returns a builtin that writes msg at the given level. The optional
+// second argument is a hash of extra fields, merged over the bound fields.
+func logAt(level string, bound map[string]object.Object) object.BuiltinFunction {
+	return func(args ...object.Object) object.Object {
+		if len(args) < 1 || len(args) > 2 {
+			return &object.Error{Message: fmt.Sprintf("log_%s expects 1 or 2 arguments (message, fields), got %d", level, len(args))}
+		}
+		fields := bound
+		if len(args) == 2 {
+			extra, ok := args[1].(*object.Hash)
+			if !ok {
+				return &object.Error{Message: fmt.Sprintf("log_%s fields must be a hash, got %s", level, args[1].Type())}
+			}
+			fields = mergeLogFields(bound, extra)
+		}
+		writeLogLine(level, args[0].Inspect(), fields)
+		return NULL
+	}
+}
+
+// logWith returns a builtin creating a child logger: a hash of level
+// functions (plus its own "with") that always include the given fields.
+func logWith(bound map[string]object.Object) object.BuiltinFunction {
+	return func(args ...object.Object) object.Object {
+		if len(args) != 1 {
+			return &object.Error{Message: fmt.Sprintf("log_with expects 1 argument (fields hash), got %d", len(args))}
+		}
+		extra, ok := args[0].(*object.Hash)
+		if !ok {
+			return &object.Error{Message: "log_with argument must be a hash"}
+		}
+		fields := mergeLogFields(bound, extra)
+
+		logger := &object.Hash{Pairs: make(map[object.HashKey]object.HashPair)}
+		for _, level := range logLevels {
+			setHashPair(logger, level, &object.Builtin{Fn: logAt(level, fields)})
+		}
+		setHashPair(logger, "with", &object.Builtin{Fn: logWith(fields)})
+		return logger
+	}
+}
+
+func logFormat(args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return &object.Error{Message: fmt.Sprintf("log_format expects 1 argument (\"text\" or \"json\"), got %d", len(args))}
+	}
+	format, ok := args[0].(*object.String)
+	if !ok || (format.Value != "text" && format.Value != "json") {
+		return &object.Error{Message: "log_format argument must be \"text\" or \"json\""}
+	}
+	logMu.Lock()
+	logJSON = format.Value == "json"
+	logMu.Unlock()
+	return NULL
+}
+
+func mergeLogFields(bound map[string]object.Object, extra *object.Hash) map[string]object.Object {
+	fields := make(map[string]object.Object, len(bound)+len(extra.Pairs))
+	for k, v := range bound {
+		fields[k] = v
+	}
+	for _, pair := range extra.Pairs {
+		fields[pair.Key.Inspect()] = pair.Value
+	}
+	return fields
+}
+
+func writeLogLine(level, msg string, fields map[string]object.Object) {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	now := time.Now().Format(time.RFC3339)
+
+	logMu.Lock()
+	defer logMu.Unlock()
+
+	if logJSON {
+		line := make(map[string]interface{}, len(fields)+3)
+		for _, k := range keys {
+			line[k] = objToRaw(fields[k])
+		}
+		line["time"] = now
+		line["level"] = level
+		line["msg"] = msg
+		res, err := json.Marshal(line)
+		if err != nil {
+			fmt.Fprintf(Stdout, "{\"level\":\"error\",\"msg\":%q}\n", "log encoding error: "+err.Error())
+			return
+		}
+		fmt.Fprintln(Stdout, string(res))
+		return
+	}
+
+	var out strings.Builder
+	out.WriteString(now)
+	out.WriteString(" ")
+	out.WriteString(strings.ToUpper(level))
+	out.WriteString(" ")
+	out.WriteString(msg)
+	for _, k := range keys {
+		fmt.Fprintf(&out, " %s=%s", k, fields[k].Inspect())
+	}
+	fmt.Fprintln(Stdout, out.String())
+}
+
+func setHashPair(h *object.Hash, key string, val object.Object) {
+	k := &object.String{Value: key}
+	h.Pairs[k.HashKey()] = object.HashPair{Key: k, Value: val}
+}
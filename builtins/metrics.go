@@ -0,0 +1,360 @@
+// Metrics - counters, gauges and histograms, pulled in the Prometheus text format or pushed to statsd
+
+package builtins
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"xon/object"
+)
+
+func init() {
+	builtinsMap["metric_counter"] = &object.Builtin{Fn: metricCounter}
+	builtinsMap["metric_gauge"] = &object.Builtin{Fn: metricGauge}
+	builtinsMap["metric_histogram"] = &object.Builtin{Fn: metricHistogram}
+	builtinsMap["metrics_text"] = &object.Builtin{Fn: metricsText}
+	builtinsMap["metrics_statsd"] = &object.Builtin{Fn: metricsStatsd}
+}
+
+var metricName = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+// metric is one registered counter, gauge or histogram.
+type metric struct {
+	kind  string // "counter", "gauge" or "histogram"
+	name  string
+	help  string
+	value float64 // counters and gauges
+
+	// Histograms only.
+	bounds []float64
+	counts []int64 // observations per bound, not cumulative
+	count  int64
+	sum    float64
+}
+
+var (
+	metricsMu  sync.Mutex
+	registered = make(map[string]*metric)
+
+	// statsd, when set by metrics_statsd, receives every update.
+	statsd       net.Conn
+	statsdPrefix string
+)
+
+// registerMetric returns the metric called name, creating it if needed.
+// Asking for an existing name with another kind is an error.
+func registerMetric(builtin, kind string, args []object.Object) (*metric, *object.Error) {
+	if len(args) < 1 || len(args) > 2 {
+		return nil, &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1 or 2", len(args))}
+	}
+	name, ok := args[0].(*object.String)
+	if !ok || !metricName.MatchString(name.Value) {
+		return nil, &object.Error{Message: fmt.Sprintf("first argument to `%s` must be a metric name such as \"jobs_done_total\"", builtin)}
+	}
+	var help string
+	var opts *object.Hash
+	if len(args) == 2 {
+		switch a := args[1].(type) {
+		case *object.String:
+			help = a.Value
+		case *object.Hash:
+			opts = a
+			if s, ok := getHashValue(a, "help").(*object.String); ok {
+				help = s.Value
+			}
+		default:
+			return nil, &object.Error{Message: fmt.Sprintf("second argument to `%s` must be a help STRING or an options HASH, got %s", builtin, args[1].Type())}
+		}
+	}
+
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	if m, ok := registered[name.Value]; ok {
+		if m.kind != kind {
+			return nil, &object.Error{Message: fmt.Sprintf("%s: %s is already a %s", builtin, name.Value, m.kind)}
+		}
+		return m, nil
+	}
+	m := &metric{kind: kind, name: name.Value, help: help}
+	if kind == "histogram" {
+		m.bounds = latencyBuckets
+		if opts != nil && getHashValue(opts, "buckets") != nil {
+			bounds, err := histogramBounds(builtin, getHashValue(opts, "buckets"))
+			if err != nil {
+				return nil, err
+			}
+			m.bounds = bounds
+		}
+		m.counts = make([]int64, len(m.bounds))
+	}
+	registered[name.Value] = m
+	return m, nil
+}
+
+func histogramBounds(builtin string, obj object.Object) ([]float64, *object.Error) {
+	arr, ok := obj.(*object.Array)
+	if !ok || len(arr.Elements) == 0 {
+		return nil, &object.Error{Message: fmt.Sprintf("%s buckets must be a non-empty array of increasing numbers", builtin)}
+	}
+	bounds := make([]float64, len(arr.Elements))
+	for i, el := range arr.Elements {
+		v, ok := toFloat(el)
+		if !ok || (i > 0 && v <= bounds[i-1]) {
+			return nil, &object.Error{Message: fmt.Sprintf("%s buckets must be a non-empty array of increasing numbers", builtin)}
+		}
+		bounds[i] = v
+	}
+	return bounds, nil
+}
+
+// metricCounter implements metric_counter(name, help?): a hash with inc(n?)
+// and value(). Counters only go up.
+func metricCounter(args ...object.Object) object.Object {
+	m, err := registerMetric("metric_counter", "counter", args)
+	if err != nil {
+		return err
+	}
+	h := &object.Hash{Pairs: make(map[object.HashKey]object.HashPair)}
+	setHashPair(h, "inc", &object.Builtin{Fn: func(args ...object.Object) object.Object {
+		n, errObj := metricAmount("counter.inc", args)
+		if errObj != nil {
+			return errObj
+		}
+		if n < 0 {
+			return &object.Error{Message: "counter.inc: a counter cannot go down"}
+		}
+		return m.add(n)
+	}})
+	setHashPair(h, "value", &object.Builtin{Fn: m.current})
+	return h
+}
+
+// metricGauge implements metric_gauge(name, help?): a hash with set(v),
+// inc(n?), dec(n?) and value().
+func metricGauge(args ...object.Object) object.Object {
+	m, err := registerMetric("metric_gauge", "gauge", args)
+	if err != nil {
+		return err
+	}
+	h := &object.Hash{Pairs: make(map[object.HashKey]object.HashPair)}
+	setHashPair(h, "set", &object.Builtin{Fn: func(args ...object.Object) object.Object {
+		if len(args) != 1 {
+			return &object.Error{Message: fmt.Sprintf("gauge.set: wrong number of arguments. got=%d, want=1", len(args))}
+		}
+		v, ok := toFloat(args[0])
+		if !ok {
+			return &object.Error{Message: fmt.Sprintf("gauge.set: value must be a number, got %s", args[0].Type())}
+		}
+		metricsMu.Lock()
+		m.value = v
+		metricsMu.Unlock()
+		sendStatsd(m.name, v, "g")
+		return NULL
+	}})
+	setHashPair(h, "inc", &object.Builtin{Fn: func(args ...object.Object) object.Object {
+		n, errObj := metricAmount("gauge.inc", args)
+		if errObj != nil {
+			return errObj
+		}
+		return m.add(n)
+	}})
+	setHashPair(h, "dec", &object.Builtin{Fn: func(args ...object.Object) object.Object {
+		n, errObj := metricAmount("gauge.dec", args)
+		if errObj != nil {
+			return errObj
+		}
+		return m.add(-n)
+	}})
+	setHashPair(h, "value", &object.Builtin{Fn: m.current})
+	return h
+}
+
+// metricHistogram implements metric_histogram(name, options?), where
+// options is a help string or a hash with help and buckets (increasing
+// upper bounds; by default request latencies in seconds). The result is a
+// hash with observe(v), count() and sum().
+func metricHistogram(args ...object.Object) object.Object {
+	m, err := registerMetric("metric_histogram", "histogram", args)
+	if err != nil {
+		return err
+	}
+	h := &object.Hash{Pairs: make(map[object.HashKey]object.HashPair)}
+	setHashPair(h, "observe", &object.Builtin{Fn: func(args ...object.Object) object.Object {
+		if len(args) != 1 {
+			return &object.Error{Message: fmt.Sprintf("histogram.observe: wrong number of arguments. got=%d, want=1", len(args))}
+		}
+		v, ok := toFloat(args[0])
+		if !ok {
+			return &object.Error{Message: fmt.Sprintf("histogram.observe: value must be a number, got %s", args[0].Type())}
+		}
+		metricsMu.Lock()
+		m.count++
+		m.sum += v
+		if i := sort.SearchFloat64s(m.bounds, v); i < len(m.bounds) {
+			m.counts[i]++
+		}
+		metricsMu.Unlock()
+		sendStatsd(m.name, v, "h")
+		return NULL
+	}})
+	setHashPair(h, "count", &object.Builtin{Fn: func(args ...object.Object) object.Object {
+		metricsMu.Lock()
+		defer metricsMu.Unlock()
+		return &object.Integer{Value: m.count}
+	}})
+	setHashPair(h, "sum", &object.Builtin{Fn: func(args ...object.Object) object.Object {
+		metricsMu.Lock()
+		defer metricsMu.Unlock()
+		return &object.Float{Value: m.sum}
+	}})
+	return h
+}
+
+// metricAmount reads the optional amount of inc and dec, 1 by default.
+func metricAmount(name string, args []object.Object) (float64, *object.Error) {
+	if len(args) > 1 {
+		return 0, &object.Error{Message: fmt.Sprintf("%s: wrong number of arguments. got=%d, want=0 or 1", name, len(args))}
+	}
+	if len(args) == 0 {
+		return 1, nil
+	}
+	n, ok := toFloat(args[0])
+	if !ok {
+		return 0, &object.Error{Message: fmt.Sprintf("%s: amount must be a number, got %s", name, args[0].Type())}
+	}
+	return n, nil
+}
+
+func (m *metric) add(n float64) object.Object {
+	metricsMu.Lock()
+	m.value += n
+	metricsMu.Unlock()
+	if m.kind == "counter" {
+		sendStatsd(m.name, n, "c")
+	} else {
+		// statsd gauges take signed changes as "+n" or "-n".
+		sendStatsd(m.name, n, "g", "+")
+	}
+	return NULL
+}
+
+func (m *metric) current(args ...object.Object) object.Object {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	return numberObject(m.value)
+}
+
+// numberObject returns v as an INTEGER when it is whole, else a FLOAT.
+func numberObject(v float64) object.Object {
+	if v == float64(int64(v)) {
+		return &object.Integer{Value: int64(v)}
+	}
+	return &object.Float{Value: v}
+}
+
+// metricsText implements metrics_text(): every metric in the Prometheus
+// text format, for a handler to serve. http_serve's metrics endpoint
+// includes it.
+func metricsText(args ...object.Object) object.Object {
+	if len(args) != 0 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=0", len(args))}
+	}
+	return &object.String{Value: writeMetrics()}
+}
+
+func writeMetrics() string {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	names := make([]string, 0, len(registered))
+	for name := range registered {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var out strings.Builder
+	for _, name := range names {
+		m := registered[name]
+		if m.help != "" {
+			fmt.Fprintf(&out, "# HELP %s %s\n", name, m.help)
+		}
+		fmt.Fprintf(&out, "# TYPE %s %s\n", name, m.kind)
+		if m.kind != "histogram" {
+			fmt.Fprintf(&out, "%s %s\n", name, formatMetric(m.value))
+			continue
+		}
+		var cumulative int64
+		for i, bound := range m.bounds {
+			cumulative += m.counts[i]
+			fmt.Fprintf(&out, "%s_bucket{le=%q} %d\n", name, formatMetric(bound), cumulative)
+		}
+		fmt.Fprintf(&out, "%s_bucket{le=\"+Inf\"} %d\n", name, m.count)
+		fmt.Fprintf(&out, "%s_sum %s\n", name, formatMetric(m.sum))
+		fmt.Fprintf(&out, "%s_count %d\n", name, m.count)
+	}
+	return out.String()
+}
+
+func formatMetric(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// metricsStatsd implements metrics_statsd(addr, options?): from then on
+// every update is also sent over UDP to the statsd server at addr
+// ("host:port"), with options "prefix" put before each name. null stops
+// sending.
+func metricsStatsd(args ...object.Object) object.Object {
+	if len(args) != 1 && len(args) != 2 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1 or 2", len(args))}
+	}
+	var conn net.Conn
+	var prefix string
+	switch addr := args[0].(type) {
+	case *object.Null:
+	case *object.String:
+		c, err := net.Dial("udp", addr.Value)
+		if err != nil {
+			return &object.Error{Message: fmt.Sprintf("metrics_statsd: %s", err)}
+		}
+		conn = c
+	default:
+		return &object.Error{Message: fmt.Sprintf("first argument to `metrics_statsd` must be STRING (host:port) or null, got %s", args[0].Type())}
+	}
+	if len(args) == 2 {
+		opts, ok := args[1].(*object.Hash)
+		if !ok {
+			return &object.Error{Message: "metrics_statsd options must be a hash"}
+		}
+		if s, ok := getHashValue(opts, "prefix").(*object.String); ok {
+			prefix = s.Value
+		}
+	}
+
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	if statsd != nil {
+		statsd.Close()
+	}
+	statsd, statsdPrefix = conn, prefix
+	return TRUE
+}
+
+// sendStatsd sends one statsd line, such as "jobs:1|c". sign is "+" for a
+// relative gauge change, which needs an explicit sign when positive.
+// Sending is best effort; a lost packet is not an error.
+func sendStatsd(name string, v float64, kind string, sign ...string) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	if statsd == nil {
+		return
+	}
+	value := formatMetric(v)
+	if len(sign) > 0 && v >= 0 {
+		value = sign[0] + value
+	}
+	fmt.Fprintf(statsd, "%s%s:%s|%s", statsdPrefix, name, value, kind)
+}
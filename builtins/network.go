@@ -0,0 +1,207 @@
+// Network - interfaces, and listing and joining Wi-Fi networks through nmcli or netsh
+
+package builtins
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"xon/object"
+)
+
+func init() {
+	builtinsMap["net_interfaces"] = &object.Builtin{Fn: netInterfaces}
+	builtinsMap["wifi_list"] = &object.Builtin{Fn: wifiListBuiltin}
+	builtinsMap["wifi_connect"] = &object.Builtin{Fn: wifiConnectBuiltin}
+}
+
+// wifiNetwork is one network in range. Signal is a percentage.
+type wifiNetwork struct {
+	SSID      string
+	Signal    int64
+	Security  string
+	Connected bool
+}
+
+// netInterfaces returns [{name, mac, up, loopback, mtu, addresses}], each
+// address in CIDR form such as "192.168.1.20/24".
+func netInterfaces(args ...object.Object) object.Object {
+	if len(args) != 0 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=0", len(args))}
+	}
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return &object.Error{Message: "net_interfaces: " + err.Error()}
+	}
+	elements := make([]object.Object, 0, len(ifaces))
+	for _, iface := range ifaces {
+		addrs, _ := iface.Addrs()
+		addresses := make([]object.Object, len(addrs))
+		for i, a := range addrs {
+			addresses[i] = &object.String{Value: a.String()}
+		}
+		h := &object.Hash{Pairs: make(map[object.HashKey]object.HashPair)}
+		setHashPair(h, "name", &object.String{Value: iface.Name})
+		setHashPair(h, "mac", &object.String{Value: iface.HardwareAddr.String()})
+		setHashPair(h, "up", boolToObj(iface.Flags&net.FlagUp != 0))
+		setHashPair(h, "loopback", boolToObj(iface.Flags&net.FlagLoopback != 0))
+		setHashPair(h, "mtu", &object.Integer{Value: int64(iface.MTU)})
+		setHashPair(h, "addresses", &object.Array{Elements: addresses})
+		elements = append(elements, h)
+	}
+	return &object.Array{Elements: elements}
+}
+
+// wifiListBuiltin returns the networks in range, strongest first, as
+// [{ssid, signal, security, connected}].
+func wifiListBuiltin(args ...object.Object) object.Object {
+	if len(args) != 0 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=0", len(args))}
+	}
+	networks, err := wifiList()
+	if err != nil {
+		return &object.Error{Message: "wifi_list: " + err.Error()}
+	}
+	sort.SliceStable(networks, func(i, j int) bool { return networks[i].Signal > networks[j].Signal })
+	elements := make([]object.Object, len(networks))
+	for i, n := range networks {
+		h := &object.Hash{Pairs: make(map[object.HashKey]object.HashPair)}
+		setHashPair(h, "ssid", &object.String{Value: n.SSID})
+		setHashPair(h, "signal", &object.Integer{Value: n.Signal})
+		setHashPair(h, "security", &object.String{Value: n.Security})
+		setHashPair(h, "connected", boolToObj(n.Connected))
+		elements[i] = h
+	}
+	return &object.Array{Elements: elements}
+}
+
+// wifiConnectBuiltin joins a network, with a WPA passphrase or, for an
+// open network, none.
+func wifiConnectBuiltin(args ...object.Object) object.Object {
+	if len(args) < 1 || len(args) > 2 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1 or 2", len(args))}
+	}
+	strs := make([]string, 2)
+	for i, arg := range args {
+		s, ok := arg.(*object.String)
+		if !ok {
+			return &object.Error{Message: fmt.Sprintf("wifi_connect: arguments must be STRING, got %s", arg.Type())}
+		}
+		strs[i] = s.Value
+	}
+	if strs[0] == "" {
+		return &object.Error{Message: "wifi_connect: ssid is empty"}
+	}
+	if err := wifiConnect(strs[0], strs[1]); err != nil {
+		return &object.Error{Message: "wifi_connect: " + err.Error()}
+	}
+	return TRUE
+}
+
+// splitNmcli splits a line of `nmcli -t` output, where a colon inside a
+// field is written \: and a backslash \\.
+func splitNmcli(line string) []string {
+	var fields []string
+	var field strings.Builder
+	for i := 0; i < len(line); i++ {
+		switch {
+		case line[i] == '\\' && i+1 < len(line):
+			i++
+			field.WriteByte(line[i])
+		case line[i] == ':':
+			fields = append(fields, field.String())
+			field.Reset()
+		default:
+			field.WriteByte(line[i])
+		}
+	}
+	return append(fields, field.String())
+}
+
+// parseNmcli reads `nmcli -t -f IN-USE,SSID,SIGNAL,SECURITY dev wifi list`.
+// Hidden networks, with no SSID, are left out, and an SSID heard from
+// several access points is listed once with its strongest signal.
+func parseNmcli(out string) []wifiNetwork {
+	var networks []wifiNetwork
+	seen := make(map[string]int)
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		f := splitNmcli(line)
+		if len(f) != 4 || f[1] == "" {
+			continue
+		}
+		signal, _ := strconv.ParseInt(f[2], 10, 64)
+		n := wifiNetwork{SSID: f[1], Signal: signal, Security: f[3], Connected: f[0] == "*"}
+		if i, ok := seen[n.SSID]; ok {
+			networks[i].Signal = max(networks[i].Signal, n.Signal)
+			networks[i].Connected = networks[i].Connected || n.Connected
+			continue
+		}
+		seen[n.SSID] = len(networks)
+		networks = append(networks, n)
+	}
+	return networks
+}
+
+// parseNetsh reads `netsh wlan show networks mode=bssid`, taking the
+// strongest access point of each network. connected is the SSID from
+// `netsh wlan show interfaces`, if any.
+func parseNetsh(out, connected string) []wifiNetwork {
+	var networks []wifiNetwork
+	for _, line := range strings.Split(out, "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		switch {
+		case strings.HasPrefix(key, "SSID "):
+			networks = append(networks, wifiNetwork{SSID: value, Connected: value != "" && value == connected})
+		case len(networks) == 0:
+		case key == "Authentication":
+			networks[len(networks)-1].Security = value
+		case key == "Signal":
+			signal, _ := strconv.ParseInt(strings.TrimSuffix(value, "%"), 10, 64)
+			n := &networks[len(networks)-1]
+			n.Signal = max(n.Signal, signal)
+		}
+	}
+	kept := networks[:0]
+	for _, n := range networks {
+		if n.SSID != "" {
+			kept = append(kept, n)
+		}
+	}
+	return kept
+}
+
+// netshConnected returns the SSID in `netsh wlan show interfaces` output.
+func netshConnected(out string) string {
+	for _, line := range strings.Split(out, "\n") {
+		if key, value, ok := strings.Cut(line, ":"); ok && strings.TrimSpace(key) == "SSID" {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
+
+// wlanProfile is the profile netsh adds before it can connect: WPA2 with
+// the passphrase, or an open network without one.
+func wlanProfile(ssid, pass string) string {
+	security := `<authEncryption><authentication>open</authentication><encryption>none</encryption><useOneX>false</useOneX></authEncryption>`
+	if pass != "" {
+		security = `<authEncryption><authentication>WPA2PSK</authentication><encryption>AES</encryption><useOneX>false</useOneX></authEncryption>` +
+			`<sharedKey><keyType>passPhrase</keyType><protected>false</protected><keyMaterial>` + xmlEscape(pass) + `</keyMaterial></sharedKey>`
+	}
+	name := xmlEscape(ssid)
+	return `<?xml version="1.0"?>
+<WLANProfile xmlns="http://www.microsoft.com/networking/WLAN/profile/v1">
+<name>` + name + `</name>
+<SSIDConfig><SSID><name>` + name + `</name></SSID></SSIDConfig>
+<connectionType>ESS</connectionType>
+<connectionMode>auto</connectionMode>
+<MSM><security>` + security + `</security></MSM>
+</WLANProfile>
+`
+}
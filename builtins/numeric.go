@@ -0,0 +1,218 @@
+// Numeric - NUMARRAY construction, conversion and linear algebra (dot, matmul, transpose)
+
+package builtins
+
+import (
+	"fmt"
+	"xon/object"
+)
+
+func init() {
+	builtinsMap["numarray"] = &object.Builtin{Fn: numArrayNew}
+	builtinsMap["num_zeros"] = &object.Builtin{Fn: numZeros}
+	builtinsMap["num_shape"] = &object.Builtin{Fn: numShape}
+	builtinsMap["num_to_array"] = &object.Builtin{Fn: numToArray}
+	builtinsMap["num_sum"] = &object.Builtin{Fn: numSum}
+	builtinsMap["num_dot"] = &object.Builtin{Fn: numDot}
+	builtinsMap["num_matmul"] = &object.Builtin{Fn: numMatmul}
+	builtinsMap["num_transpose"] = &object.Builtin{Fn: numTranspose}
+}
+
+// numArrayNew converts an array of numbers to a vector, or an array of
+// equal-length number arrays to a matrix.
+func numArrayNew(args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1", len(args))}
+	}
+	switch arg := args[0].(type) {
+	case *object.NumArray:
+		return &object.NumArray{Data: append([]float64(nil), arg.Data...), Shape: append([]int(nil), arg.Shape...)}
+	case *object.Array:
+		if len(arg.Elements) > 0 {
+			if _, ok := arg.Elements[0].(*object.Array); ok {
+				return matrixFromRows(arg.Elements)
+			}
+		}
+		data := make([]float64, len(arg.Elements))
+		for i, el := range arg.Elements {
+			f, ok := toFloat(el)
+			if !ok {
+				return &object.Error{Message: fmt.Sprintf("numarray elements must be numbers, got %s", el.Type())}
+			}
+			data[i] = f
+		}
+		return &object.NumArray{Data: data, Shape: []int{len(data)}}
+	default:
+		return &object.Error{Message: fmt.Sprintf("argument to `numarray` must be ARRAY, got %s", args[0].Type())}
+	}
+}
+
+func matrixFromRows(rows []object.Object) object.Object {
+	cols := -1
+	data := []float64{}
+	for _, rowObj := range rows {
+		row, ok := rowObj.(*object.Array)
+		if !ok {
+			return &object.Error{Message: fmt.Sprintf("numarray rows must be arrays, got %s", rowObj.Type())}
+		}
+		if cols == -1 {
+			cols = len(row.Elements)
+		} else if len(row.Elements) != cols {
+			return &object.Error{Message: fmt.Sprintf("numarray rows must have equal length, got %d and %d", cols, len(row.Elements))}
+		}
+		for _, el := range row.Elements {
+			f, ok := toFloat(el)
+			if !ok {
+				return &object.Error{Message: fmt.Sprintf("numarray elements must be numbers, got %s", el.Type())}
+			}
+			data = append(data, f)
+		}
+	}
+	return &object.NumArray{Data: data, Shape: []int{len(rows), cols}}
+}
+
+func numZeros(args ...object.Object) object.Object {
+	if len(args) != 1 && len(args) != 2 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1 or 2", len(args))}
+	}
+	shape := make([]int, len(args))
+	size := 1
+	for i, arg := range args {
+		n, ok := arg.(*object.Integer)
+		if !ok || n.Value < 0 {
+			return &object.Error{Message: "arguments to `num_zeros` must be non-negative INTEGER"}
+		}
+		shape[i] = int(n.Value)
+		size *= int(n.Value)
+	}
+	return &object.NumArray{Data: make([]float64, size), Shape: shape}
+}
+
+func numShape(args ...object.Object) object.Object {
+	na, errObj := numArrayArg("num_shape", args, 1)
+	if errObj != nil {
+		return errObj
+	}
+	elements := make([]object.Object, len(na.Shape))
+	for i, d := range na.Shape {
+		elements[i] = &object.Integer{Value: int64(d)}
+	}
+	return &object.Array{Elements: elements}
+}
+
+func numToArray(args ...object.Object) object.Object {
+	na, errObj := numArrayArg("num_to_array", args, 1)
+	if errObj != nil {
+		return errObj
+	}
+	if len(na.Shape) == 2 {
+		rows := make([]object.Object, na.Shape[0])
+		for r := range rows {
+			rows[r] = floatsToArray(na.Data[r*na.Shape[1] : (r+1)*na.Shape[1]])
+		}
+		return &object.Array{Elements: rows}
+	}
+	return floatsToArray(na.Data)
+}
+
+func numSum(args ...object.Object) object.Object {
+	na, errObj := numArrayArg("num_sum", args, 1)
+	if errObj != nil {
+		return errObj
+	}
+	total := 0.0
+	for _, v := range na.Data {
+		total += v
+	}
+	return &object.Float{Value: total}
+}
+
+func numDot(args ...object.Object) object.Object {
+	if len(args) != 2 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=2", len(args))}
+	}
+	a, ok1 := args[0].(*object.NumArray)
+	b, ok2 := args[1].(*object.NumArray)
+	if !ok1 || !ok2 || len(a.Shape) != 1 || len(b.Shape) != 1 {
+		return &object.Error{Message: "arguments to `num_dot` must be NUMARRAY vectors"}
+	}
+	if len(a.Data) != len(b.Data) {
+		return &object.Error{Message: fmt.Sprintf("num_dot length mismatch: %d and %d", len(a.Data), len(b.Data))}
+	}
+	total := 0.0
+	for i := range a.Data {
+		total += a.Data[i] * b.Data[i]
+	}
+	return &object.Float{Value: total}
+}
+
+// numMatmul multiplies a [r, k] matrix by a [k, c] matrix, or by a length-k
+// vector giving a length-r vector.
+func numMatmul(args ...object.Object) object.Object {
+	if len(args) != 2 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=2", len(args))}
+	}
+	a, ok1 := args[0].(*object.NumArray)
+	b, ok2 := args[1].(*object.NumArray)
+	if !ok1 || !ok2 || len(a.Shape) != 2 {
+		return &object.Error{Message: "arguments to `num_matmul` must be (NUMARRAY matrix, NUMARRAY)"}
+	}
+	rows, inner := a.Shape[0], a.Shape[1]
+	cols := 1
+	if len(b.Shape) == 2 {
+		cols = b.Shape[1]
+	}
+	if b.Shape[0] != inner {
+		return &object.Error{Message: fmt.Sprintf("num_matmul shape mismatch: %v x %v", a.Shape, b.Shape)}
+	}
+	data := make([]float64, rows*cols)
+	for r := 0; r < rows; r++ {
+		for k := 0; k < inner; k++ {
+			av := a.Data[r*inner+k]
+			for c := 0; c < cols; c++ {
+				data[r*cols+c] += av * b.Data[k*cols+c]
+			}
+		}
+	}
+	if len(b.Shape) == 1 {
+		return &object.NumArray{Data: data, Shape: []int{rows}}
+	}
+	return &object.NumArray{Data: data, Shape: []int{rows, cols}}
+}
+
+func numTranspose(args ...object.Object) object.Object {
+	na, errObj := numArrayArg("num_transpose", args, 1)
+	if errObj != nil {
+		return errObj
+	}
+	if len(na.Shape) != 2 {
+		return &object.Error{Message: "argument to `num_transpose` must be a NUMARRAY matrix"}
+	}
+	rows, cols := na.Shape[0], na.Shape[1]
+	data := make([]float64, len(na.Data))
+	for r := 0; r < rows; r++ {
+		for c := 0; c < cols; c++ {
+			data[c*rows+r] = na.Data[r*cols+c]
+		}
+	}
+	return &object.NumArray{Data: data, Shape: []int{cols, rows}}
+}
+
+func numArrayArg(name string, args []object.Object, want int) (*object.NumArray, object.Object) {
+	if len(args) != want {
+		return nil, &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=%d", len(args), want)}
+	}
+	na, ok := args[0].(*object.NumArray)
+	if !ok {
+		return nil, &object.Error{Message: fmt.Sprintf("argument to `%s` must be NUMARRAY, got %s", name, args[0].Type())}
+	}
+	return na, nil
+}
+
+func floatsToArray(vals []float64) *object.Array {
+	elements := make([]object.Object, len(vals))
+	for i, v := range vals {
+		elements[i] = &object.Float{Value: v}
+	}
+	return &object.Array{Elements: elements}
+}
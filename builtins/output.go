@@ -0,0 +1,46 @@
+// Output - the stdout writer shared by all tasks, so concurrent lines stay whole
+
+package builtins
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// TaskIDs prefixes every line printed with Out by the ID of the task that
+// printed it; main sets it for --task-ids.
+var TaskIDs bool
+
+// Quiet drops everything written through Out and Stdout while set; the
+// fuzzer sets it while it runs inputs.
+var Quiet atomic.Bool
+
+var stdoutMu sync.Mutex
+
+// Stdout writes to os.Stdout under the lock Out uses, so anything written
+// in one call is never split by another task's output.
+var Stdout syncStdout
+
+type syncStdout struct{}
+
+func (syncStdout) Write(p []byte) (int, error) {
+	if Quiet.Load() {
+		return len(p), nil
+	}
+	stdoutMu.Lock()
+	defer stdoutMu.Unlock()
+	return os.Stdout.Write(p)
+}
+
+// Out prints s and a newline for the task with the given ID: 0 for the
+// main script, then 1, 2, ... in spawn order.
+func Out(task int64, s string) {
+	if TaskIDs {
+		prefix := fmt.Sprintf("[task %d] ", task)
+		s = prefix + strings.ReplaceAll(s, "\n", "\n"+prefix)
+	}
+	Stdout.Write([]byte(s + "\n"))
+}
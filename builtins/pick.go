@@ -0,0 +1,88 @@
+// Picking - letting the user click a point or drag a region on screen
+
+package builtins
+
+import (
+	"fmt"
+	"time"
+	"xon/object"
+)
+
+func init() {
+	builtinsMap["os_pick_point"] = &object.Builtin{Fn: func(args ...object.Object) object.Object {
+		return pick("os_pick_point", false, args)
+	}}
+	builtinsMap["os_pick_region"] = &object.Builtin{Fn: func(args ...object.Object) object.Object {
+		return pick("os_pick_region", true, args)
+	}}
+}
+
+const vkEscape = 0x1B
+
+// pick waits for the user to click (or, for a region, drag) with the left
+// button and returns {x, y} or {x, y, width, height}. The picking click is
+// kept from the window under the cursor. Escape, or the timeout option in
+// milliseconds running out, returns null.
+func pick(builtin string, region bool, args []object.Object) object.Object {
+	if len(args) > 1 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=0 or 1", len(args))}
+	}
+	var timeout <-chan time.Time
+	if len(args) == 1 {
+		opts, ok := args[0].(*object.Hash)
+		if !ok {
+			return &object.Error{Message: fmt.Sprintf("%s: options must be HASH, got %s", builtin, args[0].Type())}
+		}
+		if ms := getHashInt(opts, "timeout"); ms > 0 {
+			timeout = time.After(time.Duration(ms) * time.Millisecond)
+		}
+	}
+
+	events := make(chan inputEvent, 8)
+	remove, err := listenInput(func(ev inputEvent) bool {
+		left := ev.Button == "left" && (ev.Kind == "mouse_down" || ev.Kind == "mouse_up")
+		if left || (ev.Kind == "key_down" && ev.VK == vkEscape) {
+			select {
+			case events <- ev:
+			default:
+			}
+		}
+		return left
+	})
+	if err != nil {
+		return &object.Error{Message: builtin + ": " + err.Error()}
+	}
+	defer remove()
+
+	var down *inputEvent
+	for {
+		select {
+		case <-timeout:
+			return NULL
+		case ev := <-events:
+			switch {
+			case ev.Kind == "key_down":
+				return NULL
+			case ev.Kind == "mouse_down":
+				down = &ev
+			case down == nil:
+				// The button was already held when picking started.
+			case !region:
+				// Wait for the release too, so the window never sees it.
+				return pointHash(down.X, down.Y)
+			default:
+				h := pointHash(min(down.X, ev.X), min(down.Y, ev.Y))
+				setHashPair(h, "width", &object.Integer{Value: max(down.X, ev.X) - min(down.X, ev.X)})
+				setHashPair(h, "height", &object.Integer{Value: max(down.Y, ev.Y) - min(down.Y, ev.Y)})
+				return h
+			}
+		}
+	}
+}
+
+func pointHash(x, y int64) *object.Hash {
+	h := &object.Hash{Pairs: make(map[object.HashKey]object.HashPair)}
+	setHashPair(h, "x", &object.Integer{Value: x})
+	setHashPair(h, "y", &object.Integer{Value: y})
+	return h
+}
@@ -0,0 +1,23 @@
+// Platform - the OS services behind the os_*, clipboard, console and lock builtins
+//
+// Each platform file (platform_windows.go, platform_unix.go)
+// provides the same set of functions; builtins call these instead of the OS
+// so that `xon build --target` can produce binaries for any GOOS.
+
+package builtins
+
+import (
+	"fmt"
+	"runtime"
+	"xon/object"
+)
+
+// errUnsupported is returned by platform functions that have no
+// implementation on the running OS.
+func errUnsupported(name string) error {
+	return fmt.Errorf("%s is not supported on %s", name, runtime.GOOS)
+}
+
+func unsupported(name string) *object.Error {
+	return &object.Error{Message: errUnsupported(name).Error()}
+}
@@ -0,0 +1,657 @@
+//go:build unix
+
+// Platform (Unix) - shell commands, speech, AT-SPI, terminal echo and flock file locks; desktop input is Windows only
+
+package builtins
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+func mouseMove(x, y int64) error {
+	return errUnsupported("os_mouse_move")
+}
+
+func mouseClick() error {
+	return errUnsupported("os_mouse_click")
+}
+
+func mousePos() (x, y int64, err error) {
+	return 0, 0, errUnsupported("os_mouse_get_pos")
+}
+
+func keyTap(vk int64) error {
+	return errUnsupported("os_key_tap")
+}
+
+func typeText(text string) error {
+	return errUnsupported("os_keyboard_type")
+}
+
+func alertBox(title, msg string) error {
+	return errUnsupported("os_alert")
+}
+
+func setClipboard(text string) error {
+	return errUnsupported("copy")
+}
+
+func getClipboard() (string, error) {
+	return "", errUnsupported("paste")
+}
+
+func startInputHook(handle func(inputEvent) bool) (stop func(), err error) {
+	return nil, errUnsupported("keyboard and mouse hooking")
+}
+
+func sendInput(ev inputEvent) error {
+	return errUnsupported("input replay")
+}
+
+func readKey() (code uintptr, ok bool, err error) {
+	return 0, false, errUnsupported("key_pressed")
+}
+
+// defaultDockerHost is where the Docker daemon listens unless DOCKER_HOST
+// says otherwise.
+const defaultDockerHost = "unix:///var/run/docker.sock"
+
+func dialPipe(name string) (io.ReadWriteCloser, error) {
+	return nil, errUnsupported("npipe://")
+}
+
+// shellCommand runs cmd through /bin/sh.
+func shellCommand(cmd string) *exec.Cmd {
+	return exec.Command("/bin/sh", "-c", cmd)
+}
+
+// speechCommand returns the command that speaks the text on its stdin:
+// say on macOS, elsewhere espeak-ng or espeak, or spd-say, which takes the
+// text as an argument instead. rate is relative to the normal speed.
+func speechCommand(text, voice string, rate float64) (cmd *exec.Cmd, stdin bool, err error) {
+	wpm := strconv.Itoa(int(math.Round(175 * rate)))
+	if runtime.GOOS == "darwin" {
+		args := []string{"-r", wpm}
+		if voice != "" {
+			args = append(args, "-v", voice)
+		}
+		return exec.Command("say", args...), true, nil
+	}
+	for _, engine := range []string{"espeak-ng", "espeak"} {
+		if path, err := exec.LookPath(engine); err == nil {
+			args := []string{"-s", wpm, "--stdin"}
+			if voice != "" {
+				args = append(args, "-v", voice)
+			}
+			return exec.Command(path, args...), true, nil
+		}
+	}
+	if path, err := exec.LookPath("spd-say"); err == nil {
+		// spd-say's rate runs from -100 to 100 around the normal speed.
+		spdRate := max(-100, min(100, int(math.Round((rate-1)*100))))
+		args := []string{"--wait", "--rate", strconv.Itoa(spdRate)}
+		if voice != "" {
+			args = append(args, "--synthesis-voice", voice)
+		}
+		return exec.Command(path, append(args, "--", text)...), false, nil
+	}
+	return nil, false, fmt.Errorf("no speech engine found; install espeak-ng or speech-dispatcher")
+}
+
+// atspiScript answers a uiRequest on stdin through AT-SPI, walking every
+// application's windows from the desktop down. Roles are renamed to the
+// UIAutomation control types so criteria work the same on Windows.
+const atspiScript = `import json, sys
+try:
+    import gi
+    gi.require_version("Atspi", "2.0")
+    from gi.repository import Atspi
+except Exception as e:
+    print(json.dumps({"error": "AT-SPI is not available; install python3-gi and gir1.2-atspi-2.0 (%s)" % e}))
+    sys.exit(0)
+
+ROLES = {"push button": "button", "toggle button": "button", "entry": "edit",
+         "password text": "edit", "text": "edit", "label": "text", "frame": "window",
+         "dialog": "window", "check box": "checkbox", "radio button": "radiobutton",
+         "combo box": "combobox", "menu item": "menuitem", "check menu item": "menuitem",
+         "radio menu item": "menuitem", "menu bar": "menubar", "page tab": "tabitem",
+         "page tab list": "tab", "list item": "listitem", "table cell": "dataitem",
+         "tree item": "treeitem", "link": "hyperlink", "scroll bar": "scrollbar",
+         "tool bar": "toolbar", "status bar": "statusbar", "panel": "pane", "filler": "pane",
+         "spin button": "spinner", "progress bar": "progressbar"}
+req = json.load(sys.stdin)
+
+def role(acc):
+    name = acc.get_role_name() or ""
+    return ROLES.get(name, name.replace(" ", ""))
+
+def ident(acc):
+    try:
+        return acc.get_accessible_id() or ""
+    except Exception:
+        return ""
+
+def matches(acc):
+    name = acc.get_name() or ""
+    if req.get("name") and name != req["name"]:
+        return False
+    if req.get("contains") and req["contains"].lower() not in name.lower():
+        return False
+    if req.get("role") and role(acc) != req["role"]:
+        return False
+    if req.get("id") and ident(acc) != req["id"]:
+        return False
+    return True
+
+def walk(acc, found, want, depth=0):
+    if acc is None or len(found) >= want or depth > 64:
+        return
+    if matches(acc):
+        found.append(acc)
+    for i in range(acc.get_child_count()):
+        walk(acc.get_child_at_index(i), found, want, depth + 1)
+
+want = req["limit"] if req["action"] == "find" else req["index"] + 1
+found = []
+desktop = Atspi.get_desktop(0)
+for a in range(desktop.get_child_count()):
+    app = desktop.get_child_at_index(a)
+    for w in range(app.get_child_count() if app else 0):
+        win = app.get_child_at_index(w)
+        if req.get("window") and req["window"].lower() not in (win.get_name() or "").lower():
+            continue
+        walk(win, found, want)
+
+if req["action"] == "find":
+    out = []
+    for acc in found:
+        box = acc.get_extents(Atspi.CoordType.SCREEN)
+        out.append({"name": acc.get_name() or "", "role": role(acc), "id": ident(acc),
+                    "class": acc.get_role_name() or "", "x": box.x, "y": box.y,
+                    "width": box.width, "height": box.height,
+                    "enabled": acc.get_state_set().contains(Atspi.StateType.ENABLED)})
+    print(json.dumps({"elements": out}))
+    sys.exit(0)
+if len(found) <= req["index"]:
+    print(json.dumps({"error": "no control matches the criteria"}))
+    sys.exit(0)
+acc = found[req["index"]]
+if req["action"] == "invoke":
+    action = acc.get_action_iface()
+    if action is None or action.get_n_actions() == 0:
+        print(json.dumps({"error": "the control has no action to invoke"}))
+    else:
+        action.do_action(0)
+        print(json.dumps({}))
+else:
+    text = acc.get_text_iface()
+    value = acc.get_value_iface()
+    if text is not None:
+        result = text.get_text(0, text.get_character_count())
+    elif value is not None:
+        result = str(value.get_current_value())
+    else:
+        result = acc.get_name() or ""
+    print(json.dumps({"text": result}))
+`
+
+// uiHelper returns the command that answers a uiRequest on its stdin with
+// a uiResponse: python3 with the AT-SPI bindings. macOS's accessibility
+// API is not wrapped.
+func uiHelper() (*exec.Cmd, error) {
+	if runtime.GOOS == "darwin" {
+		return nil, errUnsupported("UI automation")
+	}
+	path, err := exec.LookPath("python3")
+	if err != nil {
+		return nil, fmt.Errorf("python3 with the AT-SPI bindings is needed")
+	}
+	return exec.Command(path, "-c", atspiScript), nil
+}
+
+// batteryStatus reads sysfs on Linux and pmset on macOS.
+func batteryStatus() (batteryInfo, error) {
+	if runtime.GOOS == "darwin" {
+		out, err := runTool("pmset", "-g", "batt")
+		if err != nil {
+			return batteryInfo{}, err
+		}
+		return parsePmset(out), nil
+	}
+	return sysfsBattery("/sys/class/power_supply"), nil
+}
+
+// displayList asks xrandr, so it needs an X server (or XWayland).
+func displayList() ([]displayInfo, error) {
+	if runtime.GOOS == "darwin" {
+		return nil, errUnsupported("display_list")
+	}
+	out, err := runTool("xrandr", "--query")
+	if err != nil {
+		return nil, err
+	}
+	return parseXrandr(out), nil
+}
+
+// setBrightness uses brightnessctl, which changes the backlight itself,
+// when no display is named. Otherwise, or without brightnessctl, xrandr
+// scales the picture of each display.
+func setBrightness(percent int64, name string) error {
+	if runtime.GOOS == "darwin" {
+		return errUnsupported("display_set_brightness")
+	}
+	if _, err := exec.LookPath("brightnessctl"); err == nil && name == "" {
+		_, err := runTool("brightnessctl", "--quiet", "set", strconv.FormatInt(percent, 10)+"%")
+		return err
+	}
+	displays, err := displayList()
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, d := range displays {
+		if name != "" && d.Name != name {
+			continue
+		}
+		found = true
+		level := strconv.FormatFloat(float64(percent)/100, 'f', 2, 64)
+		if _, err := runTool("xrandr", "--output", d.Name, "--brightness", level); err != nil {
+			return err
+		}
+	}
+	if !found && name != "" {
+		return fmt.Errorf("no display named %q", name)
+	}
+	return nil
+}
+
+// audioDevices asks PulseAudio, or PipeWire through its pulse server, for
+// its outputs (sinks) and inputs (sources).
+func audioDevices() ([]audioDevice, error) {
+	if runtime.GOOS == "darwin" {
+		return nil, errUnsupported("audio_devices")
+	}
+	var devices []audioDevice
+	for _, kind := range []struct{ name, list string }{{"output", "sinks"}, {"input", "sources"}} {
+		out, err := runTool("pactl", "--format=json", "list", kind.list)
+		if err != nil {
+			return nil, err
+		}
+		def, _ := runTool("pactl", "get-default-"+strings.TrimSuffix(kind.list, "s"))
+		list, err := parsePactlDevices(out, kind.name, strings.TrimSpace(def))
+		if err != nil {
+			return nil, err
+		}
+		devices = append(devices, list...)
+	}
+	return devices, nil
+}
+
+// audioRecord runs parecord, which records until it is interrupted, and
+// interrupts it once the time is up so that it finishes the WAV header.
+func audioRecord(seconds float64, path, device string) error {
+	if runtime.GOOS == "darwin" {
+		return errUnsupported("audio_record")
+	}
+	bin, err := exec.LookPath("parecord")
+	if err != nil {
+		return fmt.Errorf("parecord is not installed or not on the PATH")
+	}
+	args := []string{"--file-format=wav", "--format=s16le", "--channels=1"}
+	if device != "" {
+		args = append(args, "--device="+device)
+	}
+	var stderr strings.Builder
+	cmd := exec.Command(bin, append(args, path)...)
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	timer := time.AfterFunc(time.Duration(seconds*float64(time.Second)), func() {
+		cmd.Process.Signal(os.Interrupt)
+	})
+	err = cmd.Wait()
+	if !timer.Stop() {
+		return nil // stopped by us; parecord may report the interrupt
+	}
+	if msg := strings.TrimSpace(stderr.String()); msg != "" {
+		return fmt.Errorf("%s", msg)
+	}
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("parecord stopped before %g seconds were recorded", seconds)
+}
+
+// audioPlay uses paplay, or afplay on macOS, which plays through the
+// current output only.
+func audioPlay(path, device string, wait bool) error {
+	name, args := "paplay", []string{path}
+	if device != "" {
+		args = []string{"--device=" + device, path}
+	}
+	if runtime.GOOS == "darwin" {
+		if device != "" {
+			return fmt.Errorf("choosing a device is not supported on darwin")
+		}
+		name = "afplay"
+	}
+	if wait {
+		_, err := runTool(name, args...)
+		return err
+	}
+	bin, err := exec.LookPath(name)
+	if err != nil {
+		return fmt.Errorf("%s is not installed or not on the PATH", name)
+	}
+	cmd := exec.Command(bin, args...)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go cmd.Wait()
+	return nil
+}
+
+// The volume functions change the default sink through pactl, or the
+// output volume through AppleScript on macOS.
+
+func volumeGet() (int64, error) {
+	if runtime.GOOS == "darwin" {
+		out, err := runTool("osascript", "-e", "output volume of (get volume settings)")
+		if err != nil {
+			return 0, err
+		}
+		return strconv.ParseInt(strings.TrimSpace(out), 10, 64)
+	}
+	out, err := runTool("pactl", "get-sink-volume", "@DEFAULT_SINK@")
+	if err != nil {
+		return 0, err
+	}
+	return parsePactlVolume(out)
+}
+
+func volumeSet(percent int64) error {
+	var err error
+	if runtime.GOOS == "darwin" {
+		_, err = runTool("osascript", "-e", "set volume output volume "+strconv.FormatInt(percent, 10))
+	} else {
+		_, err = runTool("pactl", "set-sink-volume", "@DEFAULT_SINK@", strconv.FormatInt(percent, 10)+"%")
+	}
+	return err
+}
+
+func volumeMute(mute bool) error {
+	var err error
+	if runtime.GOOS == "darwin" {
+		_, err = runTool("osascript", "-e", "set volume output muted "+strconv.FormatBool(mute))
+	} else {
+		flag := "0"
+		if mute {
+			flag = "1"
+		}
+		_, err = runTool("pactl", "set-sink-mute", "@DEFAULT_SINK@", flag)
+	}
+	return err
+}
+
+// wifiList asks NetworkManager. nmcli rescans when its list is stale.
+func wifiList() ([]wifiNetwork, error) {
+	if runtime.GOOS == "darwin" {
+		return nil, errUnsupported("wifi_list")
+	}
+	out, err := runTool("nmcli", "-t", "-f", "IN-USE,SSID,SIGNAL,SECURITY", "dev", "wifi", "list")
+	if err != nil {
+		return nil, err
+	}
+	return parseNmcli(out), nil
+}
+
+// wifiConnect has NetworkManager join the network and save it as a
+// connection, so it is rejoined after a reboot.
+func wifiConnect(ssid, pass string) error {
+	if runtime.GOOS == "darwin" {
+		return errUnsupported("wifi_connect")
+	}
+	args := []string{"dev", "wifi", "connect", ssid}
+	if pass != "" {
+		args = append(args, "password", pass)
+	}
+	_, err := runTool("nmcli", args...)
+	return err
+}
+
+// scheduleInstall adds the job to the user's crontab, in place of any
+// job with the same name.
+func scheduleInstall(name string, fields, command []string) error {
+	crontab, err := readCrontab()
+	if err != nil {
+		return err
+	}
+	crontab, _ = withoutCrontabJob(crontab, name)
+	return writeCrontab(crontab + crontabLine(name, fields, command) + "\n")
+}
+
+func scheduleRemove(name string) error {
+	crontab, err := readCrontab()
+	if err != nil {
+		return err
+	}
+	crontab, found := withoutCrontabJob(crontab, name)
+	if !found {
+		return fmt.Errorf("no scheduled task named %q", name)
+	}
+	return writeCrontab(crontab)
+}
+
+// readCrontab returns the user's crontab, empty when they have none yet.
+func readCrontab() (string, error) {
+	out, err := runTool("crontab", "-l")
+	if err != nil && strings.Contains(err.Error(), "no crontab for") {
+		return "", nil
+	}
+	return out, err
+}
+
+func writeCrontab(crontab string) error {
+	path, err := exec.LookPath("crontab")
+	if err != nil {
+		return fmt.Errorf("crontab is not installed or not on the PATH")
+	}
+	cmd := exec.Command(path, "-")
+	cmd.Stdin = strings.NewReader(crontab)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("crontab: %s", msg)
+		}
+		return err
+	}
+	return nil
+}
+
+// systemdUnitPath is where the unit file for a service goes: the user's
+// systemd directory for a user unit, /etc/systemd/system otherwise.
+func systemdUnitPath(name string, user bool) (string, error) {
+	file := serviceUnitName(name) + ".service"
+	if !user {
+		return filepath.Join("/etc/systemd/system", file), nil
+	}
+	config := os.Getenv("XDG_CONFIG_HOME")
+	if config == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		config = filepath.Join(home, ".config")
+	}
+	return filepath.Join(config, "systemd", "user", file), nil
+}
+
+// installedUnit finds the unit file of an installed service, looking for
+// a user unit first.
+func installedUnit(name string) (path string, user bool, err error) {
+	for _, user := range []bool{true, false} {
+		path, err := systemdUnitPath(name, user)
+		if err != nil {
+			return "", false, err
+		}
+		if _, err := os.Stat(path); err == nil {
+			return path, user, nil
+		}
+	}
+	return "", false, fmt.Errorf("no service named %q is installed", name)
+}
+
+func systemctl(user bool, args ...string) error {
+	if user {
+		args = append([]string{"--user"}, args...)
+	}
+	_, err := runTool("systemctl", args...)
+	return err
+}
+
+// serviceInstall writes a systemd unit and enables it, so it also starts
+// at boot (for a user unit, at login unless lingering is enabled).
+func serviceInstall(script string, opts ServiceOptions, exe string) error {
+	if runtime.GOOS == "darwin" {
+		return errUnsupported("xon service")
+	}
+	path, err := systemdUnitPath(opts.Name, opts.User)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, []byte(systemdUnit(script, opts, exe)), 0644); err != nil {
+		return err
+	}
+	if err := systemctl(opts.User, "daemon-reload"); err != nil {
+		return err
+	}
+	return systemctl(opts.User, "enable", serviceUnitName(opts.Name))
+}
+
+func serviceControl(name, action string) error {
+	_, user, err := installedUnit(name)
+	if err != nil {
+		return err
+	}
+	return systemctl(user, action, serviceUnitName(name))
+}
+
+func serviceRemove(name string) error {
+	path, user, err := installedUnit(name)
+	if err != nil {
+		return err
+	}
+	if err := systemctl(user, "disable", "--now", serviceUnitName(name)); err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	return systemctl(user, "daemon-reload")
+}
+
+// serviceHost is only needed by the Windows service manager; systemd runs
+// the script itself.
+func serviceHost(name string, run func(stop <-chan struct{}) error) error {
+	return fmt.Errorf("xon service run is started by the Windows service manager; systemd runs scripts directly")
+}
+
+// secretGet reads the secret from the login keychain on macOS and from the
+// Secret Service (GNOME Keyring, KWallet) through secret-tool elsewhere.
+func secretGet(name string) (string, bool, error) {
+	if runtime.GOOS == "darwin" {
+		out, err := runTool("security", "find-generic-password", "-s", secretService, "-a", name, "-w")
+		if err != nil {
+			if strings.Contains(err.Error(), "could not be found") {
+				return "", false, nil
+			}
+			return "", false, err
+		}
+		return strings.TrimSuffix(out, "\n"), true, nil
+	}
+	out, err := runTool("secret-tool", "lookup", "service", secretService, "account", name)
+	if err != nil {
+		// secret-tool exits with status 1 and says nothing when no item matches.
+		if err.Error() == "secret-tool: exit status 1" {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	return out, true, nil
+}
+
+// secretSet replaces any earlier value. secret-tool reads the secret from
+// stdin; security only takes it as an argument, so on macOS it is briefly
+// visible to other processes of the same user.
+func secretSet(name, value string) error {
+	label := "Xon secret " + name
+	if runtime.GOOS == "darwin" {
+		_, err := runTool("security", "add-generic-password", "-U", "-s", secretService, "-a", name, "-l", label, "-w", value)
+		return err
+	}
+	_, err := runToolInput(strings.NewReader(value), "secret-tool", "store", "--label="+label, "service", secretService, "account", name)
+	return err
+}
+
+// secretDelete removes the secret; one that is not stored is fine.
+func secretDelete(name string) error {
+	if runtime.GOOS == "darwin" {
+		_, err := runTool("security", "delete-generic-password", "-s", secretService, "-a", name)
+		if err != nil && strings.Contains(err.Error(), "could not be found") {
+			return nil
+		}
+		return err
+	}
+	_, err := runTool("secret-tool", "clear", "service", secretService, "account", name)
+	if err != nil && err.Error() == "secret-tool: exit status 1" {
+		return nil
+	}
+	return err
+}
+
+// disableConsoleEcho turns off terminal echo with stty and returns a function
+// restoring it. It is a no-op when stdin is not a terminal.
+func disableConsoleEcho() func() {
+	off := exec.Command("stty", "-echo")
+	off.Stdin = os.Stdin
+	if off.Run() != nil {
+		return func() {}
+	}
+	return func() {
+		on := exec.Command("stty", "echo")
+		on.Stdin = os.Stdin
+		on.Run()
+	}
+}
+
+// lockFile takes an exclusive flock on f. When block is false it returns
+// false at once if another process holds the lock.
+func lockFile(f *os.File, block bool) (bool, error) {
+	how := syscall.LOCK_EX
+	if !block {
+		how |= syscall.LOCK_NB
+	}
+	err := syscall.Flock(int(f.Fd()), how)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func unlockFile(f *os.File) {
+	syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
@@ -0,0 +1,1038 @@
+// Platform (Windows) - user32/kernel32 calls for input, input hooks, clipboard, console and file locks
+
+package builtins
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unicode/utf16"
+	"unsafe"
+)
+
+type POINT struct {
+	X, Y int32
+}
+
+const (
+	stdInputHandle  = ^uintptr(9) // STD_INPUT_HANDLE = -10
+	enableEchoInput = 0x0004
+
+	lockfileFailImmediately = 0x1
+	lockfileExclusiveLock   = 0x2
+)
+
+// overlapped mirrors the Win32 OVERLAPPED structure expected by LockFileEx.
+type overlapped struct {
+	Internal     uintptr
+	InternalHigh uintptr
+	Offset       uint32
+	OffsetHigh   uint32
+	HEvent       uintptr
+}
+
+var (
+	user32           = syscall.NewLazyDLL("user32.dll")
+	setCursorPos     = user32.NewProc("SetCursorPos")
+	getCursorPos     = user32.NewProc("GetCursorPos")
+	mouseEvent       = user32.NewProc("mouse_event")
+	keybdEvent       = user32.NewProc("keybd_event")
+	messageBox       = user32.NewProc("MessageBoxW")
+	openClipboard    = user32.NewProc("OpenClipboard")
+	emptyClipboard   = user32.NewProc("EmptyClipboard")
+	setClipboardData = user32.NewProc("SetClipboardData")
+	getClipboardData = user32.NewProc("GetClipboardData")
+	closeClipboard   = user32.NewProc("CloseClipboard")
+	setWindowsHookEx = user32.NewProc("SetWindowsHookExW")
+	unhookHook       = user32.NewProc("UnhookWindowsHookEx")
+	callNextHookEx   = user32.NewProc("CallNextHookEx")
+	getMessage       = user32.NewProc("GetMessageW")
+	postThreadMsg    = user32.NewProc("PostThreadMessageW")
+	enumMonitors     = user32.NewProc("EnumDisplayMonitors")
+	getMonitorInfo   = user32.NewProc("GetMonitorInfoW")
+	kernel32         = syscall.NewLazyDLL("kernel32.dll")
+	globalAlloc      = kernel32.NewProc("GlobalAlloc")
+	globalLock       = kernel32.NewProc("GlobalLock")
+	globalUnlock     = kernel32.NewProc("GlobalUnlock")
+	lstrcpy          = kernel32.NewProc("lstrcpyW")
+	getStdHandle     = kernel32.NewProc("GetStdHandle")
+	getConsoleMode   = kernel32.NewProc("GetConsoleMode")
+	setConsoleMode   = kernel32.NewProc("SetConsoleMode")
+	lockFileEx       = kernel32.NewProc("LockFileEx")
+	unlockFileEx     = kernel32.NewProc("UnlockFileEx")
+	getCurrentThread = kernel32.NewProc("GetCurrentThreadId")
+	getModuleHandle  = kernel32.NewProc("GetModuleHandleW")
+	getPowerStatus   = kernel32.NewProc("GetSystemPowerStatus")
+	advapi32         = syscall.NewLazyDLL("advapi32.dll")
+	startSvcDispatch = advapi32.NewProc("StartServiceCtrlDispatcherW")
+	regSvcHandler    = advapi32.NewProc("RegisterServiceCtrlHandlerExW")
+	setServiceStatus = advapi32.NewProc("SetServiceStatus")
+	crypt32          = syscall.NewLazyDLL("crypt32.dll")
+	cryptProtect     = crypt32.NewProc("CryptProtectData")
+	cryptUnprotect   = crypt32.NewProc("CryptUnprotectData")
+	localFree        = kernel32.NewProc("LocalFree")
+	msvcrt           = syscall.NewLazyDLL("msvcrt.dll")
+	kbhit            = msvcrt.NewProc("_kbhit")
+	getch            = msvcrt.NewProc("_getch")
+	winmm            = syscall.NewLazyDLL("winmm.dll")
+	mciSendString    = winmm.NewProc("mciSendStringW")
+	mciGetError      = winmm.NewProc("mciGetErrorStringW")
+)
+
+// pointerAt turns an address Windows hands back as a uintptr (a hook's
+// lParam, a callback's data, a GlobalLock result) into a *T. That memory
+// is owned by Windows or kept alive by the call in progress, so it stays
+// valid while the caller uses it. Reading the pointer out of p's own
+// storage means the same as (*T)(unsafe.Pointer(p)), without go vet
+// taking p for a Go pointer that was kept as an integer.
+func pointerAt[T any](p uintptr) *T {
+	return *(**T)(unsafe.Pointer(&p))
+}
+
+func mouseMove(x, y int64) error {
+	setCursorPos.Call(uintptr(x), uintptr(y))
+	return nil
+}
+
+func mouseClick() error {
+	mouseEvent.Call(uintptr(0x0002), 0, 0, 0, 0) // MOUSEEVENTF_LEFTDOWN
+	mouseEvent.Call(uintptr(0x0004), 0, 0, 0, 0) // MOUSEEVENTF_LEFTUP
+	return nil
+}
+
+func mousePos() (x, y int64, err error) {
+	var pt POINT
+	getCursorPos.Call(uintptr(unsafe.Pointer(&pt)))
+	return int64(pt.X), int64(pt.Y), nil
+}
+
+func keyTap(vk int64) error {
+	keybdEvent.Call(uintptr(vk), 0, 0, 0)               // Key down
+	keybdEvent.Call(uintptr(vk), 0, uintptr(0x0002), 0) // Key up (KEYEVENTF_KEYUP = 0x0002)
+	return nil
+}
+
+// typeText taps the key for each letter, digit and space in text; other
+// characters are skipped.
+func typeText(text string) error {
+	for _, char := range text {
+		if vk := charToVK(char); vk != 0 {
+			keyTap(int64(vk))
+		}
+	}
+	return nil
+}
+
+func charToVK(r rune) byte {
+	if r >= 'a' && r <= 'z' {
+		return byte(r - 'a' + 0x41)
+	}
+	if r >= 'A' && r <= 'Z' {
+		return byte(r - 'A' + 0x41)
+	}
+	if r >= '0' && r <= '9' {
+		return byte(r - '0' + 0x30)
+	}
+	if r == ' ' {
+		return 0x20
+	}
+	return 0
+}
+
+const (
+	whKeyboardLL = 13
+	whMouseLL    = 14
+	wmQuit       = 0x0012
+	wmKeyUp      = 0x0101
+	wmSysKeyUp   = 0x0105
+	wmMouseMove  = 0x0200
+	wmMouseWheel = 0x020A
+
+	llkhfInjected = 0x10
+	llmhfInjected = 0x01
+)
+
+// kbdllhookstruct and msllhookstruct mirror KBDLLHOOKSTRUCT and
+// MSLLHOOKSTRUCT, what low-level hooks receive in lParam.
+type kbdllhookstruct struct {
+	VkCode, ScanCode, Flags, Time uint32
+	ExtraInfo                     uintptr
+}
+
+type msllhookstruct struct {
+	Pt                     POINT
+	MouseData, Flags, Time uint32
+	ExtraInfo              uintptr
+}
+
+// mouseMessages names the button messages a mouse hook sees.
+var mouseMessages = map[uintptr]inputEvent{
+	0x0201: {Kind: "mouse_down", Button: "left"},
+	0x0202: {Kind: "mouse_up", Button: "left"},
+	0x0204: {Kind: "mouse_down", Button: "right"},
+	0x0205: {Kind: "mouse_up", Button: "right"},
+	0x0207: {Kind: "mouse_down", Button: "middle"},
+	0x0208: {Kind: "mouse_up", Button: "middle"},
+}
+
+// Callbacks made by syscall.NewCallback are never freed, so the hook
+// procedures are made once and call whichever handler is installed.
+var (
+	hookCallbacks sync.Once
+	keyboardHook  uintptr
+	mouseHook     uintptr
+	hookHandler   func(inputEvent) bool
+)
+
+func keyboardProc(code, wParam, lParam uintptr) uintptr {
+	if int32(code) >= 0 {
+		k := pointerAt[kbdllhookstruct](lParam)
+		if k.Flags&llkhfInjected == 0 {
+			ev := inputEvent{Kind: "key_down", VK: int64(k.VkCode)}
+			if wParam == wmKeyUp || wParam == wmSysKeyUp {
+				ev.Kind = "key_up"
+			}
+			if hookHandler(ev) {
+				return 1
+			}
+		}
+	}
+	r, _, _ := callNextHookEx.Call(0, code, wParam, lParam)
+	return r
+}
+
+func mouseProc(code, wParam, lParam uintptr) uintptr {
+	if int32(code) >= 0 {
+		m := pointerAt[msllhookstruct](lParam)
+		if m.Flags&llmhfInjected == 0 {
+			ev, ok := mouseMessages[wParam]
+			switch wParam {
+			case wmMouseMove:
+				ev, ok = inputEvent{Kind: "mouse_move"}, true
+			case wmMouseWheel:
+				ev, ok = inputEvent{Kind: "wheel", Delta: int64(int16(m.MouseData >> 16))}, true
+			}
+			if ok {
+				ev.X, ev.Y = int64(m.Pt.X), int64(m.Pt.Y)
+				if hookHandler(ev) {
+					return 1
+				}
+			}
+		}
+	}
+	r, _, _ := callNextHookEx.Call(0, code, wParam, lParam)
+	return r
+}
+
+// startInputHook installs low-level keyboard and mouse hooks on a thread
+// of their own, which runs the message loop Windows calls them from, and
+// passes each event that was not injected to handle. Events handle returns
+// true for are not passed on. stop removes the hooks.
+func startInputHook(handle func(inputEvent) bool) (stop func(), err error) {
+	hookCallbacks.Do(func() {
+		keyboardHook = syscall.NewCallback(keyboardProc)
+		mouseHook = syscall.NewCallback(mouseProc)
+	})
+	hookHandler = handle
+	started := make(chan error, 1)
+	var thread uintptr
+	go func() {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		thread, _, _ = getCurrentThread.Call()
+		module, _, _ := getModuleHandle.Call(0)
+		kb, _, kbErr := setWindowsHookEx.Call(whKeyboardLL, keyboardHook, module, 0)
+		if kb == 0 {
+			started <- kbErr
+			return
+		}
+		defer unhookHook.Call(kb)
+		ms, _, msErr := setWindowsHookEx.Call(whMouseLL, mouseHook, module, 0)
+		if ms == 0 {
+			started <- msErr
+			return
+		}
+		defer unhookHook.Call(ms)
+		started <- nil
+		var msg [48]byte // MSG; only the loop's return value matters
+		for {
+			if r, _, _ := getMessage.Call(uintptr(unsafe.Pointer(&msg[0])), 0, 0, 0); int32(r) <= 0 {
+				return
+			}
+		}
+	}()
+	if err := <-started; err != nil {
+		return nil, fmt.Errorf("cannot install input hooks: %v", err)
+	}
+	return func() { postThreadMsg.Call(thread, wmQuit, 0, 0) }, nil
+}
+
+// sendInput injects ev as if the user had typed or clicked it.
+func sendInput(ev inputEvent) error {
+	switch ev.Kind {
+	case "key_down":
+		keybdEvent.Call(uintptr(ev.VK), 0, 0, 0)
+	case "key_up":
+		keybdEvent.Call(uintptr(ev.VK), 0, uintptr(0x0002), 0) // KEYEVENTF_KEYUP
+	case "mouse_move":
+		setCursorPos.Call(uintptr(ev.X), uintptr(ev.Y))
+	case "mouse_down", "mouse_up":
+		flags := map[string]uintptr{"left": 0x0002, "right": 0x0008, "middle": 0x0020}[ev.Button]
+		if ev.Kind == "mouse_up" {
+			flags <<= 1 // each button's up flag follows its down flag
+		}
+		setCursorPos.Call(uintptr(ev.X), uintptr(ev.Y))
+		mouseEvent.Call(flags, 0, 0, 0, 0)
+	case "wheel":
+		setCursorPos.Call(uintptr(ev.X), uintptr(ev.Y))
+		mouseEvent.Call(0x0800, 0, 0, uintptr(uint32(int32(ev.Delta))), 0) // MOUSEEVENTF_WHEEL
+	}
+	return nil
+}
+
+func alertBox(title, msg string) error {
+	tPtr, _ := syscall.UTF16PtrFromString(title)
+	mPtr, _ := syscall.UTF16PtrFromString(msg)
+	messageBox.Call(0, uintptr(unsafe.Pointer(mPtr)), uintptr(unsafe.Pointer(tPtr)), 0)
+	return nil
+}
+
+func setClipboard(text string) error {
+	opened, _, _ := openClipboard.Call(0)
+	if opened == 0 {
+		return nil
+	}
+	defer closeClipboard.Call()
+	emptyClipboard.Call()
+
+	utf16 := utf16.Encode([]rune(text + "\x00"))
+	size := uintptr(len(utf16) * 2)
+	hMem, _, _ := globalAlloc.Call(uintptr(0x0042), size) // GHND = 0x0042
+	ptr, _, _ := globalLock.Call(hMem)
+	lstrcpy.Call(ptr, uintptr(unsafe.Pointer(&utf16[0])))
+	globalUnlock.Call(hMem)
+
+	setClipboardData.Call(uintptr(13), hMem) // CF_UNICODETEXT = 13
+	return nil
+}
+
+func getClipboard() (string, error) {
+	opened, _, _ := openClipboard.Call(0)
+	if opened == 0 {
+		return "", nil
+	}
+	defer closeClipboard.Call()
+
+	hMem, _, _ := getClipboardData.Call(uintptr(13))
+	if hMem == 0 {
+		return "", nil
+	}
+
+	ptr, _, _ := globalLock.Call(hMem)
+	defer globalUnlock.Call(hMem)
+
+	text := pointerAt[uint16](ptr)
+	n := 0
+	for *(*uint16)(unsafe.Add(unsafe.Pointer(text), n*2)) != 0 {
+		n++
+	}
+	return string(utf16.Decode(unsafe.Slice(text, n))), nil
+}
+
+// defaultDockerHost is where Docker Desktop listens unless DOCKER_HOST says
+// otherwise.
+const defaultDockerHost = "npipe:////./pipe/docker_engine"
+
+// dialPipe opens the named pipe name, such as \\.\pipe\docker_engine.
+func dialPipe(name string) (io.ReadWriteCloser, error) {
+	return os.OpenFile(name, os.O_RDWR, 0)
+}
+
+// shellCommand runs cmd through cmd.exe.
+func shellCommand(cmd string) *exec.Cmd {
+	return exec.Command("cmd", "/C", cmd)
+}
+
+// speechScript speaks stdin with System.Speech, which wraps SAPI. The
+// voice and rate come in through the environment so nothing from the
+// script is ever parsed as PowerShell.
+const speechScript = `Add-Type -AssemblyName System.Speech
+$s = New-Object System.Speech.Synthesis.SpeechSynthesizer
+if ($env:XON_TTS_VOICE) { $s.SelectVoice($env:XON_TTS_VOICE) }
+$s.Rate = [int]$env:XON_TTS_RATE
+$s.Speak([Console]::In.ReadToEnd())`
+
+// speechCommand returns the command that speaks the text on its stdin.
+// SAPI rates run from -10 to 10, each 10 steps about three times faster or
+// slower, so rate (relative to normal) maps onto that scale.
+func speechCommand(text, voice string, rate float64) (cmd *exec.Cmd, stdin bool, err error) {
+	sapiRate := max(-10, min(10, int(math.Round(10*math.Log(rate)/math.Log(3)))))
+	cmd = exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", speechScript)
+	cmd.Env = append(os.Environ(), "XON_TTS_VOICE="+voice, "XON_TTS_RATE="+strconv.Itoa(sapiRate))
+	return cmd, true, nil
+}
+
+// uiaScript answers a uiRequest on stdin with UIAutomationClient. Roles are
+// control type names in lower case, such as "button" or "edit".
+const uiaScript = `$ErrorActionPreference = 'Stop'
+Add-Type -AssemblyName UIAutomationClient, UIAutomationTypes
+$req = [Console]::In.ReadToEnd() | ConvertFrom-Json
+$A = [System.Windows.Automation.AutomationElement]
+$conds = @()
+if ($req.name) { $conds += New-Object System.Windows.Automation.PropertyCondition($A::NameProperty, $req.name) }
+if ($req.id) { $conds += New-Object System.Windows.Automation.PropertyCondition($A::AutomationIdProperty, $req.id) }
+if ($req.role) {
+  $field = [System.Windows.Automation.ControlType].GetField($req.role, 'Public,Static,IgnoreCase')
+  if (-not $field) { @{ error = "unknown role $($req.role)" } | ConvertTo-Json -Compress; exit }
+  $conds += New-Object System.Windows.Automation.PropertyCondition($A::ControlTypeProperty, $field.GetValue($null))
+}
+$cond = [System.Windows.Automation.Condition]::TrueCondition
+if ($conds.Count -eq 1) { $cond = $conds[0] }
+if ($conds.Count -gt 1) { $cond = New-Object System.Windows.Automation.AndCondition(,[System.Windows.Automation.Condition[]]$conds) }
+$roots = @($A::RootElement)
+if ($req.window) {
+  $roots = @($A::RootElement.FindAll('Children', [System.Windows.Automation.Condition]::TrueCondition) |
+    Where-Object { $_.Current.Name.IndexOf($req.window, [StringComparison]::OrdinalIgnoreCase) -ge 0 })
+}
+$want = if ($req.action -eq 'find') { $req.limit } else { $req.index + 1 }
+$found = @()
+foreach ($root in $roots) {
+  foreach ($e in $root.FindAll('Descendants', $cond)) {
+    if ($req.contains -and $e.Current.Name.IndexOf($req.contains, [StringComparison]::OrdinalIgnoreCase) -lt 0) { continue }
+    $found += $e
+    if ($found.Count -ge $want) { break }
+  }
+  if ($found.Count -ge $want) { break }
+}
+if ($req.action -eq 'find') {
+  $out = @($found | ForEach-Object {
+    $c = $_.Current; $r = $c.BoundingRectangle
+    if ($r.IsEmpty) { $r = New-Object System.Windows.Rect(0, 0, 0, 0) }
+    @{ name = $c.Name; role = $c.ControlType.ProgrammaticName.Split('.')[-1].ToLower(); id = $c.AutomationId;
+       class = $c.ClassName; x = [int]$r.X; y = [int]$r.Y; width = [int]$r.Width; height = [int]$r.Height;
+       enabled = $c.IsEnabled }
+  })
+  ConvertTo-Json -Compress -InputObject @{ elements = $out }
+  exit
+}
+if ($found.Count -le $req.index) { @{ error = 'no control matches the criteria' } | ConvertTo-Json -Compress; exit }
+$e = $found[$req.index]
+$p = $null
+if ($req.action -eq 'invoke') {
+  if ($e.TryGetCurrentPattern([System.Windows.Automation.InvokePattern]::Pattern, [ref]$p)) { $p.Invoke() }
+  elseif ($e.TryGetCurrentPattern([System.Windows.Automation.TogglePattern]::Pattern, [ref]$p)) { $p.Toggle() }
+  elseif ($e.TryGetCurrentPattern([System.Windows.Automation.SelectionItemPattern]::Pattern, [ref]$p)) { $p.Select() }
+  elseif ($e.TryGetCurrentPattern([System.Windows.Automation.ExpandCollapsePattern]::Pattern, [ref]$p)) { $p.Expand() }
+  else { @{ error = 'the control has no action to invoke' } | ConvertTo-Json -Compress; exit }
+  '{}'
+  exit
+}
+if ($e.TryGetCurrentPattern([System.Windows.Automation.ValuePattern]::Pattern, [ref]$p)) { $text = $p.Current.Value }
+elseif ($e.TryGetCurrentPattern([System.Windows.Automation.TextPattern]::Pattern, [ref]$p)) { $text = $p.DocumentRange.GetText(-1) }
+else { $text = $e.Current.Name }
+@{ text = [string]$text } | ConvertTo-Json -Compress`
+
+// uiHelper returns the command that answers a uiRequest on its stdin with
+// a uiResponse.
+func uiHelper() (*exec.Cmd, error) {
+	return exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", uiaScript), nil
+}
+
+// systemPowerStatus mirrors SYSTEM_POWER_STATUS.
+type systemPowerStatus struct {
+	ACLineStatus        byte
+	BatteryFlag         byte
+	BatteryLifePercent  byte
+	SystemStatusFlag    byte
+	BatteryLifeTime     uint32
+	BatteryFullLifeTime uint32
+}
+
+func batteryStatus() (batteryInfo, error) {
+	var s systemPowerStatus
+	if r, _, err := getPowerStatus.Call(uintptr(unsafe.Pointer(&s))); r == 0 {
+		return batteryInfo{}, err
+	}
+	b := batteryInfo{
+		Present:     s.BatteryFlag&128 == 0 && s.BatteryFlag != 255, // 128: no battery, 255: unknown
+		Percent:     int64(s.BatteryLifePercent),
+		Charging:    s.BatteryFlag != 255 && s.BatteryFlag&8 != 0,
+		Plugged:     s.ACLineStatus == 1,
+		SecondsLeft: int64(s.BatteryLifeTime),
+	}
+	if s.BatteryLifePercent == 255 {
+		b.Percent = -1
+	}
+	if s.BatteryLifeTime == 0xFFFFFFFF {
+		b.SecondsLeft = -1
+	}
+	return b, nil
+}
+
+// monitorInfoEx mirrors MONITORINFOEXW.
+type monitorInfoEx struct {
+	CbSize            uint32
+	RcMonitor, RcWork struct{ Left, Top, Right, Bottom int32 }
+	DwFlags           uint32
+	SzDevice          [32]uint16
+}
+
+var enumMonitorsCallback = sync.OnceValue(func() uintptr {
+	return syscall.NewCallback(func(monitor, hdc, rect, data uintptr) uintptr {
+		list := pointerAt[[]displayInfo](data)
+		info := monitorInfoEx{CbSize: uint32(unsafe.Sizeof(monitorInfoEx{}))}
+		if r, _, _ := getMonitorInfo.Call(monitor, uintptr(unsafe.Pointer(&info))); r != 0 {
+			m := info.RcMonitor
+			*list = append(*list, displayInfo{
+				Name:    syscall.UTF16ToString(info.SzDevice[:]),
+				X:       int64(m.Left),
+				Y:       int64(m.Top),
+				Width:   int64(m.Right - m.Left),
+				Height:  int64(m.Bottom - m.Top),
+				Primary: info.DwFlags&1 != 0, // MONITORINFOF_PRIMARY
+			})
+		}
+		return 1
+	})
+})
+
+func displayList() ([]displayInfo, error) {
+	var list []displayInfo
+	if r, _, err := enumMonitors.Call(0, 0, enumMonitorsCallback(), uintptr(unsafe.Pointer(&list))); r == 0 {
+		return nil, err
+	}
+	return list, nil
+}
+
+// brightnessScript sets the backlight through WMI, which only laptop and
+// other built-in panels offer; external monitors are reached over DDC/CI,
+// which Windows does not expose to scripts.
+const brightnessScript = `$m = Get-CimInstance -Namespace root/WMI -ClassName WmiMonitorBrightnessMethods -ErrorAction SilentlyContinue
+if (-not $m) { [Console]::Error.WriteLine('no display here supports setting brightness'); exit 1 }
+$m | Where-Object { -not $env:XON_DISPLAY -or $_.InstanceName -like "*$env:XON_DISPLAY*" } |
+  Invoke-CimMethod -MethodName WmiSetBrightness -Arguments @{ Timeout = 1; Brightness = [byte]$env:XON_BRIGHTNESS } | Out-Null`
+
+func setBrightness(percent int64, name string) error {
+	cmd := exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", brightnessScript)
+	cmd.Env = append(os.Environ(), "XON_BRIGHTNESS="+strconv.FormatInt(percent, 10), "XON_DISPLAY="+name)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("%s", msg)
+		}
+		return err
+	}
+	return nil
+}
+
+// consoleTool runs a Windows tool that prints its errors on stdout, such
+// as netsh and sc.
+func consoleTool(name string, args ...string) (string, error) {
+	out, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return "", fmt.Errorf("%s", msg)
+		}
+		return "", err
+	}
+	return string(out), nil
+}
+
+// audioScript reaches the default endpoints through the Core Audio COM
+// interfaces, which PowerShell has no cmdlets for. XON_AUDIO picks the
+// action: devices prints one "kind, id, default, name" line per active
+// endpoint, separated by tabs.
+const audioScript = `Add-Type -TypeDefinition @'
+using System;
+using System.Runtime.InteropServices;
+using System.Text;
+[ComImport, Guid("5CDF2C82-841E-4546-9722-0CF74078229A"), InterfaceType(ComInterfaceType.InterfaceIsIUnknown)]
+interface IAudioEndpointVolume {
+  int RegisterControlChangeNotify(IntPtr notify);
+  int UnregisterControlChangeNotify(IntPtr notify);
+  int GetChannelCount(out uint count);
+  int SetMasterVolumeLevel(float db, IntPtr ctx);
+  int SetMasterVolumeLevelScalar(float level, IntPtr ctx);
+  int GetMasterVolumeLevel(out float db);
+  int GetMasterVolumeLevelScalar(out float level);
+  int SetChannelVolumeLevel(uint channel, float db, IntPtr ctx);
+  int SetChannelVolumeLevelScalar(uint channel, float level, IntPtr ctx);
+  int GetChannelVolumeLevel(uint channel, out float db);
+  int GetChannelVolumeLevelScalar(uint channel, out float level);
+  int SetMute([MarshalAs(UnmanagedType.Bool)] bool mute, IntPtr ctx);
+  int GetMute([MarshalAs(UnmanagedType.Bool)] out bool mute);
+}
+[StructLayout(LayoutKind.Sequential)] struct PropertyKey { public Guid Fmtid; public int Pid; }
+[StructLayout(LayoutKind.Explicit)] struct PropVariant { [FieldOffset(0)] public short Vt; [FieldOffset(8)] public IntPtr Value; [FieldOffset(16)] public IntPtr Pad; }
+[ComImport, Guid("886d8eeb-8cf2-4446-8d02-cdba1dbdcf99"), InterfaceType(ComInterfaceType.InterfaceIsIUnknown)]
+interface IPropertyStore {
+  int GetCount(out int count);
+  int GetAt(int i, out PropertyKey key);
+  int GetValue(ref PropertyKey key, out PropVariant value);
+}
+[ComImport, Guid("D666063F-1587-4E43-81F1-B948E807363F"), InterfaceType(ComInterfaceType.InterfaceIsIUnknown)]
+interface IMMDevice {
+  int Activate(ref Guid iid, int clsCtx, IntPtr parameters, [MarshalAs(UnmanagedType.IUnknown)] out object o);
+  int OpenPropertyStore(int access, out IPropertyStore store);
+  int GetId([MarshalAs(UnmanagedType.LPWStr)] out string id);
+}
+[ComImport, Guid("0BD7A1BE-7A1A-44DB-8397-CC5392387B5E"), InterfaceType(ComInterfaceType.InterfaceIsIUnknown)]
+interface IMMDeviceCollection {
+  int GetCount(out int count);
+  int Item(int i, out IMMDevice device);
+}
+[ComImport, Guid("A95664D2-9614-4F35-A746-DE8DB63617E6"), InterfaceType(ComInterfaceType.InterfaceIsIUnknown)]
+interface IMMDeviceEnumerator {
+  int EnumAudioEndpoints(int flow, int state, out IMMDeviceCollection devices);
+  int GetDefaultAudioEndpoint(int flow, int role, out IMMDevice device);
+}
+[ComImport, Guid("BCDE0395-E52F-467C-8E3D-C4579291692E")] class MMDeviceEnumerator {}
+public static class XonAudio {
+  static IMMDeviceEnumerator Enumerator() { return (IMMDeviceEnumerator)new MMDeviceEnumerator(); }
+  static IAudioEndpointVolume Volume() {
+    IMMDevice d;
+    Marshal.ThrowExceptionForHR(Enumerator().GetDefaultAudioEndpoint(0, 0, out d));
+    Guid iid = typeof(IAudioEndpointVolume).GUID;
+    object o;
+    Marshal.ThrowExceptionForHR(d.Activate(ref iid, 23, IntPtr.Zero, out o));
+    return (IAudioEndpointVolume)o;
+  }
+  public static int Get() {
+    float level;
+    Marshal.ThrowExceptionForHR(Volume().GetMasterVolumeLevelScalar(out level));
+    return (int)Math.Round(level * 100);
+  }
+  public static void Set(int percent) { Marshal.ThrowExceptionForHR(Volume().SetMasterVolumeLevelScalar(percent / 100f, IntPtr.Zero)); }
+  public static void Mute(bool mute) { Marshal.ThrowExceptionForHR(Volume().SetMute(mute, IntPtr.Zero)); }
+  public static string Devices() {
+    PropertyKey friendlyName = new PropertyKey { Fmtid = new Guid("a45c254e-df1c-4efd-8020-67d146a850e0"), Pid = 14 };
+    StringBuilder sb = new StringBuilder();
+    for (int flow = 0; flow < 2; flow++) {
+      string def = "";
+      IMMDevice d;
+      if (Enumerator().GetDefaultAudioEndpoint(flow, 0, out d) == 0) d.GetId(out def);
+      IMMDeviceCollection devices;
+      Marshal.ThrowExceptionForHR(Enumerator().EnumAudioEndpoints(flow, 1, out devices));
+      int n;
+      devices.GetCount(out n);
+      for (int i = 0; i < n; i++) {
+        string id;
+        IPropertyStore store;
+        PropVariant name;
+        devices.Item(i, out d);
+        d.GetId(out id);
+        d.OpenPropertyStore(0, out store);
+        store.GetValue(ref friendlyName, out name);
+        sb.Append(flow == 0 ? "output" : "input").Append('\t').Append(id).Append('\t');
+        sb.Append(id == def ? "1" : "0").Append('\t').Append(Marshal.PtrToStringUni(name.Value)).Append('\n');
+      }
+    }
+    return sb.ToString();
+  }
+}
+'@
+try {
+  switch ($env:XON_AUDIO) {
+    'devices' { [Console]::Out.Write([XonAudio]::Devices()) }
+    'get' { [XonAudio]::Get() }
+    'set' { [XonAudio]::Set([int]$env:XON_VOLUME) }
+    'mute' { [XonAudio]::Mute($env:XON_VOLUME -eq '1') }
+  }
+} catch { [Console]::Error.WriteLine($_.Exception.Message); exit 1 }`
+
+func coreAudio(action, value string) (string, error) {
+	cmd := exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", audioScript)
+	cmd.Env = append(os.Environ(), "XON_AUDIO="+action, "XON_VOLUME="+value)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return "", fmt.Errorf("%s", msg)
+		}
+		return "", err
+	}
+	return string(out), nil
+}
+
+func audioDevices() ([]audioDevice, error) {
+	out, err := coreAudio("devices", "")
+	if err != nil {
+		return nil, err
+	}
+	var devices []audioDevice
+	for _, line := range strings.Split(strings.ReplaceAll(out, "\r", ""), "\n") {
+		f := strings.SplitN(line, "\t", 4)
+		if len(f) != 4 {
+			continue
+		}
+		devices = append(devices, audioDevice{Kind: f[0], ID: f[1], Default: f[2] == "1", Name: f[3]})
+	}
+	return devices, nil
+}
+
+func volumeGet() (int64, error) {
+	out, err := coreAudio("get", "")
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(out), 10, 64)
+}
+
+func volumeSet(percent int64) error {
+	_, err := coreAudio("set", strconv.FormatInt(percent, 10))
+	return err
+}
+
+func volumeMute(mute bool) error {
+	value := "0"
+	if mute {
+		value = "1"
+	}
+	_, err := coreAudio("mute", value)
+	return err
+}
+
+// mciAliases numbers the MCI devices opened for recording and playback,
+// so that several can be open at once.
+var mciAliases atomic.Int64
+
+// mci sends an MCI command string, such as "play x wait".
+func mci(command string) error {
+	p, err := syscall.UTF16PtrFromString(command)
+	if err != nil {
+		return err
+	}
+	if r, _, _ := mciSendString.Call(uintptr(unsafe.Pointer(p)), 0, 0, 0); r != 0 {
+		buf := make([]uint16, 256)
+		mciGetError.Call(r, uintptr(unsafe.Pointer(&buf[0])), uintptr(len(buf)))
+		return fmt.Errorf("%s", syscall.UTF16ToString(buf))
+	}
+	return nil
+}
+
+// audioRecord records through MCI, which only knows the default input;
+// it is chosen in the Sound settings.
+func audioRecord(seconds float64, path, device string) error {
+	if device != "" {
+		return fmt.Errorf("choosing a device is not supported on windows; recordings use the default input")
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	alias := fmt.Sprintf("xonrec%d", mciAliases.Add(1))
+	if err := mci("open new type waveaudio alias " + alias); err != nil {
+		return err
+	}
+	defer mci("close " + alias)
+	if err := mci("set " + alias + " bitspersample 16 channels 1 samplespersec 44100 bytespersec 88200 alignment 2"); err != nil {
+		return err
+	}
+	if err := mci("record " + alias); err != nil {
+		return err
+	}
+	time.Sleep(time.Duration(seconds * float64(time.Second)))
+	if err := mci("stop " + alias); err != nil {
+		return err
+	}
+	return mci("save " + alias + ` "` + abs + `"`)
+}
+
+// audioPlay plays through MCI, which picks a player by the file's
+// extension and uses the default output.
+func audioPlay(path, device string, wait bool) error {
+	if device != "" {
+		return fmt.Errorf("choosing a device is not supported on windows; sounds play on the default output")
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	play := func() error {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		alias := fmt.Sprintf("xonplay%d", mciAliases.Add(1))
+		if err := mci(`open "` + abs + `" alias ` + alias); err != nil {
+			return err
+		}
+		defer mci("close " + alias)
+		return mci("play " + alias + " wait")
+	}
+	if !wait {
+		go play()
+		return nil
+	}
+	return play()
+}
+
+// wifiList reads the WLAN service's last scan; netsh cannot start a new one.
+func wifiList() ([]wifiNetwork, error) {
+	out, err := consoleTool("netsh", "wlan", "show", "networks", "mode=bssid")
+	if err != nil {
+		return nil, err
+	}
+	ifaces, _ := consoleTool("netsh", "wlan", "show", "interfaces")
+	return parseNetsh(out, netshConnected(ifaces)), nil
+}
+
+// wifiConnect adds a profile for the network, replacing any of the same
+// name, and connects with it.
+func wifiConnect(ssid, pass string) error {
+	f, err := os.CreateTemp("", "xon-wlan-*.xml")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(wlanProfile(ssid, pass))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := consoleTool("netsh", "wlan", "add", "profile", "filename="+f.Name()); err != nil {
+		return err
+	}
+	_, err = consoleTool("netsh", "wlan", "connect", "name="+ssid, "ssid="+ssid)
+	return err
+}
+
+// scheduleInstall creates the task under \Xon in Task Scheduler, for the
+// current user, replacing any task of the same name.
+func scheduleInstall(name string, fields, command []string) error {
+	trigger, err := schtasksTrigger(fields)
+	if err != nil {
+		return err
+	}
+	run := `"` + strings.Join(command, `" "`) + `"`
+	args := append([]string{"/Create", "/F", "/TN", `Xon\` + name, "/TR", run}, trigger...)
+	_, err = runTool("schtasks", args...)
+	return err
+}
+
+func scheduleRemove(name string) error {
+	_, err := runTool("schtasks", "/Delete", "/F", "/TN", `Xon\`+name)
+	return err
+}
+
+// serviceInstall registers `xon service run` for the script with the
+// service manager, started automatically at boot as LocalSystem.
+func serviceInstall(script string, opts ServiceOptions, exe string) error {
+	binPath := syscall.EscapeArg(exe) + " service run --name " + opts.Name +
+		" --log " + syscall.EscapeArg(opts.Log) + " " + syscall.EscapeArg(script)
+	_, err := consoleTool("sc.exe", "create", serviceUnitName(opts.Name), "binPath=", binPath,
+		"start=", "auto", "DisplayName=", "Xon "+opts.Name)
+	return err
+}
+
+func serviceControl(name, action string) error {
+	_, err := consoleTool("sc.exe", action, serviceUnitName(name))
+	return err
+}
+
+// serviceRemove stops the service first; one that is not running is fine.
+func serviceRemove(name string) error {
+	consoleTool("sc.exe", "stop", serviceUnitName(name))
+	_, err := consoleTool("sc.exe", "delete", serviceUnitName(name))
+	return err
+}
+
+// serviceStatus mirrors the Win32 SERVICE_STATUS structure.
+type serviceStatus struct {
+	ServiceType             uint32
+	CurrentState            uint32
+	ControlsAccepted        uint32
+	Win32ExitCode           uint32
+	ServiceSpecificExitCode uint32
+	CheckPoint              uint32
+	WaitHint                uint32
+}
+
+const (
+	serviceWin32OwnProcess = 0x10
+	serviceStopped         = 1
+	serviceStopPending     = 3
+	serviceRunning         = 4
+	serviceAcceptStop      = 0x1
+	serviceAcceptShutdown  = 0x4
+	serviceControlStop     = 1
+	serviceControlShutdown = 5
+)
+
+// serviceHost hands the process to the service manager, which calls back
+// on a thread of its own to start the service. run goes until stop is
+// closed by a stop or shutdown request.
+func serviceHost(name string, run func(stop <-chan struct{}) error) error {
+	svcName, err := syscall.UTF16PtrFromString(serviceUnitName(name))
+	if err != nil {
+		return err
+	}
+	stop := make(chan struct{})
+	var stopOnce sync.Once
+	var handle uintptr
+	report := func(state, accepts, exitCode uint32) {
+		status := serviceStatus{ServiceType: serviceWin32OwnProcess, CurrentState: state, ControlsAccepted: accepts, Win32ExitCode: exitCode}
+		setServiceStatus.Call(handle, uintptr(unsafe.Pointer(&status)))
+	}
+	handler := syscall.NewCallback(func(control, eventType, eventData, context uintptr) uintptr {
+		if control == serviceControlStop || control == serviceControlShutdown {
+			report(serviceStopPending, 0, 0)
+			stopOnce.Do(func() { close(stop) })
+		}
+		return 0 // NO_ERROR
+	})
+	var runErr error
+	serviceMain := syscall.NewCallback(func(argc, argv uintptr) uintptr {
+		handle, _, _ = regSvcHandler.Call(uintptr(unsafe.Pointer(svcName)), handler, 0)
+		report(serviceRunning, serviceAcceptStop|serviceAcceptShutdown, 0)
+		var exitCode uint32
+		if runErr = run(stop); runErr != nil {
+			exitCode = 1066 // ERROR_SERVICE_SPECIFIC_ERROR
+		}
+		report(serviceStopped, 0, exitCode)
+		return 0
+	})
+	table := [2]struct {
+		name *uint16
+		proc uintptr
+	}{{svcName, serviceMain}, {nil, 0}}
+	// Returns once the service has stopped, or at once with an error when
+	// the process was not started by the service manager.
+	if r, _, err := startSvcDispatch.Call(uintptr(unsafe.Pointer(&table[0]))); r == 0 {
+		return err
+	}
+	return runErr
+}
+
+// dataBlob mirrors the Win32 DATA_BLOB structure used by DPAPI.
+type dataBlob struct {
+	Size uint32
+	Data *byte
+}
+
+const cryptprotectUIForbidden = 0x1
+
+// secretPath is the file a secret is kept in, encrypted with DPAPI so only
+// the same Windows user can read it back.
+func secretPath(name string) (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "Xon", secretService, name+".dpapi"), nil
+}
+
+// dpapi runs CryptProtectData or CryptUnprotectData over data.
+func dpapi(proc *syscall.LazyProc, data []byte) ([]byte, error) {
+	// DPAPI wants a valid pointer even for no data.
+	buf := append(data[:len(data):len(data)], 0)
+	in := dataBlob{Size: uint32(len(data)), Data: &buf[0]}
+	var out dataBlob
+	r, _, err := proc.Call(uintptr(unsafe.Pointer(&in)), 0, 0, 0, 0, cryptprotectUIForbidden, uintptr(unsafe.Pointer(&out)))
+	if r == 0 {
+		return nil, err
+	}
+	defer localFree.Call(uintptr(unsafe.Pointer(out.Data)))
+	return append([]byte(nil), unsafe.Slice(out.Data, out.Size)...), nil
+}
+
+func secretGet(name string) (string, bool, error) {
+	path, err := secretPath(name)
+	if err != nil {
+		return "", false, err
+	}
+	sealed, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, err
+	}
+	value, err := dpapi(cryptUnprotect, sealed)
+	if err != nil {
+		return "", false, fmt.Errorf("cannot decrypt %s: %v", path, err)
+	}
+	return string(value), true, nil
+}
+
+func secretSet(name, value string) error {
+	path, err := secretPath(name)
+	if err != nil {
+		return err
+	}
+	sealed, err := dpapi(cryptProtect, []byte(value))
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, sealed, 0600)
+}
+
+func secretDelete(name string) error {
+	path, err := secretPath(name)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// disableConsoleEcho turns off echo on the console input handle and returns a
+// function restoring the previous mode. It is a no-op when stdin is not a console.
+func disableConsoleEcho() func() {
+	h, _, _ := getStdHandle.Call(stdInputHandle)
+	var mode uint32
+	if r, _, _ := getConsoleMode.Call(h, uintptr(unsafe.Pointer(&mode))); r == 0 {
+		return func() {}
+	}
+	setConsoleMode.Call(h, uintptr(mode&^enableEchoInput))
+	return func() { setConsoleMode.Call(h, uintptr(mode)) }
+}
+
+// readKey returns the next console key code without blocking; ok is false
+// when no key is waiting. Extended keys (arrows and the like) come back as
+// 0 or 0xE0 and a second readKey returns their scan code.
+func readKey() (code uintptr, ok bool, err error) {
+	if r, _, _ := kbhit.Call(); r == 0 {
+		return 0, false, nil
+	}
+	ch, _, _ := getch.Call()
+	return ch, true, nil
+}
+
+// lockFile takes an exclusive lock on f. When block is false it returns
+// false at once if another process holds the lock.
+func lockFile(f *os.File, block bool) (bool, error) {
+	flags := uintptr(lockfileExclusiveLock)
+	if !block {
+		flags |= lockfileFailImmediately
+	}
+	var ol overlapped
+	r, _, callErr := lockFileEx.Call(f.Fd(), flags, 0, 1, 0, uintptr(unsafe.Pointer(&ol)))
+	if r != 0 {
+		return true, nil
+	}
+	if block {
+		return false, callErr
+	}
+	return false, nil
+}
+
+func unlockFile(f *os.File) {
+	var ol overlapped
+	unlockFileEx.Call(f.Fd(), 0, 1, 0, uintptr(unsafe.Pointer(&ol)))
+}
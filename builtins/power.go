@@ -0,0 +1,220 @@
+// Power and displays - battery state, monitor layout and brightness
+
+package builtins
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"xon/object"
+)
+
+func init() {
+	builtinsMap["battery_status"] = &object.Builtin{Fn: batteryStatusBuiltin}
+	builtinsMap["display_list"] = &object.Builtin{Fn: displayListBuiltin}
+	builtinsMap["display_set_brightness"] = &object.Builtin{Fn: displaySetBrightness}
+}
+
+// batteryInfo is what battery_status reports. Percent and SecondsLeft are
+// -1 when the system does not know them.
+type batteryInfo struct {
+	Present     bool
+	Percent     int64
+	Charging    bool
+	Plugged     bool
+	SecondsLeft int64
+}
+
+// displayInfo is one monitor in virtual-screen coordinates, the ones
+// os_mouse_move takes; monitors left of or above the primary have
+// negative x or y.
+type displayInfo struct {
+	Name                string
+	X, Y, Width, Height int64
+	Primary             bool
+}
+
+func batteryStatusBuiltin(args ...object.Object) object.Object {
+	if len(args) != 0 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=0", len(args))}
+	}
+	b, err := batteryStatus()
+	if err != nil {
+		return &object.Error{Message: "battery_status: " + err.Error()}
+	}
+	h := &object.Hash{Pairs: make(map[object.HashKey]object.HashPair)}
+	setHashPair(h, "present", boolToObj(b.Present))
+	setHashPair(h, "percent", &object.Integer{Value: b.Percent})
+	setHashPair(h, "charging", boolToObj(b.Charging))
+	setHashPair(h, "plugged", boolToObj(b.Plugged))
+	setHashPair(h, "seconds_left", &object.Integer{Value: b.SecondsLeft})
+	return h
+}
+
+func displayListBuiltin(args ...object.Object) object.Object {
+	if len(args) != 0 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=0", len(args))}
+	}
+	displays, err := displayList()
+	if err != nil {
+		return &object.Error{Message: "display_list: " + err.Error()}
+	}
+	elements := make([]object.Object, len(displays))
+	for i, d := range displays {
+		h := &object.Hash{Pairs: make(map[object.HashKey]object.HashPair)}
+		setHashPair(h, "name", &object.String{Value: d.Name})
+		setHashPair(h, "x", &object.Integer{Value: d.X})
+		setHashPair(h, "y", &object.Integer{Value: d.Y})
+		setHashPair(h, "width", &object.Integer{Value: d.Width})
+		setHashPair(h, "height", &object.Integer{Value: d.Height})
+		setHashPair(h, "primary", boolToObj(d.Primary))
+		elements[i] = h
+	}
+	return &object.Array{Elements: elements}
+}
+
+// displaySetBrightness sets brightness in percent on the named display, or
+// on every display that supports it.
+func displaySetBrightness(args ...object.Object) object.Object {
+	if len(args) < 1 || len(args) > 2 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1 or 2", len(args))}
+	}
+	percent, ok := args[0].(*object.Integer)
+	if !ok {
+		return &object.Error{Message: fmt.Sprintf("display_set_brightness: percent must be INTEGER, got %s", args[0].Type())}
+	}
+	if percent.Value < 0 || percent.Value > 100 {
+		return &object.Error{Message: fmt.Sprintf("display_set_brightness: percent must be between 0 and 100, got %d", percent.Value)}
+	}
+	name := ""
+	if len(args) == 2 {
+		s, ok := args[1].(*object.String)
+		if !ok {
+			return &object.Error{Message: fmt.Sprintf("display_set_brightness: display name must be STRING, got %s", args[1].Type())}
+		}
+		name = s.Value
+	}
+	if err := setBrightness(percent.Value, name); err != nil {
+		return &object.Error{Message: "display_set_brightness: " + err.Error()}
+	}
+	return TRUE
+}
+
+// sysfsBattery reads a Linux power_supply directory: the first battery,
+// and whether any mains supply is online.
+func sysfsBattery(dir string) batteryInfo {
+	b := batteryInfo{Percent: -1, SecondsLeft: -1}
+	read := func(supply, file string) string {
+		data, _ := os.ReadFile(filepath.Join(dir, supply, file))
+		return strings.TrimSpace(string(data))
+	}
+	readInt := func(supply, file string) (int64, bool) {
+		n, err := strconv.ParseInt(read(supply, file), 10, 64)
+		return n, err == nil
+	}
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		supply := e.Name()
+		switch read(supply, "type") {
+		case "Mains", "USB":
+			if read(supply, "online") == "1" {
+				b.Plugged = true
+			}
+		case "Battery":
+			if b.Present || read(supply, "present") == "0" {
+				continue
+			}
+			b.Present = true
+			if n, ok := readInt(supply, "capacity"); ok {
+				b.Percent = n
+			}
+			status := read(supply, "status")
+			b.Charging = status == "Charging"
+			if status != "Discharging" {
+				continue
+			}
+			// Either energy (µWh, µW) or charge (µAh, µA) files are present.
+			now, ok1 := readInt(supply, "energy_now")
+			rate, ok2 := readInt(supply, "power_now")
+			if !ok1 || !ok2 {
+				now, ok1 = readInt(supply, "charge_now")
+				rate, ok2 = readInt(supply, "current_now")
+			}
+			if ok1 && ok2 && rate > 0 {
+				b.SecondsLeft = now * 3600 / rate
+			}
+		}
+	}
+	return b
+}
+
+var (
+	xrandrMonitor = regexp.MustCompile(`^(\S+) connected (primary )?(\d+)x(\d+)\+(-?\d+)\+(-?\d+)`)
+	pmsetPercent  = regexp.MustCompile(`(\d+)%;\s*([a-zA-Z ]+);\s*(?:(\d+):(\d+) remaining)?`)
+)
+
+// parseXrandr returns the active monitors in `xrandr --query` output.
+// Connected outputs that are switched off have no geometry and are left out.
+func parseXrandr(out string) []displayInfo {
+	var displays []displayInfo
+	for _, line := range strings.Split(out, "\n") {
+		m := xrandrMonitor.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		n := func(i int) int64 { v, _ := strconv.ParseInt(m[i], 10, 64); return v }
+		displays = append(displays, displayInfo{Name: m[1], Primary: m[2] != "", Width: n(3), Height: n(4), X: n(5), Y: n(6)})
+	}
+	return displays
+}
+
+// parsePmset reads `pmset -g batt` output from macOS.
+func parsePmset(out string) batteryInfo {
+	b := batteryInfo{Percent: -1, SecondsLeft: -1, Plugged: strings.Contains(out, "'AC Power'")}
+	m := pmsetPercent.FindStringSubmatch(out)
+	if m == nil {
+		return b
+	}
+	b.Present = true
+	b.Percent, _ = strconv.ParseInt(m[1], 10, 64)
+	b.Charging = strings.TrimSpace(m[2]) == "charging"
+	if m[3] != "" && !b.Plugged {
+		hours, _ := strconv.ParseInt(m[3], 10, 64)
+		minutes, _ := strconv.ParseInt(m[4], 10, 64)
+		b.SecondsLeft = hours*3600 + minutes*60
+	}
+	return b
+}
+
+// runTool runs a helper program found on the PATH and returns its stdout.
+// A failure carries what it printed on stderr.
+func runTool(name string, args ...string) (string, error) {
+	return runToolInput(nil, name, args...)
+}
+
+// runToolInput is runTool with stdin read from input.
+func runToolInput(input io.Reader, name string, args ...string) (string, error) {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return "", fmt.Errorf("%s is not installed or not on the PATH", name)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(path, args...)
+	cmd.Stdin = input
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		return "", fmt.Errorf("%s: %s", name, msg)
+	}
+	return stdout.String(), nil
+}
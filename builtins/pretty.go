@@ -0,0 +1,29 @@
+// Pretty - indented, depth-limited rendering of nested values
+
+package builtins
+
+import (
+	"fmt"
+	"xon/object"
+)
+
+func init() {
+	builtinsMap["pretty"] = &object.Builtin{Fn: prettyString}
+}
+
+// prettyString implements pretty(value, depth?): the value rendered over
+// indented lines, with collections nested deeper than depth shown as [...].
+func prettyString(args ...object.Object) object.Object {
+	if len(args) != 1 && len(args) != 2 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1 or 2", len(args))}
+	}
+	opts := object.DefaultPretty
+	if len(args) == 2 {
+		depth, ok := args[1].(*object.Integer)
+		if !ok || depth.Value < 0 {
+			return &object.Error{Message: "depth argument to `pretty` must be a non-negative INTEGER"}
+		}
+		opts.MaxDepth = int(depth.Value)
+	}
+	return &object.String{Value: object.Pretty(args[0], opts)}
+}
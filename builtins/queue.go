@@ -0,0 +1,438 @@
+// Queue - durable job queues with visibility timeouts, retries and a dead-letter list
+
+package builtins
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+	"xon/object"
+)
+
+func init() {
+	builtinsMap["queue_push"] = &object.Builtin{Fn: queuePush}
+	builtinsMap["queue_pop"] = &object.Builtin{Fn: queuePop}
+	builtinsMap["queue_ack"] = &object.Builtin{Fn: queueAck}
+	builtinsMap["queue_fail"] = &object.Builtin{Fn: queueFail}
+	builtinsMap["queue_stats"] = &object.Builtin{Fn: queueStats}
+	builtinsMap["queue_dead"] = &object.Builtin{Fn: queueDead}
+	builtinsMap["queue_redrive"] = &object.Builtin{Fn: queueRedrive}
+}
+
+const (
+	defaultMaxAttempts = 3
+	defaultVisibility  = 30 * time.Second
+)
+
+var queueName = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// queueJob is one job as kept on disk. VisibleAt (Unix ms) hides a job
+// that was delayed, or popped and not yet acknowledged. Receipt names the
+// latest pop; only its holder may acknowledge or fail the job.
+type queueJob struct {
+	ID          string          `json:"id"`
+	Receipt     string          `json:"receipt,omitempty"`
+	Payload     json.RawMessage `json:"payload"`
+	Attempts    int64           `json:"attempts"`
+	MaxAttempts int64           `json:"max_attempts"`
+	VisibleAt   int64           `json:"visible_at"`
+	Error       string          `json:"error,omitempty"`
+}
+
+// queueState is a queue's file: the jobs in the order they were pushed,
+// and the ones that ran out of attempts.
+type queueState struct {
+	Jobs []queueJob `json:"jobs"`
+	Dead []queueJob `json:"dead"`
+}
+
+// queueDir is where queue files live: XON_QUEUE_DIR, or Xon/queues in the
+// user's config directory.
+func queueDir() (string, error) {
+	if dir := os.Getenv("XON_QUEUE_DIR"); dir != "" {
+		return dir, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "Xon", "queues"), nil
+}
+
+// withQueue runs update on the named queue while holding its lock file, so
+// several scripts can share a queue, and saves the state when update
+// reports a change. The file is replaced in one rename, so a crash leaves
+// either the old state or the new one.
+func withQueue(name string, update func(q *queueState) (bool, error)) error {
+	if !queueName.MatchString(name) {
+		return fmt.Errorf("queue name must be letters, digits, '.', '-' or '_', got %q", name)
+	}
+	dir, err := queueDir()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	path := filepath.Join(dir, name+".json")
+	lock, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	if _, err := lockFile(lock, true); err != nil {
+		return err
+	}
+	defer unlockFile(lock)
+
+	var q queueState
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &q); err != nil {
+			return fmt.Errorf("%s is damaged: %s", path, err)
+		}
+	}
+	changed, err := update(&q)
+	if err != nil || !changed {
+		return err
+	}
+	if data, err = json.Marshal(&q); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// newQueueToken returns a random id for a job or a receipt for a pop.
+func newQueueToken() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// jobHash is what queue_pop and queue_dead hand to scripts.
+func jobHash(j queueJob) *object.Hash {
+	var payload interface{}
+	json.Unmarshal(j.Payload, &payload)
+	h := &object.Hash{Pairs: make(map[object.HashKey]object.HashPair)}
+	setHashPair(h, "id", &object.String{Value: j.ID})
+	if j.Receipt != "" {
+		setHashPair(h, "receipt", &object.String{Value: j.Receipt})
+	}
+	setHashPair(h, "payload", rawToObj(payload))
+	setHashPair(h, "attempts", &object.Integer{Value: j.Attempts})
+	if j.Error != "" {
+		setHashPair(h, "error", &object.String{Value: j.Error})
+	}
+	return h
+}
+
+// queueArgs checks the argument count and returns the queue name.
+func queueArgs(fn string, args []object.Object, min, max int) (string, *object.Error) {
+	if len(args) < min || len(args) > max {
+		want := fmt.Sprint(min)
+		if max > min {
+			want = fmt.Sprintf("%d or %d", min, max)
+		}
+		return "", &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=%s", len(args), want)}
+	}
+	name, ok := args[0].(*object.String)
+	if !ok {
+		return "", &object.Error{Message: fmt.Sprintf("%s: queue name must be STRING, got %s", fn, args[0].Type())}
+	}
+	return name.Value, nil
+}
+
+// queueOptions returns the options hash at index n, or an empty one.
+func queueOptions(fn string, args []object.Object, n int) (*object.Hash, *object.Error) {
+	if len(args) <= n {
+		return &object.Hash{Pairs: make(map[object.HashKey]object.HashPair)}, nil
+	}
+	h, ok := args[n].(*object.Hash)
+	if !ok {
+		return nil, &object.Error{Message: fn + " options must be a hash"}
+	}
+	return h, nil
+}
+
+// queuePush implements queue_push(name, payload, options?). The payload is
+// stored as JSON. Options: max_attempts (default 3) and delay (ms before
+// the job can be popped). Returns the job's id.
+func queuePush(args ...object.Object) object.Object {
+	name, errObj := queueArgs("queue_push", args, 2, 3)
+	if errObj != nil {
+		return errObj
+	}
+	opts, errObj := queueOptions("queue_push", args, 2)
+	if errObj != nil {
+		return errObj
+	}
+	payload, err := json.Marshal(objToRaw(args[1]))
+	if err != nil {
+		return &object.Error{Message: "queue_push: " + err.Error()}
+	}
+	job := queueJob{Payload: payload, MaxAttempts: defaultMaxAttempts, VisibleAt: time.Now().UnixMilli()}
+	if getHashValue(opts, "max_attempts") != nil {
+		job.MaxAttempts = getHashInt(opts, "max_attempts")
+		if job.MaxAttempts < 1 {
+			return &object.Error{Message: fmt.Sprintf("queue_push: max_attempts must be at least 1, got %d", job.MaxAttempts)}
+		}
+	}
+	job.VisibleAt += getHashInt(opts, "delay")
+	job.ID = newQueueToken()
+
+	err = withQueue(name, func(q *queueState) (bool, error) {
+		q.Jobs = append(q.Jobs, job)
+		return true, nil
+	})
+	if err != nil {
+		return &object.Error{Message: "queue_push: " + err.Error()}
+	}
+	return &object.String{Value: job.ID}
+}
+
+// queuePop implements queue_pop(name, options?). It hands out the oldest
+// visible job and hides it for the visibility option (ms, default 30000);
+// unless queue_ack removes it by then, it is handed out again with a new
+// receipt, and the old one stops working. A job whose last attempt timed
+// out goes to the dead-letter list instead. Returns {id, receipt, payload,
+// attempts}, or null when no job is ready.
+func queuePop(args ...object.Object) object.Object {
+	name, errObj := queueArgs("queue_pop", args, 1, 2)
+	if errObj != nil {
+		return errObj
+	}
+	opts, errObj := queueOptions("queue_pop", args, 1)
+	if errObj != nil {
+		return errObj
+	}
+	visibility := defaultVisibility.Milliseconds()
+	if getHashValue(opts, "visibility") != nil {
+		visibility = getHashInt(opts, "visibility")
+		if visibility < 1 {
+			return &object.Error{Message: fmt.Sprintf("queue_pop: visibility must be at least 1 ms, got %d", visibility)}
+		}
+	}
+	var popped *queueJob
+	err := withQueue(name, func(q *queueState) (bool, error) {
+		now := time.Now().UnixMilli()
+		changed := false
+		for i := 0; i < len(q.Jobs); i++ {
+			j := &q.Jobs[i]
+			if j.VisibleAt > now {
+				continue
+			}
+			if j.Attempts >= j.MaxAttempts {
+				j.Error = "visibility timeout expired"
+				j.Receipt = ""
+				q.Dead = append(q.Dead, *j)
+				q.Jobs = append(q.Jobs[:i], q.Jobs[i+1:]...)
+				i--
+				changed = true
+				continue
+			}
+			j.Attempts++
+			j.VisibleAt = now + visibility
+			j.Receipt = newQueueToken()
+			job := *j
+			popped = &job
+			return true, nil
+		}
+		return changed, nil
+	})
+	if err != nil {
+		return &object.Error{Message: "queue_pop: " + err.Error()}
+	}
+	if popped == nil {
+		return NULL
+	}
+	return jobHash(*popped)
+}
+
+// findReceipt returns the index of the job last popped with receipt, or
+// -1 once the job is done, dead or handed out again.
+func findReceipt(jobs []queueJob, receipt string) int {
+	for i, j := range jobs {
+		if receipt != "" && j.Receipt == receipt {
+			return i
+		}
+	}
+	return -1
+}
+
+// queueAck implements queue_ack(name, receipt): the job popped with that
+// receipt is done and removed. Returns false if the receipt is stale, as
+// when the visibility timeout ran out and another worker popped the job.
+func queueAck(args ...object.Object) object.Object {
+	name, errObj := queueArgs("queue_ack", args, 2, 2)
+	if errObj != nil {
+		return errObj
+	}
+	receipt, ok := args[1].(*object.String)
+	if !ok {
+		return &object.Error{Message: fmt.Sprintf("queue_ack: receipt must be STRING, got %s", args[1].Type())}
+	}
+	found := false
+	err := withQueue(name, func(q *queueState) (bool, error) {
+		i := findReceipt(q.Jobs, receipt.Value)
+		if i < 0 {
+			return false, nil
+		}
+		q.Jobs = append(q.Jobs[:i], q.Jobs[i+1:]...)
+		found = true
+		return true, nil
+	})
+	if err != nil {
+		return &object.Error{Message: "queue_ack: " + err.Error()}
+	}
+	return boolToObj(found)
+}
+
+// queueFail implements queue_fail(name, receipt, error?). The job popped
+// with that receipt can be popped again at once, or goes to the
+// dead-letter list with the error once its attempts are used up. Returns
+// true if it will be retried; a stale receipt is an error.
+func queueFail(args ...object.Object) object.Object {
+	name, errObj := queueArgs("queue_fail", args, 2, 3)
+	if errObj != nil {
+		return errObj
+	}
+	receipt, ok := args[1].(*object.String)
+	if !ok {
+		return &object.Error{Message: fmt.Sprintf("queue_fail: receipt must be STRING, got %s", args[1].Type())}
+	}
+	reason := ""
+	if len(args) == 3 {
+		switch r := args[2].(type) {
+		case *object.String:
+			reason = r.Value
+		case *object.Error:
+			reason = r.Message
+		default:
+			reason = r.Inspect()
+		}
+	}
+	retry := false
+	err := withQueue(name, func(q *queueState) (bool, error) {
+		i := findReceipt(q.Jobs, receipt.Value)
+		if i < 0 {
+			return false, fmt.Errorf("receipt %q is not current in queue %q; the job was finished or handed out again", receipt.Value, name)
+		}
+		j := q.Jobs[i]
+		j.Error, j.Receipt = reason, ""
+		if j.Attempts < j.MaxAttempts {
+			j.VisibleAt = time.Now().UnixMilli()
+			q.Jobs[i] = j
+			retry = true
+			return true, nil
+		}
+		q.Jobs = append(q.Jobs[:i], q.Jobs[i+1:]...)
+		q.Dead = append(q.Dead, j)
+		return true, nil
+	})
+	if err != nil {
+		return &object.Error{Message: "queue_fail: " + err.Error()}
+	}
+	return boolToObj(retry)
+}
+
+// queueStats implements queue_stats(name) -> {pending, in_flight, dead}.
+// Jobs still delayed, or whose visibility ran out, count as pending.
+func queueStats(args ...object.Object) object.Object {
+	name, errObj := queueArgs("queue_stats", args, 1, 1)
+	if errObj != nil {
+		return errObj
+	}
+	var pending, inFlight, dead int64
+	err := withQueue(name, func(q *queueState) (bool, error) {
+		now := time.Now().UnixMilli()
+		for _, j := range q.Jobs {
+			if j.Receipt != "" && j.VisibleAt > now {
+				inFlight++
+			} else {
+				pending++
+			}
+		}
+		dead = int64(len(q.Dead))
+		return false, nil
+	})
+	if err != nil {
+		return &object.Error{Message: "queue_stats: " + err.Error()}
+	}
+	h := &object.Hash{Pairs: make(map[object.HashKey]object.HashPair)}
+	setHashPair(h, "pending", &object.Integer{Value: pending})
+	setHashPair(h, "in_flight", &object.Integer{Value: inFlight})
+	setHashPair(h, "dead", &object.Integer{Value: dead})
+	return h
+}
+
+// queueDead implements queue_dead(name): the dead-letter jobs, oldest
+// first, each with the error of its last attempt.
+func queueDead(args ...object.Object) object.Object {
+	name, errObj := queueArgs("queue_dead", args, 1, 1)
+	if errObj != nil {
+		return errObj
+	}
+	var dead []queueJob
+	err := withQueue(name, func(q *queueState) (bool, error) {
+		dead = q.Dead
+		return false, nil
+	})
+	if err != nil {
+		return &object.Error{Message: "queue_dead: " + err.Error()}
+	}
+	elements := make([]object.Object, len(dead))
+	for i, j := range dead {
+		elements[i] = jobHash(j)
+	}
+	return &object.Array{Elements: elements}
+}
+
+// queueRedrive implements queue_redrive(name): every dead-letter job goes
+// back on the queue with its attempts reset, once whatever made it fail is
+// fixed. Returns how many were moved.
+func queueRedrive(args ...object.Object) object.Object {
+	name, errObj := queueArgs("queue_redrive", args, 1, 1)
+	if errObj != nil {
+		return errObj
+	}
+	var moved int64
+	err := withQueue(name, func(q *queueState) (bool, error) {
+		now := time.Now().UnixMilli()
+		for _, j := range q.Dead {
+			j.Attempts, j.VisibleAt, j.Error = 0, now, ""
+			q.Jobs = append(q.Jobs, j)
+		}
+		moved = int64(len(q.Dead))
+		q.Dead = nil
+		return moved > 0, nil
+	})
+	if err != nil {
+		return &object.Error{Message: "queue_redrive: " + err.Error()}
+	}
+	return &object.Integer{Value: moved}
+}
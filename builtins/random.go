@@ -0,0 +1,183 @@
+// Random - seedable generator shared by all random builtins, plus choice/shuffle helpers
+
+package builtins
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+	"xon/object"
+)
+
+var (
+	rngMu sync.Mutex
+	rng   = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+func init() {
+	builtinsMap["seed"] = &object.Builtin{Fn: randomSeed}
+	builtinsMap["random_choice"] = &object.Builtin{Fn: randomChoice}
+	builtinsMap["random_shuffle"] = &object.Builtin{Fn: randomShuffle}
+	builtinsMap["random_range"] = &object.Builtin{Fn: randomRange}
+	builtinsMap["weighted_choice"] = &object.Builtin{Fn: weightedChoice}
+}
+
+// randIntn and randFloat guard rng, which is not safe for concurrent use by spawned tasks.
+func randIntn(n int) int {
+	rngMu.Lock()
+	defer rngMu.Unlock()
+	return rng.Intn(n)
+}
+
+func randInt63n(n int64) int64 {
+	rngMu.Lock()
+	defer rngMu.Unlock()
+	return rng.Int63n(n)
+}
+
+func randFloat() float64 {
+	rngMu.Lock()
+	defer rngMu.Unlock()
+	return rng.Float64()
+}
+
+// randomSeed reseeds the generator so a run's random sequence is reproducible.
+func randomSeed(args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1", len(args))}
+	}
+	n, ok := args[0].(*object.Integer)
+	if !ok {
+		return &object.Error{Message: fmt.Sprintf("argument to `seed` must be INTEGER, got %s", args[0].Type())}
+	}
+	rngMu.Lock()
+	rng.Seed(n.Value)
+	rngMu.Unlock()
+	return NULL
+}
+
+func randomChoice(args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1", len(args))}
+	}
+	arr, ok := args[0].(*object.Array)
+	if !ok {
+		return &object.Error{Message: fmt.Sprintf("argument to `random_choice` must be ARRAY, got %s", args[0].Type())}
+	}
+	if len(arr.Elements) == 0 {
+		return NULL
+	}
+	return arr.Elements[randIntn(len(arr.Elements))]
+}
+
+// randomShuffle returns a shuffled copy; the argument is left untouched.
+func randomShuffle(args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1", len(args))}
+	}
+	arr, ok := args[0].(*object.Array)
+	if !ok {
+		return &object.Error{Message: fmt.Sprintf("argument to `random_shuffle` must be ARRAY, got %s", args[0].Type())}
+	}
+	elements := make([]object.Object, len(arr.Elements))
+	copy(elements, arr.Elements)
+	rngMu.Lock()
+	rng.Shuffle(len(elements), func(i, j int) { elements[i], elements[j] = elements[j], elements[i] })
+	rngMu.Unlock()
+	return &object.Array{Elements: elements}
+}
+
+// randomRange returns an INTEGER in [min, max] when both bounds are integers,
+// otherwise a FLOAT in [min, max).
+func randomRange(args ...object.Object) object.Object {
+	if len(args) != 2 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=2", len(args))}
+	}
+	lo, ok1 := args[0].(*object.Integer)
+	hi, ok2 := args[1].(*object.Integer)
+	if ok1 && ok2 {
+		if hi.Value < lo.Value {
+			return &object.Error{Message: fmt.Sprintf("random_range max %d is less than min %d", hi.Value, lo.Value)}
+		}
+		// The span is counted in uint64 so that it cannot wrap; Int63n
+		// takes at most MaxInt64 values.
+		span := uint64(hi.Value) - uint64(lo.Value)
+		if span >= math.MaxInt64 {
+			return &object.Error{Message: fmt.Sprintf("random_range from %d to %d spans more than %d values", lo.Value, hi.Value, int64(math.MaxInt64))}
+		}
+		return &object.Integer{Value: lo.Value + randInt63n(int64(span)+1)}
+	}
+	loF, ok1 := toFloat(args[0])
+	hiF, ok2 := toFloat(args[1])
+	if !ok1 || !ok2 {
+		return &object.Error{Message: "arguments to `random_range` must be numbers"}
+	}
+	if hiF < loF {
+		return &object.Error{Message: fmt.Sprintf("random_range max %g is less than min %g", hiF, loF)}
+	}
+	return &object.Float{Value: loF + randFloat()*(hiF-loF)}
+}
+
+// weightedChoice picks a value with probability proportional to its weight.
+// pairs is either an array of [value, weight] arrays or a hash of value => weight.
+func weightedChoice(args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1", len(args))}
+	}
+	var values []object.Object
+	var weights []float64
+	switch arg := args[0].(type) {
+	case *object.Array:
+		for _, el := range arg.Elements {
+			pair, ok := el.(*object.Array)
+			if !ok || len(pair.Elements) != 2 {
+				return &object.Error{Message: "weighted_choice pairs must be [value, weight] arrays"}
+			}
+			w, ok := toFloat(pair.Elements[1])
+			if !ok {
+				return &object.Error{Message: fmt.Sprintf("weight must be a number, got %s", pair.Elements[1].Type())}
+			}
+			values = append(values, pair.Elements[0])
+			weights = append(weights, w)
+		}
+	case *object.Hash:
+		// Go maps iterate in a random order; sorting keeps seed() reproducible.
+		pairs := make([]object.HashPair, 0, len(arg.Pairs))
+		for _, pair := range arg.Pairs {
+			pairs = append(pairs, pair)
+		}
+		sort.Slice(pairs, func(i, j int) bool { return object.Repr(pairs[i].Key) < object.Repr(pairs[j].Key) })
+		for _, pair := range pairs {
+			w, ok := toFloat(pair.Value)
+			if !ok {
+				return &object.Error{Message: fmt.Sprintf("weight must be a number, got %s", pair.Value.Type())}
+			}
+			values = append(values, pair.Key)
+			weights = append(weights, w)
+		}
+	default:
+		return &object.Error{Message: fmt.Sprintf("argument to `weighted_choice` must be ARRAY or HASH, got %s", args[0].Type())}
+	}
+
+	total := 0.0
+	for _, w := range weights {
+		if w < 0 {
+			return &object.Error{Message: "weights must not be negative"}
+		}
+		total += w
+	}
+	if total == 0 {
+		return NULL
+	}
+	target := randFloat() * total
+	for i, w := range weights {
+		if target < w {
+			return values[i]
+		}
+		target -= w
+	}
+	return values[len(values)-1]
+}
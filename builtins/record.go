@@ -0,0 +1,277 @@
+// Recording - capturing keyboard and mouse input as an editable event list and replaying it
+
+package builtins
+
+import (
+	"fmt"
+	"sync"
+	"time"
+	"xon/object"
+)
+
+func init() {
+	builtinsMap["os_record_start"] = &object.Builtin{Fn: recordStart}
+	builtinsMap["os_record_stop"] = &object.Builtin{Fn: recordStop}
+	builtinsMap["os_replay"] = &object.Builtin{Fn: replay}
+}
+
+// inputEvent is one keyboard or mouse action. Kind is key_down, key_up,
+// mouse_move, mouse_down, mouse_up or wheel; VK is set for keys, Button
+// (left, right or middle) for clicks and Delta for the wheel. X and Y are
+// the cursor position of mouse events.
+type inputEvent struct {
+	Kind   string
+	VK     int64
+	Button string
+	X, Y   int64
+	Delta  int64
+}
+
+// Input listeners share one system hook, installed while at least one is
+// registered. The platform delivers events to dispatchInput on its hook
+// thread, so listeners must return quickly. A listener returns true to
+// consume the event, keeping it from the application it was meant for.
+var inputListeners = struct {
+	sync.Mutex
+	next int
+	fns  map[int]func(inputEvent) bool
+	stop func()
+}{fns: make(map[int]func(inputEvent) bool)}
+
+// listenInput registers fn for every real (not injected) input event and
+// returns a function removing it.
+func listenInput(fn func(inputEvent) bool) (remove func(), err error) {
+	inputListeners.Lock()
+	defer inputListeners.Unlock()
+	if len(inputListeners.fns) == 0 {
+		stop, err := startInputHook(dispatchInput)
+		if err != nil {
+			return nil, err
+		}
+		inputListeners.stop = stop
+	}
+	inputListeners.next++
+	id := inputListeners.next
+	inputListeners.fns[id] = fn
+	return func() {
+		inputListeners.Lock()
+		defer inputListeners.Unlock()
+		if _, ok := inputListeners.fns[id]; !ok {
+			return
+		}
+		delete(inputListeners.fns, id)
+		if len(inputListeners.fns) == 0 {
+			inputListeners.stop()
+			inputListeners.stop = nil
+		}
+	}, nil
+}
+
+// dispatchInput passes ev to every listener and reports whether any of
+// them consumed it.
+func dispatchInput(ev inputEvent) (consumed bool) {
+	inputListeners.Lock()
+	fns := make([]func(inputEvent) bool, 0, len(inputListeners.fns))
+	for _, fn := range inputListeners.fns {
+		fns = append(fns, fn)
+	}
+	inputListeners.Unlock()
+	for _, fn := range fns {
+		if fn(ev) {
+			consumed = true
+		}
+	}
+	return consumed
+}
+
+// recording is the os_record_start session in progress, if any.
+var recording struct {
+	sync.Mutex
+	active bool
+	moves  bool
+	start  time.Time
+	events []*object.Hash
+	remove func()
+}
+
+// recordStart begins capturing input. Options: mouse_moves (false leaves
+// out cursor movement between clicks).
+func recordStart(args ...object.Object) object.Object {
+	if len(args) > 1 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=0 or 1", len(args))}
+	}
+	moves := true
+	if len(args) == 1 {
+		opts, ok := args[0].(*object.Hash)
+		if !ok {
+			return &object.Error{Message: fmt.Sprintf("os_record_start: options must be HASH, got %s", args[0].Type())}
+		}
+		if getHashValue(opts, "mouse_moves") != nil {
+			moves = getHashBool(opts, "mouse_moves")
+		}
+	}
+	recording.Lock()
+	defer recording.Unlock()
+	if recording.active {
+		return &object.Error{Message: "os_record_start: already recording"}
+	}
+	remove, err := listenInput(recordEvent)
+	if err != nil {
+		return &object.Error{Message: "os_record_start: " + err.Error()}
+	}
+	recording.active, recording.moves = true, moves
+	recording.start, recording.events, recording.remove = time.Now(), nil, remove
+	return TRUE
+}
+
+func recordEvent(ev inputEvent) bool {
+	recording.Lock()
+	defer recording.Unlock()
+	if !recording.active || (ev.Kind == "mouse_move" && !recording.moves) {
+		return false
+	}
+	h := eventHash(ev)
+	setHashPair(h, "time", &object.Integer{Value: time.Since(recording.start).Milliseconds()})
+	recording.events = append(recording.events, h)
+	return false
+}
+
+// recordStop ends the recording and returns its events, each a hash with
+// type, time (milliseconds from the start) and the fields of its kind.
+func recordStop(args ...object.Object) object.Object {
+	if len(args) != 0 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=0", len(args))}
+	}
+	recording.Lock()
+	if !recording.active {
+		recording.Unlock()
+		return &object.Error{Message: "os_record_stop: not recording"}
+	}
+	remove := recording.remove
+	recording.active, recording.remove = false, nil
+	events := recording.events
+	recording.events = nil
+	recording.Unlock()
+	remove()
+
+	elements := make([]object.Object, len(events))
+	for i, h := range events {
+		elements[i] = h
+	}
+	return &object.Array{Elements: elements}
+}
+
+// eventHash turns ev into the hash scripts see: {type, key, char} for
+// keys, {type, x, y} for moves, plus button for clicks and delta for the
+// wheel. char is the lower-case letter, digit or space a key types, or ""
+// for other keys.
+func eventHash(ev inputEvent) *object.Hash {
+	h := &object.Hash{Pairs: make(map[object.HashKey]object.HashPair)}
+	setHashPair(h, "type", &object.String{Value: ev.Kind})
+	switch ev.Kind {
+	case "key_down", "key_up":
+		setHashPair(h, "key", &object.Integer{Value: ev.VK})
+		setHashPair(h, "char", &object.String{Value: vkChar(ev.VK)})
+		return h
+	case "mouse_down", "mouse_up":
+		setHashPair(h, "button", &object.String{Value: ev.Button})
+	case "wheel":
+		setHashPair(h, "delta", &object.Integer{Value: ev.Delta})
+	}
+	setHashPair(h, "x", &object.Integer{Value: ev.X})
+	setHashPair(h, "y", &object.Integer{Value: ev.Y})
+	return h
+}
+
+// vkChar is the character a virtual key types without modifiers, for the
+// keys os_keyboard_type can press.
+func vkChar(vk int64) string {
+	switch {
+	case vk >= 0x41 && vk <= 0x5A:
+		return string(rune('a' + vk - 0x41))
+	case vk >= 0x30 && vk <= 0x39:
+		return string(rune('0' + vk - 0x30))
+	case vk == 0x20:
+		return " "
+	}
+	return ""
+}
+
+// hashEvent reads an event hash back, as edited by the script.
+func hashEvent(h *object.Hash) (inputEvent, error) {
+	ev := inputEvent{
+		Kind:   getHashStr(h, "type"),
+		VK:     getHashInt(h, "key"),
+		Button: getHashStr(h, "button"),
+		X:      getHashInt(h, "x"),
+		Y:      getHashInt(h, "y"),
+		Delta:  getHashInt(h, "delta"),
+	}
+	switch ev.Kind {
+	case "key_down", "key_up":
+		if ev.VK <= 0 || ev.VK > 0xFE {
+			return ev, fmt.Errorf("key must be a virtual key code from 1 to 254, got %d", ev.VK)
+		}
+	case "mouse_down", "mouse_up":
+		if ev.Button != "left" && ev.Button != "right" && ev.Button != "middle" {
+			return ev, fmt.Errorf("button must be left, right or middle, got %q", ev.Button)
+		}
+	case "mouse_move", "wheel":
+	default:
+		return ev, fmt.Errorf("unknown event type %q", ev.Kind)
+	}
+	return ev, nil
+}
+
+// replay sends recorded events again, waiting between them as long as the
+// recording did divided by speed. Every event is checked before the first
+// is sent, so a typo in an edited list does nothing rather than half a run.
+func replay(args ...object.Object) object.Object {
+	if len(args) < 1 || len(args) > 2 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1 or 2", len(args))}
+	}
+	list, ok := args[0].(*object.Array)
+	if !ok {
+		return &object.Error{Message: fmt.Sprintf("os_replay: events must be ARRAY, got %s", args[0].Type())}
+	}
+	speed := 1.0
+	if len(args) == 2 {
+		switch s := args[1].(type) {
+		case *object.Integer:
+			speed = float64(s.Value)
+		case *object.Float:
+			speed = s.Value
+		default:
+			return &object.Error{Message: fmt.Sprintf("os_replay: speed must be a number, got %s", args[1].Type())}
+		}
+		if speed <= 0 {
+			return &object.Error{Message: fmt.Sprintf("os_replay: speed must be positive, got %g", speed)}
+		}
+	}
+
+	events := make([]inputEvent, len(list.Elements))
+	times := make([]int64, len(list.Elements))
+	for i, el := range list.Elements {
+		h, ok := el.(*object.Hash)
+		if !ok {
+			return &object.Error{Message: fmt.Sprintf("os_replay: event %d must be HASH, got %s", i, el.Type())}
+		}
+		ev, err := hashEvent(h)
+		if err != nil {
+			return &object.Error{Message: fmt.Sprintf("os_replay: event %d: %v", i, err)}
+		}
+		events[i], times[i] = ev, getHashInt(h, "time")
+	}
+
+	var last int64
+	for i, ev := range events {
+		if wait := times[i] - last; i > 0 && wait > 0 {
+			time.Sleep(time.Duration(float64(wait) / speed * float64(time.Millisecond)))
+		}
+		last = times[i]
+		if err := sendInput(ev); err != nil {
+			return &object.Error{Message: "os_replay: " + err.Error()}
+		}
+	}
+	return TRUE
+}
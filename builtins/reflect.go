@@ -0,0 +1,124 @@
+// Reflection - function arity and parameters, classes, key lookup and dynamic calls for generic script code
+
+package builtins
+
+import (
+	"fmt"
+	"xon/object"
+)
+
+func init() {
+	builtinsMap["fn_arity"] = &object.Builtin{Fn: fnArity}
+	builtinsMap["fn_params"] = &object.Builtin{Fn: fnParams}
+	builtinsMap["has_key"] = &object.Builtin{Fn: hasKey}
+	builtinsMap["call"] = &object.Builtin{Fn: callWithArgs}
+	builtinsMap["class_of"] = &object.Builtin{Fn: classOf}
+}
+
+// methodFunction returns the function a method, bound or not, or a class
+// call runs, which takes self ahead of the parameters the caller sees.
+func methodFunction(f object.Object) (*object.CompiledFunction, bool) {
+	switch f := f.(type) {
+	case *object.Closure:
+		return f.Fn, f.Fn.Method
+	case *object.BoundMethod:
+		return f.Method.Fn, true
+	case *object.Class:
+		return f.Init.Fn, true
+	}
+	return nil, false
+}
+
+// fnArity implements fn_arity(f): the number of parameters of a script
+// function, or -1 for a builtin, which checks its own arguments.
+func fnArity(args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1", len(args))}
+	}
+	if fn, ok := methodFunction(args[0]); ok {
+		return &object.Integer{Value: int64(fn.NumParameters - 1)}
+	}
+	switch f := args[0].(type) {
+	case *object.Closure:
+		return &object.Integer{Value: int64(f.Fn.NumParameters)}
+	case *object.Builtin:
+		return &object.Integer{Value: -1}
+	}
+	return &object.Error{Message: fmt.Sprintf("argument to `fn_arity` must be a function, got %s", args[0].Type())}
+}
+
+// fnParams implements fn_params(f): the parameter names of a script
+// function in order. Builtins report an empty array.
+func fnParams(args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1", len(args))}
+	}
+	if fn, ok := methodFunction(args[0]); ok {
+		elements := make([]object.Object, fn.NumParameters-1)
+		for i := range elements {
+			elements[i] = &object.String{Value: fn.LocalNames[i+1]}
+		}
+		return &object.Array{Elements: elements}
+	}
+	switch f := args[0].(type) {
+	case *object.Closure:
+		names := f.Fn.LocalNames
+		if len(names) > f.Fn.NumParameters {
+			names = names[:f.Fn.NumParameters]
+		}
+		elements := make([]object.Object, len(names))
+		for i, name := range names {
+			elements[i] = &object.String{Value: name}
+		}
+		return &object.Array{Elements: elements}
+	case *object.Builtin:
+		return &object.Array{Elements: []object.Object{}}
+	}
+	return &object.Error{Message: fmt.Sprintf("argument to `fn_params` must be a function, got %s", args[0].Type())}
+}
+
+// hasKey implements has_key(h, k). Unlike h[k], it tells a missing key apart
+// from one mapped to null.
+func hasKey(args ...object.Object) object.Object {
+	if len(args) != 2 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=2", len(args))}
+	}
+	h, ok := args[0].(*object.Hash)
+	if !ok {
+		return &object.Error{Message: fmt.Sprintf("first argument to `has_key` must be HASH, got %s", args[0].Type())}
+	}
+	key, ok := args[1].(object.Hashable)
+	if !ok {
+		return &object.Error{Message: fmt.Sprintf("unusable as hash key: %s", args[1].Type())}
+	}
+	_, found := h.Get(key.HashKey())
+	return boolToObj(found)
+}
+
+// callWithArgs implements call(f, args): f called with the elements of the
+// args array as its arguments.
+func callWithArgs(args ...object.Object) object.Object {
+	if len(args) != 2 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=2", len(args))}
+	}
+	arr, ok := args[1].(*object.Array)
+	if !ok {
+		return &object.Error{Message: fmt.Sprintf("second argument to `call` must be ARRAY, got %s", args[1].Type())}
+	}
+	if cl, ok := args[0].(*object.Closure); ok && len(arr.Elements) != cl.Fn.NumParameters {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments: want=%d, got=%d", cl.Fn.NumParameters, len(arr.Elements))}
+	}
+	return callFunction(args[0], arr.Elements...)
+}
+
+// classOf implements class_of(x): the class x is an instance of, or null
+// for any other value.
+func classOf(args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1", len(args))}
+	}
+	if h, ok := args[0].(*object.Hash); ok && h.Class != nil {
+		return h.Class
+	}
+	return NULL
+}
@@ -2,22 +2,240 @@ package builtins
 
 import "xon/object"
 
+// BuiltinInfo documents one builtin. help, builtin_help and `xon doc
+// --builtins` all read it, so each builtin is described in one place.
+type BuiltinInfo struct {
+	Name      string
+	Signature string // call form, optional arguments marked with ?
+	Doc       string
+}
+
+// Registry lists every builtin in a stable order; an entry's index is the
+// operand of OpGetBuiltin, so new builtins are appended.
+var Registry = []BuiltinInfo{
+	{"type", "type(x)", "Returns the type name of x."},
+	{"len", "len(x)", "Returns the length of a string or array, or the rows of a numarray."},
+	{"push", "push(arr, x)", "Returns a copy of arr with x appended; arr is unchanged."},
+	{"first", "first(arr)", "Returns the first element of arr, or null when empty."},
+	{"last", "last(arr)", "Returns the last element of arr, or null when empty."},
+	{"pop", "pop(arr)", "Returns a copy of arr without its last element; arr is unchanged."},
+	{"readFile", "readFile(path)", "Returns the contents of the file at path as a string."},
+	{"writeFile", "writeFile(path, data)", "Writes the string data to the file at path, replacing it."},
+	{"toUpperCase", "toUpperCase(s)", "Returns s in upper case."},
+	{"toLowerCase", "toLowerCase(s)", "Returns s in lower case."},
+	{"now", "now()", "Returns the current Unix time in milliseconds."},
+	{"sleep", "sleep(ms, ctx?)", "Pauses the current task for ms milliseconds, or until ctx or the task is canceled."},
+	{"json_encode", "json_encode(value)", "Returns value encoded as a JSON string."},
+	{"json_decode", "json_decode(s)", "Parses the JSON string s into arrays, hashes and scalars."},
+	{"fs_remove", "fs_remove(path)", "Deletes the file or empty directory at path."},
+	{"fs_exists", "fs_exists(path)", "Reports whether path exists."},
+	{"os_mouse_move", "os_mouse_move(x, y)", "Moves the mouse cursor to screen position x, y."},
+	{"os_mouse_click", "os_mouse_click()", "Clicks the left mouse button."},
+	{"os_key_tap", "os_key_tap(vk)", "Presses and releases the key with virtual-key code vk."},
+	{"os_exec", "os_exec(cmd)", "Runs cmd through the shell (cmd /C on Windows, /bin/sh elsewhere) and returns its combined output."},
+	{"os_mouse_get_pos", "os_mouse_get_pos()", "Returns the mouse cursor position as [x, y]."},
+	{"os_alert", "os_alert(title, msg)", "Shows a message box."},
+	{"os_compile", "os_compile(script, exe, target?)", "Builds script into the standalone executable exe, a copy of this interpreter with the script appended; other targets (\"GOOS/GOARCH\") need the Go toolchain."},
+	{"os_keyboard_type", "os_keyboard_type(text)", "Types text with simulated key presses."},
+	{"math_random", "math_random(max)", "Returns a random integer in [0, max)."},
+	{"math_sqrt", "math_sqrt(n)", "Returns the square root of n."},
+	{"math_pow", "math_pow(base, exp)", "Returns base raised to exp."},
+	{"str_split", "str_split(s, sep)", "Splits s around each sep and returns the parts."},
+	{"str_contains", "str_contains(s, sub)", "Reports whether sub occurs in s."},
+	{"http_get", "http_get(url, ctx?)", "Fetches url and returns the response body; canceling ctx or the task aborts the request."},
+	{"http_serve", "http_serve(port, handler, options?)", "Serves HTTP on port; handler(req) returns the body or {status, body, headers}, and a throw is a 500. Options: access_log, metrics."},
+	{"input", "input(prompt?, options?)", "Reads a line from stdin. Options: default, hidden, number, choices."},
+	{"int", "int(x)", "Converts a number or numeric string to INTEGER."},
+	{"float", "float(x)", "Converts a number or numeric string to FLOAT."},
+	{"str", "str(x)", "Returns the printed form of x."},
+	{"bool", "bool(x)", "Returns the truthiness of x."},
+	{"typeof", "typeof(x)", "Returns the type name of x."},
+	{"copy", "copy(text)", "Puts text on the clipboard."},
+	{"paste", "paste()", "Returns the clipboard text."},
+	{"gui_run", "gui_run(config)", "Opens a native window described by config and runs it until closed."},
+	{"gui_get", "gui_get(id)", "Returns the current text of the input widget id."},
+	{"log_debug", "log_debug(msg, fields?)", "Logs msg at debug level."},
+	{"log_info", "log_info(msg, fields?)", "Logs msg at info level."},
+	{"log_warn", "log_warn(msg, fields?)", "Logs msg at warn level."},
+	{"log_error", "log_error(msg, fields?)", "Logs msg at error level."},
+	{"log_with", "log_with(fields)", "Returns a child logger that adds fields to every line."},
+	{"log_format", "log_format(format)", "Switches log output to \"text\" or \"json\" lines."},
+	{"key_pressed", "key_pressed()", "Returns the pending key without blocking, or null."},
+	{"sum", "sum(arr, selector?)", "Returns the sum of the numbers in arr."},
+	{"avg", "avg(arr, selector?)", "Returns the mean of the numbers in arr."},
+	{"median", "median(arr, selector?)", "Returns the median of the numbers in arr."},
+	{"stddev", "stddev(arr, selector?)", "Returns the population standard deviation of arr."},
+	{"percentile", "percentile(arr, p, selector?)", "Returns the p-th percentile (0-100) of arr."},
+	{"min_by", "min_by(arr, selector)", "Returns the element with the smallest selected key."},
+	{"max_by", "max_by(arr, selector)", "Returns the element with the largest selected key."},
+	{"group_by", "group_by(arr, selector)", "Returns a hash of selected key => array of elements."},
+	{"count_by", "count_by(arr, selector)", "Returns a hash of selected key => number of elements."},
+	{"numarray", "numarray(arr)", "Converts numbers to a vector, or rows of numbers to a matrix."},
+	{"num_zeros", "num_zeros(n, m?)", "Returns a zero vector of length n, or an n by m matrix."},
+	{"num_shape", "num_shape(na)", "Returns the dimensions of na as an array."},
+	{"num_to_array", "num_to_array(na)", "Converts na back to (nested) arrays of floats."},
+	{"num_sum", "num_sum(na)", "Returns the sum of all elements of na."},
+	{"num_dot", "num_dot(a, b)", "Returns the dot product of two vectors."},
+	{"num_matmul", "num_matmul(a, b)", "Multiplies matrix a by a matrix or vector b."},
+	{"num_transpose", "num_transpose(m)", "Returns the transpose of matrix m."},
+	{"num_format", "num_format(n, options?)", "Formats n with thousands separators. Options: decimals, thousands, decimal."},
+	{"currency_format", "currency_format(n, code)", "Formats n as an amount of currency code (USD, EUR, GBP, JPY, CHF)."},
+	{"seed", "seed(n)", "Reseeds the random generator for a reproducible sequence."},
+	{"random_choice", "random_choice(arr)", "Returns a random element of arr, or null when empty."},
+	{"random_shuffle", "random_shuffle(arr)", "Returns a shuffled copy of arr."},
+	{"random_range", "random_range(min, max)", "Returns a random INTEGER in [min, max], or FLOAT in [min, max)."},
+	{"weighted_choice", "weighted_choice(pairs)", "Picks a value with probability proportional to its weight."},
+	{"memoize", "memoize(f)", "Returns f with results cached per argument list."},
+	{"cache_new", "cache_new(ttl_ms)", "Returns a cache with get, set, has, delete, clear and size."},
+	{"emitter_new", "emitter_new()", "Returns an event emitter with on, once, off, emit and count."},
+	{"fsm_new", "fsm_new(spec)", "Returns a state machine with state, can, allowed, go and history."},
+	{"fs_lock", "fs_lock(path, options?)", "Takes an exclusive OS lock on path, waiting while another task or process holds it. Options: timeout, wait. Locking a path the same task holds is an error."},
+	{"fs_unlock", "fs_unlock(path)", "Releases a lock taken with fs_lock."},
+	{"fs_sha256", "fs_sha256(path)", "Returns the hex SHA-256 digest of the file at path."},
+	{"fs_compare", "fs_compare(a, b)", "Reports whether two files have identical contents."},
+	{"fs_sync", "fs_sync(src, dst, options?)", "Mirrors changed files from src to dst. Options: compare, delete, exclude, dry_run."},
+	{"push_mut", "push_mut(arr, x...)", "Appends the values to arr in place and returns it."},
+	{"pop_mut", "pop_mut(arr)", "Removes and returns the last element of arr."},
+	{"freeze", "freeze(x)", "Makes an array or hash, and everything inside it, read-only."},
+	{"is_frozen", "is_frozen(x)", "Reports whether x is a frozen array or hash."},
+	{"is_null", "is_null(x)", "Reports whether x is null."},
+	{"ifnull", "ifnull(x, fallback)", "Returns fallback when x is null, otherwise x."},
+	{"ord", "ord(ch)", "Returns the code point of a one-character string."},
+	{"chr", "chr(n)", "Returns the one-character string for code point n."},
+	{"bytes", "bytes(s)", "Returns the UTF-8 bytes of s as integers."},
+	{"str_from_bytes", "str_from_bytes(arr)", "Decodes an array of UTF-8 bytes to a string."},
+	{"pretty", "pretty(value, depth?)", "Returns value rendered over indented lines."},
+	{"help", "help(name?)", "Prints the overview, or the docs of a builtin, function or global."},
+	{"builtins_list", "builtins_list()", "Returns the names of all builtins."},
+	{"builtin_help", "builtin_help(name)", "Returns {name, signature, doc} for a builtin, or null."},
+	{"argparse", "argparse(flags, options?)", "Parses the script's arguments into {options, positionals}; handles --help."},
+	{"globals", "globals()", "Returns a hash of every assigned global, standard library included."},
+	{"locals", "locals()", "Returns a hash of the calling function's parameters and locals."},
+	{"vm_stats", "vm_stats()", "Returns instructions executed, frame depth, stack use and allocations."},
+	{"mem_usage", "mem_usage()", "Returns process heap figures in bytes, GC count and goroutines."},
+	{"fn_arity", "fn_arity(f)", "Returns the number of parameters of f, or -1 for a builtin. For a class, the number its init takes."},
+	{"fn_params", "fn_params(f)", "Returns the parameter names of f."},
+	{"has_key", "has_key(h, k)", "Reports whether hash h contains key k, even if it maps to null."},
+	{"call", "call(f, args)", "Calls f with the elements of the array args as arguments."},
+	{"to_int", "to_int(x)", "Converts a float (truncating), numeric string or boolean to INTEGER; errors otherwise."},
+	{"to_float", "to_float(x)", "Converts an integer, numeric string or boolean to FLOAT; errors otherwise."},
+	{"to_str", "to_str(x)", "Returns x as text, the same text string concatenation uses."},
+	{"to_bool", "to_bool(x)", "Parses true/false/1/0/yes/no strings; numbers are true when non-zero, null is false."},
+	{"try_int", "try_int(x, default?)", "Like to_int, but returns default (or null) instead of an error."},
+	{"try_float", "try_float(x, default?)", "Like to_float, but returns default (or null) instead of an error."},
+	{"ctx_new", "ctx_new(options?)", "Returns a cancellable context. Options: parent, timeout (ms), interrupt (cancel on Ctrl+C)."},
+	{"ctx_cancel", "ctx_cancel(ctx)", "Cancels ctx and the contexts made from it; tasks spawned with it stop."},
+	{"ctx_done", "ctx_done(ctx)", "Returns true once ctx was canceled or timed out."},
+	{"with_timeout", "with_timeout(ms, f)", "Runs f (or f(ctx)) and returns its result, or an error if it takes longer than ms."},
+	{"metric_counter", "metric_counter(name, help?)", "Returns the counter called name, with inc(n?) and value()."},
+	{"metric_gauge", "metric_gauge(name, help?)", "Returns the gauge called name, with set(v), inc(n?), dec(n?) and value()."},
+	{"metric_histogram", "metric_histogram(name, options?)", "Returns the histogram called name, with observe(v), count() and sum(). Options: help, buckets."},
+	{"metrics_text", "metrics_text()", "Returns every metric in the Prometheus text format; http_serve's metrics endpoint includes it."},
+	{"metrics_statsd", "metrics_statsd(addr, options?)", "Also sends every metric update over UDP to the statsd server at addr; null stops. Options: prefix."},
+	{"browser_open", "browser_open(options?)", "Starts Chrome or Chromium and returns a browser. Options: path, headless (default true), timeout (ms, default 10000)."},
+	{"browser_goto", "browser_goto(b, url)", "Loads url in the browser and waits for the page to load."},
+	{"browser_click", "browser_click(b, selector)", "Waits for the element matching the CSS selector and clicks it with the mouse."},
+	{"browser_type", "browser_type(b, selector, text)", "Focuses the element matching selector and types text into it."},
+	{"browser_text", "browser_text(b, selector)", "Returns the rendered text of the element matching selector, waiting for it to appear."},
+	{"browser_screenshot", "browser_screenshot(b, path)", "Saves the visible page as a PNG file."},
+	{"browser_close", "browser_close(b)", "Closes the browser."},
+	{"html_parse", "html_parse(s)", "Parses the HTML in s, however sloppy, and returns the document node."},
+	{"html_select", "html_select(node, selector)", "Returns the elements under node (or in an HTML string) matching the CSS selector, in document order."},
+	{"html_text", "html_text(node)", "Returns the text of node without tags, scripts or styles, with whitespace collapsed."},
+	{"html_attr", "html_attr(node, name)", "Returns the value of the element's attribute name, or null."},
+	{"git_clone", "git_clone(url, dir, options?)", "Clones url into dir and returns {dir, head}. Options: branch, depth."},
+	{"git_pull", "git_pull(dir, options?)", "Fast-forwards the repository in dir and returns {updated, head}. Options: remote, branch."},
+	{"git_commit", "git_commit(dir, message, options?)", "Stages all changes and commits them, returning the new hash. Options: all (false: staged only), author, allow_empty."},
+	{"git_push", "git_push(dir, options?)", "Pushes the current branch. Options: remote, branch, set_upstream, tags, force (with lease)."},
+	{"git_status", "git_status(dir)", "Returns {branch, upstream, ahead, behind, clean, files}; each file is {path, index, worktree, from?}."},
+	{"docker_ps", "docker_ps(options?)", "Lists containers as {id, name, image, state, status}. Options: all (include stopped ones)."},
+	{"docker_run", "docker_run(image, options?)", "Creates and starts a container, pulling the image if needed, and returns {id, name}. Options: name, cmd, env, ports, volumes, remove."},
+	{"docker_stop", "docker_stop(id, options?)", "Stops a container by id or name. Options: timeout (seconds before it is killed)."},
+	{"docker_logs", "docker_logs(id, options?)", "Returns a container's stdout and stderr as one string. Options: tail (last n lines)."},
+	{"xlsx_read", "xlsx_read(path, options?)", "Reads a workbook as {sheet: rows}, each row a hash keyed by the header row. Options: sheet (return just its rows), header (false: rows as arrays)."},
+	{"xlsx_write", "xlsx_write(path, data, options?)", "Writes rows (hashes or arrays), or {sheet: rows}, with a bold frozen header and fitted columns. Options: columns, sheet."},
+	{"tts_speak", "tts_speak(text, options?)", "Speaks text aloud with the system speech engine. Options: voice, rate (1 is normal), wait (false: return at once)."},
+	{"os_ui_find", "os_ui_find(criteria)", "Finds controls through the accessibility API. Criteria: name, contains, role, id, window, limit. Returns [{name, role, id, class, x, y, width, height, enabled}]."},
+	{"os_ui_invoke", "os_ui_invoke(criteria)", "Presses, toggles, selects or expands the first control matching criteria (index picks another match)."},
+	{"os_ui_get_text", "os_ui_get_text(criteria)", "Returns the value or text of the first control matching criteria (index picks another match)."},
+	{"range", "range(start?, end, step?)", "Returns the integers from start (default 0) up to, not including, end, counting by step. Like start..end, the range is lazy: for-in, len and indexing read it without building an array."},
+	{"os_record_start", "os_record_start(options?)", "Starts recording keyboard and mouse input (Windows). Options: mouse_moves (false: only keys, clicks and the wheel)."},
+	{"os_record_stop", "os_record_stop()", "Stops recording and returns the events: {type, time, key} for key_down/key_up, {type, time, x, y} for mouse_move, plus button for mouse_down/mouse_up and delta for wheel."},
+	{"os_replay", "os_replay(events, speed?)", "Sends recorded (or edited) events again with their original timing divided by speed. Every event is checked before any is sent."},
+	{"os_on_key", "os_on_key(fn)", "Calls fn({type, key, char}) for every key pressed or released anywhere (Windows), on a task of its own. Returns a function that removes the hook."},
+	{"os_on_mouse", "os_on_mouse(fn)", "Calls fn({type, x, y, button?, delta?}) for every mouse move, click and wheel turn anywhere (Windows). Returns a function that removes the hook."},
+	{"os_pick_point", "os_pick_point(options?)", "Waits for the user to left-click and returns {x, y} (Windows); the click does not reach the window under it. Escape or the timeout option (ms) returns null."},
+	{"os_pick_region", "os_pick_region(options?)", "Waits for the user to drag with the left button and returns {x, y, width, height} (Windows). Escape or the timeout option (ms) returns null."},
+	{"battery_status", "battery_status()", "Returns {present, percent, charging, plugged, seconds_left}; percent and seconds_left are -1 when unknown."},
+	{"display_list", "display_list()", "Returns the monitors as [{name, x, y, width, height, primary}] in the coordinates os_mouse_move uses (xrandr on Linux)."},
+	{"display_set_brightness", "display_set_brightness(percent, name?)", "Sets brightness on the named display or all of them: the built-in panel through WMI on Windows, brightnessctl or xrandr on Linux."},
+	{"net_interfaces", "net_interfaces()", "Lists network interfaces as {name, mac, up, loopback, mtu, addresses}, each address in CIDR form."},
+	{"wifi_list", "wifi_list()", "Lists Wi-Fi networks in range, strongest first, as {ssid, signal, security, connected}; uses nmcli on Linux and netsh on Windows."},
+	{"wifi_connect", "wifi_connect(ssid, pass?)", "Joins a Wi-Fi network with a WPA passphrase, or an open one without; the network is saved and rejoined later."},
+	{"os_schedule_install", "os_schedule_install(name, cron, scriptPath)", "Runs the script on a cron schedule (\"30 2 * * *\", \"@hourly\"), through the user's crontab on Linux and Task Scheduler on Windows; replaces a job of the same name."},
+	{"os_schedule_remove", "os_schedule_remove(name)", "Removes a job installed with os_schedule_install."},
+	{"error", "error(msg, code?, data?)", "Makes an error value to throw; a catch block reads e.message, e.code, e.data (a hash) and e.stack (the functions active at the throw)."},
+	{"secret_get", "secret_get(name)", "Returns the secret stored under name in the OS keychain, or null if there is none."},
+	{"secret_set", "secret_set(name, value)", "Stores a secret in the OS keychain (DPAPI, Keychain or libsecret), replacing any earlier one; a null value deletes it."},
+	{"config_load", "config_load(defaults, options?)", "Merges defaults, a JSON or TOML file, .env, environment variables and --flags (in rising precedence) into one hash, converting text to each default's type. Options: file, dotenv, env_prefix, args."},
+	{"class_of", "class_of(x)", "Returns the class x is an instance of, or null when x is not an instance."},
+	{"color_parse", "color_parse(c)", "Parses #rgb, #rrggbb, #rrggbbaa, rgb()/rgba() text, a color name, an [r, g, b, a?] array or an {r, g, b, a?} hash into {r, g, b, a}."},
+	{"color_hex", "color_hex(c)", "Formats a color as #rrggbb, or #rrggbbaa when it is not opaque."},
+	{"rgb_to_hsl", "rgb_to_hsl(c)", "Converts a color to {h, s, l, a}: h in degrees, s and l in 0-1."},
+	{"hsl_to_rgb", "hsl_to_rgb(h, s, l)", "Converts hue in degrees and saturation and lightness in 0-1 (or an {h, s, l, a?} hash) to {r, g, b, a}."},
+	{"color_contrast", "color_contrast(a, b)", "Returns the WCAG contrast ratio of two colors, from 1 to 21; body text needs 4.5."},
+	{"color_nearest", "color_nearest(c, palette)", "Returns the palette color closest to c; for a hash palette, the name of that color."},
+	{"on_frame", "on_frame(fn, fps?)", "Has the GUI canvas call fn(dt) fps times a second (60 by default), dt being the seconds since the last frame; null stops it."},
+	{"frame_step", "frame_step(dt, options?)", "Runs one on_frame frame without a window and returns its draw commands. Options: keys (held during the frame), png, width, height."},
+	{"draw_clear", "draw_clear(color)", "Fills the canvas with color; call it inside an on_frame function, like the other draw_ functions."},
+	{"draw_rect", "draw_rect(x, y, w, h, color)", "Fills a rectangle on the canvas."},
+	{"draw_circle", "draw_circle(x, y, r, color)", "Fills a circle centred on x, y."},
+	{"draw_line", "draw_line(x, y, x2, y2, color)", "Draws a one-pixel line."},
+	{"draw_text", "draw_text(x, y, text, color?)", "Writes text in the system font, white by default, above the frame's shapes and sprites."},
+	{"draw_sprite", "draw_sprite(s, x, y, scale?)", "Draws sprite s with its top left at x, y, each pixel scale pixels wide."},
+	{"sprite_load", "sprite_load(path)", "Loads a PNG, GIF or JPEG file as a sprite."},
+	{"sprite_from", "sprite_from(rows, palette)", "Makes a sprite from equal-length strings, each character a color from palette; . and space are transparent."},
+	{"sprite_size", "sprite_size(s)", "Returns [width, height] of sprite s."},
+	{"key_down", "key_down(name)", "Reports whether a key is held on the GUI canvas: a letter, a digit, left, right, up, down, space, enter, escape, shift, ctrl, tab or backspace."},
+	{"key_hit", "key_hit(name)", "Reports whether a key went down since the previous frame."},
+	{"audio_devices", "audio_devices()", "Lists sound inputs and outputs as {id, name, kind, default}."},
+	{"audio_record", "audio_record(seconds, path, options?)", "Records mono WAV audio from the default input, or options.device, for the given seconds."},
+	{"audio_play", "audio_play(path, options?)", "Plays a sound file. Options: device, wait (false: return at once)."},
+	{"volume_get", "volume_get()", "Returns the default output's volume in percent."},
+	{"volume_set", "volume_set(percent)", "Sets the default output's volume in percent."},
+	{"volume_mute", "volume_mute(on?)", "Mutes the default output, or unmutes it with false."},
+	{"queue_push", "queue_push(name, payload, options?)", "Adds a job to a durable queue and returns its id. Options: max_attempts (3), delay (ms)."},
+	{"queue_pop", "queue_pop(name, options?)", "Takes the oldest ready job as {id, receipt, payload, attempts}, or null; it comes back unless acknowledged within the visibility option (ms, 30000)."},
+	{"queue_ack", "queue_ack(name, receipt)", "Marks a popped job done and removes it; false if the receipt is stale."},
+	{"queue_fail", "queue_fail(name, receipt, error?)", "Returns a popped job for a retry, or moves it to the dead-letter list when out of attempts; true if it will be retried."},
+	{"queue_stats", "queue_stats(name)", "Returns {pending, in_flight, dead} counts for a queue."},
+	{"queue_dead", "queue_dead(name)", "Lists the dead-letter jobs with the error of their last attempt."},
+	{"queue_redrive", "queue_redrive(name)", "Moves every dead-letter job back onto the queue and returns how many."},
+	{"test_http_server", "test_http_server(routes)", "Starts a local server answering routes (\"/path\" or \"GET /path\" => body, {status, body, headers} or fn(req)) and returns its URL."},
+	{"expect_snapshot", "expect_snapshot(name, value)", "Compares value with the golden file __snapshots__/name.snap, writing it on first use or with --update-snapshots."},
+}
+
 // BuiltinNames returns all builtin function names in a stable order.
-var BuiltinNames = []string{
-	"type", "len", "push", "first", "last", "pop",
-	"readFile", "writeFile",
-	"toUpperCase", "toLowerCase",
-	"now", "sleep",
-	"json_encode", "json_decode",
-	"fs_remove", "fs_exists",
-	"os_mouse_move", "os_mouse_click", "os_key_tap", "os_exec",
-	"os_mouse_get_pos", "os_alert", "os_compile", "os_keyboard_type",
-	"math_random", "math_sqrt", "math_pow",
-	"str_split", "str_contains",
-	"http_get", "http_serve",
-	"input", "int", "float", "str", "bool", "typeof",
-	"copy", "paste",
-	"gui_run", "gui_get",
+var BuiltinNames = registryNames()
+
+var registryIndex = make(map[string]int)
+
+func registryNames() []string {
+	names := make([]string, len(Registry))
+	for i, info := range Registry {
+		names[i] = info.Name
+		registryIndex[info.Name] = i
+	}
+	return names
+}
+
+// LookupInfo returns the documentation of the named builtin.
+func LookupInfo(name string) (BuiltinInfo, bool) {
+	i, ok := registryIndex[name]
+	if !ok {
+		return BuiltinInfo{}, false
+	}
+	return Registry[i], true
 }
 
 // GetBuiltinByName returns a builtin function by name.
@@ -29,6 +247,15 @@ func GetBuiltinByName(name string) *object.Builtin {
 	return b
 }
 
+// BuiltinName returns the name b is registered under, or "" for builtins
+// made by other builtins, such as an emitter's on.
+func BuiltinName(b *object.Builtin) string {
+	if info, ok := builtinInfoFor(b); ok {
+		return info.Name
+	}
+	return ""
+}
+
 // GetBuiltinByIndex returns a builtin by its index in BuiltinNames.
 func GetBuiltinByIndex(index int) *object.Builtin {
 	if index < 0 || index >= len(BuiltinNames) {
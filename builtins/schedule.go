@@ -0,0 +1,262 @@
+// Scheduling - installing scripts as recurring jobs with cron or Task Scheduler
+
+package builtins
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"xon/object"
+)
+
+func init() {
+	builtinsMap["os_schedule_install"] = &object.Builtin{Fn: scheduleInstallBuiltin}
+	builtinsMap["os_schedule_remove"] = &object.Builtin{Fn: scheduleRemoveBuiltin}
+}
+
+// scheduleInstallBuiltin registers this interpreter running scriptPath on
+// the cron schedule, replacing any job of the same name.
+func scheduleInstallBuiltin(args ...object.Object) object.Object {
+	if len(args) != 3 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=3", len(args))}
+	}
+	strs := make([]string, 3)
+	for i, arg := range args {
+		s, ok := arg.(*object.String)
+		if !ok {
+			return &object.Error{Message: fmt.Sprintf("os_schedule_install: arguments must be STRING, got %s", arg.Type())}
+		}
+		strs[i] = s.Value
+	}
+	name, spec, script := strs[0], strs[1], strs[2]
+	if err := checkScheduleName(name); err != nil {
+		return &object.Error{Message: "os_schedule_install: " + err.Error()}
+	}
+	fields, err := parseCron(spec)
+	if err != nil {
+		return &object.Error{Message: "os_schedule_install: " + err.Error()}
+	}
+	script, err = filepath.Abs(script)
+	if err == nil {
+		_, err = os.Stat(script)
+	}
+	if err != nil {
+		return &object.Error{Message: "os_schedule_install: " + err.Error()}
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return &object.Error{Message: "os_schedule_install: " + err.Error()}
+	}
+	if err := scheduleInstall(name, fields, []string{exe, script}); err != nil {
+		return &object.Error{Message: "os_schedule_install: " + err.Error()}
+	}
+	return TRUE
+}
+
+func scheduleRemoveBuiltin(args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1", len(args))}
+	}
+	name, ok := args[0].(*object.String)
+	if !ok {
+		return &object.Error{Message: fmt.Sprintf("os_schedule_remove: name must be STRING, got %s", args[0].Type())}
+	}
+	if err := checkScheduleName(name.Value); err != nil {
+		return &object.Error{Message: "os_schedule_remove: " + err.Error()}
+	}
+	if err := scheduleRemove(name.Value); err != nil {
+		return &object.Error{Message: "os_schedule_remove: " + err.Error()}
+	}
+	return TRUE
+}
+
+// checkScheduleName keeps job names to characters that need no quoting in
+// a crontab comment or a Task Scheduler path.
+func checkScheduleName(name string) error {
+	if name == "" {
+		return fmt.Errorf("name is empty")
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return fmt.Errorf("name %q may only contain letters, digits, - and _", name)
+		}
+	}
+	return nil
+}
+
+var cronMacros = map[string]string{
+	"@hourly":   "0 * * * *",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@weekly":   "0 0 * * 0",
+	"@monthly":  "0 0 1 * *",
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+}
+
+var cronFields = []struct {
+	name     string
+	min, max int
+}{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+// parseCron checks a five-field cron expression (minute, hour, day of
+// month, month, day of week) or an @daily-style macro and returns its
+// fields. Each field is *, a number, a range a-b, any of those with /step,
+// or a comma list of them.
+func parseCron(spec string) ([]string, error) {
+	if macro, ok := cronMacros[strings.TrimSpace(spec)]; ok {
+		spec = macro
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression %q needs 5 fields (minute hour day month weekday), got %d", spec, len(fields))
+	}
+	for i, field := range fields {
+		f := cronFields[i]
+		for _, part := range strings.Split(field, ",") {
+			rng, step, hasStep := strings.Cut(part, "/")
+			if hasStep {
+				if n, err := strconv.Atoi(step); err != nil || n < 1 {
+					return nil, fmt.Errorf("bad step %q in cron %s field %q", step, f.name, field)
+				}
+			}
+			if rng == "*" {
+				continue
+			}
+			lo, hi, isRange := strings.Cut(rng, "-")
+			if !isRange {
+				hi = lo
+			}
+			a, err1 := strconv.Atoi(lo)
+			b, err2 := strconv.Atoi(hi)
+			if err1 != nil || err2 != nil || a < f.min || b > f.max || a > b {
+				return nil, fmt.Errorf("cron %s field %q must be within %d-%d", f.name, field, f.min, f.max)
+			}
+		}
+	}
+	return fields, nil
+}
+
+// crontabLine is the crontab entry for a job, tagged with its name so it
+// can be found again. % ends a crontab command, so it is escaped.
+func crontabLine(name string, fields, command []string) string {
+	quoted := make([]string, len(command))
+	for i, arg := range command {
+		quoted[i] = "'" + strings.ReplaceAll(strings.ReplaceAll(arg, "'", `'\''`), "%", `\%`) + "'"
+	}
+	return strings.Join(fields, " ") + " " + strings.Join(quoted, " ") + " " + crontabTag(name)
+}
+
+func crontabTag(name string) string {
+	return "# xon:" + name
+}
+
+// withoutCrontabJob drops the entry tagged with name from a crontab and
+// reports whether there was one.
+func withoutCrontabJob(crontab, name string) (string, bool) {
+	var kept []string
+	found := false
+	for _, line := range strings.Split(strings.TrimRight(crontab, "\n"), "\n") {
+		if strings.HasSuffix(line, " "+crontabTag(name)) {
+			found = true
+			continue
+		}
+		if line != "" || len(kept) > 0 {
+			kept = append(kept, line)
+		}
+	}
+	if len(kept) == 0 {
+		return "", found
+	}
+	return strings.Join(kept, "\n") + "\n", found
+}
+
+var schtasksDays = []string{"SUN", "MON", "TUE", "WED", "THU", "FRI", "SAT", "SUN"}
+
+var schtasksMonths = []string{"", "JAN", "FEB", "MAR", "APR", "MAY", "JUN", "JUL", "AUG", "SEP", "OCT", "NOV", "DEC"}
+
+// schtasksTrigger turns cron fields into schtasks /Create schedule
+// arguments. Task Scheduler triggers cover the common shapes (every n
+// minutes, hourly, daily, on some weekdays, on a day of some months) but
+// not every cron expression.
+func schtasksTrigger(fields []string) ([]string, error) {
+	minute, hour, dom, month, dow := fields[0], fields[1], fields[2], fields[3], fields[4]
+	single := func(s string) (int, bool) {
+		n, err := strconv.Atoi(s)
+		return n, err == nil
+	}
+	every := func(s string) (int, bool) {
+		if s == "*" {
+			return 1, true
+		}
+		if step, ok := strings.CutPrefix(s, "*/"); ok {
+			return single(step)
+		}
+		return 0, false
+	}
+	list := func(s string, names []string) (string, bool) {
+		var out []string
+		for _, part := range strings.Split(s, ",") {
+			n, ok := single(part)
+			if !ok {
+				return "", false
+			}
+			out = append(out, names[n])
+		}
+		return strings.Join(out, ","), true
+	}
+	fail := fmt.Errorf("cron expression %q has no Task Scheduler equivalent", strings.Join(fields, " "))
+
+	if n, ok := every(minute); ok {
+		if hour != "*" || dom != "*" || month != "*" || dow != "*" {
+			return nil, fail
+		}
+		return []string{"/SC", "MINUTE", "/MO", strconv.Itoa(n)}, nil
+	}
+	m, ok := single(minute)
+	if !ok {
+		return nil, fail
+	}
+	if n, ok := every(hour); ok {
+		if dom != "*" || month != "*" || dow != "*" {
+			return nil, fail
+		}
+		return []string{"/SC", "HOURLY", "/MO", strconv.Itoa(n), "/ST", fmt.Sprintf("00:%02d", m)}, nil
+	}
+	h, ok := single(hour)
+	if !ok {
+		return nil, fail
+	}
+	start := fmt.Sprintf("%02d:%02d", h, m)
+	switch {
+	case dom == "*" && month == "*" && dow == "*":
+		return []string{"/SC", "DAILY", "/ST", start}, nil
+	case dom == "*" && month == "*":
+		if days, ok := list(dow, schtasksDays); ok {
+			return []string{"/SC", "WEEKLY", "/D", days, "/ST", start}, nil
+		}
+	case dow == "*":
+		day, ok := single(dom)
+		if !ok {
+			break
+		}
+		args := []string{"/SC", "MONTHLY", "/D", strconv.Itoa(day)}
+		if month != "*" {
+			months, ok := list(month, schtasksMonths)
+			if !ok {
+				break
+			}
+			args = append(args, "/M", months)
+		}
+		return append(args, "/ST", start), nil
+	}
+	return nil, fail
+}
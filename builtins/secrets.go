@@ -0,0 +1,68 @@
+// Secrets - secret_get/secret_set: API tokens and passwords kept in the OS keychain instead of in scripts
+
+package builtins
+
+import (
+	"fmt"
+	"xon/object"
+)
+
+// secretService is the service (macOS, libsecret) or folder (Windows) the
+// secrets are filed under, keeping them apart from other programs' items.
+const secretService = "xon"
+
+func init() {
+	builtinsMap["secret_get"] = &object.Builtin{Fn: secretGetBuiltin}
+	builtinsMap["secret_set"] = &object.Builtin{Fn: secretSetBuiltin}
+}
+
+// secretGetBuiltin returns the stored secret, or null when there is none,
+// so a script can fall back with ??.
+func secretGetBuiltin(args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1", len(args))}
+	}
+	name, ok := args[0].(*object.String)
+	if !ok {
+		return &object.Error{Message: fmt.Sprintf("secret_get: name must be STRING, got %s", args[0].Type())}
+	}
+	if err := checkScheduleName(name.Value); err != nil {
+		return &object.Error{Message: "secret_get: " + err.Error()}
+	}
+	value, found, err := secretGet(name.Value)
+	if err != nil {
+		return &object.Error{Message: "secret_get: " + err.Error()}
+	}
+	if !found {
+		return NULL
+	}
+	return &object.String{Value: value}
+}
+
+// secretSetBuiltin stores value under name, replacing any earlier value;
+// a null value deletes the secret.
+func secretSetBuiltin(args ...object.Object) object.Object {
+	if len(args) != 2 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=2", len(args))}
+	}
+	name, ok := args[0].(*object.String)
+	if !ok {
+		return &object.Error{Message: fmt.Sprintf("secret_set: name must be STRING, got %s", args[0].Type())}
+	}
+	if err := checkScheduleName(name.Value); err != nil {
+		return &object.Error{Message: "secret_set: " + err.Error()}
+	}
+	var err error
+	switch value := args[1].(type) {
+	case *object.String:
+		err = secretSet(name.Value, value.Value)
+	case *object.Null:
+		err = secretDelete(name.Value)
+	default:
+		return &object.Error{Message: fmt.Sprintf("secret_set: value must be STRING or null, got %s", args[1].Type())}
+	}
+	if err != nil {
+		return &object.Error{Message: "secret_set: " + err.Error()}
+	}
+	return TRUE
+}
@@ -0,0 +1,175 @@
+// Services - `xon service`: scripts kept running by systemd or the Windows service manager
+
+package builtins
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ServiceOptions configures `xon service install`.
+type ServiceOptions struct {
+	Name string // defaults to the script's file name without .xn
+	Log  string // file the script's output is appended to; defaults to NAME.log beside the script
+	User bool   // install a systemd user unit rather than a system one; not used on Windows
+}
+
+// serviceRestartDelay is how long a service waits before running its
+// script again after it exits.
+const serviceRestartDelay = 5 * time.Second
+
+// serviceUnitName is the name systemd or Windows knows the service by.
+func serviceUnitName(name string) string {
+	return "xon-" + name
+}
+
+// resolveService fills in the defaults of opts and makes the script and
+// log paths absolute, since services do not start in the caller's directory.
+func resolveService(script string, opts ServiceOptions) (string, ServiceOptions, error) {
+	script, err := filepath.Abs(script)
+	if err != nil {
+		return "", opts, err
+	}
+	if _, err := os.Stat(script); err != nil {
+		return "", opts, err
+	}
+	if opts.Name == "" {
+		opts.Name = strings.TrimSuffix(filepath.Base(script), ".xn")
+	}
+	if err := checkScheduleName(opts.Name); err != nil {
+		return "", opts, err
+	}
+	if opts.Log == "" {
+		opts.Log = filepath.Join(filepath.Dir(script), opts.Name+".log")
+	}
+	if opts.Log, err = filepath.Abs(opts.Log); err != nil {
+		return "", opts, err
+	}
+	return script, opts, nil
+}
+
+// InstallService registers the script as a service that starts at boot
+// and is restarted whenever it exits, and returns the service's name. It
+// does not start it.
+func InstallService(script string, opts ServiceOptions) (string, error) {
+	script, opts, err := resolveService(script, opts)
+	if err != nil {
+		return "", err
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	return opts.Name, serviceInstall(script, opts, exe)
+}
+
+func StartService(name string) error {
+	if err := checkScheduleName(name); err != nil {
+		return err
+	}
+	return serviceControl(name, "start")
+}
+
+func StopService(name string) error {
+	if err := checkScheduleName(name); err != nil {
+		return err
+	}
+	return serviceControl(name, "stop")
+}
+
+// RemoveService stops the service if it is running and unregisters it.
+func RemoveService(name string) error {
+	if err := checkScheduleName(name); err != nil {
+		return err
+	}
+	return serviceRemove(name)
+}
+
+// RunService is the process the Windows service manager starts: it runs
+// the script until the service is stopped.
+func RunService(script string, opts ServiceOptions) error {
+	script, opts, err := resolveService(script, opts)
+	if err != nil {
+		return err
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	return serviceHost(opts.Name, func(stop <-chan struct{}) error {
+		return superviseScript([]string{exe, script}, filepath.Dir(script), opts.Log, stop)
+	})
+}
+
+// superviseScript runs command in dir with its output appended to the log,
+// starting it again serviceRestartDelay after it exits, until stop is
+// closed.
+func superviseScript(command []string, dir, logPath string, stop <-chan struct{}) error {
+	for {
+		log, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		cmd := exec.Command(command[0], command[1:]...)
+		cmd.Dir = dir
+		cmd.Stdout = log
+		cmd.Stderr = log
+		if err = cmd.Start(); err == nil {
+			done := make(chan error, 1)
+			go func() { done <- cmd.Wait() }()
+			select {
+			case err = <-done:
+			case <-stop:
+				cmd.Process.Kill()
+				<-done
+				log.Close()
+				return nil
+			}
+		}
+		status := "exited"
+		if err != nil {
+			status = err.Error()
+		}
+		fmt.Fprintf(log, "%s xon service: script %s, restarting in %s\n", time.Now().Format(time.RFC3339), status, serviceRestartDelay)
+		log.Close()
+		select {
+		case <-stop:
+			return nil
+		case <-time.After(serviceRestartDelay):
+		}
+	}
+}
+
+// systemdUnit is the unit file for a service running script. systemd does
+// the restarting and the log redirection itself.
+func systemdUnit(script string, opts ServiceOptions, exe string) string {
+	// % starts a specifier in any setting; ExecStart also expands $ and
+	// takes quoted words.
+	spec := func(s string) string { return strings.ReplaceAll(s, "%", "%%") }
+	quote := func(s string) string {
+		return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "%", "%%", "$", "$$").Replace(s) + `"`
+	}
+	target := "multi-user.target"
+	if opts.User {
+		target = "default.target"
+	}
+	return fmt.Sprintf(`[Unit]
+Description=Xon script %s
+After=network.target
+
+[Service]
+ExecStart=%s %s
+WorkingDirectory=%s
+Restart=always
+RestartSec=%d
+StandardOutput=append:%s
+StandardError=append:%s
+
+[Install]
+WantedBy=%s
+`, spec(script), quote(exe), quote(script), spec(filepath.Dir(script)), int(serviceRestartDelay.Seconds()), spec(opts.Log), spec(opts.Log), target)
+}
@@ -0,0 +1,84 @@
+// Snapshots - golden-file checks for large structured outputs
+
+package builtins
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"xon/object"
+)
+
+// SnapshotDir is where expect_snapshot keeps its files; main points it at
+// __snapshots__ next to the script.
+var SnapshotDir = "__snapshots__"
+
+// UpdateSnapshots makes expect_snapshot rewrite files instead of comparing
+// against them; main sets it for --update-snapshots.
+var UpdateSnapshots bool
+
+// snapshotFormat prints every element and level, so nothing a test cares
+// about is cut off.
+var snapshotFormat = object.PrettyOptions{Width: 80}
+
+func init() {
+	builtinsMap["expect_snapshot"] = &object.Builtin{Fn: expectSnapshot}
+}
+
+// expectSnapshot implements expect_snapshot(name, value). value is printed
+// as by pretty(), untruncated, and compared with SnapshotDir/name.snap. The
+// first run, or any run with --update-snapshots, writes the file and
+// returns true. Later runs return true when the output matches and
+// otherwise stop the script with an error naming the first differing line,
+// so a bare expect_snapshot(...) statement still fails.
+func expectSnapshot(args ...object.Object) object.Object {
+	if len(args) != 2 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=2", len(args))}
+	}
+	name, ok := args[0].(*object.String)
+	if !ok {
+		return &object.Error{Message: fmt.Sprintf("first argument to `expect_snapshot` must be STRING, got %s", args[0].Type())}
+	}
+	if name.Value == "" || strings.ContainsAny(name.Value, `/\`) || name.Value == "." || name.Value == ".." {
+		return &object.Error{Message: fmt.Sprintf("invalid snapshot name %q", name.Value)}
+	}
+	got := object.Pretty(args[1], snapshotFormat) + "\n"
+	path := filepath.Join(SnapshotDir, name.Value+".snap")
+
+	want, err := os.ReadFile(path)
+	if UpdateSnapshots || os.IsNotExist(err) {
+		if err := os.MkdirAll(SnapshotDir, 0755); err != nil {
+			return &object.Error{Message: err.Error()}
+		}
+		if err := os.WriteFile(path, []byte(got), 0644); err != nil {
+			return &object.Error{Message: err.Error()}
+		}
+		return TRUE
+	}
+	if err != nil {
+		return &object.Error{Message: err.Error()}
+	}
+	if string(want) == got {
+		return TRUE
+	}
+	return &object.Error{Message: snapshotMismatch(name.Value, string(want), got), Raise: true}
+}
+
+// snapshotMismatch describes the first line where got and want differ.
+func snapshotMismatch(name, want, got string) string {
+	wantLines := strings.Split(want, "\n")
+	gotLines := strings.Split(got, "\n")
+	for i := 0; ; i++ {
+		var w, g string
+		if i < len(wantLines) {
+			w = wantLines[i]
+		}
+		if i < len(gotLines) {
+			g = gotLines[i]
+		}
+		if w != g || i >= len(wantLines) || i >= len(gotLines) {
+			return fmt.Sprintf("snapshot %s differs at line %d: want %q, got %q (rerun with --update-snapshots to accept)", name, i+1, w, g)
+		}
+	}
+}
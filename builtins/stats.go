@@ -0,0 +1,335 @@
+// Statistics - numeric aggregates and keyed grouping over arrays of numbers or hashes
+
+package builtins
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"xon/object"
+)
+
+func init() {
+	builtinsMap["sum"] = &object.Builtin{Fn: statsSum}
+	builtinsMap["avg"] = &object.Builtin{Fn: statsAvg}
+	builtinsMap["median"] = &object.Builtin{Fn: statsMedian}
+	builtinsMap["stddev"] = &object.Builtin{Fn: statsStddev}
+	builtinsMap["percentile"] = &object.Builtin{Fn: statsPercentile}
+	builtinsMap["min_by"] = &object.Builtin{Fn: statsExtremeBy("min_by", -1)}
+	builtinsMap["max_by"] = &object.Builtin{Fn: statsExtremeBy("max_by", 1)}
+	builtinsMap["group_by"] = &object.Builtin{Fn: statsGroupBy}
+	builtinsMap["count_by"] = &object.Builtin{Fn: statsCountBy}
+}
+
+// selectKey applies a selector to an element: a STRING selector reads that
+// field of a hash element, anything else is called as a function.
+func selectKey(sel object.Object, el object.Object) object.Object {
+	if name, ok := sel.(*object.String); ok {
+		h, ok := el.(*object.Hash)
+		if !ok {
+			return &object.Error{Message: fmt.Sprintf("cannot select field %q from %s", name.Value, el.Type())}
+		}
+		if v := getHashValue(h, name.Value); v != nil {
+			return v
+		}
+		return NULL
+	}
+	return callFunction(sel, el)
+}
+
+// numericArgs extracts the numbers from args[0], optionally mapped through the
+// selector at args[selIdx]. allInt reports whether every value was an INTEGER.
+func numericArgs(name string, args []object.Object, selIdx int) (vals []float64, allInt bool, errObj *object.Error) {
+	nums, errObj := selectNumbers(name, args, selIdx)
+	if errObj != nil {
+		return nil, false, errObj
+	}
+	allInt = true
+	vals = make([]float64, len(nums))
+	for i, n := range nums {
+		vals[i], _ = toFloat(n)
+		if _, ok := n.(*object.Integer); !ok {
+			allInt = false
+		}
+	}
+	return vals, allInt, nil
+}
+
+// selectNumbers is numericArgs without the conversion to float64: it returns
+// each INTEGER or FLOAT as found.
+func selectNumbers(name string, args []object.Object, selIdx int) ([]object.Object, *object.Error) {
+	arr, ok := args[0].(*object.Array)
+	if !ok {
+		return nil, &object.Error{Message: fmt.Sprintf("first argument to `%s` must be ARRAY, got %s", name, args[0].Type())}
+	}
+	var sel object.Object
+	if len(args) > selIdx {
+		sel = args[selIdx]
+	}
+	nums := make([]object.Object, 0, len(arr.Elements))
+	for _, el := range arr.Elements {
+		if sel != nil {
+			el = selectKey(sel, el)
+		}
+		switch v := el.(type) {
+		case *object.Integer, *object.Float:
+			nums = append(nums, v)
+		case *object.Error:
+			return nil, v
+		default:
+			return nil, &object.Error{Message: fmt.Sprintf("`%s` requires numeric values, got %s", name, el.Type())}
+		}
+	}
+	return nums, nil
+}
+
+func statsSum(args ...object.Object) object.Object {
+	if len(args) < 1 || len(args) > 2 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1 or 2", len(args))}
+	}
+	nums, errObj := selectNumbers("sum", args, 1)
+	if errObj != nil {
+		return errObj
+	}
+	// Integers are added as int64; float64 would round them past 2^53.
+	var total int64
+	for _, n := range nums {
+		v, ok := n.(*object.Integer)
+		if !ok {
+			ftotal := 0.0
+			for _, n := range nums {
+				f, _ := toFloat(n)
+				ftotal += f
+			}
+			return &object.Float{Value: ftotal}
+		}
+		total += v.Value
+	}
+	return &object.Integer{Value: total}
+}
+
+func statsAvg(args ...object.Object) object.Object {
+	if len(args) < 1 || len(args) > 2 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1 or 2", len(args))}
+	}
+	vals, _, errObj := numericArgs("avg", args, 1)
+	if errObj != nil {
+		return errObj
+	}
+	if len(vals) == 0 {
+		return NULL
+	}
+	return &object.Float{Value: mean(vals)}
+}
+
+func statsMedian(args ...object.Object) object.Object {
+	if len(args) < 1 || len(args) > 2 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1 or 2", len(args))}
+	}
+	vals, _, errObj := numericArgs("median", args, 1)
+	if errObj != nil {
+		return errObj
+	}
+	if len(vals) == 0 {
+		return NULL
+	}
+	return &object.Float{Value: percentileOf(vals, 50)}
+}
+
+// statsStddev returns the population standard deviation.
+func statsStddev(args ...object.Object) object.Object {
+	if len(args) < 1 || len(args) > 2 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1 or 2", len(args))}
+	}
+	vals, _, errObj := numericArgs("stddev", args, 1)
+	if errObj != nil {
+		return errObj
+	}
+	if len(vals) == 0 {
+		return NULL
+	}
+	m := mean(vals)
+	variance := 0.0
+	for _, v := range vals {
+		variance += (v - m) * (v - m)
+	}
+	return &object.Float{Value: math.Sqrt(variance / float64(len(vals)))}
+}
+
+// statsPercentile implements percentile(arr, p, selector?) with p in [0, 100],
+// interpolating linearly between the closest ranks.
+func statsPercentile(args ...object.Object) object.Object {
+	if len(args) < 2 || len(args) > 3 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=2 or 3", len(args))}
+	}
+	var p float64
+	switch v := args[1].(type) {
+	case *object.Integer:
+		p = float64(v.Value)
+	case *object.Float:
+		p = v.Value
+	default:
+		return &object.Error{Message: fmt.Sprintf("percentile must be a number, got %s", args[1].Type())}
+	}
+	if p < 0 || p > 100 {
+		return &object.Error{Message: fmt.Sprintf("percentile must be between 0 and 100, got %g", p)}
+	}
+	vals, _, errObj := numericArgs("percentile", args, 2)
+	if errObj != nil {
+		return errObj
+	}
+	if len(vals) == 0 {
+		return NULL
+	}
+	return &object.Float{Value: percentileOf(vals, p)}
+}
+
+// statsExtremeBy builds min_by/max_by: the element whose selected key is
+// smallest (dir -1) or largest (dir 1). Keys must be all numbers or all strings.
+func statsExtremeBy(name string, dir int) object.BuiltinFunction {
+	return func(args ...object.Object) object.Object {
+		if len(args) != 2 {
+			return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=2", len(args))}
+		}
+		arr, ok := args[0].(*object.Array)
+		if !ok {
+			return &object.Error{Message: fmt.Sprintf("first argument to `%s` must be ARRAY, got %s", name, args[0].Type())}
+		}
+		var best, bestKey object.Object
+		for _, el := range arr.Elements {
+			key := selectKey(args[1], el)
+			if key.Type() == object.ERROR_OBJ {
+				return key
+			}
+			if best == nil {
+				best, bestKey = el, key
+				continue
+			}
+			cmp, err := compareKeys(key, bestKey)
+			if err != nil {
+				return &object.Error{Message: fmt.Sprintf("`%s`: %s", name, err)}
+			}
+			if cmp == dir {
+				best, bestKey = el, key
+			}
+		}
+		if best == nil {
+			return NULL
+		}
+		return best
+	}
+}
+
+func statsGroupBy(args ...object.Object) object.Object {
+	if len(args) != 2 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=2", len(args))}
+	}
+	arr, ok := args[0].(*object.Array)
+	if !ok {
+		return &object.Error{Message: fmt.Sprintf("first argument to `group_by` must be ARRAY, got %s", args[0].Type())}
+	}
+	groups := &object.Hash{Pairs: make(map[object.HashKey]object.HashPair)}
+	for _, el := range arr.Elements {
+		key := selectKey(args[1], el)
+		if key.Type() == object.ERROR_OBJ {
+			return key
+		}
+		hashable, ok := key.(object.Hashable)
+		if !ok {
+			return &object.Error{Message: fmt.Sprintf("unusable as hash key: %s", key.Type())}
+		}
+		hk := hashable.HashKey()
+		pair, ok := groups.Pairs[hk]
+		if !ok {
+			pair = object.HashPair{Key: key, Value: &object.Array{Elements: []object.Object{}}}
+		}
+		group := pair.Value.(*object.Array)
+		group.Elements = append(group.Elements, el)
+		groups.Pairs[hk] = pair
+	}
+	return groups
+}
+
+func statsCountBy(args ...object.Object) object.Object {
+	if len(args) != 2 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=2", len(args))}
+	}
+	arr, ok := args[0].(*object.Array)
+	if !ok {
+		return &object.Error{Message: fmt.Sprintf("first argument to `count_by` must be ARRAY, got %s", args[0].Type())}
+	}
+	counts := &object.Hash{Pairs: make(map[object.HashKey]object.HashPair)}
+	for _, el := range arr.Elements {
+		key := selectKey(args[1], el)
+		if key.Type() == object.ERROR_OBJ {
+			return key
+		}
+		hashable, ok := key.(object.Hashable)
+		if !ok {
+			return &object.Error{Message: fmt.Sprintf("unusable as hash key: %s", key.Type())}
+		}
+		hk := hashable.HashKey()
+		var n int64
+		if pair, ok := counts.Pairs[hk]; ok {
+			n = pair.Value.(*object.Integer).Value
+		}
+		counts.Pairs[hk] = object.HashPair{Key: key, Value: &object.Integer{Value: n + 1}}
+	}
+	return counts
+}
+
+func mean(vals []float64) float64 {
+	total := 0.0
+	for _, v := range vals {
+		total += v
+	}
+	return total / float64(len(vals))
+}
+
+func percentileOf(vals []float64, p float64) float64 {
+	sorted := append([]float64(nil), vals...)
+	sort.Float64s(sorted)
+	rank := p / 100 * float64(len(sorted)-1)
+	lo := int(math.Floor(rank))
+	hi := int(math.Ceil(rank))
+	return sorted[lo] + (sorted[hi]-sorted[lo])*(rank-float64(lo))
+}
+
+// compareKeys orders two numbers or two strings, returning -1, 0 or 1.
+func compareKeys(a, b object.Object) (int, error) {
+	if as, ok := a.(*object.String); ok {
+		bs, ok := b.(*object.String)
+		if !ok {
+			return 0, fmt.Errorf("cannot compare %s with %s", a.Type(), b.Type())
+		}
+		switch {
+		case as.Value < bs.Value:
+			return -1, nil
+		case as.Value > bs.Value:
+			return 1, nil
+		}
+		return 0, nil
+	}
+	af, ok1 := toFloat(a)
+	bf, ok2 := toFloat(b)
+	if !ok1 || !ok2 {
+		return 0, fmt.Errorf("cannot compare %s with %s", a.Type(), b.Type())
+	}
+	switch {
+	case af < bf:
+		return -1, nil
+	case af > bf:
+		return 1, nil
+	}
+	return 0, nil
+}
+
+func toFloat(obj object.Object) (float64, bool) {
+	switch v := obj.(type) {
+	case *object.Integer:
+		return float64(v.Value), true
+	case *object.Float:
+		return v.Value, true
+	}
+	return 0, false
+}
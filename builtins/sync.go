@@ -0,0 +1,250 @@
+// Sync - incremental one-way directory mirroring with excludes, deletion and dry-run
+
+package builtins
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+	"xon/object"
+)
+
+type syncOptions struct {
+	delete   bool
+	dryRun   bool
+	useHash  bool
+	excludes []string
+}
+
+type syncReport struct {
+	copied    []string
+	deleted   []string
+	unchanged int64
+}
+
+func init() {
+	builtinsMap["fs_sync"] = &object.Builtin{Fn: fsSync}
+}
+
+// fsSync implements fs_sync(srcDir, dstDir, options?). Files are copied when
+// missing from dstDir or when they differ: by default a file differs if its
+// size changed or the source is newer; {"compare": "hash"} compares contents
+// instead. Copies keep the source modification time so later runs skip them.
+// Other options: "delete" removes files not present in srcDir, "exclude" is an
+// array of glob patterns matched against relative paths and base names, and
+// "dry_run" only reports. Returns {"copied": [...], "deleted": [...], "unchanged": n}.
+func fsSync(args ...object.Object) object.Object {
+	if len(args) != 2 && len(args) != 3 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=2 or 3", len(args))}
+	}
+	src, ok1 := args[0].(*object.String)
+	dst, ok2 := args[1].(*object.String)
+	if !ok1 || !ok2 {
+		return &object.Error{Message: "first two arguments to `fs_sync` must be STRING, STRING"}
+	}
+	var opts syncOptions
+	if len(args) == 3 {
+		h, ok := args[2].(*object.Hash)
+		if !ok {
+			return &object.Error{Message: "fs_sync options must be a hash"}
+		}
+		opts.delete = getHashBool(h, "delete")
+		opts.dryRun = getHashBool(h, "dry_run")
+		switch mode := getHashStr(h, "compare"); mode {
+		case "", "time":
+		case "hash":
+			opts.useHash = true
+		default:
+			return &object.Error{Message: fmt.Sprintf("fs_sync compare must be \"time\" or \"hash\", got %q", mode)}
+		}
+		for _, el := range getHashArray(h, "exclude") {
+			pattern, ok := el.(*object.String)
+			if !ok {
+				return &object.Error{Message: "fs_sync exclude patterns must be STRING"}
+			}
+			if _, err := filepath.Match(pattern.Value, ""); err != nil {
+				return &object.Error{Message: fmt.Sprintf("fs_sync: bad exclude pattern %q", pattern.Value)}
+			}
+			opts.excludes = append(opts.excludes, pattern.Value)
+		}
+	}
+
+	info, err := os.Stat(src.Value)
+	if err != nil {
+		return &object.Error{Message: fmt.Sprintf("fs_sync: %s", err)}
+	}
+	if !info.IsDir() {
+		return &object.Error{Message: fmt.Sprintf("fs_sync: %s is not a directory", src.Value)}
+	}
+
+	report, err := syncDirs(src.Value, dst.Value, opts)
+	if err != nil {
+		return &object.Error{Message: fmt.Sprintf("fs_sync: %s", err)}
+	}
+
+	result := &object.Hash{Pairs: make(map[object.HashKey]object.HashPair)}
+	setHashPair(result, "copied", stringsToArray(report.copied))
+	setHashPair(result, "deleted", stringsToArray(report.deleted))
+	setHashPair(result, "unchanged", &object.Integer{Value: report.unchanged})
+	return result
+}
+
+func syncDirs(src, dst string, opts syncOptions) (*syncReport, error) {
+	report := &syncReport{copied: []string{}, deleted: []string{}}
+	seen := make(map[string]bool)
+
+	err := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		if syncExcluded(rel, opts.excludes) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		seen[rel] = true
+		if info.IsDir() {
+			return nil
+		}
+
+		target := filepath.Join(dst, rel)
+		changed, err := syncNeedsCopy(path, target, info, opts.useHash)
+		if err != nil {
+			return err
+		}
+		if !changed {
+			report.unchanged++
+			return nil
+		}
+		report.copied = append(report.copied, filepath.ToSlash(rel))
+		if opts.dryRun {
+			return nil
+		}
+		return copyFilePreservingTime(path, target, info)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.delete {
+		if err := syncDeleteExtra(dst, seen, opts, report); err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(report.copied)
+	sort.Strings(report.deleted)
+	return report, nil
+}
+
+// syncDeleteExtra removes entries of dst that have no counterpart in src.
+// Excluded paths are left alone on both sides.
+func syncDeleteExtra(dst string, seen map[string]bool, opts syncOptions, report *syncReport) error {
+	if _, err := os.Stat(dst); os.IsNotExist(err) {
+		return nil
+	}
+	var extra []string
+	err := filepath.Walk(dst, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dst, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		if syncExcluded(rel, opts.excludes) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !seen[rel] {
+			extra = append(extra, rel)
+			report.deleted = append(report.deleted, filepath.ToSlash(rel))
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+		}
+		return nil
+	})
+	if err != nil || opts.dryRun {
+		return err
+	}
+	for _, rel := range extra {
+		if err := os.RemoveAll(filepath.Join(dst, rel)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func syncExcluded(rel string, patterns []string) bool {
+	slashed := filepath.ToSlash(rel)
+	base := filepath.Base(rel)
+	for _, p := range patterns {
+		if ok, _ := filepath.Match(p, slashed); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(p, base); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func syncNeedsCopy(src, dst string, srcInfo os.FileInfo, useHash bool) (bool, error) {
+	dstInfo, err := os.Stat(dst)
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if dstInfo.IsDir() || dstInfo.Size() != srcInfo.Size() {
+		return true, nil
+	}
+	if useHash {
+		same, err := filesEqual(src, dst)
+		return !same, err
+	}
+	// Compare at second precision: FAT and some network shares round mtimes.
+	return srcInfo.ModTime().Truncate(time.Second).After(dstInfo.ModTime().Truncate(time.Second)), nil
+}
+
+func copyFilePreservingTime(src, dst string, info os.FileInfo) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Chtimes(dst, info.ModTime(), info.ModTime())
+}
+
+func stringsToArray(vals []string) *object.Array {
+	elements := make([]object.Object, len(vals))
+	for i, v := range vals {
+		elements[i] = &object.String{Value: v}
+	}
+	return &object.Array{Elements: elements}
+}
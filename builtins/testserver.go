@@ -0,0 +1,59 @@
+// Test server - an in-process HTTP server with canned routes, so scripts can test HTTP code offline
+
+package builtins
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"xon/object"
+)
+
+func init() {
+	builtinsMap["test_http_server"] = &object.Builtin{Fn: testHTTPServer}
+}
+
+// testHTTPServer implements test_http_server(routes): it starts a server on
+// a free localhost port and returns its base URL, such as
+// "http://127.0.0.1:41234". routes maps "/path" or "METHOD /path" to the
+// response: a string body, a hash with "status", "body" and "headers", or
+// a function called with the request hash (method, path, query, headers,
+// body) that returns either, as for http_serve. Other requests get 404.
+// The server runs until the script ends.
+func testHTTPServer(args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1", len(args))}
+	}
+	routes, ok := args[0].(*object.Hash)
+	if !ok {
+		return &object.Error{Message: fmt.Sprintf("argument to `test_http_server` must be HASH, got %s", args[0].Type())}
+	}
+	table := make(map[string]object.Object)
+	for _, pair := range routes.Pairs {
+		key, ok := pair.Key.(*object.String)
+		if !ok {
+			return &object.Error{Message: fmt.Sprintf("test_http_server routes must be STRING, got %s", pair.Key.Type())}
+		}
+		table[key.Value] = pair.Value
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		response, ok := table[r.Method+" "+r.URL.Path]
+		if !ok {
+			response, ok = table[r.URL.Path]
+		}
+		if !ok {
+			http.Error(w, fmt.Sprintf("no route for %s %s", r.Method, r.URL.Path), http.StatusNotFound)
+			return
+		}
+		if response.Type() == object.CLOSURE_OBJ {
+			if RunClosureCallback == nil {
+				http.Error(w, "Server engine not initialized", http.StatusInternalServerError)
+				return
+			}
+			response = RunClosureCallback(response.(*object.Closure), []object.Object{requestHash(r)})
+		}
+		writeResponse(w, response)
+	}))
+	return &object.String{Value: server.URL}
+}
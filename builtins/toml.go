@@ -0,0 +1,276 @@
+// TOML - the subset of TOML that config files use, for config_load
+
+package builtins
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+	"xon/object"
+)
+
+// parseTOML reads src into a hash, with a nested hash per table. It takes
+// comments, [tables] and dotted keys, and values that fit on one line:
+// strings, numbers, booleans, arrays and inline tables. Dates come back as
+// strings; multi-line strings and [[arrays of tables]] are rejected.
+func parseTOML(src string) (*object.Hash, error) {
+	root := &object.Hash{Pairs: make(map[object.HashKey]object.HashPair)}
+	current := root
+	for n, line := range strings.Split(src, "\n") {
+		p := &tomlParser{s: strings.TrimSpace(line)}
+		if p.done() {
+			continue
+		}
+		var err error
+		if strings.HasPrefix(p.s, "[[") {
+			err = fmt.Errorf("arrays of tables are not supported")
+		} else if p.s[0] == '[' {
+			p.pos++
+			var keys []string
+			if keys, err = p.keys(); err == nil {
+				if err = p.expect(']'); err == nil {
+					current, err = tomlTable(root, keys)
+				}
+			}
+		} else {
+			err = p.keyValue(current)
+		}
+		if err == nil && !p.done() {
+			err = fmt.Errorf("unexpected %q after the value", p.s[p.pos:])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", n+1, err)
+		}
+	}
+	return root, nil
+}
+
+// tomlTable returns the table at path under root, creating the tables on
+// the way.
+func tomlTable(root *object.Hash, path []string) (*object.Hash, error) {
+	table := root
+	for _, key := range path {
+		switch v := getHashValue(table, key).(type) {
+		case nil:
+			sub := &object.Hash{Pairs: make(map[object.HashKey]object.HashPair)}
+			setHashPair(table, key, sub)
+			table = sub
+		case *object.Hash:
+			table = v
+		default:
+			return nil, fmt.Errorf("%s is already a value, not a table", key)
+		}
+	}
+	return table, nil
+}
+
+type tomlParser struct {
+	s   string
+	pos int
+}
+
+func (p *tomlParser) skipSpace() {
+	for p.pos < len(p.s) && (p.s[p.pos] == ' ' || p.s[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+// done reports whether only a comment is left.
+func (p *tomlParser) done() bool {
+	p.skipSpace()
+	return p.pos == len(p.s) || p.s[p.pos] == '#'
+}
+
+func (p *tomlParser) expect(c byte) error {
+	p.skipSpace()
+	if p.pos == len(p.s) || p.s[p.pos] != c {
+		return fmt.Errorf("expected %c", c)
+	}
+	p.pos++
+	return nil
+}
+
+// keyValue reads `key = value` into table.
+func (p *tomlParser) keyValue(table *object.Hash) error {
+	keys, err := p.keys()
+	if err != nil {
+		return err
+	}
+	if err := p.expect('='); err != nil {
+		return err
+	}
+	value, err := p.value()
+	if err != nil {
+		return err
+	}
+	if table, err = tomlTable(table, keys[:len(keys)-1]); err != nil {
+		return err
+	}
+	last := keys[len(keys)-1]
+	if getHashValue(table, last) != nil {
+		return fmt.Errorf("%s is set twice", last)
+	}
+	setHashPair(table, last, value)
+	return nil
+}
+
+// keys reads a dotted key such as a.b."c d".
+func (p *tomlParser) keys() ([]string, error) {
+	var keys []string
+	for {
+		p.skipSpace()
+		start := p.pos
+		var key string
+		if p.pos < len(p.s) && (p.s[p.pos] == '"' || p.s[p.pos] == '\'') {
+			s, err := p.str()
+			if err != nil {
+				return nil, err
+			}
+			key = s
+		} else {
+			for p.pos < len(p.s) && isTOMLBare(p.s[p.pos]) {
+				p.pos++
+			}
+			key = p.s[start:p.pos]
+			if key == "" {
+				return nil, fmt.Errorf("expected a key")
+			}
+		}
+		keys = append(keys, key)
+		p.skipSpace()
+		if p.pos == len(p.s) || p.s[p.pos] != '.' {
+			return keys, nil
+		}
+		p.pos++
+	}
+}
+
+func isTOMLBare(c byte) bool {
+	return c == '_' || c == '-' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func (p *tomlParser) value() (object.Object, error) {
+	p.skipSpace()
+	if p.pos == len(p.s) {
+		return nil, fmt.Errorf("expected a value")
+	}
+	switch p.s[p.pos] {
+	case '"', '\'':
+		s, err := p.str()
+		if err != nil {
+			return nil, err
+		}
+		return &object.String{Value: s}, nil
+	case '[':
+		p.pos++
+		var elements []object.Object
+		for {
+			p.skipSpace()
+			if p.pos < len(p.s) && p.s[p.pos] == ']' {
+				p.pos++
+				return &object.Array{Elements: elements}, nil
+			}
+			el, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			elements = append(elements, el)
+			p.skipSpace()
+			if p.pos < len(p.s) && p.s[p.pos] == ',' {
+				p.pos++
+			} else if p.pos == len(p.s) || p.s[p.pos] != ']' {
+				return nil, fmt.Errorf("expected , or ] in array (arrays must fit on one line)")
+			}
+		}
+	case '{':
+		p.pos++
+		table := &object.Hash{Pairs: make(map[object.HashKey]object.HashPair)}
+		p.skipSpace()
+		if p.pos < len(p.s) && p.s[p.pos] == '}' {
+			p.pos++
+			return table, nil
+		}
+		for {
+			if err := p.keyValue(table); err != nil {
+				return nil, err
+			}
+			p.skipSpace()
+			if p.pos < len(p.s) && p.s[p.pos] == '}' {
+				p.pos++
+				return table, nil
+			}
+			if err := p.expect(','); err != nil {
+				return nil, fmt.Errorf("expected , or } in inline table")
+			}
+		}
+	}
+	start := p.pos
+	for p.pos < len(p.s) && (isTOMLBare(p.s[p.pos]) || strings.IndexByte("+.:", p.s[p.pos]) >= 0) {
+		p.pos++
+	}
+	word := p.s[start:p.pos]
+	switch {
+	case word == "true" || word == "false":
+		return boolToObj(word == "true"), nil
+	case strings.ContainsAny(word, ":") || len(word) >= 10 && word[4] == '-' && word[7] == '-':
+		return &object.String{Value: word}, nil // a date or time
+	}
+	if n, err := strconv.ParseInt(word, 0, 64); err == nil && (len(word) < 2 || word[0] != '0' || strings.ContainsAny(word[1:2], "xob")) {
+		return &object.Integer{Value: n}, nil
+	}
+	if f, err := strconv.ParseFloat(strings.ReplaceAll(word, "_", ""), 64); err == nil {
+		return &object.Float{Value: f}, nil
+	}
+	return nil, fmt.Errorf("invalid value %q", word)
+}
+
+// str reads a "basic" string with escapes or a 'literal' one.
+func (p *tomlParser) str() (string, error) {
+	quote := p.s[p.pos]
+	if strings.HasPrefix(p.s[p.pos:], strings.Repeat(string(quote), 3)) {
+		return "", fmt.Errorf("multi-line strings are not supported")
+	}
+	p.pos++
+	var out strings.Builder
+	for p.pos < len(p.s) {
+		c := p.s[p.pos]
+		p.pos++
+		switch {
+		case c == quote:
+			return out.String(), nil
+		case c == '\\' && quote == '"' && p.pos < len(p.s):
+			e := p.s[p.pos]
+			p.pos++
+			switch e {
+			case 'n':
+				out.WriteByte('\n')
+			case 't':
+				out.WriteByte('\t')
+			case 'r':
+				out.WriteByte('\r')
+			case '"', '\\':
+				out.WriteByte(e)
+			case 'u', 'U':
+				size := 4
+				if e == 'U' {
+					size = 8
+				}
+				if p.pos+size > len(p.s) {
+					return "", fmt.Errorf("short \\%c escape", e)
+				}
+				r, err := strconv.ParseUint(p.s[p.pos:p.pos+size], 16, 32)
+				if err != nil || !utf8.ValidRune(rune(r)) {
+					return "", fmt.Errorf("invalid \\%c escape", e)
+				}
+				out.WriteRune(rune(r))
+				p.pos += size
+			default:
+				return "", fmt.Errorf("invalid escape \\%c", e)
+			}
+		default:
+			out.WriteByte(c)
+		}
+	}
+	return "", fmt.Errorf("unterminated string")
+}
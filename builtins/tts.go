@@ -0,0 +1,75 @@
+// TTS - speaking text aloud with the platform's speech engine
+
+package builtins
+
+import (
+	"fmt"
+	"strings"
+	"xon/object"
+)
+
+func init() {
+	builtinsMap["tts_speak"] = &object.Builtin{Fn: ttsSpeak}
+}
+
+// ttsSpeak implements tts_speak(text, options?). Options: voice (an
+// engine-specific name such as "Alex", "en-us" or "Microsoft Zira
+// Desktop"), rate (1 is normal speed, 2 twice as fast) and wait (false
+// returns while the text is still being spoken). Returns true.
+func ttsSpeak(args ...object.Object) object.Object {
+	if len(args) != 1 && len(args) != 2 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1 or 2", len(args))}
+	}
+	text, ok := args[0].(*object.String)
+	if !ok {
+		return &object.Error{Message: fmt.Sprintf("argument to `tts_speak` must be STRING, got %s", args[0].Type())}
+	}
+	opts := &object.Hash{Pairs: make(map[object.HashKey]object.HashPair)}
+	if len(args) == 2 {
+		h, ok := args[1].(*object.Hash)
+		if !ok {
+			return &object.Error{Message: "tts_speak options must be a hash"}
+		}
+		opts = h
+	}
+	rate := 1.0
+	switch r := getHashValue(opts, "rate").(type) {
+	case nil:
+	case *object.Integer:
+		rate = float64(r.Value)
+	case *object.Float:
+		rate = r.Value
+	default:
+		return &object.Error{Message: fmt.Sprintf("tts_speak: rate must be a number, got %s", r.Type())}
+	}
+	if rate < 0.1 || rate > 10 {
+		return &object.Error{Message: fmt.Sprintf("tts_speak: rate must be between 0.1 and 10, got %g", rate)}
+	}
+	if strings.TrimSpace(text.Value) == "" {
+		return TRUE
+	}
+
+	cmd, stdin, err := speechCommand(text.Value, getHashStr(opts, "voice"), rate)
+	if err != nil {
+		return &object.Error{Message: "tts_speak: " + err.Error()}
+	}
+	if stdin {
+		cmd.Stdin = strings.NewReader(text.Value)
+	}
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		return &object.Error{Message: "tts_speak: " + err.Error()}
+	}
+	if getHashValue(opts, "wait") != nil && !getHashBool(opts, "wait") {
+		go cmd.Wait()
+		return TRUE
+	}
+	if err := cmd.Wait(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return &object.Error{Message: "tts_speak: " + msg}
+		}
+		return &object.Error{Message: "tts_speak: " + err.Error()}
+	}
+	return TRUE
+}
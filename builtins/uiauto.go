@@ -0,0 +1,117 @@
+// UI automation - finding and driving controls through the accessibility API (UIAutomation, AT-SPI)
+
+package builtins
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"xon/object"
+)
+
+func init() {
+	builtinsMap["os_ui_find"] = &object.Builtin{Fn: func(args ...object.Object) object.Object {
+		return uiAutomation("os_ui_find", "find", args)
+	}}
+	builtinsMap["os_ui_invoke"] = &object.Builtin{Fn: func(args ...object.Object) object.Object {
+		return uiAutomation("os_ui_invoke", "invoke", args)
+	}}
+	builtinsMap["os_ui_get_text"] = &object.Builtin{Fn: func(args ...object.Object) object.Object {
+		return uiAutomation("os_ui_get_text", "text", args)
+	}}
+}
+
+// uiRequest is what the platform helper reads on stdin. Every criterion
+// that is set must match: name exactly, contains as a case-insensitive
+// part of the name, role as a control type such as "button" or "edit", id
+// as the automation id, and window as part of the top-level window's
+// title. invoke and text act on match number index.
+type uiRequest struct {
+	Action   string `json:"action"` // "find", "invoke" or "text"
+	Name     string `json:"name,omitempty"`
+	Contains string `json:"contains,omitempty"`
+	Role     string `json:"role,omitempty"`
+	ID       string `json:"id,omitempty"`
+	Window   string `json:"window,omitempty"`
+	Index    int64  `json:"index"`
+	Limit    int64  `json:"limit"`
+}
+
+// uiResponse is what the helper prints: the matching elements for find,
+// the text for text, or an error.
+type uiResponse struct {
+	Error    string           `json:"error"`
+	Elements []map[string]any `json:"elements"`
+	Text     *string          `json:"text"`
+}
+
+// uiAutomation implements the os_ui_* builtins. Each takes a criteria
+// hash; find returns the matching controls as {name, role, id, class, x,
+// y, width, height, enabled}, invoke presses, toggles or selects the
+// first match, and get_text returns its value or text.
+func uiAutomation(builtin, action string, args []object.Object) object.Object {
+	if len(args) != 1 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1", len(args))}
+	}
+	criteria, ok := args[0].(*object.Hash)
+	if !ok {
+		return &object.Error{Message: fmt.Sprintf("argument to `%s` must be a criteria HASH, got %s", builtin, args[0].Type())}
+	}
+	req := uiRequest{
+		Action:   action,
+		Name:     getHashStr(criteria, "name"),
+		Contains: getHashStr(criteria, "contains"),
+		Role:     strings.ToLower(getHashStr(criteria, "role")),
+		ID:       getHashStr(criteria, "id"),
+		Window:   getHashStr(criteria, "window"),
+		Index:    getHashInt(criteria, "index"),
+		Limit:    getHashInt(criteria, "limit"),
+	}
+	if req.Name == "" && req.Contains == "" && req.Role == "" && req.ID == "" && req.Window == "" {
+		return &object.Error{Message: builtin + ": criteria needs at least one of name, contains, role, id or window"}
+	}
+	if req.Limit <= 0 {
+		req.Limit = 100
+	}
+
+	cmd, err := uiHelper()
+	if err != nil {
+		return &object.Error{Message: builtin + ": " + err.Error()}
+	}
+	input, _ := json.Marshal(req)
+	cmd.Stdin = bytes.NewReader(input)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	runErr := cmd.Run()
+	var resp uiResponse
+	if err := json.Unmarshal(stdout.Bytes(), &resp); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" && runErr != nil {
+			msg = runErr.Error()
+		}
+		if msg == "" {
+			msg = "the accessibility helper gave no answer"
+		}
+		return &object.Error{Message: builtin + ": " + msg}
+	}
+	if resp.Error != "" {
+		return &object.Error{Message: builtin + ": " + resp.Error}
+	}
+
+	switch action {
+	case "find":
+		elements := make([]object.Object, len(resp.Elements))
+		for i, el := range resp.Elements {
+			elements[i] = rawToObj(el)
+		}
+		return &object.Array{Elements: elements}
+	case "text":
+		if resp.Text == nil {
+			return &object.Error{Message: builtin + ": the accessibility helper gave no text"}
+		}
+		return &object.String{Value: *resp.Text}
+	}
+	return TRUE
+}
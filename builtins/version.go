@@ -0,0 +1,55 @@
+// Version - the runtime and standard library version checked by `requires` directives
+
+package builtins
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version is the language, runtime and embedded standard library version.
+// Bump the minor part whenever builtins or std functions are added, so
+// scripts can declare what they need with `requires "1.2";`;
+// TestVersionTracksBuiltins fails until the bump is made.
+const Version = "1.29"
+
+// CheckRequires returns an error when a script's `requires` version is newer
+// than this runtime. Versions are dot-separated numbers; missing parts count
+// as zero, so "1" and "1.0" are the same.
+func CheckRequires(required string) error {
+	want, err := parseVersion(required)
+	if err != nil {
+		return fmt.Errorf("invalid requires version %q: want numbers such as \"1.2\"", required)
+	}
+	have, _ := parseVersion(Version)
+	for i := 0; i < len(want) || i < len(have); i++ {
+		var w, h int
+		if i < len(want) {
+			w = want[i]
+		}
+		if i < len(have) {
+			h = have[i]
+		}
+		if w != h {
+			if w > h {
+				return fmt.Errorf("this script requires Xon %s or newer, but this is Xon %s; install a newer release to run it", required, Version)
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+func parseVersion(v string) ([]int, error) {
+	parts := strings.Split(v, ".")
+	nums := make([]int, len(parts))
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid version %q", v)
+		}
+		nums[i] = n
+	}
+	return nums, nil
+}
@@ -0,0 +1,590 @@
+// XLSX - reading and writing Excel workbooks with archive/zip and encoding/xml
+
+package builtins
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"xon/object"
+)
+
+func init() {
+	builtinsMap["xlsx_read"] = &object.Builtin{Fn: xlsxRead}
+	builtinsMap["xlsx_write"] = &object.Builtin{Fn: xlsxWrite}
+}
+
+// The parts of a workbook xlsx_read looks at. Namespaces are left out of
+// the tags so that files from Excel, LibreOffice and generators that use
+// prefixes all decode the same way.
+type xlsxWorkbook struct {
+	Sheets []struct {
+		Name string `xml:"name,attr"`
+		RID  string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+type xlsxRelationships struct {
+	Rels []struct {
+		ID     string `xml:"Id,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+
+// xlsxText is a string item: plain <t>, or rich text runs of <r><t>.
+type xlsxText struct {
+	T    string `xml:"t"`
+	Runs []struct {
+		T string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (t xlsxText) String() string {
+	if len(t.Runs) == 0 {
+		return t.T
+	}
+	var b strings.Builder
+	for _, r := range t.Runs {
+		b.WriteString(r.T)
+	}
+	return b.String()
+}
+
+type xlsxSharedStrings struct {
+	Items []xlsxText `xml:"si"`
+}
+
+type xlsxStyles struct {
+	NumFmts []struct {
+		ID   int    `xml:"numFmtId,attr"`
+		Code string `xml:"formatCode,attr"`
+	} `xml:"numFmts>numFmt"`
+	CellXfs []struct {
+		NumFmtID int `xml:"numFmtId,attr"`
+	} `xml:"cellXfs>xf"`
+}
+
+type xlsxSheet struct {
+	Rows []struct {
+		Cells []struct {
+			Ref    string   `xml:"r,attr"`
+			Type   string   `xml:"t,attr"`
+			Style  int      `xml:"s,attr"`
+			Value  string   `xml:"v"`
+			Inline xlsxText `xml:"is"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+// xlsxFile is an open workbook archive.
+type xlsxFile struct {
+	files   map[string]*zip.File
+	strings []string
+	dates   map[int]bool // style index -> the cell holds a date
+}
+
+func (x *xlsxFile) decode(name string, v any) error {
+	f, ok := x.files[name]
+	if !ok {
+		return fmt.Errorf("%s is missing from the workbook", name)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	if err := xml.NewDecoder(rc).Decode(v); err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	return nil
+}
+
+// xlsxRead implements xlsx_read(path, options?). It returns a hash of
+// sheet name to rows, where the first row of each sheet names the keys of
+// the hashes for the rows below it. Options: sheet (return only that
+// sheet's rows) and header (false returns every row as an array).
+func xlsxRead(args ...object.Object) object.Object {
+	if len(args) != 1 && len(args) != 2 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1 or 2", len(args))}
+	}
+	p, ok := args[0].(*object.String)
+	if !ok {
+		return &object.Error{Message: fmt.Sprintf("argument to `xlsx_read` must be STRING, got %s", args[0].Type())}
+	}
+	opts := &object.Hash{Pairs: make(map[object.HashKey]object.HashPair)}
+	if len(args) == 2 {
+		h, ok := args[1].(*object.Hash)
+		if !ok {
+			return &object.Error{Message: "xlsx_read options must be a hash"}
+		}
+		opts = h
+	}
+	header := getHashValue(opts, "header") == nil || getHashBool(opts, "header")
+	only := getHashStr(opts, "sheet")
+
+	zr, err := zip.OpenReader(p.Value)
+	if err != nil {
+		return &object.Error{Message: "xlsx_read: " + err.Error()}
+	}
+	defer zr.Close()
+	x := &xlsxFile{files: make(map[string]*zip.File), dates: make(map[int]bool)}
+	for _, f := range zr.File {
+		x.files[strings.TrimPrefix(f.Name, "/")] = f
+	}
+
+	var wb xlsxWorkbook
+	var rels xlsxRelationships
+	if err := x.decode("xl/workbook.xml", &wb); err != nil {
+		return &object.Error{Message: "xlsx_read: " + err.Error()}
+	}
+	if err := x.decode("xl/_rels/workbook.xml.rels", &rels); err != nil {
+		return &object.Error{Message: "xlsx_read: " + err.Error()}
+	}
+	// Shared strings and styles are optional parts.
+	if _, ok := x.files["xl/sharedStrings.xml"]; ok {
+		var sst xlsxSharedStrings
+		if err := x.decode("xl/sharedStrings.xml", &sst); err != nil {
+			return &object.Error{Message: "xlsx_read: " + err.Error()}
+		}
+		for _, si := range sst.Items {
+			x.strings = append(x.strings, si.String())
+		}
+	}
+	if _, ok := x.files["xl/styles.xml"]; ok {
+		var styles xlsxStyles
+		if err := x.decode("xl/styles.xml", &styles); err != nil {
+			return &object.Error{Message: "xlsx_read: " + err.Error()}
+		}
+		custom := make(map[int]string)
+		for _, nf := range styles.NumFmts {
+			custom[nf.ID] = nf.Code
+		}
+		for i, xf := range styles.CellXfs {
+			x.dates[i] = isDateFormat(xf.NumFmtID, custom[xf.NumFmtID])
+		}
+	}
+
+	targets := make(map[string]string)
+	for _, r := range rels.Rels {
+		target := r.Target
+		if strings.HasPrefix(target, "/") {
+			target = strings.TrimPrefix(target, "/")
+		} else {
+			target = path.Join("xl", target)
+		}
+		targets[r.ID] = target
+	}
+
+	result := &object.Hash{Pairs: make(map[object.HashKey]object.HashPair)}
+	for _, s := range wb.Sheets {
+		if only != "" && s.Name != only {
+			continue
+		}
+		rows, err := x.readSheet(targets[s.RID], header)
+		if err != nil {
+			return &object.Error{Message: fmt.Sprintf("xlsx_read: sheet %q: %v", s.Name, err)}
+		}
+		if only != "" {
+			return rows
+		}
+		setHashPair(result, s.Name, rows)
+	}
+	if only != "" {
+		return &object.Error{Message: fmt.Sprintf("xlsx_read: no sheet named %q", only)}
+	}
+	return result
+}
+
+// readSheet returns a sheet's rows as arrays, or as hashes keyed by the
+// first row when header is set. Blank rows are left out; cells missing from
+// a row are null.
+func (x *xlsxFile) readSheet(name string, header bool) (object.Object, error) {
+	var sheet xlsxSheet
+	if err := x.decode(name, &sheet); err != nil {
+		return nil, err
+	}
+	var grid [][]object.Object
+	for _, row := range sheet.Rows {
+		var cells []object.Object
+		for i, c := range row.Cells {
+			col := i
+			if c.Ref != "" {
+				col = xlsxColumn(c.Ref)
+			}
+			for len(cells) <= col {
+				cells = append(cells, NULL)
+			}
+			cells[col] = x.cellValue(c.Type, c.Style, c.Value, c.Inline)
+		}
+		if len(cells) > 0 {
+			grid = append(grid, cells)
+		}
+	}
+
+	rows := []object.Object{}
+	if !header {
+		for _, cells := range grid {
+			rows = append(rows, &object.Array{Elements: cells})
+		}
+		return &object.Array{Elements: rows}, nil
+	}
+	if len(grid) == 0 {
+		return &object.Array{Elements: rows}, nil
+	}
+	keys := make([]string, len(grid[0]))
+	for i, cell := range grid[0] {
+		if cell == NULL || cell.Inspect() == "" {
+			keys[i] = xlsxColumnName(i)
+		} else {
+			keys[i] = cell.Inspect()
+		}
+	}
+	for _, cells := range grid[1:] {
+		h := &object.Hash{Pairs: make(map[object.HashKey]object.HashPair)}
+		for i, key := range keys {
+			value := object.Object(NULL)
+			if i < len(cells) {
+				value = cells[i]
+			}
+			setHashPair(h, key, value)
+		}
+		rows = append(rows, h)
+	}
+	return &object.Array{Elements: rows}, nil
+}
+
+func (x *xlsxFile) cellValue(typ string, style int, v string, inline xlsxText) object.Object {
+	switch typ {
+	case "s":
+		i, err := strconv.Atoi(v)
+		if err != nil || i < 0 || i >= len(x.strings) {
+			return NULL
+		}
+		return &object.String{Value: x.strings[i]}
+	case "inlineStr":
+		return &object.String{Value: inline.String()}
+	case "str", "e":
+		return &object.String{Value: v}
+	case "b":
+		return boolToObj(v == "1")
+	}
+	if v == "" {
+		return NULL
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return &object.String{Value: v}
+	}
+	if x.dates[style] {
+		return &object.String{Value: xlsxDate(f)}
+	}
+	if f == math.Trunc(f) && math.Abs(f) < 1<<53 {
+		return &object.Integer{Value: int64(f)}
+	}
+	return &object.Float{Value: f}
+}
+
+// isDateFormat reports whether a number format shows a date or time: one
+// of the built-in date formats, or a custom code with date or time parts
+// outside quoted text and brackets.
+func isDateFormat(id int, code string) bool {
+	if id >= 14 && id <= 22 || id >= 45 && id <= 47 {
+		return true
+	}
+	inQuote, inBracket := false, false
+	for _, c := range strings.ToLower(code) {
+		switch {
+		case c == '"':
+			inQuote = !inQuote
+		case inQuote:
+		case c == '[':
+			inBracket = true
+		case c == ']':
+			inBracket = false
+		case inBracket:
+		case strings.ContainsRune("ymdhs", c):
+			return true
+		}
+	}
+	return false
+}
+
+// xlsxDate turns a serial day number (days since 1899-12-30, the 1900
+// date system) into "2006-01-02", with the time when there is one.
+func xlsxDate(serial float64) string {
+	epoch := time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+	t := epoch.Add(time.Duration(math.Round(serial*86400)) * time.Second)
+	if t.Hour() == 0 && t.Minute() == 0 && t.Second() == 0 {
+		return t.Format("2006-01-02")
+	}
+	if serial < 1 {
+		return t.Format("15:04:05")
+	}
+	return t.Format("2006-01-02 15:04:05")
+}
+
+// xlsxColumn returns the 0-based column of a cell reference such as "AB12".
+func xlsxColumn(ref string) int {
+	col := 0
+	for _, c := range ref {
+		if c < 'A' || c > 'Z' {
+			break
+		}
+		col = col*26 + int(c-'A'+1)
+	}
+	return col - 1
+}
+
+// xlsxColumnName is the letters for a 0-based column: 0 is "A", 26 "AA".
+func xlsxColumnName(col int) string {
+	name := ""
+	for col++; col > 0; col = (col - 1) / 26 {
+		name = string(rune('A'+(col-1)%26)) + name
+	}
+	return name
+}
+
+// xlsxWrite implements xlsx_write(path, data, options?). data is one
+// sheet's rows, or a hash of sheet name to rows. Rows are hashes, written
+// under a header row of their keys, or arrays, written as they are. The
+// header is bold and frozen, and columns are sized to fit. Options:
+// columns (the header order; the keys sorted by default) and sheet (the
+// name for a single sheet, "Sheet1" by default).
+func xlsxWrite(args ...object.Object) object.Object {
+	if len(args) != 2 && len(args) != 3 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=2 or 3", len(args))}
+	}
+	p, ok := args[0].(*object.String)
+	if !ok {
+		return &object.Error{Message: fmt.Sprintf("first argument to `xlsx_write` must be STRING, got %s", args[0].Type())}
+	}
+	opts := &object.Hash{Pairs: make(map[object.HashKey]object.HashPair)}
+	if len(args) == 3 {
+		h, ok := args[2].(*object.Hash)
+		if !ok {
+			return &object.Error{Message: "xlsx_write options must be a hash"}
+		}
+		opts = h
+	}
+	var columns []string
+	for _, c := range getHashArray(opts, "columns") {
+		columns = append(columns, objectText(c))
+	}
+
+	type sheet struct {
+		name string
+		rows []object.Object
+	}
+	var sheets []sheet
+	switch data := args[1].(type) {
+	case *object.Array:
+		name := getHashStr(opts, "sheet")
+		if name == "" {
+			name = "Sheet1"
+		}
+		sheets = append(sheets, sheet{name, data.Elements})
+	case *object.Hash:
+		for _, pair := range data.Pairs {
+			rows, ok := pair.Value.(*object.Array)
+			if !ok {
+				return &object.Error{Message: fmt.Sprintf("xlsx_write: sheet %s must be an array of rows, got %s", pair.Key.Inspect(), pair.Value.Type())}
+			}
+			sheets = append(sheets, sheet{pair.Key.Inspect(), rows.Elements})
+		}
+		sort.Slice(sheets, func(i, j int) bool { return sheets[i].name < sheets[j].name })
+	default:
+		return &object.Error{Message: fmt.Sprintf("second argument to `xlsx_write` must be ARRAY or HASH, got %s", args[1].Type())}
+	}
+	if len(sheets) == 0 {
+		return &object.Error{Message: "xlsx_write: a workbook needs at least one sheet"}
+	}
+	for _, s := range sheets {
+		if s.name == "" || len(s.name) > 31 || strings.ContainsAny(s.name, `[]:*?/\`) {
+			return &object.Error{Message: fmt.Sprintf("xlsx_write: %q is not a valid sheet name (1 to 31 characters, none of []:*?/\\)", s.name)}
+		}
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	add := func(name, content string) {
+		w, _ := zw.Create(name)
+		io.WriteString(w, content)
+	}
+	var overrides, workbook, rels strings.Builder
+	for i, s := range sheets {
+		n := i + 1
+		fmt.Fprintf(&overrides, `<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`, n)
+		fmt.Fprintf(&workbook, `<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, xmlEscape(s.name), n, n)
+		fmt.Fprintf(&rels, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet%d.xml"/>`, n, n)
+		add(fmt.Sprintf("xl/worksheets/sheet%d.xml", n), xlsxSheetXML(s.rows, columns))
+	}
+	stylesID := len(sheets) + 1
+	fmt.Fprintf(&rels, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>`, stylesID)
+
+	add("[Content_Types].xml", xml.Header+`<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">`+
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>`+
+		`<Default Extension="xml" ContentType="application/xml"/>`+
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>`+
+		`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>`+
+		overrides.String()+`</Types>`)
+	add("_rels/.rels", xml.Header+`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`+
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>`+
+		`</Relationships>`)
+	add("xl/workbook.xml", xml.Header+`<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">`+
+		`<sheets>`+workbook.String()+`</sheets></workbook>`)
+	add("xl/_rels/workbook.xml.rels", xml.Header+`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`+
+		rels.String()+`</Relationships>`)
+	// Style 0 is the default and style 1 the bold header.
+	add("xl/styles.xml", xml.Header+`<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">`+
+		`<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>`+
+		`<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>`+
+		`<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>`+
+		`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>`+
+		`<cellXfs count="2"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/><xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/></cellXfs>`+
+		`</styleSheet>`)
+	if err := zw.Close(); err != nil {
+		return &object.Error{Message: "xlsx_write: " + err.Error()}
+	}
+	if err := os.WriteFile(p.Value, buf.Bytes(), 0644); err != nil {
+		return &object.Error{Message: "xlsx_write: " + err.Error()}
+	}
+	return TRUE
+}
+
+// xlsxSheetXML renders one worksheet. When the rows are hashes, a bold
+// header row of columns (or of every key, sorted) comes first and stays
+// frozen while scrolling.
+func xlsxSheetXML(rows []object.Object, columns []string) string {
+	var header []string
+	for _, row := range rows {
+		if _, ok := row.(*object.Hash); ok {
+			header = columns
+			break
+		}
+	}
+	if header == nil {
+		seen := make(map[string]bool)
+		for _, row := range rows {
+			if h, ok := row.(*object.Hash); ok {
+				for _, pair := range h.Pairs {
+					if key := pair.Key.Inspect(); !seen[key] {
+						seen[key] = true
+						header = append(header, key)
+					}
+				}
+			}
+		}
+		sort.Strings(header)
+	}
+
+	var grid [][]object.Object
+	if len(header) > 0 {
+		cells := make([]object.Object, len(header))
+		for i, key := range header {
+			cells[i] = &object.String{Value: key}
+		}
+		grid = append(grid, cells)
+	}
+	for _, row := range rows {
+		switch row := row.(type) {
+		case *object.Hash:
+			cells := make([]object.Object, len(header))
+			for i, key := range header {
+				cells[i] = NULL
+				if pair, ok := row.Pairs[(&object.String{Value: key}).HashKey()]; ok {
+					cells[i] = pair.Value
+				}
+			}
+			grid = append(grid, cells)
+		case *object.Array:
+			grid = append(grid, row.Elements)
+		default:
+			grid = append(grid, []object.Object{row})
+		}
+	}
+
+	// Widths fit the longest value in each column, within reason.
+	var widths []int
+	for _, cells := range grid {
+		for i, cell := range cells {
+			for len(widths) <= i {
+				widths = append(widths, 8)
+			}
+			if n := len([]rune(xlsxCellText(cell))) + 2; n > widths[i] {
+				widths[i] = min(n, 60)
+			}
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString(xml.Header + `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">`)
+	if len(header) > 0 {
+		b.WriteString(`<sheetViews><sheetView workbookViewId="0"><pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/></sheetView></sheetViews>`)
+	}
+	if len(widths) > 0 {
+		b.WriteString(`<cols>`)
+		for i, w := range widths {
+			fmt.Fprintf(&b, `<col min="%d" max="%d" width="%d" customWidth="1"/>`, i+1, i+1, w)
+		}
+		b.WriteString(`</cols>`)
+	}
+	b.WriteString(`<sheetData>`)
+	for r, cells := range grid {
+		fmt.Fprintf(&b, `<row r="%d">`, r+1)
+		style := ""
+		if r == 0 && len(header) > 0 {
+			style = ` s="1"`
+		}
+		for c, cell := range cells {
+			ref := fmt.Sprintf("%s%d", xlsxColumnName(c), r+1)
+			switch cell := cell.(type) {
+			case *object.Null:
+			case *object.Integer:
+				fmt.Fprintf(&b, `<c r="%s"%s><v>%d</v></c>`, ref, style, cell.Value)
+			case *object.Float:
+				fmt.Fprintf(&b, `<c r="%s"%s><v>%s</v></c>`, ref, style, strconv.FormatFloat(cell.Value, 'g', -1, 64))
+			case *object.Boolean:
+				v := 0
+				if cell.Value {
+					v = 1
+				}
+				fmt.Fprintf(&b, `<c r="%s"%s t="b"><v>%d</v></c>`, ref, style, v)
+			default:
+				fmt.Fprintf(&b, `<c r="%s"%s t="inlineStr"><is><t xml:space="preserve">%s</t></is></c>`, ref, style, xmlEscape(xlsxCellText(cell)))
+			}
+		}
+		b.WriteString(`</row>`)
+	}
+	b.WriteString(`</sheetData></worksheet>`)
+	return b.String()
+}
+
+// xlsxCellText is how a value reads in a cell: arrays and hashes are
+// written as JSON.
+func xlsxCellText(obj object.Object) string {
+	switch obj := obj.(type) {
+	case *object.Null:
+		return ""
+	case *object.Array, *object.Hash:
+		data, _ := json.Marshal(objToRaw(obj))
+		return string(data)
+	default:
+		return obj.Inspect()
+	}
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
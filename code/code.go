@@ -58,6 +58,29 @@ const (
 	OpCatch
 	OpThrow
 	OpEndCatch
+	OpCurrentClosure
+	OpAppend
+	OpFreeze
+	OpGroupStart
+	OpGroupEnd
+	OpJumpNotNull
+	OpJumpNull
+	OpSetIndex
+	OpSetMember
+	OpGreaterEqual
+	OpPow
+	OpRange
+	OpSlice
+	OpClass
+
+	// Superinstructions. Fuse puts them in decoded code; the compiler never
+	// emits them. See fuse.go for what each stands for.
+	OpAddConstLocal
+	OpAddLocalLocal
+	OpAddConstGlobal
+	OpIndexLocal
+	OpCompareConstJump
+	OpCompareLocalJump
 )
 
 type Definition struct {
@@ -66,53 +89,74 @@ type Definition struct {
 }
 
 var definitions = map[Opcode]*Definition{
-	OpConstant:      {"OpConstant", []int{2}}, // 2 bytes = 65535 possible constants
-	OpAdd:           {"OpAdd", []int{}},
-	OpPop:           {"OpPop", []int{}},
-	OpSub:           {"OpSub", []int{}},
-	OpMul:           {"OpMul", []int{}},
-	OpDiv:           {"OpDiv", []int{}},
-	OpTrue:          {"OpTrue", []int{}},
-	OpFalse:         {"OpFalse", []int{}},
-	OpString:        {"OpString", []int{2}},
-	OpOut:           {"OpOut", []int{}},
-	OpSetGlobal:     {"OpSetGlobal", []int{2}},
-	OpGetGlobal:     {"OpGetGlobal", []int{2}},
-	OpJump:          {"OpJump", []int{2}},
-	OpJumpNotTruthy: {"OpJumpNotTruthy", []int{2}},
-	OpGreaterThan:   {"OpGreaterThan", []int{}},
-	OpEqual:         {"OpEqual", []int{}},
-	OpNotEqual:      {"OpNotEqual", []int{}},
-	OpCall:          {"OpCall", []int{1}}, // 1 byte for number of arguments
-	OpReturnValue:   {"OpReturnValue", []int{}},
-	OpReturn:        {"OpReturn", []int{}},
-	OpGetLocal:      {"OpGetLocal", []int{1}}, // locals usually small, 1 byte is plenty
-	OpSetLocal:      {"OpSetLocal", []int{1}},
-	OpGetBuiltin:    {"OpGetBuiltin", []int{1}},
-	OpArray:         {"OpArray", []int{2}},
-	OpHash:          {"OpHash", []int{2}},
-	OpIndex:         {"OpIndex", []int{}},
-	OpMember:        {"OpMember", []int{2}},
-	OpNull:          {"OpNull", []int{}},
-	OpMinus:         {"OpMinus", []int{}},
-	OpBang:          {"OpBang", []int{}},
-	OpSpawn:         {"OpSpawn", []int{1}}, // 1 byte for number of arguments
-	OpClosure:       {"OpClosure", []int{2, 1}},
-	OpGetFree:       {"OpGetFree", []int{1}},
-	OpSetFree:       {"OpSetFree", []int{1}},
-	OpImport:        {"OpImport", []int{}},
-	OpBitAnd:        {"OpBitAnd", []int{}},
-	OpBitOr:         {"OpBitOr", []int{}},
-	OpBitXor:        {"OpBitXor", []int{}},
-	OpBitNot:        {"OpBitNot", []int{}},
-	OpLshift:        {"OpLshift", []int{}},
-	OpRshift:        {"OpRshift", []int{}},
-	OpMod:           {"OpMod", []int{}},
-	OpJumpTruthy:    {"OpJumpTruthy", []int{2}},
-	OpDup:           {"OpDup", []int{}},
-	OpCatch:         {"OpCatch", []int{2}},
-	OpThrow:         {"OpThrow", []int{}},
-	OpEndCatch:      {"OpEndCatch", []int{}},
+	OpConstant:       {"OpConstant", []int{2}}, // 2 bytes = 65535 possible constants
+	OpAdd:            {"OpAdd", []int{}},
+	OpPop:            {"OpPop", []int{}},
+	OpSub:            {"OpSub", []int{}},
+	OpMul:            {"OpMul", []int{}},
+	OpDiv:            {"OpDiv", []int{}},
+	OpTrue:           {"OpTrue", []int{}},
+	OpFalse:          {"OpFalse", []int{}},
+	OpString:         {"OpString", []int{2}},
+	OpOut:            {"OpOut", []int{}},
+	OpSetGlobal:      {"OpSetGlobal", []int{2}},
+	OpGetGlobal:      {"OpGetGlobal", []int{2}},
+	OpJump:           {"OpJump", []int{2}},
+	OpJumpNotTruthy:  {"OpJumpNotTruthy", []int{2}},
+	OpGreaterThan:    {"OpGreaterThan", []int{}},
+	OpEqual:          {"OpEqual", []int{}},
+	OpNotEqual:       {"OpNotEqual", []int{}},
+	OpCall:           {"OpCall", []int{1}}, // 1 byte for number of arguments
+	OpReturnValue:    {"OpReturnValue", []int{}},
+	OpReturn:         {"OpReturn", []int{}},
+	OpGetLocal:       {"OpGetLocal", []int{1}}, // locals usually small, 1 byte is plenty
+	OpSetLocal:       {"OpSetLocal", []int{1}},
+	OpGetBuiltin:     {"OpGetBuiltin", []int{1}},
+	OpArray:          {"OpArray", []int{2}},
+	OpHash:           {"OpHash", []int{2}},
+	OpIndex:          {"OpIndex", []int{}},
+	OpMember:         {"OpMember", []int{2}},
+	OpNull:           {"OpNull", []int{}},
+	OpMinus:          {"OpMinus", []int{}},
+	OpBang:           {"OpBang", []int{}},
+	OpSpawn:          {"OpSpawn", []int{1}}, // 1 byte for number of arguments
+	OpClosure:        {"OpClosure", []int{2, 1}},
+	OpGetFree:        {"OpGetFree", []int{1}},
+	OpSetFree:        {"OpSetFree", []int{1}},
+	OpImport:         {"OpImport", []int{}},
+	OpBitAnd:         {"OpBitAnd", []int{}},
+	OpBitOr:          {"OpBitOr", []int{}},
+	OpBitXor:         {"OpBitXor", []int{}},
+	OpBitNot:         {"OpBitNot", []int{}},
+	OpLshift:         {"OpLshift", []int{}},
+	OpRshift:         {"OpRshift", []int{}},
+	OpMod:            {"OpMod", []int{}},
+	OpJumpTruthy:     {"OpJumpTruthy", []int{2}},
+	OpDup:            {"OpDup", []int{}},
+	OpCatch:          {"OpCatch", []int{2}},
+	OpThrow:          {"OpThrow", []int{}},
+	OpEndCatch:       {"OpEndCatch", []int{}},
+	OpCurrentClosure: {"OpCurrentClosure", []int{}},
+	OpAppend:         {"OpAppend", []int{}},
+	OpFreeze:         {"OpFreeze", []int{}},
+	OpGroupStart:     {"OpGroupStart", []int{}},
+	OpGroupEnd:       {"OpGroupEnd", []int{}},
+	OpJumpNotNull:    {"OpJumpNotNull", []int{2}},
+	OpJumpNull:       {"OpJumpNull", []int{2}},
+	OpSetIndex:       {"OpSetIndex", []int{}},
+	OpSetMember:      {"OpSetMember", []int{2}},
+	OpGreaterEqual:   {"OpGreaterEqual", []int{}},
+	OpPow:            {"OpPow", []int{}},
+	OpRange:          {"OpRange", []int{}},
+	OpSlice:          {"OpSlice", []int{}},
+	OpClass:          {"OpClass", []int{2, 2}}, // class constant, method count
+
+	OpAddConstLocal:    {"OpAddConstLocal", []int{}},
+	OpAddLocalLocal:    {"OpAddLocalLocal", []int{}},
+	OpAddConstGlobal:   {"OpAddConstGlobal", []int{}},
+	OpIndexLocal:       {"OpIndexLocal", []int{}},
+	OpCompareConstJump: {"OpCompareConstJump", []int{}},
+	OpCompareLocalJump: {"OpCompareLocalJump", []int{}},
 }
 
 func Lookup(op byte) (*Definition, error) {
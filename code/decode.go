@@ -0,0 +1,77 @@
+package code
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Instr is one decoded instruction, so the VM reads operands as plain ints
+// instead of decoding bytes on every step. The targets of jumps and
+// OpCatch are instruction indexes into the decoded slice, not byte offsets.
+type Instr struct {
+	Op Opcode
+	A  int
+	B  int
+	C  int // only used by superinstructions
+}
+
+// Decode returns one Instr per instruction in ins. An undefined opcode
+// decodes with no operands; the VM reports it when it reaches it.
+func Decode(ins Instructions) []Instr {
+	// index maps each instruction's byte offset, and the end, to its
+	// position in the result.
+	index := make([]int, len(ins)+1)
+	var out []Instr
+	for i := 0; i < len(ins); {
+		index[i] = len(out)
+		in := Instr{Op: Opcode(ins[i])}
+		width := 0
+		if def, ok := definitions[in.Op]; ok {
+			for n, w := range def.OperandWidths {
+				v := 0
+				switch w {
+				case 2:
+					v = int(binary.BigEndian.Uint16(ins[i+1+width:]))
+				case 1:
+					v = int(ins[i+1+width])
+				}
+				if n == 0 {
+					in.A = v
+				} else {
+					in.B = v
+				}
+				width += w
+			}
+		}
+		out = append(out, in)
+		i += 1 + width
+	}
+	index[len(ins)] = len(out)
+
+	for n, in := range out {
+		switch in.Op {
+		case OpJump, OpJumpNotTruthy, OpJumpTruthy, OpJumpNotNull, OpJumpNull, OpCatch:
+			if in.A <= len(ins) {
+				out[n].A = index[in.A]
+			}
+		}
+	}
+	return out
+}
+
+// String formats in as a disassembly line would. Superinstructions show
+// all three operands, in the order fuse.go lists them.
+func (in Instr) String() string {
+	def, ok := definitions[in.Op]
+	switch {
+	case !ok:
+		return fmt.Sprintf("opcode %d", in.Op)
+	case in.Op >= OpAddConstLocal:
+		return fmt.Sprintf("%s %d %d %d", def.Name, in.A, in.B, in.C)
+	case len(def.OperandWidths) == 1:
+		return fmt.Sprintf("%s %d", def.Name, in.A)
+	case len(def.OperandWidths) == 2:
+		return fmt.Sprintf("%s %d %d", def.Name, in.A, in.B)
+	}
+	return def.Name
+}
@@ -0,0 +1,86 @@
+package code
+
+// Fuse rewrites decoded code in place, replacing the first instruction of
+// each sequence below with one superinstruction that does the work of the
+// whole sequence and then skips the rest. The rest stays in place, so a
+// jump into the middle of a sequence still runs it, and the VM can fall
+// back to the original instruction when the operands are not the common
+// case (two integers, say).
+//
+//	GetLocal a, Constant c, Add, SetLocal a             x = x + c     OpAddConstLocal a c 3
+//	GetLocal a, Dup, Constant c, Add, SetLocal a, Pop   x++           OpAddConstLocal a c 5
+//	GetLocal a, GetLocal b, Add, SetLocal a             x = x + y     OpAddLocalLocal a b 3
+//	GetGlobal a, ... the same with SetGlobal                          OpAddConstGlobal a c n
+//	GetLocal a, Index                                   xs[a]         OpIndexLocal a
+//	Constant c, compare, JumpNotTruthy t                if (x == c)   OpCompareConstJump c compare t
+//	GetLocal a, compare, JumpNotTruthy t                if (c > x)    OpCompareLocalJump a compare t
+//
+// A, B and C of a superinstruction hold the operands in the order shown.
+func Fuse(code []Instr) []Instr {
+	for i, in := range code {
+		next := code[i+1:]
+		switch in.Op {
+		case OpGetLocal:
+			if n := addConstLength(next, OpSetLocal, in.A); n > 0 {
+				code[i] = Instr{Op: OpAddConstLocal, A: in.A, B: constOf(next, n), C: n}
+			} else if matches(next, OpGetLocal, OpAdd, OpSetLocal) && next[2].A == in.A {
+				code[i] = Instr{Op: OpAddLocalLocal, A: in.A, B: next[0].A, C: 3}
+			} else if matches(next, OpIndex) {
+				code[i] = Instr{Op: OpIndexLocal, A: in.A}
+			} else if compareJump(next) {
+				code[i] = Instr{Op: OpCompareLocalJump, A: in.A, B: int(next[0].Op), C: next[1].A}
+			}
+		case OpGetGlobal:
+			if n := addConstLength(next, OpSetGlobal, in.A); n > 0 {
+				code[i] = Instr{Op: OpAddConstGlobal, A: in.A, B: constOf(next, n), C: n}
+			}
+		case OpConstant:
+			if compareJump(next) {
+				code[i] = Instr{Op: OpCompareConstJump, A: in.A, B: int(next[0].Op), C: next[1].A}
+			}
+		}
+	}
+	return code
+}
+
+// addConstLength returns how many instructions in code finish adding a
+// constant to the variable read just before it and storing the sum back
+// with set, or 0 if they do not.
+func addConstLength(code []Instr, set Opcode, slot int) int {
+	if matches(code, OpConstant, OpAdd, set) && code[2].A == slot {
+		return 3
+	}
+	if matches(code, OpDup, OpConstant, OpAdd, set, OpPop) && code[3].A == slot {
+		return 5
+	}
+	return 0
+}
+
+// constOf returns the constant index of the sequence addConstLength
+// measured as n instructions long.
+func constOf(code []Instr, n int) int {
+	if n == 3 {
+		return code[0].A
+	}
+	return code[1].A
+}
+
+func compareJump(code []Instr) bool {
+	return matches(code, OpGreaterThan, OpJumpNotTruthy) ||
+		matches(code, OpGreaterEqual, OpJumpNotTruthy) ||
+		matches(code, OpEqual, OpJumpNotTruthy) ||
+		matches(code, OpNotEqual, OpJumpNotTruthy)
+}
+
+// matches reports whether code starts with the given opcodes.
+func matches(code []Instr, ops ...Opcode) bool {
+	if len(code) < len(ops) {
+		return false
+	}
+	for i, op := range ops {
+		if code[i].Op != op {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,43 @@
+package code
+
+import "sort"
+
+// LineEntry starts a run of instructions compiled from one source line, at
+// a byte offset into a function's instructions. File is empty when the
+// source was a single unnamed text.
+type LineEntry struct {
+	Offset int
+	File   string
+	Line   int
+}
+
+// LineTable maps a function's instructions to source lines for stack
+// traces. Entries are in offset order; instructions before the first
+// entry have no known line.
+type LineTable []LineEntry
+
+// Line returns the file and line of the instruction at byte offset; the
+// line is 0 if it is not known.
+func (t LineTable) Line(offset int) (file string, line int) {
+	i := sort.Search(len(t), func(i int) bool { return t[i].Offset > offset })
+	if i == 0 {
+		return "", 0
+	}
+	return t[i-1].File, t[i-1].Line
+}
+
+// Offsets returns the byte offset of each instruction in ins, indexed the
+// way Decode numbers them.
+func Offsets(ins Instructions) []int {
+	var offsets []int
+	for i := 0; i < len(ins); {
+		offsets = append(offsets, i)
+		i++
+		if def, ok := definitions[Opcode(ins[i-1])]; ok {
+			for _, w := range def.OperandWidths {
+				i += w
+			}
+		}
+	}
+	return offsets
+}
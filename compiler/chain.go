@@ -0,0 +1,51 @@
+package compiler
+
+import (
+	"xon/ast"
+	"xon/code"
+)
+
+// Optional chaining. In a chain of member accesses, indexes and calls such
+// as a?.b.c(1)[0], a ?. that finds null skips the rest of the chain, which
+// then evaluates to null. Each ?. emits OpJumpNull; the jumps are collected
+// in chainJumps and pointed at the end of the outermost link once it is
+// compiled. Operands that are not links, such as call arguments or the
+// sides of an infix expression, start chains of their own.
+
+// startChain is called by a chain link before compiling its operands. It
+// reports whether the link starts a new chain, saving the enclosing one.
+func (c *Compiler) startChain() (outer []int, started bool) {
+	if c.continueChain {
+		c.continueChain = false
+		return nil, false
+	}
+	outer, c.chainJumps = c.chainJumps, nil
+	return outer, true
+}
+
+// endChain patches the ?. jumps of a chain started by the link that calls
+// it, once the link is compiled.
+func (c *Compiler) endChain(outer []int, started bool) {
+	if !started {
+		return
+	}
+	for _, pos := range c.chainJumps {
+		c.changeOperand(pos, len(c.currentInstructions()))
+	}
+	c.chainJumps = outer
+}
+
+// compileChainOperand compiles the object, function or indexed value of a
+// link, continuing the chain when it is a link itself.
+func (c *Compiler) compileChainOperand(node ast.Expression) error {
+	switch node.(type) {
+	case *ast.MemberExpression, *ast.CallExpression, *ast.IndexExpression, *ast.SliceExpression:
+		c.continueChain = true
+	}
+	return c.Compile(node)
+}
+
+// optionalJump emits the null check of a ?. on the value just compiled.
+func (c *Compiler) optionalJump() {
+	c.chainJumps = append(c.chainJumps, c.emit(code.OpJumpNull, 9999))
+}
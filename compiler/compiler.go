@@ -1,22 +1,31 @@
 package compiler
 
 import (
+	"fmt"
+	"strings"
 	"xon/ast"
 	"xon/builtins"
 	"xon/code"
 	"xon/object"
-	"fmt"
-	"strings"
+	"xon/token"
 )
 
 type CompilationScope struct {
 	instructions code.Instructions
+	lines        code.LineTable
+	// groups counts the group blocks open in this function, which
+	// return may not leave.
+	groups int
+	// init marks the constructor of a class, which always returns the new
+	// instance, so its body may not return.
+	init bool
 }
 
 type loopContext struct {
 	startPos        int
 	breakPatches    []int
 	continuePatches []int
+	groups          int // open group blocks when the loop started
 }
 
 type Compiler struct {
@@ -25,10 +34,68 @@ type Compiler struct {
 	scopes      []CompilationScope
 	scopeIndex  int
 	loopStack   []loopContext
+
+	// Warnings collects non-fatal diagnostics such as shadowed names.
+	Warnings []Warning
+	// Docs maps documented global names to their /// comments.
+	Docs map[string]string
+	// AllowRedeclare permits `set` of a name already defined in the same
+	// scope; the REPL enables it so lines can be re-entered.
+	AllowRedeclare bool
+
+	// moduleMembers maps the global slot of an imported module to the
+	// slots of its members; see bindModuleMembers.
+	moduleMembers map[int]map[string]int
+	// shadowedBuiltins maps a builtin name hidden by `set` to the index of
+	// its warning, which collects the calls made through the new binding.
+	shadowedBuiltins map[string]int
+	// stdGlobals holds the globals the standard library declared that the
+	// script has not yet redeclared; the script may shadow each one once,
+	// as if the library were an enclosing scope.
+	stdGlobals map[string]bool
+
+	// chainJumps and continueChain track optional chains; see chain.go.
+	chainJumps    []int
+	continueChain bool
+
+	// line is the source line of the node being compiled, recorded in the
+	// scope's line table as instructions are emitted.
+	line int
+	// sources is the file map of the program being compiled, which turns
+	// lines of the joined source into lines of a file.
+	sources token.SourceMap
+}
+
+type Warning struct {
+	File    string // empty unless the program was lexed with lexer.NewChunks
+	Line    int
+	Col     int
+	Message string
+	// ShadowsBuiltin marks a set that hides a builtin; --strict makes
+	// these errors. Calls lists the lines that then call the name.
+	ShadowsBuiltin bool
+	Calls          []int
+}
+
+func (w Warning) String() string {
+	s := fmt.Sprintf("Line %d, Col %d: %s", w.Line, w.Col, w.Message)
+	if len(w.Calls) > 0 {
+		lines := make([]string, len(w.Calls))
+		for i, line := range w.Calls {
+			lines[i] = fmt.Sprint(line)
+		}
+		if len(lines) == 1 {
+			s += "; called on line " + lines[0]
+		} else {
+			s += "; called on lines " + strings.Join(lines, ", ")
+		}
+	}
+	return s
 }
 
 type Bytecode struct {
 	Instructions code.Instructions
+	Lines        code.LineTable
 	Constants    []object.Object
 	SymbolTable  *SymbolTable
 }
@@ -48,11 +115,29 @@ func New() *Compiler {
 		symbolTable: symbolTable,
 		scopes:      []CompilationScope{mainScope},
 		scopeIndex:  0,
+		Docs:        make(map[string]string),
+
+		moduleMembers:    make(map[int]map[string]int),
+		shadowedBuiltins: make(map[string]int),
+		stdGlobals:       make(map[string]bool),
 	}
 }
 
+// GlobalNames returns the name bound to each global slot, by index.
+func (c *Compiler) GlobalNames() []string {
+	return c.symbolTable.SlotNames()
+}
+
+// BindGlobal defines name in a fresh global slot and returns its index. The
+// post-mortem debugger uses it to expose a crashed frame's locals to the
+// lines typed at its prompt.
+func (c *Compiler) BindGlobal(name string) int {
+	return c.symbolTable.Define(name).Index
+}
+
 func (c *Compiler) ResetInstructions() {
 	c.scopes[c.scopeIndex].instructions = code.Instructions{}
+	c.scopes[c.scopeIndex].lines = nil
 }
 
 func (c *Compiler) currentInstructions() code.Instructions {
@@ -78,9 +163,28 @@ func (c *Compiler) leaveScope() code.Instructions {
 	return instructions
 }
 
+// enterBlock opens a lexical block scope. Unlike enterScope it does not
+// start a new function: code keeps going into the current instructions.
+func (c *Compiler) enterBlock() {
+	c.symbolTable = NewBlockSymbolTable(c.symbolTable)
+}
+
+func (c *Compiler) leaveBlock() {
+	c.symbolTable = c.symbolTable.Outer
+}
+
 func (c *Compiler) Compile(node ast.Node) error {
+	// Instructions belong to the innermost node that has a position; a
+	// call spread over several lines is reported on the line it starts.
+	if line := node.Range().Start.Line; line > 0 && line != c.line {
+		outer := c.line
+		c.line = line
+		defer func() { c.line = outer }()
+	}
+
 	switch node := node.(type) {
 	case *ast.Program:
+		c.sources = node.Sources
 		for _, s := range node.Statements {
 			err := c.Compile(s)
 			if err != nil {
@@ -103,6 +207,12 @@ func (c *Compiler) Compile(node ast.Node) error {
 		c.emit(code.OpOut)
 
 	case *ast.ReturnStatement:
+		if c.scopes[c.scopeIndex].groups > 0 {
+			return fmt.Errorf("return inside a group block; return after the group instead")
+		}
+		if c.scopes[c.scopeIndex].init {
+			return c.errorAt(node.Token, "return inside init; calling the class always returns the new instance")
+		}
 		err := c.Compile(node.Value)
 		if err != nil {
 			return err
@@ -124,6 +234,26 @@ func (c *Compiler) Compile(node ast.Node) error {
 
 		c.emit(code.OpSpawn, len(node.Call.Arguments))
 
+	case *ast.GroupStatement:
+		// The VM waits at OpGroupEnd for everything spawned in between, so
+		// the body must run to its end: return, break and continue cannot
+		// jump out of it.
+		c.emit(code.OpGroupStart)
+		c.scopes[c.scopeIndex].groups++
+		err := c.compileScopedBlock(node.Body)
+		c.scopes[c.scopeIndex].groups--
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpGroupEnd)
+
+	case *ast.RequiresStatement:
+		// Checked while compiling, so an old runtime refuses the script
+		// before any of it runs.
+		if err := builtins.CheckRequires(node.Version); err != nil {
+			return err
+		}
+
 	case *ast.ImportStatement:
 		err := c.Compile(node.Path)
 		if err != nil {
@@ -149,30 +279,69 @@ func (c *Compiler) Compile(node ast.Node) error {
 			symbol := c.symbolTable.Define(name)
 			if symbol.Scope == GlobalScope {
 				c.emit(code.OpSetGlobal, symbol.Index)
+				c.bindModuleMembers(symbol, node.Path)
 			} else {
 				c.emit(code.OpSetLocal, symbol.Index)
 			}
 		}
 
 	case *ast.SetStatement:
-		err := c.Compile(node.Value)
+		err := c.checkDeclaration(node.Token, node.Name.Value)
 		if err != nil {
 			return err
 		}
+		// The name is defined before its value is compiled wherever the value
+		// can only mean the new variable, so functions in it (fn literals,
+		// memoize(fn ...)) call themselves through the variable. Functions
+		// inside functions capture variables by value, so only a literal
+		// defines its local early, and it calls itself through its own
+		// closure (see compileFunction).
 		var symbol Symbol
-		if node.IsConst {
-			symbol = c.symbolTable.DefineConst(node.Name.Value)
-		} else {
-			symbol = c.symbolTable.Define(node.Name.Value)
+		_, isFn := node.Value.(*ast.FunctionLiteral)
+		early := (c.symbolTable.slotOwner().Outer == nil || isFn) && !usesDirectly(node.Value, node.Name.Value)
+		define := func() {
+			if node.IsConst {
+				symbol = c.symbolTable.DefineConst(node.Name.Value)
+			} else {
+				symbol = c.symbolTable.Define(node.Name.Value)
+			}
+		}
+		if early {
+			define()
+		}
+		err = c.Compile(node.Value)
+		if err != nil {
+			return err
+		}
+		if node.IsDeep {
+			c.emit(code.OpFreeze)
+		}
+		if !early {
+			define()
 		}
 		if symbol.Scope == GlobalScope {
 			c.emit(code.OpSetGlobal, symbol.Index)
+			if node.Doc != "" {
+				c.Docs[node.Name.Value] = node.Doc
+			}
 		} else if symbol.Scope == LocalScope {
 			c.emit(code.OpSetLocal, symbol.Index)
 		} else if symbol.Scope == FreeScope {
 			c.emit(code.OpSetFree, symbol.Index)
 		}
 
+	case *ast.DestructureStatement:
+		err := c.compileDestructure(node)
+		if err != nil {
+			return err
+		}
+
+	case *ast.ClassStatement:
+		err := c.compileClass(node)
+		if err != nil {
+			return err
+		}
+
 	case *ast.ThrowStatement:
 		err := c.Compile(node.Value)
 		if err != nil {
@@ -186,11 +355,20 @@ func (c *Compiler) Compile(node ast.Node) error {
 			return err
 		}
 		symbol, ok := c.symbolTable.Resolve(node.Name.Value)
+		if ok && symbol.Scope == FunctionScope {
+			// Assigning to a function's own name rebinds the variable it
+			// is stored in; reads after this see that variable too.
+			c.symbolTable.ForgetFunctionName(node.Name.Value)
+			symbol, ok = c.symbolTable.Resolve(node.Name.Value)
+		}
 		if !ok {
-			return fmt.Errorf("undefined variable %s", node.Name.Value)
+			return c.errorAt(node.Name.Token, "undefined variable %s", node.Name.Value)
 		}
 		if symbol.IsConst {
-			return fmt.Errorf("cannot assign to constant %s", node.Name.Value)
+			return c.errorAt(node.Name.Token, "cannot assign to constant %s", node.Name.Value)
+		}
+		if _, isModule := c.moduleMembers[symbol.Index]; isModule && symbol.Scope == GlobalScope {
+			return fmt.Errorf("cannot assign to module %s", node.Name.Value)
 		}
 		if symbol.Scope == GlobalScope {
 			c.emit(code.OpSetGlobal, symbol.Index)
@@ -200,8 +378,15 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emit(code.OpSetFree, symbol.Index)
 		}
 
+	case *ast.IndexAssignStatement:
+		err := c.compileIndexAssign(node)
+		if err != nil {
+			return err
+		}
+
 	case *ast.InfixExpression:
-		if node.Operator == "<" {
+		// a < b and a <= b compile as b > a and b >= a.
+		if node.Operator == "<" || node.Operator == "<=" {
 			err := c.Compile(node.Right)
 			if err != nil {
 				return err
@@ -212,15 +397,23 @@ func (c *Compiler) Compile(node ast.Node) error {
 				return err
 			}
 
-			c.emit(code.OpGreaterThan)
+			if node.Operator == "<" {
+				c.emit(code.OpGreaterThan)
+			} else {
+				c.emit(code.OpGreaterEqual)
+			}
 			return nil
 		}
+		// The short-circuit operators leave the left operand as the result
+		// when they skip the right one.
 		if node.Operator == "&&" {
 			err := c.Compile(node.Left)
 			if err != nil {
 				return err
 			}
+			c.emit(code.OpDup)
 			jumpPos := c.emit(code.OpJumpNotTruthy, 9999)
+			c.emit(code.OpPop)
 			err = c.Compile(node.Right)
 			if err != nil {
 				return err
@@ -234,6 +427,20 @@ func (c *Compiler) Compile(node ast.Node) error {
 				return err
 			}
 			jumpPos := c.emit(code.OpJumpTruthy, 9999)
+			c.emit(code.OpPop)
+			err = c.Compile(node.Right)
+			if err != nil {
+				return err
+			}
+			c.changeOperand(jumpPos, len(c.currentInstructions()))
+			return nil
+		}
+		if node.Operator == "??" {
+			err := c.Compile(node.Left)
+			if err != nil {
+				return err
+			}
+			jumpPos := c.emit(code.OpJumpNotNull, 9999)
 			err = c.Compile(node.Right)
 			if err != nil {
 				return err
@@ -263,8 +470,14 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emit(code.OpDiv)
 		case "%":
 			c.emit(code.OpMod)
+		case "**":
+			c.emit(code.OpPow)
+		case "..":
+			c.emit(code.OpRange)
 		case ">":
 			c.emit(code.OpGreaterThan)
+		case ">=":
+			c.emit(code.OpGreaterEqual)
 		case "==":
 			c.emit(code.OpEqual)
 		case "!=":
@@ -306,10 +519,10 @@ func (c *Compiler) Compile(node ast.Node) error {
 		}
 		symbol, ok := c.symbolTable.Resolve(ident.Value)
 		if !ok {
-			return fmt.Errorf("undefined variable %s", ident.Value)
+			return c.errorAt(ident.Token, "undefined variable %s", ident.Value)
 		}
 		if symbol.IsConst {
-			return fmt.Errorf("cannot modify constant %s", ident.Value)
+			return c.errorAt(ident.Token, "cannot modify constant %s", ident.Value)
 		}
 		c.loadSymbol(symbol)
 		c.emit(code.OpDup)
@@ -365,6 +578,14 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emit(code.OpFalse)
 		}
 
+	case *ast.NullLiteral:
+		c.emit(code.OpNull)
+
+	case *ast.ErrorExpression:
+		// Only in programs with syntax errors, which are reported instead
+		// of run. A null keeps the stack balanced if one is compiled anyway.
+		c.emit(code.OpNull)
+
 	case *ast.ArrayLiteral:
 		for _, el := range node.Elements {
 			err := c.Compile(el)
@@ -394,7 +615,8 @@ func (c *Compiler) Compile(node ast.Node) error {
 		c.emit(code.OpHash, len(keys)*2)
 
 	case *ast.IndexExpression:
-		err := c.Compile(node.Left)
+		outer, started := c.startChain()
+		err := c.compileChainOperand(node.Left)
 		if err != nil {
 			return err
 		}
@@ -403,59 +625,79 @@ func (c *Compiler) Compile(node ast.Node) error {
 			return err
 		}
 		c.emit(code.OpIndex)
+		c.endChain(outer, started)
+
+	case *ast.SliceExpression:
+		// A missing bound is pushed as null.
+		outer, started := c.startChain()
+		err := c.compileChainOperand(node.Left)
+		if err != nil {
+			return err
+		}
+		for _, bound := range []ast.Expression{node.Low, node.High} {
+			if bound == nil {
+				c.emit(code.OpNull)
+				continue
+			}
+			if err := c.Compile(bound); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpSlice)
+		c.endChain(outer, started)
 
 	case *ast.MemberExpression:
-		err := c.Compile(node.Object)
+		outer, started := c.startChain()
+		if module, ok := node.Object.(*ast.Identifier); ok && !node.Optional {
+			if slot, ok := c.moduleMemberSlot(module, node.Member.Value); ok {
+				c.emit(code.OpGetGlobal, slot)
+				c.endChain(outer, started)
+				return nil
+			}
+		}
+		err := c.compileChainOperand(node.Object)
 		if err != nil {
 			return err
 		}
+		if node.Optional {
+			c.optionalJump()
+		}
 		memberStr := &object.String{Value: node.Member.Value}
 		c.emit(code.OpMember, c.addConstant(memberStr))
+		c.endChain(outer, started)
 
 	case *ast.Identifier:
 		symbol, ok := c.symbolTable.Resolve(node.Value)
 		if !ok {
-			return fmt.Errorf("undefined variable %s", node.Value)
+			return c.errorAt(node.Token, "undefined variable %s", node.Value)
 		}
 		c.loadSymbol(symbol)
 
 	case *ast.FunctionLiteral:
-		c.enterScope()
-
-		for _, p := range node.Parameters {
-			c.symbolTable.Define(p.Value)
-		}
-
-		err := c.Compile(node.Body)
+		err := c.compileFunction(node, "")
 		if err != nil {
 			return err
 		}
 
-		// If the last instruction isn't a return, add implicit return null
-		ins := c.currentInstructions()
-		if len(ins) == 0 || ins[len(ins)-1] != byte(code.OpReturnValue) {
-			c.emit(code.OpReturn)
-		}
-
-		numLocals := c.symbolTable.numDefinitions
-		freeSymbols := c.symbolTable.FreeSymbols
-		instructions := c.leaveScope()
-
-		for _, s := range freeSymbols {
-			c.loadSymbol(s)
+	case *ast.CallExpression:
+		outer, started := c.startChain()
+		if ident, ok := node.Function.(*ast.Identifier); ok {
+			c.noteShadowedCall(ident)
 		}
-
-		compiledFn := &object.CompiledFunction{
-			Instructions:  instructions,
-			NumLocals:     numLocals,
-			NumParameters: len(node.Parameters),
+		// x.push(v) appends in place without materializing the method.
+		if member, ok := node.Function.(*ast.MemberExpression); ok && member.Member.Value == "push" && len(node.Arguments) == 1 && !member.Optional {
+			if err := c.compileChainOperand(member.Object); err != nil {
+				return err
+			}
+			if err := c.Compile(node.Arguments[0]); err != nil {
+				return err
+			}
+			c.emit(code.OpAppend)
+			c.endChain(outer, started)
+			return nil
 		}
 
-		fnIndex := c.addConstant(compiledFn)
-		c.emit(code.OpClosure, fnIndex, len(freeSymbols))
-
-	case *ast.CallExpression:
-		err := c.Compile(node.Function)
+		err := c.compileChainOperand(node.Function)
 		if err != nil {
 			return err
 		}
@@ -468,37 +710,40 @@ func (c *Compiler) Compile(node ast.Node) error {
 		}
 
 		c.emit(code.OpCall, len(node.Arguments))
+		c.endChain(outer, started)
 
 	case *ast.TryExpression:
+		// Both paths leave exactly one value, null when the block ends in
+		// a statement, so a try used as a statement has one to pop.
 		catchEmitPos := c.emit(code.OpCatch, 9999)
-		err := c.compileBlockPreservingLast(node.Block)
+		c.enterBlock()
+		err := c.compileBlockValue(node.Block)
+		c.leaveBlock()
 		if err != nil {
 			return err
 		}
 		c.emit(code.OpEndCatch)
 		jumpOverPos := c.emit(code.OpJump, 9999)
 		catchProloguePos := len(c.currentInstructions())
+		c.enterBlock()
 		if node.CatchParameter != nil {
-			c.enterScope()
 			paramSym := c.symbolTable.Define(node.CatchParameter.Value)
-			c.emit(code.OpSetLocal, paramSym.Index)
+			c.storeSymbol(paramSym)
 		} else {
 			c.emit(code.OpPop)
 		}
-		err = c.compileBlockPreservingLast(node.CatchBlock)
+		err = c.compileBlockValue(node.CatchBlock)
+		c.leaveBlock()
 		if err != nil {
-			if node.CatchParameter != nil {
-				c.leaveScope()
-			}
 			return err
 		}
-		if node.CatchParameter != nil {
-			c.leaveScope()
-		}
 		afterCatchPos := len(c.currentInstructions())
 		c.changeOperand(catchEmitPos, catchProloguePos)
 		c.changeOperand(jumpOverPos, afterCatchPos)
 
+	case *ast.MatchExpression:
+		return c.compileMatch(node)
+
 	case *ast.IfStatement:
 		err := c.Compile(node.Condition)
 		if err != nil {
@@ -507,7 +752,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 
 		jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
 
-		err = c.Compile(node.Consequence)
+		err = c.compileScopedBlock(node.Consequence)
 		if err != nil {
 			return err
 		}
@@ -521,7 +766,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 			afterConsequencePos := len(c.currentInstructions())
 			c.changeOperand(jumpNotTruthyPos, afterConsequencePos)
 
-			err = c.Compile(node.Alternative)
+			err = c.compileScopedBlock(node.Alternative)
 			if err != nil {
 				return err
 			}
@@ -532,7 +777,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 
 	case *ast.WhileStatement:
 		beforeLoopPos := len(c.currentInstructions())
-		c.loopStack = append(c.loopStack, loopContext{startPos: beforeLoopPos})
+		c.loopStack = append(c.loopStack, loopContext{startPos: beforeLoopPos, groups: c.scopes[c.scopeIndex].groups})
 
 		err := c.Compile(node.Condition)
 		if err != nil {
@@ -542,7 +787,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 
 		jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
 
-		err = c.Compile(node.Body)
+		err = c.compileScopedBlock(node.Body)
 		if err != nil {
 			c.loopStack = c.loopStack[:len(c.loopStack)-1]
 			return err
@@ -555,7 +800,32 @@ func (c *Compiler) Compile(node ast.Node) error {
 		c.patchLoopExits(afterBodyPos)
 		c.loopStack = c.loopStack[:len(c.loopStack)-1]
 
+	case *ast.DoWhileStatement:
+		// continue jumps to the condition, which is patched in below.
+		bodyPos := len(c.currentInstructions())
+		c.loopStack = append(c.loopStack, loopContext{groups: c.scopes[c.scopeIndex].groups})
+
+		err := c.compileScopedBlock(node.Body)
+		if err != nil {
+			c.loopStack = c.loopStack[:len(c.loopStack)-1]
+			return err
+		}
+
+		c.loopStack[len(c.loopStack)-1].startPos = len(c.currentInstructions())
+		err = c.Compile(node.Condition)
+		if err != nil {
+			c.loopStack = c.loopStack[:len(c.loopStack)-1]
+			return err
+		}
+		c.emit(code.OpJumpTruthy, bodyPos)
+
+		c.patchLoopExits(len(c.currentInstructions()))
+		c.loopStack = c.loopStack[:len(c.loopStack)-1]
+
 	case *ast.ForStatement:
+		// The loop variable lives in its own block around init, body and update.
+		c.enterBlock()
+		defer c.leaveBlock()
 		if node.Init != nil {
 			err := c.Compile(node.Init)
 			if err != nil {
@@ -563,7 +833,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 			}
 		}
 		beforeCondPos := len(c.currentInstructions())
-		c.loopStack = append(c.loopStack, loopContext{startPos: beforeCondPos})
+		c.loopStack = append(c.loopStack, loopContext{startPos: beforeCondPos, groups: c.scopes[c.scopeIndex].groups})
 
 		err := c.Compile(node.Condition)
 		if err != nil {
@@ -572,11 +842,13 @@ func (c *Compiler) Compile(node ast.Node) error {
 		}
 		jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
 
-		err = c.Compile(node.Body)
+		err = c.compileScopedBlock(node.Body)
 		if err != nil {
 			c.loopStack = c.loopStack[:len(c.loopStack)-1]
 			return err
 		}
+		// continue runs the update before re-checking the condition.
+		c.loopStack[len(c.loopStack)-1].startPos = len(c.currentInstructions())
 		if node.Update != nil {
 			err = c.Compile(node.Update)
 			if err != nil {
@@ -591,61 +863,64 @@ func (c *Compiler) Compile(node ast.Node) error {
 		c.loopStack = c.loopStack[:len(c.loopStack)-1]
 
 	case *ast.ForInStatement:
-		c.enterScope()
+		// Hidden iterator state and the loop variable are scoped to the loop.
+		c.enterBlock()
+		defer c.leaveBlock()
 		err := c.Compile(node.Iterable)
 		if err != nil {
-			c.leaveScope()
 			return err
 		}
 		iterSym := c.symbolTable.Define("__for_iter")
-		c.emit(code.OpSetLocal, iterSym.Index)
+		c.storeSymbol(iterSym)
 		idxSym := c.symbolTable.Define("__for_idx")
-		c.symbolTable.Define(node.Variable.Value)
+		loopVarSym := c.symbolTable.Define(node.Variable.Value)
 		c.emit(code.OpConstant, c.addConstant(&object.Integer{Value: 0}))
-		c.emit(code.OpSetLocal, idxSym.Index)
+		c.storeSymbol(idxSym)
 
+		// continue jumps to the increment, which is patched in below.
+		c.loopStack = append(c.loopStack, loopContext{groups: c.scopes[c.scopeIndex].groups})
 		beforeLoopPos := len(c.currentInstructions())
-		c.loopStack = append(c.loopStack, loopContext{startPos: beforeLoopPos})
 
 		// condition: __for_idx < __for_iter.len()
-		c.emit(code.OpGetLocal, iterSym.Index)
+		c.loadSymbol(iterSym)
 		c.emit(code.OpMember, c.addConstant(&object.String{Value: "len"}))
 		c.emit(code.OpCall, 0)
-		c.emit(code.OpGetLocal, idxSym.Index)
+		c.loadSymbol(idxSym)
 		c.emit(code.OpGreaterThan) // length > index  =>  index < length
 		jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
 
 		// loop var = iterable[index]
-		c.emit(code.OpGetLocal, iterSym.Index)
-		c.emit(code.OpGetLocal, idxSym.Index)
+		c.loadSymbol(iterSym)
+		c.loadSymbol(idxSym)
 		c.emit(code.OpIndex)
-		loopVarSym, _ := c.symbolTable.Resolve(node.Variable.Value)
-		c.emit(code.OpSetLocal, loopVarSym.Index)
+		c.storeSymbol(loopVarSym)
 
-		err = c.Compile(node.Body)
+		err = c.compileScopedBlock(node.Body)
 		if err != nil {
 			c.loopStack = c.loopStack[:len(c.loopStack)-1]
-			c.leaveScope()
 			return err
 		}
 
 		// index++
-		c.emit(code.OpGetLocal, idxSym.Index)
+		c.loopStack[len(c.loopStack)-1].startPos = len(c.currentInstructions())
+		c.loadSymbol(idxSym)
 		c.emit(code.OpConstant, c.addConstant(&object.Integer{Value: 1}))
 		c.emit(code.OpAdd)
-		c.emit(code.OpSetLocal, idxSym.Index)
+		c.storeSymbol(idxSym)
 
 		c.emit(code.OpJump, beforeLoopPos)
 		afterBodyPos := len(c.currentInstructions())
 		c.changeOperand(jumpNotTruthyPos, afterBodyPos)
 		c.patchLoopExits(afterBodyPos)
 		c.loopStack = c.loopStack[:len(c.loopStack)-1]
-		c.leaveScope()
 
 	case *ast.BreakStatement:
 		if len(c.loopStack) == 0 {
 			return fmt.Errorf("break outside of loop")
 		}
+		if c.leavesGroup() {
+			return fmt.Errorf("break cannot leave a group block")
+		}
 		pos := c.emit(code.OpJump, 9999)
 		c.loopStack[len(c.loopStack)-1].breakPatches = append(c.loopStack[len(c.loopStack)-1].breakPatches, pos)
 
@@ -653,6 +928,9 @@ func (c *Compiler) Compile(node ast.Node) error {
 		if len(c.loopStack) == 0 {
 			return fmt.Errorf("continue outside of loop")
 		}
+		if c.leavesGroup() {
+			return fmt.Errorf("continue cannot leave a group block")
+		}
 		pos := c.emit(code.OpJump, 9999)
 		c.loopStack[len(c.loopStack)-1].continuePatches = append(c.loopStack[len(c.loopStack)-1].continuePatches, pos)
 
@@ -663,6 +941,10 @@ func (c *Compiler) Compile(node ast.Node) error {
 				return err
 			}
 		}
+
+	case *ast.PipeExpression:
+		// Emitting nothing here would leave its consumer popping an empty stack.
+		return c.errorAt(node.Token, "the |> operator is not supported by the compiler yet")
 	}
 
 	return nil
@@ -678,12 +960,473 @@ func (c *Compiler) loadSymbol(s Symbol) {
 		c.emit(code.OpGetBuiltin, s.Index)
 	case FreeScope:
 		c.emit(code.OpGetFree, s.Index)
+	case FunctionScope:
+		c.emit(code.OpCurrentClosure)
+	}
+}
+
+// errorAt returns a compile error at tok's position in its file.
+func (c *Compiler) errorAt(tok token.Token, format string, args ...interface{}) error {
+	return fmt.Errorf("%s: %s", c.sources.Position(tok.Line, tok.Col), fmt.Sprintf(format, args...))
+}
+
+// checkDeclaration rejects `set` of a name already declared in the current
+// scope and warns when it shadows a name from an enclosing scope. Globals of
+// the standard library count as enclosing: the script's own `set time = 5`
+// hides the library's time, whose functions keep using their own slot.
+func (c *Compiler) checkDeclaration(tok token.Token, name string) error {
+	file, line := c.sources.Locate(tok.Line)
+	if c.symbolTable.DefinedHere(name) {
+		if c.AllowRedeclare {
+			return nil
+		}
+		if c.stdGlobals[name] && file != builtins.StdLibFile {
+			delete(c.stdGlobals, name)
+			c.Warnings = append(c.Warnings, Warning{
+				File:    file,
+				Line:    line,
+				Col:     tok.Col,
+				Message: fmt.Sprintf("set %s shadows %s from the standard library", name, name),
+			})
+			return nil
+		}
+		return c.errorAt(tok, "%s is already defined in this scope; use `%s = ...` to reassign it", name, name)
 	}
+	if file == builtins.StdLibFile && c.symbolTable.Outer == nil {
+		c.stdGlobals[name] = true
+	}
+	if c.symbolTable.IsBuiltin(name) {
+		msg := fmt.Sprintf("set %s shadows the builtin %s", name, name)
+		if info, ok := builtins.LookupInfo(name); ok {
+			msg = fmt.Sprintf("set %s shadows the builtin %s", name, info.Signature)
+		}
+		c.shadowedBuiltins[name] = len(c.Warnings)
+		c.Warnings = append(c.Warnings, Warning{
+			File:           file,
+			Line:           line,
+			Col:            tok.Col,
+			Message:        msg,
+			ShadowsBuiltin: true,
+		})
+	} else if outer, ok := c.symbolTable.LookupOuter(name); ok {
+		where := "an enclosing scope"
+		if outer.Scope == GlobalScope {
+			where = "the global scope"
+		}
+		c.Warnings = append(c.Warnings, Warning{
+			File:    file,
+			Line:    line,
+			Col:     tok.Col,
+			Message: fmt.Sprintf("set %s shadows %s from %s", name, name, where),
+		})
+	}
+	return nil
+}
+
+// compileMatch tests the cases in order against the value, kept in a
+// hidden local, and leaves the taken body's last expression on the stack,
+// or null when no case matches. The pattern _ matches anything, a bare
+// identifier matches anything and binds the value for the guard and body,
+// and any other pattern is compared with ==.
+func (c *Compiler) compileMatch(node *ast.MatchExpression) error {
+	c.enterBlock()
+	defer c.leaveBlock()
+	if err := c.Compile(node.Value); err != nil {
+		return err
+	}
+	valueSym := c.symbolTable.Define("__match")
+	c.storeSymbol(valueSym)
+
+	var endJumps []int
+	for _, mc := range node.Cases {
+		c.enterBlock()
+		var nextJumps []int
+		if ident, ok := mc.Pattern.(*ast.Identifier); ok {
+			if ident.Value != "_" {
+				c.loadSymbol(valueSym)
+				c.storeSymbol(c.symbolTable.Define(ident.Value))
+			}
+		} else {
+			c.loadSymbol(valueSym)
+			if err := c.Compile(mc.Pattern); err != nil {
+				c.leaveBlock()
+				return err
+			}
+			c.emit(code.OpEqual)
+			nextJumps = append(nextJumps, c.emit(code.OpJumpNotTruthy, 9999))
+		}
+		if mc.Guard != nil {
+			if err := c.Compile(mc.Guard); err != nil {
+				c.leaveBlock()
+				return err
+			}
+			nextJumps = append(nextJumps, c.emit(code.OpJumpNotTruthy, 9999))
+		}
+		err := c.compileBlockValue(mc.Body)
+		c.leaveBlock()
+		if err != nil {
+			return err
+		}
+		endJumps = append(endJumps, c.emit(code.OpJump, 9999))
+		for _, pos := range nextJumps {
+			c.changeOperand(pos, len(c.currentInstructions()))
+		}
+	}
+	c.emit(code.OpNull)
+	for _, pos := range endJumps {
+		c.changeOperand(pos, len(c.currentInstructions()))
+	}
+	return nil
+}
+
+// compileBlockValue compiles a block for its value: its last expression,
+// or null when the block is empty or ends in a statement.
+func (c *Compiler) compileBlockValue(block *ast.BlockStatement) error {
+	stmts := block.Statements
+	if len(stmts) > 0 {
+		if _, ok := stmts[len(stmts)-1].(*ast.ExpressionStatement); ok {
+			return c.compileBlockPreservingLast(block)
+		}
+	}
+	if err := c.Compile(block); err != nil {
+		return err
+	}
+	c.emit(code.OpNull)
+	return nil
+}
+
+// compileFunction compiles a function literal and emits its closure. A
+// named function inside another function can call itself by that name,
+// which is bound to the running closure; at the top level the name is the
+// global variable. For a method of class, self is an implicit first
+// parameter and the function is named Class.method in stack traces; its
+// bare name is not bound inside it, as calling the method that way would
+// leave out self.
+func (c *Compiler) compileFunction(node *ast.FunctionLiteral, class string) error {
+	method := class != "" || c.usesFreeSelf(node)
+	global := c.symbolTable.slotOwner().Outer == nil
+	c.enterScope()
+
+	name, numParameters := node.Name, len(node.Parameters)
+	if class != "" {
+		name = class + "." + node.Name
+	}
+	if method {
+		numParameters++
+		c.symbolTable.Define("self")
+	} else if node.Name != "" && !global {
+		c.symbolTable.DefineFunctionName(node.Name)
+	}
+
+	for _, p := range node.Parameters {
+		if class != "" && p.Value == "self" {
+			return c.errorAt(p.Token, "self is passed to %s implicitly; remove it from the parameters", name)
+		}
+		c.symbolTable.Define(p.Value)
+	}
+
+	err := c.Compile(node.Body)
+	if err != nil {
+		return err
+	}
+
+	// If the last instruction isn't a return, add implicit return null
+	ins := c.currentInstructions()
+	if len(ins) == 0 || ins[len(ins)-1] != byte(code.OpReturnValue) {
+		c.emit(code.OpReturn)
+	}
+	c.closeFunction(name, node.Doc, numParameters).Method = method
+	return nil
+}
+
+// usesFreeSelf reports whether node refers to a self that nothing in
+// scope declares. Such a function is a method: it takes self as a hidden
+// first parameter, which reading it from a hash (h.greet) binds to h.
+func (c *Compiler) usesFreeSelf(node *ast.FunctionLiteral) bool {
+	if c.symbolTable.DefinedHere("self") {
+		return false
+	}
+	if _, ok := c.symbolTable.LookupOuter("self"); ok {
+		return false
+	}
+	f := &selfFinder{}
+	ast.Walk(f, node)
+	return f.used && !f.declared
+}
+
+// usesDirectly reports whether node reads name other than from inside a
+// function literal, as `x + 1` does in `set x = x + 1`.
+func usesDirectly(node ast.Node, name string) bool {
+	f := &nameFinder{name: name}
+	ast.Walk(f, node)
+	return f.used
+}
+
+type nameFinder struct {
+	name string
+	used bool
+}
+
+func (f *nameFinder) Visit(node ast.Node) ast.Visitor {
+	switch n := node.(type) {
+	case *ast.Identifier:
+		f.used = f.used || n.Value == f.name
+	case *ast.MemberExpression:
+		ast.Walk(f, n.Object)
+		return nil
+	case *ast.FunctionLiteral:
+		return nil
+	}
+	return f
+}
+
+// selfFinder looks through a function for uses of self, skipping member
+// names (x.self) and nested functions with a self parameter of their own.
+type selfFinder struct {
+	used, declared bool
+}
+
+func (f *selfFinder) Visit(node ast.Node) ast.Visitor {
+	switch n := node.(type) {
+	case *ast.Identifier:
+		if n.Value == "self" {
+			f.used = true
+		}
+	case *ast.MemberExpression:
+		ast.Walk(f, n.Object)
+		return nil
+	case *ast.FunctionLiteral:
+		for _, p := range n.Parameters {
+			if p.Value == "self" {
+				return nil
+			}
+		}
+	case *ast.SetStatement:
+		f.declared = f.declared || n.Name.Value == "self"
+	}
+	return f
+}
+
+// closeFunction leaves the scope of the function just compiled and emits
+// the closure over it, loading the variables it captures.
+func (c *Compiler) closeFunction(name, doc string, numParameters int) *object.CompiledFunction {
+	numLocals := c.symbolTable.numDefinitions
+	localNames := c.symbolTable.SlotNames()
+	freeSymbols := c.symbolTable.FreeSymbols
+	lines := c.scopes[c.scopeIndex].lines
+	instructions := c.leaveScope()
+
+	freeNames := make([]string, len(freeSymbols))
+	for i, s := range freeSymbols {
+		freeNames[i] = s.Name
+	}
+
+	for _, s := range freeSymbols {
+		c.loadSymbol(s)
+	}
+
+	compiledFn := &object.CompiledFunction{
+		Instructions:  instructions,
+		Lines:         lines,
+		NumLocals:     numLocals,
+		NumParameters: numParameters,
+		Name:          name,
+		Doc:           doc,
+		LocalNames:    localNames,
+		FreeNames:     freeNames,
+	}
+
+	fnIndex := c.addConstant(compiledFn)
+	c.emit(code.OpClosure, fnIndex, len(freeSymbols))
+	return compiledFn
+}
+
+// compileClass pushes the name and closure of each method, then the
+// constructor, which OpClass gathers into a Class bound to the class name
+// as a constant. A global class is declared first so its methods can make
+// instances of it; one inside a function is not visible in its methods, as
+// they capture its variable before it is set.
+func (c *Compiler) compileClass(node *ast.ClassStatement) error {
+	name := node.Name.Value
+	if err := c.checkDeclaration(node.Name.Token, name); err != nil {
+		return err
+	}
+	var symbol Symbol
+	global := c.scopeIndex == 0
+	if global {
+		symbol = c.symbolTable.DefineConst(name)
+	}
+
+	methods := 0
+	for _, m := range node.Methods {
+		if m.Name == "init" {
+			continue
+		}
+		c.emit(code.OpString, c.addConstant(&object.String{Value: m.Name}))
+		if err := c.compileFunction(m, name); err != nil {
+			return err
+		}
+		methods++
+	}
+	if err := c.compileConstructor(node); err != nil {
+		return err
+	}
+
+	fields := make([]string, len(node.Fields))
+	for i, f := range node.Fields {
+		fields[i] = f.Name.Value
+	}
+	c.emit(code.OpClass, c.addConstant(&object.Class{Name: name, Fields: fields}), methods)
+
+	if !global {
+		symbol = c.symbolTable.DefineConst(name)
+	}
+	c.storeSymbol(symbol)
+	if global && node.Doc != "" {
+		c.Docs[name] = node.Doc
+	}
+	return nil
+}
+
+// compileConstructor emits the Init of a class: it takes self and the
+// parameters of the init method, sets each field to its default, which is
+// evaluated again for every instance, runs the body of init and returns
+// self.
+func (c *Compiler) compileConstructor(node *ast.ClassStatement) error {
+	c.enterScope()
+	self := c.symbolTable.Define("self")
+	name, doc := node.Name.Value, node.Doc
+	init := node.Init()
+	var params []*ast.Identifier
+	if init != nil {
+		params, doc = init.Parameters, init.Doc
+	}
+	for _, p := range params {
+		if p.Value == "self" {
+			return c.errorAt(p.Token, "self is passed to %s.init implicitly; remove it from the parameters", name)
+		}
+		c.symbolTable.Define(p.Value)
+	}
+
+	for _, f := range node.Fields {
+		c.loadSymbol(self)
+		if f.Value == nil {
+			c.emit(code.OpNull)
+		} else if err := c.Compile(f.Value); err != nil {
+			return err
+		}
+		c.emit(code.OpSetMember, c.addConstant(&object.String{Value: f.Name.Value}))
+	}
+	if init != nil {
+		c.scopes[c.scopeIndex].init = true
+		if err := c.Compile(init.Body); err != nil {
+			return err
+		}
+	}
+	c.loadSymbol(self)
+	c.emit(code.OpReturnValue)
+	c.closeFunction(name+".init", doc, len(params)+1)
+	return nil
+}
+
+// compileDestructure evaluates the value once, keeps it on the stack while
+// each name takes its element or key, then drops it.
+func (c *Compiler) compileDestructure(node *ast.DestructureStatement) error {
+	for _, name := range node.Names {
+		if err := c.checkDeclaration(name.Token, name.Value); err != nil {
+			return err
+		}
+	}
+	if err := c.Compile(node.Value); err != nil {
+		return err
+	}
+	if node.IsDeep {
+		c.emit(code.OpFreeze)
+	}
+	for i, name := range node.Names {
+		c.emit(code.OpDup)
+		if node.IsHash {
+			c.emit(code.OpConstant, c.addConstant(&object.String{Value: node.Keys[i]}))
+		} else {
+			c.emit(code.OpConstant, c.addConstant(&object.Integer{Value: int64(i)}))
+		}
+		c.emit(code.OpIndex)
+		var symbol Symbol
+		if node.IsConst {
+			symbol = c.symbolTable.DefineConst(name.Value)
+		} else {
+			symbol = c.symbolTable.Define(name.Value)
+		}
+		c.storeSymbol(symbol)
+	}
+	c.emit(code.OpPop)
+	return nil
+}
+
+// compileIndexAssign pushes the container, the index (members use a
+// constant operand instead) and the value, then stores in place.
+func (c *Compiler) compileIndexAssign(node *ast.IndexAssignStatement) error {
+	switch target := node.Target.(type) {
+	case *ast.IndexExpression:
+		if module, ok := target.Left.(*ast.Identifier); ok && c.isModule(module) {
+			return fmt.Errorf("cannot assign to %s[%s]: module members are read-only", module.Value, target.Index.String())
+		}
+		if err := c.Compile(target.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(target.Index); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		c.emit(code.OpSetIndex)
+	case *ast.MemberExpression:
+		if module, ok := target.Object.(*ast.Identifier); ok && c.isModule(module) {
+			return fmt.Errorf("cannot assign to %s.%s: module members are read-only", module.Value, target.Member.Value)
+		}
+		if err := c.Compile(target.Object); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		c.emit(code.OpSetMember, c.addConstant(&object.String{Value: target.Member.Value}))
+	default:
+		return fmt.Errorf("cannot assign to %s", node.Target.String())
+	}
+	return nil
+}
+
+// storeSymbol pops the top of the stack into the variable s refers to.
+func (c *Compiler) storeSymbol(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(code.OpSetGlobal, s.Index)
+	case LocalScope:
+		c.emit(code.OpSetLocal, s.Index)
+	case FreeScope:
+		c.emit(code.OpSetFree, s.Index)
+	}
+}
+
+// compileScopedBlock compiles the body of an if/while/for in its own block scope.
+// leavesGroup reports whether a jump out of the innermost loop would skip
+// the end of a group block.
+func (c *Compiler) leavesGroup() bool {
+	return c.scopes[c.scopeIndex].groups > c.loopStack[len(c.loopStack)-1].groups
+}
+
+func (c *Compiler) compileScopedBlock(block *ast.BlockStatement) error {
+	c.enterBlock()
+	defer c.leaveBlock()
+	return c.Compile(block)
 }
 
 func (c *Compiler) Bytecode() *Bytecode {
 	return &Bytecode{
 		Instructions: c.currentInstructions(),
+		Lines:        c.scopes[c.scopeIndex].lines,
 		Constants:    c.constants,
 		SymbolTable:  c.symbolTable,
 	}
@@ -702,11 +1445,30 @@ func (c *Compiler) emit(op code.Opcode, operands ...int) int {
 
 func (c *Compiler) addInstruction(ins []byte) int {
 	posNewInstruction := len(c.currentInstructions())
+	c.noteLine(posNewInstruction)
 	updatedInstructions := append(c.currentInstructions(), ins...)
 	c.scopes[c.scopeIndex].instructions = updatedInstructions
 	return posNewInstruction
 }
 
+// noteLine starts a new line table entry at pos when the current line
+// differs from that of the instruction before it.
+func (c *Compiler) noteLine(pos int) {
+	if c.line == 0 {
+		return
+	}
+	file, line := c.sources.Locate(c.line)
+	scope := &c.scopes[c.scopeIndex]
+	n := len(scope.lines)
+	switch {
+	case n > 0 && scope.lines[n-1].Line == line && scope.lines[n-1].File == file:
+	case n > 0 && scope.lines[n-1].Offset == pos:
+		scope.lines[n-1].File, scope.lines[n-1].Line = file, line
+	default:
+		scope.lines = append(scope.lines, code.LineEntry{Offset: pos, File: file, Line: line})
+	}
+}
+
 func (c *Compiler) changeOperand(opPos int, operand int) {
 	op := code.Opcode(c.currentInstructions()[opPos])
 
@@ -746,3 +1508,14 @@ func (c *Compiler) patchLoopExits(afterPos int) {
 		c.changeOperand(pos, ctx.startPos)
 	}
 }
+
+// noteShadowedCall records a call through a name that a `set` took from a
+// builtin on that set's warning.
+func (c *Compiler) noteShadowedCall(ident *ast.Identifier) {
+	i, ok := c.shadowedBuiltins[ident.Value]
+	if !ok || c.symbolTable.IsBuiltin(ident.Value) {
+		return
+	}
+	_, line := c.sources.Locate(ident.Token.Line)
+	c.Warnings[i].Calls = append(c.Warnings[i].Calls, line)
+}
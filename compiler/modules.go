@@ -0,0 +1,117 @@
+package compiler
+
+import (
+	"strings"
+	"xon/ast"
+	"xon/builtins"
+	"xon/code"
+	"xon/lexer"
+	"xon/object"
+	"xon/parser"
+)
+
+// bindModuleMembers gives each top-level name of the module imported as the
+// global module its own global slot, filled right after the import, so that
+// module.name compiles to a single OpGetGlobal instead of an OpMember hash
+// lookup. A slot is a copy, so names the module assigns after declaring
+// them, such as a counter, keep the lookup, which reads the module's current
+// value. Modules whose path is not a literal or whose source cannot be read
+// or parsed here keep the dynamic lookup; the import itself reports why at
+// run time.
+func (c *Compiler) bindModuleMembers(module Symbol, path ast.Expression) {
+	str, ok := path.(*ast.StringLiteral)
+	if !ok {
+		return
+	}
+	modulePath := str.Value
+	if !strings.HasSuffix(modulePath, ".xn") {
+		modulePath += ".xn"
+	}
+	source, err := builtins.LoadModule(modulePath)
+	if err != nil {
+		return
+	}
+	p := parser.New(lexer.New(source))
+	program := p.ParseProgram()
+	if len(p.Errors) > 0 {
+		return
+	}
+
+	assigned := assignedNames(program)
+	members := make(map[string]int)
+	for _, name := range moduleGlobalNames(program) {
+		if _, seen := members[name]; seen || assigned[name] {
+			continue
+		}
+		slot := c.symbolTable.ReserveSlot(module.Name + "." + name)
+		c.emit(code.OpGetGlobal, module.Index)
+		c.emit(code.OpMember, c.addConstant(&object.String{Value: name}))
+		c.emit(code.OpSetGlobal, slot)
+		members[name] = slot
+	}
+	c.moduleMembers[module.Index] = members
+}
+
+// moduleGlobalNames lists the names a module declares at its top level.
+func moduleGlobalNames(program *ast.Program) []string {
+	var names []string
+	for _, stmt := range program.Statements {
+		switch s := stmt.(type) {
+		case *ast.SetStatement:
+			names = append(names, s.Name.Value)
+		case *ast.ClassStatement:
+			names = append(names, s.Name.Value)
+		case *ast.DestructureStatement:
+			for _, name := range s.Names {
+				names = append(names, name.Value)
+			}
+		case *ast.ImportStatement:
+			if s.Alias != nil {
+				names = append(names, s.Alias.Value)
+			} else if str, ok := s.Path.(*ast.StringLiteral); ok {
+				parts := strings.Split(strings.TrimSuffix(str.Value, ".xn"), "/")
+				names = append(names, parts[len(parts)-1])
+			}
+		}
+	}
+	return names
+}
+
+// assignedNames lists the names program assigns anywhere with =, an
+// operator assignment or ++/--, in any scope.
+func assignedNames(program *ast.Program) map[string]bool {
+	names := make(map[string]bool)
+	ast.Inspect(program, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.AssignStatement:
+			names[n.Name.Value] = true
+		case *ast.PostfixExpression:
+			if ident, ok := n.Left.(*ast.Identifier); ok {
+				names[ident.Value] = true
+			}
+		}
+		return true
+	})
+	return names
+}
+
+// moduleMemberSlot returns the global slot holding module.member when
+// module names an import bound by bindModuleMembers.
+func (c *Compiler) moduleMemberSlot(module *ast.Identifier, member string) (int, bool) {
+	symbol, ok := c.symbolTable.Resolve(module.Value)
+	if !ok || symbol.Scope != GlobalScope {
+		return 0, false
+	}
+	slot, ok := c.moduleMembers[symbol.Index][member]
+	return slot, ok
+}
+
+// isModule reports whether ident names an import bound by bindModuleMembers.
+func (c *Compiler) isModule(ident *ast.Identifier) bool {
+	symbol, ok := c.symbolTable.Resolve(ident.Value)
+	if !ok || symbol.Scope != GlobalScope {
+		return false
+	}
+	_, ok = c.moduleMembers[symbol.Index]
+	return ok
+}
@@ -3,10 +3,11 @@ package compiler
 type SymbolScope string
 
 const (
-	GlobalScope  SymbolScope = "GLOBAL"
-	LocalScope   SymbolScope = "LOCAL"
-	BuiltinScope SymbolScope = "BUILTIN"
-	FreeScope    SymbolScope = "FREE"
+	GlobalScope   SymbolScope = "GLOBAL"
+	LocalScope    SymbolScope = "LOCAL"
+	BuiltinScope  SymbolScope = "BUILTIN"
+	FreeScope     SymbolScope = "FREE"
+	FunctionScope SymbolScope = "FUNCTION"
 )
 
 type Symbol struct {
@@ -21,6 +22,8 @@ type SymbolTable struct {
 	store          map[string]Symbol
 	numDefinitions int
 	FreeSymbols    []Symbol
+	block          bool     // lexical block: shares the enclosing function's (or global) slots
+	slotNames      []string // name declared in each slot, for debuggers
 }
 
 func NewSymbolTable() *SymbolTable {
@@ -34,6 +37,16 @@ func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
 	return s
 }
 
+// NewBlockSymbolTable opens a block scope (if/while/for/try body) inside
+// outer. Names defined in it get slots in the enclosing function or global
+// space but stop resolving once the block is left.
+func NewBlockSymbolTable(outer *SymbolTable) *SymbolTable {
+	s := NewSymbolTable()
+	s.Outer = outer
+	s.block = true
+	return s
+}
+
 func (s *SymbolTable) Define(name string) Symbol {
 	return s.define(name, false)
 }
@@ -43,22 +56,48 @@ func (s *SymbolTable) DefineConst(name string) Symbol {
 }
 
 func (s *SymbolTable) define(name string, isConst bool) Symbol {
-	symbol := Symbol{Name: name, Index: s.numDefinitions, IsConst: isConst}
-	if s.Outer == nil {
+	owner := s.slotOwner()
+	symbol := Symbol{Name: name, Index: owner.numDefinitions, IsConst: isConst}
+	if owner.Outer == nil {
 		symbol.Scope = GlobalScope
 	} else {
 		symbol.Scope = LocalScope
 	}
 	s.store[name] = symbol
-	s.numDefinitions++
+	owner.numDefinitions++
+	owner.slotNames = append(owner.slotNames, name)
 	return symbol
 }
 
+// ReserveSlot allocates a slot no name resolves to; label is what debuggers
+// show for it.
+func (s *SymbolTable) ReserveSlot(label string) int {
+	owner := s.slotOwner()
+	owner.numDefinitions++
+	owner.slotNames = append(owner.slotNames, label)
+	return owner.numDefinitions - 1
+}
+
+// SlotNames returns the name declared in each local (or global) slot, by
+// index. Block-scoped names keep their slot, so a name can appear twice.
+func (s *SymbolTable) SlotNames() []string {
+	return s.slotOwner().slotNames
+}
+
+// slotOwner returns the function (or global) table that allocates slots for s.
+func (s *SymbolTable) slotOwner() *SymbolTable {
+	owner := s
+	for owner.block {
+		owner = owner.Outer
+	}
+	return owner
+}
+
 func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
 	obj, ok := s.store[name]
 	if !ok && s.Outer != nil {
 		obj, ok = s.Outer.Resolve(name)
-		if !ok {
+		if !ok || s.block {
 			return obj, ok
 		}
 
@@ -72,6 +111,36 @@ func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
 	return obj, ok
 }
 
+// DefinedHere reports whether name was declared (not merely referenced) in s itself.
+func (s *SymbolTable) DefinedHere(name string) bool {
+	sym, ok := s.store[name]
+	return ok && (sym.Scope == GlobalScope || sym.Scope == LocalScope)
+}
+
+// IsBuiltin reports whether name, looked up from s, currently refers to a
+// builtin rather than to a variable declared over it.
+func (s *SymbolTable) IsBuiltin(name string) bool {
+	for t := s; t != nil; t = t.Outer {
+		if sym, ok := t.store[name]; ok {
+			return sym.Scope == BuiltinScope
+		}
+	}
+	return false
+}
+
+// LookupOuter finds the declaration name would shadow in an enclosing scope,
+// without registering free variables the way Resolve does. Builtins are ignored.
+func (s *SymbolTable) LookupOuter(name string) (Symbol, bool) {
+	for t := s.Outer; t != nil; t = t.Outer {
+		sym, ok := t.store[name]
+		if !ok || sym.Scope == FreeScope || sym.Scope == BuiltinScope {
+			continue
+		}
+		return sym, true
+	}
+	return Symbol{}, false
+}
+
 func (s *SymbolTable) defineFree(original Symbol) Symbol {
 	s.FreeSymbols = append(s.FreeSymbols, original)
 
@@ -86,6 +155,28 @@ func (s *SymbolTable) DefineBuiltin(index int, name string) Symbol {
 	return symbol
 }
 
+// DefineFunctionName lets a function refer to itself by the name it is
+// being bound to, resolving to the closure currently executing.
+func (s *SymbolTable) DefineFunctionName(name string) Symbol {
+	symbol := Symbol{Name: name, Index: 0, Scope: FunctionScope}
+	s.store[name] = symbol
+	return symbol
+}
+
+// ForgetFunctionName drops the binding DefineFunctionName made for name
+// in the function enclosing s, so that name resolves to the variable the
+// function is stored in.
+func (s *SymbolTable) ForgetFunctionName(name string) {
+	for t := s; t != nil; t = t.Outer {
+		if sym, ok := t.store[name]; ok {
+			if sym.Scope == FunctionScope {
+				delete(t.store, name)
+			}
+			return
+		}
+	}
+}
+
 func (s *SymbolTable) Symbols() []Symbol {
 	symbols := make([]Symbol, 0, len(s.store))
 	for _, sym := range s.store {
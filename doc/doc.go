@@ -0,0 +1,84 @@
+// Package doc renders the /// comments of a script's top-level `set`
+// declarations as Markdown or HTML reference pages.
+package doc
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"strings"
+	"xon/ast"
+)
+
+// Entry is one documented top-level binding.
+type Entry struct {
+	Name      string
+	Signature string // "name(a, b)" for functions, "name" otherwise
+	IsConst   bool
+	Doc       string
+}
+
+// Collect returns the documented top-level bindings of program in source
+// order. Undocumented bindings are skipped.
+func Collect(program *ast.Program) []Entry {
+	var entries []Entry
+	for _, stmt := range program.Statements {
+		set, ok := stmt.(*ast.SetStatement)
+		if !ok || set.Doc == "" {
+			continue
+		}
+		e := Entry{Name: set.Name.Value, Signature: set.Name.Value, IsConst: set.IsConst, Doc: set.Doc}
+		if fl, ok := set.Value.(*ast.FunctionLiteral); ok {
+			params := make([]string, len(fl.Parameters))
+			for i, p := range fl.Parameters {
+				params[i] = p.Value
+			}
+			e.Signature = fmt.Sprintf("%s(%s)", set.Name.Value, strings.Join(params, ", "))
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// Markdown renders entries as a Markdown document headed by title.
+func Markdown(title string, entries []Entry) string {
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "# %s\n", title)
+	if len(entries) == 0 {
+		out.WriteString("\nNo documented declarations.\n")
+	}
+	for _, e := range entries {
+		fmt.Fprintf(&out, "\n## `%s`\n\n", e.Signature)
+		if e.IsConst {
+			out.WriteString("*const*\n\n")
+		}
+		out.WriteString(e.Doc)
+		out.WriteString("\n")
+	}
+	return out.String()
+}
+
+// HTML renders entries as a standalone HTML page headed by title. Doc text
+// is escaped and blank lines separate paragraphs.
+func HTML(title string, entries []Entry) string {
+	var out bytes.Buffer
+	t := html.EscapeString(title)
+	fmt.Fprintf(&out, "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>%s</title>\n</head>\n<body>\n<h1>%s</h1>\n", t, t)
+	if len(entries) == 0 {
+		out.WriteString("<p>No documented declarations.</p>\n")
+	}
+	for _, e := range entries {
+		fmt.Fprintf(&out, "<h2 id=\"%s\"><code>%s</code></h2>\n", html.EscapeString(e.Name), html.EscapeString(e.Signature))
+		if e.IsConst {
+			out.WriteString("<p><em>const</em></p>\n")
+		}
+		for _, para := range strings.Split(e.Doc, "\n\n") {
+			if strings.TrimSpace(para) == "" {
+				continue
+			}
+			fmt.Fprintf(&out, "<p>%s</p>\n", html.EscapeString(para))
+		}
+	}
+	out.WriteString("</body>\n</html>\n")
+	return out.String()
+}
@@ -0,0 +1,167 @@
+// Package fuzz feeds mutated scripts to the parser, compiler and VM and
+// reports the inputs that crash them. Syntax, compile and runtime errors
+// are expected; only panics and hangs count as failures.
+package fuzz
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+	"xon/builtins"
+	"xon/compiler"
+	"xon/lexer"
+	"xon/parser"
+	"xon/token"
+	"xon/vm"
+)
+
+// Seeds are small scripts covering most of the grammar, used when no
+// corpus files are given.
+var Seeds = []string{
+	`set x = 5; out x + 1;`,
+	`set add = fn(a, b) { return a + b; }; out add(1, 2);`,
+	`if (x > 1) { out "big"; } else if (x == 1) { out "one"; } else { out "small"; }`,
+	`for (set i = 0; i < 3; i++) { if (i == 1) { continue; } out i; }`,
+	`for (v in [1, 2, 3]) { out v; } while (true) { break; }`,
+	`set h = {"a": 1, "b": [1, 2]}; out h.a; out h["b"][0];`,
+	`out match (x) { 1 => { "one" } _ => { "other" } };`,
+	`set r = try { throw "bad"; } catch (e) { e }; out r;`,
+	`spawn work(1); group { spawn work(2); }`,
+	`import "std/strings" as s; out s.join(["a"], ",");`,
+	`const limit = 10; out "${limit} items";`,
+	`out [1, 2, 3] |> map(fn(x) { return x * 2; }); out ~1 & 2 | 3 ^ 4 << 1 >> 1 % 2;`,
+	`requires "1.0"; out -x; out !true; x--;`,
+	`try { throw "x"; } catch (e) { out e; } out "after";`,
+}
+
+// RunBudget is how long Check lets a compiled input run before stopping
+// it, so that inputs which loop forever are not reported as hangs.
+const RunBudget = 100 * time.Millisecond
+
+// Check parses src and, when it has no syntax errors, compiles it and runs
+// it for up to RunBudget with its output discarded. It returns an error if
+// any step panics. As inputs are run, a corpus should not call builtins
+// with side effects outside the script, such as fs_remove or os_exec.
+func Check(src string) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	p := parser.New(lexer.New(src))
+	program := p.ParseProgram()
+	if len(p.Errors) > 0 {
+		return nil
+	}
+	comp := compiler.New()
+	if comp.Compile(program) != nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), RunBudget)
+	defer cancel()
+	machine := vm.New(comp.Bytecode())
+	machine.SetContext(ctx)
+	builtins.Quiet.Store(true)
+	defer builtins.Quiet.Store(false)
+	machine.Run()
+	return nil
+}
+
+// Crash is an input that made Check fail or run for longer than the
+// timeout given to Run.
+type Crash struct {
+	Input string
+	Err   error
+}
+
+// Run checks n mutations of the corpus scripts, each derived from a random
+// script and mutated one to four times. The same seed gives the same
+// inputs. Each distinct failure is reported once.
+func Run(corpus []string, n int, seed int64, timeout time.Duration) []Crash {
+	rng := rand.New(rand.NewSource(seed))
+	seen := make(map[string]bool)
+	var crashes []Crash
+	for i := 0; i < n; i++ {
+		src := corpus[rng.Intn(len(corpus))]
+		for edits := 1 + rng.Intn(4); edits > 0; edits-- {
+			src = Mutate(rng, src)
+		}
+		err := checkWithTimeout(src, timeout)
+		if err != nil && !seen[err.Error()] {
+			seen[err.Error()] = true
+			crashes = append(crashes, Crash{Input: src, Err: err})
+		}
+	}
+	return crashes
+}
+
+func checkWithTimeout(src string, timeout time.Duration) error {
+	done := make(chan error, 1)
+	go func() { done <- Check(src) }()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+		// The goroutine is left running; a hang is reported all the same.
+		return fmt.Errorf("no result after %s", timeout)
+	}
+}
+
+// vocabulary holds the tokens Mutate inserts.
+var vocabulary = []string{
+	"set", "fn", "if", "else", "for", "while", "in", "return", "match", "spawn",
+	"group", "import", "as", "try", "catch", "throw", "break", "continue",
+	"const", "requires", "true", "false", "null", "x", "0", "1.5", `"s"`,
+	`"${x}"`, "(", ")", "{", "}", "[", "]", ",", ";", ":", ".", "=", "==",
+	"!=", "<", ">", "<=", ">=", "+", "-", "*", "**", "/", "%", "!", "++", "--", "=>", "|>", "..",
+	"&", "|", "^", "~", "<<", ">>", "_",
+}
+
+// Mutate returns src with one token deleted, duplicated, replaced or
+// swapped with its neighbour, a vocabulary token inserted, or the script
+// cut short.
+func Mutate(rng *rand.Rand, src string) string {
+	toks := tokens(src)
+	if len(toks) == 0 {
+		return vocabulary[rng.Intn(len(vocabulary))]
+	}
+	i := rng.Intn(len(toks))
+	word := vocabulary[rng.Intn(len(vocabulary))]
+	switch rng.Intn(6) {
+	case 0:
+		toks = append(toks[:i], toks[i+1:]...)
+	case 1:
+		toks = append(toks[:i+1], toks[i:]...)
+	case 2:
+		toks[i] = word
+	case 3:
+		if i+1 < len(toks) {
+			toks[i], toks[i+1] = toks[i+1], toks[i]
+		}
+	case 4:
+		toks = append(toks[:i], append([]string{word}, toks[i:]...)...)
+	case 5:
+		toks = toks[:i]
+	}
+	return strings.Join(toks, " ")
+}
+
+// tokens splits src into the source text of its tokens.
+func tokens(src string) []string {
+	var toks []string
+	l := lexer.New(src)
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		switch tok.Type {
+		case token.STRING:
+			toks = append(toks, `"`+tok.Literal+`"`)
+		case token.HEREDOC:
+			toks = append(toks, `"""`+tok.Literal+`"""`)
+		default:
+			toks = append(toks, tok.Literal)
+		}
+	}
+	return toks
+}
@@ -1,50 +1,124 @@
 package lexer
 
-import "xon/token"
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+	"xon/token"
+)
 
 type Lexer struct {
 	input        string
 	position     int
 	readPosition int
-	ch           byte
+	ch           rune // current character; 0 at the end of input
 	line         int
 	col          int
+	docLines     []string // pending /// comment lines for the next token
+	// unclosed is the ILLEGAL token for a /* comment still open at the
+	// end of input, returned in place of EOF.
+	unclosed *token.Token
+	// keepComments makes comments COMMENT tokens instead of whitespace.
+	keepComments bool
+	sources      token.SourceMap
 }
 
 func New(input string) *Lexer {
-	l := &Lexer{input: input, line: 1, col: 0}
+	return NewAt(input, 1, 1)
+}
+
+// NewAt is like New for input that starts at line and col of a larger
+// source, such as the expression inside a string interpolation, so its
+// tokens carry positions in that source.
+func NewAt(input string, line, col int) *Lexer {
+	l := &Lexer{input: input, line: line, col: col - 1}
 	l.readChar()
 	return l
 }
 
+// Chunk is one file for NewChunks.
+type Chunk struct {
+	File   string
+	Source string
+}
+
+// NewChunks lexes chunks joined into one text, each starting on a new
+// line, and keeps a map of where each begins so errors can name the file
+// and line they are in (see Sources). The standard library is put in
+// front of every script this way.
+func NewChunks(chunks ...Chunk) *Lexer {
+	parts := make([]string, len(chunks))
+	sources := make(token.SourceMap, len(chunks))
+	line := 1
+	for i, c := range chunks {
+		parts[i] = c.Source
+		sources[i] = token.Source{File: c.File, Line: line}
+		line += strings.Count(c.Source, "\n") + 1
+	}
+	l := New(strings.Join(parts, "\n"))
+	l.sources = sources
+	return l
+}
+
+// Sources returns the file map of a lexer made with NewChunks, or nil.
+func (l *Lexer) Sources() token.SourceMap {
+	return l.sources
+}
+
+// NewWithComments is like New but also returns each // and /* */ comment
+// as a COMMENT token holding its full text, for formatters and other
+// tooling. The parser skips them and keeps them in Program.Comments.
+func NewWithComments(input string) *Lexer {
+	l := New(input)
+	l.keepComments = true
+	return l
+}
+
 func (l *Lexer) readChar() {
 	if l.ch == '\n' {
 		l.line++
-		l.col = 0
+		l.col = 1
 	} else {
 		l.col++
 	}
 
+	size := 1
 	if l.readPosition >= len(l.input) {
 		l.ch = 0
 	} else {
-		l.ch = l.input[l.readPosition]
+		l.ch, size = utf8.DecodeRuneInString(l.input[l.readPosition:])
 	}
 	l.position = l.readPosition
-	l.readPosition += 1
+	l.readPosition += size
 }
 
-func (l *Lexer) peekChar() byte {
+func (l *Lexer) peekChar() rune {
 	if l.readPosition >= len(l.input) {
 		return 0
-	} else {
-		return l.input[l.readPosition]
 	}
+	ch, _ := utf8.DecodeRuneInString(l.input[l.readPosition:])
+	return ch
 }
 
+// NextToken returns the next token, attaching any /// doc comment lines
+// read since the previous token.
 func (l *Lexer) NextToken() token.Token {
+	tok := l.readToken()
+	tok.EndLine, tok.EndCol = l.line, l.col
+	if len(l.docLines) > 0 && tok.Type != token.COMMENT {
+		tok.Doc = strings.Join(l.docLines, "\n")
+		l.docLines = nil
+	}
+	return tok
+}
+
+func (l *Lexer) readToken() token.Token {
 	var tok token.Token
 	l.skipWhitespace()
+	if l.unclosed != nil {
+		tok, l.unclosed = *l.unclosed, nil
+		return tok
+	}
 
 	line, col := l.line, l.col
 
@@ -78,13 +152,23 @@ func (l *Lexer) NextToken() token.Token {
 			tok = token.Token{Type: token.MINUS, Literal: string(l.ch)}
 		}
 	case '*':
-		tok = token.Token{Type: token.ASTERISK, Literal: string(l.ch)}
+		if l.peekChar() == '*' {
+			ch := l.ch
+			l.readChar()
+			tok = token.Token{Type: token.POWER, Literal: string(ch) + string(l.ch)}
+		} else {
+			tok = token.Token{Type: token.ASTERISK, Literal: string(l.ch)}
+		}
 	case '/':
+		// Comments only get here with keepComments; skipWhitespace drops
+		// them otherwise.
+		start := l.position
 		if l.peekChar() == '/' {
 			for l.ch != '\n' && l.ch != 0 {
 				l.readChar()
 			}
-			return l.NextToken()
+			l.recordComment(l.input[start:l.position])
+			return token.Token{Type: token.COMMENT, Literal: l.input[start:l.position], Line: line, Col: col}
 		}
 		if l.peekChar() == '*' {
 			l.readChar() // consume /
@@ -99,7 +183,7 @@ func (l *Lexer) NextToken() token.Token {
 				if l.ch == '*' && l.peekChar() == '/' {
 					l.readChar()
 					l.readChar()
-					return l.NextToken()
+					return token.Token{Type: token.COMMENT, Literal: l.input[start:l.position], Line: line, Col: col}
 				}
 				l.readChar()
 			}
@@ -112,6 +196,10 @@ func (l *Lexer) NextToken() token.Token {
 			ch := l.ch
 			l.readChar()
 			tok = token.Token{Type: token.LSHIFT, Literal: string(ch) + string(l.ch)}
+		} else if l.peekChar() == '=' {
+			ch := l.ch
+			l.readChar()
+			tok = token.Token{Type: token.LT_EQ, Literal: string(ch) + string(l.ch)}
 		} else {
 			tok = token.Token{Type: token.LT, Literal: string(l.ch)}
 		}
@@ -120,6 +208,10 @@ func (l *Lexer) NextToken() token.Token {
 			ch := l.ch
 			l.readChar()
 			tok = token.Token{Type: token.RSHIFT, Literal: string(ch) + string(l.ch)}
+		} else if l.peekChar() == '=' {
+			ch := l.ch
+			l.readChar()
+			tok = token.Token{Type: token.GT_EQ, Literal: string(ch) + string(l.ch)}
 		} else {
 			tok = token.Token{Type: token.GT, Literal: string(l.ch)}
 		}
@@ -153,6 +245,16 @@ func (l *Lexer) NextToken() token.Token {
 		}
 	case '^':
 		tok = token.Token{Type: token.BITXOR, Literal: string(l.ch)}
+	case '?':
+		if l.peekChar() == '?' {
+			l.readChar()
+			tok = token.Token{Type: token.NULLISH, Literal: "??"}
+		} else if l.peekChar() == '.' {
+			l.readChar()
+			tok = token.Token{Type: token.OPTDOT, Literal: "?."}
+		} else {
+			tok = token.Token{Type: token.ILLEGAL, Literal: string(l.ch)}
+		}
 	case '~':
 		tok = token.Token{Type: token.BITNOT, Literal: string(l.ch)}
 	case ';':
@@ -160,7 +262,12 @@ func (l *Lexer) NextToken() token.Token {
 	case ':':
 		tok = token.Token{Type: token.COLON, Literal: string(l.ch)}
 	case '.':
-		tok = token.Token{Type: token.DOT, Literal: string(l.ch)}
+		if l.peekChar() == '.' {
+			l.readChar()
+			tok = token.Token{Type: token.DOTDOT, Literal: ".."}
+		} else {
+			tok = token.Token{Type: token.DOT, Literal: string(l.ch)}
+		}
 	case ',':
 		tok = token.Token{Type: token.COMMA, Literal: string(l.ch)}
 	case '(':
@@ -176,8 +283,23 @@ func (l *Lexer) NextToken() token.Token {
 	case ']':
 		tok = token.Token{Type: token.RBRACKET, Literal: string(l.ch)}
 	case '"':
+		if strings.HasPrefix(l.input[l.position:], `"""`) {
+			lit, closed := l.readHeredoc()
+			if !closed {
+				return token.Token{Type: token.ILLEGAL, Literal: `unterminated """ string literal`, Line: line, Col: col}
+			}
+			tok = token.Token{Type: token.HEREDOC, Literal: lit}
+			break
+		}
+		lit, closed := l.readString()
+		if !closed {
+			// Reported at the opening quote; the rest of the input was
+			// swallowed looking for the closing one.
+			tok = token.Token{Type: token.ILLEGAL, Literal: "unterminated string literal", Line: line, Col: col}
+			return tok
+		}
 		tok.Type = token.STRING
-		tok.Literal = l.readString()
+		tok.Literal = lit
 	case 0:
 		tok.Type = token.EOF
 		tok.Literal = ""
@@ -205,7 +327,9 @@ func (l *Lexer) NextToken() token.Token {
 	return tok
 }
 
-func (l *Lexer) readString() string {
+// readString reads up to the closing quote. closed is false when the
+// input ends first.
+func (l *Lexer) readString() (lit string, closed bool) {
 	position := l.position + 1
 	for {
 		l.readChar()
@@ -213,12 +337,32 @@ func (l *Lexer) readString() string {
 			break
 		}
 	}
-	return l.input[position:l.position]
+	return l.input[position:l.position], l.ch == '"'
+}
+
+// readHeredoc reads a """ string from its first quote to the closing """,
+// which it leaves l on the last quote of. Quotes inside need no escaping.
+func (l *Lexer) readHeredoc() (lit string, closed bool) {
+	l.readChar()
+	l.readChar()
+	position := l.position + 1
+	for {
+		l.readChar()
+		if l.ch == 0 {
+			return "", false
+		}
+		if strings.HasPrefix(l.input[l.position:], `"""`) {
+			lit = l.input[position:l.position]
+			l.readChar()
+			l.readChar()
+			return lit, true
+		}
+	}
 }
 
 func (l *Lexer) readIdentifier() string {
 	pos := l.position
-	for isLetter(l.ch) || isDigit(l.ch) {
+	for isLetter(l.ch) || isDigit(l.ch) || unicode.In(l.ch, unicode.Mn, unicode.Mc, unicode.Nd) {
 		l.readChar()
 	}
 	return l.input[pos:l.position]
@@ -226,29 +370,71 @@ func (l *Lexer) readIdentifier() string {
 
 func (l *Lexer) readNumber() (string, token.TokenType) {
 	pos := l.position
+	if l.ch == '0' && strings.ContainsRune("xXbBoO", l.peekChar()) {
+		// 0x1F, 0b1010 or 0o755. Letters and digits after the prefix are
+		// all taken, so the parser reports 0b12 rather than reading 0b1 2.
+		l.readChar()
+		l.readChar()
+		for isLetter(l.ch) || isDigit(l.ch) {
+			l.readChar()
+		}
+		return l.input[pos:l.position], token.INT
+	}
+	// Underscores may separate digits (1_000_000); the parser rejects
+	// misplaced ones.
 	var tType token.TokenType = token.INT
-	for isDigit(l.ch) || (l.ch == '.' && isDigit(l.peekChar())) {
+	for isDigit(l.ch) || l.ch == '_' || (l.ch == '.' && isDigit(l.peekChar())) {
 		if l.ch == '.' {
 			tType = token.FLOAT
 		}
 		l.readChar()
 	}
+	// An exponent (1.5e9, 2E-3) makes any number a float.
+	if l.ch == 'e' || l.ch == 'E' {
+		next := l.peekChar()
+		if isDigit(next) || ((next == '+' || next == '-') && l.readPosition+1 < len(l.input) && isDigit(rune(l.input[l.readPosition+1]))) {
+			tType = token.FLOAT
+			l.readChar()
+			l.readChar()
+			for isDigit(l.ch) || l.ch == '_' {
+				l.readChar()
+			}
+		}
+	}
 	return l.input[pos:l.position], tType
 }
 
+// recordComment collects "///" doc comment lines; any other line comment
+// ("//", or a "////" banner) discards the lines collected so far.
+func (l *Lexer) recordComment(comment string) {
+	if strings.HasPrefix(comment, "///") && !strings.HasPrefix(comment, "////") {
+		text := strings.TrimPrefix(comment[3:], " ")
+		l.docLines = append(l.docLines, strings.TrimRight(text, " \t\r"))
+	} else {
+		l.docLines = nil
+	}
+}
+
 func (l *Lexer) skipWhitespace() {
 	for l.ch == ' ' || l.ch == '\t' || l.ch == '\n' || l.ch == '\r' || (l.ch == '/' && (l.peekChar() == '/' || l.peekChar() == '*')) {
+		if l.ch == '/' && l.keepComments {
+			return
+		}
 		if l.ch == '/' && l.peekChar() == '/' {
+			start := l.position
 			for l.ch != '\n' && l.ch != 0 {
 				l.readChar()
 			}
+			l.recordComment(l.input[start:l.position])
 			continue
 		}
 		if l.ch == '/' && l.peekChar() == '*' {
+			line, col := l.line, l.col
 			l.readChar()
 			l.readChar()
 			for {
 				if l.ch == 0 {
+					l.unclosed = &token.Token{Type: token.ILLEGAL, Literal: "unclosed block comment", Line: line, Col: col}
 					return
 				}
 				if l.ch == '*' && l.peekChar() == '/' {
@@ -264,5 +450,8 @@ func (l *Lexer) skipWhitespace() {
 	}
 }
 
-func isLetter(ch byte) bool { return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_' }
-func isDigit(ch byte) bool  { return '0' <= ch && ch <= '9' }
+// isLetter accepts any Unicode letter, so names like größe or 名前 work.
+// Combining marks and non-ASCII digits may follow the first letter; see
+// readIdentifier.
+func isLetter(ch rune) bool { return unicode.IsLetter(ch) || ch == '_' }
+func isDigit(ch rune) bool  { return '0' <= ch && ch <= '9' }
@@ -1,9 +1,13 @@
 package main
 
 import (
+	"xon/ast"
 	"xon/builtins"
 	"xon/compiler"
+	"xon/doc"
+	"xon/fuzz"
 	"xon/lexer"
+	"xon/minify"
 	"xon/object"
 	"xon/parser"
 	"xon/repl"
@@ -11,8 +15,12 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 // normalizeScriptSource strips UTF-8 BOM and normalizes line endings to \n
@@ -23,22 +31,99 @@ func normalizeScriptSource(s string) string {
 	return strings.ReplaceAll(s, "\r\n", "\n")
 }
 
+// EmbeddedScript is the script a standalone executable runs, appended to
+// the binary by `xon build`. It can also be set with -ldflags.
 var EmbeddedScript string
 
 func main() {
 	var source string
 	var scriptName string
 
+	if exe, err := os.Executable(); err == nil && EmbeddedScript == "" {
+		EmbeddedScript, _ = builtins.EmbeddedScript(exe)
+	}
+
 	args := os.Args[1:]
 	disassemble := false
-	if len(args) > 0 && args[0] == "-d" {
-		disassemble = true
-		args = args[1:]
+	postMortem := false
+	strict := false
+	crashReport := os.Getenv("XON_CRASH_REPORT")
+	// Embedded executables pass every argument through to the script.
+	for EmbeddedScript == "" && len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		switch args[0] {
+		case "-d":
+			disassemble = true
+			args = args[1:]
+		case "--version":
+			fmt.Println("Xon " + builtins.Version)
+			return
+		case "--debug":
+			postMortem = true
+			builtins.HTTPDebug = true
+			args = args[1:]
+		case "--strict":
+			strict = true
+			args = args[1:]
+		case "--task-ids":
+			builtins.TaskIDs = true
+			args = args[1:]
+		case "--update-snapshots":
+			builtins.UpdateSnapshots = true
+			args = args[1:]
+		case "--crash-report":
+			if len(args) < 2 {
+				fmt.Printf("%s requires a file, directory or URL\n", args[0])
+				return
+			}
+			crashReport = args[1]
+			args = args[2:]
+		case "--max-stack", "--max-frames":
+			if len(args) < 2 {
+				fmt.Printf("%s requires a number\n", args[0])
+				return
+			}
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				fmt.Printf("invalid value for %s: %s\n", args[0], args[1])
+				return
+			}
+			if args[0] == "--max-stack" {
+				vm.MaxStackSize = n
+			} else {
+				vm.MaxFrames = n
+			}
+			args = args[2:]
+		default:
+			fmt.Printf("unknown option %s\n", args[0])
+			return
+		}
+	}
+
+	if EmbeddedScript == "" && len(args) > 0 && args[0] == "doc" {
+		runDoc(args[1:])
+		return
+	}
+	if EmbeddedScript == "" && len(args) > 0 && args[0] == "build" {
+		runBuild(args[1:])
+		return
+	}
+	if EmbeddedScript == "" && len(args) > 0 && args[0] == "minify" {
+		runMinify(args[1:])
+		return
+	}
+	if EmbeddedScript == "" && len(args) > 0 && args[0] == "fuzz" {
+		runFuzz(args[1:])
+		return
+	}
+	if EmbeddedScript == "" && len(args) > 0 && args[0] == "service" {
+		runService(args[1:])
+		return
 	}
 
 	if EmbeddedScript != "" {
-		source = EmbeddedScript
+		source = normalizeScriptSource(EmbeddedScript)
 		scriptName = "embedded"
+		builtins.ScriptArgs = args
 	} else if len(args) < 1 {
 		fmt.Println("Xon REPL")
 		fmt.Println("Type your code below. Press Ctrl+C to exit.")
@@ -52,6 +137,8 @@ func main() {
 		}
 		source = normalizeScriptSource(string(input))
 		scriptName = args[0]
+		builtins.ScriptArgs = args[1:]
+		builtins.SnapshotDir = filepath.Join(filepath.Dir(args[0]), "__snapshots__")
 	}
 
 	// Load standard library source
@@ -61,10 +148,8 @@ func main() {
 		stdSource = normalizeScriptSource(stdContent)
 	}
 
-	// Combine std + user source
-	fullSource := stdSource + "\n" + source
-
-	l := lexer.New(fullSource)
+	// Combine std + user source, keeping track of which lines are whose.
+	l := lexer.NewChunks(lexer.Chunk{File: builtins.StdLibFile, Source: stdSource}, lexer.Chunk{File: scriptName, Source: source})
 	p := parser.New(l)
 	program := p.ParseProgram()
 
@@ -73,14 +158,33 @@ func main() {
 		for _, msg := range p.Errors {
 			fmt.Println("\t" + msg)
 		}
-		return
+		os.Exit(1)
 	}
 
 	comp := compiler.New()
+	builtins.ScriptDocs = comp.Docs
+	builtins.GlobalNames = comp.GlobalNames
 	err = comp.Compile(program)
 	if err != nil {
 		fmt.Printf("Compiler error: %s\n", err)
-		return
+		os.Exit(1)
+	}
+
+	// Report warnings for the user script only. Under --strict, shadowing a
+	// builtin is a compile error and stops the script.
+	failed := false
+	for _, w := range comp.Warnings {
+		if w.File == scriptName {
+			if strict && w.ShadowsBuiltin {
+				fmt.Printf("Compiler error: %s: %s\n", w.File, w)
+				failed = true
+			} else {
+				fmt.Fprintf(os.Stderr, "Warning: %s: %s\n", w.File, w)
+			}
+		}
+	}
+	if failed {
+		os.Exit(1)
 	}
 
 	bytecode := comp.Bytecode()
@@ -108,13 +212,15 @@ func main() {
 		for i, arg := range args {
 			subVm.SetStack(i, arg)
 		}
-		subVm.SetStackPointer(cl.Fn.NumLocals)
+		if err := subVm.SetStackPointer(cl.Fn.NumLocals); err != nil {
+			return &object.Error{Message: err.Error()}
+		}
 
 		err := subVm.Run()
 		if err != nil {
 			return &object.Error{Message: err.Error()}
 		}
-		return subVm.LastPoppedStackElem()
+		return subVm.StackTop()
 	}
 
 	if disassemble {
@@ -127,10 +233,340 @@ func main() {
 		return
 	}
 
+	if info, err := os.Stdout.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		vm.PrettyOut = true
+	}
+
+	if crashReport != "" {
+		vm.TraceLength = crashTraceLength
+	}
+	started := time.Now()
 	machine := vm.NewWithGlobalsState(bytecode, globals, globalsMu)
 	err = machine.Run()
 	if err != nil {
 		fmt.Printf("VM error in %s: %s\n", scriptName, err)
+		trace := machine.StackTrace()
+		for _, f := range trace {
+			fmt.Printf("  at %s\n", f)
+		}
+		if crashReport != "" {
+			writeCrashReport(crashReport, scriptName, started, err, trace, machine)
+		}
+		if postMortem {
+			repl.PostMortem(os.Stdin, os.Stdout, err, machine, comp, globals, globalsMu)
+		}
+		os.Exit(1)
+	}
+}
+
+// crashTraceLength is how many of the last instructions a crash report
+// lists.
+const crashTraceLength = 50
+
+// writeCrashReport records why and where the script stopped, for
+// --crash-report or XON_CRASH_REPORT, and says on stderr where it went.
+func writeCrashReport(target, scriptName string, started time.Time, err error, trace []vm.StackFrame, machine *vm.VM) {
+	stack := make([]string, len(trace))
+	for i, f := range trace {
+		stack[i] = f.String()
+	}
+	report := &builtins.CrashReport{
+		Script:       scriptName,
+		Version:      builtins.Version,
+		Time:         time.Now().Format(time.RFC3339),
+		DurationMs:   time.Since(started).Milliseconds(),
+		Instructions: machine.InstructionCount(),
+		Error:        err.Error(),
+		Stack:        stack,
+		Recent:       machine.Trace(),
+		Globals:      builtins.SnapshotGlobals(stdGlobalNames()),
+	}
+	where, werr := builtins.WriteCrashReport(target, report)
+	if werr != nil {
+		fmt.Fprintf(os.Stderr, "could not write crash report: %s\n", werr)
 		return
 	}
+	fmt.Fprintf(os.Stderr, "crash report written to %s\n", where)
+}
+
+// runDoc implements `xon doc [--html] file.xn`: it prints the script's ///
+// doc comments as Markdown, or as an HTML page with --html. With --builtins
+// instead of a file it documents the builtin registry.
+func runDoc(args []string) {
+	asHTML, builtinRef := false, false
+	for len(args) > 0 && strings.HasPrefix(args[0], "--") {
+		switch args[0] {
+		case "--html":
+			asHTML = true
+		case "--builtins":
+			builtinRef = true
+		default:
+			fmt.Printf("unknown option %s\n", args[0])
+			return
+		}
+		args = args[1:]
+	}
+	if builtinRef && len(args) == 0 {
+		entries := make([]doc.Entry, len(builtins.Registry))
+		for i, info := range builtins.Registry {
+			entries[i] = doc.Entry{Name: info.Name, Signature: info.Signature, Doc: info.Doc}
+		}
+		printDoc("Xon builtins", entries, asHTML)
+		return
+	}
+	if builtinRef || len(args) != 1 {
+		fmt.Println("usage: xon doc [--html] (file.xn | --builtins)")
+		return
+	}
+	input, err := ioutil.ReadFile(args[0])
+	if err != nil {
+		fmt.Println("Error reading file:", err)
+		return
+	}
+	p := parser.New(lexer.New(normalizeScriptSource(string(input))))
+	program := p.ParseProgram()
+	if len(p.Errors) > 0 {
+		fmt.Println("Syntax Errors:")
+		for _, msg := range p.Errors {
+			fmt.Println("\t" + msg)
+		}
+		os.Exit(1)
+	}
+	printDoc(args[0], doc.Collect(program), asHTML)
+}
+
+func printDoc(title string, entries []doc.Entry, asHTML bool) {
+	if asHTML {
+		fmt.Print(doc.HTML(title, entries))
+	} else {
+		fmt.Print(doc.Markdown(title, entries))
+	}
+}
+
+// runBuild implements `xon build [--target GOOS/GOARCH] [-o out] file.xn`:
+// it builds a standalone executable running the script, for another
+// platform with --target. The output defaults to the script name, with .exe
+// for Windows targets.
+func runBuild(args []string) {
+	target, output := "", ""
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		if len(args) < 2 {
+			fmt.Fprintf(os.Stderr, "%s requires a value\n", args[0])
+			os.Exit(1)
+		}
+		switch args[0] {
+		case "--target":
+			target = args[1]
+		case "-o", "--exe":
+			output = args[1]
+		default:
+			fmt.Fprintf(os.Stderr, "unknown option %s\n", args[0])
+			os.Exit(1)
+		}
+		args = args[2:]
+	}
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: xon build [--target GOOS/GOARCH] [--exe out] file.xn")
+		os.Exit(1)
+	}
+	if output == "" {
+		output = strings.TrimSuffix(filepath.Base(args[0]), ".xn")
+		goos := runtime.GOOS
+		if target != "" {
+			goos, _, _ = strings.Cut(target, "/")
+		}
+		if goos == "windows" {
+			output += ".exe"
+		}
+	}
+	if err := builtins.BuildExecutable(args[0], output, target); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Println("Successfully built " + output)
+}
+
+const serviceUsage = `usage: xon service install [--name NAME] [--log FILE] [--user] file.xn
+       xon service start|stop|remove NAME`
+
+// runService implements `xon service`: install registers the script as a
+// systemd unit or Windows service that starts at boot and restarts when it
+// exits, with its output appended to a log; start, stop and remove manage
+// it by name. `service run` is what the Windows service manager starts.
+func runService(args []string) {
+	if len(args) == 0 {
+		fmt.Println(serviceUsage)
+		return
+	}
+	action, args := args[0], args[1:]
+	var opts builtins.ServiceOptions
+	for (action == "install" || action == "run") && len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		if args[0] == "--user" {
+			opts.User = true
+			args = args[1:]
+			continue
+		}
+		if len(args) < 2 {
+			fmt.Printf("%s requires a value\n", args[0])
+			return
+		}
+		switch args[0] {
+		case "--name":
+			opts.Name = args[1]
+		case "--log":
+			opts.Log = args[1]
+		default:
+			fmt.Printf("unknown option %s\n", args[0])
+			return
+		}
+		args = args[2:]
+	}
+	if len(args) != 1 {
+		fmt.Println(serviceUsage)
+		return
+	}
+	var err error
+	switch action {
+	case "install":
+		var name string
+		if name, err = builtins.InstallService(args[0], opts); err == nil {
+			fmt.Printf("Installed service %s; start it with: xon service start %s\n", name, name)
+		}
+	case "run":
+		err = builtins.RunService(args[0], opts)
+	case "start":
+		err = builtins.StartService(args[0])
+	case "stop":
+		err = builtins.StopService(args[0])
+	case "remove":
+		err = builtins.RemoveService(args[0])
+	default:
+		fmt.Println(serviceUsage)
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+// runMinify implements `xon minify [--rename] [-o out.xn] file.xn`: it prints
+// the script without comments or spare whitespace, or writes it to the -o
+// file. --rename also shortens names local to functions.
+func runMinify(args []string) {
+	var opts minify.Options
+	output := ""
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		switch args[0] {
+		case "--rename":
+			opts.RenameLocals = true
+			args = args[1:]
+		case "-o":
+			if len(args) < 2 {
+				fmt.Println("-o requires a file name")
+				return
+			}
+			output = args[1]
+			args = args[2:]
+		default:
+			fmt.Printf("unknown option %s\n", args[0])
+			return
+		}
+	}
+	if len(args) != 1 {
+		fmt.Println("usage: xon minify [--rename] [-o out.xn] file.xn")
+		return
+	}
+	input, err := ioutil.ReadFile(args[0])
+	if err != nil {
+		fmt.Println("Error reading file:", err)
+		return
+	}
+	if opts.RenameLocals {
+		opts.Reserved = append(stdGlobalNames(), builtins.BuiltinNames...)
+	}
+	result, err := minify.Minify(normalizeScriptSource(string(input)), opts)
+	if err != nil {
+		fmt.Println("Syntax Errors:")
+		for _, msg := range strings.Split(err.Error(), "\n") {
+			fmt.Println("\t" + msg)
+		}
+		return
+	}
+	if output == "" {
+		fmt.Print(result)
+		return
+	}
+	if err := ioutil.WriteFile(output, []byte(result), 0644); err != nil {
+		fmt.Println("Error writing file:", err)
+	}
+}
+
+// runFuzz implements `xon fuzz [-n N] [--seed S] [file.xn ...]`: it checks
+// N mutations of the given scripts (or built-in seeds) and prints every
+// input that crashes the parser, compiler or VM, exiting with status 1 if any.
+func runFuzz(args []string) {
+	n := 10000
+	seed := time.Now().UnixNano()
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		if len(args) < 2 {
+			fmt.Printf("%s requires a number\n", args[0])
+			return
+		}
+		v, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			fmt.Printf("invalid value for %s: %s\n", args[0], args[1])
+			return
+		}
+		switch args[0] {
+		case "-n":
+			n = int(v)
+		case "--seed":
+			seed = v
+		default:
+			fmt.Printf("unknown option %s\n", args[0])
+			return
+		}
+		args = args[2:]
+	}
+	corpus := fuzz.Seeds
+	if len(args) > 0 {
+		corpus = nil
+		for _, path := range args {
+			input, err := ioutil.ReadFile(path)
+			if err != nil {
+				fmt.Println("Error reading file:", err)
+				return
+			}
+			corpus = append(corpus, normalizeScriptSource(string(input)))
+		}
+	}
+
+	fmt.Printf("fuzzing %d inputs (seed %d)\n", n, seed)
+	crashes := fuzz.Run(corpus, n, seed, 5*time.Second)
+	for i, c := range crashes {
+		fmt.Printf("\ncrash %d: %s\n%s\n", i+1, c.Err, c.Input)
+	}
+	if len(crashes) > 0 {
+		fmt.Printf("\n%d crashing inputs\n", len(crashes))
+		os.Exit(1)
+	}
+	fmt.Println("no crashes")
+}
+
+// stdGlobalNames lists the globals the standard library defines, which a
+// minified script must not shadow.
+func stdGlobalNames() []string {
+	stdContent, err := builtins.LoadStdLib()
+	if err != nil {
+		return nil
+	}
+	program := parser.New(lexer.New(normalizeScriptSource(stdContent))).ParseProgram()
+	var names []string
+	for _, stmt := range program.Statements {
+		if set, ok := stmt.(*ast.SetStatement); ok {
+			names = append(names, set.Name.Value)
+		}
+	}
+	return names
 }
@@ -0,0 +1,398 @@
+// Package minify rewrites a script with comments and whitespace removed and,
+// optionally, its function-local names shortened.
+package minify
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"xon/ast"
+	"xon/lexer"
+	"xon/parser"
+	"xon/token"
+)
+
+// Options controls Minify.
+type Options struct {
+	// RenameLocals shortens parameters and variables declared inside
+	// functions. Globals keep their names so other scripts, help and
+	// globals() still find them.
+	RenameLocals bool
+	// Reserved lists names renamed locals must not take, such as builtins
+	// and standard library globals.
+	Reserved []string
+}
+
+// Minify returns src as a single line of tokens separated only where the
+// lexer needs it. The script must parse; its syntax errors are returned.
+func Minify(src string, opts Options) (string, error) {
+	p := parser.New(lexer.New(src))
+	program := p.ParseProgram()
+	if len(p.Errors) > 0 {
+		return "", fmt.Errorf("%s", strings.Join(p.Errors, "\n"))
+	}
+
+	var renames map[position]string
+	if opts.RenameLocals {
+		renames = planRenames(program, src, opts.Reserved)
+	}
+
+	var out bytes.Buffer
+	var prev token.Token
+	l := lexer.New(src)
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		if tok.Type == token.IDENT {
+			if name, ok := renames[position{tok.Line, tok.Col}]; ok {
+				tok.Literal = name
+			}
+		}
+		if out.Len() > 0 && needsSpace(prev, tok) {
+			out.WriteByte(' ')
+		}
+		out.WriteString(tokenText(tok))
+		prev = tok
+	}
+	out.WriteByte('\n')
+	return out.String(), nil
+}
+
+func tokenText(tok token.Token) string {
+	switch tok.Type {
+	case token.STRING:
+		return `"` + tok.Literal + `"`
+	case token.HEREDOC:
+		return `"""` + tok.Literal + `"""`
+	}
+	return tok.Literal
+}
+
+// needsSpace reports whether a and b would lex differently when written
+// next to each other, as with two words (`set x`) or `-` `-` becoming `--`.
+func needsSpace(a, b token.Token) bool {
+	l := lexer.New(tokenText(a) + tokenText(b))
+	first, second := l.NextToken(), l.NextToken()
+	return first.Type != a.Type || first.Literal != a.Literal ||
+		second.Type != b.Type || second.Literal != b.Literal
+}
+
+type position struct{ line, col int }
+
+// binding is one declared variable. Only bindings inside functions are
+// renamed, and only when every use has a source position.
+type binding struct {
+	local  bool
+	pinned bool
+	uses   []position
+}
+
+type scope struct {
+	outer    *scope
+	names    map[string]*binding
+	function bool // false for the global scope and the blocks in it
+}
+
+func (s *scope) define(name string, at *ast.Identifier) *binding {
+	b := &binding{local: s.function}
+	s.names[name] = b
+	if at != nil {
+		b.use(at)
+	}
+	return b
+}
+
+func (s *scope) resolve(name string) *binding {
+	for t := s; t != nil; t = t.outer {
+		if b, ok := t.names[name]; ok {
+			return b
+		}
+	}
+	return nil
+}
+
+func (b *binding) use(id *ast.Identifier) {
+	if id.Token.Line == 0 {
+		b.pinned = true
+		return
+	}
+	b.uses = append(b.uses, position{id.Token.Line, id.Token.Col})
+}
+
+// renamer walks the program with the compiler's scoping rules, tying each
+// identifier to the binding it refers to.
+type renamer struct {
+	bindings []*binding
+	used     map[string]bool // every name in the source, plus reserved ones
+	pinning  bool            // inside an interpolation: pin instead of recording uses
+}
+
+// planRenames returns the new name for each identifier position that refers
+// to a renamable local. New names are unique across the script, so no
+// renamed variable can capture or shadow another.
+func planRenames(program *ast.Program, src string, reserved []string) map[position]string {
+	r := &renamer{used: make(map[string]bool)}
+	for _, name := range reserved {
+		r.used[name] = true
+	}
+	l := lexer.New(src)
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		if tok.Type == token.IDENT {
+			r.used[tok.Literal] = true
+		}
+	}
+
+	global := &scope{names: make(map[string]*binding)}
+	for _, stmt := range program.Statements {
+		r.statement(global, stmt)
+	}
+
+	renames := make(map[position]string)
+	next := 0
+	for _, b := range r.bindings {
+		if !b.local || b.pinned {
+			continue
+		}
+		var name string
+		for {
+			name = shortName(next)
+			next++
+			if !r.used[name] && token.LookupIdent(name) == token.IDENT {
+				break
+			}
+		}
+		for _, pos := range b.uses {
+			renames[pos] = name
+		}
+	}
+	return renames
+}
+
+// shortName returns a, b, ..., z, aa, ab, ... for n = 0, 1, 2, ...
+func shortName(n int) string {
+	name := ""
+	for {
+		name = string(rune('a'+n%26)) + name
+		n = n/26 - 1
+		if n < 0 {
+			return name
+		}
+	}
+}
+
+func (r *renamer) define(s *scope, name string, at *ast.Identifier) *binding {
+	if r.pinning {
+		at = nil
+	}
+	b := s.define(name, at)
+	b.pinned = b.pinned || r.pinning
+	r.bindings = append(r.bindings, b)
+	return b
+}
+
+func (r *renamer) ref(s *scope, id *ast.Identifier) {
+	b := s.resolve(id.Value)
+	switch {
+	case b == nil:
+	case r.pinning:
+		b.pinned = true
+	default:
+		b.use(id)
+	}
+}
+
+func block(outer *scope) *scope {
+	return &scope{outer: outer, names: make(map[string]*binding), function: outer.function}
+}
+
+func (r *renamer) statements(s *scope, stmts []ast.Statement) {
+	for _, stmt := range stmts {
+		r.statement(s, stmt)
+	}
+}
+
+func (r *renamer) scopedBlock(s *scope, b *ast.BlockStatement) {
+	if b != nil {
+		r.statements(block(s), b.Statements)
+	}
+}
+
+func (r *renamer) statement(s *scope, stmt ast.Statement) {
+	switch n := stmt.(type) {
+	case *ast.SetStatement:
+		if fl, ok := n.Value.(*ast.FunctionLiteral); ok && fl.Name != "" {
+			// The function can call itself by the name it is being bound to.
+			b := &binding{local: s.function}
+			r.function(s, fl, b)
+			s.names[n.Name.Value] = b
+			b.use(n.Name)
+			r.bindings = append(r.bindings, b)
+			return
+		}
+		r.expression(s, n.Value)
+		r.define(s, n.Name.Value, n.Name)
+	case *ast.DestructureStatement:
+		r.expression(s, n.Value)
+		for i, name := range n.Names {
+			b := r.define(s, name.Value, name)
+			// In `{name}` one token is both the key and the variable.
+			if n.IsHash && n.Keys[i] == name.Value {
+				b.pinned = true
+			}
+		}
+	case *ast.AssignStatement:
+		target := s.resolve(n.Name.Value)
+		if fl, ok := n.Value.(*ast.FunctionLiteral); ok && fl.Name != "" {
+			r.function(s, fl, target)
+		} else {
+			r.expression(s, n.Value)
+		}
+		r.ref(s, n.Name)
+	case *ast.IndexAssignStatement:
+		r.expression(s, n.Target)
+		r.expression(s, n.Value)
+	case *ast.OutStatement:
+		r.expression(s, n.Value)
+	case *ast.ReturnStatement:
+		r.expression(s, n.Value)
+	case *ast.ThrowStatement:
+		r.expression(s, n.Value)
+	case *ast.ExpressionStatement:
+		r.expression(s, n.Expression)
+	case *ast.BlockStatement:
+		r.statements(s, n.Statements)
+	case *ast.SpawnStatement:
+		r.expression(s, n.Call)
+	case *ast.GroupStatement:
+		r.scopedBlock(s, n.Body)
+	case *ast.ImportStatement:
+		r.expression(s, n.Path)
+		if n.Alias != nil {
+			r.define(s, n.Alias.Value, n.Alias)
+		} else if str, ok := n.Path.(*ast.StringLiteral); ok {
+			// The name comes from the path string and cannot be renamed.
+			name := strings.TrimSuffix(str.Value, ".xn")
+			parts := strings.Split(name, "/")
+			r.define(s, parts[len(parts)-1], nil).pinned = true
+		}
+	case *ast.IfStatement:
+		r.expression(s, n.Condition)
+		r.scopedBlock(s, n.Consequence)
+		r.scopedBlock(s, n.Alternative)
+	case *ast.WhileStatement:
+		r.expression(s, n.Condition)
+		r.scopedBlock(s, n.Body)
+	case *ast.DoWhileStatement:
+		r.scopedBlock(s, n.Body)
+		r.expression(s, n.Condition)
+	case *ast.ForStatement:
+		loop := block(s)
+		if n.Init != nil {
+			r.statement(loop, n.Init)
+		}
+		r.expression(loop, n.Condition)
+		r.scopedBlock(loop, n.Body)
+		if n.Update != nil {
+			r.statement(loop, n.Update)
+		}
+	case *ast.ForInStatement:
+		loop := block(s)
+		r.expression(loop, n.Iterable)
+		r.define(loop, n.Variable.Value, n.Variable)
+		r.scopedBlock(loop, n.Body)
+	}
+}
+
+// function walks a function literal in a new scope. self, when set, is the
+// binding its own name refers to inside the body.
+func (r *renamer) function(s *scope, fl *ast.FunctionLiteral, self *binding) {
+	fs := &scope{outer: s, names: make(map[string]*binding), function: true}
+	if fl.Name != "" {
+		if self != nil {
+			fs.names[fl.Name] = self
+		} else {
+			// Bound to an undeclared name: leave the self-reference alone.
+			r.define(fs, fl.Name, nil).pinned = true
+		}
+	}
+	for _, p := range fl.Parameters {
+		r.define(fs, p.Value, p)
+	}
+	if fl.Body != nil {
+		r.statements(fs, fl.Body.Statements)
+	}
+}
+
+func (r *renamer) expression(s *scope, exp ast.Expression) {
+	switch n := exp.(type) {
+	case *ast.Identifier:
+		r.ref(s, n)
+	case *ast.InterpolatedString:
+		// Names inside "${...}" live in the string token, so their
+		// bindings keep the original name.
+		saved := r.pinning
+		r.pinning = true
+		for _, part := range n.Parts {
+			r.expression(s, part)
+		}
+		r.pinning = saved
+	case *ast.PrefixExpression:
+		r.expression(s, n.Right)
+	case *ast.InfixExpression:
+		r.expression(s, n.Left)
+		r.expression(s, n.Right)
+	case *ast.PostfixExpression:
+		r.expression(s, n.Left)
+	case *ast.PipeExpression:
+		r.expression(s, n.Left)
+		r.expression(s, n.Right)
+	case *ast.FunctionLiteral:
+		r.function(s, n, nil)
+	case *ast.CallExpression:
+		r.expression(s, n.Function)
+		for _, arg := range n.Arguments {
+			r.expression(s, arg)
+		}
+	case *ast.ArrayLiteral:
+		for _, el := range n.Elements {
+			r.expression(s, el)
+		}
+	case *ast.HashLiteral:
+		for k, v := range n.Pairs {
+			r.expression(s, k)
+			r.expression(s, v)
+		}
+	case *ast.IndexExpression:
+		r.expression(s, n.Left)
+		r.expression(s, n.Index)
+	case *ast.SliceExpression:
+		r.expression(s, n.Left)
+		r.expression(s, n.Low)
+		r.expression(s, n.High)
+	case *ast.MemberExpression:
+		// The member name is a key, not a variable.
+		r.expression(s, n.Object)
+	case *ast.MatchExpression:
+		r.expression(s, n.Value)
+		for _, c := range n.Cases {
+			// A bare identifier pattern binds the value for the guard and body.
+			cs := block(s)
+			if id, ok := c.Pattern.(*ast.Identifier); ok {
+				if id.Value != "_" {
+					r.define(cs, id.Value, id)
+				}
+			} else {
+				r.expression(s, c.Pattern)
+			}
+			r.expression(cs, c.Guard)
+			r.scopedBlock(cs, c.Body)
+		}
+	case *ast.TryExpression:
+		r.scopedBlock(s, n.Block)
+		catch := block(s)
+		if n.CatchParameter != nil {
+			r.define(catch, n.CatchParameter.Value, n.CatchParameter)
+		}
+		if n.CatchBlock != nil {
+			r.statements(catch, n.CatchBlock.Statements)
+		}
+	}
+}
@@ -2,11 +2,16 @@ package object
 
 import (
 	"bytes"
+	"context"
 	"xon/ast"
 	"fmt"
 	"hash/fnv"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"xon/code"
 )
 
 type ObjectType string
@@ -26,6 +31,11 @@ const (
 	MODULE_OBJ       = "MODULE"
 	COMPILED_FN_OBJ  = "COMPILED_FUNCTION"
 	CLOSURE_OBJ      = "CLOSURE"
+	NUMARRAY_OBJ     = "NUMARRAY"
+	CONTEXT_OBJ      = "CONTEXT"
+	RANGE_OBJ        = "RANGE"
+	CLASS_OBJ        = "CLASS"
+	METHOD_OBJ       = "METHOD"
 )
 
 type Object interface {
@@ -87,10 +97,19 @@ type ReturnValue struct{ Value Object }
 func (rv *ReturnValue) Type() ObjectType { return RETURN_VALUE_OBJ }
 func (rv *ReturnValue) Inspect() string  { return rv.Value.Inspect() }
 
+// Error is both what a failing builtin returns and what error(msg, code,
+// data) makes for scripts to throw. Code and Data are nil unless given;
+// Stack is filled in when the error is first thrown.
 type Error struct {
 	Message string
+	Code    Object
+	Data    *Hash
+	Stack   []string // functions active at the throw, innermost first
 	Line    int
 	Col     int
+	// Raise makes the VM treat the error as a runtime error, as it does a
+	// failed assignment, instead of handing it to the script as a value.
+	Raise bool
 }
 
 func (e *Error) Type() ObjectType { return ERROR_OBJ }
@@ -98,6 +117,9 @@ func (e *Error) Inspect() string {
 	if e.Line != 0 {
 		return fmt.Sprintf("runtime error: %s (at line %d, col %d)", e.Message, e.Line, e.Col)
 	}
+	if e.Code != nil && e.Code.Type() != NULL_OBJ {
+		return fmt.Sprintf("ERROR: %s (code %s)", e.Message, e.Code.Inspect())
+	}
 	return "ERROR: " + e.Message
 }
 
@@ -130,22 +152,79 @@ func (f *Function) Inspect() string {
 
 type CompiledFunction struct {
 	Instructions  []byte
+	Lines         code.LineTable // source line of each run of instructions, for stack traces
 	NumLocals     int
 	NumParameters int
-	Constants     []Object // optional: if set, used instead of VM constants (for imported modules)
+	Name          string       // binding name, empty for anonymous functions
+	Doc           string       // /// doc comment of the binding, shown by help(fn)
+	LocalNames    []string     // name of each local slot, for post-mortem inspection
+	FreeNames     []string     // name of each captured variable
+	Method        bool         // takes self first: a class method, or a function using self that h.name binds to h
+	Module        *ModuleState // the imported module the function belongs to; nil in the main program
+
+	decoded atomic.Pointer[[]code.Instr]
+}
+
+// Code returns the function's instructions decoded for the VM, with
+// superinstructions fused in. They are decoded on first use; tasks racing
+// to do it get equal results.
+func (cf *CompiledFunction) Code() []code.Instr {
+	if d := cf.decoded.Load(); d != nil {
+		return *d
+	}
+	d := code.Fuse(code.Decode(cf.Instructions))
+	cf.decoded.Store(&d)
+	return d
+}
+
+// Line returns the file and line instruction ip, an index into Code, was
+// compiled from; the line is 0 if it is not known.
+func (cf *CompiledFunction) Line(ip int) (file string, line int) {
+	offsets := code.Offsets(cf.Instructions)
+	if ip < 0 || ip >= len(offsets) {
+		return "", 0
+	}
+	return cf.Lines.Line(offsets[ip])
+}
+
+// ModuleState is what an imported module's code runs against: its own
+// constant pool and globals. Import links every function compiled in the
+// module to it, so the module's functions keep reading their own globals
+// and constants when called from the importer.
+type ModuleState struct {
+	Path      string
+	Constants []Object
+	Globals   []Object
+	GlobalsMu *sync.RWMutex
+	// Exports is the hash the importer sees; ExportNames maps a global
+	// slot to its key there. Export keeps the two in step.
+	Exports     *Hash
+	ExportNames map[int]*String
+}
+
+// Export records that the module's global slot now holds val, so the
+// importer reads the current value. The caller holds GlobalsMu, which is
+// also the Mu of Exports.
+func (m *ModuleState) Export(slot int, val Object) {
+	if key, ok := m.ExportNames[slot]; ok && val != nil {
+		m.Exports.Pairs[key.HashKey()] = HashPair{Key: key, Value: val}
+	}
 }
 
 func (cf *CompiledFunction) Type() ObjectType { return COMPILED_FN_OBJ }
 func (cf *CompiledFunction) Inspect() string  { return fmt.Sprintf("CompiledFunction[%p]", cf) }
 
-type Array struct{ Elements []Object }
+type Array struct {
+	Elements []Object
+	Frozen   bool // set by freeze / `set const deep`; mutation is an error
+}
 
 func (a *Array) Type() ObjectType { return ARRAY_OBJ }
 func (a *Array) Inspect() string {
 	var out bytes.Buffer
 	elements := []string{}
 	for _, e := range a.Elements {
-		elements = append(elements, e.Inspect())
+		elements = append(elements, Repr(e))
 	}
 	out.WriteString("[")
 	out.WriteString(strings.Join(elements, ", "))
@@ -153,21 +232,133 @@ func (a *Array) Inspect() string {
 	return out.String()
 }
 
+// NumArray is a dense vector (Shape [n]) or row-major matrix (Shape [rows, cols])
+// of float64 values, so numeric code avoids one heap object per element.
+type NumArray struct {
+	Data  []float64
+	Shape []int
+}
+
+func (n *NumArray) Type() ObjectType { return NUMARRAY_OBJ }
+func (n *NumArray) Inspect() string {
+	var out bytes.Buffer
+	out.WriteString("numarray(")
+	if len(n.Shape) == 2 {
+		rows := []string{}
+		for r := 0; r < n.Shape[0]; r++ {
+			rows = append(rows, formatFloats(n.Data[r*n.Shape[1]:(r+1)*n.Shape[1]]))
+		}
+		out.WriteString("[")
+		out.WriteString(strings.Join(rows, ", "))
+		out.WriteString("]")
+	} else {
+		out.WriteString(formatFloats(n.Data))
+	}
+	out.WriteString(")")
+	return out.String()
+}
+
+func formatFloats(vals []float64) string {
+	elements := make([]string, len(vals))
+	for i, v := range vals {
+		elements[i] = fmt.Sprintf("%g", v)
+	}
+	return "[" + strings.Join(elements, ", ") + "]"
+}
+
+// Range is the integers from Start up to, not including, End, counting by
+// Step. It is made by start..end and range(); elements are computed on
+// demand, so a range of a billion numbers takes no more room than one of ten.
+type Range struct {
+	Start, End, Step int64
+}
+
+func (r *Range) Type() ObjectType { return RANGE_OBJ }
+func (r *Range) Inspect() string {
+	if r.Step == 1 {
+		return fmt.Sprintf("%d..%d", r.Start, r.End)
+	}
+	return fmt.Sprintf("range(%d, %d, %d)", r.Start, r.End, r.Step)
+}
+
+// Len returns the number of elements.
+func (r *Range) Len() int64 {
+	switch {
+	case r.Step > 0 && r.End > r.Start:
+		return (r.End - r.Start + r.Step - 1) / r.Step
+	case r.Step < 0 && r.End < r.Start:
+		return (r.Start - r.End - r.Step - 1) / -r.Step
+	}
+	return 0
+}
+
+// At returns element i, which must be in [0, Len()).
+func (r *Range) At(i int64) int64 { return r.Start + i*r.Step }
+
+// Context is a cancellation scope made by ctx_new. Builtins that wait, such
+// as sleep and http_get, return early once it is done, and a task spawned
+// with a Context argument stops when it is canceled.
+type Context struct {
+	Ctx    context.Context
+	Cancel context.CancelFunc
+}
+
+func (c *Context) Type() ObjectType { return CONTEXT_OBJ }
+func (c *Context) Inspect() string {
+	if c.Ctx.Err() != nil {
+		return "context(done)"
+	}
+	return "context(active)"
+}
+
 type HashPair struct {
 	Key   Object
 	Value Object
 }
 
 type Hash struct {
-	Pairs map[HashKey]HashPair
+	Pairs  map[HashKey]HashPair
+	Frozen bool
+	Class  *Class // set on instances of a class, whose methods it has
+	// Mu, when set, guards Pairs against another goroutine's writes, as
+	// for a module's exports, which its functions update; readers use Get
+	// and Entries.
+	Mu *sync.RWMutex
+}
+
+// Get returns the pair stored under key.
+func (h *Hash) Get(key HashKey) (HashPair, bool) {
+	if h.Mu != nil {
+		h.Mu.RLock()
+		defer h.Mu.RUnlock()
+	}
+	pair, ok := h.Pairs[key]
+	return pair, ok
+}
+
+// Entries returns h's pairs in no particular order.
+func (h *Hash) Entries() []HashPair {
+	if h.Mu != nil {
+		h.Mu.RLock()
+		defer h.Mu.RUnlock()
+	}
+	pairs := make([]HashPair, 0, len(h.Pairs))
+	for _, pair := range h.Pairs {
+		pairs = append(pairs, pair)
+	}
+	return pairs
 }
 
 func (h *Hash) Type() ObjectType { return HASH_OBJ }
 func (h *Hash) Inspect() string {
 	var out bytes.Buffer
 	pairs := []string{}
-	for _, pair := range h.Pairs {
-		pairs = append(pairs, fmt.Sprintf("%s: %s", pair.Key.Inspect(), pair.Value.Inspect()))
+	for _, pair := range h.Entries() {
+		pairs = append(pairs, fmt.Sprintf("%s: %s", Repr(pair.Key), Repr(pair.Value)))
+	}
+	sort.Strings(pairs)
+	if h.Class != nil {
+		out.WriteString(h.Class.Name)
 	}
 	out.WriteString("{")
 	out.WriteString(strings.Join(pairs, ", "))
@@ -175,6 +366,72 @@ func (h *Hash) Inspect() string {
 	return out.String()
 }
 
+// Class is made by a class statement. Calling it makes an instance, a Hash
+// whose Class is set: Init runs with the new hash as its first argument,
+// self, fills in the fields and returns it. Methods also take self first;
+// looking one up on an instance binds it to a BoundMethod.
+type Class struct {
+	Name    string
+	Fields  []string // in declaration order
+	Init    *Closure
+	Methods map[string]*Closure
+}
+
+func (c *Class) Type() ObjectType { return CLASS_OBJ }
+func (c *Class) Inspect() string  { return "class " + c.Name }
+
+// NewInstance returns an empty instance of c for its Init to fill in.
+func (c *Class) NewInstance() *Hash {
+	return &Hash{Pairs: make(map[HashKey]HashPair, len(c.Fields)), Class: c}
+}
+
+// BoundMethod is a method looked up on an instance; calling it passes the
+// instance as self ahead of the call's arguments.
+type BoundMethod struct {
+	Receiver Object
+	Method   *Closure
+}
+
+func (bm *BoundMethod) Type() ObjectType { return METHOD_OBJ }
+func (bm *BoundMethod) Inspect() string {
+	if bm.Method.Fn.Name == "" {
+		return "method"
+	}
+	return "method " + bm.Method.Fn.Name
+}
+
+// Repr is the source-like form of obj: strings are quoted so that "1" and 1
+// print differently. Collections use it for their elements and the REPL for
+// results; Inspect remains the display form used by out and str().
+func Repr(obj Object) string {
+	if s, ok := obj.(*String); ok {
+		return strconv.Quote(s.Value)
+	}
+	return obj.Inspect()
+}
+
+// Freeze marks obj and every array or hash reachable from it as frozen.
+func Freeze(obj Object) {
+	switch v := obj.(type) {
+	case *Array:
+		if v.Frozen {
+			return
+		}
+		v.Frozen = true
+		for _, el := range v.Elements {
+			Freeze(el)
+		}
+	case *Hash:
+		if v.Frozen {
+			return
+		}
+		v.Frozen = true
+		for _, pair := range v.Pairs {
+			Freeze(pair.Value)
+		}
+	}
+}
+
 type Module struct {
 	Name string
 	Env  *Environment
@@ -0,0 +1,165 @@
+package object
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// PrettyOptions controls Pretty output.
+type PrettyOptions struct {
+	MaxDepth int  // collections nested deeper print as [...] / {...}; 0 means unlimited
+	MaxItems int  // elements shown per collection before "... N more"; 0 means unlimited
+	Width    int  // a collection stays on one line when it fits in this many columns
+	Color    bool // ANSI colors for strings, numbers and literals
+}
+
+// DefaultPretty is used by the REPL, terminal `out` and pretty().
+var DefaultPretty = PrettyOptions{MaxDepth: 6, MaxItems: 100, Width: 80}
+
+const (
+	ansiReset   = "\x1b[0m"
+	ansiString  = "\x1b[32m"
+	ansiNumber  = "\x1b[33m"
+	ansiLiteral = "\x1b[35m"
+	ansiDim     = "\x1b[90m"
+)
+
+// Pretty renders obj like Repr, but breaks collections that do not fit
+// opts.Width over indented lines and truncates huge or deeply nested ones.
+func Pretty(obj Object, opts PrettyOptions) string {
+	p := &prettyPrinter{opts: opts, active: make(map[Object]bool)}
+	text, _ := p.format(obj, 0, 0)
+	return text
+}
+
+type prettyPrinter struct {
+	opts   PrettyOptions
+	active map[Object]bool // collections on the current path, to stop on cycles
+}
+
+// format returns the rendering of obj and its visible width, or -1 when the
+// rendering spans several lines.
+func (p *prettyPrinter) format(obj Object, depth, indent int) (string, int) {
+	switch v := obj.(type) {
+	case *String:
+		s := Repr(v)
+		return p.paint(ansiString, s), len(s)
+	case *Integer, *Float:
+		s := v.Inspect()
+		return p.paint(ansiNumber, s), len(s)
+	case *Boolean, *Null:
+		s := v.Inspect()
+		return p.paint(ansiLiteral, s), len(s)
+	case *Array:
+		flat := true
+		for _, el := range v.Elements {
+			if el == nil {
+				continue
+			}
+			if t := el.Type(); t == ARRAY_OBJ || t == HASH_OBJ {
+				flat = false
+				break
+			}
+		}
+		return p.collection(v, "[", "]", len(v.Elements), flat, depth, indent, func(i int) (string, int) {
+			return p.format(v.Elements[i], depth+1, indent+1)
+		})
+	case *Hash:
+		pairs := v.Entries()
+		sort.Slice(pairs, func(i, j int) bool { return Repr(pairs[i].Key) < Repr(pairs[j].Key) })
+		open := "{"
+		if v.Class != nil {
+			open = v.Class.Name + "{"
+		}
+		return p.collection(v, open, "}", len(pairs), false, depth, indent, func(i int) (string, int) {
+			key, kw := p.format(pairs[i].Key, depth+1, indent+1)
+			val, vw := p.format(pairs[i].Value, depth+1, indent+1)
+			if vw < 0 {
+				return key + ": " + val, -1
+			}
+			return key + ": " + val, kw + 2 + vw
+		})
+	case nil:
+		return p.paint(ansiLiteral, "null"), 4
+	default:
+		s := obj.Inspect()
+		if strings.Contains(s, "\n") {
+			return s, -1
+		}
+		return s, len(s)
+	}
+}
+
+// collection lays out n items between open and close. Items of a flat
+// collection (scalars only) are packed several per line when it must wrap.
+func (p *prettyPrinter) collection(obj Object, open, close string, n int, flat bool, depth, indent int, item func(int) (string, int)) (string, int) {
+	if n == 0 {
+		return open + close, 2
+	}
+	if p.active[obj] || (p.opts.MaxDepth > 0 && depth >= p.opts.MaxDepth) {
+		s := open + "..." + close
+		return p.paint(ansiDim, s), len(s)
+	}
+	p.active[obj] = true
+	defer delete(p.active, obj)
+
+	shown := n
+	if p.opts.MaxItems > 0 && n > p.opts.MaxItems {
+		shown = p.opts.MaxItems
+	}
+	parts := make([]string, 0, shown+1)
+	widths := make([]int, 0, shown+1)
+	width := len(open) + len(close)
+	for i := 0; i < shown; i++ {
+		text, w := item(i)
+		parts = append(parts, text)
+		widths = append(widths, w)
+		if w < 0 || width < 0 {
+			width = -1
+			continue
+		}
+		if i > 0 {
+			width += 2
+		}
+		width += w
+	}
+	if shown < n {
+		more := fmt.Sprintf("... %d more", n-shown)
+		parts = append(parts, p.paint(ansiDim, more))
+		widths = append(widths, len(more))
+		if width >= 0 {
+			width += 2 + len(more)
+		}
+	}
+
+	if width >= 0 && indent*2+width <= p.opts.Width {
+		return open + strings.Join(parts, ", ") + close, width
+	}
+	pad := strings.Repeat("  ", indent+1)
+	var out strings.Builder
+	out.WriteString(open + "\n" + pad)
+	lineWidth := len(pad)
+	for i, part := range parts {
+		if i > 0 {
+			if flat && lineWidth+2+widths[i] <= p.opts.Width {
+				out.WriteString(", ")
+				lineWidth += 2
+			} else {
+				out.WriteString(",\n" + pad)
+				lineWidth = len(pad)
+			}
+		}
+		out.WriteString(part)
+		lineWidth += widths[i]
+	}
+	out.WriteString("\n" + strings.Repeat("  ", indent) + close)
+	return out.String(), -1
+}
+
+func (p *prettyPrinter) paint(color, s string) string {
+	if !p.opts.Color {
+		return s
+	}
+	return color + s + ansiReset
+}
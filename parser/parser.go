@@ -13,13 +13,16 @@ const (
 	_ int = iota
 	LOWEST
 	PIPE
+	NULLISH     // ??
 	OR          // ||
 	AND         // &&
 	EQUALS      // ==
 	LESSGREATER // > or <
+	RANGE       // .., below + so 0..n+1 ends at n+1
 	SUM         // +
 	PRODUCT     // *
 	PREFIX      // -X or !X
+	POWER       // **, above prefix so -2 ** 2 is -(2 ** 2)
 	INDEX       // array[index]
 	DOT         // obj.method
 	CALL        // myFunction(X)
@@ -30,16 +33,22 @@ var precedences = map[token.TokenType]int{
 	token.NOT_EQ:   EQUALS,
 	token.LT:       LESSGREATER,
 	token.GT:       LESSGREATER,
+	token.LT_EQ:    LESSGREATER,
+	token.GT_EQ:    LESSGREATER,
+	token.DOTDOT:   RANGE,
 	token.PLUS:     SUM,
 	token.MINUS:    SUM,
 	token.ASTERISK: PRODUCT,
 	token.SLASH:    PRODUCT,
 	token.MOD:      PRODUCT,
+	token.POWER:    POWER,
 	token.LPAREN:   CALL,
 	token.LBRACKET: INDEX,
 	token.DOT:      DOT,
+	token.OPTDOT:   DOT,
 	token.AND:      AND,
 	token.OR:       OR,
+	token.NULLISH:  NULLISH,
 	token.PIPE:     PIPE,
 	token.INC:      SUM,
 	token.DEC:      SUM,
@@ -60,6 +69,8 @@ type Parser struct {
 	curToken  token.Token
 	peekToken token.Token
 	Errors    []string
+	comments  []token.Token   // COMMENT tokens skipped so far
+	sources   token.SourceMap // the lexer's file map, for error positions
 
 	prefixParseFns map[token.TokenType]prefixParseFn
 	infixParseFns  map[token.TokenType]infixParseFn
@@ -67,8 +78,9 @@ type Parser struct {
 
 func New(l *lexer.Lexer) *Parser {
 	p := &Parser{
-		l:      l,
-		Errors: []string{},
+		l:       l,
+		Errors:  []string{},
+		sources: l.Sources(),
 	}
 
 	p.prefixParseFns = make(map[token.TokenType]prefixParseFn)
@@ -76,17 +88,20 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerPrefix(token.INT, p.parseIntegerLiteral)
 	p.registerPrefix(token.FLOAT, p.parseFloatLiteral)
 	p.registerPrefix(token.STRING, p.parseStringLiteral)
+	p.registerPrefix(token.HEREDOC, p.parseStringLiteral)
 	p.registerPrefix(token.LPAREN, p.parseGroupedExpression)
 	p.registerPrefix(token.LBRACKET, p.parseArrayLiteral)
 	p.registerPrefix(token.LBRACE, p.parseHashLiteral)
 	p.registerPrefix(token.FN, p.parseFunctionLiteral)
 	p.registerPrefix(token.TRUE, p.parseBoolean)
 	p.registerPrefix(token.FALSE, p.parseBoolean)
+	p.registerPrefix(token.NULL, p.parseNullLiteral)
 	p.registerPrefix(token.BANG, p.parsePrefixExpression)
 	p.registerPrefix(token.MINUS, p.parsePrefixExpression)
 	p.registerPrefix(token.BITNOT, p.parsePrefixExpression)
 	p.registerPrefix(token.MATCH, p.parseMatchExpression)
 	p.registerPrefix(token.TRY, p.parseTryExpression)
+	p.registerPrefix(token.ILLEGAL, p.parseIllegal)
 
 	p.infixParseFns = make(map[token.TokenType]infixParseFn)
 	p.registerInfix(token.PLUS, p.parseInfixExpression)
@@ -98,10 +113,16 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.NOT_EQ, p.parseInfixExpression)
 	p.registerInfix(token.LT, p.parseInfixExpression)
 	p.registerInfix(token.GT, p.parseInfixExpression)
+	p.registerInfix(token.LT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.GT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.POWER, p.parseInfixExpression)
+	p.registerInfix(token.DOTDOT, p.parseInfixExpression)
 	p.registerInfix(token.LPAREN, p.parseCallExpression)
 	p.registerInfix(token.LBRACKET, p.parseIndexExpression)
 	p.registerInfix(token.AND, p.parseInfixExpression)
 	p.registerInfix(token.OR, p.parseInfixExpression)
+	p.registerInfix(token.NULLISH, p.parseInfixExpression)
+	p.registerInfix(token.OPTDOT, p.parseMemberExpression)
 	p.registerInfix(token.PIPE, p.parsePipeExpression)
 	p.registerInfix(token.DOT, p.parseMemberExpression)
 	p.registerInfix(token.INC, p.parsePostfixExpression)
@@ -139,9 +160,61 @@ func (p *Parser) curPrecedence() int {
 	return LOWEST
 }
 
+// errorAt records a syntax error at tok's position.
+func (p *Parser) errorAt(tok token.Token, format string, args ...interface{}) {
+	p.Errors = append(p.Errors, p.sources.Position(tok.Line, tok.Col)+": "+fmt.Sprintf(format, args...))
+}
+
+// badExpression records a syntax error at tok and returns the
+// ErrorExpression that stands in for the expression it spoils, so callers
+// never get a nil expression.
+func (p *Parser) badExpression(tok token.Token, format string, args ...interface{}) ast.Expression {
+	p.errorAt(tok, format, args...)
+	exp := &ast.ErrorExpression{Token: tok}
+	exp.SetRange(ast.Span{Start: startOf(tok), End: endOf(tok)})
+	return exp
+}
+
+// startOf and endOf are where tok starts and where it ends, just past its
+// last character.
+func startOf(tok token.Token) ast.Pos { return ast.Pos{Line: tok.Line, Col: tok.Col} }
+func endOf(tok token.Token) ast.Pos   { return ast.Pos{Line: tok.EndLine, Col: tok.EndCol} }
+
+// finish sets node's span to run from start to the end of the current
+// token, where every parse function stops.
+func (p *Parser) finish(node ast.Node, start ast.Pos) {
+	node.SetRange(ast.Span{Start: start, End: endOf(p.curToken)})
+}
+
+// newIdentifier makes an Identifier of the current token.
+func (p *Parser) newIdentifier() *ast.Identifier {
+	id := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	p.finish(id, startOf(p.curToken))
+	return id
+}
+
+// isKeyword reports whether tok is a reserved word such as for or match.
+func isKeyword(tok token.Token) bool {
+	return tok.Type != token.IDENT && token.LookupIdent(tok.Literal) == tok.Type
+}
+
+// nameError records that tok cannot be used where a name (what) is
+// expected, saying so plainly when tok is a reserved word.
+func (p *Parser) nameError(tok token.Token, what string) {
+	if isKeyword(tok) {
+		p.errorAt(tok, "%s is a reserved keyword and cannot be used as %s", tok.Literal, what)
+		return
+	}
+	p.errorAt(tok, "expected %s, got %s", what, tok.Type)
+}
+
 func (p *Parser) nextToken() {
 	p.curToken = p.peekToken
 	p.peekToken = p.l.NextToken()
+	for p.peekToken.Type == token.COMMENT {
+		p.comments = append(p.comments, p.peekToken)
+		p.peekToken = p.l.NextToken()
+	}
 }
 
 func (p *Parser) ParseProgram() *ast.Program {
@@ -153,10 +226,38 @@ func (p *Parser) ParseProgram() *ast.Program {
 		}
 		p.nextToken()
 	}
+	program.Comments = p.comments
+	program.Sources = p.sources
+	if n := len(program.Statements); n > 0 {
+		program.SetRange(ast.Span{Start: program.Statements[0].Range().Start, End: program.Statements[n-1].Range().End})
+	}
 	return program
 }
 
+// parseStatement parses the statement at the current token and leaves the
+// current token on its last one. It returns nil for an empty statement or
+// one too broken to keep.
 func (p *Parser) parseStatement() ast.Statement {
+	start := startOf(p.curToken)
+	stmt := p.parseStatementKind()
+	if stmt != nil {
+		p.finish(stmt, start)
+	}
+	return stmt
+}
+
+func (p *Parser) parseStatementKind() ast.Statement {
+	// "set = 1" is a set missing its name, reported by parseSetStatement.
+	if isKeyword(p.curToken) && p.curToken.Type != token.SET && p.peekToken.Type == token.ASSIGN {
+		p.nameError(p.curToken, "a variable name")
+		p.nextToken()
+		p.nextToken()
+		p.parseExpression(LOWEST)
+		if p.peekToken.Type == token.SEMICOLON {
+			p.nextToken()
+		}
+		return nil
+	}
 	switch p.curToken.Type {
 	case token.SET:
 		return p.parseSetStatement()
@@ -168,12 +269,20 @@ func (p *Parser) parseStatement() ast.Statement {
 		return p.parseIfStatement()
 	case token.WHILE:
 		return p.parseWhileStatement()
+	case token.DO:
+		return p.parseDoWhileStatement()
 	case token.FOR:
 		return p.parseForStatement()
 	case token.SPAWN:
 		return p.parseSpawnStatement()
+	case token.CLASS:
+		return p.parseClassStatement()
+	case token.GROUP:
+		return p.parseGroupStatement()
 	case token.IMPORT:
 		return p.parseImportStatement()
+	case token.REQUIRES:
+		return p.parseRequiresStatement()
 	case token.THROW:
 		return p.parseThrowStatement()
 	case token.BREAK:
@@ -193,26 +302,106 @@ func (p *Parser) parseStatement() ast.Statement {
 	}
 }
 
-func (p *Parser) parseSetStatement() *ast.SetStatement {
-	stmt := &ast.SetStatement{Token: p.curToken}
+func (p *Parser) parseSetStatement() ast.Statement {
+	stmt := &ast.SetStatement{Token: p.curToken, Doc: p.curToken.Doc}
 	p.nextToken() // past set
 	if p.curToken.Type == token.CONST {
 		stmt.IsConst = true
 		p.nextToken()
+		// `deep` is contextual: only a modifier when another name follows it.
+		if p.curToken.Type == token.IDENT && p.curToken.Literal == "deep" && p.peekToken.Type == token.IDENT {
+			stmt.IsDeep = true
+			p.nextToken()
+		}
+	}
+	if p.curToken.Type == token.LBRACKET || p.curToken.Type == token.LBRACE {
+		return p.parseDestructureStatement(stmt)
 	}
 	if p.curToken.Type != token.IDENT {
-		p.Errors = append(p.Errors, fmt.Sprintf("Line %d, Col %d: expected identifier", p.curToken.Line, p.curToken.Col))
-		return nil
+		p.nameError(p.curToken, "a variable name")
+		if !isKeyword(p.curToken) {
+			return nil
+		}
+		// Parse the rest as usual so the keyword is the only error.
 	}
-	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	stmt.Name = p.newIdentifier()
 
 	if p.peekToken.Type != token.ASSIGN {
-		p.Errors = append(p.Errors, fmt.Sprintf("Line %d, Col %d: expected assign =", p.peekToken.Line, p.peekToken.Col))
+		p.errorAt(p.peekToken, "expected assign =")
 		return nil
 	}
 	p.nextToken() // to =
 	p.nextToken() // past =
 
+	stmt.Value = p.parseExpression(LOWEST)
+	if fl, ok := stmt.Value.(*ast.FunctionLiteral); ok {
+		fl.Name = stmt.Name.Value
+		fl.Doc = stmt.Doc
+	}
+
+	if p.peekToken.Type == token.SEMICOLON {
+		p.nextToken()
+	}
+	return stmt
+}
+
+// parseDestructureStatement parses the pattern and value of `set [a, b] =
+// value` or `set {name, age: years} = value`, with the set and its
+// modifiers already read into set.
+func (p *Parser) parseDestructureStatement(set *ast.SetStatement) ast.Statement {
+	stmt := &ast.DestructureStatement{Token: set.Token, IsConst: set.IsConst, IsDeep: set.IsDeep}
+	var end token.TokenType = token.RBRACKET
+	if p.curToken.Type == token.LBRACE {
+		stmt.IsHash = true
+		end = token.RBRACE
+	}
+	seen := make(map[string]bool)
+	for p.peekToken.Type != end {
+		p.nextToken()
+		if p.curToken.Type != token.IDENT {
+			p.nameError(p.curToken, "a variable name")
+			return nil
+		}
+		key := p.curToken.Literal
+		if stmt.IsHash && p.peekToken.Type == token.COLON {
+			p.nextToken()
+			p.nextToken()
+			if p.curToken.Type != token.IDENT {
+				p.nameError(p.curToken, "a variable name")
+				return nil
+			}
+		}
+		name := p.newIdentifier()
+		if seen[name.Value] {
+			p.errorAt(p.curToken, "%s appears twice in the pattern", name.Value)
+			return nil
+		}
+		seen[name.Value] = true
+		stmt.Names = append(stmt.Names, name)
+		if stmt.IsHash {
+			stmt.Keys = append(stmt.Keys, key)
+		}
+		if p.peekToken.Type != token.COMMA {
+			break
+		}
+		p.nextToken()
+	}
+	if p.peekToken.Type != end {
+		p.errorAt(p.peekToken, "expected , or %s in the pattern", end)
+		return nil
+	}
+	p.nextToken()
+	if len(stmt.Names) == 0 {
+		p.errorAt(p.curToken, "the pattern needs at least one name")
+		return nil
+	}
+
+	if p.peekToken.Type != token.ASSIGN {
+		p.errorAt(p.peekToken, "expected assign =")
+		return nil
+	}
+	p.nextToken() // to =
+	p.nextToken() // past =
 	stmt.Value = p.parseExpression(LOWEST)
 
 	if p.peekToken.Type == token.SEMICOLON {
@@ -223,12 +412,15 @@ func (p *Parser) parseSetStatement() *ast.SetStatement {
 
 func (p *Parser) parseAssignStatement() *ast.AssignStatement {
 	stmt := &ast.AssignStatement{Token: token.Token{Type: token.ASSIGN, Literal: "="}}
-	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	stmt.Name = p.newIdentifier()
 
 	p.nextToken() // past identifier (to =)
 	p.nextToken() // past =
 
 	stmt.Value = p.parseExpression(LOWEST)
+	if fl, ok := stmt.Value.(*ast.FunctionLiteral); ok {
+		fl.Name = stmt.Name.Value
+	}
 
 	if p.peekToken.Type == token.SEMICOLON {
 		p.nextToken()
@@ -284,7 +476,7 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	return stmt
 }
 
-func (p *Parser) parseImportStatement() *ast.ImportStatement {
+func (p *Parser) parseImportStatement() ast.Statement {
 	stmt := &ast.ImportStatement{Token: p.curToken}
 	p.nextToken()
 	stmt.Path = p.parseExpression(LOWEST)
@@ -293,10 +485,10 @@ func (p *Parser) parseImportStatement() *ast.ImportStatement {
 		p.nextToken() // past path
 		p.nextToken() // past as
 		if p.curToken.Type != token.IDENT {
-			p.Errors = append(p.Errors, fmt.Sprintf("expected identifier after 'as', got %s", p.curToken.Type))
+			p.nameError(p.curToken, "a module name after 'as'")
 			return nil
 		}
-		stmt.Alias = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+		stmt.Alias = p.newIdentifier()
 	}
 
 	if p.peekToken.Type == token.SEMICOLON {
@@ -305,6 +497,20 @@ func (p *Parser) parseImportStatement() *ast.ImportStatement {
 	return stmt
 }
 
+func (p *Parser) parseRequiresStatement() ast.Statement {
+	stmt := &ast.RequiresStatement{Token: p.curToken}
+	if p.peekToken.Type != token.STRING {
+		p.errorAt(p.peekToken, "expected version string after requires")
+		return nil
+	}
+	p.nextToken()
+	stmt.Version = p.curToken.Literal
+	if p.peekToken.Type == token.SEMICOLON {
+		p.nextToken()
+	}
+	return stmt
+}
+
 func (p *Parser) parseIfStatement() *ast.IfStatement {
 	stmt := &ast.IfStatement{Token: p.curToken}
 	p.nextToken() // past if
@@ -317,8 +523,7 @@ func (p *Parser) parseIfStatement() *ast.IfStatement {
 	} else {
 		// support single statement without block
 		p.nextToken()
-		consequence := p.parseStatement()
-		stmt.Consequence = &ast.BlockStatement{Statements: []ast.Statement{consequence}}
+		stmt.Consequence = p.parseSingleStatement()
 	}
 
 	if p.peekToken.Type == token.ELSE {
@@ -328,8 +533,7 @@ func (p *Parser) parseIfStatement() *ast.IfStatement {
 			stmt.Alternative = p.parseBlockStatement()
 		} else {
 			p.nextToken()
-			alternative := p.parseStatement()
-			stmt.Alternative = &ast.BlockStatement{Statements: []ast.Statement{alternative}}
+			stmt.Alternative = p.parseSingleStatement()
 		}
 	}
 	return stmt
@@ -345,14 +549,37 @@ func (p *Parser) parseWhileStatement() *ast.WhileStatement {
 		stmt.Body = p.parseBlockStatement()
 	} else {
 		p.nextToken()
-		body := p.parseStatement()
-		stmt.Body = &ast.BlockStatement{Statements: []ast.Statement{body}}
+		stmt.Body = p.parseSingleStatement()
+	}
+	return stmt
+}
+
+// parseDoWhileStatement parses `do { ... } while cond;`. The body must be a
+// block, so the while that ends it cannot be mistaken for a loop of its own.
+func (p *Parser) parseDoWhileStatement() ast.Statement {
+	stmt := &ast.DoWhileStatement{Token: p.curToken}
+	if p.peekToken.Type != token.LBRACE {
+		p.errorAt(p.peekToken, "expected { for do body, got %s", p.peekToken.Type)
+		return nil
+	}
+	p.nextToken()
+	stmt.Body = p.parseBlockStatement()
+	if p.peekToken.Type != token.WHILE {
+		p.errorAt(p.peekToken, "expected while after do body, got %s", p.peekToken.Type)
+		return nil
+	}
+	p.nextToken()
+	p.nextToken()
+	stmt.Condition = p.parseExpression(LOWEST)
+	if p.peekToken.Type == token.SEMICOLON {
+		p.nextToken()
 	}
 	return stmt
 }
 
 func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 	block := &ast.BlockStatement{Token: p.curToken}
+	start := startOf(p.curToken)
 	block.Statements = []ast.Statement{}
 
 	p.nextToken()
@@ -364,12 +591,67 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 		}
 		p.nextToken()
 	}
+	p.finish(block, start)
 	return block
 }
 
-func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
+// parseSingleStatement parses the one statement allowed in place of a
+// block, as in `if (x) out x;`, wrapped in a block.
+func (p *Parser) parseSingleStatement() *ast.BlockStatement {
+	block := &ast.BlockStatement{Token: p.curToken, Statements: []ast.Statement{}}
+	start := startOf(p.curToken)
+	if stmt := p.parseStatement(); stmt != nil {
+		block.Statements = append(block.Statements, stmt)
+	}
+	p.finish(block, start)
+	return block
+}
+
+func (p *Parser) parseExpressionStatement() ast.Statement {
 	stmt := &ast.ExpressionStatement{Token: p.curToken}
 	stmt.Expression = p.parseExpression(LOWEST)
+	if p.peekToken.Type == token.ASSIGN {
+		return p.parseIndexAssignStatement(stmt.Expression)
+	}
+	if p.peekToken.Type == token.SEMICOLON {
+		p.nextToken()
+	}
+	return stmt
+}
+
+// parseIndexAssignStatement parses the `= value` of `arr[i] = value` or
+// `obj.field = value`, with target already parsed. Only an index or member
+// expression can be assigned to, and not through ?., which may have
+// nothing to store into.
+func (p *Parser) parseIndexAssignStatement(target ast.Expression) ast.Statement {
+	stmt := &ast.IndexAssignStatement{Token: p.peekToken, Target: target}
+	switch target.(type) {
+	case *ast.IndexExpression, *ast.MemberExpression:
+	default:
+		p.errorAt(p.peekToken, "cannot assign to %s; expected a variable, index or member", target.String())
+		return nil
+	}
+	for e := target; e != nil; {
+		switch n := e.(type) {
+		case *ast.IndexExpression:
+			e = n.Left
+		case *ast.SliceExpression:
+			e = n.Left
+		case *ast.MemberExpression:
+			if n.Optional {
+				p.errorAt(p.peekToken, "cannot assign through ?.")
+				return nil
+			}
+			e = n.Object
+		case *ast.CallExpression:
+			e = n.Function
+		default:
+			e = nil
+		}
+	}
+	p.nextToken() // to =
+	p.nextToken() // past =
+	stmt.Value = p.parseExpression(LOWEST)
 	if p.peekToken.Type == token.SEMICOLON {
 		p.nextToken()
 	}
@@ -377,12 +659,16 @@ func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
 }
 
 func (p *Parser) parseExpression(precedence int) ast.Expression {
+	start := p.curToken
 	prefix := p.prefixParseFns[p.curToken.Type]
 	if prefix == nil {
-		p.Errors = append(p.Errors, fmt.Sprintf("Line %d, Col %d: no prefix function for %s", p.curToken.Line, p.curToken.Col, p.curToken.Type))
-		return nil
+		if isKeyword(p.curToken) {
+			return p.badExpression(p.curToken, "%s is a reserved keyword and cannot start an expression", p.curToken.Literal)
+		}
+		return p.badExpression(p.curToken, "no prefix function for %s", p.curToken.Type)
 	}
 	leftExp := prefix()
+	p.finish(leftExp, startOf(start))
 
 	for p.peekToken.Type != token.SEMICOLON && precedence < p.peekPrecedence() {
 		infix := p.infixParseFns[p.peekToken.Type]
@@ -390,18 +676,34 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 			return leftExp
 		}
 		p.nextToken()
+		left := leftExp.Range().Start
 		leftExp = infix(leftExp)
+		p.finish(leftExp, left)
 	}
 	return leftExp
 }
 
+// parseIllegal reports a token the lexer rejected. Its literal is either
+// the offending character or a description such as "unterminated string
+// literal".
+func (p *Parser) parseIllegal() ast.Expression {
+	if len([]rune(p.curToken.Literal)) == 1 {
+		return p.badExpression(p.curToken, "illegal character %q", p.curToken.Literal)
+	}
+	return p.badExpression(p.curToken, "%s", p.curToken.Literal)
+}
+
 func (p *Parser) parseIdentifier() ast.Expression {
 	return &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 }
 
 func (p *Parser) parseIntegerLiteral() ast.Expression {
 	lit := &ast.IntegerLiteral{Token: p.curToken}
-	value, _ := strconv.ParseInt(p.curToken.Literal, 0, 64)
+	// Base 0 takes the 0x, 0b and 0o prefixes.
+	value, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
+	if err != nil {
+		return p.badExpression(p.curToken, "could not parse %q as integer", p.curToken.Literal)
+	}
 	lit.Value = value
 	return lit
 }
@@ -417,25 +719,40 @@ func (p *Parser) parsePrefixExpression() ast.Expression {
 }
 
 func (p *Parser) parseStringLiteral() ast.Expression {
-	lit := p.curToken.Literal
-	if !strings.Contains(lit, "${") {
-		return &ast.StringLiteral{Token: p.curToken, Value: lit}
+	tok := p.curToken
+	lit := tok.Literal
+	// The value is lit[from:to], and text gives the value of a part of it.
+	from, to := 0, len(lit)
+	text := func(a, b int) string { return lit[a:b] }
+	if tok.Type == token.HEREDOC {
+		var indent string
+		from, to, indent = heredocLayout(lit)
+		text = func(a, b int) string { return dedent(lit[a:b], indent, a == from && from > 0) }
+	}
+	if !strings.Contains(lit[from:to], "${") {
+		return &ast.StringLiteral{Token: tok, Value: text(from, to)}
 	}
 
-	exp := &ast.InterpolatedString{Token: p.curToken, Parts: []ast.Expression{}}
+	exp := &ast.InterpolatedString{Token: tok, Parts: []ast.Expression{}}
+	textPart := func(a, b int) *ast.StringLiteral {
+		start, end := posInString(tok, a), posInString(tok, b)
+		part := &ast.StringLiteral{Token: token.Token{Type: token.STRING, Literal: lit[a:b], Line: start.Line, Col: start.Col}, Value: text(a, b)}
+		part.SetRange(ast.Span{Start: start, End: end})
+		return part
+	}
 
 	// Simple interpolation parser
-	i := 0
-	for i < len(lit) {
-		idx := strings.Index(lit[i:], "${")
+	i := from
+	for i < to {
+		idx := strings.Index(lit[i:to], "${")
 		if idx == -1 {
-			exp.Parts = append(exp.Parts, &ast.StringLiteral{Value: lit[i:]})
+			exp.Parts = append(exp.Parts, textPart(i, to))
 			break
 		}
 
 		// Add literal part before ${
 		if idx > 0 {
-			exp.Parts = append(exp.Parts, &ast.StringLiteral{Value: lit[i : i+idx]})
+			exp.Parts = append(exp.Parts, textPart(i, i+idx))
 		}
 
 		i += idx + 2 // move past ${
@@ -443,17 +760,21 @@ func (p *Parser) parseStringLiteral() ast.Expression {
 		// Find closing }
 		// This is a bit naive (doesn't handle nested {})
 		// but for a start it works.
-		end := strings.Index(lit[i:], "}")
+		end := strings.Index(lit[i:to], "}")
 		if end == -1 {
-			p.Errors = append(p.Errors, "unterminated interpolation")
-			return nil
+			return p.badExpression(p.curToken, "unterminated interpolation")
 		}
 
 		exprStr := lit[i : i+end]
 		// Lex and Parse the expression inside
-		subL := lexer.New(exprStr)
+		at := posInString(tok, i)
+		subL := lexer.NewAt(exprStr, at.Line, at.Col)
 		subP := New(subL)
+		subP.sources = p.sources
 		subProg := subP.ParseProgram()
+		for _, msg := range subP.Errors {
+			p.errorAt(p.curToken, "in interpolation ${%s}: %s", exprStr, msg)
+		}
 		if len(subProg.Statements) > 0 {
 			if stmt, ok := subProg.Statements[0].(*ast.ExpressionStatement); ok {
 				exp.Parts = append(exp.Parts, stmt.Expression)
@@ -466,16 +787,74 @@ func (p *Parser) parseStringLiteral() ast.Expression {
 	return exp
 }
 
+// heredocLayout returns where the value of a """ string's literal starts
+// and ends, and the indentation to strip from its lines. A line break
+// right after the opening quotes is not part of the value, nor is the last
+// line when it holds only the indentation of the closing quotes; that
+// indentation is removed from the start of every line.
+func heredocLayout(lit string) (from, to int, indent string) {
+	to = len(lit)
+	if i := strings.LastIndexByte(lit, '\n'); i >= 0 && strings.Trim(lit[i+1:], " \t") == "" {
+		indent = lit[i+1:]
+		to = i
+		if to > 0 && lit[to-1] == '\r' {
+			to--
+		}
+	}
+	if strings.HasPrefix(lit, "\r\n") {
+		from = 2
+	} else if strings.HasPrefix(lit, "\n") {
+		from = 1
+	}
+	return min(from, to), to, indent
+}
+
+// dedent removes indent from the start of each line of s after the first,
+// and from the first too when s starts a line.
+func dedent(s, indent string, atLineStart bool) string {
+	if indent == "" {
+		return s
+	}
+	lines := strings.Split(s, "\n")
+	for i := range lines {
+		if i > 0 || atLineStart {
+			lines[i] = strings.TrimPrefix(lines[i], indent)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// posInString returns the position of byte offset i in the literal of the
+// string token tok, which starts just after the opening quote or quotes.
+func posInString(tok token.Token, i int) ast.Pos {
+	pos := ast.Pos{Line: tok.Line, Col: tok.Col + 1}
+	if tok.Type == token.HEREDOC {
+		pos.Col += 2
+	}
+	for _, ch := range tok.Literal[:i] {
+		if ch == '\n' {
+			pos.Line++
+			pos.Col = 1
+		} else {
+			pos.Col++
+		}
+	}
+	return pos
+}
+
 func (p *Parser) parseBoolean() ast.Expression {
 	return &ast.Boolean{Token: p.curToken, Value: p.curToken.Type == token.TRUE}
 }
 
+func (p *Parser) parseNullLiteral() ast.Expression {
+	return &ast.NullLiteral{Token: p.curToken}
+}
+
 func (p *Parser) parseGroupedExpression() ast.Expression {
 	p.nextToken()
 	exp := p.parseExpression(LOWEST)
 	if p.peekToken.Type != token.RPAREN {
-		p.Errors = append(p.Errors, fmt.Sprintf("Line %d, Col %d: expected )", p.peekToken.Line, p.peekToken.Col))
-		return nil
+		return p.badExpression(p.peekToken, "expected )")
 	}
 	p.nextToken()
 	return exp
@@ -496,8 +875,7 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 		key := p.parseExpression(LOWEST)
 
 		if p.peekToken.Type != token.COLON {
-			p.Errors = append(p.Errors, fmt.Sprintf("Line %d, Col %d: expected :", p.peekToken.Line, p.peekToken.Col))
-			return nil
+			return p.badExpression(p.peekToken, "expected :")
 		}
 		p.nextToken() // move to colon
 		p.nextToken() // past colon
@@ -506,8 +884,7 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 		hash.Pairs[key] = value
 
 		if p.peekToken.Type != token.RBRACE && p.peekToken.Type != token.COMMA {
-			p.Errors = append(p.Errors, fmt.Sprintf("Line %d, Col %d: expected , or }", p.peekToken.Line, p.peekToken.Col))
-			return nil
+			return p.badExpression(p.peekToken, "expected , or }")
 		}
 		if p.peekToken.Type == token.COMMA {
 			p.nextToken()
@@ -531,23 +908,24 @@ func (p *Parser) parseExpressionList(end token.TokenType) []ast.Expression {
 		list = append(list, p.parseExpression(LOWEST))
 	}
 	if p.peekToken.Type != end {
-		return nil
+		p.errorAt(p.peekToken, "expected , or %s", end)
+		return list
 	}
 	p.nextToken()
 	return list
 }
 
 func (p *Parser) parseFunctionLiteral() ast.Expression {
-	lit := &ast.FunctionLiteral{Token: p.curToken}
+	lit := &ast.FunctionLiteral{Token: p.curToken, Doc: p.curToken.Doc}
 	if p.peekToken.Type != token.LPAREN {
-		return nil
+		return p.badExpression(p.peekToken, "expected ( after fn")
 	}
 	p.nextToken()
 
 	lit.Parameters = p.parseFunctionParameters()
 
 	if p.peekToken.Type != token.LBRACE {
-		return nil
+		return p.badExpression(p.peekToken, "expected { for function body")
 	}
 	p.nextToken()
 
@@ -563,17 +941,21 @@ func (p *Parser) parseFunctionParameters() []*ast.Identifier {
 	}
 	p.nextToken()
 
-	ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-	identifiers = append(identifiers, ident)
-
-	for p.peekToken.Type == token.COMMA {
+	for {
+		if p.curToken.Type != token.IDENT {
+			p.nameError(p.curToken, "a parameter name")
+			return identifiers
+		}
+		identifiers = append(identifiers, p.newIdentifier())
+		if p.peekToken.Type != token.COMMA {
+			break
+		}
 		p.nextToken()
 		p.nextToken()
-		ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-		identifiers = append(identifiers, ident)
 	}
 	if p.peekToken.Type != token.RPAREN {
-		return nil
+		p.errorAt(p.peekToken, "expected , or ) after parameter")
+		return identifiers
 	}
 	p.nextToken()
 	return identifiers
@@ -586,6 +968,10 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 		Left:     left,
 	}
 	precedence := p.curPrecedence()
+	if expression.Token.Type == token.POWER {
+		// Right associative: 2 ** 3 ** 2 is 2 ** (3 ** 2).
+		precedence--
+	}
 	p.nextToken()
 	expression.Right = p.parseExpression(precedence)
 	return expression
@@ -597,12 +983,30 @@ func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
 	return exp
 }
 
+// parseIndexExpression parses left[index], or the slice left[low:high] in
+// which either bound may be left out.
 func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
-	exp := &ast.IndexExpression{Token: p.curToken, Left: left}
+	tok := p.curToken
 	p.nextToken()
-	exp.Index = p.parseExpression(LOWEST)
+	var index ast.Expression
+	if p.curToken.Type != token.COLON {
+		index = p.parseExpression(LOWEST)
+		if p.peekToken.Type != token.COLON {
+			if p.peekToken.Type != token.RBRACKET {
+				return p.badExpression(p.peekToken, "expected ] or :")
+			}
+			p.nextToken()
+			return &ast.IndexExpression{Token: tok, Left: left, Index: index}
+		}
+		p.nextToken()
+	}
+	exp := &ast.SliceExpression{Token: tok, Left: left, Low: index}
 	if p.peekToken.Type != token.RBRACKET {
-		return nil
+		p.nextToken()
+		exp.High = p.parseExpression(LOWEST)
+		if p.peekToken.Type != token.RBRACKET {
+			return p.badExpression(p.peekToken, "expected ]")
+		}
 	}
 	p.nextToken()
 	return exp
@@ -612,8 +1016,7 @@ func (p *Parser) parseFloatLiteral() ast.Expression {
 	lit := &ast.FloatLiteral{Token: p.curToken}
 	value, err := strconv.ParseFloat(p.curToken.Literal, 64)
 	if err != nil {
-		p.Errors = append(p.Errors, fmt.Sprintf("could not parse %q as float", p.curToken.Literal))
-		return nil
+		return p.badExpression(p.curToken, "could not parse %q as float", p.curToken.Literal)
 	}
 	lit.Value = value
 	return lit
@@ -628,14 +1031,14 @@ func (p *Parser) parsePostfixExpression(left ast.Expression) ast.Expression {
 }
 
 func (p *Parser) parseMemberExpression(left ast.Expression) ast.Expression {
-	exp := &ast.MemberExpression{Token: p.curToken, Object: left}
+	exp := &ast.MemberExpression{Token: p.curToken, Object: left, Optional: p.curToken.Type == token.OPTDOT}
 
 	p.nextToken() // move to member name
-	if p.curToken.Type != token.IDENT {
-		p.Errors = append(p.Errors, fmt.Sprintf("expected identifier after '.', got %s", p.curToken.Type))
-		return nil
+	// Keywords are allowed as member names (cache.set, obj.match).
+	if p.curToken.Type != token.IDENT && token.LookupIdent(p.curToken.Literal) != p.curToken.Type {
+		return p.badExpression(p.curToken, "expected identifier after '%s', got %s", exp.Token.Literal, p.curToken.Type)
 	}
-	exp.Member = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	exp.Member = p.newIdentifier()
 
 	return exp
 }
@@ -654,7 +1057,7 @@ func (p *Parser) parseForStatement() ast.Statement {
 
 	// for x in expr { ... }
 	if p.curToken.Type == token.IDENT && p.peekToken.Type == token.IN {
-		stmt := &ast.ForInStatement{Token: tok, Variable: &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}}
+		stmt := &ast.ForInStatement{Token: tok, Variable: p.newIdentifier()}
 		p.nextToken() // past ident
 		p.nextToken() // past in
 		stmt.Iterable = p.parseExpression(LOWEST)
@@ -667,6 +1070,11 @@ func (p *Parser) parseForStatement() ast.Statement {
 		return stmt
 	}
 
+	if isKeyword(p.curToken) && p.peekToken.Type == token.IN {
+		p.nameError(p.curToken, "a loop variable")
+		return nil
+	}
+
 	// C-style for ( init ; condition ; update ) { ... }
 	stmt := &ast.ForStatement{Token: tok}
 
@@ -717,19 +1125,26 @@ func (p *Parser) parseMatchExpression() ast.Expression {
 	exp.Value = p.parseExpression(LOWEST)
 
 	if p.peekToken.Type != token.LBRACE {
-		p.Errors = append(p.Errors, fmt.Sprintf("expected { after match expression, got %s", p.peekToken.Type))
-		return nil
+		return p.badExpression(p.peekToken, "expected { after match expression, got %s", p.peekToken.Type)
 	}
 	p.nextToken() // move to {
 
 	for p.peekToken.Type != token.RBRACE && p.peekToken.Type != token.EOF {
 		p.nextToken()
 		mCase := &ast.MatchCase{}
+		start := startOf(p.curToken)
 		mCase.Pattern = p.parseExpression(LOWEST)
+		if p.peekToken.Type == token.IF {
+			p.nextToken() // to if
+			if p.peekToken.Type == token.FAT_ARROW {
+				return p.badExpression(p.peekToken, "expected a condition after if in match case")
+			}
+			p.nextToken() // past if
+			mCase.Guard = p.parseExpression(LOWEST)
+		}
 
 		if p.peekToken.Type != token.FAT_ARROW {
-			p.Errors = append(p.Errors, fmt.Sprintf("expected => after pattern, got %s", p.peekToken.Type))
-			return nil
+			return p.badExpression(p.peekToken, "expected => after pattern, got %s", p.peekToken.Type)
 		}
 		p.nextToken() // to =>
 		p.nextToken() // past =>
@@ -738,9 +1153,9 @@ func (p *Parser) parseMatchExpression() ast.Expression {
 			mCase.Body = p.parseBlockStatement()
 		} else {
 			// support single statement
-			stmt := p.parseStatement()
-			mCase.Body = &ast.BlockStatement{Statements: []ast.Statement{stmt}}
+			mCase.Body = p.parseSingleStatement()
 		}
+		mCase.SetRange(ast.Span{Start: start, End: endOf(p.curToken)})
 		exp.Cases = append(exp.Cases, mCase)
 
 		if p.peekToken.Type == token.COMMA {
@@ -749,8 +1164,7 @@ func (p *Parser) parseMatchExpression() ast.Expression {
 	}
 
 	if p.peekToken.Type != token.RBRACE {
-		p.Errors = append(p.Errors, "missing } in match expression")
-		return nil
+		return p.badExpression(p.peekToken, "missing } in match expression")
 	}
 	p.nextToken() // past }
 
@@ -775,18 +1189,95 @@ func (p *Parser) parseSpawnStatement() ast.Statement {
 	return stmt
 }
 
+// parseClassStatement parses `class Name { ... }`, whose body holds fields,
+// `name = default;` or just `name;`, and methods, `fn name(params) { ... }`.
+func (p *Parser) parseClassStatement() ast.Statement {
+	stmt := &ast.ClassStatement{Token: p.curToken, Doc: p.curToken.Doc}
+	p.nextToken()
+	if p.curToken.Type != token.IDENT {
+		p.nameError(p.curToken, "a class name")
+		return nil
+	}
+	stmt.Name = p.newIdentifier()
+	if p.peekToken.Type != token.LBRACE {
+		p.errorAt(p.peekToken, "expected { after class %s, got %s", stmt.Name.Value, p.peekToken.Type)
+		return nil
+	}
+	p.nextToken()
+
+	seen := make(map[string]bool)
+	for p.peekToken.Type != token.RBRACE {
+		p.nextToken()
+		start := p.curToken
+		var name string
+		switch p.curToken.Type {
+		case token.SEMICOLON:
+			continue
+		case token.EOF:
+			p.errorAt(p.curToken, "missing } in class %s", stmt.Name.Value)
+			return nil
+		case token.FN:
+			p.nextToken()
+			if p.curToken.Type != token.IDENT {
+				p.nameError(p.curToken, "a method name")
+				return nil
+			}
+			name = p.curToken.Literal
+			lit, ok := p.parseFunctionLiteral().(*ast.FunctionLiteral)
+			if !ok {
+				return nil
+			}
+			lit.Token, lit.Name, lit.Doc = start, name, start.Doc
+			p.finish(lit, startOf(start))
+			stmt.Methods = append(stmt.Methods, lit)
+		case token.IDENT:
+			field := &ast.ClassField{Name: p.newIdentifier()}
+			name = field.Name.Value
+			if p.peekToken.Type == token.ASSIGN {
+				p.nextToken()
+				p.nextToken()
+				field.Value = p.parseExpression(LOWEST)
+			}
+			field.SetRange(ast.Span{Start: startOf(start), End: endOf(p.curToken)})
+			if p.peekToken.Type == token.SEMICOLON {
+				p.nextToken()
+			}
+			stmt.Fields = append(stmt.Fields, field)
+		default:
+			p.errorAt(p.curToken, "expected a field or fn in class %s, got %s", stmt.Name.Value, p.curToken.Type)
+			return nil
+		}
+		if seen[name] {
+			p.errorAt(start, "%s is declared twice in class %s", name, stmt.Name.Value)
+			return nil
+		}
+		seen[name] = true
+	}
+	p.nextToken() // to }
+	return stmt
+}
+
+func (p *Parser) parseGroupStatement() ast.Statement {
+	stmt := &ast.GroupStatement{Token: p.curToken}
+	if p.peekToken.Type != token.LBRACE {
+		p.Errors = append(p.Errors, "expected { after group")
+		return nil
+	}
+	p.nextToken()
+	stmt.Body = p.parseBlockStatement()
+	return stmt
+}
+
 func (p *Parser) parseTryExpression() ast.Expression {
 	exp := &ast.TryExpression{Token: p.curToken}
 	if p.peekToken.Type != token.LBRACE {
-		p.Errors = append(p.Errors, "expected { after try")
-		return nil
+		return p.badExpression(p.peekToken, "expected { after try")
 	}
 	p.nextToken()
 	exp.Block = p.parseBlockStatement()
 
 	if p.peekToken.Type != token.CATCH {
-		p.Errors = append(p.Errors, "expected catch after try block")
-		return nil
+		return p.badExpression(p.peekToken, "expected catch after try block")
 	}
 	p.nextToken() // to catch
 
@@ -794,20 +1285,18 @@ func (p *Parser) parseTryExpression() ast.Expression {
 		p.nextToken() // to (
 		p.nextToken() // to ident
 		if p.curToken.Type != token.IDENT {
-			p.Errors = append(p.Errors, "expected identifier in catch")
-			return nil
+			p.nameError(p.curToken, "a catch variable")
+			return &ast.ErrorExpression{Token: p.curToken}
 		}
-		exp.CatchParameter = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+		exp.CatchParameter = p.newIdentifier()
 		if p.peekToken.Type != token.RPAREN {
-			p.Errors = append(p.Errors, "expected ) after catch parameter")
-			return nil
+			return p.badExpression(p.peekToken, "expected ) after catch parameter")
 		}
 		p.nextToken() // to )
 	}
 
 	if p.peekToken.Type != token.LBRACE {
-		p.Errors = append(p.Errors, "expected { after catch")
-		return nil
+		return p.badExpression(p.peekToken, "expected { after catch")
 	}
 	p.nextToken()
 	exp.CatchBlock = p.parseBlockStatement()
@@ -0,0 +1,102 @@
+package repl
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"xon/compiler"
+	"xon/object"
+	"xon/vm"
+)
+
+const DEBUG_PROMPT = "debug>> "
+
+// PostMortem opens a REPL on a script that failed with runErr. comp is the
+// compiler that built the script and globals its global slots, so every
+// global stays reachable; the locals of the failing (innermost) frame are
+// bound as globals too. Besides expressions it accepts:
+//
+//	where     print the call chain, innermost last
+//	locals    print the variables of the loaded frame
+//	frame N   load the variables of frame N from `where` instead
+//	exit      leave the debugger
+func PostMortem(in io.Reader, out io.Writer, runErr error, machine *vm.VM, comp *compiler.Compiler, globals []object.Object, globalsMu *sync.RWMutex) {
+	frames := machine.Frames()
+	if len(frames) == 0 {
+		return
+	}
+	comp.AllowRedeclare = true
+	pretty := prettyFor(out)
+
+	fmt.Fprintf(out, "Post-mortem debugger: %s\n", firstLine(runErr.Error()))
+	printWhere(out, frames)
+	current := len(frames) - 1
+	loadFrame(out, frames[current], comp, globals, globalsMu)
+	fmt.Fprintln(out, "Type where, locals, frame N or an expression; exit to quit.")
+
+	scanner := bufio.NewScanner(in)
+	for {
+		fmt.Fprint(out, DEBUG_PROMPT)
+		if !scanner.Scan() {
+			return
+		}
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+		case line == "exit" || line == "quit":
+			return
+		case line == "where":
+			printWhere(out, frames)
+		case line == "locals":
+			printLocals(out, frames[current], pretty)
+		case strings.HasPrefix(line, "frame "):
+			n, err := strconv.Atoi(strings.TrimSpace(line[len("frame "):]))
+			if err != nil || n < 0 || n >= len(frames) {
+				fmt.Fprintf(out, "no frame %s; see where\n", strings.TrimSpace(line[len("frame "):]))
+				continue
+			}
+			current = n
+			loadFrame(out, frames[current], comp, globals, globalsMu)
+		default:
+			evalLine(out, line, comp, globals, globalsMu, pretty)
+		}
+	}
+}
+
+func printWhere(out io.Writer, frames []vm.FrameInfo) {
+	fmt.Fprintln(out, "call chain (most recent call last):")
+	for i, f := range frames {
+		fmt.Fprintf(out, "  #%d %s\n", i, f.Name)
+	}
+}
+
+func printLocals(out io.Writer, frame vm.FrameInfo, pretty object.PrettyOptions) {
+	if len(frame.Locals) == 0 {
+		fmt.Fprintf(out, "%s has no locals\n", frame.Name)
+		return
+	}
+	for _, v := range frame.Locals {
+		fmt.Fprintf(out, "  %s = %s\n", v.Name, object.Pretty(v.Value, pretty))
+	}
+}
+
+// loadFrame binds the frame's variables as globals of the same names, so
+// lines typed at the prompt see them as the failing code did.
+func loadFrame(out io.Writer, frame vm.FrameInfo, comp *compiler.Compiler, globals []object.Object, globalsMu *sync.RWMutex) {
+	globalsMu.Lock()
+	for _, v := range frame.Locals {
+		globals[comp.BindGlobal(v.Name)] = v.Value
+	}
+	globalsMu.Unlock()
+	fmt.Fprintf(out, "loaded frame %s (%d variables)\n", frame.Name, len(frame.Locals))
+}
+
+func firstLine(s string) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
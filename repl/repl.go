@@ -2,6 +2,7 @@ package repl
 
 import (
 	"bufio"
+	"xon/builtins"
 	"xon/compiler"
 	"xon/lexer"
 	"xon/object"
@@ -9,6 +10,7 @@ import (
 	"xon/vm"
 	"fmt"
 	"io"
+	"os"
 	"sync"
 )
 
@@ -20,6 +22,11 @@ func Start(in io.Reader, out io.Writer) {
 	globals := make([]object.Object, vm.GlobalsSize)
 	globalsMu := &sync.RWMutex{}
 	comp := compiler.New()
+	comp.AllowRedeclare = true
+	builtins.ScriptDocs = comp.Docs
+	builtins.GlobalNames = comp.GlobalNames
+
+	pretty := prettyFor(out)
 
 	for {
 		fmt.Fprintf(out, PROMPT)
@@ -33,36 +40,54 @@ func Start(in io.Reader, out io.Writer) {
 			return
 		}
 
-		l := lexer.New(line)
-		p := parser.New(l)
-		program := p.ParseProgram()
+		evalLine(out, line, comp, globals, globalsMu, pretty)
+	}
+}
 
-		if len(p.Errors) > 0 {
-			printParserErrors(out, p.Errors)
-			continue
+// prettyFor returns the result format for out: colored when it is a
+// terminal and NO_COLOR is unset.
+func prettyFor(out io.Writer) object.PrettyOptions {
+	pretty := object.DefaultPretty
+	if f, ok := out.(*os.File); ok && os.Getenv("NO_COLOR") == "" {
+		if info, err := f.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+			pretty.Color = true
 		}
+	}
+	return pretty
+}
 
-		comp.ResetInstructions()
-		err := comp.Compile(program)
-		if err != nil {
-			fmt.Fprintf(out, "Compiler error: %s\n", err)
-			continue
-		}
+// evalLine compiles and runs one line against the session's compiler and
+// globals, printing errors or the resulting value.
+func evalLine(out io.Writer, line string, comp *compiler.Compiler, globals []object.Object, globalsMu *sync.RWMutex, pretty object.PrettyOptions) {
+	l := lexer.New(line)
+	p := parser.New(l)
+	program := p.ParseProgram()
 
-		bytecode := comp.Bytecode()
+	if len(p.Errors) > 0 {
+		printParserErrors(out, p.Errors)
+		return
+	}
 
-		machine := vm.NewWithGlobalsState(bytecode, globals, globalsMu)
-		err = machine.Run()
-		if err != nil {
-			fmt.Fprintf(out, "VM error: %s\n", err)
-			continue
-		}
+	comp.ResetInstructions()
+	err := comp.Compile(program)
+	if err != nil {
+		fmt.Fprintf(out, "Compiler error: %s\n", err)
+		return
+	}
 
-		stackTop := machine.LastPoppedStackElem()
-		if stackTop != nil {
-			io.WriteString(out, stackTop.Inspect())
-			io.WriteString(out, "\n")
-		}
+	bytecode := comp.Bytecode()
+
+	machine := vm.NewWithGlobalsState(bytecode, globals, globalsMu)
+	err = machine.Run()
+	if err != nil {
+		fmt.Fprintf(out, "VM error: %s\n", err)
+		return
+	}
+
+	stackTop := machine.LastPoppedStackElem()
+	if stackTop != nil {
+		io.WriteString(out, object.Pretty(stackTop, pretty))
+		io.WriteString(out, "\n")
 	}
 }
 
@@ -0,0 +1,96 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestAudio(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the fake pactl, parecord and paplay stand in for PulseAudio")
+	}
+	log := fakeTools(t, map[string]string{
+		"pactl": `#!/bin/sh
+echo "pactl $@" >> "$FAKE_TOOL_LOG"
+case "$*" in
+"--format=json list sinks") echo '[{"index":1,"name":"alsa_output.analog-stereo","description":"Built-in Audio Analog Stereo"}]' ;;
+"--format=json list sources") echo '[{"index":1,"name":"alsa_output.analog-stereo.monitor","description":"Monitor of Built-in Audio"},{"index":2,"name":"alsa_input.usb-mic","description":"USB Microphone"}]' ;;
+"get-default-sink") echo alsa_output.analog-stereo ;;
+"get-default-source") echo alsa_input.analog-stereo ;;
+"get-sink-volume @DEFAULT_SINK@") printf 'Volume: front-left: 42598 /  65%% / -11.23 dB,   front-right: 42598 /  65%% / -11.23 dB\n        balance 0.00\n' ;;
+esac
+exit 0
+`,
+		"parecord": `#!/bin/sh
+echo "parecord $@" >> "$FAKE_TOOL_LOG"
+for a; do f=$a; done
+case "$*" in *--device=gone*) echo "Stream error: No such entity" >&2; exit 1 ;; esac
+echo RIFF > "$f"
+trap 'exit 1' INT
+while :; do /bin/sleep 0.02; done
+`,
+		"paplay": `#!/bin/sh
+echo "paplay $@" >> "$FAKE_TOOL_LOG"
+`,
+	})
+	dir := filepath.Dir(log)
+	wav := filepath.Join(dir, "memo.wav")
+
+	out, err := runSource(`
+set ds = audio_devices();
+out len(ds);
+out ds[0];
+out ds[1]["name"];
+out volume_get();
+out volume_set(30);
+out volume_mute();
+out volume_mute(false);
+out volume_set(120);
+out audio_record(0.2, "` + wav + `", {"device": "alsa_input.usb-mic"});
+out audio_record(1, "` + wav + `", {"device": "gone"});
+out audio_record(1, "memo.mp3");
+out audio_record(0, "` + wav + `");
+out audio_play("` + wav + `");
+out audio_play("` + filepath.Join(dir, "missing.wav") + `");
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `2
+{"default": true, "id": "alsa_output.analog-stereo", "kind": "output", "name": "Built-in Audio Analog Stereo"}
+USB Microphone
+65
+true
+true
+true
+ERROR: volume_set: percent must be between 0 and 100, got 120
+true
+ERROR: audio_record: Stream error: No such entity
+ERROR: audio_record: recordings are WAV files, so the path must end in .wav, got "memo.mp3"
+ERROR: audio_record: seconds must be between 0 and 86400, got 0
+true
+ERROR: audio_play: stat ` + filepath.Join(dir, "missing.wav") + `: no such file or directory
+`
+	if out != want {
+		t.Errorf("output = %q, want %q", out, want)
+	}
+	if data, err := os.ReadFile(wav); err != nil || string(data) != "RIFF\n" {
+		t.Errorf("recording not written: %q %v", data, err)
+	}
+
+	calls, _ := os.ReadFile(log)
+	for _, call := range []string{
+		"pactl set-sink-volume @DEFAULT_SINK@ 30%",
+		"pactl set-sink-mute @DEFAULT_SINK@ 1",
+		"pactl set-sink-mute @DEFAULT_SINK@ 0",
+		"parecord --file-format=wav --format=s16le --channels=1 --device=alsa_input.usb-mic " + wav,
+		"paplay " + wav,
+	} {
+		if !strings.Contains(string(calls), call+"\n") {
+			t.Errorf("missing call %q in:\n%s", call, calls)
+		}
+	}
+}
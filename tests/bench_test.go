@@ -0,0 +1,164 @@
+package tests
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"xon/compiler"
+	"xon/lexer"
+	"xon/parser"
+	"xon/vm"
+)
+
+func BenchmarkFeatureSuite(b *testing.B) {
+	content, err := os.ReadFile("features.xn")
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < b.N; i++ {
+		if _, err := runSource(string(content)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// benchVM compiles source, which must not use the stdlib, once and runs it
+// on a fresh VM b.N times, so only execution is measured.
+func benchVM(b *testing.B, source string) {
+	b.Helper()
+	p := parser.New(lexer.New(source))
+	program := p.ParseProgram()
+	if len(p.Errors) > 0 {
+		b.Fatal(p.Errors)
+	}
+	c := compiler.New()
+	if err := c.Compile(program); err != nil {
+		b.Fatal(err)
+	}
+	bytecode := c.Bytecode()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := vm.New(bytecode).Run(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkDispatch is dominated by instruction dispatch: calls, locals,
+// arithmetic and jumps, with no builtins or output.
+func BenchmarkDispatch(b *testing.B) {
+	benchVM(b, `
+set fib = fn(n) { if (n < 2) { return n; } return fib(n - 1) + fib(n - 2); };
+set total = 0;
+for (set i = 0; i < 20000; i++) { total = total + i - 1; }
+set r = fib(20);
+`)
+}
+
+// BenchmarkNumericLoop runs integer and float loops over function locals,
+// which should not allocate per iteration.
+func BenchmarkNumericLoop(b *testing.B) {
+	b.ReportAllocs()
+	benchVM(b, `
+set sum = fn(n) {
+	set total = 0;
+	set x = 0.0;
+	for (set i = 0; i < n; i++) { total = total + i; x = x + 0.5; }
+	return total;
+};
+set r = sum(20000);
+`)
+}
+
+func BenchmarkFib(b *testing.B) {
+	benchVM(b, `
+set fib = fn(n) { if (n < 2) { return n; } return fib(n - 1) + fib(n - 2); };
+set r = fib(22);
+`)
+}
+
+func BenchmarkStringBuilding(b *testing.B) {
+	b.ReportAllocs()
+	benchVM(b, `
+set build = fn(n) {
+	set s = "";
+	for (set i = 0; i < n; i++) { s = s + str(i) + ","; }
+	return len(s);
+};
+set r = build(2000);
+`)
+}
+
+// BenchmarkHashChurn builds a short-lived hash per iteration and reads it
+// back, so it measures hash allocation, hashing of string keys and lookup.
+func BenchmarkHashChurn(b *testing.B) {
+	b.ReportAllocs()
+	benchVM(b, `
+set churn = fn(n) {
+	set total = 0;
+	for (set i = 0; i < n; i++) {
+		set k = "key" + str(i % 50);
+		set h = {k: i, "n": i, "even": i % 2 == 0};
+		total = total + h[k] + h["n"];
+		if (has_key(h, "missing")) { total = 0; }
+	}
+	return total;
+};
+set r = churn(5000);
+`)
+}
+
+// BenchmarkArraySort quicksorts 2000 pseudo-random integers, written in
+// Xon as scripts have no sort builtin.
+func BenchmarkArraySort(b *testing.B) {
+	benchVM(b, `
+set qsort = fn(xs) {
+	if (len(xs) < 2) { return xs; }
+	set pivot = xs[0];
+	set less = [];
+	set more = [];
+	for (set i = 1; i < len(xs); i++) {
+		if (xs[i] < pivot) { push_mut(less, xs[i]); } else { push_mut(more, xs[i]); }
+	}
+	set sorted = qsort(less);
+	push_mut(sorted, pivot);
+	for v in qsort(more) { push_mut(sorted, v); }
+	return sorted;
+};
+set xs = [];
+set x = 42;
+for (set i = 0; i < 2000; i++) {
+	x = (x * 1103515245 + 12345) % 2147483648;
+	push_mut(xs, x % 10000);
+}
+set sorted = qsort(xs);
+`)
+}
+
+// BenchmarkHTTPHandler measures requests per second through a script
+// handler served by test_http_server, including the Go HTTP round trip.
+func BenchmarkHTTPHandler(b *testing.B) {
+	out, err := runSource(`
+out test_http_server({"GET /hello": fn(req) {
+	return {"status": 200, "body": "hello " + req["query"], "headers": {"Content-Type": "text/plain"}};
+}});
+`)
+	if err != nil {
+		b.Fatal(err)
+	}
+	url := strings.TrimSpace(out) + "/hello?name=bench"
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		resp, err := http.Get(url)
+		if err != nil {
+			b.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || string(body) != "hello name=bench" {
+			b.Fatalf("got %d %q", resp.StatusCode, body)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package tests
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeDevTools stands in for Chrome's DevTools endpoint: it lists one page
+// and answers its commands over a WebSocket, recording their methods.
+type fakeDevTools struct {
+	mu      sync.Mutex
+	methods []string
+}
+
+func (f *fakeDevTools) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/json/list" {
+		fmt.Fprintf(w, `[{"type": "page", "webSocketDebuggerUrl": "ws://%s/devtools/page/1"}]`, r.Host)
+		return
+	}
+	sum := sha1.Sum([]byte(r.Header.Get("Sec-WebSocket-Key") + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+	conn, rw, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", base64.StdEncoding.EncodeToString(sum[:]))
+	rw.Flush()
+
+	for {
+		data, err := readClientFrame(rw.Reader)
+		if err != nil {
+			return
+		}
+		var msg struct {
+			ID     int64          `json:"id"`
+			Method string         `json:"method"`
+			Params map[string]any `json:"params"`
+		}
+		json.Unmarshal(data, &msg)
+		f.mu.Lock()
+		f.methods = append(f.methods, msg.Method)
+		f.mu.Unlock()
+
+		result := `{}`
+		switch msg.Method {
+		case "Page.navigate":
+			writeServerFrame(conn, `{"method": "Page.loadEventFired", "params": {}}`)
+		case "Runtime.evaluate":
+			expr, _ := msg.Params["expression"].(string)
+			value := `"Hello from JS"`
+			if strings.Contains(expr, "getBoundingClientRect") {
+				value = `{"x": 10, "y": 20}`
+			}
+			result = fmt.Sprintf(`{"result": {"type": "object", "value": {"found": true, "value": %s}}}`, value)
+		case "Page.captureScreenshot":
+			result = fmt.Sprintf(`{"data": %q}`, base64.StdEncoding.EncodeToString([]byte("PNG")))
+		}
+		writeServerFrame(conn, fmt.Sprintf(`{"id": %d, "result": %s}`, msg.ID, result))
+	}
+}
+
+func readClientFrame(r *bufio.Reader) ([]byte, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return nil, err
+	}
+	if head[0]&0x0F == 0x8 {
+		return nil, io.EOF
+	}
+	n := uint64(head[1] & 0x7F)
+	switch n {
+	case 126:
+		var ext [2]byte
+		io.ReadFull(r, ext[:])
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		io.ReadFull(r, ext[:])
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	var mask [4]byte
+	io.ReadFull(r, mask[:])
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return payload, nil
+}
+
+func writeServerFrame(w io.Writer, text string) {
+	frame := []byte{0x81}
+	if len(text) < 126 {
+		frame = append(frame, byte(len(text)))
+	} else {
+		frame = append(frame, 126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(text)))
+	}
+	w.Write(append(frame, text...))
+}
+
+func TestBrowserBuiltins(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake browser is a shell script")
+	}
+	devtools := &fakeDevTools{}
+	srv := httptest.NewServer(devtools)
+	defer srv.Close()
+
+	// The fake browser only announces the endpoint, as Chrome does, and
+	// stays up until it is killed.
+	dir := t.TempDir()
+	chrome := filepath.Join(dir, "chrome")
+	script := fmt.Sprintf("#!/bin/sh\necho 'DevTools listening on ws://%s/devtools/browser/1' >&2\nexec sleep 30\n", srv.Listener.Addr())
+	if err := os.WriteFile(chrome, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	shot := filepath.Join(dir, "page.png")
+
+	out, err := runSource(fmt.Sprintf(`
+set b = browser_open({"path": "%s", "timeout": 2000});
+out b;
+browser_goto(b, "https://example.com");
+browser_type(b, "#q", "xon");
+browser_click(b, "button");
+out browser_text(b, "h1");
+browser_screenshot(b, "%s");
+browser_close(b);
+out b;
+out browser_text(b, "h1");
+`, chrome, shot))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "browser(open)\nHello from JS\nbrowser(closed)\nERROR: browser_text: the browser is closed\n"
+	if out != want {
+		t.Errorf("output = %q, want %q", out, want)
+	}
+	if png, _ := os.ReadFile(shot); string(png) != "PNG" {
+		t.Errorf("screenshot = %q, want the decoded data", png)
+	}
+
+	devtools.mu.Lock()
+	methods := strings.Join(devtools.methods, " ")
+	devtools.mu.Unlock()
+	for _, want := range []string{"Page.enable", "Page.navigate", "Input.insertText", "Input.dispatchMouseEvent", "Page.captureScreenshot", "Browser.close"} {
+		if !strings.Contains(methods, want) {
+			t.Errorf("browser was not sent %s; got %s", want, methods)
+		}
+	}
+}
+
+func TestBrowserOpenWithoutChrome(t *testing.T) {
+	out, err := runSource(`out browser_open({"path": "/nonexistent/chrome"});`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out, "ERROR: browser_open: ") {
+		t.Errorf("output = %q, want a browser_open error", out)
+	}
+}
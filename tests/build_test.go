@@ -0,0 +1,106 @@
+package tests
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"xon/builtins"
+)
+
+func TestBundleScript(t *testing.T) {
+	dir := t.TempDir()
+	runner := filepath.Join(dir, "xon")
+	if err := os.WriteFile(runner, []byte("\x7fELF runner image"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := builtins.EmbeddedScript(runner); ok {
+		t.Fatal("plain runner reported a script")
+	}
+
+	first := filepath.Join(dir, "first")
+	if err := builtins.BundleScript(runner, first, []byte(`out "first";`)); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := builtins.EmbeddedScript(first); !ok || got != `out "first";` {
+		t.Errorf("EmbeddedScript(first) = %q, %v", got, ok)
+	}
+	if info, err := os.Stat(first); err != nil || info.Mode().Perm()&0100 == 0 {
+		t.Errorf("bundle is not executable: %v %v", info, err)
+	}
+
+	// A bundle used as the runner has its script replaced, not stacked.
+	second := filepath.Join(dir, "second")
+	if err := builtins.BundleScript(first, second, []byte(`out "second";`)); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := builtins.EmbeddedScript(second); !ok || got != `out "second";` {
+		t.Errorf("EmbeddedScript(second) = %q, %v", got, ok)
+	}
+	data, _ := os.ReadFile(second)
+	if want := "\x7fELF runner image" + `out "second";`; string(data[:len(want)]) != want {
+		t.Errorf("second starts with %q, want %q", data[:len(want)], want)
+	}
+
+	if err := builtins.BundleScript(first, first, []byte(`out 1;`)); err == nil {
+		t.Error("bundling onto the runner itself succeeded")
+	}
+	if got, ok := builtins.EmbeddedScript(first); !ok || got != `out "first";` {
+		t.Errorf("runner damaged: %q, %v", got, ok)
+	}
+}
+
+func TestBuildCrossTarget(t *testing.T) {
+	xon := buildXon(t)
+	dir := t.TempDir()
+	script := filepath.Join(dir, "quote.xn")
+	source := `out "it's built";`
+	if err := os.WriteFile(script, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// 386 binaries run on amd64 hosts, so that pairing also runs the result.
+	target := runtime.GOOS + "/amd64"
+	if runtime.GOARCH == "amd64" {
+		target = runtime.GOOS + "/386"
+	}
+	exe := filepath.Join(dir, "quote")
+	cmd := exec.Command(xon, "build", "--target", target, "--exe", exe, script)
+	cmd.Dir = ".."
+	if out, err := cmd.CombinedOutput(); err != nil || string(out) != "Successfully built "+exe+"\n" {
+		t.Fatalf("xon build --target %s: %v\n%s", target, err, out)
+	}
+	if got, ok := builtins.EmbeddedScript(exe); !ok || got != source {
+		t.Errorf("EmbeddedScript = %q, %v; want %q", got, ok, source)
+	}
+	if runtime.GOARCH == "amd64" && runtime.GOOS != "darwin" {
+		if out, err := exec.Command(exe).CombinedOutput(); err != nil || string(out) != "it's built\n" {
+			t.Errorf("running the %s build: %v\n%s", target, err, out)
+		}
+	}
+}
+
+func TestBuildFailureExitCode(t *testing.T) {
+	xon := buildXon(t)
+	dir := t.TempDir()
+	for _, args := range [][]string{
+		{"build"},
+		{"build", "--exe"},
+		{"build", "--bogus", "x", "a.xn"},
+		{"build", "--exe", filepath.Join(dir, "out"), filepath.Join(dir, "missing.xn")},
+	} {
+		cmd := exec.Command(xon, args...)
+		cmd.Dir = ".."
+		var stdout strings.Builder
+		cmd.Stdout = &stdout
+		err := cmd.Run()
+		if exit, ok := err.(*exec.ExitError); !ok || exit.ExitCode() != 1 {
+			t.Errorf("xon %v: err = %v, want exit status 1", args, err)
+		}
+		if stdout.Len() != 0 {
+			t.Errorf("xon %v wrote %q to stdout, want errors on stderr", args, stdout.String())
+		}
+	}
+}
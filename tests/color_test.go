@@ -0,0 +1,57 @@
+package tests
+
+import "testing"
+
+func TestColors(t *testing.T) {
+	out, err := runSource(`
+out color_parse("#ff8800");
+out color_parse("#F80");
+out color_parse("rgba(10, 20, 30, 0.5)");
+out color_parse("navy");
+out color_hex([255, 136, 0]);
+out color_hex({"r": 1, "g": 2, "b": 3, "a": 0.5});
+out rgb_to_hsl("#ff8800");
+out hsl_to_rgb(32, 1, 0.5);
+out color_hex(hsl_to_rgb(rgb_to_hsl("#3366cc")));
+out color_hex(hsl_to_rgb(-120, 1, 0.5));
+out color_contrast("black", "white");
+out color_contrast("#777", "#fff");
+out color_nearest([250, 10, 20], ["#000", "#f00", "#0f0"]);
+out color_nearest("#ee1111", {"danger": "#d33", "ok": "#3a3", "ink": "#222"});
+out color_parse("nope");
+out color_parse("#12345");
+out color_parse([1, 2]);
+out color_parse([1, 2, 300]);
+out color_parse({"r": 1, "g": 2});
+out hsl_to_rgb(0, 2, 0.5);
+out color_nearest("#fff", []);
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"a": 1, "b": 0, "g": 136, "r": 255}
+{"a": 1, "b": 0, "g": 136, "r": 255}
+{"a": 0.5, "b": 30, "g": 20, "r": 10}
+{"a": 1, "b": 128, "g": 0, "r": 0}
+#ff8800
+#01020380
+{"a": 1, "h": 32, "l": 0.5, "s": 1}
+{"a": 1, "b": 0, "g": 136, "r": 255}
+#3366cc
+#0000ff
+21
+4.48
+#f00
+danger
+ERROR: color_parse: unknown color "nope"
+ERROR: color_parse: "#12345" is not #rgb, #rrggbb or #rrggbbaa
+ERROR: color_parse: color array needs 3 or 4 numbers, got 2
+ERROR: color_parse: channel 300 is outside 0-255
+ERROR: color_parse: color hash needs r, g and b, missing b
+ERROR: hsl_to_rgb: s and l must be in 0-1, got 2
+ERROR: color_nearest: the palette is empty
+`
+	if out != want {
+		t.Errorf("output =\n%s\nwant\n%s", out, want)
+	}
+}
@@ -0,0 +1,116 @@
+package tests
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"xon/code"
+	"xon/compiler"
+	"xon/lexer"
+	"xon/object"
+	"xon/parser"
+)
+
+func compileSource(t *testing.T, src string) (*compiler.Bytecode, error) {
+	t.Helper()
+	p := parser.New(lexer.New(src))
+	program := p.ParseProgram()
+	if len(p.Errors) != 0 {
+		t.Fatalf("%q: syntax errors %q", src, p.Errors)
+	}
+	c := compiler.New()
+	err := c.Compile(program)
+	return c.Bytecode(), err
+}
+
+func TestCompilerOutput(t *testing.T) {
+	for src, want := range map[string]string{
+		"out 1 + 2;":                "OpConstant 0|OpConstant 1|OpAdd|OpOut",
+		"set x = 1; out x;":         "OpConstant 0|OpSetGlobal 0|OpGetGlobal 0|OpOut",
+		"out 1 < 2;":                "OpConstant 0|OpConstant 1|OpGreaterThan|OpOut",
+		"out 2 ** 3;":               "OpConstant 0|OpConstant 1|OpPow|OpOut",
+		"out 1..3;":                 "OpConstant 0|OpConstant 1|OpRange|OpOut",
+		"out [1][:2];":              "OpConstant 0|OpArray 1|OpNull|OpConstant 1|OpSlice|OpOut",
+		"out 1 <= 2; out 1 >= 2;":   "OpConstant 0|OpConstant 1|OpGreaterEqual|OpOut|OpConstant 2|OpConstant 3|OpGreaterEqual|OpOut",
+		"out -1; out !true;":        "OpConstant 0|OpMinus|OpOut|OpTrue|OpBang|OpOut",
+		"out [1, 2];":               "OpConstant 0|OpConstant 1|OpArray 2|OpOut",
+		"set f = fn(a) { };":        "OpClosure 0 0|OpSetGlobal 0",
+		"set x = null; out x?.a.b;": "OpNull|OpSetGlobal 0|OpGetGlobal 0|OpJumpNull 16|OpMember 0|OpMember 1|OpOut",
+		"out 1 ?? 2;":               "OpConstant 0|OpJumpNotNull 9|OpConstant 1|OpOut",
+		"out 1 && 2;":               "OpConstant 0|OpDup|OpJumpNotTruthy 11|OpPop|OpConstant 1|OpOut",
+		"set h = {}; h.a = 1;":      "OpHash 0|OpSetGlobal 0|OpGetGlobal 0|OpConstant 0|OpSetMember 1",
+		"set a = [0]; a[0] = 1;":    "OpConstant 0|OpArray 1|OpSetGlobal 0|OpGetGlobal 0|OpConstant 1|OpConstant 2|OpSetIndex",
+		"set [a, b] = [1, 2];":      "OpConstant 0|OpConstant 1|OpArray 2|OpDup|OpConstant 2|OpIndex|OpSetGlobal 0|OpDup|OpConstant 3|OpIndex|OpSetGlobal 1|OpPop",
+		"out match 1 { 2 => 3 };":   "OpConstant 0|OpSetGlobal 0|OpGetGlobal 0|OpConstant 1|OpEqual|OpJumpNotTruthy 22|OpConstant 2|OpJump 23|OpNull|OpOut",
+	} {
+		bytecode, err := compileSource(t, src)
+		if err != nil {
+			t.Errorf("%q: %v", src, err)
+			continue
+		}
+		if got := listing(bytecode.Instructions.String()); got != want {
+			t.Errorf("%q compiled to\n %s\nwant\n %s", src, got, want)
+		}
+	}
+}
+
+func TestCompilerNumberConstants(t *testing.T) {
+	bytecode, err := compileSource(t, "out 1_000_000; out 1.5e9; out 0xFF_FF; out 2E-3;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, c := range bytecode.Constants {
+		got = append(got, fmt.Sprintf("%s %s", c.Type(), c.Inspect()))
+	}
+	want := "INTEGER 1000000, FLOAT 1.5e+09, INTEGER 65535, FLOAT 0.002"
+	if strings.Join(got, ", ") != want {
+		t.Errorf("constants = %s, want %s", strings.Join(got, ", "), want)
+	}
+}
+
+func TestCompilerLocals(t *testing.T) {
+	bytecode, err := compileSource(t, "set f = fn(a) { set b = a; return b; };")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fn, ok := bytecode.Constants[0].(*object.CompiledFunction)
+	if !ok {
+		t.Fatalf("constant 0 is %T, want a compiled function", bytecode.Constants[0])
+	}
+	if fn.NumParameters != 1 || fn.NumLocals != 2 {
+		t.Errorf("parameters %d, locals %d, want 1 and 2", fn.NumParameters, fn.NumLocals)
+	}
+	want := "OpGetLocal 0|OpSetLocal 1|OpGetLocal 1|OpReturnValue"
+	if got := listing(code.Instructions(fn.Instructions).String()); got != want {
+		t.Errorf("body compiled to\n %s\nwant\n %s", got, want)
+	}
+}
+
+func TestCompilerErrors(t *testing.T) {
+	for src, want := range map[string]string{
+		"out nope;":                               "undefined variable nope",
+		"set const k = 1; k = 2;":                 "cannot assign to constant k",
+		"set a = 1; set a = 2;":                   "a is already defined in this scope",
+		"set f = fn() { set b = 1; set b = 2; };": "b is already defined in this scope",
+		"break;":                               "break outside of loop",
+		"class A { fn init() { return 1; } }":  "return inside init",
+		"class A { fn m(self) { return 1; } }": "self is passed to A.m implicitly",
+	} {
+		_, err := compileSource(t, src)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: error = %v, want it to contain %q", src, err, want)
+		}
+	}
+}
+
+// listing turns an instruction dump into "Op a|Op b", without offsets.
+func listing(dump string) string {
+	var ops []string
+	for _, line := range strings.Split(strings.TrimSpace(dump), "\n") {
+		if _, op, ok := strings.Cut(line, " "); ok {
+			ops = append(ops, op)
+		}
+	}
+	return strings.Join(ops, "|")
+}
@@ -0,0 +1,109 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConfigLoad(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"config.toml": `# app settings
+port = 9000
+name = "demo"  # the default name
+ratio = 2
+[db]
+host = "db.local"
+tags = ["a", 'b', 3, 1.5, true, { weight = 0x10 }]
+`,
+		"config.json": `{"port": "9100", "extra": [1, 2]}`,
+		"bad.toml":    "port = 1\nport = 2\n",
+		"bad.json":    `{"port": "many"}`,
+		".env": `# local overrides
+export APP_NAME="from \"dotenv\""
+APP_PORT=7000 # not this one
+APP_HOSTS='x, y'
+`,
+	}
+	for name, src := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("APP_PORT", "6000")
+	t.Setenv("APP_DEBUG", "yes")
+	t.Setenv("PORT", "not a number")
+
+	out, err := runSource(`
+set dir = "` + filepath.ToSlash(dir) + `";
+set defaults = {"port": 8080, "debug": false, "verbose": true, "ratio": 1.0, "hosts": ["localhost"], "name": "x", "workers": 2};
+set opts = {"file": dir + "/config.toml", "dotenv": dir + "/.env", "env_prefix": "APP_", "args": ["--workers=4", "input.txt", "--ratio", "0.5", "--no-verbose", "--unknown", "--", "--port=1"]};
+set cfg = config_load(defaults, opts);
+out [cfg.port, cfg.debug, cfg.verbose, cfg.ratio, cfg.hosts, cfg.name, cfg.workers];
+out cfg.db;
+out config_load({"port": 1}, {"file": dir + "/config.json", "dotenv": null, "env_prefix": "NONE_", "args": []});
+out config_load({"port": 1}, {"file": dir + "/missing.toml", "dotenv": dir + "/missing.env", "env_prefix": "NONE_", "args": []});
+out config_load({"port": 1}, {"dotenv": null, "args": []});
+out config_load({"port": 1}, {"file": dir + "/bad.toml", "args": []});
+out config_load({"port": 1}, {"file": dir + "/bad.json", "env_prefix": "NONE_", "args": []});
+out config_load({"port": 1}, {"env_prefix": "NONE_", "args": ["--port"]});
+out config_load({"debug": false}, {"env_prefix": "NONE_", "args": ["--debug=maybe"]});
+out config_load({"port": 1}, {"file": dir + "/config.yaml"});
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `[6000, true, false, 0.5, ["x", "y"], "from \"dotenv\"", 4]
+{"host": "db.local", "tags": ["a", "b", 3, 1.5, true, {"weight": 16}]}
+{"extra": [1, 2], "port": 9100}
+{"port": 1}
+ERROR: config_load: environment variable PORT: expected an integer, got "not a number"
+ERROR: config_load: ` + filepath.Join(dir, "bad.toml") + `: line 2: port is set twice
+ERROR: config_load: ` + filepath.Join(dir, "bad.json") + `: port: expected an integer, got "many"
+ERROR: config_load: flag --port needs a value
+ERROR: config_load: flag --debug: expected true or false, got "maybe"
+ERROR: config_load: ` + filepath.Join(dir, "config.yaml") + `: config files must end in .json or .toml
+`
+	if out != want {
+		t.Errorf("output =\n%s\nwant\n%s", out, want)
+	}
+}
+
+func TestConfigLoadNestedKeys(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"config.json": `{"db": {"host": "db.local", "pool": {"size": "8"}, "extra": true}}`,
+		".env":        "APP_DB_USER=dotenv\nAPP_DB_POOL_SIZE=16\n",
+	}
+	for name, src := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("APP_DB_PORT", "6543")
+	t.Setenv("APP_DB_POOL_SIZE", "32")
+
+	out, err := runSource(`
+set dir = "` + filepath.ToSlash(dir) + `";
+set defaults = {"db": {"host": "localhost", "port": 5432, "user": "app", "pool": {"size": 4, "tls": true}}, "db_name": "main"};
+set opts = fn(args) { return {"file": dir + "/config.json", "dotenv": dir + "/.env", "env_prefix": "APP_", "args": args}; };
+out config_load(defaults, opts(["--db.host=flag.local", "--no-db-pool-tls", "--db_name", "other"]));
+out defaults.db;
+out config_load(defaults, opts(["--db-port", "x"]));
+out config_load({"db": {"port": 1}}, {"file": dir + "/config.json", "dotenv": null, "env_prefix": "NONE_", "args": ["--db.port=2", "--db", """{"port": 3}"""]});
+out config_load({"db": {"port": 1}, "db_port": 2}, {"dotenv": null, "env_prefix": "NONE_", "args": ["--db-port=9"]});
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"db": {"extra": true, "host": "flag.local", "pool": {"size": 32, "tls": false}, "port": 6543, "user": "dotenv"}, "db_name": "other"}
+{"host": "localhost", "pool": {"size": 4, "tls": true}, "port": 5432, "user": "app"}
+ERROR: config_load: flag --db-port: expected an integer, got "x"
+{"db": {"port": 3}}
+{"db": {"port": 1}, "db_port": 9}
+`
+	if out != want {
+		t.Errorf("output =\n%s\nwant\n%s", out, want)
+	}
+}
@@ -0,0 +1,158 @@
+package tests
+
+import (
+	"strings"
+	"testing"
+)
+
+// conformance is a corpus of small scripts with the exact output they
+// print, or the error they stop with. Unlike features.xn each case runs on
+// its own, so one failing case cannot hide the others, and failures can be
+// checked too. |> is not compiled yet and is left out.
+var conformance = []struct {
+	name string
+	src  string
+	out  string // expected stdout
+	err  string // expected error text, if the script fails
+}{
+	// Arithmetic and operators
+	{"precedence", `out 2 + 3 * 4 - 10 / 2; out (2 + 3) * 4; out 10 - 2 - 3; out 2 * 3 % 4;`, "9\n20\n5\n2\n", ""},
+	{"integer division truncates", `out 7 / 2; out -7 / 2; out 7 % 3;`, "3\n-3\n1\n", ""},
+	{"exponent", `out 2 ** 10; out 2 ** 3 ** 2; out -2 ** 2; out (-2) ** 3; out 3 * 2 ** 2; out 0 ** 0;`, "1024\n512\n-4\n-8\n12\n1\n", ""},
+	{"exponent with floats", `out 2 ** -1; out 4 ** 0.5; out 2.5 ** 2; out type(2 ** 2); out type(2 ** -2);`, "0.5\n2\n6.25\nINTEGER\nFLOAT\n", ""},
+	{"mixed int and float", `out 1 + 0.5; out 2 * 1.5; out 7.5 % 2; out 1.0 / 0;`, "1.5\n3\n1.5\n+Inf\n", ""},
+	{"comparison", `out 1 == 1; out 1 != 2; out 3 > 2; out 2 < 1; out 1 + 2 == 3;`, "true\ntrue\ntrue\nfalse\ntrue\n", ""},
+	{"less or equal, greater or equal", `out 2 <= 2; out 3 <= 2; out 2 >= 2; out 1 >= 2; out 1.5 <= 2; out 2 >= 2.0; out 0.0 / 0 >= 0;`, "true\nfalse\ntrue\nfalse\ntrue\ntrue\nfalse\n", ""},
+	{"inclusive loop bounds", `set n = 0; for (set i = 1; i <= 4; i++) { n = n + i; } set j = 5; while (j >= 3) { j--; } out n; out j;`, "10\n2\n", ""},
+	{"bitwise", `out 5 & 3; out 5 | 3; out 5 ^ 3; out ~5; out 1 << 4; out 256 >> 2;`, "1\n7\n6\n-6\n16\n64\n", ""},
+	{"short circuit", `out false && 1; out 0 || 5; out [false || 5, true && 2, null && 1];`, "false\n0\n[5, 2, null]\n", ""},
+	{"null coalescing", `set h = {"a": 1}; out h["b"] ?? "none"; out h["a"] ?? 2; out false ?? 3; out null ?? null ?? 4; out null ?? false || 7;`, "none\n1\nfalse\n4\n7\n", ""},
+	{"null coalescing skips the right side", `set n = 0; set f = fn() { n = n + 1; return n; }; out 1 ?? f(); out null ?? f(); out n;`, "1\n1\n1\n", ""},
+	{"unary", `out -(-3); out !true; out !null; out !0;`, "3\nfalse\ntrue\nfalse\n", ""},
+	{"division by zero", `out 1 / 0;`, "", "division by zero"},
+	{"modulo by zero", `out 1 % 0;`, "", "modulo by zero"},
+
+	// Strings
+	{"concatenation", `out "ab" + "cd"; out "n" + 1; out 1 + "n";`, "abcd\nn1\n1n\n", ""},
+	{"interpolation", `set who = "xon"; out "hi ${who}, ${1 + 2}!";`, "hi xon, 3!\n", ""},
+	{"string equality", `out "a" == "a"; out "a" != "b";`, "true\ntrue\n", ""},
+	{"string ordering", `out "a" < "b"; out "b" > "a"; out "ab" < "b"; out "a" < "ab"; out "Z" < "a"; out "x" <= "x"; out "x" >= "y";`, "true\ntrue\ntrue\ntrue\ntrue\ntrue\nfalse\n", ""},
+	{"string ordering in a loop", `set w = ["pear", "apple", "fig"]; set lo = w[0]; for x in w { if (x < lo) { lo = x; } } out lo;`, "apple\n", ""},
+	{"string and number do not order", `out "1" < 2;`, "", "unsupported types for binary operation: INTEGER STRING"},
+	{"length counts bytes", `out len("héllo");`, "6\n", ""},
+
+	// Truthiness and null
+	{"truthiness", `set g = fn(x) { if (x) { return "yes"; } return "no"; }; out g(null); out g(false); out g(0); out g("");`, "no\nno\nyes\nyes\n", ""},
+	{"types", `out type(null); out type(1); out type(1.5); out type("s"); out type([]); out type({});`, "NULL\nINTEGER\nFLOAT\nSTRING\nARRAY\nHASH\n", ""},
+
+	// Variables
+	{"reassign", `set a = 1; a = a + 1; out a;`, "2\n", ""},
+	{"redefine in scope", `set a = 1; set a = 2;`, "", "a is already defined in this scope"},
+	{"shadow stdlib globals", `set time = 5; set map = [1]; set log = "x"; out time + len(map); out log; out filter([1, 2, 3], fn(n) { return n > 1; });`, "6\nx\n[2, 3]\n", ""},
+	{"redefine shadowed stdlib global", `set time = 5; set time = 6;`, "", "time is already defined in this scope"},
+	{"assign to const", `set const k = 1; k = 2;`, "", "cannot assign to constant k"},
+	{"destructure array", `set [a, b] = [1, 2, 3]; out a + b; set [x, y] = [9]; out x; out y;`, "3\n9\nnull\n", ""},
+	{"destructure hash", `set {name, age: years} = {"name": "Ada", "age": 36}; out name; out years;`, "Ada\n36\n", ""},
+	{"destructure evaluates once", `set n = 0; set f = fn() { n = n + 1; return [n, n]; }; set [p, q] = f(); out n;`, "1\n", ""},
+	{"destructure in a function", `set area = fn(r) { set {w, h} = r; return w * h; }; out area({"w": 3, "h": 4});`, "12\n", ""},
+	{"destructure redefines", `set a = 1; set [a] = [2];`, "", "a is already defined in this scope"},
+	{"destructure const", `set const [k] = [1]; k = 2;`, "", "cannot assign to constant k"},
+	{"undefined variable", `out nope;`, "", "undefined variable nope"},
+
+	// Collections
+	{"array index", `set a = [1, 2, 3]; out a[0]; out a[2]; out a[5]; out len(a);`, "1\n3\nnull\n3\n", ""},
+	{"nested index", `out [1, [2, [3]]][1][1][0];`, "3\n", ""},
+	{"array push", `set xs = []; xs.push(1); xs.push(2); out xs;`, "[1, 2]\n", ""},
+	{"hash keys", `set h = {"a": 1, 2: "two", true: "yes"}; out h["a"]; out h[2]; out h[true]; out h["zz"];`, "1\ntwo\nyes\nnull\n", ""},
+	{"member access", `set h = {"x": {"y": 2}}; out h.x.y; out {"a": 1}.a;`, "2\n1\n", ""},
+	{"optional chaining", `set u = {"a": {"b": 1}, "f": fn() { return 2; }}; set n = null; out u?.a?.b; out n?.a.b; out n?.f(); out u?.f(); out n?.xs[0]; out n?.a ?? "none";`, "1\nnull\nnull\n2\nnull\nnone\n", ""},
+	{"optional chain ends at its last link", `set n = null; out [n?.a, 1]; out len(n?.a ?? "");`, "[null, 1]\n0\n", ""},
+	{"member of null", `set n = null; out n.a;`, "", "member access not supported on NULL"},
+	{"index assignment", `set a = [1, 2, 3]; a[0] = 5; a[1 + 1] = a[0] * 2; out a; set h = {"k": 1}; h["k"] = 2; h["n"] = [1]; h["n"][0] = 9; out h;`, "[5, 2, 10]\n{\"k\": 2, \"n\": [9]}\n", ""},
+	{"member assignment", `set h = {}; h.x = {"y": 1}; h.x.y = 7; out h; set f = fn() { set o = {}; o.n = 1; o.n = o.n + 1; return o.n; }; out f();`, "{\"x\": {\"y\": 7}}\n2\n", ""},
+	{"assignment is shared", `set a = [0]; set b = a; b[0] = 1; out a;`, "[1]\n", ""},
+	{"index assignment out of range", `set a = [1]; a[1] = 2;`, "", "index 1 out of range for array of length 1"},
+	{"index assignment to frozen", `set const deep c = {"a": [1]}; c["a"][0] = 2;`, "", "cannot assign to a frozen array"},
+	{"pop from frozen", `set a = freeze([1, 2]); a.pop(); out "unreachable";`, "", "cannot pop from a frozen array"},
+	{"pop_mut from frozen", `set a = freeze([1, 2]); pop_mut(a); out "unreachable";`, "", "cannot pop from a frozen array"},
+	{"frozen writes are catchable", `set a = freeze([1]); set h = freeze({"k": 1}); out try { a[0] = 2; } catch (e) { e.message }; out try { h["k"] = 2; } catch (e) { e.message }; out try { h.k = 2; } catch (e) { e.message }; out try { a.push(2); } catch (e) { e.message }; out [a, h];`, "cannot assign to a frozen array\ncannot assign to a frozen hash\ncannot assign to a frozen hash\ncannot push to a frozen array\n[[1], {\"k\": 1}]\n", ""},
+	{"push_mut to frozen", `set a = freeze([]); out try { push_mut(a, 1); } catch (e) { e.message }; out len(a);`, "cannot push to a frozen array\n0\n", ""},
+	{"member assignment to non-hash", `set s = "x"; s.n = 1;`, "", "member assignment not supported on STRING"},
+	{"string index", `set s = "abc"; out s[1];`, "", "index operator not supported: STRING"},
+	{"numarray operator error", `set v = numarray([1, 2]); out v % 2;`, "", "unsupported operator for NUMARRAY: %"},
+
+	// Functions
+	{"call", `set add = fn(a, b) { return a + b; }; out add(2, 3);`, "5\n", ""},
+	{"recursion", `set f = fn(n) { if (n < 2) { return n; } return f(n - 1) + f(n - 2); }; out f(15);`, "610\n", ""},
+	{"memoized recursion", `set calls = 0; set fib = fn(n) { calls++; if (n < 2) { return n; } return fib(n - 1) + fib(n - 2); }; fib = memoize(fib); out fib(20); out calls; set f = memoize(fn(n) { if (n < 2) { return n; } return f(n - 1) + f(n - 2); }); out f(60);`, "6765\n21\n1548008755920\n", ""},
+	{"local recursion", `set g = fn() { set down = fn(n) { if (n == 0) { return "done"; } return down(n - 1); }; return down(3); }; out g();`, "done\n", ""},
+	{"assign to a function's own name", `set g = fn() { set h = fn() { h = 5; return h; }; return h(); }; out g(); set top = fn() { top = 7; return 1; }; out top(); out top; set once = fn() { set first = once; once = fn() { return "again"; }; return [type(first), once()]; }; out once(); out once();`, "5\n1\n7\n[\"CLOSURE\", \"again\"]\nagain\n", ""},
+	{"closure counter", `set mk = fn() { set n = 0; return fn() { n = n + 1; return n; }; }; set c = mk(); c(); out c();`, "2\n", ""},
+	{"wrong argument count", `set add = fn(a, b) { return a + b; }; out add(1);`, "", "wrong number of arguments: want=2, got=1"},
+	{"call a non-function", `set x = 5; x();`, "", "calling non-function: INTEGER"},
+	{"hash methods bind self", `set c = {"n": 1, "inc": fn(by) { self.n = self.n + by; return self; }}; c.inc(2).inc(3); out c.n; set f = c["inc"]; f(4); out c.n; out {"self": 2}.self;`, "6\n10\n2\n", ""},
+	{"method without a receiver", `set f = fn() { return self.n; }; f();`, "", "calling a function that uses self without a receiver"},
+
+	// Control flow
+	{"for with break and continue", `for (set i = 0; i < 5; i++) { if (i == 1) { continue; } if (i == 3) { break; } out i; }`, "0\n2\n", ""},
+	{"while", `set i = 0; while (i < 3) { i++; } out i;`, "3\n", ""},
+	{"do while runs once", `set n = 10; do { n++; } while n < 5; out n; set i = 0; do { i = i + 2; } while (i < 7) out i;`, "11\n8\n", ""},
+	{"do while break and continue", `set j = 0; do { j++; if (j == 2) { continue; } if (j == 4) { break; } out j; } while j < 10; out j;`, "1\n3\n4\n", ""},
+	{"do while body scope", `set x = 0; do { set t = x + 1; x = t; } while x < 3; out x;`, "3\n", ""},
+	{"for in", `for x in [10, 20] { out x; }`, "10\n20\n", ""},
+	{"match literals", `set f = fn(v) { return match v { 1 => "one", "two" => 2, true => "yes", null => "nothing", _ => "other" }; }; out f(1); out f("two"); out f(true); out f(null); out f(1.5);`, "one\n2\nyes\nnothing\nother\n", ""},
+	{"match binds and guards", `set f = fn(n) { return match n { 0 => "zero", x if x < 0 => "negative " + str(-x), big if big > 100 => { set half = big / 2; half; }, n => n * 10 }; }; out f(0); out f(-4); out f(300); out f(7);`, "zero\nnegative 4\n150\n70\n", ""},
+	{"match without a case taken", `out match 5 { 1 => "one", x if x > 9 => "big" }; out match 5 { _ => { out "side"; } };`, "null\nside\nnull\n", ""},
+	{"match evaluates once", `set calls = 0; set next = fn() { calls++; return calls; }; out match next() { 2 => "two", 1 => "one" }; out calls;`, "one\n1\n", ""},
+	{"nested match in loop", `for i in 0..4 { out match i % 2 { 0 => match i { 0 => "zero", _ => "even" }, _ => "odd" }; }`, "zero\nodd\neven\nodd\n", ""},
+	{"match binding is scoped", `set x = "outer"; out match 3 { x if x > 1 => x }; out x;`, "3\nouter\n", ""},
+	{"slices", `set a = [1, 2, 3, 4, 5]; out a[1:4]; out a[:2]; out a[3:]; out a[:]; out a[4:1]; out a[-3:99];`, "[2, 3, 4]\n[1, 2]\n[4, 5]\n[1, 2, 3, 4, 5]\n[]\n[1, 2, 3, 4, 5]\n", ""},
+	{"string slices", `set s = "hello world"; out s[:5]; out s[6:]; out s[4:7]; out len(s[20:]);`, "hello\nworld\no w\n0\n", ""},
+	{"slices copy", `set a = [1, 2, 3]; set b = a[1:]; b[0] = 9; b.push(4); out a; out b; set const deep c = [1, 2]; set d = c[:]; d[0] = 5; out d;`, "[1, 2, 3]\n[9, 3, 4]\n[5, 2]\n", ""},
+	{"slice through ?.", `set h = {"xs": [1, 2, 3]}; set n = null; out h?.xs[1:]; out n?.xs[1:];`, "[2, 3]\nnull\n", ""},
+	{"slice bounds are integers", `out [1, 2][0:"1"];`, "", "slice bounds must be INTEGER, got STRING"},
+	{"slice of a hash", `out {"a": 1}[0:1];`, "", "slice not supported: HASH"},
+	{"for in range", `set n = 0; for i in 1..4 { n = n + i; } for i in 3..3 { n = 100; } out n; for i in range(5, 0, -2) { out i; }`, "6\n5\n3\n1\n", ""},
+	{"range binds below +", `set n = 2; out 0..n + 1; out len(0..n * 3); out (1..4)[2]; out (1..4)[3]; out range(3); out range(0, 7, 3).len();`, "0..3\n6\n3\nnull\n0..3\n3\n", ""},
+	{"range of a billion", `set r = 0..1_000_000_000; out len(r); out r[999_999_999]; out type(r);`, "1000000000\n999999999\nRANGE\n", ""},
+	{"range bounds are integers", `out 0..1.5;`, "", "range bounds must be INTEGER, got INTEGER..FLOAT"},
+	{"else if", `set x = 2; if (x == 1) { out "one"; } else if (x == 2) { out "two"; } else { out "many"; }`, "two\n", ""},
+
+	// Errors
+	{"try catch", `set r = try { throw "bad"; } catch (e) { "caught " + e.message }; out r;`, "caught bad\n", ""},
+	{"uncaught throw", `out "before"; throw "boom"; out "after";`, "before\n", "uncaught throw: boom"},
+	{"error values", `set e = try { throw error("not found", 404, {"id": 7}); } catch (e) { e }; out e; out e.message; out e.code; out e.data["id"]; out type(e);`, "ERROR: not found (code 404)\nnot found\n404\n7\nERROR\n", ""},
+	{"error defaults", `set e = error("plain"); out e.code; out e.data; out e.stack; out e.missing;`, "null\n{}\n[]\nnull\n", ""},
+	{"float parsing rejects non-finite", `out to_float(" 1.5e3 "); out to_float("nan"); out to_float("-Inf").message; out try_float("infinity"); out try_float("NaN", 0); out try_float("1e400", -1);`, "1500\nERROR: to_float: cannot parse \"nan\" as a number\nto_float: cannot parse \"-Inf\" as a number\nnull\n0\n-1\n", ""},
+	{"builtin errors have members", `out to_int("x").message;`, "to_int: cannot parse \"x\" as an integer\n", ""},
+	{"error arguments", `out error(1); out error("m", [1]); out error("m", 1, 2);`, "ERROR: error: message must be STRING, got INTEGER\nERROR: error: code must be INTEGER or STRING, got ARRAY\nERROR: error: data must be HASH, got INTEGER\n", ""},
+	{"throw unwinds calls", `set inner = fn() { throw error("deep", "E_DEEP"); }; set outer = fn() { return 1 + inner(); }; set e = try { out 10 + outer(); } catch (e) { e }; out e.code; out e.stack; out "after";`, "E_DEEP\n[\"inner\", \"outer\", \"<main>\"]\nafter\n", ""},
+	{"throw keeps first stack", `set f = fn() { throw error("x"); }; set g = fn() { try { f(); } catch (e) { throw e; } }; out try { g(); } catch (e) { e.stack };`, "[\"f\", \"g\", \"<main>\"]\n", ""},
+	{"try statement ending in statements", `for (set i = 0; i < 3; i++) { try { if (i == 1) { throw "odd"; } out i; } catch (e) { out e.message; } } set r = try { set x = 1; } catch (e) { e }; out r; out "after";`, "0\nodd\n2\nnull\nafter\n", ""},
+	{"with_timeout rethrows", `out try { with_timeout(1000, fn() { throw error("bad", 7); }); } catch (e) { e.code }; out try { with_timeout(1000, fn() { throw "plain"; }); } catch (e) { e.message }; with_timeout(1000, fn() { throw "again"; }); out "unreachable";`, "7\nplain\n", "uncaught throw: again"},
+	{"caught runtime errors are error values", `set a = freeze([1]); set e = try { a[0] = 2; } catch (e) { e }; out type(e); out e.message; out e.code; out try { a.push(2); } catch (e) { e.code }; set t = try { throw "x"; } catch (e) { e }; out [t.message, t.code, t.data.value]; set n = try { throw 42; } catch (e) { e }; out [n.message, n.data.value + 1];`, "ERROR\ncannot assign to a frozen array\nE_RUNTIME\nE_RUNTIME\n[\"x\", \"E_THROW\", \"x\"]\n[\"42\", 43]\n", ""},
+	{"return inside try", `set g = fn() { try { return 1; } catch (e) { out "stale handler"; return -1; } }; out g(); throw "later";`, "1\n", "uncaught throw: later"},
+	{"uncaught error value", `throw error("disk full", "E_DISK");`, "", "uncaught error: disk full (code E_DISK)"},
+
+	// Tasks
+	{"group waits for tasks", `set n = 0; group { spawn fn() { n = n + 1; }(); } out n;`, "1\n", ""},
+}
+
+func TestConformance(t *testing.T) {
+	for _, tc := range conformance {
+		t.Run(tc.name, func(t *testing.T) {
+			out, err := runSource(tc.src)
+			if out != tc.out {
+				t.Errorf("output = %q, want %q", out, tc.out)
+			}
+			switch {
+			case tc.err == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tc.err != "" && err == nil:
+				t.Errorf("no error, want %q", tc.err)
+			case tc.err != "" && !strings.Contains(err.Error(), tc.err):
+				t.Errorf("error = %q, want it to contain %q", err, tc.err)
+			}
+		})
+	}
+}
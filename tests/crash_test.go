@@ -0,0 +1,179 @@
+package tests
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"xon/builtins"
+	"xon/compiler"
+	"xon/lexer"
+	"xon/parser"
+	"xon/vm"
+)
+
+func TestCrashTrace(t *testing.T) {
+	program := parser.New(lexer.New(`set check = fn(x) { throw "bad " + x; }; check(1);`)).ParseProgram()
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		t.Fatal(err)
+	}
+	defer func(n int) { vm.TraceLength = n }(vm.TraceLength)
+	vm.TraceLength = 4
+	machine := vm.New(comp.Bytecode())
+	err := machine.Run()
+	if err == nil || err.Error() != "uncaught throw: bad 1" {
+		t.Fatalf("Run() = %v", err)
+	}
+	wantTrace := []string{
+		"check 0000 OpString 0",
+		"check 0001 OpGetLocal 0",
+		"check 0002 OpAdd",
+		"check 0003 OpThrow",
+	}
+	if got := machine.Trace(); !reflect.DeepEqual(got, wantTrace) {
+		t.Errorf("Trace() = %q, want %q", got, wantTrace)
+	}
+	wantStack := []vm.StackFrame{{Name: "check", Line: 1}, {Name: "<main>", Line: 1}}
+	if got := machine.StackTrace(); !reflect.DeepEqual(got, wantStack) {
+		t.Errorf("StackTrace() = %v, want %v", got, wantStack)
+	}
+	if n := machine.InstructionCount(); n != 9 {
+		t.Errorf("InstructionCount() = %d, want 9", n)
+	}
+}
+
+func TestStackTraceLines(t *testing.T) {
+	program := parser.New(lexer.New(`set name = "x";
+set check = fn(v) {
+  set y = v + 1;
+  return name(y);
+};
+set run = fn() {
+  return check(
+    41
+  );
+};
+run();`)).ParseProgram()
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		t.Fatal(err)
+	}
+	machine := vm.New(comp.Bytecode())
+	if err := machine.Run(); err == nil || err.Error() != "calling non-function: STRING" {
+		t.Fatalf("Run() = %v", err)
+	}
+	want := []vm.StackFrame{{Name: "check", Line: 4}, {Name: "run", Line: 7}, {Name: "<main>", Line: 11}}
+	if got := machine.StackTrace(); !reflect.DeepEqual(got, want) {
+		t.Errorf("StackTrace() = %v, want %v", got, want)
+	}
+}
+
+func TestStackTraceFiles(t *testing.T) {
+	program := parser.New(lexer.NewChunks(
+		lexer.Chunk{File: "lib.xn", Source: "set twice = fn(f) {\n  return f() * 2;\n};"},
+		lexer.Chunk{File: "main.xn", Source: "set n = 1;\nout twice(fn() { return n.size; });"},
+	)).ParseProgram()
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		t.Fatal(err)
+	}
+	machine := vm.New(comp.Bytecode())
+	if err := machine.Run(); err == nil {
+		t.Fatal("Run() succeeded")
+	}
+	want := []vm.StackFrame{
+		{Name: "<anonymous fn>", File: "main.xn", Line: 2},
+		{Name: "twice", File: "lib.xn", Line: 2},
+		{Name: "<main>", File: "main.xn", Line: 2},
+	}
+	if got := machine.StackTrace(); !reflect.DeepEqual(got, want) {
+		t.Errorf("StackTrace() = %v, want %v", got, want)
+	}
+	if got := want[1].String(); got != "twice (lib.xn:2)" {
+		t.Errorf("String() = %q", got)
+	}
+	_, err := compileSource(t, "set x = 1;\nout y;")
+	if err == nil || err.Error() != "Line 2, Col 5: undefined variable y" {
+		t.Errorf("compile error = %v", err)
+	}
+}
+
+func TestStackTraceOverflow(t *testing.T) {
+	program := parser.New(lexer.NewChunks(lexer.Chunk{File: "deep.xn", Source: "set f = fn(n) {\n  return f(n + 1);\n};\nf(0);"})).ParseProgram()
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		t.Fatal(err)
+	}
+	defer func(n int) { vm.MaxFrames = n }(vm.MaxFrames)
+	vm.MaxFrames = 50
+	machine := vm.New(comp.Bytecode())
+	err := machine.Run()
+	if err == nil || err.Error() != "stack overflow: call depth exceeded 50 frames (raise with --max-frames)" {
+		t.Fatalf("Run() = %v", err)
+	}
+	want := []vm.StackFrame{
+		{Name: "f", File: "deep.xn", Line: 2, Repeats: 49},
+		{Name: "<main>", File: "deep.xn", Line: 4},
+	}
+	got := machine.StackTrace()
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("StackTrace() = %v, want %v", got, want)
+	}
+	if s := got[0].String(); s != "f (deep.xn:2) (repeated 49 times)" {
+		t.Errorf("String() = %q", s)
+	}
+}
+
+func TestWriteCrashReport(t *testing.T) {
+	report := &builtins.CrashReport{
+		Script:  "job.xn",
+		Version: builtins.Version,
+		Error:   "uncaught throw: \"bad\"",
+		Stack:   []string{"check", "<main>"},
+		Globals: map[string]string{"count": "2"},
+	}
+
+	dir := t.TempDir()
+	where, err := builtins.WriteCrashReport(dir, report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Dir(where) != dir || !strings.HasPrefix(filepath.Base(where), "crash-") {
+		t.Errorf("report written to %s, want a crash-*.json file in %s", where, dir)
+	}
+	data, err := os.ReadFile(where)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"<main>"`) {
+		t.Errorf("report escapes <main>:\n%s", data)
+	}
+
+	var posted builtins.CrashReport
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &posted); err != nil || r.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+	if _, err := builtins.WriteCrashReport(server.URL, report); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&posted, report) {
+		t.Errorf("webhook got %+v, want %+v", posted, *report)
+	}
+	if _, err := builtins.WriteCrashReport(server.URL+"/missing", &builtins.CrashReport{}); err == nil {
+		t.Error("a webhook failure was not reported")
+	}
+}
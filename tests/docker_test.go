@@ -0,0 +1,122 @@
+package tests
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeDocker answers the Engine API calls the docker_* builtins make,
+// recording each request as "METHOD path?query" and the last create body.
+type fakeDocker struct {
+	mu       sync.Mutex
+	requests []string
+	created  map[string]any
+	pulled   bool
+}
+
+func (f *fakeDocker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, r.Method+" "+r.URL.RequestURI())
+	switch {
+	case r.URL.Path == "/containers/json":
+		fmt.Fprint(w, `[{"Id": "0123456789abcdef", "Names": ["/web"], "Image": "nginx", "State": "running", "Status": "Up 2 minutes"}]`)
+	case r.URL.Path == "/containers/create":
+		if !f.pulled {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"message": "No such image: alpine:3"}`)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&f.created)
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, `{"Id": "fedcba9876543210"}`)
+	case r.URL.Path == "/images/create":
+		f.pulled = true
+		fmt.Fprint(w, `{"status": "Pulling"}`+"\n"+`{"status": "Done"}`)
+	case r.URL.Path == "/containers/fedcba9876543210/start":
+		w.WriteHeader(http.StatusNoContent)
+	case r.URL.Path == "/containers/fedcba9876543210/json":
+		fmt.Fprint(w, `{"Name": "/job"}`)
+	case r.URL.Path == "/containers/job/stop":
+		w.WriteHeader(http.StatusNotModified)
+	case r.URL.Path == "/containers/job/logs":
+		for _, frame := range []struct {
+			stream byte
+			text   string
+		}{{1, "hello\n"}, {2, "oops\n"}} {
+			header := []byte{frame.stream, 0, 0, 0, 0, 0, 0, 0}
+			binary.BigEndian.PutUint32(header[4:], uint32(len(frame.text)))
+			w.Write(append(header, frame.text...))
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, `{"message": "No such container: `+strings.Split(r.URL.Path, "/")[2]+`"}`)
+	}
+}
+
+func TestDockerBuiltins(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake daemon listens on a unix socket")
+	}
+	sock := filepath.Join(t.TempDir(), "docker.sock")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	daemon := &fakeDocker{}
+	srv := &http.Server{Handler: daemon}
+	go srv.Serve(ln)
+	defer srv.Close()
+	t.Setenv("DOCKER_HOST", "unix://"+sock)
+
+	out, err := runSource(`
+out docker_ps();
+out docker_run("alpine:3", {"name": "job", "cmd": ["echo", "hello"], "env": {"MODE": "test"}, "ports": {"8080": 80}, "volumes": {"/srv": "/data"}, "remove": true});
+out docker_logs("job", {"tail": 10});
+out docker_stop("job", {"timeout": 5});
+out docker_stop("ghost");
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `[{"id": "0123456789ab", "image": "nginx", "name": "web", "state": "running", "status": "Up 2 minutes"}]
+{"id": "fedcba987654", "name": "job"}
+hello
+oops
+
+true
+ERROR: docker_stop: No such container: ghost
+`
+	if out != want {
+		t.Errorf("output:\n%s\nwant:\n%s", out, want)
+	}
+
+	daemon.mu.Lock()
+	defer daemon.mu.Unlock()
+	for _, req := range []string{
+		"GET /containers/json",
+		"POST /containers/create?name=job",
+		"POST /images/create?fromImage=alpine&tag=3",
+		"GET /containers/job/logs?stdout=1&stderr=1&tail=10",
+		"POST /containers/job/stop?t=5",
+	} {
+		if !strings.Contains(strings.Join(daemon.requests, "\n"), req) {
+			t.Errorf("daemon did not get %s; got %q", req, daemon.requests)
+		}
+	}
+	created, _ := json.Marshal(daemon.created)
+	for _, part := range []string{`"Cmd":["echo","hello"]`, `"Env":["MODE=test"]`, `"ExposedPorts":{"80/tcp":{}}`, `"Binds":["/srv:/data"]`, `"PortBindings":{"80/tcp":[{"HostPort":"8080"}]}`, `"AutoRemove":true`} {
+		if !strings.Contains(string(created), part) {
+			t.Errorf("create body %s does not contain %s", created, part)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// fakeTools writes each script in tools as an executable of that name to a
+// temporary directory and makes it the whole PATH for the rest of t, so
+// builtins that shell out run the fakes instead of the real tools. The
+// scripts find the returned log path, which sits in the same directory, in
+// $FAKE_TOOL_LOG; PATH holds nothing else, so they call other commands by
+// absolute path.
+func fakeTools(t *testing.T, tools map[string]string) (logPath string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake tools are shell scripts")
+	}
+	dir := t.TempDir()
+	for name, script := range tools {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	logPath = filepath.Join(dir, "calls")
+	t.Setenv("FAKE_TOOL_LOG", logPath)
+	t.Setenv("PATH", dir)
+	return logPath
+}
@@ -0,0 +1,21 @@
+package tests
+
+import (
+	"testing"
+	"xon/fuzz"
+)
+
+// FuzzScripts checks that no input makes the parser, compiler or VM panic.
+// go test runs the seeds; go test -fuzz=FuzzScripts explores further. The
+// inputs run, so only fuzz.Seeds, which call no builtins with side effects,
+// seed it; features.xn writes files and starts servers.
+func FuzzScripts(f *testing.F) {
+	for _, seed := range fuzz.Seeds {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, src string) {
+		if err := fuzz.Check(src); err != nil {
+			t.Fatalf("%v\ninput: %q", err, src)
+		}
+	})
+}
@@ -0,0 +1,124 @@
+package tests
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFrameLoop(t *testing.T) {
+	out, err := runSource(`
+out frame_step(0.1);
+out draw_rect(0, 0, 1, 1, "red");
+set pos = {"x": 10};
+on_frame(fn(dt) {
+    if (key_down("right")) { pos.x = pos.x + 100 * dt; }
+    if (key_hit("space")) { draw_text(0, 0, "jump"); }
+    draw_rect(pos.x, 5, 2.5, 3, [0, 255, 0]);
+    draw_line(0, 0, 4, 2, "#fff");
+}, 30);
+out frame_step(0.5, {"keys": ["right", "SPACE"]});
+out frame_step(0.5, {"keys": ["right", "space"]});
+out pos.x;
+out frame_step(0.5, {"keys": []});
+out key_down("right");
+out key_down("f1");
+out frame_step(0.1, {"keys": ["lef"]});
+out on_frame(fn(dt) { return draw_circle(0, 0, 1, "nope"); });
+out frame_step(0);
+out on_frame(null, 0);
+on_frame(null);
+out frame_step(0.1);
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `ERROR: frame_step: no function is registered with on_frame
+ERROR: draw_rect: draw inside the function given to on_frame
+[{"color": "#ffffff", "op": "text", "text": "jump", "x": 0, "y": 0}, {"color": "#00ff00", "h": 3, "op": "rect", "w": 2.5, "x": 60, "y": 5}, {"color": "#ffffff", "op": "line", "x": 0, "x2": 4, "y": 0, "y2": 2}]
+[{"color": "#00ff00", "h": 3, "op": "rect", "w": 2.5, "x": 110, "y": 5}, {"color": "#ffffff", "op": "line", "x": 0, "x2": 4, "y": 0, "y2": 2}]
+110
+[{"color": "#00ff00", "h": 3, "op": "rect", "w": 2.5, "x": 110, "y": 5}, {"color": "#ffffff", "op": "line", "x": 0, "x2": 4, "y": 0, "y2": 2}]
+false
+ERROR: key_down: unknown key "f1"; use a letter, a digit or one of left, right, up, down, space, enter, escape, shift, ctrl, tab, backspace
+ERROR: frame_step: unknown key "lef"; use a letter, a digit or one of left, right, up, down, space, enter, escape, shift, ctrl, tab, backspace
+null
+ERROR: draw_circle: unknown color "nope"
+ERROR: on_frame: fps must be between 1 and 240, got 0
+ERROR: frame_step: no function is registered with on_frame
+`
+	if out != want {
+		t.Errorf("output =\n%s\nwant\n%s", out, want)
+	}
+}
+
+func TestFrameRender(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "frame.png")
+	out, err := runSource(`
+set dot = sprite_from(["r.", ".r"], {"r": "#ff0000"});
+out dot;
+out sprite_size(dot);
+out sprite_from(["ab", "a"], {"a": "#000", "b": "#fff"});
+out sprite_from(["ax"], {"a": "#000"});
+out sprite_load("missing.png");
+on_frame(fn(dt) {
+    draw_clear("#000080");
+    draw_rect(0, 0, 4, 4, "rgba(255, 255, 255, 0.5)");
+    draw_circle(20, 20, 5, "lime");
+    draw_line(10, 0, 13, 0, "yellow");
+    draw_sprite(dot, 30, 0, 2);
+    draw_text(0, 30, "not rendered to png");
+});
+out len(frame_step(0, {"png": "` + filepath.ToSlash(path) + `", "width": 40, "height": 40}));
+on_frame(null);
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `sprite(2x2)
+[2, 2]
+ERROR: sprite_from: row 1 has 1 pixels; rows must be equal and not empty
+ERROR: sprite_from: row 0 uses 'x', which is not in the palette
+ERROR: sprite_load: open missing.png: no such file or directory
+6
+`
+	if out != want {
+		t.Fatalf("output =\n%s\nwant\n%s", out, want)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	img, err := png.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b != image.Rect(0, 0, 40, 40) {
+		t.Fatalf("bounds = %v", b)
+	}
+	for _, c := range []struct {
+		x, y int
+		want color.RGBA
+	}{
+		{39, 39, color.RGBA{0, 0, 128, 255}},   // clear
+		{1, 1, color.RGBA{128, 128, 192, 255}}, // half-transparent white over it
+		{20, 20, color.RGBA{0, 255, 0, 255}},   // circle centre
+		{20, 16, color.RGBA{0, 255, 0, 255}},   // inside its top
+		{24, 16, color.RGBA{0, 0, 128, 255}},   // outside its corner
+		{12, 0, color.RGBA{255, 255, 0, 255}},  // line
+		{31, 1, color.RGBA{255, 0, 0, 255}},    // first sprite pixel, doubled
+		{33, 1, color.RGBA{0, 0, 128, 255}},    // transparent sprite pixel
+		{33, 3, color.RGBA{255, 0, 0, 255}},    // last sprite pixel
+	} {
+		r, g, b, a := img.At(c.x, c.y).RGBA()
+		got := color.RGBA{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8), uint8(a >> 8)}
+		if got != c.want {
+			t.Errorf("pixel %d,%d = %v, want %v", c.x, c.y, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package tests
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGitBuiltins(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	for k, v := range map[string]string{
+		"GIT_AUTHOR_NAME": "Xon", "GIT_AUTHOR_EMAIL": "xon@example.com",
+		"GIT_COMMITTER_NAME": "Xon", "GIT_COMMITTER_EMAIL": "xon@example.com",
+		"GIT_CONFIG_GLOBAL": os.DevNull, "GIT_CONFIG_NOSYSTEM": "1",
+	} {
+		t.Setenv(k, v)
+	}
+	dir := t.TempDir()
+	remote := filepath.Join(dir, "remote.git")
+	if out, err := exec.Command("git", "init", "--quiet", "--bare", "--initial-branch=main", remote).CombinedOutput(); err != nil {
+		t.Fatalf("git init: %v %s", err, out)
+	}
+	a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+
+	out, err := runSource(fmt.Sprintf(`
+set a = %q;
+set b = %q;
+out git_clone(%q, a).head; // an empty repository has no HEAD yet
+git_clone(%q, b);
+writeFile(a + "/notes.txt", "one");
+set st = git_status(a);
+out st.clean;
+out st.files[0];
+set first = git_commit(a, "Add notes");
+out len(first);
+out git_commit(a, "Again");
+out git_push(a, {"remote": "origin", "branch": "main", "set_upstream": true});
+out git_status(a).upstream;
+writeFile(a + "/notes.txt", "two");
+out git_status(a).files[0];
+git_commit(a, "Update notes");
+out git_status(a).ahead;
+git_push(a);
+set pulled = git_pull(b);
+out pulled.updated;
+out readFile(b + "/notes.txt");
+out git_pull(b).updated;
+out git_status(b).branch;
+out git_clone(%q, b);
+`, a, b, remote, remote, remote))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"",
+		"false",
+		`{"index": "?", "path": "notes.txt", "worktree": "?"}`,
+		"40",
+		"ERROR: git_commit: nothing to commit",
+		"true",
+		"origin/main",
+		`{"index": "", "path": "notes.txt", "worktree": "M"}`,
+		"1",
+		"true",
+		"two",
+		"false",
+		"main",
+	}
+	lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+	if len(lines) != len(want)+1 {
+		t.Fatalf("output:\n%s", out)
+	}
+	for i, w := range want {
+		if lines[i] != w {
+			t.Errorf("line %d = %q, want %q", i+1, lines[i], w)
+		}
+	}
+	if last := lines[len(want)]; !strings.HasPrefix(last, "ERROR: git_clone: fatal: destination path") {
+		t.Errorf("cloning into an existing repository: %q", last)
+	}
+}
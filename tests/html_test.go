@@ -0,0 +1,105 @@
+package tests
+
+import (
+	"strings"
+	"testing"
+	"xon/builtins"
+	"xon/object"
+)
+
+const selectorPage = `<!DOCTYPE html>
+<html><head><title>T</title><style>p > a { color: red }</style></head>
+<BODY>
+<div id="main" class="page wide">
+  <h1 lang="en-GB">Title</h1>
+  <p class="intro">Hello <a href="https://x.test/1">one</a></p>
+  <p>Unclosed <a href="/2" rel="nofollow noopener">two</a>
+  <ul>
+    <li>a<li class="hot">b<li>c
+  </ul>
+  <br/><input type=text name=q disabled>
+</div>
+<!-- <a href="/hidden">comment</a> -->
+<script>document.write("<a href='/js'>js</a>")</script>
+</BODY></html>`
+
+func TestHTMLSelect(t *testing.T) {
+	doc := builtins.GetBuiltinByName("html_parse").Fn(&object.String{Value: selectorPage})
+	selectAll := builtins.GetBuiltinByName("html_select").Fn
+	text := builtins.GetBuiltinByName("html_text").Fn
+	for selector, want := range map[string]string{
+		"a":                     "one two",
+		"div a":                 "one two",
+		"p > a":                 "one two",
+		"div > a":               "",
+		"#main h1":              "Title",
+		".page.wide h1":         "Title",
+		".page.narrow h1":       "",
+		"p.intro a":             "one",
+		"a[href^=https]":        "one",
+		`a[href="/2"]`:          "two",
+		"a[rel~=noopener]":      "two",
+		"a[href*=x]":            "one",
+		"a[href$='1']":          "one",
+		"h1[lang|=en]":          "Title",
+		"li":                    "a b c",
+		"li:first-child":        "a",
+		"li:last-child":         "c",
+		"li:nth-child(2)":       "b",
+		"li:nth-child(odd)":     "a c",
+		"li:not(.hot)":          "a c",
+		"li.hot + li":           "c",
+		"li:first-child ~ li":   "b c",
+		"h1 ~ p a, li.hot":      "one two b",
+		"ul > li[class=hot]":    "b",
+		"*:first-child > title": "T",
+	} {
+		result := selectAll(doc, &object.String{Value: selector})
+		arr, ok := result.(*object.Array)
+		if !ok {
+			t.Errorf("%s: %s", selector, result.Inspect())
+			continue
+		}
+		var got []string
+		for _, el := range arr.Elements {
+			got = append(got, text(el).Inspect())
+		}
+		if strings.Join(got, " ") != want {
+			t.Errorf("%s matched %q, want %q", selector, got, want)
+		}
+	}
+}
+
+func TestHTMLParseIsForgiving(t *testing.T) {
+	for src, want := range map[string]string{
+		`<p>One<p>Two`:                           `<p>One</p><p>Two</p>`,
+		`<UL><LI>a<LI>b</UL>`:                    `<ul><li>a</li><li>b</li></ul>`,
+		`<b><i>x</b></i>y`:                       `<b><i>x</i></b>y`,
+		`<img src=a.png alt="A &amp; B"><br>`:    `<img src="a.png" alt="A &amp; B"><br>`,
+		`a < b && c</div>`:                       `a &lt; b &amp;&amp; c`,
+		`<script>if (a<b) {}</script>`:           `<script>if (a<b) {}</script>`,
+		`<table><tr><td>1<td>2<tr><td>3</table>`: `<table><tr><td>1</td><td>2</td></tr><tr><td>3</td></tr></table>`,
+		`<div class="a" class="b" hidden>`:       `<div class="a" hidden=""></div>`,
+		`<!-- open`:                              `<!-- open-->`,
+	} {
+		got := builtins.GetBuiltinByName("html_parse").Fn(&object.String{Value: src}).Inspect()
+		if got != want {
+			t.Errorf("%s parsed to\n %s\nwant\n %s", src, got, want)
+		}
+	}
+}
+
+func TestHTMLSelectorErrors(t *testing.T) {
+	for selector, want := range map[string]string{
+		"div[":            `bad selector "div[" at 5: expected an attribute name`,
+		"a >":             `bad selector "a >" at 4: expected a selector`,
+		"li:hover":        `bad selector "li:hover" at 9: unsupported pseudo-class :hover`,
+		"li:nth-child(0)": `bad selector "li:nth-child(0)" at 15: expected a position, odd or even in :nth-child()`,
+		"a, ":             `bad selector "a, " at 4: expected a selector`,
+	} {
+		result := builtins.GetBuiltinByName("html_select").Fn(&object.String{Value: "<a></a>"}, &object.String{Value: selector})
+		if result.Inspect() != "ERROR: html_select: "+want {
+			t.Errorf("%s: %s", selector, result.Inspect())
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package tests
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"xon/lexer"
+	"xon/token"
+)
+
+func TestLexerEdgeCases(t *testing.T) {
+	for src, want := range map[string]string{
+		// Longest operator wins; "<<=" is a shift followed by assignment.
+		"a<<=b>>c|>d=>e":  `IDENT "a", << "<<", = "=", IDENT "b", >> ">>", IDENT "c", |> "|>", IDENT "d", => "=>", IDENT "e"`,
+		"x++ -- !== &&||": `IDENT "x", ++ "++", -- "--", != "!=", = "=", && "&&", || "||"`,
+		// A dot is part of a number only when a digit follows it.
+		"1.5.x 3. 0.25": `FLOAT "1.5", . ".", IDENT "x", INT "3", . ".", FLOAT "0.25"`,
+		// Interpolation is left to the parser; the string keeps ${...}.
+		`"a${b}c" "x`:              `STRING "a${b}c", ILLEGAL "unterminated string literal"`,
+		`"""a "b" ""c""" "`:        `HEREDOC "a \"b\" \"\"c", ILLEGAL "unterminated string literal"`,
+		"// line\n/* block\n */ y": `IDENT "y"`,
+		"a.b.c(1)[2]":              `IDENT "a", . ".", IDENT "b", . ".", IDENT "c", ( "(", INT "1", ) ")", [ "[", INT "2", ] "]"`,
+		"a??b ?. ?":                `IDENT "a", ?? "??", IDENT "b", ?. "?.", ILLEGAL "?"`,
+		"a<=b>=c< =d":              `IDENT "a", <= "<=", IDENT "b", >= ">=", IDENT "c", < "<", = "=", IDENT "d"`,
+		"a***b * *c":               `IDENT "a", ** "**", * "*", IDENT "b", * "*", * "*", IDENT "c"`,
+		"1..n 1...2 a.b..c":        `INT "1", .. "..", IDENT "n", INT "1", .. "..", . ".", INT "2", IDENT "a", . ".", IDENT "b", .. "..", IDENT "c"`,
+		"§":                        `ILLEGAL "§"`,
+		"0x1F 0b10.5 0o7g 0xa.b":   `INT "0x1F", INT "0b10", . ".", INT "5", INT "0o7g", INT "0xa", . ".", IDENT "b"`,
+		"setx set_ set":            `IDENT "setx", IDENT "set_", SET "set"`,
+		"do done while":            `DO "do", IDENT "done", WHILE "while"`,
+		"1_000 1.5e9 2E-3 1e+x 7e": `INT "1_000", FLOAT "1.5e9", FLOAT "2E-3", INT "1", IDENT "e", + "+", IDENT "x", INT "7", IDENT "e"`,
+	} {
+		var got []string
+		l := lexer.New(src)
+		for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+			got = append(got, fmt.Sprintf("%s %q", tok.Type, tok.Literal))
+		}
+		if strings.Join(got, ", ") != want {
+			t.Errorf("%q:\n got %s\nwant %s", src, strings.Join(got, ", "), want)
+		}
+	}
+}
+
+func TestLexerPositions(t *testing.T) {
+	l := lexer.New("set a = 1;\n  out \"é\" ;")
+	for _, want := range []struct {
+		lit                        string
+		line, col, endLine, endCol int
+	}{
+		{"set", 1, 1, 1, 4},
+		{"a", 1, 5, 1, 6},
+		{"=", 1, 7, 1, 8},
+		{"1", 1, 9, 1, 10},
+		{";", 1, 10, 1, 11},
+		{"out", 2, 3, 2, 6},
+		{"é", 2, 7, 2, 10},
+		{";", 2, 11, 2, 12},
+	} {
+		tok := l.NextToken()
+		if tok.Literal != want.lit || tok.Line != want.line || tok.Col != want.col || tok.EndLine != want.endLine || tok.EndCol != want.endCol {
+			t.Errorf("got %q at %d:%d-%d:%d, want %q at %d:%d-%d:%d", tok.Literal, tok.Line, tok.Col, tok.EndLine, tok.EndCol,
+				want.lit, want.line, want.col, want.endLine, want.endCol)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package tests
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"xon/compiler"
+	"xon/lexer"
+	"xon/parser"
+	"xon/vm"
+)
+
+// buildXon builds the interpreter into a temporary directory for tests
+// that run it as a command.
+func buildXon(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("needs the Go toolchain to build xon")
+	}
+	xon := filepath.Join(t.TempDir(), "xon")
+	if out, err := exec.Command("go", "build", "-o", xon, "..").CombinedOutput(); err != nil {
+		t.Fatalf("go build: %v\n%s", err, out)
+	}
+	return xon
+}
+
+func TestCallLimits(t *testing.T) {
+	_, err := runSource(`set f = fn(n) { return f(n + 1); }; f(0);`)
+	if err == nil || err.Error() != "stack overflow: call depth exceeded 10000 frames (raise with --max-frames)" {
+		t.Errorf("deep recursion: %v", err)
+	}
+
+	defer func(n int) { vm.MaxStackSize = n }(vm.MaxStackSize)
+	vm.MaxStackSize = 64
+	_, err = runSource(`set f = fn(n) { set a = 1; set b = 2; return f(n + a + b); }; f(0);`)
+	if err == nil || err.Error() != "stack overflow: exceeded 64 stack slots (raise with --max-stack)" {
+		t.Errorf("deep stack: %v", err)
+	}
+
+	program := parser.New(lexer.New(`out 1;`)).ParseProgram()
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		t.Fatal(err)
+	}
+	vm.MaxStackSize = 4 * vm.StackSize
+	machine := vm.New(comp.Bytecode())
+	if err := machine.SetStackPointer(vm.MaxStackSize + 1); err == nil {
+		t.Error("SetStackPointer past MaxStackSize succeeded")
+	}
+	if err := machine.SetStackPointer(vm.MaxStackSize); err != nil {
+		t.Errorf("SetStackPointer(MaxStackSize) = %v", err)
+	}
+}
+
+func TestMaxFramesFlag(t *testing.T) {
+	xon := buildXon(t)
+	script := filepath.Join(t.TempDir(), "depth.xn")
+	os.WriteFile(script, []byte(`set down = fn(n) {
+  if (n == 0) { return 0; }
+  return down(n - 1);
+};
+out down(300);`), 0644)
+
+	out, err := exec.Command(xon, "--max-frames", "100", script).CombinedOutput()
+	if err == nil {
+		t.Fatalf("--max-frames 100 ran to the end:\n%s", out)
+	}
+	for _, want := range []string{
+		"stack overflow: call depth exceeded 100 frames (raise with --max-frames)",
+		"at down (" + script + ":3) (repeated 99 times)",
+	} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("output lacks %q:\n%s", want, out)
+		}
+	}
+
+	out, err = exec.Command(xon, "--max-frames", "400", script).CombinedOutput()
+	if err != nil || string(out) != "0\n" {
+		t.Errorf("--max-frames 400 = %q, %v", out, err)
+	}
+}
@@ -0,0 +1,65 @@
+package tests
+
+import (
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// Tasks of one script contend for fs_lock the way separate processes do:
+// a second lock waits for fs_unlock, or gives up after its timeout.
+func TestFsLockWaitsWithinProcess(t *testing.T) {
+	path := strconv.Quote(filepath.Join(t.TempDir(), "job.lock"))
+	out, err := runSource(`set path = ` + path + `;
+group {
+  spawn fn() { fs_lock(path); sleep(100); out "first done"; fs_unlock(path); }();
+  spawn fn() { sleep(20); out fs_lock(path); out "second locked"; fs_unlock(path); }();
+}
+out fs_lock(path);
+group {
+  spawn fn() { out fs_lock(path, {"wait": false}); out fs_lock(path, {"timeout": 60}); }();
+}
+out fs_unlock(path);
+out fs_unlock(path);
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "first done\ntrue\nsecond locked\ntrue\nfalse\nfalse\ntrue\nfalse\n"; out != want {
+		t.Errorf("output = %q, want %q", out, want)
+	}
+}
+
+// A task locking a path it already holds gets an error rather than waiting
+// on itself, and a wait for another task's lock ends when the waiting task
+// times out or its group is canceled.
+func TestFsLockSameTaskAndCancel(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "job.lock")
+	out, err := runSource(`set path = ` + strconv.Quote(file) + `;
+out fs_lock(path);
+out fs_lock(path);
+out with_timeout(100, fn() { return fs_lock(path); });
+group {
+  spawn fn() { out with_timeout(50, fn() { return fs_lock(path); }); }();
+}
+try {
+  group {
+    spawn fn() { fs_lock(path); out "not reached"; }();
+    spawn fn() { sleep(20); throw "stop"; }();
+  }
+} catch (e) {
+  out e.message;
+}
+out fs_unlock(path);
+out fs_lock(path);
+out fs_unlock(path);
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	held := "ERROR: fs_lock: " + file + " is already locked by this task\n"
+	want := "true\n" + held + held + "ERROR: with_timeout: timed out after 50 ms\nstop\ntrue\ntrue\ntrue\n"
+	if out != want {
+		t.Errorf("output = %q, want %q", out, want)
+	}
+}
@@ -0,0 +1,89 @@
+package tests
+
+import (
+	"strings"
+	"testing"
+	"xon/ast"
+	"xon/builtins"
+	"xon/lexer"
+	"xon/minify"
+	"xon/parser"
+)
+
+const minifySample = `// Totals an order.
+set tax = 0.5;
+/// total(items) adds up item prices.
+set total = fn(items) {
+    set subtotal = 0;
+    for item in items {
+        subtotal = subtotal + item["price"];   // running sum
+    }
+    set doubled = map(items, fn(entry) { return entry["price"] * 2; });
+    return subtotal + tax + len(doubled) - -1;
+};
+set note = """a "quoted" // not a comment""";
+set count = 3;
+count--;
+out total([{"price": 2}, {"price": 3}]);
+out filter([1, 2, 3], fn(value) { return value > count - 1; });
+out note + " " + str(count);
+`
+
+// stdAndBuiltinNames is what `xon minify --rename` reserves.
+func stdAndBuiltinNames(t *testing.T) []string {
+	std, err := builtins.LoadStdLib()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := append([]string(nil), builtins.BuiltinNames...)
+	for _, stmt := range parser.New(lexer.New(std)).ParseProgram().Statements {
+		if set, ok := stmt.(*ast.SetStatement); ok {
+			names = append(names, set.Name.Value)
+		}
+	}
+	return names
+}
+
+func TestMinifyRoundTrip(t *testing.T) {
+	want, err := runSource(minifySample)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, opts := range []minify.Options{{}, {RenameLocals: true, Reserved: stdAndBuiltinNames(t)}} {
+		small, err := minify.Minify(minifySample, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Count(small, "\n") != 1 || strings.Contains(small, "running sum") || strings.Contains(small, "Totals") {
+			t.Errorf("rename=%v: not minified:\n%s", opts.RenameLocals, small)
+		}
+		got, err := runSource(small)
+		if err != nil || got != want {
+			t.Errorf("rename=%v: minified script printed %q, %v; want %q\n%s", opts.RenameLocals, got, err, want, small)
+		}
+	}
+
+	if _, err := minify.Minify("set = 1;", minify.Options{}); err == nil {
+		t.Error("Minify accepted a syntax error")
+	}
+}
+
+func TestMinifyRenameKeepsSharedNames(t *testing.T) {
+	reserved := append(stdAndBuiltinNames(t), "a", "b")
+	small, err := minify.Minify(minifySample, minify.Options{RenameLocals: true, Reserved: reserved})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Globals, builtins and standard library functions keep their names.
+	for _, kept := range []string{"set tax=", "set total=fn(", "set note=", "set count=", "count--", "map(", "len(", "filter(", "str(count)"} {
+		if !strings.Contains(small, kept) {
+			t.Errorf("%q was renamed in:\n%s", kept, small)
+		}
+	}
+	// Function locals and parameters are shortened, never to a reserved name.
+	for _, gone := range []string{"items", "subtotal", "item", "doubled", "entry", "value", "fn(a)", "fn(b)"} {
+		if strings.Contains(small, gone) {
+			t.Errorf("%q survived renaming in:\n%s", gone, small)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// A module's globals, read through the import, reflect the updates its own
+// functions make to them.
+func TestModuleStateIsLive(t *testing.T) {
+	inModuleDir(t, map[string]string{"counter.xn": `set count = 0;
+set total = 0.5;
+set inc = fn() { count++; total = total + 1; return count; };
+set name = "first";
+set rename = fn(n) { name = n; };
+`})
+
+	out, err := runSource(`import "counter" as m;
+out m.count;
+m.inc();
+m.inc();
+out m.count;
+out m.total;
+m.rename("second");
+out m.name;
+out m["count"];
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "0\n2\n2.5\nsecond\n2\n"; out != want {
+		t.Errorf("output = %q, want %q", out, want)
+	}
+}
+
+// Tasks can read a module's members while another task's calls into the
+// module update them; run with -race to check the exports hash is guarded.
+func TestModuleStateAcrossTasks(t *testing.T) {
+	inModuleDir(t, map[string]string{"counter.xn": `set count = 0;
+set inc = fn() { count++; };
+`})
+
+	out, err := runSource(`import "counter" as m;
+group {
+  spawn fn() { for (set i = 0; i < 500; i++) { m.inc(); } }();
+  spawn fn() { for (set i = 0; i < 500; i++) { set a = m.count; set b = m["count"]; set s = str(m); } }();
+}
+out m.count;
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "500\n" {
+		t.Errorf("output = %q, want %q", out, "500\n")
+	}
+}
+
+// Module members are read-only to the importer: writes that name the module
+// fail to compile, and writes through an alias fail when they run, so a
+// member's bound slot and the module's hash cannot disagree.
+func TestModuleMembersAreReadOnly(t *testing.T) {
+	inModuleDir(t, map[string]string{"counter.xn": `set count = 0;`})
+
+	for _, src := range []string{
+		`import "counter" as m; m.count = 5;`,
+		`import "counter" as m; m["count"] = 5;`,
+	} {
+		if _, err := runSource(src); err == nil || !strings.Contains(err.Error(), "module members are read-only") {
+			t.Errorf("%s: err = %v", src, err)
+		}
+	}
+
+	out, err := runSource(`import "counter" as m;
+set d = m;
+out try { d.count = 5; } catch (e) { e.message };
+out try { d["count"] = 5; } catch (e) { e.message };
+out m.count;
+out m["count"];
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "cannot assign to a frozen hash\ncannot assign to a frozen hash\n0\n0\n"; out != want {
+		t.Errorf("output = %q, want %q", out, want)
+	}
+}
+
+// inModuleDir writes files to a temporary directory and makes it the
+// working directory, where imports look for modules, for the rest of t.
+func inModuleDir(t *testing.T, files map[string]string) {
+	t.Helper()
+	dir := t.TempDir()
+	for name, src := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Chdir(dir)
+}
@@ -0,0 +1,78 @@
+package tests
+
+import (
+	"os"
+	"runtime"
+	"testing"
+)
+
+func TestWifi(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the fake nmcli stands in for NetworkManager")
+	}
+	log := fakeTools(t, map[string]string{"nmcli": `#!/bin/sh
+echo "$@" >> "$FAKE_TOOL_LOG"
+[ "$3" = "IN-USE,SSID,SIGNAL,SECURITY" ] && /bin/cat <<'EOF'
+ :Cafe:40:WPA2
+*:Home\:5G:72:WPA2 WPA3
+ ::90:WPA2
+ :Cafe:55:WPA2
+ :Guest:81:
+EOF
+[ "$4" = "Nope" ] && { echo "Error: No network with SSID 'Nope' found." >&2; exit 10; }
+exit 0
+`})
+
+	out, err := runSource(`
+set ws = wifi_list();
+out len(ws);
+out ws[0];
+out ws[1]["ssid"];
+out ws[1]["connected"];
+out ws[2]["signal"];
+out wifi_connect("Home:5G", "s3cret");
+out wifi_connect("Guest");
+out wifi_connect("Nope", "x");
+out wifi_connect("");
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `3
+{"connected": false, "security": "", "signal": 81, "ssid": "Guest"}
+Home:5G
+true
+55
+true
+true
+ERROR: wifi_connect: nmcli: Error: No network with SSID 'Nope' found.
+ERROR: wifi_connect: ssid is empty
+`
+	if out != want {
+		t.Errorf("output = %q, want %q", out, want)
+	}
+	calls, _ := os.ReadFile(log)
+	wantCalls := `-t -f IN-USE,SSID,SIGNAL,SECURITY dev wifi list
+dev wifi connect Home:5G password s3cret
+dev wifi connect Guest
+dev wifi connect Nope password x
+`
+	if string(calls) != wantCalls {
+		t.Errorf("nmcli got %q, want %q", calls, wantCalls)
+	}
+}
+
+func TestNetInterfaces(t *testing.T) {
+	out, err := runSource(`
+set loopback = filter(net_interfaces(), fn(i) { return i["loopback"]; });
+out len(loopback) > 0;
+out type(loopback[0]["addresses"]);
+out type(loopback[0]["mtu"]);
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "true\nARRAY\nINTEGER\n"; out != want {
+		t.Errorf("output = %q, want %q", out, want)
+	}
+}
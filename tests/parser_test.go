@@ -0,0 +1,151 @@
+package tests
+
+import (
+	"strings"
+	"testing"
+	"xon/ast"
+	"xon/lexer"
+	"xon/parser"
+)
+
+func TestParserPrecedence(t *testing.T) {
+	for src, want := range map[string]string{
+		"out 1 + 2 * 3;":                   "out (1 + (2 * 3));",
+		"out 1 - 2 - 3;":                   "out ((1 - 2) - 3);",
+		"out -a.b(c)[d];":                  "out (-(a.b(c)[d]));",
+		"x = !y == z;":                     "x = ((!y) == z);",
+		"out 1 + 2 > 3 && 4 != 5 || 6;":    "out ((((1 + 2) > 3) && (4 != 5)) || 6);",
+		"out a < b == c > d;":              "out ((a < b) == (c > d));",
+		"out a <= b + 1 == c >= d;":        "out ((a <= (b + 1)) == (c >= d));",
+		"out -a ** b ** c * d;":            "out ((-(a ** (b ** c))) * d);",
+		"out a ** -b.c[0];":                "out (a ** (-(b.c[0])));",
+		"out 0..n + 1 < m..k;":             "out ((0 .. (n + 1)) < (m .. k));",
+		"out f(1)(2)[0];":                  "out (f(1)(2)[0]);",
+		"set g = fn(x) { return x * 2; };": "set g = fn(x) return (x * 2);;",
+		"out [1, 2 + 3][0];":               "out ([1, (2 + 3)][0]);",
+		"out a?.b.c() ?? d || e;":          "out (a?.b.c() ?? (d || e));",
+		"set [a, b] = f(1);":               "set [a, b] = f(1);",
+		"set const {name, age: n} = p;":    "set {name, age: n} = p;",
+		"a[i + 1] = b.c;":                  "(a[(i + 1)]) = b.c;",
+		"f().x = 1;":                       "f().x = 1;",
+		"out a[1:n - 1][:2] + s[i:];":      "out (((a[1:(n - 1)])[:2]) + (s[i:]));",
+		"out a[:];":                        "out (a[:]);",
+	} {
+		p := parser.New(lexer.New(src))
+		program := p.ParseProgram()
+		if len(p.Errors) != 0 {
+			t.Errorf("%q: unexpected errors %q", src, p.Errors)
+			continue
+		}
+		if got := program.String(); got != want {
+			t.Errorf("%q parsed as %q, want %q", src, got, want)
+		}
+	}
+}
+
+func TestParserErrors(t *testing.T) {
+	for src, want := range map[string]string{
+		"out 1 +;":                  "Line 1, Col 8: no prefix function for ;",
+		"set = 1;":                  "Line 1, Col 5: expected a variable name, got =",
+		"out (1;":                   "Line 1, Col 7: expected )",
+		"set f = fn() { return; };": "Line 1, Col 22: no prefix function for ;",
+		"out 0b102;":                `Line 1, Col 5: could not parse "0b102" as integer`,
+		"out 9223372036854775808;":  `Line 1, Col 5: could not parse "9223372036854775808" as integer`,
+		"out 1__000;":               `Line 1, Col 5: could not parse "1__000" as integer`,
+		"out 1.5_e3;":               `Line 1, Col 5: could not parse "1.5_e3" as float`,
+		"set [a, 1] = p;":           "Line 1, Col 9: expected a variable name, got INT",
+		"set {a b} = p;":            "Line 1, Col 8: expected , or } in the pattern",
+		"set [a, a] = p;":           "Line 1, Col 9: a appears twice in the pattern",
+		"set [] = p;":               "Line 1, Col 6: the pattern needs at least one name",
+		"f() = 1;":                  "Line 1, Col 5: cannot assign to f(); expected a variable, index or member",
+		"a?.b.c = 1;":               "Line 1, Col 8: cannot assign through ?.",
+		"out a[1 2];":               "Line 1, Col 9: expected ] or :",
+		"do out 1; while x;":        "Line 1, Col 4: expected { for do body, got OUT",
+		"do { x++; } until x;":      "Line 1, Col 13: expected while after do body, got IDENT",
+		"out a[1:2:3];":             "Line 1, Col 10: expected ]",
+		"a[1:2] = b;":               "Line 1, Col 8: cannot assign to (a[1:2]); expected a variable, index or member",
+		"match x { n if => 1 }":     "Line 1, Col 16: expected a condition after if in match case",
+		"match x { n if n > 1 2 }":  "Line 1, Col 22: expected => after pattern, got INT",
+		"class A { 1 }":             "Line 1, Col 11: expected a field or fn in class A, got INT",
+		"class { }":                 "Line 1, Col 7: expected a class name, got {",
+		"class A { x; x; }":         "Line 1, Col 14: x is declared twice in class A",
+	} {
+		p := parser.New(lexer.New(src))
+		p.ParseProgram()
+		if len(p.Errors) == 0 || p.Errors[0] != want {
+			t.Errorf("%q: errors = %q, want first %q", src, p.Errors, want)
+		}
+	}
+}
+
+func TestChunkPositions(t *testing.T) {
+	p := parser.New(lexer.NewChunks(
+		lexer.Chunk{File: "lib.xn", Source: "set a = 1;\n\nset b = 2;"},
+		lexer.Chunk{File: "main.xn", Source: "out a;\nout (b;"},
+	))
+	program := p.ParseProgram()
+	if want := "main.xn: Line 2, Col 7: expected )"; len(p.Errors) != 1 || p.Errors[0] != want {
+		t.Errorf("errors = %q, want %q", p.Errors, want)
+	}
+	for i, want := range []struct {
+		file string
+		line int
+	}{{"lib.xn", 1}, {"lib.xn", 3}, {"main.xn", 1}} {
+		file, line := program.Sources.Locate(program.Statements[i].Range().Start.Line)
+		if file != want.file || line != want.line {
+			t.Errorf("statement %d at %s:%d, want %s:%d", i, file, line, want.file, want.line)
+		}
+	}
+}
+
+func TestHeredocStrings(t *testing.T) {
+	for src, want := range map[string]string{
+		"out \"\"\"\n  a \"q\"\n    b\n  \"\"\";": "a \"q\"\n  b",
+		"out \"\"\"x\"\"\";":                      "x",
+		"out \"\"\"\r\n  crlf\r\n  \"\"\";":       "crlf",
+		"out \"\"\"\n\"\"\";":                     "",
+	} {
+		p := parser.New(lexer.New(src))
+		program := p.ParseProgram()
+		if len(p.Errors) != 0 {
+			t.Errorf("%q: unexpected errors %q", src, p.Errors)
+			continue
+		}
+		lit, ok := program.Statements[0].(*ast.OutStatement).Value.(*ast.StringLiteral)
+		if !ok || lit.Value != want {
+			t.Errorf("%q: value = %#v, want %q", src, program.Statements[0].(*ast.OutStatement).Value, want)
+		}
+	}
+
+	// Positions inside the heredoc are those of the source.
+	p := parser.New(lexer.New("out \"\"\"\n  v=${+}\n  \"\"\";"))
+	p.ParseProgram()
+	if want := "Line 2, Col 7: no prefix function for +"; len(p.Errors) == 0 || !strings.Contains(p.Errors[0], want) {
+		t.Errorf("errors = %q, want one containing %q", p.Errors, want)
+	}
+	p = parser.New(lexer.New("set a = 1;\nout \"\"\"abc\"\";\n"))
+	p.ParseProgram()
+	if want := `Line 2, Col 5: unterminated """ string literal`; len(p.Errors) != 1 || p.Errors[0] != want {
+		t.Errorf("errors = %q, want [%q]", p.Errors, want)
+	}
+}
+
+func TestMatchGuards(t *testing.T) {
+	p := parser.New(lexer.New(`set r = match n { 0 => "zero", x if x > 100 && x < 1000 => { "big"; }, _ => "other" };`))
+	program := p.ParseProgram()
+	if len(p.Errors) != 0 {
+		t.Fatalf("unexpected errors %q", p.Errors)
+	}
+	m := program.Statements[0].(*ast.SetStatement).Value.(*ast.MatchExpression)
+	var guards []string
+	for _, c := range m.Cases {
+		if c.Guard == nil {
+			guards = append(guards, "-")
+		} else {
+			guards = append(guards, c.Guard.String())
+		}
+	}
+	if got, want := strings.Join(guards, " | "), "- | ((x > 100) && (x < 1000)) | -"; got != want {
+		t.Errorf("guards = %q, want %q", got, want)
+	}
+}
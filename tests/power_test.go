@@ -0,0 +1,80 @@
+package tests
+
+import (
+	"os"
+	"runtime"
+	"testing"
+)
+
+func TestDisplays(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the fake xrandr stands in for an X server")
+	}
+	log := fakeTools(t, map[string]string{"xrandr": `#!/bin/sh
+echo "$@" >> "$FAKE_TOOL_LOG"
+[ "$1" = "--query" ] && /bin/cat <<'EOF'
+Screen 0: minimum 8 x 8, current 4480 x 1440, maximum 32767 x 32767
+eDP-1 connected primary 1920x1080+2560+0 (normal left inverted right x axis y axis) 309mm x 174mm
+   1920x1080     60.02*+
+HDMI-1 connected 2560x1440+0+0 (normal left inverted right x axis y axis) 597mm x 336mm
+   2560x1440     59.95*+
+DP-1 connected (normal left inverted right x axis y axis)
+DP-2 disconnected (normal left inverted right x axis y axis)
+EOF
+exit 0
+`})
+
+	out, err := runSource(`
+set ds = display_list();
+out len(ds);
+out ds[0];
+out ds[1]["primary"];
+out display_set_brightness(80, "HDMI-1");
+out display_set_brightness(5);
+out display_set_brightness(50, "VGA-1");
+out display_set_brightness(101);
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `2
+{"height": 1080, "name": "eDP-1", "primary": true, "width": 1920, "x": 2560, "y": 0}
+false
+true
+true
+ERROR: display_set_brightness: no display named "VGA-1"
+ERROR: display_set_brightness: percent must be between 0 and 100, got 101
+`
+	if out != want {
+		t.Errorf("output = %q, want %q", out, want)
+	}
+	calls, _ := os.ReadFile(log)
+	wantCalls := `--query
+--query
+--output HDMI-1 --brightness 0.80
+--query
+--output eDP-1 --brightness 0.05
+--output HDMI-1 --brightness 0.05
+--query
+`
+	if string(calls) != wantCalls {
+		t.Errorf("xrandr got %q, want %q", calls, wantCalls)
+	}
+}
+
+func TestBatteryStatus(t *testing.T) {
+	out, err := runSource(`
+set b = battery_status();
+out type(b["present"]);
+out type(b["percent"]);
+out type(b["charging"]);
+out type(b["plugged"]);
+out type(b["seconds_left"]);
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "BOOLEAN\nINTEGER\nBOOLEAN\nBOOLEAN\nINTEGER\n"; out != want {
+		t.Errorf("output = %q, want %q", out, want)
+	}
+}
@@ -0,0 +1,104 @@
+package tests
+
+import (
+	"testing"
+)
+
+func TestQueue(t *testing.T) {
+	t.Setenv("XON_QUEUE_DIR", t.TempDir())
+
+	// The first run leaves jobs behind, as if the script stopped.
+	out, err := runSource(`
+queue_push("jobs", {"step": 1});
+queue_push("jobs", {"step": 2}, {"max_attempts": 1});
+queue_push("jobs", "later", {"delay": 60000});
+set job = queue_pop("jobs");
+out job["payload"];
+out job["attempts"];
+out queue_stats("jobs");
+out queue_pop("jobs", {"visibility": 1})["payload"];
+out queue_push("../etc", 1);
+out queue_pop("jobs", {"visibility": 0});
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"step": 1}
+1
+{"dead": 0, "in_flight": 1, "pending": 2}
+{"step": 2}
+ERROR: queue_push: queue name must be letters, digits, '.', '-' or '_', got "../etc"
+ERROR: queue_pop: visibility must be at least 1 ms, got 0
+`
+	if out != want {
+		t.Errorf("first run = %q, want %q", out, want)
+	}
+
+	// The second run finds them: step 1 is still hidden, step 2 timed out on
+	// its only attempt and is dead-lettered on the next pop.
+	out, err = runSource(`
+sleep(5);
+out queue_pop("jobs");
+set dead = queue_dead("jobs");
+out len(dead);
+out dead[0]["error"];
+out queue_redrive("jobs");
+set job = queue_pop("jobs");
+out job["payload"];
+out queue_fail("jobs", job["receipt"], "printer offline");
+out queue_fail("jobs", "nope");
+out queue_dead("jobs")[0]["error"];
+out queue_stats("jobs");
+out queue_ack("jobs", job["receipt"]);
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = `null
+1
+visibility timeout expired
+1
+{"step": 2}
+false
+ERROR: queue_fail: receipt "nope" is not current in queue "jobs"; the job was finished or handed out again
+printer offline
+{"dead": 1, "in_flight": 1, "pending": 1}
+false
+`
+	if out != want {
+		t.Errorf("second run = %q, want %q", out, want)
+	}
+}
+
+// A worker that overran its visibility timeout holds a stale receipt: it
+// can neither fail nor acknowledge the job another worker popped since.
+func TestQueueStaleReceipt(t *testing.T) {
+	t.Setenv("XON_QUEUE_DIR", t.TempDir())
+	out, err := runSource(`
+queue_push("work", "job");
+set a = queue_pop("work", {"visibility": 50});
+sleep(80);
+set b = queue_pop("work");
+out b["attempts"];
+out a["receipt"] == b["receipt"];
+out type(queue_fail("work", a["receipt"], "late"));
+out queue_ack("work", a["receipt"]);
+out queue_pop("work");
+out queue_ack("work", b["receipt"]);
+out queue_stats("work");
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `2
+false
+ERROR
+false
+null
+true
+{"dead": 0, "in_flight": 0, "pending": 0}
+`
+	if out != want {
+		t.Errorf("output = %q, want %q", out, want)
+	}
+}
@@ -0,0 +1,80 @@
+package tests
+
+import (
+	"runtime"
+	"testing"
+)
+
+// Recording needs a desktop session, so this covers what does not: the
+// checks os_replay makes before sending anything, and the errors on
+// systems without input hooks.
+func TestRecordReplayChecks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("input hooks work on Windows; the errors below are for other systems")
+	}
+	out, err := runSource(`
+out os_replay([{"type": "key_down", "key": 65}, {"type": "press", "key": 65}]);
+out os_replay([{"type": "mouse_down", "button": "side"}]);
+out os_replay([{"type": "key_up", "key": 300}]);
+out os_replay([5]);
+out os_replay([], 0);
+out os_replay([], "fast");
+out os_replay([]);
+out os_replay([{"type": "mouse_move", "x": 1, "y": 2, "time": 0}], 2.5);
+out os_record_start();
+out os_record_stop();
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `ERROR: os_replay: event 1: unknown event type "press"
+ERROR: os_replay: event 0: button must be left, right or middle, got "side"
+ERROR: os_replay: event 0: key must be a virtual key code from 1 to 254, got 300
+ERROR: os_replay: event 0 must be HASH, got INTEGER
+ERROR: os_replay: speed must be positive, got 0
+ERROR: os_replay: speed must be a number, got STRING
+true
+ERROR: os_replay: input replay is not supported on ` + runtime.GOOS + `
+ERROR: os_record_start: keyboard and mouse hooking is not supported on ` + runtime.GOOS + `
+ERROR: os_record_stop: not recording
+`
+	if out != want {
+		t.Errorf("output = %q, want %q", out, want)
+	}
+}
+
+func TestInputHookChecks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("input hooks work on Windows; the errors below are for other systems")
+	}
+	out, err := runSource(`
+out os_on_key("expand");
+out os_on_mouse(fn(ev) { out ev; });
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "ERROR: os_on_key: handler must be a function, got STRING\n" +
+		"ERROR: os_on_mouse: keyboard and mouse hooking is not supported on " + runtime.GOOS + "\n"
+	if out != want {
+		t.Errorf("output = %q, want %q", out, want)
+	}
+}
+
+func TestPickChecks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("picking works on Windows; the errors below are for other systems")
+	}
+	out, err := runSource(`
+out os_pick_point(500);
+out os_pick_region({"timeout": 500});
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "ERROR: os_pick_point: options must be HASH, got INTEGER\n" +
+		"ERROR: os_pick_region: keyboard and mouse hooking is not supported on " + runtime.GOOS + "\n"
+	if out != want {
+		t.Errorf("output = %q, want %q", out, want)
+	}
+}
@@ -2,18 +2,26 @@ package tests
 
 import (
 	"bytes"
-	"xon/builtins"
-	"xon/compiler"
-	"xon/lexer"
-	"xon/object"
-	"xon/parser"
-	"xon/vm"
+	"fmt"
 	"io"
+	"net"
+	"net/http"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
 	"sync"
 	"testing"
+	"time"
+	"xon/ast"
+	"xon/builtins"
+	"xon/code"
+	"xon/compiler"
+	"xon/lexer"
+	"xon/object"
+	"xon/parser"
+	"xon/token"
+	"xon/vm"
 )
 
 // runSource runs Xon source (stdlib will be prepended) and returns stdout and any error.
@@ -22,9 +30,7 @@ func runSource(source string) (stdout string, runErr error) {
 	if err != nil {
 		return "", err
 	}
-	fullSource := stdContent + "\n" + source
-
-	l := lexer.New(fullSource)
+	l := lexer.NewChunks(lexer.Chunk{File: builtins.StdLibFile, Source: stdContent}, lexer.Chunk{Source: source})
 	p := parser.New(l)
 	program := p.ParseProgram()
 	if len(p.Errors) > 0 {
@@ -32,6 +38,8 @@ func runSource(source string) (stdout string, runErr error) {
 	}
 
 	comp := compiler.New()
+	builtins.ScriptDocs = comp.Docs
+	builtins.GlobalNames = comp.GlobalNames
 	if err := comp.Compile(program); err != nil {
 		return "", err
 	}
@@ -52,11 +60,13 @@ func runSource(source string) (stdout string, runErr error) {
 		for i, arg := range args {
 			subVm.SetStack(i, arg)
 		}
-		subVm.SetStackPointer(cl.Fn.NumLocals)
+		if err := subVm.SetStackPointer(cl.Fn.NumLocals); err != nil {
+			return &object.Error{Message: err.Error()}
+		}
 		if err := subVm.Run(); err != nil {
 			return &object.Error{Message: err.Error()}
 		}
-		return subVm.LastPoppedStackElem()
+		return subVm.StackTop()
 	}
 
 	// Capture stdout
@@ -112,3 +122,554 @@ func TestFeatures(t *testing.T) {
 	}
 	t.Logf("feature tests passed (%d PASS lines)", passCount)
 }
+
+func TestRequiresNewerVersion(t *testing.T) {
+	_, err := runSource(`requires "99.0"; out "ran";`)
+	if err == nil || !strings.Contains(err.Error(), "requires Xon 99.0 or newer") {
+		t.Fatalf("expected a requires error, got %v", err)
+	}
+}
+
+func TestShadowedBuiltinWarning(t *testing.T) {
+	p := parser.New(lexer.New("set len = fn(x) { return 0; };\nout len([1]);\nout len([2]);\n"))
+	program := p.ParseProgram()
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		t.Fatal(err)
+	}
+	if len(comp.Warnings) != 1 || !comp.Warnings[0].ShadowsBuiltin {
+		t.Fatalf("expected one builtin shadow warning, got %v", comp.Warnings)
+	}
+	want := "Line 1, Col 1: set len shadows the builtin len(x); called on lines 2, 3"
+	if got := comp.Warnings[0].String(); got != want {
+		t.Errorf("warning = %q, want %q", got, want)
+	}
+}
+
+func TestStrictShadowingFails(t *testing.T) {
+	xon := buildXon(t)
+	script := filepath.Join(t.TempDir(), "shadow.xn")
+	if err := os.WriteFile(script, []byte("set len = 5;\nout len;\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := exec.Command(xon, script).CombinedOutput()
+	want := "Warning: " + script + ": Line 1, Col 1: set len shadows the builtin len(x)\n5\n"
+	if err != nil || string(out) != want {
+		t.Errorf("without --strict: %v\n%s", err, out)
+	}
+
+	out, err = exec.Command(xon, "--strict", script).CombinedOutput()
+	want = "Compiler error: " + script + ": Line 1, Col 1: set len shadows the builtin len(x)\n"
+	if exit, ok := err.(*exec.ExitError); !ok || exit.ExitCode() != 1 {
+		t.Errorf("--strict exited with %v, want status 1", err)
+	}
+	if string(out) != want {
+		t.Errorf("--strict output = %q, want %q", out, want)
+	}
+}
+
+func TestTaskIDPrefix(t *testing.T) {
+	builtins.TaskIDs = true
+	defer func() { builtins.TaskIDs = false }()
+	out, err := runSource(`out "main"; group { spawn fn() { out "one"; }(); }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 2 || lines[0] != "[task 0] main" || !strings.HasPrefix(lines[1], "[task ") || !strings.HasSuffix(lines[1], "] one") {
+		t.Errorf("output = %q", out)
+	}
+}
+
+func TestGroupCancelStopsBlockedSiblings(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	})}
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	start := time.Now()
+	out, err := runSource(fmt.Sprintf(`group {
+  spawn fn() { sleep(5000); out "slept"; }();
+  spawn fn() { http_get("http://%s/"); out "fetched"; }();
+  spawn fn() { sleep(50); out "failing"; throw "boom"; }();
+}`, ln.Addr()))
+	if err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Errorf("group error = %v", err)
+	}
+	if out != "failing\n" {
+		t.Errorf("canceled tasks kept running: output = %q", out)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("group waited %v for canceled tasks", elapsed)
+	}
+}
+
+func TestBuiltinPanicIsCatchable(t *testing.T) {
+	comp := compiler.New()
+	boom := comp.BindGlobal("boom")
+	p := parser.New(lexer.New(`out try { boom(); } catch (e) { e.message + " " + e.code };`))
+	if err := comp.Compile(p.ParseProgram()); err != nil {
+		t.Fatal(err)
+	}
+	globals := make([]object.Object, vm.GlobalsSize)
+	globals[boom] = &object.Builtin{Fn: func(args ...object.Object) object.Object {
+		var m map[string]int
+		m["x"] = 1
+		return nil
+	}}
+
+	old := os.Stdout
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout = w
+	runErr := vm.NewWithGlobalsState(comp.Bytecode(), globals, &sync.RWMutex{}).Run()
+	w.Close()
+	os.Stdout = old
+	out, _ := io.ReadAll(r)
+
+	if runErr != nil {
+		t.Fatalf("panic was not caught: %v", runErr)
+	}
+	want := "panic in builtin function: assignment to entry in nil map E_RUNTIME\n"
+	if string(out) != want {
+		t.Errorf("output = %q, want %q", out, want)
+	}
+}
+
+func TestBadSyntaxIsReported(t *testing.T) {
+	for _, src := range []string{
+		`fn(`,
+		`set f = fn(1) {};`,
+		`out [1, 2`,
+		`out f(1, 2`,
+		`out a[1;`,
+		`out "${+}";`,
+		`out {"a" 1};`,
+		`out try { 1 } finally { 2 };`,
+	} {
+		p := parser.New(lexer.New(src))
+		p.ParseProgram()
+		if len(p.Errors) == 0 {
+			t.Errorf("%q: expected a syntax error", src)
+		}
+	}
+}
+
+func TestUnterminatedStringLiteral(t *testing.T) {
+	p := parser.New(lexer.New("set a = 1;\nout \"abc;\nset b = 2;\n"))
+	p.ParseProgram()
+	want := "Line 2, Col 5: unterminated string literal"
+	if len(p.Errors) != 1 || p.Errors[0] != want {
+		t.Errorf("errors = %q, want [%q]", p.Errors, want)
+	}
+}
+
+func TestReservedKeywordAsName(t *testing.T) {
+	for src, want := range map[string]string{
+		"set for = 1;":         "Line 1, Col 5: for is a reserved keyword",
+		"out in;":              "Line 1, Col 5: in is a reserved keyword",
+		"match = 2;":           "Line 1, Col 1: match is a reserved keyword",
+		"set f = fn(in) {};":   "Line 1, Col 12: in is a reserved keyword",
+		"for match in [1] { }": "Line 1, Col 5: match is a reserved keyword",
+	} {
+		p := parser.New(lexer.New(src))
+		p.ParseProgram()
+		if len(p.Errors) == 0 || !strings.HasPrefix(p.Errors[0], want) {
+			t.Errorf("%q: errors = %q, want first to start with %q", src, p.Errors, want)
+		}
+	}
+	p := parser.New(lexer.New(`set h = {"for": 1}; out h.for;`))
+	p.ParseProgram()
+	if len(p.Errors) != 0 {
+		t.Errorf("keyword after '.': unexpected errors %q", p.Errors)
+	}
+}
+
+func TestUnicodeIdentifiers(t *testing.T) {
+	l := lexer.New("größe 名前1 नाम _x€")
+	for _, want := range []token.Token{
+		{Type: token.IDENT, Literal: "größe", Col: 1},
+		{Type: token.IDENT, Literal: "名前1", Col: 7},
+		{Type: token.IDENT, Literal: "नाम", Col: 11},
+		{Type: token.IDENT, Literal: "_x", Col: 15},
+		{Type: token.ILLEGAL, Literal: "€", Col: 17},
+	} {
+		got := l.NextToken()
+		if got.Type != want.Type || got.Literal != want.Literal || got.Col != want.Col {
+			t.Errorf("got %s %q at col %d, want %s %q at col %d", got.Type, got.Literal, got.Col, want.Type, want.Literal, want.Col)
+		}
+	}
+}
+
+func TestCommentTokens(t *testing.T) {
+	src := "// head\n/// Adds.\nset add = fn(a, b) { /* inner */ return a + b; }; // tail\nout add(1, 2);\n"
+	program := parser.New(lexer.NewWithComments(src)).ParseProgram()
+	var got []string
+	for _, c := range program.Comments {
+		got = append(got, c.Literal)
+	}
+	want := []string{"// head", "/// Adds.", "/* inner */", "// tail"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("comments = %q, want %q", got, want)
+	}
+	plain := parser.New(lexer.New(src)).ParseProgram()
+	if program.String() != plain.String() || len(plain.Comments) != 0 {
+		t.Errorf("comments changed the parse: %q vs %q", program.String(), plain.String())
+	}
+	if set := program.Statements[0].(*ast.SetStatement); set.Doc != "Adds." {
+		t.Errorf("doc = %q, want %q", set.Doc, "Adds.")
+	}
+}
+
+// spanCollector records "Type start-end" for every node ast.Walk reaches.
+type spanCollector struct{ spans []string }
+
+func (c *spanCollector) Visit(node ast.Node) ast.Visitor {
+	if node != nil {
+		r := node.Range()
+		c.spans = append(c.spans, fmt.Sprintf("%T %d:%d-%d:%d", node, r.Start.Line, r.Start.Col, r.End.Line, r.End.Col))
+	}
+	return c
+}
+
+func TestNodeSpans(t *testing.T) {
+	src := "set total = add(1, x) * 2;\nout \"n=${total}!\";\n"
+	program := parser.New(lexer.New(src)).ParseProgram()
+	c := &spanCollector{}
+	ast.Walk(c, program)
+	want := []string{
+		"*ast.Program 1:1-2:19",
+		"*ast.SetStatement 1:1-1:27",
+		"*ast.Identifier 1:5-1:10",
+		"*ast.InfixExpression 1:13-1:26",
+		"*ast.CallExpression 1:13-1:22",
+		"*ast.Identifier 1:13-1:16",
+		"*ast.IntegerLiteral 1:17-1:18",
+		"*ast.Identifier 1:20-1:21",
+		"*ast.IntegerLiteral 1:25-1:26",
+		"*ast.OutStatement 2:1-2:19",
+		"*ast.InterpolatedString 2:5-2:18",
+		"*ast.StringLiteral 2:6-2:8",
+		"*ast.Identifier 2:10-2:15",
+		"*ast.StringLiteral 2:16-2:17",
+	}
+	if strings.Join(c.spans, "\n") != strings.Join(want, "\n") {
+		t.Errorf("spans:\n%s\nwant:\n%s", strings.Join(c.spans, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestInspectAndRewrite(t *testing.T) {
+	program := parser.New(lexer.New("set f = fn(n) { return n * (2 + 3); };\nout f(1 + 1);\nout \"${x}\";\n")).ParseProgram()
+
+	var names []string
+	ast.Inspect(program, func(node ast.Node) bool {
+		if id, ok := node.(*ast.Identifier); ok {
+			names = append(names, id.Value)
+		}
+		_, isFn := node.(*ast.FunctionLiteral)
+		return !isFn
+	})
+	if got := strings.Join(names, " "); got != "f f x" {
+		t.Errorf("identifiers outside functions = %q, want %q", got, "f f x")
+	}
+
+	// Fold additions of integer literals and drop out statements of strings.
+	ast.Rewrite(program, func(node ast.Node) ast.Node {
+		switch n := node.(type) {
+		case *ast.InfixExpression:
+			l, lok := n.Left.(*ast.IntegerLiteral)
+			r, rok := n.Right.(*ast.IntegerLiteral)
+			if lok && rok && n.Operator == "+" {
+				sum := l.Value + r.Value
+				folded := &ast.IntegerLiteral{Token: token.Token{Type: token.INT, Literal: fmt.Sprint(sum)}, Value: sum}
+				folded.SetRange(n.Range())
+				return folded
+			}
+		case *ast.OutStatement:
+			if _, ok := n.Value.(*ast.InterpolatedString); ok {
+				return nil
+			}
+		}
+		return node
+	})
+	want := "set f = fn(n) return (n * 5);;out f(2);"
+	if program.String() != want {
+		t.Errorf("rewritten program = %q, want %q", program.String(), want)
+	}
+}
+
+func TestDecodeJumpTargets(t *testing.T) {
+	ins := append(code.Make(code.OpTrue), code.Make(code.OpJumpNotTruthy, 7)...)
+	ins = append(ins, code.Make(code.OpConstant, 0)...)
+	ins = append(ins, code.Make(code.OpNull)...)
+	got := code.Decode(ins)
+	want := []code.Instr{{Op: code.OpTrue}, {Op: code.OpJumpNotTruthy, A: 3}, {Op: code.OpConstant}, {Op: code.OpNull}}
+	if len(got) != len(want) {
+		t.Fatalf("decoded %d instructions, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("instruction %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestIntegerOperators(t *testing.T) {
+	out, err := runSource("out 17 % 5; out 12 & 6; out 12 | 6; out 12 ^ 6; out 12 << 2; out 12 >> 2;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "2\n4\n14\n10\n48\n3\n"; out != want {
+		t.Errorf("output = %q, want %q", out, want)
+	}
+}
+
+func TestFuseSuperinstructions(t *testing.T) {
+	ins := code.Make(code.OpGetLocal, 0)
+	ins = append(ins, code.Make(code.OpConstant, 1)...)
+	ins = append(ins, code.Make(code.OpAdd)...)
+	ins = append(ins, code.Make(code.OpSetLocal, 0)...)
+	ins = append(ins, code.Make(code.OpConstant, 2)...)
+	ins = append(ins, code.Make(code.OpEqual)...)
+	ins = append(ins, code.Make(code.OpJumpNotTruthy, 0)...)
+	got := code.Fuse(code.Decode(ins))
+	if got[0] != (code.Instr{Op: code.OpAddConstLocal, A: 0, B: 1, C: 3}) {
+		t.Errorf("instruction 0 = %+v, want OpAddConstLocal", got[0])
+	}
+	if got[4] != (code.Instr{Op: code.OpCompareConstJump, A: 2, B: int(code.OpEqual), C: 0}) {
+		t.Errorf("instruction 4 = %+v, want OpCompareConstJump", got[4])
+	}
+	if got[1].Op != code.OpConstant || got[5].Op != code.OpEqual {
+		t.Errorf("fused sequences were not left in place: %+v", got)
+	}
+
+	// The same loops over integers take the fused paths, and over floats
+	// and strings fall back to the original instructions.
+	out, err := runSource(`
+set f = fn(n) {
+	set total = 0;
+	set xs = [1, 2, 3];
+	for (set i = 0; i < n; i++) { total = total + 2; if (i == 1) { out xs[i]; } }
+	return total;
+};
+out f(3);
+set g = 0.5;
+for (set j = 0; j < 2; j++) { g = g + 1; }
+out g;
+set s = "a";
+if (s == "a") { out "str"; }
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "2\n6\n2.5\nstr\n"; out != want {
+		t.Errorf("output = %q, want %q", out, want)
+	}
+}
+
+func TestUnboxedLocals(t *testing.T) {
+	// Sums kept unboxed must read back the same wherever the local goes:
+	// into output, arrays, closures, returns and mixed int/float math.
+	out, err := runSource(`
+set f = fn(n) {
+	set i = 0;
+	set total = 0;
+	set x = 1;
+	set seen = [];
+	while (i != n) {
+		total = total + i;
+		x = x + 0.5;
+		i++;
+		seen.push(i);
+	}
+	set get = fn() { return total; };
+	out "${i} ${x} ${seen}";
+	return [get(), total + 1, x == 3.0];
+};
+out f(4);
+out f(0);
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "4 3 [1, 2, 3, 4]\n[6, 7, true]\n0 1 []\n[0, 1, false]\n"; out != want {
+		t.Errorf("output = %q, want %q", out, want)
+	}
+}
+
+// serveScript runs script, a format string taking a free port number,
+// and returns a function making GET requests to that port. The script is
+// expected to start a server on the port.
+func serveScript(t *testing.T, script string) func(path string) (int, string, http.Header) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+	if _, err := runSource(fmt.Sprintf(script, port)); err != nil {
+		t.Fatal(err)
+	}
+	return func(path string) (int, string, http.Header) {
+		t.Helper()
+		var resp *http.Response
+		var err error
+		for i := 0; ; i++ {
+			resp, err = http.Get(fmt.Sprintf("http://127.0.0.1:%d%s", port, path))
+			if err == nil || i == 50 {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, strings.TrimSpace(string(body)), resp.Header
+	}
+}
+
+func TestHTTPHandlerResponses(t *testing.T) {
+	get := serveScript(t, `
+http_serve(%d, fn(req) {
+	if (req["path"] == "/missing") { return {"status": 404, "body": "no " + req["query"], "headers": {"X-Kind": "demo"}}; }
+	if (req["path"] == "/boom") { throw "database password leaked"; }
+	if (req["path"] == "/data") { return {"name": "xon"}; }
+	return "hello";
+});
+`)
+
+	if status, body, _ := get("/"); status != 200 || body != "hello" {
+		t.Errorf("/: %d %q, want 200 \"hello\"", status, body)
+	}
+	if status, body, header := get("/missing?id=7"); status != 404 || body != "no id=7" || header.Get("X-Kind") != "demo" {
+		t.Errorf("/missing: %d %q %v, want 404 \"no id=7\" with X-Kind", status, body, header)
+	}
+	if status, body, _ := get("/data"); status != 200 || body != `{"name": "xon"}` {
+		t.Errorf("/data: %d %q, want the inspected hash", status, body)
+	}
+	if status, body, _ := get("/boom"); status != 500 || body != "Internal Server Error" {
+		t.Errorf("/boom: %d %q, want 500 without the message", status, body)
+	}
+	builtins.HTTPDebug = true
+	defer func() { builtins.HTTPDebug = false }()
+	if status, body, _ := get("/boom"); status != 500 || !strings.Contains(body, "database password leaked") {
+		t.Errorf("/boom in debug mode: %d %q, want 500 with the message", status, body)
+	}
+}
+
+func TestHTTPMiddleware(t *testing.T) {
+	get := serveScript(t, `
+http_serve(%d, fn(req) {
+	if (req["path"] == "/gone") { return {"status": 410}; }
+	return "ok";
+}, {"access_log": true, "metrics": true});
+`)
+	// The access log goes through the log builtins to stdout.
+	old := os.Stdout
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout = w
+	get("/")
+	get("/")
+	get("/gone")
+	os.Stdout = old
+	w.Close()
+	logged, _ := io.ReadAll(r)
+
+	lines := strings.Split(strings.TrimSpace(string(logged)), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("logged %q, want 3 lines", logged)
+	}
+	for _, want := range []string{"INFO http request", "method=GET", "path=/gone", "status=410", "latency_ms="} {
+		if !strings.Contains(lines[2], want) {
+			t.Errorf("log line %q does not contain %q", lines[2], want)
+		}
+	}
+
+	status, body, _ := get("/metrics")
+	if status != 200 {
+		t.Fatalf("/metrics status %d", status)
+	}
+	for _, want := range []string{
+		`http_requests_total{method="GET",status="200"} 2`,
+		`http_requests_total{method="GET",status="410"} 1`,
+		`http_request_duration_seconds_bucket{le="+Inf"} 3`,
+		`http_request_duration_seconds_count 3`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics do not contain %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	out, err := runSource(fmt.Sprintf(`
+metrics_statsd("%s", {"prefix": "app."});
+set sent = metric_counter("test_sent_total", "Messages sent.");
+sent.inc(2);
+set depth = metric_gauge("test_depth");
+depth.set(4);
+depth.dec();
+set took = metric_histogram("test_took_seconds", {"buckets": [0.5, 1]});
+took.observe(0.75);
+metrics_statsd(null);
+sent.inc();
+out metrics_text();
+out metric_gauge("test_took_seconds");
+`, conn.LocalAddr()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var packets []string
+	buf := make([]byte, 512)
+	for len(packets) < 4 {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("after %q: %v", packets, err)
+		}
+		packets = append(packets, string(buf[:n]))
+	}
+	want := []string{"app.test_sent_total:2|c", "app.test_depth:4|g", "app.test_depth:-1|g", "app.test_took_seconds:0.75|h"}
+	if strings.Join(packets, " ") != strings.Join(want, " ") {
+		t.Errorf("statsd got %q, want %q", packets, want)
+	}
+
+	for _, line := range []string{
+		"# HELP test_sent_total Messages sent.",
+		"# TYPE test_sent_total counter",
+		"test_sent_total 3",
+		"test_depth 3",
+		`test_took_seconds_bucket{le="0.5"} 0`,
+		`test_took_seconds_bucket{le="1"} 1`,
+		`test_took_seconds_bucket{le="+Inf"} 1`,
+		"test_took_seconds_sum 0.75",
+		"metric_gauge: test_took_seconds is already a histogram",
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("output does not contain %q:\n%s", line, out)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestScheduleCrontab(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Task Scheduler is used on Windows")
+	}
+	// The fake crontab keeps the installed table in the log.
+	tab := fakeTools(t, map[string]string{"crontab": `#!/bin/sh
+case "$1" in
+-l) [ -f "$FAKE_TOOL_LOG" ] && exec /bin/cat "$FAKE_TOOL_LOG"
+    echo "no crontab for tester" >&2; exit 1 ;;
+-) /bin/cat > "$FAKE_TOOL_LOG" ;;
+esac
+`})
+	job := filepath.Join(filepath.Dir(tab), "job.xn")
+	if err := os.WriteFile(job, []byte(`out "tick";`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := runSource(`
+set job = "` + job + `";
+out os_schedule_install("backup", "30 2 * * *", job);
+out os_schedule_install("sync", "*/15 9-17 * * 1-5", job);
+out os_schedule_install("backup", "@hourly", job);
+out os_schedule_install("report", "0 8 * * 1,3", job);
+out os_schedule_remove("report");
+out os_schedule_remove("report");
+out os_schedule_install("nightly backup", "@daily", job);
+out os_schedule_install("x", "60 * * * *", job);
+out os_schedule_install("x", "* * *", job);
+out os_schedule_install("x", "*/0 * * * *", job);
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `true
+true
+true
+true
+true
+ERROR: os_schedule_remove: no scheduled task named "report"
+ERROR: os_schedule_install: name "nightly backup" may only contain letters, digits, - and _
+ERROR: os_schedule_install: cron minute field "60" must be within 0-59
+ERROR: os_schedule_install: cron expression "* * *" needs 5 fields (minute hour day month weekday), got 3
+ERROR: os_schedule_install: bad step "0" in cron minute field "*/0"
+`
+	if out != want {
+		t.Errorf("output = %q, want %q", out, want)
+	}
+	got, _ := os.ReadFile(tab)
+	command := "'" + exe + "' '" + job + "'"
+	wantTab := "*/15 9-17 * * 1-5 " + command + " # xon:sync\n" +
+		"0 * * * * " + command + " # xon:backup\n"
+	if string(got) != wantTab {
+		t.Errorf("crontab = %q, want %q", got, wantTab)
+	}
+}
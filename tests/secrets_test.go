@@ -0,0 +1,55 @@
+package tests
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestSecretTool(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the fake secret-tool stands in for libsecret")
+	}
+	// The fake secret-tool keeps one file per secret beside the log.
+	fakeTools(t, map[string]string{"secret-tool": `#!/bin/sh
+store=$FAKE_TOOL_LOG.secrets
+/bin/mkdir -p "$store"
+case "$1" in
+store) [ "$2" = "--label=Xon secret $6" ] || { echo "bad label $2" >&2; exit 2; }
+       /bin/cat > "$store/$6" ;;
+lookup) [ -f "$store/$5" ] || exit 1
+        exec /bin/cat "$store/$5" ;;
+clear) [ -f "$store/$5" ] || exit 1
+       exec /bin/rm "$store/$5" ;;
+esac
+`})
+
+	out, err := runSource(`
+out secret_get("api_token");
+out secret_set("api_token", "s3cret value");
+out secret_get("api_token");
+out secret_set("api_token", "");
+out secret_get("api_token");
+out secret_set("api_token", null);
+out secret_get("api_token") ?? "gone";
+out secret_set("api_token", null);
+out secret_get("../passwd");
+out secret_set("api_token", 42);
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `null
+true
+s3cret value
+true
+
+true
+gone
+true
+ERROR: secret_get: name "../passwd" may only contain letters, digits, - and _
+ERROR: secret_set: value must be STRING or null, got INTEGER
+`
+	if out != want {
+		t.Errorf("output = %q, want %q", out, want)
+	}
+}
@@ -0,0 +1,90 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"xon/builtins"
+)
+
+func TestServiceSystemd(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the fake systemctl stands in for systemd")
+	}
+	calls := fakeTools(t, map[string]string{"systemctl": "#!/bin/sh\necho \"$@\" >> \"$FAKE_TOOL_LOG\"\n"})
+	dir := filepath.Dir(calls)
+	web := filepath.Join(dir, "web 100%.xn")
+	if err := os.WriteFile(web, []byte(`http_serve(8080, fn(req) { return "ok"; });`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(dir, "config"))
+
+	name, err := builtins.InstallService(web, builtins.ServiceOptions{Name: "web", User: true})
+	if err != nil || name != "web" {
+		t.Fatalf("InstallService = %q, %v", name, err)
+	}
+	unitPath := filepath.Join(dir, "config", "systemd", "user", "xon-web.service")
+	unit, err := os.ReadFile(unitPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	escaped := filepath.Join(dir, "web 100%%.xn")
+	want := `[Unit]
+Description=Xon script ` + escaped + `
+After=network.target
+
+[Service]
+ExecStart="` + exe + `" "` + escaped + `"
+WorkingDirectory=` + dir + `
+Restart=always
+RestartSec=5
+StandardOutput=append:` + filepath.Join(dir, "web.log") + `
+StandardError=append:` + filepath.Join(dir, "web.log") + `
+
+[Install]
+WantedBy=default.target
+`
+	if string(unit) != want {
+		t.Errorf("unit file =\n%s\nwant\n%s", unit, want)
+	}
+
+	for _, step := range []func(string) error{builtins.StartService, builtins.StopService, builtins.RemoveService} {
+		if err := step("web"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := os.Stat(unitPath); !os.IsNotExist(err) {
+		t.Errorf("unit file still there after RemoveService: %v", err)
+	}
+	got, _ := os.ReadFile(calls)
+	wantCalls := `--user daemon-reload
+--user enable xon-web
+--user start xon-web
+--user stop xon-web
+--user disable --now xon-web
+--user daemon-reload
+`
+	if string(got) != wantCalls {
+		t.Errorf("systemctl got %q, want %q", got, wantCalls)
+	}
+
+	for _, tc := range []struct {
+		err  error
+		want string
+	}{
+		{builtins.StartService("web"), `no service named "web" is installed`},
+		{builtins.StopService("../web"), `name "../web" may only contain letters, digits, - and _`},
+	} {
+		if tc.err == nil || tc.err.Error() != tc.want {
+			t.Errorf("error = %v, want %q", tc.err, tc.want)
+		}
+	}
+	if _, err := builtins.InstallService(filepath.Join(dir, "missing.xn"), builtins.ServiceOptions{User: true}); !os.IsNotExist(err) {
+		t.Errorf("InstallService of a missing script = %v, want a not-exist error", err)
+	}
+}
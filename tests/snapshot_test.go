@@ -0,0 +1,54 @@
+package tests
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"xon/builtins"
+)
+
+func TestExpectSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	defer func(old string) { builtins.SnapshotDir = old }(builtins.SnapshotDir)
+	builtins.SnapshotDir = dir
+
+	out, err := runSource(`out expect_snapshot("report", {"rows": [1, 2]});
+out expect_snapshot("report", {"rows": [1, 2]});`)
+	if err != nil || out != "true\ntrue\n" {
+		t.Fatalf("first runs = %q, %v", out, err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "report.snap")); string(data) != "{\"rows\": [1, 2]}\n" {
+		t.Errorf("snapshot file = %q", data)
+	}
+
+	// A mismatch stops the script even when the result is not used.
+	out, err = runSource(`expect_snapshot("report", {"rows": [1, 3]});
+out "unreachable";`)
+	if err == nil || out != "" {
+		t.Fatalf("mismatch = %q, %v; want an error", out, err)
+	}
+	if want := `snapshot report differs at line 1: want "{\"rows\": [1, 2]}", got "{\"rows\": [1, 3]}"`; !strings.Contains(err.Error(), want) {
+		t.Errorf("error = %v, want it to contain %s", err, want)
+	}
+}
+
+func TestExpectSnapshotExitStatus(t *testing.T) {
+	xon := buildXon(t)
+	dir := t.TempDir()
+	script := filepath.Join(dir, "check.xn")
+	os.WriteFile(script, []byte(`expect_snapshot("value", 1);`), 0644)
+	if out, err := exec.Command(xon, script).CombinedOutput(); err != nil {
+		t.Fatalf("first run: %v\n%s", err, out)
+	}
+
+	os.WriteFile(script, []byte(`expect_snapshot("value", 2);`), 0644)
+	out, err := exec.Command(xon, script).CombinedOutput()
+	if exit, ok := err.(*exec.ExitError); !ok || exit.ExitCode() == 0 {
+		t.Fatalf("mismatched run: err = %v, want a non-zero exit\n%s", err, out)
+	}
+	if !strings.Contains(string(out), "snapshot value differs at line 1") {
+		t.Errorf("output = %q", out)
+	}
+}
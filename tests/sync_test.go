@@ -0,0 +1,99 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestFsSync(t *testing.T) {
+	dir := t.TempDir()
+	src, dst := filepath.Join(dir, "src"), filepath.Join(dir, "dst")
+	write := func(path, data string, mtime time.Time) {
+		t.Helper()
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(path, mtime, mtime)
+	}
+	read := func(path string) string {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "<" + err.Error() + ">"
+		}
+		return string(data)
+	}
+	sync := func(opts string) string {
+		t.Helper()
+		script := "out fs_sync(" + strconv.Quote(src) + ", " + strconv.Quote(dst)
+		if opts != "" {
+			script += ", " + opts
+		}
+		out, err := runSource(script + ");")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+	check := func(step, got, want string) {
+		t.Helper()
+		if got != want {
+			t.Errorf("%s: got %q, want %q", step, got, want)
+		}
+	}
+
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	write(filepath.Join(src, "a.txt"), "one", old)
+	write(filepath.Join(src, "sub", "b.txt"), "two", old)
+	write(filepath.Join(src, "debug.log"), "noise", old)
+	write(filepath.Join(src, "cache", "c.bin"), "x", old)
+	const exclude = `{"exclude": ["*.log", "cache"]}`
+
+	// New files are copied with their modification time; excluded ones are not.
+	check("first sync", sync(exclude), "{\"copied\": [\"a.txt\", \"sub/b.txt\"], \"deleted\": [], \"unchanged\": 0}\n")
+	check("copied file", read(filepath.Join(dst, "sub", "b.txt")), "two")
+	if info, err := os.Stat(filepath.Join(dst, "a.txt")); err != nil || !info.ModTime().Equal(old) {
+		t.Errorf("a.txt mtime = %v, %v; want %v", info.ModTime(), err, old)
+	}
+	for _, skipped := range []string{"debug.log", "cache"} {
+		if _, err := os.Stat(filepath.Join(dst, skipped)); !os.IsNotExist(err) {
+			t.Errorf("excluded %s was copied", skipped)
+		}
+	}
+	check("second sync", sync(exclude), "{\"copied\": [], \"deleted\": [], \"unchanged\": 2}\n")
+
+	// Same size and time: only comparing contents notices the change.
+	write(filepath.Join(src, "a.txt"), "ONE", old)
+	check("time compare", sync(`{"compare": "time"}`), "{\"copied\": [\"cache/c.bin\", \"debug.log\"], \"deleted\": [], \"unchanged\": 2}\n")
+	check("time compare kept", read(filepath.Join(dst, "a.txt")), "one")
+	check("hash compare", sync(`{"compare": "hash"}`), "{\"copied\": [\"a.txt\"], \"deleted\": [], \"unchanged\": 3}\n")
+	check("hash compare copied", read(filepath.Join(dst, "a.txt")), "ONE")
+
+	// A newer source is copied; dry_run only reports, deletions included.
+	write(filepath.Join(src, "sub", "b.txt"), "two, edited", time.Now())
+	write(filepath.Join(dst, "extra.txt"), "stale", old)
+	write(filepath.Join(dst, "gone", "d.txt"), "stale", old)
+	write(filepath.Join(dst, "keep.log"), "mine", old)
+	const deleting = `{"delete": true, "exclude": ["*.log", "cache"]`
+	check("dry run", sync(deleting+`, "dry_run": true}`), "{\"copied\": [\"sub/b.txt\"], \"deleted\": [\"extra.txt\", \"gone\"], \"unchanged\": 1}\n")
+	check("dry run left copy", read(filepath.Join(dst, "sub", "b.txt")), "two")
+	check("dry run left extra", read(filepath.Join(dst, "extra.txt")), "stale")
+
+	check("delete", sync(deleting+"}"), "{\"copied\": [\"sub/b.txt\"], \"deleted\": [\"extra.txt\", \"gone\"], \"unchanged\": 1}\n")
+	check("delete copied", read(filepath.Join(dst, "sub", "b.txt")), "two, edited")
+	for _, removed := range []string{"extra.txt", "gone"} {
+		if _, err := os.Stat(filepath.Join(dst, removed)); !os.IsNotExist(err) {
+			t.Errorf("%s was not deleted", removed)
+		}
+	}
+	check("excluded file kept", read(filepath.Join(dst, "keep.log")), "mine")
+
+	out, err := runSource(`out fs_sync("a", "b", {"compare": "size"});`)
+	check("bad compare", out, "ERROR: fs_sync compare must be \"time\" or \"hash\", got \"size\"\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,50 @@
+package tests
+
+import (
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestTTSSpeak(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the fake speech engine stands in for espeak-ng")
+	}
+	log := fakeTools(t, map[string]string{
+		"espeak-ng": "#!/bin/sh\necho \"$@\" >> \"$FAKE_TOOL_LOG\"\n/bin/cat >> \"$FAKE_TOOL_LOG\"\necho >> \"$FAKE_TOOL_LOG\"\n",
+	})
+
+	out, err := runSource(`
+out tts_speak("Backup finished");
+out tts_speak("""it's "quoted"; rm -rf""", {"voice": "en-us", "rate": 1.5});
+out tts_speak("x", {"rate": 50});
+out tts_speak("x", {"rate": "fast"});
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "true\ntrue\nERROR: tts_speak: rate must be between 0.1 and 10, got 50\nERROR: tts_speak: rate must be a number, got STRING\n"
+	if out != want {
+		t.Errorf("output = %q, want %q", out, want)
+	}
+	spoken, _ := os.ReadFile(log)
+	wantSpoken := "-s 175 --stdin\nBackup finished\n-s 263 --stdin -v en-us\nit's \"quoted\"; rm -rf\n"
+	if string(spoken) != wantSpoken {
+		t.Errorf("engine got %q, want %q", spoken, wantSpoken)
+	}
+}
+
+func TestTTSSpeakWithoutEngine(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("other systems always have a speech engine")
+	}
+	t.Setenv("PATH", t.TempDir())
+	out, err := runSource(`out tts_speak("hello");`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "ERROR: tts_speak: no speech engine found") {
+		t.Errorf("output = %q, want a missing engine error", out)
+	}
+}
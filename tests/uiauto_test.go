@@ -0,0 +1,54 @@
+package tests
+
+import (
+	"os"
+	"runtime"
+	"testing"
+)
+
+func TestUIAutomation(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the fake python3 stands in for the AT-SPI helper")
+	}
+	log := fakeTools(t, map[string]string{"python3": `#!/bin/sh
+req=$(/bin/cat)
+echo "$req" >> "$FAKE_TOOL_LOG"
+case "$req" in
+*'"action":"find"'*) echo '{"elements": [{"name": "Seven", "role": "button", "id": "num7Button", "x": 10, "y": 20, "width": 40, "height": 30, "enabled": true}]}' ;;
+*'"action":"text"'*) echo '{"text": "Display is 7"}' ;;
+*'"name":"Missing"'*) echo '{"error": "no control matches the criteria"}' ;;
+*) echo '{}' ;;
+esac
+`})
+
+	out, err := runSource(`
+set found = os_ui_find({"window": "Calculator", "role": "Button", "name": "Seven"});
+out len(found);
+out found[0]["id"];
+out found[0]["width"] + found[0]["height"];
+out os_ui_invoke({"id": "num7Button"});
+out os_ui_get_text({"id": "CalculatorResults", "index": 1});
+out os_ui_invoke({"name": "Missing"});
+out os_ui_find({"limit": 3});
+out os_ui_find("Seven");
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "1\nnum7Button\n70\ntrue\nDisplay is 7\n" +
+		"ERROR: os_ui_invoke: no control matches the criteria\n" +
+		"ERROR: os_ui_find: criteria needs at least one of name, contains, role, id or window\n" +
+		"ERROR: argument to `os_ui_find` must be a criteria HASH, got STRING\n"
+	if out != want {
+		t.Errorf("output = %q, want %q", out, want)
+	}
+	requests, _ := os.ReadFile(log)
+	wantRequests := `{"action":"find","name":"Seven","role":"button","window":"Calculator","index":0,"limit":100}
+{"action":"invoke","id":"num7Button","index":0,"limit":100}
+{"action":"text","id":"CalculatorResults","index":1,"limit":100}
+{"action":"invoke","name":"Missing","index":0,"limit":100}
+`
+	if string(requests) != wantRequests {
+		t.Errorf("helper got %q, want %q", requests, wantRequests)
+	}
+}
@@ -0,0 +1,30 @@
+package tests
+
+import (
+	"testing"
+	"xon/ast"
+	"xon/builtins"
+	"xon/lexer"
+	"xon/parser"
+)
+
+// TestVersionTracksBuiltins pins Version to the builtins and standard
+// library globals it provides. Adding either means bumping the minor part
+// of builtins.Version and then updating the numbers here.
+func TestVersionTracksBuiltins(t *testing.T) {
+	std, err := builtins.LoadStdLib()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdGlobals := 0
+	for _, stmt := range parser.New(lexer.New(std)).ParseProgram().Statements {
+		if _, ok := stmt.(*ast.SetStatement); ok {
+			stdGlobals++
+		}
+	}
+	const version, registry, globals = "1.29", 200, 28
+	if builtins.Version != version || len(builtins.Registry) != registry || stdGlobals != globals {
+		t.Errorf("Xon %s has %d builtins and %d std globals; pinned: %s with %d and %d",
+			builtins.Version, len(builtins.Registry), stdGlobals, version, registry, globals)
+	}
+}
@@ -0,0 +1,95 @@
+package tests
+
+import (
+	"archive/zip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestXLSXRoundTrip(t *testing.T) {
+	book := filepath.Join(t.TempDir(), "report.xlsx")
+	out, err := runSource(fmt.Sprintf(`
+set rows = [
+  {"name": "Ada", "qty": 3, "price": 2.5, "paid": true},
+  {"name": "Bob & Co <ltd>", "qty": 10, "tags": ["a", "b"]}
+];
+out xlsx_write("%[1]s", rows, {"columns": ["name", "qty", "price", "paid", "tags"]});
+out xlsx_read("%[1]s");
+out xlsx_read("%[1]s", {"sheet": "Sheet1", "header": false})[0];
+out xlsx_write("%[1]s", {"Costs": [[1, 2], [3]], "Sales": rows});
+out xlsx_read("%[1]s", {"sheet": "Costs"});
+out xlsx_read("%[1]s", {"sheet": "Missing"});
+out xlsx_write("%[1]s", {"a/b": []});
+`, book))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `true
+{"Sheet1": [{"name": "Ada", "paid": true, "price": 2.5, "qty": 3, "tags": null}, {"name": "Bob & Co <ltd>", "paid": null, "price": null, "qty": 10, "tags": "[\"a\",\"b\"]"}]}
+["name", "qty", "price", "paid", "tags"]
+true
+[{"1": 3, "2": null}]
+ERROR: xlsx_read: no sheet named "Missing"
+ERROR: xlsx_write: "a/b" is not a valid sheet name (1 to 31 characters, none of []:*?/\)
+`
+	if out != want {
+		t.Errorf("output:\n%s\nwant:\n%s", out, want)
+	}
+}
+
+// TestXLSXReadExcelFile reads a workbook laid out the way Excel saves one:
+// shared strings, rich text, a date style, a gap between cells and a sheet
+// path given relative to the workbook.
+func TestXLSXReadExcelFile(t *testing.T) {
+	book := filepath.Join(t.TempDir(), "excel.xlsx")
+	f, err := os.Create(book)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	for name, content := range map[string]string{
+		"xl/workbook.xml": `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<sheets><sheet name="Orders" sheetId="1" r:id="rId3"/></sheets></workbook>`,
+		"xl/_rels/workbook.xml.rels": `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId3" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="/xl/worksheets/orders.xml"/></Relationships>`,
+		"xl/sharedStrings.xml": `<sst xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+<si><t>Customer</t></si><si><t>Date</t></si><si><r><t>Acme</t></r><r><rPr><b/></rPr><t> Corp</t></r></si></sst>`,
+		"xl/styles.xml": `<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+<numFmts count="1"><numFmt numFmtId="164" formatCode="dd/mm/yyyy\ hh:mm"/></numFmts>
+<cellXfs count="3"><xf numFmtId="0"/><xf numFmtId="14"/><xf numFmtId="164"/></cellXfs></styleSheet>`,
+		"xl/worksheets/orders.xml": `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>
+<row r="1"><c r="A1" t="s"><v>0</v></c><c r="B1" t="s"><v>1</v></c><c r="D1" t="inlineStr"><is><t>Total</t></is></c></row>
+<row r="2"><c r="A2" t="s"><v>2</v></c><c r="B2" s="1"><v>45292</v></c><c r="C2"><v>7</v></c><c r="D2"><f>C2*2</f><v>14.5</v></c></row>
+<row r="3"><c r="B3" s="2"><v>45292.75</v></c></row>
+</sheetData></worksheet>`,
+	} {
+		w, _ := zw.Create(name)
+		w.Write([]byte(content))
+	}
+	zw.Close()
+	f.Close()
+
+	out, err := runSource(fmt.Sprintf(`out xlsx_read("%s");`, book))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"Orders": [{"C": 7, "Customer": "Acme Corp", "Date": "2024-01-01", "Total": 14.5}, {"C": null, "Customer": null, "Date": "2024-01-01 18:00:00", "Total": null}]}`
+	if strings.TrimSpace(out) != want {
+		t.Errorf("output:\n%s\nwant:\n%s", out, want)
+	}
+}
+
+func TestXLSXReadNotAWorkbook(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "plain.xlsx")
+	os.WriteFile(path, []byte("not a zip"), 0644)
+	out, err := runSource(fmt.Sprintf(`out xlsx_read("%s");`, path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out, "ERROR: xlsx_read: ") {
+		t.Errorf("output = %q, want an xlsx_read error", out)
+	}
+}
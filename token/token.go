@@ -1,15 +1,19 @@
 package token
 
+import "fmt"
+
 type TokenType string
 
 const (
 	ILLEGAL = "ILLEGAL"
 	EOF     = "EOF"
+	COMMENT = "COMMENT" // only from lexer.NewWithComments
 
-	IDENT  = "IDENT"
-	INT    = "INT"
-	FLOAT  = "FLOAT"
-	STRING = "STRING"
+	IDENT   = "IDENT"
+	INT     = "INT"
+	FLOAT   = "FLOAT"
+	STRING  = "STRING"
+	HEREDOC = "HEREDOC" // """...""", with the text between the quotes as written
 
 	ASSIGN    = "="
 	FAT_ARROW = "=>"
@@ -22,19 +26,25 @@ const (
 	MOD       = "%"
 	BANG      = "!"
 
-	LT     = "<"
-	GT     = ">"
-	EQ     = "=="
-	NOT_EQ = "!="
-	AND    = "&&"
-	OR     = "||"
-	PIPE   = "|>"
-	RSHIFT = ">>"
+	LT      = "<"
+	GT      = ">"
+	POWER   = "**"
+	LT_EQ   = "<="
+	GT_EQ   = ">="
+	EQ      = "=="
+	NOT_EQ  = "!="
+	AND     = "&&"
+	OR      = "||"
+	PIPE    = "|>"
+	NULLISH = "??"
+	OPTDOT  = "?."
+	RSHIFT  = ">>"
 
 	COMMA     = ","
 	SEMICOLON = ";"
 	COLON     = ":"
 	DOT       = "."
+	DOTDOT    = ".."
 
 	LPAREN   = "("
 	RPAREN   = ")"
@@ -43,57 +53,67 @@ const (
 	LBRACKET = "["
 	RBRACKET = "]"
 
-	SET    = "SET"
-	OUT    = "OUT"
-	IF     = "IF"
-	ELSE   = "ELSE"
-	FOR    = "FOR"
-	WHILE  = "WHILE"
-	FN     = "FN"
-	RETURN = "RETURN"
-	MATCH  = "MATCH"
-	SPAWN  = "SPAWN"
-	IMPORT = "IMPORT"
-	AS     = "AS"
-	TRY    = "TRY"
-	CATCH  = "CATCH"
-	THROW  = "THROW"
-	TRUE   = "TRUE"
-	FALSE  = "FALSE"
-	BREAK  = "BREAK"
+	SET      = "SET"
+	OUT      = "OUT"
+	IF       = "IF"
+	ELSE     = "ELSE"
+	FOR      = "FOR"
+	WHILE    = "WHILE"
+	DO       = "DO"
+	FN       = "FN"
+	RETURN   = "RETURN"
+	MATCH    = "MATCH"
+	SPAWN    = "SPAWN"
+	GROUP    = "GROUP"
+	IMPORT   = "IMPORT"
+	AS       = "AS"
+	TRY      = "TRY"
+	CATCH    = "CATCH"
+	THROW    = "THROW"
+	TRUE     = "TRUE"
+	FALSE    = "FALSE"
+	NULL     = "NULL"
+	BREAK    = "BREAK"
 	CONTINUE = "CONTINUE"
-	IN     = "IN"
-	CONST  = "CONST"
-
-	BITAND  = "&"
-	BITOR   = "|"
-	BITXOR  = "^"
-	BITNOT  = "~"
-	LSHIFT  = "<<"
+	IN       = "IN"
+	CONST    = "CONST"
+	REQUIRES = "REQUIRES"
+	CLASS    = "CLASS"
+
+	BITAND = "&"
+	BITOR  = "|"
+	BITXOR = "^"
+	BITNOT = "~"
+	LSHIFT = "<<"
 )
 
 var keywords = map[string]TokenType{
-	"set":    SET,
-	"out":    OUT,
-	"if":     IF,
-	"else":   ELSE,
-	"for":    FOR,
-	"while":  WHILE,
-	"fn":     FN,
-	"return": RETURN,
-	"match":  MATCH,
-	"spawn":  SPAWN,
-	"import": IMPORT,
-	"as":     AS,
-	"try":    TRY,
-	"catch":  CATCH,
-	"throw":  THROW,
-	"true":   TRUE,
-	"false":  FALSE,
-	"break":  BREAK,
+	"set":      SET,
+	"out":      OUT,
+	"if":       IF,
+	"else":     ELSE,
+	"for":      FOR,
+	"while":    WHILE,
+	"do":       DO,
+	"fn":       FN,
+	"return":   RETURN,
+	"match":    MATCH,
+	"spawn":    SPAWN,
+	"group":    GROUP,
+	"import":   IMPORT,
+	"as":       AS,
+	"try":      TRY,
+	"catch":    CATCH,
+	"throw":    THROW,
+	"true":     TRUE,
+	"false":    FALSE,
+	"null":     NULL,
+	"break":    BREAK,
 	"continue": CONTINUE,
-	"in":     IN,
-	"const":  CONST,
+	"in":       IN,
+	"const":    CONST,
+	"requires": REQUIRES,
+	"class":    CLASS,
 }
 
 type Token struct {
@@ -101,6 +121,9 @@ type Token struct {
 	Literal string
 	Line    int
 	Col     int
+	EndLine int // EndLine and EndCol are just past the token's last character
+	EndCol  int
+	Doc     string // text of /// comments directly preceding this token
 }
 
 func LookupIdent(ident string) TokenType {
@@ -109,3 +132,37 @@ func LookupIdent(ident string) TokenType {
 	}
 	return IDENT
 }
+
+// Source is one file of a source text joined from several: its name and
+// the line of the joined text it starts on.
+type Source struct {
+	File string
+	Line int
+}
+
+// SourceMap lists the files a source text was joined from, in order; see
+// lexer.NewChunks. Positions in tokens and AST nodes are lines of the
+// joined text, and Locate turns them back into lines of a file. A nil map
+// stands for a single unnamed text.
+type SourceMap []Source
+
+// Locate returns the file that line of the joined text belongs to and the
+// line within that file.
+func (m SourceMap) Locate(line int) (file string, fileLine int) {
+	for i := len(m) - 1; i >= 0; i-- {
+		if line >= m[i].Line {
+			return m[i].File, line - m[i].Line + 1
+		}
+	}
+	return "", line
+}
+
+// Position formats line and col of the joined text for error messages:
+// "Line 3, Col 5", preceded by the file name when the map has one.
+func (m SourceMap) Position(line, col int) string {
+	file, line := m.Locate(line)
+	if file == "" {
+		return fmt.Sprintf("Line %d, Col %d", line, col)
+	}
+	return fmt.Sprintf("%s: Line %d, Col %d", file, line, col)
+}
@@ -0,0 +1,693 @@
+package vm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"xon/builtins"
+	"xon/code"
+	"xon/compiler"
+	"xon/lexer"
+	"xon/object"
+	"xon/parser"
+)
+
+// opFunc executes one decoded instruction of frame, the current frame.
+// Operands that are jump targets are instruction indexes; a handler jumps
+// by setting frame.ip to the target minus one, as Run increments it.
+type opFunc func(vm *VM, frame *Frame, in code.Instr) error
+
+// ops maps each opcode to its handler; Run looks handlers up here instead
+// of switching on the opcode. Filled in init, as some handlers reach Run.
+var ops [256]opFunc
+
+// errHalt is returned by a handler when the outermost frame returns; Run
+// stops without an error.
+var errHalt = errors.New("halt")
+
+func init() {
+	for _, op := range []code.Opcode{
+		code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpMod, code.OpPow,
+		code.OpGreaterThan, code.OpGreaterEqual, code.OpEqual, code.OpNotEqual,
+		code.OpBitAnd, code.OpBitOr, code.OpBitXor, code.OpLshift, code.OpRshift,
+	} {
+		ops[op] = (*VM).opBinary
+	}
+	ops[code.OpConstant] = (*VM).opConstant
+	ops[code.OpString] = (*VM).opConstant
+	ops[code.OpMinus] = (*VM).opMinus
+	ops[code.OpBang] = (*VM).opBang
+	ops[code.OpBitNot] = (*VM).opBitNot
+	ops[code.OpTrue] = (*VM).opTrue
+	ops[code.OpFalse] = (*VM).opFalse
+	ops[code.OpNull] = (*VM).opNull
+	ops[code.OpOut] = (*VM).opOut
+	ops[code.OpGetGlobal] = (*VM).opGetGlobal
+	ops[code.OpSetGlobal] = (*VM).opSetGlobal
+	ops[code.OpGetLocal] = (*VM).opGetLocal
+	ops[code.OpSetLocal] = (*VM).opSetLocal
+	ops[code.OpGetBuiltin] = (*VM).opGetBuiltin
+	ops[code.OpArray] = (*VM).opArray
+	ops[code.OpHash] = (*VM).opHash
+	ops[code.OpIndex] = (*VM).opIndex
+	ops[code.OpMember] = (*VM).opMember
+	ops[code.OpSetIndex] = (*VM).opSetIndex
+	ops[code.OpSetMember] = (*VM).opSetMember
+	ops[code.OpRange] = (*VM).opRange
+	ops[code.OpSlice] = (*VM).opSlice
+	ops[code.OpClass] = (*VM).opClass
+	ops[code.OpJump] = (*VM).opJump
+	ops[code.OpJumpNotTruthy] = (*VM).opJumpNotTruthy
+	ops[code.OpJumpTruthy] = (*VM).opJumpTruthy
+	ops[code.OpJumpNotNull] = (*VM).opJumpNotNull
+	ops[code.OpJumpNull] = (*VM).opJumpNull
+	ops[code.OpDup] = (*VM).opDup
+	ops[code.OpCatch] = (*VM).opCatch
+	ops[code.OpThrow] = (*VM).opThrow
+	ops[code.OpEndCatch] = (*VM).opEndCatch
+	ops[code.OpCall] = (*VM).opCall
+	ops[code.OpAppend] = (*VM).opAppend
+	ops[code.OpSpawn] = (*VM).opSpawn
+	ops[code.OpGroupStart] = (*VM).opGroupStart
+	ops[code.OpGroupEnd] = (*VM).opGroupEnd
+	ops[code.OpClosure] = (*VM).opClosure
+	ops[code.OpGetFree] = (*VM).opGetFree
+	ops[code.OpSetFree] = (*VM).opSetFree
+	ops[code.OpFreeze] = (*VM).opFreeze
+	ops[code.OpCurrentClosure] = (*VM).opCurrentClosure
+	ops[code.OpReturnValue] = (*VM).opReturnValue
+	ops[code.OpReturn] = (*VM).opReturn
+	ops[code.OpPop] = (*VM).opPop
+	ops[code.OpImport] = (*VM).opImport
+
+	ops[code.OpAddConstLocal] = (*VM).opAddConstLocal
+	ops[code.OpAddLocalLocal] = (*VM).opAddLocalLocal
+	ops[code.OpAddConstGlobal] = (*VM).opAddConstGlobal
+	ops[code.OpIndexLocal] = (*VM).opIndexLocal
+	ops[code.OpCompareConstJump] = (*VM).opCompareConstJump
+	ops[code.OpCompareLocalJump] = (*VM).opCompareLocalJump
+}
+
+func (vm *VM) opConstant(frame *Frame, in code.Instr) error {
+	return vm.push(vm.getConstants()[in.A])
+}
+
+func (vm *VM) opBinary(frame *Frame, in code.Instr) error {
+	return vm.executeBinaryOperation(in.Op)
+}
+
+func (vm *VM) opMinus(frame *Frame, in code.Instr) error {
+	operand := vm.pop()
+	switch obj := operand.(type) {
+	case *object.Integer:
+		return vm.push(&object.Integer{Value: -obj.Value})
+	case *object.Float:
+		return vm.push(&object.Float{Value: -obj.Value})
+	default:
+		return fmt.Errorf("unsupported type for negation: %s", obj.Type())
+	}
+}
+
+func (vm *VM) opBang(frame *Frame, in code.Instr) error {
+	return vm.push(&object.Boolean{Value: !isTruthy(vm.pop())})
+}
+
+func (vm *VM) opBitNot(frame *Frame, in code.Instr) error {
+	operand := vm.pop()
+	obj, ok := operand.(*object.Integer)
+	if !ok {
+		return fmt.Errorf("bitwise NOT requires integer, got %s", operand.Type())
+	}
+	return vm.push(&object.Integer{Value: ^obj.Value})
+}
+
+func (vm *VM) opTrue(frame *Frame, in code.Instr) error {
+	return vm.push(&object.Boolean{Value: true})
+}
+
+func (vm *VM) opFalse(frame *Frame, in code.Instr) error {
+	return vm.push(&object.Boolean{Value: false})
+}
+
+func (vm *VM) opNull(frame *Frame, in code.Instr) error {
+	return vm.push(&object.Null{})
+}
+
+func (vm *VM) opOut(frame *Frame, in code.Instr) error {
+	val := vm.pop()
+	if PrettyOut && (val.Type() == object.ARRAY_OBJ || val.Type() == object.HASH_OBJ) {
+		builtins.Out(vm.taskID, object.Pretty(val, object.DefaultPretty))
+	} else {
+		builtins.Out(vm.taskID, val.Inspect())
+	}
+	return nil
+}
+
+func (vm *VM) opGetGlobal(frame *Frame, in code.Instr) error {
+	globals, mu := vm.getGlobals()
+	mu.RLock()
+	val := globals[in.A]
+	mu.RUnlock()
+	return vm.push(val)
+}
+
+func (vm *VM) opSetGlobal(frame *Frame, in code.Instr) error {
+	val := vm.pop()
+	globals, mu := vm.getGlobals()
+	mu.Lock()
+	globals[in.A] = val
+	if m := frame.cl.Fn.Module; m != nil {
+		m.Export(in.A, val)
+	}
+	mu.Unlock()
+	return nil
+}
+
+func (vm *VM) opGetLocal(frame *Frame, in code.Instr) error {
+	return vm.push(vm.local(frame.basePointer + in.A))
+}
+
+func (vm *VM) opSetLocal(frame *Frame, in code.Instr) error {
+	vm.stack[frame.basePointer+in.A] = vm.pop()
+	return nil
+}
+
+func (vm *VM) opGetBuiltin(frame *Frame, in code.Instr) error {
+	builtin := builtins.GetBuiltinByIndex(in.A)
+	if builtin == nil {
+		return fmt.Errorf("builtin function not found at index %d", in.A)
+	}
+	return vm.push(builtin)
+}
+
+func (vm *VM) opArray(frame *Frame, in code.Instr) error {
+	array := vm.buildArray(vm.sp-in.A, vm.sp)
+	vm.sp = vm.sp - in.A
+	return vm.push(array)
+}
+
+func (vm *VM) opHash(frame *Frame, in code.Instr) error {
+	hash, err := vm.buildHash(vm.sp-in.A, vm.sp)
+	if err != nil {
+		return err
+	}
+	vm.sp = vm.sp - in.A
+	return vm.push(hash)
+}
+
+func (vm *VM) opIndex(frame *Frame, in code.Instr) error {
+	index := vm.pop()
+	left := vm.pop()
+	return vm.executeIndexExpression(left, index)
+}
+
+func (vm *VM) opMember(frame *Frame, in code.Instr) error {
+	memberName := vm.getConstants()[in.A].(*object.String).Value
+	return vm.executeMemberExpression(vm.pop(), memberName)
+}
+
+func (vm *VM) opSetIndex(frame *Frame, in code.Instr) error {
+	value := vm.pop()
+	index := vm.pop()
+	return vm.executeSetIndex(vm.pop(), index, value)
+}
+
+func (vm *VM) opSetMember(frame *Frame, in code.Instr) error {
+	member := vm.getConstants()[in.A].(*object.String)
+	value := vm.pop()
+	target := vm.pop()
+	if _, ok := target.(*object.Hash); !ok {
+		return fmt.Errorf("member assignment not supported on %s", target.Type())
+	}
+	return vm.executeSetIndex(target, member, value)
+}
+
+// opRange makes start..end from two integers without building an array.
+func (vm *VM) opRange(frame *Frame, in code.Instr) error {
+	end := vm.pop()
+	start := vm.pop()
+	s, ok1 := start.(*object.Integer)
+	e, ok2 := end.(*object.Integer)
+	if !ok1 || !ok2 {
+		return fmt.Errorf("range bounds must be INTEGER, got %s..%s", start.Type(), end.Type())
+	}
+	return vm.push(&object.Range{Start: s.Value, End: e.Value, Step: 1})
+}
+
+func (vm *VM) opSlice(frame *Frame, in code.Instr) error {
+	high := vm.pop()
+	low := vm.pop()
+	return vm.executeSlice(vm.pop(), low, high)
+}
+
+// opClass makes a class from the constant in.A, which holds its name and
+// fields, and from the stack: the name and closure of each of its in.B
+// methods, then its constructor.
+func (vm *VM) opClass(frame *Frame, in code.Instr) error {
+	proto := vm.getConstants()[in.A].(*object.Class)
+	class := &object.Class{
+		Name:    proto.Name,
+		Fields:  proto.Fields,
+		Init:    vm.pop().(*object.Closure),
+		Methods: make(map[string]*object.Closure, in.B),
+	}
+	for i := 0; i < in.B; i++ {
+		method := vm.pop().(*object.Closure)
+		class.Methods[vm.pop().(*object.String).Value] = method
+	}
+	return vm.push(class)
+}
+
+func (vm *VM) opJump(frame *Frame, in code.Instr) error {
+	frame.ip = in.A - 1
+	return nil
+}
+
+func (vm *VM) opJumpNotTruthy(frame *Frame, in code.Instr) error {
+	if !isTruthy(vm.pop()) {
+		frame.ip = in.A - 1
+	}
+	return nil
+}
+
+func (vm *VM) opJumpTruthy(frame *Frame, in code.Instr) error {
+	if isTruthy(vm.StackTop()) {
+		frame.ip = in.A - 1
+	}
+	return nil
+}
+
+// opJumpNotNull keeps a non-null value on the stack and jumps past the
+// right operand of ??; a null is dropped for the right operand to replace.
+func (vm *VM) opJumpNotNull(frame *Frame, in code.Instr) error {
+	if _, isNull := vm.StackTop().(*object.Null); !isNull {
+		frame.ip = in.A - 1
+		return nil
+	}
+	vm.pop()
+	return nil
+}
+
+// opJumpNull ends an optional chain at a null, which stays on the stack as
+// the chain's value.
+func (vm *VM) opJumpNull(frame *Frame, in code.Instr) error {
+	if _, isNull := vm.StackTop().(*object.Null); isNull {
+		frame.ip = in.A - 1
+	}
+	return nil
+}
+
+func (vm *VM) opDup(frame *Frame, in code.Instr) error {
+	if vm.sp == 0 {
+		return fmt.Errorf("stack empty for OpDup")
+	}
+	return vm.push(vm.stack[vm.sp-1])
+}
+
+func (vm *VM) opCatch(frame *Frame, in code.Instr) error {
+	vm.catchHandlers = append(vm.catchHandlers, catchHandler{pos: in.A, frameIndex: vm.frameIndex, sp: vm.sp})
+	return nil
+}
+
+func (vm *VM) opThrow(frame *Frame, in code.Instr) error {
+	if vm.sp == 0 {
+		return fmt.Errorf("throw with empty stack")
+	}
+	return vm.throw(vm.pop())
+}
+
+func (vm *VM) opEndCatch(frame *Frame, in code.Instr) error {
+	if len(vm.catchHandlers) == 0 {
+		return fmt.Errorf("OpEndCatch without OpCatch")
+	}
+	vm.catchHandlers = vm.catchHandlers[:len(vm.catchHandlers)-1]
+	return nil
+}
+
+func (vm *VM) opCall(frame *Frame, in code.Instr) error {
+	return vm.executeCall(in.A)
+}
+
+func (vm *VM) opAppend(frame *Frame, in code.Instr) error {
+	val := vm.pop()
+	target := vm.pop()
+	if arr, ok := target.(*object.Array); ok {
+		if arr.Frozen {
+			return vm.raise(errors.New("cannot push to a frozen array"))
+		}
+		arr.Elements = append(arr.Elements, val)
+		return vm.push(&object.Null{})
+	}
+	// Not an array: fall back to an ordinary target.push(val) call.
+	if err := vm.executeMemberExpression(target, "push"); err != nil {
+		return err
+	}
+	if err := vm.push(val); err != nil {
+		return err
+	}
+	return vm.executeCall(1)
+}
+
+func (vm *VM) opSpawn(frame *Frame, in code.Instr) error {
+	numArgs := in.A
+	args := make([]object.Object, numArgs)
+	for i := numArgs - 1; i >= 0; i-- {
+		args[i] = vm.pop()
+	}
+
+	target := vm.pop()
+	var cl *object.Closure
+
+	switch t := target.(type) {
+	case *object.Closure:
+		cl = t
+	case *object.CompiledFunction:
+		cl = &object.Closure{Fn: t}
+	case *object.BoundMethod:
+		cl = t.Method
+		args = append([]object.Object{t.Receiver}, args...)
+	default:
+		return fmt.Errorf("spawn target must be a function, got %s", target.Type())
+	}
+
+	// Inside a group block the task reports to the group, which
+	// waits for it; otherwise it runs on its own and its errors
+	// are only printed.
+	var group *taskGroup
+	cancel := vm.cancel
+	if len(vm.groups) > 0 {
+		group = vm.groups[len(vm.groups)-1]
+		group.wg.Add(1)
+		cancel = group.cancel
+	}
+	var stops []context.CancelFunc
+	for _, arg := range args {
+		if c, ok := arg.(*object.Context); ok {
+			cancel = newCancelFlag(cancel, c.Ctx)
+			stops = append(stops, cancel.stop)
+		}
+	}
+	taskID := nextTaskID.Add(1)
+	report := func(err error) {
+		switch {
+		case err == errCanceled:
+		case group == nil:
+			builtins.Out(taskID, "Sub-VM error: "+err.Error())
+		default:
+			group.fail(err)
+		}
+	}
+
+	go func() {
+		if group != nil {
+			defer group.wg.Done()
+		}
+		for _, stop := range stops {
+			defer stop()
+		}
+		defer func() {
+			if r := recover(); r != nil {
+				if group != nil {
+					group.fail(fmt.Errorf("panic: %v", r))
+				} else {
+					builtins.Out(taskID, fmt.Sprintf("Recovered in spawn goroutine: %v", r))
+				}
+			}
+		}()
+		subVm, err := vm.newTaskVM(cl, args, cancel, taskID)
+		if err != nil {
+			report(err)
+			return
+		}
+		if err := subVm.Run(); err != nil {
+			report(err)
+		}
+	}()
+	return nil
+}
+
+func (vm *VM) opGroupStart(frame *Frame, in code.Instr) error {
+	vm.startGroup()
+	return nil
+}
+
+func (vm *VM) opGroupEnd(frame *Frame, in code.Instr) error {
+	err := vm.endGroup()
+	if err == nil {
+		return nil
+	}
+	if len(vm.catchHandlers) == 0 {
+		return fmt.Errorf("task group: %s", err)
+	}
+	// A task's uncaught throw is rethrown as the same value.
+	var thrown object.Object = runtimeError(err)
+	if t, ok := err.(*uncaughtThrow); ok {
+		thrown = t.value
+	}
+	return vm.throw(thrown)
+}
+
+func (vm *VM) opClosure(frame *Frame, in code.Instr) error {
+	return vm.pushClosure(in.A, in.B)
+}
+
+func (vm *VM) opGetFree(frame *Frame, in code.Instr) error {
+	return vm.push(frame.cl.Free[in.A])
+}
+
+func (vm *VM) opSetFree(frame *Frame, in code.Instr) error {
+	frame.cl.Free[in.A] = vm.pop()
+	return nil
+}
+
+func (vm *VM) opFreeze(frame *Frame, in code.Instr) error {
+	object.Freeze(vm.stack[vm.sp-1])
+	return nil
+}
+
+func (vm *VM) opCurrentClosure(frame *Frame, in code.Instr) error {
+	return vm.push(frame.cl)
+}
+
+func (vm *VM) opReturnValue(frame *Frame, in code.Instr) error {
+	return vm.returnFromFrame(vm.pop())
+}
+
+func (vm *VM) opReturn(frame *Frame, in code.Instr) error {
+	return vm.returnFromFrame(&object.Null{})
+}
+
+// returnFromFrame pops the current frame and leaves value on the caller's
+// stack, or halts Run with value on top when it was the outermost frame.
+func (vm *VM) returnFromFrame(value object.Object) error {
+	frame := vm.popFrame()
+	// A return from inside a try block leaves its handler behind.
+	for len(vm.catchHandlers) > 0 && vm.catchHandlers[len(vm.catchHandlers)-1].frameIndex > vm.frameIndex {
+		vm.catchHandlers = vm.catchHandlers[:len(vm.catchHandlers)-1]
+	}
+	if vm.frameIndex == 0 {
+		vm.sp = 0
+		vm.push(value)
+		return errHalt
+	}
+	vm.sp = frame.basePointer - 1
+	if vm.cancel != nil && vm.cancel.canceled() {
+		return errCanceled
+	}
+	return vm.push(value)
+}
+
+func (vm *VM) opPop(frame *Frame, in code.Instr) error {
+	vm.pop()
+	return nil
+}
+
+func (vm *VM) opImport(frame *Frame, in code.Instr) error {
+	pathObj := vm.pop()
+	path, ok := pathObj.(*object.String)
+	if !ok {
+		return fmt.Errorf("import path must be string, got %s", pathObj.Type())
+	}
+
+	modulePath := path.Value
+	if !strings.HasSuffix(modulePath, ".xn") {
+		modulePath += ".xn"
+	}
+
+	if mod, ok := vm.modules[modulePath]; ok {
+		return vm.push(mod)
+	}
+
+	// Load and compile
+	content, err := builtins.LoadModule(modulePath)
+	if err != nil {
+		return fmt.Errorf("could not read import file %s: %s", modulePath, err)
+	}
+
+	// Prepend standard library so modules have access to it
+	stdSource, err := builtins.LoadStdLib()
+	if err != nil {
+		fmt.Printf("Warning: could not load stdlib for import: %v\n", err)
+	}
+	l := lexer.NewChunks(lexer.Chunk{File: builtins.StdLibFile, Source: stdSource}, lexer.Chunk{File: modulePath, Source: content})
+	p := parser.New(l)
+	program := p.ParseProgram()
+	if len(p.Errors) != 0 {
+		return fmt.Errorf("import parse error: %v", p.Errors)
+	}
+
+	c := compiler.New()
+	err = c.Compile(program)
+	if err != nil {
+		return fmt.Errorf("import compile error: %s", err)
+	}
+
+	bytecode := c.Bytecode()
+	// Run in sub-VM
+	subVm := New(bytecode)
+	subVm.modules = vm.modules
+	subVm.cancel = vm.cancel
+	subVm.taskID = vm.taskID
+
+	err = subVm.Run()
+	if err != nil {
+		return fmt.Errorf("import runtime error: %s", err)
+	}
+
+	// Export all globals as a Hash, which the module's functions keep
+	// current as they assign its globals. It is frozen so that the importer,
+	// including through an alias, cannot write members that
+	// bindModuleMembers has already copied into their own slots.
+	exportHash := &object.Hash{Pairs: make(map[object.HashKey]object.HashPair), Frozen: true, Mu: subVm.globalsMu}
+	names := make(map[int]*object.String)
+	for _, sym := range bytecode.SymbolTable.Symbols() {
+		if sym.Scope == compiler.GlobalScope {
+			key := &object.String{Value: sym.Name}
+			names[sym.Index] = key
+			if val := subVm.globals[sym.Index]; val != nil {
+				exportHash.Pairs[key.HashKey()] = object.HashPair{Key: key, Value: val}
+			}
+		}
+	}
+
+	// Link the module's functions to its own constants and globals,
+	// so they work when called from this VM.
+	module := &object.ModuleState{
+		Path:        modulePath,
+		Constants:   bytecode.Constants,
+		Globals:     subVm.globals,
+		GlobalsMu:   subVm.globalsMu,
+		Exports:     exportHash,
+		ExportNames: names,
+	}
+	for _, constant := range bytecode.Constants {
+		if fn, ok := constant.(*object.CompiledFunction); ok {
+			fn.Module = module
+		}
+	}
+
+	vm.modules[modulePath] = exportHash
+	return vm.push(exportHash)
+}
+
+// Superinstructions, put in by code.Fuse. Each does the work of its whole
+// sequence when the operands are numbers, and otherwise runs just the
+// first instruction of the sequence, leaving the rest to the originals.
+// Sums stored in locals stay unboxed; see unboxed.go.
+
+func (vm *VM) opAddConstLocal(frame *Frame, in code.Instr) error {
+	slot := frame.basePointer + in.A
+	x, ok := vm.numAt(slot)
+	c, ok2 := numOf(vm.getConstants()[in.B])
+	if !ok || !ok2 {
+		return vm.opGetLocal(frame, in)
+	}
+	vm.setNum(slot, addNums(x, c))
+	frame.ip += in.C
+	return nil
+}
+
+func (vm *VM) opAddLocalLocal(frame *Frame, in code.Instr) error {
+	slot := frame.basePointer + in.A
+	x, ok := vm.numAt(slot)
+	y, ok2 := vm.numAt(frame.basePointer + in.B)
+	if !ok || !ok2 {
+		return vm.opGetLocal(frame, in)
+	}
+	vm.setNum(slot, addNums(x, y))
+	frame.ip += in.C
+	return nil
+}
+
+func (vm *VM) opAddConstGlobal(frame *Frame, in code.Instr) error {
+	globals, mu := vm.getGlobals()
+	mu.Lock()
+	x, ok := numOf(globals[in.A])
+	c, ok2 := numOf(vm.getConstants()[in.B])
+	ok = ok && ok2
+	if ok {
+		globals[in.A] = addNums(x, c).box()
+		if m := frame.cl.Fn.Module; m != nil {
+			m.Export(in.A, globals[in.A])
+		}
+	}
+	mu.Unlock()
+	if !ok {
+		return vm.opGetGlobal(frame, in)
+	}
+	frame.ip += in.C
+	return nil
+}
+
+func (vm *VM) opIndexLocal(frame *Frame, in code.Instr) error {
+	frame.ip++
+	return vm.executeIndexExpression(vm.pop(), vm.local(frame.basePointer+in.A))
+}
+
+func (vm *VM) opCompareConstJump(frame *Frame, in code.Instr) error {
+	right, ok := numOf(vm.getConstants()[in.A])
+	return vm.compareJump(frame, in, right, ok, (*VM).opConstant)
+}
+
+func (vm *VM) opCompareLocalJump(frame *Frame, in code.Instr) error {
+	right, ok := vm.numAt(frame.basePointer + in.A)
+	return vm.compareJump(frame, in, right, ok, (*VM).opGetLocal)
+}
+
+// compareJump compares the top of the stack with right using the
+// comparison opcode in.B and jumps to in.C when the result is false.
+// Unless both are numbers it runs fallback instead.
+func (vm *VM) compareJump(frame *Frame, in code.Instr, right num, ok bool, fallback opFunc) error {
+	left, ok2 := numOf(vm.stack[vm.sp-1])
+	if !ok || !ok2 {
+		return fallback(vm, frame, in)
+	}
+	var result bool
+	if !left.isFloat && !right.isFloat {
+		result = compare(code.Opcode(in.B), left.i, right.i)
+	} else {
+		result = compare(code.Opcode(in.B), left.float(), right.float())
+	}
+	vm.sp--
+	if result {
+		frame.ip += 2
+	} else {
+		frame.ip = in.C - 1
+	}
+	return nil
+}
+
+func compare[T int64 | float64](op code.Opcode, l, r T) bool {
+	switch op {
+	case code.OpGreaterThan:
+		return l > r
+	case code.OpGreaterEqual:
+		return l >= r
+	case code.OpEqual:
+		return l == r
+	case code.OpNotEqual:
+		return l != r
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+package vm
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"xon/object"
+)
+
+// errCanceled stops a task whose group gave up after a sibling failed or
+// whose context was canceled.
+var errCanceled = errors.New("task canceled")
+
+// nextTaskID numbers spawned tasks; see builtins.TaskIDs.
+var nextTaskID atomic.Int64
+
+// uncaughtThrow is the error a throw with no catch handler stops the VM
+// with; a group rethrows its value in the task that waits for it.
+type uncaughtThrow struct {
+	value object.Object
+}
+
+func (e *uncaughtThrow) Error() string {
+	if err, ok := e.value.(*object.Error); ok {
+		return "uncaught error: " + strings.TrimPrefix(err.Inspect(), "ERROR: ")
+	}
+	return "uncaught throw: " + e.value.Inspect()
+}
+
+// cancelFlag tells spawned tasks to stop. A task is canceled when its own
+// group's flag or that of any enclosing group is set, or when a context it
+// was spawned with is done; ctx is done in all of those cases, so blocking
+// builtins can wait on it.
+type cancelFlag struct {
+	ctx  context.Context
+	stop context.CancelFunc
+}
+
+// newCancelFlag returns a flag that is set with parent, which may be nil,
+// and when ctx, which may also be nil, is done. Call stop once the flag is
+// no longer needed to release it.
+func newCancelFlag(parent *cancelFlag, ctx context.Context) *cancelFlag {
+	base := context.Background()
+	if parent != nil {
+		base = parent.ctx
+	}
+	c, stop := context.WithCancel(base)
+	if ctx != nil {
+		release := context.AfterFunc(ctx, stop)
+		return &cancelFlag{ctx: c, stop: func() { release(); stop() }}
+	}
+	return &cancelFlag{ctx: c, stop: stop}
+}
+
+func (c *cancelFlag) canceled() bool {
+	return c.ctx.Err() != nil
+}
+
+// taskGroup tracks the tasks spawned inside one `group { ... }` block.
+type taskGroup struct {
+	wg     sync.WaitGroup
+	mu     sync.Mutex
+	err    error // the first task failure
+	cancel *cancelFlag
+	// catchDepth is the number of catch handlers when the group started;
+	// a throw to a handler below it abandons the group.
+	catchDepth int
+}
+
+// fail records err if it is the group's first failure and cancels the
+// remaining tasks.
+func (g *taskGroup) fail(err error) {
+	g.mu.Lock()
+	if g.err == nil {
+		g.err = err
+	}
+	g.mu.Unlock()
+	g.cancel.stop()
+}
+
+func (vm *VM) startGroup() {
+	vm.groups = append(vm.groups, &taskGroup{
+		cancel:     newCancelFlag(vm.cancel, nil),
+		catchDepth: len(vm.catchHandlers),
+	})
+}
+
+// endGroup waits for the innermost group's tasks and returns the first
+// error any of them hit.
+func (vm *VM) endGroup() error {
+	g := vm.groups[len(vm.groups)-1]
+	vm.groups = vm.groups[:len(vm.groups)-1]
+	g.wg.Wait()
+	g.cancel.stop()
+	return g.err
+}
+
+// abandonGroups cancels and waits for the groups a throw is jumping out
+// of, those started after the handler at catchDepth was installed.
+func (vm *VM) abandonGroups(catchDepth int) {
+	for len(vm.groups) > 0 {
+		g := vm.groups[len(vm.groups)-1]
+		if g.catchDepth <= catchDepth {
+			return
+		}
+		g.cancel.stop()
+		g.wg.Wait()
+		vm.groups = vm.groups[:len(vm.groups)-1]
+	}
+}
@@ -0,0 +1,128 @@
+package vm
+
+import (
+	"fmt"
+	"xon/code"
+	"xon/object"
+)
+
+// TraceLength is how many of its most recent instructions each VM keeps
+// for Trace. It is 0, and nothing is recorded, unless the CLI asks for
+// crash reports.
+var TraceLength = 0
+
+// traced is one executed instruction: the function it ran in and its
+// index in that function's decoded code.
+type traced struct {
+	fn *object.CompiledFunction
+	ip int
+	in code.Instr
+}
+
+// instrTrace is a ring of the last TraceLength instructions.
+type instrTrace struct {
+	entries []traced
+	next    int
+	full    bool
+}
+
+func newInstrTrace() *instrTrace {
+	if TraceLength <= 0 {
+		return nil
+	}
+	return &instrTrace{entries: make([]traced, TraceLength)}
+}
+
+func (t *instrTrace) record(fn *object.CompiledFunction, ip int, in code.Instr) {
+	t.entries[t.next] = traced{fn, ip, in}
+	t.next++
+	if t.next == len(t.entries) {
+		t.next = 0
+		t.full = true
+	}
+}
+
+// Trace lists the last instructions this VM executed, oldest first, as
+// "function index instruction". After Run returns an error the last entry
+// is the one that failed.
+func (vm *VM) Trace() []string {
+	if vm.trace == nil {
+		return nil
+	}
+	var order []traced
+	if vm.trace.full {
+		order = append(order, vm.trace.entries[vm.trace.next:]...)
+	}
+	order = append(order, vm.trace.entries[:vm.trace.next]...)
+	lines := make([]string, len(order))
+	for i, t := range order {
+		name := t.fn.Name
+		switch {
+		case name == "" && t.fn == vm.frames[0].cl.Fn && vm.taskID == 0:
+			name = "<main>"
+		case name == "":
+			name = "<anonymous fn>"
+		}
+		lines[i] = fmt.Sprintf("%s %04d %s", name, t.ip, t.in)
+	}
+	return lines
+}
+
+// StackFrame is one active call in a stack trace: the function and the
+// file and line it is at; Line is 0 if unknown and File empty for a
+// source that was not named. For every frame but the innermost that is the
+// line of the call into the next.
+type StackFrame struct {
+	Name string
+	File string
+	Line int
+	// Repeats is how many identical calls in a row this frame stands for,
+	// as in deep recursion; 0 for a single call.
+	Repeats int
+}
+
+func (f StackFrame) String() string {
+	var s string
+	switch {
+	case f.Line == 0:
+		s = f.Name
+	case f.File == "":
+		s = fmt.Sprintf("%s (line %d)", f.Name, f.Line)
+	default:
+		s = fmt.Sprintf("%s (%s:%d)", f.Name, f.File, f.Line)
+	}
+	if f.Repeats > 1 {
+		s += fmt.Sprintf(" (repeated %d times)", f.Repeats)
+	}
+	return s
+}
+
+// StackTrace lists the active calls, innermost first. After Run returns an
+// error it is the failing call chain, and the first frame's line is that
+// of the instruction that failed. A run of identical frames is folded into
+// one whose Repeats counts them, so a stack overflow stays readable.
+func (vm *VM) StackTrace() []StackFrame {
+	trace := make([]StackFrame, 0, vm.frameIndex)
+	for i := vm.frameIndex - 1; i >= 0; i-- {
+		f := vm.frames[i]
+		file, line := f.cl.Fn.Line(f.ip)
+		frame := StackFrame{Name: vm.frameName(i), File: file, Line: line}
+		if n := len(trace); n > 0 {
+			last := &trace[n-1]
+			if last.Name == frame.Name && last.File == frame.File && last.Line == frame.Line {
+				if last.Repeats == 0 {
+					last.Repeats = 1
+				}
+				last.Repeats++
+				continue
+			}
+		}
+		trace = append(trace, frame)
+	}
+	return trace
+}
+
+// InstructionCount is the number of instructions this VM has executed.
+func (vm *VM) InstructionCount() uint64 {
+	return vm.instructions
+}
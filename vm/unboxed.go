@@ -0,0 +1,91 @@
+package vm
+
+import "xon/object"
+
+// Numeric locals are kept unboxed. A superinstruction that stores an
+// integer or float sum into a local writes it to vm.nums, which runs
+// parallel to vm.stack, and puts the unboxedNum marker in the stack slot
+// instead of allocating an object. The number is boxed again only when the
+// local's value leaves the slot through local, so a loop counter that is
+// only incremented and compared never allocates. Nothing but the slot's own
+// frame sees the marker: closures, calls and returns all take values that
+// were pushed, and pushing a local boxes it.
+
+// num is an integer or float held outside an object.
+type num struct {
+	i       int64
+	f       float64
+	isFloat bool
+}
+
+type unboxed struct{}
+
+func (unboxed) Type() object.ObjectType { return "UNBOXED" }
+func (unboxed) Inspect() string         { return "<unboxed>" }
+
+// unboxedNum marks a stack slot whose value is in vm.nums.
+var unboxedNum object.Object = unboxed{}
+
+// local returns the value in stack slot i, boxing an unboxed number and
+// leaving the box in the slot so later reads share it.
+func (vm *VM) local(i int) object.Object {
+	obj := vm.stack[i]
+	if obj != unboxedNum {
+		return obj
+	}
+	obj = vm.nums[i].box()
+	vm.stack[i] = obj
+	return obj
+}
+
+// numAt reads stack slot i as a number without boxing it.
+func (vm *VM) numAt(i int) (num, bool) {
+	if vm.stack[i] == unboxedNum {
+		return vm.nums[i], true
+	}
+	return numOf(vm.stack[i])
+}
+
+// setNum stores n unboxed in stack slot i.
+func (vm *VM) setNum(i int, n num) {
+	if i >= len(vm.nums) {
+		grown := make([]num, len(vm.stack))
+		copy(grown, vm.nums)
+		vm.nums = grown
+	}
+	vm.nums[i] = n
+	vm.stack[i] = unboxedNum
+}
+
+func numOf(obj object.Object) (num, bool) {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return num{i: obj.Value}, true
+	case *object.Float:
+		return num{f: obj.Value, isFloat: true}, true
+	}
+	return num{}, false
+}
+
+func (n num) box() object.Object {
+	if n.isFloat {
+		return &object.Float{Value: n.f}
+	}
+	return &object.Integer{Value: n.i}
+}
+
+func (n num) float() float64 {
+	if n.isFloat {
+		return n.f
+	}
+	return float64(n.i)
+}
+
+// addNums adds like OpAdd: integers stay integers, and a float on either
+// side makes the sum a float.
+func addNums(a, b num) num {
+	if !a.isFloat && !b.isFloat {
+		return num{i: a.i + b.i}
+	}
+	return num{f: a.float() + b.float(), isFloat: true}
+}
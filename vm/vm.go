@@ -1,35 +1,56 @@
 package vm
 
 import (
-	"encoding/binary"
+	"context"
 	"xon/builtins"
 	"xon/code"
 	"xon/compiler"
-	"xon/lexer"
 	"xon/object"
-	"xon/parser"
+	"errors"
 	"fmt"
-	"io/ioutil"
 	"math"
-	"strings"
+	"runtime"
+	"sort"
 	"sync"
+	"time"
 )
 
 const (
-	StackSize   = 2048
-	GlobalsSize = 65536
-	MaxFrames   = 1024
+	StackSize     = 2048 // initial stack slots; the stack grows on demand up to MaxStackSize
+	GlobalsSize   = 65536
+	initialFrames = 64
+)
+
+// PrettyOut makes out print arrays and hashes with object.Pretty (indented,
+// truncated). The CLI enables it when stdout is a terminal.
+var PrettyOut bool
+
+// Limits shared by every VM, including spawned and callback sub-VMs.
+// The CLI overrides them with --max-stack and --max-frames.
+var (
+	MaxStackSize = 1 << 20
+	MaxFrames    = 10000
 )
 
 type Frame struct {
 	cl          *object.Closure
-	ip          int
+	code        []code.Instr // cl's decoded instructions
+	ip          int          // index into code
 	basePointer int
 }
 
+// catchHandler is an open try block: where its catch code starts, and the
+// frame and stack height a throw from any depth inside it returns to.
+type catchHandler struct {
+	pos        int
+	frameIndex int
+	sp         int
+}
+
 func NewFrame(cl *object.Closure, basePointer int) *Frame {
 	return &Frame{
 		cl:          cl,
+		code:        cl.Fn.Code(),
 		ip:          -1,
 		basePointer: basePointer,
 	}
@@ -43,6 +64,7 @@ type VM struct {
 	constants []object.Object
 
 	stack     []object.Object
+	nums      []num // unboxed numbers for stack slots marked unboxedNum
 	sp        int
 	globals   []object.Object
 	globalsMu *sync.RWMutex
@@ -50,15 +72,22 @@ type VM struct {
 	frames        []*Frame
 	frameIndex    int
 	modules       map[string]*object.Hash
-	catchHandlers []int
+	catchHandlers []catchHandler
+
+	groups []*taskGroup // open group blocks, innermost last
+	cancel *cancelFlag  // set when this VM runs a task that may be canceled
+	taskID int64        // 0 for the main script, numbered from 1 by spawn
+
+	instructions uint64      // executed so far, reported by vm_stats()
+	trace        *instrTrace // the last TraceLength instructions, or nil
 }
 
 func New(bytecode *compiler.Bytecode) *VM {
-	mainFn := &object.CompiledFunction{Instructions: bytecode.Instructions}
+	mainFn := &object.CompiledFunction{Instructions: bytecode.Instructions, Lines: bytecode.Lines}
 	mainClosure := &object.Closure{Fn: mainFn}
 	mainFrame := NewFrame(mainClosure, 0)
 
-	frames := make([]*Frame, MaxFrames)
+	frames := make([]*Frame, initialFrames)
 	frames[0] = mainFrame
 
 	return &VM{
@@ -70,7 +99,8 @@ func New(bytecode *compiler.Bytecode) *VM {
 		frames:         frames,
 		frameIndex:     1,
 		modules:        make(map[string]*object.Hash),
-		catchHandlers:  make([]int, 0, 8),
+		catchHandlers:  make([]catchHandler, 0, 8),
+		trace:          newInstrTrace(),
 	}
 }
 
@@ -88,20 +118,37 @@ func (vm *VM) currentFrame() *Frame {
 	return vm.frames[vm.frameIndex-1]
 }
 
-// getConstants returns the constants for the current frame.
-// If the frame's closure has its own constants (imported module), use those.
-// Otherwise, fall back to the VM's main constants.
+// getConstants returns the constants for the current frame: its module's
+// pool for a function from an imported module, else the VM's own.
 func (vm *VM) getConstants() []object.Object {
 	frame := vm.currentFrame()
-	if frame != nil && frame.cl.Fn.Constants != nil {
-		return frame.cl.Fn.Constants
+	if frame != nil && frame.cl.Fn.Module != nil {
+		return frame.cl.Fn.Module.Constants
 	}
 	return vm.constants
 }
 
-func (vm *VM) pushFrame(f *Frame) {
-	vm.frames[vm.frameIndex] = f
+// getGlobals returns the globals the current frame reads and writes, chosen
+// the same way as getConstants.
+func (vm *VM) getGlobals() ([]object.Object, *sync.RWMutex) {
+	frame := vm.currentFrame()
+	if frame != nil && frame.cl.Fn.Module != nil {
+		return frame.cl.Fn.Module.Globals, frame.cl.Fn.Module.GlobalsMu
+	}
+	return vm.globals, vm.globalsMu
+}
+
+func (vm *VM) pushFrame(f *Frame) error {
+	if vm.frameIndex >= MaxFrames {
+		return fmt.Errorf("stack overflow: call depth exceeded %d frames (raise with --max-frames)", MaxFrames)
+	}
+	if vm.frameIndex >= len(vm.frames) {
+		vm.frames = append(vm.frames, f)
+	} else {
+		vm.frames[vm.frameIndex] = f
+	}
 	vm.frameIndex++
+	return nil
 }
 
 func (vm *VM) popFrame() *Frame {
@@ -116,428 +163,104 @@ func (vm *VM) StackTop() object.Object {
 	return vm.stack[vm.sp-1]
 }
 
-func (vm *VM) Run() error {
-	var ip int
-	var ins code.Instructions
-	var op code.Opcode
+// Codes of the errors a catch handler receives for what was not thrown
+// as an error value: a runtime error of the VM or a builtin, or any other
+// thrown value, which is kept in the error's data as "value".
+const (
+	runtimeErrorCode = "E_RUNTIME"
+	thrownValueCode  = "E_THROW"
+)
 
-	for vm.frameIndex > 0 {
-		frame := vm.currentFrame()
-		if frame.ip >= len(frame.Instructions())-1 {
-			break
+// throw hands thrown to the innermost catch handler, or returns the
+// uncaught error when there is none. The handler always receives an
+// error value, so it can read e.message and e.code.
+func (vm *VM) throw(thrown object.Object) error {
+	if len(vm.catchHandlers) == 0 {
+		if e, ok := thrown.(*object.Error); ok && e.Stack == nil {
+			e.Stack = vm.callStack()
 		}
-		frame.ip++
-
-		ip = frame.ip
-		ins = frame.Instructions()
-		op = code.Opcode(ins[ip])
-
-		switch op {
-		case code.OpConstant:
-			constIndex := binary.BigEndian.Uint16(ins[ip+1:])
-			frame.ip += 2
-			if err := vm.push(vm.getConstants()[constIndex]); err != nil {
-				return err
-			}
-
-		case code.OpString:
-			constIndex := binary.BigEndian.Uint16(ins[ip+1:])
-			frame.ip += 2
-			if err := vm.push(vm.getConstants()[constIndex]); err != nil {
-				return err
-			}
-
-		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv,
-			code.OpGreaterThan, code.OpEqual, code.OpNotEqual:
-			if err := vm.executeBinaryOperation(op); err != nil {
-				return err
-			}
-
-		case code.OpMinus:
-			operand := vm.pop()
-			switch obj := operand.(type) {
-			case *object.Integer:
-				vm.push(&object.Integer{Value: -obj.Value})
-			case *object.Float:
-				vm.push(&object.Float{Value: -obj.Value})
-			default:
-				return fmt.Errorf("unsupported type for negation: %s", obj.Type())
-			}
-
-		case code.OpBang:
-			operand := vm.pop()
-			if isTruthy(operand) {
-				vm.push(&object.Boolean{Value: false})
-			} else {
-				vm.push(&object.Boolean{Value: true})
-			}
-
-		case code.OpBitNot:
-			operand := vm.pop()
-			obj, ok := operand.(*object.Integer)
-			if !ok {
-				return fmt.Errorf("bitwise NOT requires integer, got %s", operand.Type())
-			}
-			if err := vm.push(&object.Integer{Value: ^obj.Value}); err != nil {
-				return err
-			}
-
-		case code.OpTrue:
-			if err := vm.push(&object.Boolean{Value: true}); err != nil {
-				return err
-			}
-
-		case code.OpFalse:
-			if err := vm.push(&object.Boolean{Value: false}); err != nil {
-				return err
-			}
-
-		case code.OpNull:
-			if err := vm.push(&object.Null{}); err != nil {
-				return err
-			}
-
-		case code.OpOut:
-			val := vm.pop()
-			fmt.Println(val.Inspect())
-
-		case code.OpGetGlobal:
-			globalIndex := binary.BigEndian.Uint16(ins[ip+1:])
-			frame.ip += 2
-			vm.globalsMu.RLock()
-			val := vm.globals[globalIndex]
-			vm.globalsMu.RUnlock()
-			if err := vm.push(val); err != nil {
-				return err
-			}
-
-		case code.OpSetGlobal:
-			globalIndex := binary.BigEndian.Uint16(ins[ip+1:])
-			frame.ip += 2
-			val := vm.pop()
-			vm.globalsMu.Lock()
-			vm.globals[globalIndex] = val
-			vm.globalsMu.Unlock()
-
-		case code.OpGetLocal:
-			localIndex := int(ins[ip+1])
-			frame.ip += 1
-			if err := vm.push(vm.stack[frame.basePointer+localIndex]); err != nil {
-				return err
-			}
-
-		case code.OpSetLocal:
-			localIndex := int(ins[ip+1])
-			frame.ip += 1
-			vm.stack[frame.basePointer+localIndex] = vm.pop()
-
-		case code.OpGetBuiltin:
-			builtinIndex := int(ins[ip+1])
-			frame.ip += 1
-			builtin := builtins.GetBuiltinByIndex(builtinIndex)
-			if builtin == nil {
-				return fmt.Errorf("builtin function not found at index %d", builtinIndex)
-			}
-			if err := vm.push(builtin); err != nil {
-				return err
-			}
-
-		case code.OpArray:
-			numElements := int(binary.BigEndian.Uint16(ins[ip+1:]))
-			frame.ip += 2
-			array := vm.buildArray(vm.sp-numElements, vm.sp)
-			vm.sp = vm.sp - numElements
-			if err := vm.push(array); err != nil {
-				return err
-			}
-
-		case code.OpHash:
-			numElements := int(binary.BigEndian.Uint16(ins[ip+1:]))
-			frame.ip += 2
-			hash, err := vm.buildHash(vm.sp-numElements, vm.sp)
-			if err != nil {
-				return err
-			}
-			vm.sp = vm.sp - numElements
-			if err := vm.push(hash); err != nil {
-				return err
-			}
-
-		case code.OpIndex:
-			index := vm.pop()
-			left := vm.pop()
-			if err := vm.executeIndexExpression(left, index); err != nil {
-				return err
-			}
-
-		case code.OpMember:
-			constIndex := binary.BigEndian.Uint16(ins[ip+1:])
-			frame.ip += 2
-			memberName := vm.getConstants()[constIndex].(*object.String).Value
-			obj := vm.pop()
-			if err := vm.executeMemberExpression(obj, memberName); err != nil {
-				return err
-			}
-
-		case code.OpJump:
-			pos := int(binary.BigEndian.Uint16(ins[ip+1:]))
-			vm.currentFrame().ip = pos - 1
-
-		case code.OpJumpNotTruthy:
-			pos := int(binary.BigEndian.Uint16(ins[ip+1:]))
-			frame.ip += 2
-			condition := vm.pop()
-			if !isTruthy(condition) {
-				vm.currentFrame().ip = pos - 1
-			}
-
-		case code.OpJumpTruthy:
-			pos := int(binary.BigEndian.Uint16(ins[ip+1:]))
-			frame.ip += 2
-			condition := vm.StackTop()
-			if isTruthy(condition) {
-				vm.currentFrame().ip = pos - 1
-			}
-
-		case code.OpDup:
-			if vm.sp == 0 {
-				return fmt.Errorf("stack empty for OpDup")
-			}
-			vm.push(vm.stack[vm.sp-1])
-
-		case code.OpCatch:
-			pos := int(binary.BigEndian.Uint16(ins[ip+1:]))
-			frame.ip += 2
-			vm.catchHandlers = append(vm.catchHandlers, pos)
-
-		case code.OpThrow:
-			if vm.sp == 0 {
-				return fmt.Errorf("throw with empty stack")
-			}
-			if len(vm.catchHandlers) == 0 {
-				errObj := vm.pop()
-				return fmt.Errorf("uncaught throw: %s", errObj.Inspect())
-			}
-			thrown := vm.pop()
-			handlerPos := vm.catchHandlers[len(vm.catchHandlers)-1]
-			vm.catchHandlers = vm.catchHandlers[:len(vm.catchHandlers)-1]
-			vm.push(thrown)
-			vm.currentFrame().ip = handlerPos - 1
-
-		case code.OpEndCatch:
-			if len(vm.catchHandlers) == 0 {
-				return fmt.Errorf("OpEndCatch without OpCatch")
-			}
-			vm.catchHandlers = vm.catchHandlers[:len(vm.catchHandlers)-1]
-
-		case code.OpCall:
-			numArgs := int(ins[ip+1])
-			frame.ip += 1
-			callee := vm.stack[vm.sp-1-numArgs]
-
-			switch cl := callee.(type) {
-			case *object.Closure:
-				if numArgs != cl.Fn.NumParameters {
-					return fmt.Errorf("wrong number of arguments: want=%d, got=%d",
-						cl.Fn.NumParameters, numArgs)
-				}
-				frame := NewFrame(cl, vm.sp-numArgs)
-				vm.pushFrame(frame)
-				vm.sp = frame.basePointer + cl.Fn.NumLocals
-
-			case *object.Builtin:
-				args := vm.stack[vm.sp-numArgs : vm.sp]
-				result := cl.Fn(args...)
-				vm.sp = vm.sp - numArgs - 1
-				if result != nil {
-					vm.push(result)
-				} else {
-					vm.push(&object.Null{})
-				}
-
-			default:
-				return fmt.Errorf("calling non-function: %s", callee.Type())
-			}
-
-		case code.OpSpawn:
-			numArgs := int(ins[ip+1])
-			frame.ip += 1
-
-			args := make([]object.Object, numArgs)
-			for i := numArgs - 1; i >= 0; i-- {
-				args[i] = vm.pop()
-			}
-
-			target := vm.pop()
-			var cl *object.Closure
-
-			switch t := target.(type) {
-			case *object.Closure:
-				cl = t
-			case *object.CompiledFunction:
-				cl = &object.Closure{Fn: t}
-			default:
-				return fmt.Errorf("spawn target must be a function, got %s", target.Type())
-			}
-
-			go func() {
-				defer func() {
-					if r := recover(); r != nil {
-						fmt.Printf("Recovered in spawn goroutine: %v\n", r)
-					}
-				}()
-				subVm := &VM{
-					constants:  vm.constants,
-					globals:    vm.globals,
-					globalsMu:  vm.globalsMu,
-					stack:      make([]object.Object, StackSize),
-					sp:         0,
-					frames:     make([]*Frame, MaxFrames),
-					frameIndex: 1,
-				}
-
-				newFrame := NewFrame(cl, 0)
-				subVm.frames[0] = newFrame
-
-				for i, arg := range args {
-					subVm.stack[i] = arg
-				}
-				subVm.sp = cl.Fn.NumLocals
-
-				err := subVm.Run()
-				if err != nil {
-					fmt.Printf("Sub-VM error: %s\n", err)
-				}
-			}()
-
-		case code.OpClosure:
-			constIndex := binary.BigEndian.Uint16(ins[ip+1:])
-			numFree := int(ins[ip+3])
-			frame.ip += 3
-
-			err := vm.pushClosure(int(constIndex), numFree)
-			if err != nil {
-				return err
-			}
+		return &uncaughtThrow{thrown}
+	}
+	e, ok := thrown.(*object.Error)
+	if !ok {
+		message := thrown.Inspect()
+		if s, isString := thrown.(*object.String); isString {
+			message = s.Value
+		}
+		key := &object.String{Value: "value"}
+		e = &object.Error{
+			Message: message,
+			Code:    &object.String{Value: thrownValueCode},
+			Data:    &object.Hash{Pairs: map[object.HashKey]object.HashPair{key.HashKey(): {Key: key, Value: thrown}}},
+		}
+		thrown = e
+	}
+	if e.Stack == nil {
+		e.Stack = vm.callStack()
+	}
+	handler := vm.catchHandlers[len(vm.catchHandlers)-1]
+	vm.catchHandlers = vm.catchHandlers[:len(vm.catchHandlers)-1]
+	vm.abandonGroups(len(vm.catchHandlers))
+	vm.frameIndex = handler.frameIndex
+	vm.sp = handler.sp
+	vm.push(thrown)
+	vm.currentFrame().ip = handler.pos - 1
+	return nil
+}
 
-		case code.OpGetFree:
-			freeIndex := int(ins[ip+1])
-			frame.ip += 1
+// raise reports a runtime error that try can catch, such as one raised by
+// a builtin or a write to a frozen collection: the handler receives an
+// error with its message and runtimeErrorCode, and with no handler it
+// stops the VM.
+func (vm *VM) raise(err error) error {
+	if len(vm.catchHandlers) == 0 {
+		return err
+	}
+	return vm.throw(runtimeError(err))
+}
 
-			err := vm.push(frame.cl.Free[freeIndex])
-			if err != nil {
-				return err
-			}
+// runtimeError is the error value a catch handler receives for err.
+func runtimeError(err error) *object.Error {
+	return &object.Error{Message: err.Error(), Code: &object.String{Value: runtimeErrorCode}}
+}
 
-		case code.OpSetFree:
-			freeIndex := int(ins[ip+1])
-			frame.ip += 1
+// callStack names the functions of the active frames, innermost first.
+func (vm *VM) callStack() []string {
+	names := make([]string, 0, vm.frameIndex)
+	for i := vm.frameIndex - 1; i >= 0; i-- {
+		names = append(names, vm.frameName(i))
+	}
+	return names
+}
 
-			val := vm.pop()
-			frame.cl.Free[freeIndex] = val
+func (vm *VM) Run() error {
+	// Leaving with an error never reaches OpGroupEnd; stop those tasks.
+	defer vm.abandonGroups(-1)
 
-		case code.OpReturnValue:
-			returnValue := vm.pop()
-			frame := vm.popFrame()
-			if vm.frameIndex == 0 {
-				vm.sp = 0
-				vm.push(returnValue)
-				return nil
-			}
-			vm.sp = frame.basePointer - 1
-			if err := vm.push(returnValue); err != nil {
-				return err
-			}
+	for vm.frameIndex > 0 {
+		frame := vm.currentFrame()
+		if frame.ip >= len(frame.code)-1 {
+			break
+		}
+		frame.ip++
+		vm.instructions++
+		if vm.cancel != nil && vm.instructions&0xff == 0 && vm.cancel.canceled() {
+			return errCanceled
+		}
 
-		case code.OpReturn:
-			frame := vm.popFrame()
-			if vm.frameIndex == 0 {
-				vm.sp = 0
-				vm.push(&object.Null{})
+		in := frame.code[frame.ip]
+		if vm.trace != nil {
+			vm.trace.record(frame.cl.Fn, frame.ip, in)
+		}
+		handler := ops[in.Op]
+		if handler == nil {
+			return fmt.Errorf("unknown opcode %d", in.Op)
+		}
+		if err := handler(vm, frame, in); err != nil {
+			if err == errHalt {
 				return nil
 			}
-			vm.sp = frame.basePointer - 1
-			if err := vm.push(&object.Null{}); err != nil {
-				return err
-			}
-
-		case code.OpPop:
-			vm.pop()
-
-		case code.OpImport:
-			pathObj := vm.pop()
-			path, ok := pathObj.(*object.String)
-			if !ok {
-				return fmt.Errorf("import path must be string, got %s", pathObj.Type())
-			}
-
-			modulePath := path.Value
-			if !strings.HasSuffix(modulePath, ".xn") {
-				modulePath += ".xn"
-			}
-
-			if mod, ok := vm.modules[modulePath]; ok {
-				if err := vm.push(mod); err != nil {
-					return err
-				}
-				continue
-			}
-
-			// Load and compile
-			content, err := ioutil.ReadFile(modulePath)
-			if err != nil {
-				return fmt.Errorf("could not read import file %s: %s", modulePath, err)
-			}
-
-			// Prepend standard library so modules have access to it
-			stdSource, err := builtins.LoadStdLib()
-			if err != nil {
-				fmt.Printf("Warning: could not load stdlib for import: %v\n", err)
-			}
-			fullSource := stdSource + "\n" + string(content)
-
-			l := lexer.New(fullSource)
-			p := parser.New(l)
-			program := p.ParseProgram()
-			if len(p.Errors) != 0 {
-				return fmt.Errorf("import parse error: %v", p.Errors)
-			}
-
-			c := compiler.New()
-			err = c.Compile(program)
-			if err != nil {
-				return fmt.Errorf("import compile error: %s", err)
-			}
-
-			bytecode := c.Bytecode()
-			// Run in sub-VM
-			subVm := New(bytecode)
-			subVm.modules = vm.modules
-
-			err = subVm.Run()
-			if err != nil {
-				return fmt.Errorf("import runtime error: %s", err)
-			}
-
-			// Export all globals as a Hash
-			exportHash := &object.Hash{Pairs: make(map[object.HashKey]object.HashPair)}
-			moduleConstants := bytecode.Constants
-			for _, sym := range bytecode.SymbolTable.Symbols() {
-				if sym.Scope == compiler.GlobalScope {
-					val := subVm.globals[sym.Index]
-					if val != nil {
-						// Attach module constants to closures so they work in the main VM
-						vm.attachConstants(val, moduleConstants)
-						key := &object.String{Value: sym.Name}
-						exportHash.Pairs[key.HashKey()] = object.HashPair{Key: key, Value: val}
-					}
-				}
-			}
-
-			vm.modules[modulePath] = exportHash
-			if err := vm.push(exportHash); err != nil {
-				return err
-			}
+			return err
 		}
 	}
 
@@ -548,6 +271,11 @@ func (vm *VM) executeBinaryOperation(op code.Opcode) error {
 	right := vm.pop()
 	left := vm.pop()
 
+	// Elementwise NUMARRAY operations (with scalar broadcasting)
+	if left.Type() == object.NUMARRAY_OBJ || right.Type() == object.NUMARRAY_OBJ {
+		return vm.executeNumArrayBinaryOp(op, left, right)
+	}
+
 	// Integer operations
 	leftInt, ok1 := left.(*object.Integer)
 	rightInt, ok2 := right.(*object.Integer)
@@ -587,8 +315,12 @@ func (vm *VM) executeBinaryOperation(op code.Opcode) error {
 				return fmt.Errorf("modulo by zero")
 			}
 			return vm.push(&object.Float{Value: math.Mod(leftF, rightF)})
+		case code.OpPow:
+			return vm.push(&object.Float{Value: math.Pow(leftF, rightF)})
 		case code.OpGreaterThan:
 			return vm.push(nativeBoolToObj(leftF > rightF))
+		case code.OpGreaterEqual:
+			return vm.push(nativeBoolToObj(leftF >= rightF))
 		case code.OpEqual:
 			return vm.push(nativeBoolToObj(leftF == rightF))
 		case code.OpNotEqual:
@@ -623,20 +355,58 @@ func (vm *VM) executeBinaryOperation(op code.Opcode) error {
 		}
 	}
 
-	// String equality (for == and !=)
+	// String equality and ordering, by bytes; < and <= arrive here swapped
+	// as > and >=.
 	if ok3 && ok4 {
 		switch op {
 		case code.OpEqual:
 			return vm.push(nativeBoolToObj(leftStr.Value == rightStr.Value))
 		case code.OpNotEqual:
 			return vm.push(nativeBoolToObj(leftStr.Value != rightStr.Value))
+		case code.OpGreaterThan:
+			return vm.push(nativeBoolToObj(leftStr.Value > rightStr.Value))
+		case code.OpGreaterEqual:
+			return vm.push(nativeBoolToObj(leftStr.Value >= rightStr.Value))
 		}
 	}
 
-	// Null comparison: null == null is true, null == anything else is false
 	if left == nil || right == nil {
 		return fmt.Errorf("binary op with nil: left=%v right=%v", left, right)
 	}
+
+	// Null comparison: null == null is true, null == anything else is false
+	if left.Type() == object.NULL_OBJ || right.Type() == object.NULL_OBJ {
+		switch op {
+		case code.OpEqual:
+			return vm.push(nativeBoolToObj(left.Type() == right.Type()))
+		case code.OpNotEqual:
+			return vm.push(nativeBoolToObj(left.Type() != right.Type()))
+		}
+	}
+
+	// Classes are equal only to themselves.
+	leftClass, ok7 := left.(*object.Class)
+	rightClass, ok8 := right.(*object.Class)
+	if ok7 && ok8 {
+		switch op {
+		case code.OpEqual:
+			return vm.push(nativeBoolToObj(leftClass == rightClass))
+		case code.OpNotEqual:
+			return vm.push(nativeBoolToObj(leftClass != rightClass))
+		}
+	}
+
+	// Values of different types are never equal; other operators need an
+	// explicit conversion.
+	if left.Type() != right.Type() {
+		switch op {
+		case code.OpEqual:
+			return vm.push(nativeBoolToObj(false))
+		case code.OpNotEqual:
+			return vm.push(nativeBoolToObj(true))
+		}
+		return fmt.Errorf("unsupported types for binary operation: %s %s (convert with to_int, to_float or to_str)", left.Type(), right.Type())
+	}
 	return fmt.Errorf("unsupported types for binary operation: %s %s", left.Type(), right.Type())
 }
 
@@ -649,14 +419,25 @@ func (vm *VM) executeIntegerBinaryOp(op code.Opcode, left, right int64) error {
 	case code.OpMul:
 		return vm.push(&object.Integer{Value: left * right})
 	case code.OpDiv:
+		if right == 0 {
+			return fmt.Errorf("division by zero")
+		}
 		return vm.push(&object.Integer{Value: left / right})
 	case code.OpMod:
 		if right == 0 {
 			return fmt.Errorf("modulo by zero")
 		}
 		return vm.push(&object.Integer{Value: left % right})
+	case code.OpPow:
+		// A negative power of an integer is a fraction.
+		if right < 0 {
+			return vm.push(&object.Float{Value: math.Pow(float64(left), float64(right))})
+		}
+		return vm.push(&object.Integer{Value: intPow(left, right)})
 	case code.OpGreaterThan:
 		return vm.push(nativeBoolToObj(left > right))
+	case code.OpGreaterEqual:
+		return vm.push(nativeBoolToObj(left >= right))
 	case code.OpEqual:
 		return vm.push(nativeBoolToObj(left == right))
 	case code.OpNotEqual:
@@ -676,6 +457,143 @@ func (vm *VM) executeIntegerBinaryOp(op code.Opcode, left, right int64) error {
 	}
 }
 
+func (vm *VM) executeNumArrayBinaryOp(op code.Opcode, left, right object.Object) error {
+	leftNum, leftIsArr := left.(*object.NumArray)
+	rightNum, rightIsArr := right.(*object.NumArray)
+
+	// A numarray equals only a numarray; other operands are never equal to it.
+	if op == code.OpEqual || op == code.OpNotEqual {
+		if _, ok := numericValue(left); !ok && !leftIsArr {
+			return vm.push(nativeBoolToObj(op == code.OpNotEqual))
+		}
+		if _, ok := numericValue(right); !ok && !rightIsArr {
+			return vm.push(nativeBoolToObj(op == code.OpNotEqual))
+		}
+	}
+
+	var leftScalar, rightScalar float64
+	if !leftIsArr {
+		f, ok := numericValue(left)
+		if !ok {
+			return fmt.Errorf("unsupported types for binary operation: %s %s", left.Type(), right.Type())
+		}
+		leftScalar = f
+	}
+	if !rightIsArr {
+		f, ok := numericValue(right)
+		if !ok {
+			return fmt.Errorf("unsupported types for binary operation: %s %s", left.Type(), right.Type())
+		}
+		rightScalar = f
+	}
+
+	var shape []int
+	var size int
+	if leftIsArr {
+		shape, size = leftNum.Shape, len(leftNum.Data)
+	} else {
+		shape, size = rightNum.Shape, len(rightNum.Data)
+	}
+	if leftIsArr && rightIsArr && !sameShape(leftNum.Shape, rightNum.Shape) {
+		if op == code.OpEqual || op == code.OpNotEqual {
+			return vm.push(nativeBoolToObj(op == code.OpNotEqual))
+		}
+		return fmt.Errorf("numarray shape mismatch: %v and %v", leftNum.Shape, rightNum.Shape)
+	}
+
+	if op == code.OpEqual || op == code.OpNotEqual {
+		equal := leftIsArr && rightIsArr
+		for i := 0; equal && i < size; i++ {
+			equal = leftNum.Data[i] == rightNum.Data[i]
+		}
+		return vm.push(nativeBoolToObj(equal == (op == code.OpEqual)))
+	}
+
+	data := make([]float64, size)
+	for i := range data {
+		l, r := leftScalar, rightScalar
+		if leftIsArr {
+			l = leftNum.Data[i]
+		}
+		if rightIsArr {
+			r = rightNum.Data[i]
+		}
+		switch op {
+		case code.OpAdd:
+			data[i] = l + r
+		case code.OpSub:
+			data[i] = l - r
+		case code.OpMul:
+			data[i] = l * r
+		case code.OpDiv:
+			data[i] = l / r
+		case code.OpPow:
+			data[i] = math.Pow(l, r)
+		default:
+			return fmt.Errorf("unsupported operator for NUMARRAY: %s", operatorSymbol(op))
+		}
+	}
+	return vm.push(&object.NumArray{Data: data, Shape: append([]int(nil), shape...)})
+}
+
+// operatorSymbols spells binary opcodes the way scripts write them, for
+// error messages. a < b compiles to b > a, so only > and >= appear.
+var operatorSymbols = map[code.Opcode]string{
+	code.OpAdd: "+", code.OpSub: "-", code.OpMul: "*", code.OpDiv: "/",
+	code.OpMod: "%", code.OpPow: "**", code.OpRange: "..",
+	code.OpGreaterThan: ">", code.OpGreaterEqual: ">=",
+	code.OpEqual: "==", code.OpNotEqual: "!=",
+	code.OpBitAnd: "&", code.OpBitOr: "|", code.OpBitXor: "^",
+	code.OpLshift: "<<", code.OpRshift: ">>",
+}
+
+// operatorSymbol returns the source form of op, or its opcode name.
+func operatorSymbol(op code.Opcode) string {
+	if sym, ok := operatorSymbols[op]; ok {
+		return sym
+	}
+	if def, err := code.Lookup(byte(op)); err == nil {
+		return def.Name
+	}
+	return fmt.Sprintf("opcode %d", op)
+}
+
+// intPow is base ** exp for exp >= 0 by repeated squaring, wrapping on
+// overflow like the other integer operators.
+func intPow(base, exp int64) int64 {
+	result := int64(1)
+	for exp > 0 {
+		if exp&1 == 1 {
+			result *= base
+		}
+		base *= base
+		exp >>= 1
+	}
+	return result
+}
+
+func numericValue(obj object.Object) (float64, bool) {
+	switch o := obj.(type) {
+	case *object.Integer:
+		return float64(o.Value), true
+	case *object.Float:
+		return o.Value, true
+	}
+	return 0, false
+}
+
+func sameShape(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
 func (vm *VM) buildArray(startIndex, endIndex int) object.Object {
 	elements := make([]object.Object, endIndex-startIndex)
 	for i := startIndex; i < endIndex; i++ {
@@ -705,11 +623,91 @@ func (vm *VM) executeIndexExpression(left, index object.Object) error {
 		return vm.executeArrayIndex(left, index)
 	case left.Type() == object.HASH_OBJ:
 		return vm.executeHashIndex(left, index)
+	case left.Type() == object.NUMARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
+		return vm.executeNumArrayIndex(left, index)
+	case left.Type() == object.RANGE_OBJ && index.Type() == object.INTEGER_OBJ:
+		r := left.(*object.Range)
+		i := index.(*object.Integer).Value
+		if i < 0 || i >= r.Len() {
+			return vm.push(&object.Null{})
+		}
+		return vm.push(&object.Integer{Value: r.At(i)})
 	default:
 		return fmt.Errorf("index operator not supported: %s", left.Type())
 	}
 }
 
+// executeSlice pushes a copy of left[low:high] for an array or string.
+// Null bounds mean the start and the end, and bounds past either end are
+// clamped, so s[:3] of a shorter string is the whole string. Strings are
+// sliced by byte, as len counts them.
+func (vm *VM) executeSlice(left, low, high object.Object) error {
+	var length int64
+	switch l := left.(type) {
+	case *object.Array:
+		length = int64(len(l.Elements))
+	case *object.String:
+		length = int64(len(l.Value))
+	default:
+		return fmt.Errorf("slice not supported: %s", left.Type())
+	}
+	bound := func(b object.Object, fallback int64) (int64, error) {
+		switch n := b.(type) {
+		case *object.Null:
+			return fallback, nil
+		case *object.Integer:
+			return max(0, min(length, n.Value)), nil
+		}
+		return 0, fmt.Errorf("slice bounds must be INTEGER, got %s", b.Type())
+	}
+	lo, err := bound(low, 0)
+	if err != nil {
+		return err
+	}
+	hi, err := bound(high, length)
+	if err != nil {
+		return err
+	}
+	hi = max(lo, hi)
+	if s, ok := left.(*object.String); ok {
+		return vm.push(&object.String{Value: s.Value[lo:hi]})
+	}
+	elements := make([]object.Object, hi-lo)
+	copy(elements, left.(*object.Array).Elements[lo:hi])
+	return vm.push(&object.Array{Elements: elements})
+}
+
+// executeSetIndex stores value into left[index] in place. Arrays take an
+// index inside their bounds; hashes take any hashable key.
+func (vm *VM) executeSetIndex(left, index, value object.Object) error {
+	switch l := left.(type) {
+	case *object.Array:
+		i, ok := index.(*object.Integer)
+		if !ok {
+			return fmt.Errorf("array index must be INTEGER, got %s", index.Type())
+		}
+		if l.Frozen {
+			return vm.raise(errors.New("cannot assign to a frozen array"))
+		}
+		if i.Value < 0 || i.Value >= int64(len(l.Elements)) {
+			return fmt.Errorf("index %d out of range for array of length %d", i.Value, len(l.Elements))
+		}
+		l.Elements[i.Value] = value
+	case *object.Hash:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return fmt.Errorf("unusable as hash key: %s", index.Type())
+		}
+		if l.Frozen {
+			return vm.raise(errors.New("cannot assign to a frozen hash"))
+		}
+		l.Pairs[key.HashKey()] = object.HashPair{Key: index, Value: value}
+	default:
+		return fmt.Errorf("index assignment not supported: %s", left.Type())
+	}
+	return nil
+}
+
 func (vm *VM) executeArrayIndex(array, index object.Object) error {
 	arr := array.(*object.Array)
 	i := index.(*object.Integer).Value
@@ -720,28 +718,241 @@ func (vm *VM) executeArrayIndex(array, index object.Object) error {
 	return vm.push(arr.Elements[i])
 }
 
+// executeNumArrayIndex yields a FLOAT from a vector, or a copy of a row from a matrix.
+func (vm *VM) executeNumArrayIndex(numArray, index object.Object) error {
+	na := numArray.(*object.NumArray)
+	i := index.(*object.Integer).Value
+	if i < 0 || i >= int64(na.Shape[0]) {
+		return vm.push(&object.Null{})
+	}
+	if len(na.Shape) == 2 {
+		cols := int64(na.Shape[1])
+		row := append([]float64(nil), na.Data[i*cols:(i+1)*cols]...)
+		return vm.push(&object.NumArray{Data: row, Shape: []int{na.Shape[1]}})
+	}
+	return vm.push(&object.Float{Value: na.Data[i]})
+}
+
 func (vm *VM) executeHashIndex(hash, index object.Object) error {
 	h := hash.(*object.Hash)
 	key, ok := index.(object.Hashable)
 	if !ok {
 		return fmt.Errorf("unusable as hash key: %s", index.Type())
 	}
-	pair, ok := h.Pairs[key.HashKey()]
+	pair, ok := h.Get(key.HashKey())
 	if !ok {
 		return vm.push(&object.Null{})
 	}
-	return vm.push(pair.Value)
+	return vm.push(bindMethod(h, pair.Value))
+}
+
+// bindMethod binds a method read from hash h to h, so that h.greet() and
+// h["greet"]() pass h as self; any other value is returned as it is.
+func bindMethod(h *object.Hash, value object.Object) object.Object {
+	if cl, ok := value.(*object.Closure); ok && cl.Fn.Method {
+		return &object.BoundMethod{Receiver: h, Method: cl}
+	}
+	return value
+}
+
+// executeCall calls the function sitting below numArgs arguments on the stack.
+// Closures get a new frame; builtins run immediately and leave their result.
+func (vm *VM) executeCall(numArgs int) error {
+	callee := vm.stack[vm.sp-1-numArgs]
+
+	switch cl := callee.(type) {
+	case *object.Closure:
+		if numArgs != cl.Fn.NumParameters {
+			if cl.Fn.Method && numArgs == cl.Fn.NumParameters-1 {
+				return fmt.Errorf("calling a function that uses self without a receiver; store it in a hash and call it as h.name()")
+			}
+			return fmt.Errorf("wrong number of arguments: want=%d, got=%d",
+				cl.Fn.NumParameters, numArgs)
+		}
+		frame := NewFrame(cl, vm.sp-numArgs)
+		if err := vm.ensureStack(frame.basePointer + cl.Fn.NumLocals); err != nil {
+			return err
+		}
+		if err := vm.pushFrame(frame); err != nil {
+			return err
+		}
+		vm.sp = frame.basePointer + cl.Fn.NumLocals
+		return nil
+
+	case *object.Builtin:
+		args := vm.stack[vm.sp-numArgs : vm.sp]
+		if intrinsic, ok := intrinsics[cl]; ok {
+			result, err := intrinsic(vm, args)
+			vm.sp = vm.sp - numArgs - 1
+			if vm.cancel != nil && vm.cancel.canceled() {
+				return errCanceled
+			}
+			if t, ok := err.(*uncaughtThrow); ok {
+				return vm.throw(t.value)
+			}
+			if err != nil {
+				return vm.raise(err)
+			}
+			return vm.push(result)
+		}
+		if i, ok := cancelable[cl]; ok && vm.cancel != nil {
+			var release func()
+			args, release = vm.withCancelContext(args, i)
+			defer release()
+		}
+		result, err := callBuiltin(cl, args)
+		vm.sp = vm.sp - numArgs - 1
+		// A canceled task stops here rather than acting on the result
+		// of a sleep or request its group cut short.
+		if vm.cancel != nil && vm.cancel.canceled() {
+			return errCanceled
+		}
+		if e, ok := result.(*object.Error); ok && e.Raise {
+			err = errors.New(e.Message)
+		}
+		if err != nil {
+			return vm.raise(err)
+		}
+		if result != nil {
+			return vm.push(result)
+		}
+		return vm.push(&object.Null{})
+
+	case *object.BoundMethod:
+		return vm.callWithSelf(cl.Method, cl.Receiver, numArgs)
+
+	case *object.Class:
+		return vm.callWithSelf(cl.Init, cl.NewInstance(), numArgs)
+
+	default:
+		return fmt.Errorf("calling non-function: %s", callee.Type())
+	}
+}
+
+// callWithSelf calls method, which takes self first, in place of the
+// callee below numArgs arguments: the callee's slot takes the method and
+// self goes in ahead of the arguments.
+func (vm *VM) callWithSelf(method *object.Closure, self object.Object, numArgs int) error {
+	if numArgs != method.Fn.NumParameters-1 {
+		return fmt.Errorf("wrong number of arguments to %s: want=%d, got=%d",
+			method.Fn.Name, method.Fn.NumParameters-1, numArgs)
+	}
+	if err := vm.ensureStack(vm.sp + 1); err != nil {
+		return err
+	}
+	base := vm.sp - numArgs
+	copy(vm.stack[base+1:vm.sp+1], vm.stack[base:vm.sp])
+	vm.stack[base] = self
+	vm.stack[base-1] = method
+	vm.sp++
+	return vm.executeCall(numArgs + 1)
+}
+
+// cancelable are builtins that take an optional context, mapped to its
+// argument index. In a task that can be canceled they also stop when the
+// task is canceled.
+var cancelable = map[*object.Builtin]int{
+	builtins.GetBuiltinByName("sleep"):    1,
+	builtins.GetBuiltinByName("http_get"): 1,
+}
+
+// withCancelContext returns args with the context at index i, if there is
+// one, replaced by a context that is also done when vm's task is canceled;
+// if args end before i, the task's own context is added. release frees the
+// combined context once the call returns.
+func (vm *VM) withCancelContext(args []object.Object, i int) ([]object.Object, func()) {
+	switch {
+	case len(args) == i:
+		return append(args[:i:i], &object.Context{Ctx: vm.cancel.ctx}), func() {}
+	case len(args) == i+1:
+		c, ok := args[i].(*object.Context)
+		if !ok {
+			return args, func() {}
+		}
+		ctx, stop := context.WithCancel(c.Ctx)
+		release := context.AfterFunc(vm.cancel.ctx, stop)
+		args = append(args[:i:i], &object.Context{Ctx: ctx, Cancel: stop})
+		return args, func() { release(); stop() }
+	}
+	return args, func() {}
+}
+
+// newTaskVM returns a VM that will run cl(args...) on its own stack,
+// sharing this VM's constants and globals.
+func (vm *VM) newTaskVM(cl *object.Closure, args []object.Object, cancel *cancelFlag, taskID int64) (*VM, error) {
+	task := &VM{
+		constants:  vm.constants,
+		globals:    vm.globals,
+		globalsMu:  vm.globalsMu,
+		stack:      make([]object.Object, StackSize),
+		frames:     make([]*Frame, initialFrames),
+		frameIndex: 1,
+		cancel:     cancel,
+		taskID:     taskID,
+	}
+	task.frames[0] = NewFrame(cl, 0)
+	if err := task.ensureStack(cl.Fn.NumLocals); err != nil {
+		return nil, err
+	}
+	copy(task.stack, args)
+	task.sp = cl.Fn.NumLocals
+	return task, nil
+}
+
+// callBuiltin runs b, turning a Go panic inside it into an error naming
+// the builtin, which a try block can catch.
+func callBuiltin(b *object.Builtin, args []object.Object) (result object.Object, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			name := builtins.BuiltinName(b)
+			if name == "" {
+				name = "builtin function"
+			}
+			err = fmt.Errorf("panic in %s: %v", name, r)
+		}
+	}()
+	return b.Fn(args...), nil
 }
 
 func (vm *VM) executeMemberExpression(obj object.Object, member string) error {
 	switch o := obj.(type) {
 	case *object.Hash:
+		// A field of an instance hides a method of the same name.
 		key := &object.String{Value: member}
-		pair, ok := o.Pairs[key.HashKey()]
-		if !ok {
-			return vm.push(&object.Null{})
+		pair, ok := o.Get(key.HashKey())
+		if ok {
+			return vm.push(bindMethod(o, pair.Value))
 		}
-		return vm.push(pair.Value)
+		if o.Class != nil {
+			if method, ok := o.Class.Methods[member]; ok {
+				return vm.push(&object.BoundMethod{Receiver: o, Method: method})
+			}
+		}
+		return vm.push(&object.Null{})
+
+	case *object.Class:
+		switch member {
+		case "name":
+			return vm.push(&object.String{Value: o.Name})
+		case "fields":
+			fields := make([]object.Object, len(o.Fields))
+			for i, name := range o.Fields {
+				fields[i] = &object.String{Value: name}
+			}
+			return vm.push(&object.Array{Elements: fields})
+		case "methods":
+			names := make([]string, 0, len(o.Methods))
+			for name := range o.Methods {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			methods := make([]object.Object, len(names))
+			for i, name := range names {
+				methods[i] = &object.String{Value: name}
+			}
+			return vm.push(&object.Array{Elements: methods})
+		}
+		return vm.push(&object.Null{})
 
 	case *object.Array:
 		switch member {
@@ -752,17 +963,76 @@ func (vm *VM) executeMemberExpression(obj object.Object, member string) error {
 			}}
 			return vm.push(fn)
 		case "push":
+			// Methods mutate the array in place; the push/pop builtins return copies.
 			fn := &object.Builtin{Fn: func(args ...object.Object) object.Object {
 				if len(args) != 1 {
 					return &object.Error{Message: "wrong number of arguments"}
 				}
-				newElements := make([]object.Object, len(o.Elements)+1)
-				copy(newElements, o.Elements)
-				newElements[len(o.Elements)] = args[0]
-				o.Elements = newElements
+				if o.Frozen {
+					return &object.Error{Message: "cannot push to a frozen array", Raise: true}
+				}
+				o.Elements = append(o.Elements, args[0])
 				return &object.Null{}
 			}}
 			return vm.push(fn)
+		case "pop":
+			fn := &object.Builtin{Fn: func(args ...object.Object) object.Object {
+				if len(args) != 0 {
+					return &object.Error{Message: "wrong number of arguments"}
+				}
+				if o.Frozen {
+					return &object.Error{Message: "cannot pop from a frozen array", Raise: true}
+				}
+				if len(o.Elements) == 0 {
+					return &object.Null{}
+				}
+				last := o.Elements[len(o.Elements)-1]
+				o.Elements[len(o.Elements)-1] = nil
+				o.Elements = o.Elements[:len(o.Elements)-1]
+				return last
+			}}
+			return vm.push(fn)
+		}
+		return vm.push(&object.Null{})
+
+	case *object.NumArray:
+		if member == "len" {
+			fn := &object.Builtin{Fn: func(args ...object.Object) object.Object {
+				return &object.Integer{Value: int64(o.Shape[0])}
+			}}
+			return vm.push(fn)
+		}
+		return vm.push(&object.Null{})
+
+	case *object.Range:
+		if member == "len" {
+			fn := &object.Builtin{Fn: func(args ...object.Object) object.Object {
+				return &object.Integer{Value: o.Len()}
+			}}
+			return vm.push(fn)
+		}
+		return vm.push(&object.Null{})
+
+	case *object.Error:
+		switch member {
+		case "message":
+			return vm.push(&object.String{Value: o.Message})
+		case "code":
+			if o.Code == nil {
+				return vm.push(&object.Null{})
+			}
+			return vm.push(o.Code)
+		case "data":
+			if o.Data == nil {
+				return vm.push(&object.Hash{Pairs: make(map[object.HashKey]object.HashPair)})
+			}
+			return vm.push(o.Data)
+		case "stack":
+			frames := make([]object.Object, len(o.Stack))
+			for i, name := range o.Stack {
+				frames[i] = &object.String{Value: name}
+			}
+			return vm.push(&object.Array{Elements: frames})
 		}
 		return vm.push(&object.Null{})
 
@@ -779,14 +1049,201 @@ func nativeBoolToObj(input bool) *object.Boolean {
 }
 
 func (vm *VM) push(obj object.Object) error {
-	if vm.sp >= StackSize {
-		return fmt.Errorf("stack overflow")
+	if vm.sp >= len(vm.stack) {
+		if err := vm.ensureStack(vm.sp + 1); err != nil {
+			return err
+		}
 	}
 	vm.stack[vm.sp] = obj
 	vm.sp++
 	return nil
 }
 
+// ensureStack grows the stack so it holds at least n slots, doubling its
+// size up to MaxStackSize.
+func (vm *VM) ensureStack(n int) error {
+	if n <= len(vm.stack) {
+		return nil
+	}
+	if n > MaxStackSize {
+		return fmt.Errorf("stack overflow: exceeded %d stack slots (raise with --max-stack)", MaxStackSize)
+	}
+	size := len(vm.stack)
+	if size == 0 {
+		size = StackSize
+	}
+	for size < n {
+		size *= 2
+	}
+	if size > MaxStackSize {
+		size = MaxStackSize
+	}
+	grown := make([]object.Object, size)
+	copy(grown, vm.stack)
+	vm.stack = grown
+	return nil
+}
+
+// intrinsics are builtins that report on the VM calling them or act for
+// its task. executeCall runs these instead of the builtin's own Fn, which
+// cannot see the VM. An error they return is raised in the caller; an
+// uncaught throw is thrown there again as the same value.
+var intrinsics = map[*object.Builtin]func(vm *VM, args []object.Object) (object.Object, error){
+	builtins.GetBuiltinByName("locals"):   (*VM).localsHash,
+	builtins.GetBuiltinByName("vm_stats"): (*VM).stats,
+	builtins.GetBuiltinByName("fs_lock"):  (*VM).fsLock,
+}
+
+func init() {
+	// withTimeout runs a VM, and running a VM reads intrinsics, so this
+	// entry cannot be part of the initializer.
+	intrinsics[builtins.GetBuiltinByName("with_timeout")] = (*VM).withTimeout
+}
+
+// localsHash implements locals(): the variables of the calling function.
+// At the top level, where variables are globals, it is empty.
+func (vm *VM) localsHash(args []object.Object) (object.Object, error) {
+	if len(args) != 0 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=0", len(args))}, nil
+	}
+	h := &object.Hash{Pairs: make(map[object.HashKey]object.HashPair)}
+	frames := vm.Frames()
+	for _, v := range frames[len(frames)-1].Locals {
+		key := &object.String{Value: v.Name}
+		h.Pairs[key.HashKey()] = object.HashPair{Key: key, Value: v.Value}
+	}
+	return h, nil
+}
+
+// stats implements vm_stats() for this VM: instructions executed, current
+// call depth and stack use. "allocations" counts Go heap allocations of the
+// whole process, spawned tasks included.
+func (vm *VM) stats(args []object.Object) (object.Object, error) {
+	if len(args) != 0 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=0", len(args))}, nil
+	}
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	h := &object.Hash{Pairs: make(map[object.HashKey]object.HashPair)}
+	for _, kv := range []struct {
+		key string
+		val int64
+	}{
+		{"instructions", int64(vm.instructions)},
+		{"frame_depth", int64(vm.frameIndex)},
+		{"max_frames", int64(MaxFrames)},
+		{"stack_used", int64(vm.sp)},
+		{"stack_size", int64(len(vm.stack))},
+		{"allocations", int64(m.Mallocs)},
+	} {
+		key := &object.String{Value: kv.key}
+		h.Pairs[key.HashKey()] = object.HashPair{Key: key, Value: &object.Integer{Value: kv.val}}
+	}
+	return h, nil
+}
+
+// fsLock implements fs_lock for this VM's task, whose locks it tells
+// apart from other tasks', and stops waiting when the task is canceled.
+func (vm *VM) fsLock(args []object.Object) (object.Object, error) {
+	ctx := context.Background()
+	if vm.cancel != nil {
+		ctx = vm.cancel.ctx
+	}
+	return builtins.FsLock(vm.taskID, ctx, args...), nil
+}
+
+// withTimeout implements with_timeout(ms, f): f's result, or an error if
+// it runs longer than ms milliseconds. f runs in a task VM that stops at
+// the deadline, cutting short its sleeps and requests; a one-parameter f
+// also gets the deadline's context to pass on. A value f throws is thrown
+// again in the caller, and its runtime errors are raised there.
+func (vm *VM) withTimeout(args []object.Object) (object.Object, error) {
+	if len(args) != 2 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=2", len(args))}, nil
+	}
+	ms, ok := args[0].(*object.Integer)
+	if !ok {
+		return &object.Error{Message: fmt.Sprintf("first argument to `with_timeout` must be INTEGER (ms), got %s", args[0].Type())}, nil
+	}
+	cl, ok := args[1].(*object.Closure)
+	if !ok || cl.Fn.NumParameters > 1 {
+		return &object.Error{Message: "second argument to `with_timeout` must be a function taking no arguments or a context"}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(ms.Value)*time.Millisecond)
+	defer cancel()
+	var callArgs []object.Object
+	if cl.Fn.NumParameters == 1 {
+		callArgs = []object.Object{&object.Context{Ctx: ctx, Cancel: cancel}}
+	}
+	flag := newCancelFlag(vm.cancel, ctx)
+	defer flag.stop()
+	task, err := vm.newTaskVM(cl, callArgs, flag, vm.taskID)
+	if err == nil {
+		err = task.Run()
+	}
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		return &object.Error{Message: fmt.Sprintf("with_timeout: timed out after %d ms", ms.Value)}, nil
+	case err != nil:
+		return nil, err
+	}
+	return task.StackTop(), nil
+}
+
+// frameName labels frame i for call chains: its binding name, <main> for
+// the top level or <anonymous fn>. A spawned task starts in the function
+// it was spawned with rather than the top level.
+func (vm *VM) frameName(i int) string {
+	name := vm.frames[i].cl.Fn.Name
+	switch {
+	case i == 0 && name == "" && vm.taskID == 0:
+		return "<main>"
+	case name == "":
+		return "<anonymous fn>"
+	}
+	return name
+}
+
+// Variable is a named value in a frame, reported by Frames.
+type Variable struct {
+	Name  string
+	Value object.Object
+}
+
+// FrameInfo describes an active call: the function name and its parameters,
+// locals and captured variables in slot order. A local not assigned yet may
+// show a value left behind by an earlier call.
+type FrameInfo struct {
+	Name   string
+	Locals []Variable
+}
+
+// Frames reports the active calls, outermost (<main>) first. After Run
+// returns an error the frames are those of the failing call chain, which
+// the post-mortem debugger loads.
+func (vm *VM) Frames() []FrameInfo {
+	infos := make([]FrameInfo, 0, vm.frameIndex)
+	for i := 0; i < vm.frameIndex; i++ {
+		f := vm.frames[i]
+		fn := f.cl.Fn
+		info := FrameInfo{Name: vm.frameName(i)}
+		for slot, name := range fn.LocalNames {
+			idx := f.basePointer + slot
+			if idx < len(vm.stack) && vm.stack[idx] != nil {
+				info.Locals = append(info.Locals, Variable{Name: name, Value: vm.local(idx)})
+			}
+		}
+		for slot, name := range fn.FreeNames {
+			if slot < len(f.cl.Free) {
+				info.Locals = append(info.Locals, Variable{Name: name, Value: f.cl.Free[slot]})
+			}
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
 func (vm *VM) pushClosure(constIndex int, numFree int) error {
 	constant := vm.getConstants()[constIndex]
 	compiledFn, ok := constant.(*object.CompiledFunction)
@@ -804,29 +1261,6 @@ func (vm *VM) pushClosure(constIndex int, numFree int) error {
 	return vm.push(closure)
 }
 
-// attachConstants recursively attaches module constants to closures
-// so they can reference the correct constant pool when called from another VM.
-func (vm *VM) attachConstants(val object.Object, constants []object.Object) {
-	switch v := val.(type) {
-	case *object.Closure:
-		if v.Fn.Constants == nil {
-			v.Fn.Constants = constants
-		}
-		// Also attach to free variables that might be closures
-		for _, free := range v.Free {
-			vm.attachConstants(free, constants)
-		}
-	case *object.Hash:
-		for _, pair := range v.Pairs {
-			vm.attachConstants(pair.Value, constants)
-		}
-	case *object.Array:
-		for _, elem := range v.Elements {
-			vm.attachConstants(elem, constants)
-		}
-	}
-}
-
 func (vm *VM) pop() object.Object {
 	obj := vm.stack[vm.sp-1]
 	vm.sp--
@@ -846,11 +1280,28 @@ func (vm *VM) SetFrameIndex(i int) {
 }
 
 func (vm *VM) SetStack(i int, obj object.Object) {
+	if i >= len(vm.stack) {
+		if err := vm.ensureStack(i + 1); err != nil {
+			return
+		}
+	}
 	vm.stack[i] = obj
 }
 
-func (vm *VM) SetStackPointer(sp int) {
+// SetStackPointer sets sp, growing the stack to hold it. It fails, leaving
+// sp unchanged, when that would pass MaxStackSize.
+func (vm *VM) SetStackPointer(sp int) error {
+	if err := vm.ensureStack(sp); err != nil {
+		return err
+	}
 	vm.sp = sp
+	return nil
+}
+
+// SetContext makes Run stop with an error, along with the tasks it spawns
+// and any sleep or request it is waiting in, once ctx is done.
+func (vm *VM) SetContext(ctx context.Context) {
+	vm.cancel = newCancelFlag(vm.cancel, ctx)
 }
 
 func isTruthy(obj object.Object) bool {